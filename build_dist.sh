@@ -39,6 +39,13 @@ while [ "$#" -gt 1 ]; do
 		ldflags="$ldflags -w -s"
 		tags="${tags:+$tags,}ts_omit_aws,ts_omit_bird,ts_omit_tap,ts_omit_kube,ts_omit_completion"
 		;;
+	--minimal)
+		# --extra-small, plus leaving out the optional subsystems
+		# that embedded routers often don't need.
+		shift
+		ldflags="$ldflags -w -s"
+		tags="${tags:+$tags,}ts_omit_aws,ts_omit_bird,ts_omit_tap,ts_omit_kube,ts_omit_completion,ts_omit_ssh,ts_omit_portmapper,ts_omit_netstack,ts_omit_taildrop,ts_omit_serve"
+		;;
 	--box)
 		shift
 		tags="${tags:+$tags,}ts_include_cli"
@@ -4,7 +4,13 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -30,6 +36,18 @@ type FileTarget struct {
 	PeerAPIURL string
 }
 
+// TailnetService is a service declared on a node in the tailnet, as
+// returned by the LocalAPI services endpoint.
+type TailnetService struct {
+	tailcfg.DeclaredService
+
+	NodeID   tailcfg.StableNodeID
+	NodeName string       // MagicDNS name of the node, without the trailing dot
+	Addrs    []netip.Addr // Tailscale IPs of the node
+	Online   bool         // whether the node is online, or is this node
+	Self     bool         // whether the node is this node
+}
+
 type WaitingFile struct {
 	Name string
 	Size int64
@@ -57,3 +75,47 @@ type ExitNodeSuggestionResponse struct {
 	Name     string
 	Location tailcfg.LocationView `json:",omitempty"`
 }
+
+// ReadyzResponse is the response to a LocalAPI readyz request.
+type ReadyzResponse struct {
+	// Ready is whether all the subsystems in Subsystems are ready.
+	Ready bool
+
+	// Subsystems are the subsystems asked about and those they depend
+	// on, in the order tailscaled starts them.
+	Subsystems []SubsystemReadiness
+}
+
+// SubsystemReadiness is the readiness of one of tailscaled's subsystems.
+type SubsystemReadiness struct {
+	Name      string   // "store", "engine", "magicsock", "control", "dns" or "router"
+	Ready     bool     // whether it and the subsystems it depends on are ready
+	Reason    string   `json:",omitempty"` // why it isn't ready, if known
+	DependsOn []string `json:",omitempty"` // subsystems that must be ready first
+}
+
+// ConnAuditResponse is the response to a LocalAPI conn-audit request.
+type ConnAuditResponse struct {
+	Enabled bool             // whether new connections are being recorded
+	Entries []ConnAuditEntry // oldest first
+}
+
+// ConnAuditEntry is an inbound connection that this node accepted from a
+// peer, as recorded by the connection audit log and returned by the
+// LocalAPI conn-audit endpoint.
+type ConnAuditEntry struct {
+	Start    time.Time // when the connection was opened
+	End      time.Time // when its last packet was seen
+	Proto    ipproto.Proto
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+	BytesIn  int64 // IP bytes received from the peer
+	BytesOut int64 // IP bytes sent to the peer
+
+	// The peer's identity when the connection ended. They're empty if
+	// the source address wasn't a known node, as for subnet routes.
+	NodeID   tailcfg.StableNodeID `json:",omitempty"`
+	NodeName string               `json:",omitempty"`
+	User     string               `json:",omitempty"` // login name of the node's owner
+	Tags     []string             `json:",omitempty"`
+}
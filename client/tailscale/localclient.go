@@ -39,6 +39,7 @@
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -465,6 +466,30 @@ func (lc *LocalClient) BugReport(ctx context.Context, note string) (string, erro
 	return lc.BugReportWithOpts(ctx, BugReportOpts{Note: note})
 }
 
+// BugReportBundle logs a bug report marker like BugReport and returns it
+// along with a stream of a diagnostics bundle, a gzipped tar archive, that
+// contains it. The caller must close the bundle.
+func (lc *LocalClient) BugReportBundle(ctx context.Context, note string) (marker string, bundle io.ReadCloser, err error) {
+	qparams := make(url.Values)
+	if note != "" {
+		qparams.Set("note", note)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/bugreport-bundle?"+qparams.Encode(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return "", nil, err
+	}
+	if res.StatusCode != 200 {
+		all, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return "", nil, fmt.Errorf("%s: %s", res.Status, errorMessageFromBody(all))
+	}
+	return res.Header.Get("Tailscale-Bugreport-Marker"), res.Body, nil
+}
+
 // DebugAction invokes a debug action, such as "rebind" or "restun".
 // These are development tools and subject to change or removal over time.
 func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
@@ -566,6 +591,16 @@ func (lc *LocalClient) SetDevStoreKeyValue(ctx context.Context, key, value strin
 	return nil
 }
 
+// ComponentDebugLogging returns the components that have debug logging
+// enabled, with the time until which it is.
+func (lc *LocalClient) ComponentDebugLogging(ctx context.Context) (map[string]time.Time, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/component-debug-logging")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[map[string]time.Time](body)
+}
+
 // SetComponentDebugLogging sets component's debug logging enabled for
 // the provided duration. If the duration is in the past, the debug logging
 // is disabled.
@@ -593,6 +628,39 @@ func Status(ctx context.Context) (*ipnstate.Status, error) {
 	return defaultLocalClient.Status(ctx)
 }
 
+// Readyz reports whether the named tailscaled subsystem and those it
+// depends on are ready, or whether all subsystems are if subsystem is
+// empty. If wait is true, it waits until they're ready or ctx is done.
+// Unlike other LocalAPI methods, it works while tailscaled is still
+// starting.
+func (lc *LocalClient) Readyz(ctx context.Context, subsystem string, wait bool) (*apitype.ReadyzResponse, error) {
+	v := url.Values{}
+	if subsystem != "" {
+		v.Set("subsystem", subsystem)
+	}
+	if wait {
+		v.Set("wait", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/readyz?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	slurp, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusServiceUnavailable {
+		err = fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(slurp))
+		return nil, httpStatusError{bestError(err, slurp), res.StatusCode}
+	}
+	return decodeJSON[*apitype.ReadyzResponse](slurp)
+}
+
 // Status returns the Tailscale daemon's status.
 func (lc *LocalClient) Status(ctx context.Context) (*ipnstate.Status, error) {
 	return lc.status(ctx, "")
@@ -838,6 +906,29 @@ func (lc *LocalClient) SetDNS(ctx context.Context, name, value string) error {
 	return err
 }
 
+// TailnetServices returns the services declared on this node and its
+// peers.
+func (lc *LocalClient) TailnetServices(ctx context.Context) ([]apitype.TailnetService, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/services")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.TailnetService](body)
+}
+
+// DeclareService declares svc on this node, replacing any service declared
+// with the same name.
+func (lc *LocalClient) DeclareService(ctx context.Context, svc tailcfg.DeclaredService) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/services", 200, jsonBody(svc))
+	return err
+}
+
+// RemoveDeclaredService removes the named service declared on this node.
+func (lc *LocalClient) RemoveDeclaredService(ctx context.Context, name string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/services?name="+url.QueryEscape(name), 200, nil)
+	return err
+}
+
 // DialTCP connects to the host's port via Tailscale.
 //
 // The host may be a base DNS name (resolved from the netmap inside
@@ -1433,6 +1524,45 @@ func (lc *LocalClient) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.Fi
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugStateHistory returns the backend's most recent state transitions,
+// oldest first.
+func (lc *LocalClient) DebugStateHistory(ctx context.Context) ([]ipn.StateTransition, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-state-history")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.StateTransition](body)
+}
+
+// DebugMagicsockState returns a JSON snapshot of the internal state of
+// tailscaled's magicsock: its DERP connections and the paths it knows to each
+// peer. If redact is true, the IP addresses of peers' endpoints are replaced
+// by placeholders, so that the snapshot can be shared. Its format is not
+// stable.
+func (lc *LocalClient) DebugMagicsockState(ctx context.Context, redact bool) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/debug-magicsock?redact="+strconv.FormatBool(redact))
+}
+
+// DaemonInfo returns how tailscaled was built and which netstack mode,
+// router and DNS configurator it's using.
+func (lc *LocalClient) DaemonInfo(ctx context.Context) (*ipn.DaemonInfo, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/daemon-info")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.DaemonInfo](body)
+}
+
+// DebugDroppedFlows returns the flows most recently dropped by the packet
+// filter, oldest first.
+func (lc *LocalClient) DebugDroppedFlows(ctx context.Context) ([]filtertype.DroppedFlow, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-dropped-flows")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]filtertype.DroppedFlow](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -1442,6 +1572,124 @@ func (lc *LocalClient) DebugSetExpireIn(ctx context.Context, d time.Duration) er
 	return err
 }
 
+// DebugSetPeerChaos makes tailscaled delay packets it sends to the peer
+// with Tailscale IP ip by latency plus a random amount up to jitter, and
+// drop the fraction dropRate of them. Passing zero for all three removes
+// the degradation.
+//
+// This is meant for testing how software behaves over a bad network path.
+func (lc *LocalClient) DebugSetPeerChaos(ctx context.Context, ip netip.Addr, latency, jitter time.Duration, dropRate float64) error {
+	v := url.Values{
+		"ip":      {ip.String()},
+		"latency": {latency.String()},
+		"jitter":  {jitter.String()},
+		"drop":    {strconv.FormatFloat(dropRate, 'f', -1, 64)},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-peer-chaos?"+v.Encode(), 200, nil)
+	if err != nil {
+		return fmt.Errorf("error %w: %s", err, body)
+	}
+	return nil
+}
+
+// LowPower returns the configuration and state of tailscaled's low power
+// mode.
+func (lc *LocalClient) LowPower(ctx context.Context) (*ipnstate.LowPower, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/low-power")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.LowPower](body)
+}
+
+// SetLowPower configures tailscaled's low power mode, in which it stops
+// its background network activity once the device is on battery and the
+// tailnet has been idle for idleAfter. A zero idleAfter turns it off.
+// Callers should call it again whenever onBattery changes.
+func (lc *LocalClient) SetLowPower(ctx context.Context, idleAfter time.Duration, onBattery bool) (*ipnstate.LowPower, error) {
+	v := url.Values{
+		"idle":    {idleAfter.String()},
+		"battery": {strconv.FormatBool(onBattery)},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/low-power?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.LowPower](body)
+}
+
+// ConnAudit returns the entries of tailscaled's connection audit log for
+// the inbound connections that ended at or after since (or all of them, if
+// since is zero), oldest first. If limit is positive, only the most recent
+// limit entries are returned.
+func (lc *LocalClient) ConnAudit(ctx context.Context, since time.Time, limit int) (*apitype.ConnAuditResponse, error) {
+	v := url.Values{}
+	if !since.IsZero() {
+		v.Set("since", since.UTC().Format(time.RFC3339))
+	}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/conn-audit?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.ConnAuditResponse](body)
+}
+
+// SetConnAuditEnabled sets whether tailscaled records the inbound
+// connections that peers open in its connection audit log. The setting
+// persists across restarts.
+func (lc *LocalClient) SetConnAuditEnabled(ctx context.Context, enabled bool) error {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/conn-audit?enable="+strconv.FormatBool(enabled), 200, nil)
+	if err != nil {
+		return fmt.Errorf("error %w: %s", err, body)
+	}
+	return nil
+}
+
+// PeerKeepalive returns how tailscaled keeps its connection to the peer
+// with Tailscale IP ip alive.
+func (lc *LocalClient) PeerKeepalive(ctx context.Context, ip netip.Addr) (*ipnstate.PeerKeepalive, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-keepalive?ip="+url.QueryEscape(ip.String()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.PeerKeepalive](body)
+}
+
+// SetPeerKeepalive sets how tailscaled keeps its connection to the peer
+// with Tailscale IP ip alive. The zero PeerKeepalive restores the
+// defaults. The setting lasts until tailscaled restarts.
+func (lc *LocalClient) SetPeerKeepalive(ctx context.Context, ip netip.Addr, ka ipnstate.PeerKeepalive) (*ipnstate.PeerKeepalive, error) {
+	v := url.Values{
+		"ip":        {ip.String()},
+		"heartbeat": {ka.HeartbeatInterval.String()},
+		"wireguard": {ka.WireGuardKeepalive.String()},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/peer-keepalive?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.PeerKeepalive](body)
+}
+
+// DebugSpeedtest measures the throughput between this node and the peer
+// with Tailscale IP ip, first from the peer and then to it, for duration d
+// in each direction. The peer must be owned by the same user or grant this
+// node the tailcfg.PeerCapabilitySpeedtest capability.
+func (lc *LocalClient) DebugSpeedtest(ctx context.Context, ip netip.Addr, d time.Duration) ([]ipnstate.SpeedtestResult, error) {
+	v := url.Values{
+		"ip":       {ip.String()},
+		"duration": {d.String()},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-speedtest?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[[]ipnstate.SpeedtestResult](body)
+}
+
 // StreamDebugCapture streams a pcap-formatted packet capture.
 //
 // The provided context does not determine the lifetime of the
@@ -1519,6 +1767,25 @@ func (lc *LocalClient) SetUseExitNode(ctx context.Context, on bool) error {
 	return err
 }
 
+// EditAdvertisedRoutes adds the routes in add to, and removes the routes in
+// remove from, the routes that the node advertises, without changing its
+// other prefs. Adding or removing both of 0.0.0.0/0 and ::/0 starts or stops
+// advertising the node as an exit node.
+func (lc *LocalClient) EditAdvertisedRoutes(ctx context.Context, add, remove []netip.Prefix) (*ipn.Prefs, error) {
+	v := url.Values{}
+	for _, p := range add {
+		v.Add("add", p.String())
+	}
+	for _, p := range remove {
+		v.Add("remove", p.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/edit-advertised-routes?"+v.Encode(), http.StatusOK, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.Prefs](body)
+}
+
 // DriveSetServerAddr instructs Taildrive to use the server at addr to access
 // the filesystem. This is used on platforms like Windows and MacOS to let
 // Taildrive know to use the file server running in the GUI app.
@@ -53,6 +53,7 @@
 	versionFlag = flag.Bool("version", false, "print version and exit")
 	addr        = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
 	httpPort    = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
+	httpDERP    = flag.Bool("http-port-derp", true, "whether to also serve DERP over unencrypted HTTP on --http-port, for clients on networks that block or intercept TLS. Clients only use it to reach a server whose key they learned over TLS.")
 	stunPort    = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	configPath  = flag.String("c", "", "config file path")
 	certMode    = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt")
@@ -68,6 +69,11 @@
 	verifyClients   = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
 	verifyFailOpen  = flag.Bool("verify-client-url-fail-open", true, "whether we fail open if --verify-client-url is unreachable")
+	verifyKeysFile  = flag.String("verify-client-keys-file", "", "if non-empty, path to a file of the only node keys (\"nodekey:...\", one per line; # starts a comment) to accept clients with, for private DERP servers")
+
+	usageInterval   = flag.Duration("usage-interval", 5*time.Minute, "how often to export the traffic relayed between each pair of clients, if --usage-csv or --usage-prometheus is set")
+	usageCSV        = flag.String("usage-csv", "", "if non-empty, path to a CSV file to append the traffic relayed between each pair of clients to, every --usage-interval")
+	usagePrometheus = flag.Bool("usage-prometheus", false, "whether to serve counters of the traffic relayed per client and per pair of clients at /debug/usage, in the Prometheus text format")
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
@@ -172,6 +178,18 @@ func main() {
 	s.SetVerifyClient(*verifyClients)
 	s.SetVerifyClientURL(*verifyClientURL)
 	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
+	if *verifyKeysFile != "" {
+		b, err := os.ReadFile(*verifyKeysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys, err := parseClientKeys(b)
+		if err != nil {
+			log.Fatalf("%s: %v", *verifyKeysFile, err)
+		}
+		s.SetVerifyClientKeys(keys)
+		log.Printf("DERP clients restricted to %d node keys", len(keys))
+	}
 
 	if *meshPSKFile != "" {
 		b, err := os.ReadFile(*meshPSKFile)
@@ -188,12 +206,34 @@ func main() {
 	if err := startMesh(s); err != nil {
 		log.Fatalf("startMesh: %v", err)
 	}
+	var usageSinks []derp.UsageSink
+	if *usageCSV != "" {
+		f, err := os.OpenFile(*usageCSV, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("opening --usage-csv file: %v", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		usageSinks = append(usageSinks, derp.NewCSVUsageSink(f, fi.Size() == 0))
+	}
+	var promUsage *derp.PrometheusUsageSink
+	if *usagePrometheus {
+		promUsage = derp.NewPrometheusUsageSink()
+		usageSinks = append(usageSinks, promUsage)
+	}
+	if len(usageSinks) > 0 {
+		s.SetUsageAccounting(*usageInterval, usageSinks...)
+		log.Printf("DERP usage accounting enabled, exporting every %v", *usageInterval)
+	}
 	expvar.Publish("derp", s.ExpVar())
+	expvar.Publish("derp_websocket_accepts", expvar.Func(func() any { return derphttp.WebSocketAccepts() }))
 
 	mux := http.NewServeMux()
+	var derpHandler http.Handler // nil if not running DERP
 	if *runDERP {
-		derpHandler := derphttp.Handler(s)
-		derpHandler = addWebSocketSupport(s, derpHandler)
+		derpHandler = derphttp.Handler(s)
 		mux.Handle("/derp", derpHandler)
 	} else {
 		mux.Handle("/derp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -250,6 +290,9 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	if promUsage != nil {
+		debug.Handle("usage", "Relayed traffic per client (Prometheus)", promUsage)
+	}
 	debug.Handle("set-mutex-profile-fraction", "SetMutexProfileFraction", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s := r.FormValue("rate")
 		if s == "" || r.Header.Get("Sec-Debug") != "derp" {
@@ -338,6 +381,9 @@ func main() {
 			go func() {
 				port80mux := http.NewServeMux()
 				port80mux.HandleFunc("/generate_204", derphttp.ServeNoContent)
+				if derpHandler != nil && *httpDERP {
+					port80mux.Handle("/derp", derpHandler)
+				}
 				port80mux.Handle("/", certManager.HTTPHandler(tsweb.Port80Handler{Main: mux}))
 				port80srv := &http.Server{
 					Addr:        net.JoinHostPort(listenHost, fmt.Sprintf("%d", *httpPort)),
@@ -387,6 +433,26 @@ func prodAutocertHostPolicy(_ context.Context, host string) error {
 	return errors.New("invalid hostname")
 }
 
+// parseClientKeys parses the contents of a --verify-client-keys-file: node
+// keys, one per line, with blank lines and # comments ignored. It returns a
+// non-nil slice, even if there are no keys.
+func parseClientKeys(b []byte) ([]key.NodePublic, error) {
+	keys := []key.NodePublic{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var k key.NodePublic
+		if err := k.UnmarshalText([]byte(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
 func defaultMeshPSKFile() string {
 	try := []string{
 		"/home/derp/keys/derp-mesh.key",
@@ -7,11 +7,13 @@
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/tstest/deptest"
+	"tailscale.com/types/key"
 )
 
 func TestProdAutocertHostPolicy(t *testing.T) {
@@ -110,3 +112,24 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestParseClientKeys(t *testing.T) {
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	in := "# private relay clients\n" + k1.String() + "\n\n  " + k2.String() + " # laptop\n"
+	got, err := parseClientKeys([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []key.NodePublic{k1, k2}; !slices.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	got, err = parseClientKeys([]byte("# none\n"))
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("empty file: got %v, %v; want empty non-nil", got, err)
+	}
+
+	if _, err := parseClientKeys([]byte(k1.String() + "\nbogus\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("bad key: got error %v; want one about line 2", err)
+	}
+}
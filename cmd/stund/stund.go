@@ -14,12 +14,16 @@
 	"syscall"
 
 	"tailscale.com/net/stunserver"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/tsweb"
 )
 
 var (
 	stunAddr = flag.String("stun", ":3478", "UDP address on which to start the STUN server")
 	httpAddr = flag.String("http", ":3479", "address on which to start the debug http server")
+
+	rateLimit = flag.Float64("rate-limit", 0, "if non-zero, the maximum number of STUN requests per second to answer from each source IP")
+	rateBurst = flag.Int("rate-burst", 10, "the number of STUN requests a source IP may burst above --rate-limit")
 )
 
 func main() {
@@ -32,6 +36,9 @@ func main() {
 	go http.ListenAndServe(*httpAddr, mux())
 
 	s := stunserver.New(ctx)
+	if *rateLimit > 0 {
+		s.SetRateLimit(rate.Limit(*rateLimit), *rateBurst)
+	}
 	if err := s.ListenAndServe(*stunAddr); err != nil {
 		log.Fatal(err)
 	}
@@ -44,5 +51,6 @@ func mux() *http.ServeMux {
 	})
 	debug := tsweb.Debugger(mux)
 	debug.KV("stun_addr", *stunAddr)
+	debug.KV("rate_limit", *rateLimit)
 	return mux
 }
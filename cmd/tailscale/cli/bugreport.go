@@ -8,6 +8,8 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -22,6 +24,7 @@
 		fs := newFlagSet("bugreport")
 		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "run additional in-depth checks")
 		fs.BoolVar(&bugReportArgs.record, "record", false, "if true, pause and then write another bugreport")
+		fs.StringVar(&bugReportArgs.bundle, "bundle", "", "if non-empty, also write a diagnostics bundle (a .tar.gz of recent logs, netcheck report, netmap summary, goroutines, router state and filter rules) to this file, or \"-\" for stdout")
 		return fs
 	})(),
 }
@@ -29,6 +32,7 @@
 var bugReportArgs struct {
 	diagnose bool
 	record   bool
+	bundle   string
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -40,6 +44,12 @@ func runBugReport(ctx context.Context, args []string) error {
 	default:
 		return errors.New("unknown arguments")
 	}
+	if bugReportArgs.bundle != "" {
+		if bugReportArgs.record || bugReportArgs.diagnose {
+			return errors.New("--bundle can't be used with --record or --diagnose")
+		}
+		return runBugReportBundle(ctx, note, bugReportArgs.bundle)
+	}
 	opts := tailscale.BugReportOpts{
 		Note:     note,
 		Diagnose: bugReportArgs.diagnose,
@@ -81,3 +91,36 @@ type bugReportResp struct {
 	outln("Please provide both bugreport markers above to the support team or GitHub issue.")
 	return nil
 }
+
+func runBugReportBundle(ctx context.Context, note, dst string) (retErr error) {
+	marker, bundle, err := localClient.BugReportBundle(ctx, note)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	var w io.Writer = Stdout
+	if dst != "-" {
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		w = f
+	}
+	if _, err := io.Copy(w, bundle); err != nil {
+		return fmt.Errorf("writing diagnostics bundle: %w", err)
+	}
+	if dst == "-" {
+		// Keep stdout for the bundle.
+		fmt.Fprintln(Stderr, marker)
+		return nil
+	}
+	outln(marker)
+	printf("Wrote diagnostics bundle to %s; please attach it along with the marker above.\n", outName(dst))
+	return nil
+}
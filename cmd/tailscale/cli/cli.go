@@ -159,6 +159,11 @@ func newRootCmd() *ffcli.Command {
 		return nil
 	})
 	rootfs.Lookup("socket").DefValue = localClient.Socket
+	rootfs.BoolFunc("user", "talk to the current user's tailscaled started with 'tailscaled --user'", func(string) error {
+		localClient.Socket = paths.UserTailscaledSocket()
+		localClient.UseSocketOnly = true
+		return nil
+	})
 
 	rootCmd := &ffcli.Command{
 		Name:       "tailscale",
@@ -174,6 +179,7 @@ func newRootCmd() *ffcli.Command {
 			upCmd,
 			downCmd,
 			setCmd,
+			routeCmd(),
 			loginCmd,
 			logoutCmd,
 			switchCmd,
@@ -196,6 +202,8 @@ func newRootCmd() *ffcli.Command {
 			exitNodeCmd(),
 			updateCmd,
 			whoisCmd,
+			metricsCmd,
+			servicesCmd,
 			debugCmd,
 			driveCmd,
 			idTokenCmd,
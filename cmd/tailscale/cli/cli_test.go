@@ -1032,6 +1032,7 @@ func TestUpdatePrefs(t *testing.T) {
 				AppConnectorSet:           true,
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
+				EphemeralSet:              true,
 				ExitNodeAllowLANAccessSet: true,
 				ExitNodeIDSet:             true,
 				ExitNodeIPSet:             true,
@@ -1046,6 +1047,67 @@ func TestUpdatePrefs(t *testing.T) {
 				WantRunningSet:            true,
 			},
 		},
+		{
+			name:  "keep_unspecified",
+			flags: []string{"--keep-unspecified", "--accept-routes"},
+			curPrefs: &ipn.Prefs{
+				ControlURL:      ipn.DefaultControlURL,
+				Persist:         &persist.Persist{UserProfile: tailcfg.UserProfile{LoginName: "crawshaw.github"}},
+				Hostname:        "foo",
+				AdvertiseTags:   []string{"tag:foo"},
+				AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")},
+				ShieldsUp:       true,
+			},
+			env: upCheckEnv{backendState: "Running"},
+			checkUpdatePrefsMutations: func(t *testing.T, newPrefs *ipn.Prefs) {
+				if !newPrefs.RouteAll {
+					t.Error("RouteAll = false; want true")
+				}
+				if newPrefs.Hostname != "foo" || !newPrefs.ShieldsUp {
+					t.Errorf("Hostname, ShieldsUp = %q, %v; want kept", newPrefs.Hostname, newPrefs.ShieldsUp)
+				}
+				if !reflect.DeepEqual(newPrefs.AdvertiseTags, []string{"tag:foo"}) {
+					t.Errorf("AdvertiseTags = %v; want kept", newPrefs.AdvertiseTags)
+				}
+				if !reflect.DeepEqual(newPrefs.AdvertiseRoutes, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}) {
+					t.Errorf("AdvertiseRoutes = %v; want kept", newPrefs.AdvertiseRoutes)
+				}
+			},
+			wantJustEditMP: &ipn.MaskedPrefs{
+				RouteAllSet:    true,
+				WantRunningSet: true,
+			},
+		},
+		{
+			name:  "keep_unspecified_exit_node",
+			flags: []string{"--keep-unspecified", "--advertise-exit-node"},
+			curPrefs: &ipn.Prefs{
+				ControlURL:      ipn.DefaultControlURL,
+				Persist:         &persist.Persist{UserProfile: tailcfg.UserProfile{LoginName: "crawshaw.github"}},
+				AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")},
+			},
+			env: upCheckEnv{backendState: "Running"},
+			checkUpdatePrefsMutations: func(t *testing.T, newPrefs *ipn.Prefs) {
+				if !hasExitNodeRoutes(newPrefs.AdvertiseRoutes) {
+					t.Errorf("AdvertiseRoutes = %v; want exit node routes", newPrefs.AdvertiseRoutes)
+				}
+				if got := withoutExitNodes(newPrefs.AdvertiseRoutes); !reflect.DeepEqual(got, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/16")}) {
+					t.Errorf("AdvertiseRoutes = %v; want 10.0.0.0/16 kept", newPrefs.AdvertiseRoutes)
+				}
+			},
+			wantJustEditMP: &ipn.MaskedPrefs{
+				AdvertiseRoutesSet: true,
+				WantRunningSet:     true,
+			},
+		},
+		{
+			name:  "keep_unspecified_and_reset",
+			flags: []string{"--keep-unspecified", "--reset"},
+			curPrefs: &ipn.Prefs{
+				ControlURL: ipn.DefaultControlURL,
+			},
+			wantErrSubtr: "can't use both --reset and --keep-unspecified",
+		},
 		{
 			name:  "control_synonym",
 			flags: []string{},
@@ -35,6 +35,7 @@
 	"tailscale.com/hostinfo"
 	"tailscale.com/internal/noiseconn"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/paths"
@@ -67,11 +68,22 @@
 			Exec:       runDERPMap,
 			ShortHelp:  "Print DERP map",
 		},
+		{
+			Name:       "magicsock",
+			ShortUsage: "tailscale debug magicsock",
+			Exec:       runDebugMagicsock,
+			ShortHelp:  "Print magicsock's DERP connections and peer paths",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("magicsock")
+				fs.BoolVar(&debugMagicsockArgs.redact, "redact", true, "replace the IP addresses of peers' endpoints with placeholders, so the output can be shared")
+				return fs
+			})(),
+		},
 		{
 			Name:       "component-logs",
 			ShortUsage: "tailscale debug component-logs [" + strings.Join(ipn.DebuggableComponents, "|") + "]",
 			Exec:       runDebugComponentLogs,
-			ShortHelp:  "Enable/disable debug logs for a component",
+			ShortHelp:  "Enable/disable debug logs for a component, or list those enabled",
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("component-logs")
 				fs.DurationVar(&debugComponentLogsArgs.forDur, "for", time.Hour, "how long to enable debug logs for; zero or negative means to disable")
@@ -200,6 +212,7 @@
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("prefs")
 				fs.BoolVar(&prefsArgs.pretty, "pretty", false, "If true, pretty-print output")
+				fs.BoolVar(&prefsArgs.schema, "schema", false, "If true, print the JSON Schema of the files accepted by 'tailscale set --from-file' instead")
 				return fs
 			})(),
 		},
@@ -308,6 +321,125 @@
 			Exec:       runPeerEndpointChanges,
 			ShortHelp:  "Prints debug information about a peer's endpoint changes",
 		},
+		{
+			Name:       "state-history",
+			ShortUsage: "tailscale debug state-history",
+			Exec:       runDebugStateHistory,
+			ShortHelp:  "Prints the backend's recent state transitions",
+		},
+		{
+			Name:       "dropped-flows",
+			ShortUsage: "tailscale debug dropped-flows",
+			Exec:       runDebugDroppedFlows,
+			ShortHelp:  "Prints the flows recently dropped by the packet filter",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug dropped-flows' command prints the flows that the packet
+filter dropped most recently, oldest first, with why each was dropped. A flow
+is printed at most once every 10 seconds, however many of its packets were
+dropped.
+`),
+		},
+		{
+			Name:       "conn-audit",
+			ShortUsage: "tailscale debug conn-audit [flags]",
+			Exec:       runDebugConnAudit,
+			ShortHelp:  "Prints or configures the audit log of inbound connections",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug conn-audit' command prints the connection audit log, one
+JSON object per line, oldest first. While it's enabled, the log records each
+inbound connection that a peer opens to this node once the connection ends:
+the peer's identity, the destination port, when it was open, and how many
+bytes it carried. Entries are kept for 30 days, up to 10,000 of them.
+
+With --enable or --disable, it turns the recording on or off instead. The
+setting persists across restarts of tailscaled.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("conn-audit")
+				fs.BoolVar(&debugConnAuditArgs.enable, "enable", false, "start recording inbound connections")
+				fs.BoolVar(&debugConnAuditArgs.disable, "disable", false, "stop recording inbound connections")
+				fs.DurationVar(&debugConnAuditArgs.since, "since", 0, "only print connections that ended within this long; 0 for all")
+				fs.IntVar(&debugConnAuditArgs.limit, "limit", 0, "only print this many of the most recent connections; 0 for all")
+				return fs
+			})(),
+		},
+		{
+			Name:       "readyz",
+			ShortUsage: "tailscale debug readyz [--wait] [subsystem]",
+			Exec:       runDebugReadyz,
+			ShortHelp:  "Reports whether tailscaled's subsystems are ready",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug readyz' command reports the readiness of tailscaled's
+subsystems (store, engine, magicsock, control, dns and router), or of one
+subsystem and those it depends on. It exits non-zero if they aren't all
+ready. With --wait, it waits until they are instead.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("readyz")
+				fs.BoolVar(&debugReadyzArgs.wait, "wait", false, "wait until ready")
+				fs.DurationVar(&debugReadyzArgs.timeout, "timeout", 0, "with --wait, how long to wait; 0 means forever")
+				return fs
+			})(),
+		},
+		{
+			Name:       "peer-chaos",
+			ShortUsage: "tailscale debug peer-chaos [flags] <hostname-or-IP>",
+			Exec:       runDebugPeerChaos,
+			ShortHelp:  "Adds artificial latency and packet loss to traffic sent to a peer",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug peer-chaos' command makes this node delay and drop the
+packets it sends to a peer, to test how software behaves over a degraded
+tailnet path. It lasts until tailscaled restarts, or until the command is
+run again; running it with no flags removes the degradation.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("peer-chaos")
+				fs.DurationVar(&debugPeerChaosArgs.latency, "latency", 0, "delay to add to each packet")
+				fs.DurationVar(&debugPeerChaosArgs.jitter, "jitter", 0, "maximum random delay to add to each packet on top of --latency")
+				fs.Float64Var(&debugPeerChaosArgs.drop, "drop", 0, "fraction of packets to drop, from 0 to 1")
+				return fs
+			})(),
+		},
+		{
+			Name:       "peer-keepalive",
+			ShortUsage: "tailscale debug peer-keepalive [flags] <hostname-or-IP>",
+			Exec:       runDebugPeerKeepalive,
+			ShortHelp:  "Tunes how often this node keeps its connection to a peer alive",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug peer-keepalive' command sets how often this node sends
+disco heartbeats to a peer while there's traffic to it, and WireGuard
+persistent keepalives to it even while there isn't. Fewer of them let a
+battery-powered device sleep more, at the cost of noticing path changes and
+NAT timeouts later. It lasts until tailscaled restarts, or until the command
+is run again; running it with no flags restores the defaults.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("peer-keepalive")
+				fs.DurationVar(&debugPeerKeepaliveArgs.heartbeat, "heartbeat", 0, "interval of disco heartbeats; 0 for the default, negative for none")
+				fs.DurationVar(&debugPeerKeepaliveArgs.wireguard, "wireguard", 0, "interval of WireGuard persistent keepalives, in whole seconds; 0 for none")
+				return fs
+			})(),
+		},
+		{
+			Name:       "speedtest",
+			ShortUsage: "tailscale debug speedtest [--time=5s] <hostname-or-IP>",
+			Exec:       runDebugSpeedtest,
+			ShortHelp:  "Measures throughput to and from a peer over Tailscale",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug speedtest' command transfers data from a peer to this node
+and then from this node to the peer, over the tailnet, and prints the
+throughput and the path (direct or DERP relay) used for each direction. It
+helps tell slow tailnet paths apart from slow applications.
+
+The peer must be owned by the same user as this node, or grant it the
+tailscale.com/cap/speedtest capability in the tailnet policy file.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("speedtest")
+				fs.DurationVar(&debugSpeedtestArgs.duration, "time", 5*time.Second, "how long to transfer data in each direction, up to 30s")
+				return fs
+			})(),
+		},
 		{
 			Name:       "dial-types",
 			ShortUsage: "tailscale debug dial-types <hostname-or-IP> <port>",
@@ -452,9 +584,15 @@ func runLocalAPIProxy() {
 
 var prefsArgs struct {
 	pretty bool
+	schema bool
 }
 
 func runPrefs(ctx context.Context, args []string) error {
+	if prefsArgs.schema {
+		j, _ := json.MarshalIndent(ipn.PrefsSchema(), "", "\t")
+		outln(string(j))
+		return nil
+	}
 	prefs, err := localClient.GetPrefs(ctx)
 	if err != nil {
 		return err
@@ -543,6 +681,22 @@ func runDERPMap(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugMagicsockArgs struct {
+	redact bool
+}
+
+func runDebugMagicsock(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.DebugMagicsockState(ctx, debugMagicsockArgs.redact)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(st)
+	return nil
+}
+
 func localAPIAction(action string) func(context.Context, []string) error {
 	return func(ctx context.Context, args []string) error {
 		if len(args) > 0 {
@@ -893,6 +1047,21 @@ func runTS2021(ctx context.Context, args []string) error {
 }
 
 func runDebugComponentLogs(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		enabled, err := localClient.ComponentDebugLogging(ctx)
+		if err != nil {
+			return err
+		}
+		if len(enabled) == 0 {
+			outln("No components have debug logs enabled.")
+		}
+		for _, component := range ipn.DebuggableComponents {
+			if until, ok := enabled[component]; ok {
+				printf("%s: enabled until %v\n", component, until.Local().Format(time.RFC3339))
+			}
+		}
+		return nil
+	}
 	if len(args) != 1 {
 		return errors.New("usage: tailscale debug component-logs [" + strings.Join(ipn.DebuggableComponents, "|") + "]")
 	}
@@ -1110,6 +1279,243 @@ func debugControlKnobs(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runDebugStateHistory(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	hist, err := localClient.DebugStateHistory(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range hist {
+		line := fmt.Sprintf("%s %v -> %v: %s", t.Time.Format(time.RFC3339), t.From, t.To, t.Reason)
+		if t.Illegal {
+			line += " (illegal)"
+		}
+		outln(line)
+	}
+	return nil
+}
+
+func runDebugDroppedFlows(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	flows, err := localClient.DebugDroppedFlows(ctx)
+	if err != nil {
+		return err
+	}
+	for _, f := range flows {
+		outln(fmt.Sprintf("%s %s %v %v -> %v: %s", f.Time.Format(time.RFC3339), f.Dir, f.Proto, f.Src, f.Dst, f.Reason))
+	}
+	return nil
+}
+
+var debugConnAuditArgs struct {
+	enable  bool
+	disable bool
+	since   time.Duration
+	limit   int
+}
+
+func runDebugConnAudit(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	a := debugConnAuditArgs
+	if a.enable || a.disable {
+		if a.enable && a.disable {
+			return errors.New("--enable and --disable are mutually exclusive")
+		}
+		if err := localClient.SetConnAuditEnabled(ctx, a.enable); err != nil {
+			return err
+		}
+		if a.enable {
+			printf("Connection audit log enabled.\n")
+		} else {
+			printf("Connection audit log disabled.\n")
+		}
+		return nil
+	}
+	var since time.Time
+	if a.since > 0 {
+		since = time.Now().Add(-a.since)
+	}
+	res, err := localClient.ConnAudit(ctx, since, a.limit)
+	if err != nil {
+		return err
+	}
+	if !res.Enabled {
+		fmt.Fprintln(Stderr, "# connection audit log is disabled; enable it with --enable")
+	}
+	enc := json.NewEncoder(Stdout)
+	for _, e := range res.Entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var debugReadyzArgs struct {
+	wait    bool
+	timeout time.Duration
+}
+
+func runDebugReadyz(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: tailscale debug readyz [--wait] [subsystem]")
+	}
+	var subsystem string
+	if len(args) == 1 {
+		subsystem = args[0]
+	}
+	if debugReadyzArgs.wait && debugReadyzArgs.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, debugReadyzArgs.timeout)
+		defer cancel()
+	}
+	res, err := localClient.Readyz(ctx, subsystem, debugReadyzArgs.wait)
+	if err != nil {
+		return err
+	}
+	for _, s := range res.Subsystems {
+		if s.Ready {
+			outln(s.Name + ": ready")
+		} else {
+			outln(s.Name + ": not ready: " + s.Reason)
+		}
+	}
+	if !res.Ready {
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+var debugPeerChaosArgs struct {
+	latency time.Duration
+	jitter  time.Duration
+	drop    float64
+}
+
+func runDebugPeerChaos(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug peer-chaos [flags] <hostname-or-IP>")
+	}
+	hostOrIP := args[0]
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	if ipStr != hostOrIP {
+		log.Printf("lookup %q => %q", hostOrIP, ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	a := debugPeerChaosArgs
+	if err := localClient.DebugSetPeerChaos(ctx, ip, a.latency, a.jitter, a.drop); err != nil {
+		return err
+	}
+	if a.latency == 0 && a.jitter == 0 && a.drop == 0 {
+		printf("Removed degradation of traffic to %v.\n", hostOrIP)
+	} else {
+		printf("Traffic to %v now has latency %v (+ up to %v jitter) and drop rate %v.\n", hostOrIP, a.latency, a.jitter, a.drop)
+	}
+	return nil
+}
+
+var debugPeerKeepaliveArgs struct {
+	heartbeat time.Duration
+	wireguard time.Duration
+}
+
+func runDebugPeerKeepalive(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug peer-keepalive [flags] <hostname-or-IP>")
+	}
+	hostOrIP := args[0]
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	if ipStr != hostOrIP {
+		log.Printf("lookup %q => %q", hostOrIP, ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	ka, err := localClient.SetPeerKeepalive(ctx, ip, ipnstate.PeerKeepalive{
+		HeartbeatInterval:  debugPeerKeepaliveArgs.heartbeat,
+		WireGuardKeepalive: debugPeerKeepaliveArgs.wireguard,
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case ka.HeartbeatInterval < 0:
+		printf("Heartbeats to %v: none\n", hostOrIP)
+	case ka.HeartbeatInterval == 0:
+		printf("Heartbeats to %v: default interval\n", hostOrIP)
+	default:
+		printf("Heartbeats to %v: every %v\n", hostOrIP, ka.HeartbeatInterval)
+	}
+	if ka.WireGuardKeepalive == 0 {
+		printf("WireGuard keepalives to %v: none\n", hostOrIP)
+	} else {
+		printf("WireGuard keepalives to %v: every %v\n", hostOrIP, ka.WireGuardKeepalive)
+	}
+	return nil
+}
+
+var debugSpeedtestArgs struct {
+	duration time.Duration
+}
+
+func runDebugSpeedtest(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug speedtest [--time=5s] <hostname-or-IP>")
+	}
+	hostOrIP := args[0]
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	if ipStr != hostOrIP {
+		log.Printf("lookup %q => %q", hostOrIP, ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	printf("Testing throughput with %v for %v in each direction...\n", hostOrIP, debugSpeedtestArgs.duration)
+	res, err := localClient.DebugSpeedtest(ctx, ip, debugSpeedtestArgs.duration)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		path := "unknown path"
+		if r.Endpoint != "" {
+			path = "direct " + r.Endpoint
+		} else if r.DERPRegionCode != "" {
+			path = "DERP(" + r.DERPRegionCode + ")"
+		}
+		printf("%-8s %8.2f Mbits/sec  %.2f MB in %v via %s\n", r.Direction, r.MbitsPerSecond(), float64(r.Bytes)/1e6, r.Duration.Round(time.Millisecond), path)
+	}
+	return nil
+}
+
 var debugDialTypesArgs struct {
 	network string
 }
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/atomicfile"
+)
+
+var metricsCmd = &ffcli.Command{
+	Name:       "metrics",
+	ShortUsage: "tailscale metrics <subcommand> [flags]",
+	ShortHelp:  "Show Tailscale metrics",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale metrics' command shows the metrics of tailscaled: counters and
+gauges from its subsystems (magicsock, the DERP client, the packet filter, the
+engine and more), in the Prometheus text-based exposition format.
+
+To have Prometheus scrape them over HTTP instead, run tailscaled with
+--debug=<ip>:<port> and scrape /debug/metrics on that address.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "print",
+			ShortUsage: "tailscale metrics print",
+			Exec:       runMetricsPrint,
+			ShortHelp:  "Print current metric values in the Prometheus text format",
+		},
+		{
+			Name:       "write",
+			ShortUsage: "tailscale metrics write <path>",
+			Exec:       runMetricsWrite,
+			ShortHelp:  "Write metric values to a file",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale metrics write' command writes the metrics of tailscaled to a
+text file, atomically, in the Prometheus text-based exposition format. Run it
+periodically to have the textfile collector of node_exporter pick them up.
+`),
+		},
+	},
+}
+
+func runMetricsPrint(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	out, err := localClient.DaemonMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(out)
+	return nil
+}
+
+func runMetricsWrite(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale metrics write <path>")
+	}
+	out, err := localClient.DaemonMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(args[0], out, 0644)
+}
@@ -11,6 +11,7 @@
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -36,6 +37,7 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.StringVar(&netcheckArgs.derpMap, "derp-map", "", "if non-empty, the path or http(s) URL of a JSON DERP map to check instead of the one from tailscaled, such as to validate a new DERP server before deploying it")
 		return fs
 	})(),
 }
@@ -44,6 +46,7 @@
 	format  string
 	every   time.Duration
 	verbose bool
+	derpMap string // path or URL of a DERP map to use instead of tailscaled's
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
@@ -78,21 +81,29 @@ func runNetcheck(ctx context.Context, args []string) error {
 		fmt.Fprintln(Stderr, "netcheck: UDP test failure:", err)
 	}
 
-	dm, err := localClient.CurrentDERPMap(ctx)
-	noRegions := dm != nil && len(dm.Regions) == 0
-	if noRegions {
-		log.Printf("No DERP map from tailscaled; using default.")
+	hc := &http.Client{
+		Transport: tlsdial.NewTransport(),
+		Timeout:   10 * time.Second,
 	}
-	if err != nil || noRegions {
-		hc := &http.Client{
-			Transport: tlsdial.NewTransport(),
-			Timeout:   10 * time.Second,
-		}
-		dm, err = prodDERPMap(ctx, hc)
+	var dm *tailcfg.DERPMap
+	if netcheckArgs.derpMap != "" {
+		dm, err = loadDERPMap(ctx, hc, netcheckArgs.derpMap)
 		if err != nil {
-			log.Println("Failed to fetch a DERP map, so netcheck cannot continue. Check your Internet connection.")
 			return err
 		}
+	} else {
+		dm, err = localClient.CurrentDERPMap(ctx)
+		noRegions := dm != nil && len(dm.Regions) == 0
+		if noRegions {
+			log.Printf("No DERP map from tailscaled; using default.")
+		}
+		if err != nil || noRegions {
+			dm, err = prodDERPMap(ctx, hc)
+			if err != nil {
+				log.Println("Failed to fetch a DERP map, so netcheck cannot continue. Check your Internet connection.")
+				return err
+			}
+		}
 	}
 	for {
 		t0 := time.Now()
@@ -220,25 +231,60 @@ func portMapping(r *netcheck.Report) string {
 
 func prodDERPMap(ctx context.Context, httpc *http.Client) (*tailcfg.DERPMap, error) {
 	log.Printf("attempting to fetch a DERPMap from %s", ipn.DefaultControlURL)
-	req, err := http.NewRequestWithContext(ctx, "GET", ipn.DefaultControlURL+"/derpmap/default", nil)
+	dm, err := fetchDERPMap(ctx, httpc, ipn.DefaultControlURL+"/derpmap/default")
 	if err != nil {
-		return nil, fmt.Errorf("create prodDERPMap request: %w", err)
+		return nil, fmt.Errorf("fetch prodDERPMap: %w", err)
+	}
+	return dm, nil
+}
+
+// loadDERPMap returns the DERP map at src, which is either an http(s) URL
+// or the path of a local file, in JSON.
+func loadDERPMap(ctx context.Context, httpc *http.Client, src string) (*tailcfg.DERPMap, error) {
+	var dm *tailcfg.DERPMap
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		var err error
+		dm, err = fetchDERPMap(ctx, httpc, src)
+		if err != nil {
+			return nil, fmt.Errorf("fetching DERP map: %w", err)
+		}
+	} else {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		dm = new(tailcfg.DERPMap)
+		if err := json.Unmarshal(b, dm); err != nil {
+			return nil, fmt.Errorf("parsing DERP map %s: %w", src, err)
+		}
+	}
+	if len(dm.Regions) == 0 {
+		return nil, fmt.Errorf("DERP map %s has no regions", src)
+	}
+	return dm, nil
+}
+
+// fetchDERPMap fetches the JSON DERP map at url.
+func fetchDERPMap(ctx context.Context, httpc *http.Client, url string) (*tailcfg.DERPMap, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
 	res, err := httpc.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetch prodDERPMap failed: %w", err)
+		return nil, err
 	}
 	defer res.Body.Close()
 	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
 	if err != nil {
-		return nil, fmt.Errorf("fetch prodDERPMap failed: %w", err)
+		return nil, err
 	}
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("fetch prodDERPMap: %v: %s", res.Status, b)
+		return nil, fmt.Errorf("%v: %s", res.Status, b)
 	}
 	var derpMap tailcfg.DERPMap
 	if err = json.Unmarshal(b, &derpMap); err != nil {
-		return nil, fmt.Errorf("fetch prodDERPMap: %w", err)
+		return nil, err
 	}
 	return &derpMap, nil
 }
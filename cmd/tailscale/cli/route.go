@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/net/netutil"
+)
+
+func routeCmd() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "route",
+		ShortUsage: "tailscale route <add|remove> [flags] [<route>...]",
+		ShortHelp:  "Add or remove routes advertised by this machine",
+		LongHelp: strings.TrimSpace(`
+"tailscale route" adds or removes individual subnet routes advertised by
+this machine, or its advertisement as an exit node, without changing any
+other settings. Routes are IP prefixes such as 10.0.0.0/8.
+
+Unlike "tailscale set --advertise-routes", which replaces all advertised
+routes, it leaves the routes it isn't given as they are.
+`),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "add",
+				ShortUsage: "tailscale route add [--exit-node] [<route>...]",
+				ShortHelp:  "Advertise routes",
+				Exec:       runRouteEdit(true),
+				FlagSet:    routeFlagSet("add"),
+			},
+			{
+				Name:       "remove",
+				ShortUsage: "tailscale route remove [--exit-node] [<route>...]",
+				ShortHelp:  "Stop advertising routes",
+				Exec:       runRouteEdit(false),
+				FlagSet:    routeFlagSet("remove"),
+			},
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+var routeArgs struct {
+	exitNode bool
+}
+
+func routeFlagSet(name string) *flag.FlagSet {
+	fs := newFlagSet(name)
+	fs.BoolVar(&routeArgs.exitNode, "exit-node", false, "also add or remove the default routes, advertising this machine as an exit node or not")
+	return fs
+}
+
+func runRouteEdit(add bool) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 && !routeArgs.exitNode {
+			return errors.New("no routes given")
+		}
+		routes, err := netutil.CalcAdvertiseRoutes(strings.Join(args, ","), routeArgs.exitNode)
+		if err != nil {
+			return err
+		}
+		if add {
+			_, err = localClient.EditAdvertisedRoutes(ctx, routes, nil)
+		} else {
+			_, err = localClient.EditAdvertisedRoutes(ctx, nil, routes)
+		}
+		return err
+	}
+}
@@ -159,6 +159,9 @@ type serveEnv struct {
 	http             uint      // HTTP port
 	tcp              uint      // TCP port
 	tlsTerminatedTCP uint      // a TLS terminated TCP port
+	udp              uint      // UDP port
+	proxyProtocol    int       // PROXY protocol version for TCP forwarders; 0 for none
+	forwardedHeader  bool      // whether HTTP proxies send a Forwarded header
 	subcmd           serveMode // subcommand
 	yes              bool      // update without prompt
 
@@ -614,7 +617,7 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 		return nil
 	}
 	printFunnelStatus(ctx)
-	if sc == nil || (len(sc.TCP) == 0 && len(sc.Web) == 0 && len(sc.AllowFunnel) == 0) {
+	if sc == nil || (len(sc.TCP) == 0 && len(sc.UDP) == 0 && len(sc.Web) == 0 && len(sc.AllowFunnel) == 0) {
 		printf("No serve config\n")
 		return nil
 	}
@@ -628,6 +631,10 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 		}
 		printf("\n")
 	}
+	if len(sc.UDP) > 0 {
+		printUDPStatusTree(sc, st)
+		printf("\n")
+	}
 	for hp := range sc.Web {
 		err := e.printWebStatusTree(sc, hp)
 		if err != nil {
@@ -664,6 +671,23 @@ func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.S
 	return nil
 }
 
+func printUDPStatusTree(sc *ipn.ServeConfig, st *ipnstate.Status) {
+	dnsName := strings.TrimSuffix(st.Self.DNSName, ".")
+	for p, h := range sc.UDP {
+		hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(p))))
+		fStatus := "tailnet only"
+		if sc.AllowFunnel[hp] {
+			fStatus = "Funnel on"
+		}
+		printf("|-- udp://%s (%s)\n", hp, fStatus)
+		for _, a := range st.TailscaleIPs {
+			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(p)))
+			printf("|-- udp://%s\n", ipp)
+		}
+		printf("|--> udp://%s\n", h.UDPForward)
+	}
+}
+
 func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) error {
 	// No-op if no serve config
 	if sc == nil {
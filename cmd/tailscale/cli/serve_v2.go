@@ -71,6 +71,7 @@ type commandInfo struct {
 	serveTypeHTTP
 	serveTypeTCP
 	serveTypeTLSTerminatedTCP
+	serveTypeUDP
 )
 
 var infoMap = map[serveMode]commandInfo{
@@ -126,6 +127,9 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			}
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
+			fs.UintVar(&e.udp, "udp", 0, "Expose a UDP forwarder to forward UDP datagrams at the specified port")
+			fs.IntVar(&e.proxyProtocol, "proxy-protocol", 0, "For TCP forwarders, the PROXY protocol version (1 or 2) with which to send the original client address to the target")
+			fs.BoolVar(&e.forwardedHeader, "forwarded-header", false, "For HTTP proxies, also send a Forwarded header (RFC 7239) describing the original client")
 			fs.BoolVar(&e.yes, "yes", false, "Update without interactive prompts (default false)")
 		}),
 		UsageFunc: usageFuncNoDefaultValues,
@@ -333,7 +337,13 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 const backgroundExistsMsg = "background configuration already exists, use `tailscale %s --%s=%d off` to remove the existing configuration"
 
 func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe serveType) error {
-	sc, isFg := sc.FindConfig(port)
+	find := sc.FindConfig
+	if wantServe == serveTypeUDP {
+		// UDP ports are separate from the TCP ports used by the
+		// other serve types.
+		find = sc.FindUDPConfig
+	}
+	sc, isFg := find(port)
 	if sc == nil {
 		return nil
 	}
@@ -343,6 +353,9 @@ func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe se
 	if !e.bg {
 		return fmt.Errorf(backgroundExistsMsg, infoMap[e.subcmd].Name, wantServe.String(), port)
 	}
+	if wantServe == serveTypeUDP {
+		return nil
+	}
 	existingServe := serveFromPortHandler(sc.TCP[port])
 	if wantServe != existingServe {
 		return fmt.Errorf("want %q but port is already serving %q", wantServe, existingServe)
@@ -369,6 +382,9 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, st *ipnstate.Status, dnsName st
 	// update serve config based on the type
 	switch srvType {
 	case serveTypeHTTPS, serveTypeHTTP:
+		if e.proxyProtocol != 0 {
+			return errors.New("--proxy-protocol is only supported for TCP forwarders")
+		}
 		useTLS := srvType == serveTypeHTTPS
 		err := e.applyWebServe(sc, dnsName, srvPort, useTLS, mount, target)
 		if err != nil {
@@ -378,11 +394,27 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, st *ipnstate.Status, dnsName st
 		if e.setPath != "" {
 			return fmt.Errorf("cannot mount a path for TCP serve")
 		}
+		if e.forwardedHeader {
+			return errors.New("--forwarded-header is only supported for HTTP proxies")
+		}
 
 		err := e.applyTCPServe(sc, dnsName, srvType, srvPort, target)
 		if err != nil {
 			return fmt.Errorf("failed to apply TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		if e.setPath != "" {
+			return fmt.Errorf("cannot mount a path for UDP serve")
+		}
+		if e.proxyProtocol != 0 {
+			return errors.New("--proxy-protocol is only supported for TCP forwarders")
+		}
+		if e.forwardedHeader {
+			return errors.New("--forwarded-header is only supported for HTTP proxies")
+		}
+		if err := e.applyUDPServe(sc, srvPort, target); err != nil {
+			return fmt.Errorf("failed to apply UDP serve: %w", err)
+		}
 	default:
 		return fmt.Errorf("invalid type %q", srvType)
 	}
@@ -438,7 +470,14 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 		return "", ""
 	}
 
-	if sc.Web[hp] != nil {
+	if srvType == serveTypeUDP {
+		output.WriteString(fmt.Sprintf("|-- udp://%s\n", hp))
+		for _, a := range st.TailscaleIPs {
+			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(srvPort)))
+			output.WriteString(fmt.Sprintf("|-- udp://%s\n", ipp))
+		}
+		output.WriteString(fmt.Sprintf("|--> udp://%s\n", sc.UDP[srvPort].UDPForward))
+	} else if sc.Web[hp] != nil {
 		var mounts []string
 
 		for k := range sc.Web[hp].Handlers {
@@ -521,6 +560,7 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			return err
 		}
 		h.Proxy = t
+		h.ForwardedHeader = e.forwardedHeader
 	}
 
 	// TODO: validation needs to check nested foreground configs
@@ -559,11 +599,31 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("cannot serve TCP; already serving web on %d", srcPort)
 	}
 
+	switch e.proxyProtocol {
+	case 0, 1, 2:
+	default:
+		return fmt.Errorf("invalid PROXY protocol version %d; must be 1 or 2", e.proxyProtocol)
+	}
+
 	sc.SetTCPForwarding(srcPort, dstURL.Host, terminateTLS, dnsName)
+	sc.TCP[srcPort].ProxyProtocol = e.proxyProtocol
 
 	return nil
 }
 
+func (e *serveEnv) applyUDPServe(sc *ipn.ServeConfig, srcPort uint16, target string) error {
+	targetURL, err := ipn.ExpandProxyTargetValue(target, []string{"udp"}, "udp")
+	if err != nil {
+		return fmt.Errorf("unable to expand target: %v", err)
+	}
+	dstURL, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("invalid UDP target %q: %v", target, err)
+	}
+	sc.SetUDPForwarding(srcPort, dstURL.Host)
+	return nil
+}
+
 func (e *serveEnv) applyFunnel(sc *ipn.ServeConfig, dnsName string, srvPort uint16, allowFunnel bool) {
 	hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
 
@@ -592,6 +652,11 @@ func (e *serveEnv) unsetServe(sc *ipn.ServeConfig, dnsName string, srvType serve
 		if err != nil {
 			return fmt.Errorf("failed to remove TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		if !sc.IsUDPForwardingOnPort(srvPort) {
+			return errors.New("error: serve config does not exist")
+		}
+		sc.RemoveUDPForwarding(srvPort)
 	default:
 		return fmt.Errorf("invalid type %q", srvType)
 	}
@@ -607,6 +672,7 @@ func srvTypeAndPortFromFlags(e *serveEnv) (srvType serveType, srvPort uint16, er
 		serveTypeHTTPS:            e.https,
 		serveTypeTCP:              e.tcp,
 		serveTypeTLSTerminatedTCP: e.tlsTerminatedTCP,
+		serveTypeUDP:              e.udp,
 	}
 
 	var srcTypeCount int
@@ -814,6 +880,8 @@ func (s serveType) String() string {
 		return "tcp"
 	case serveTypeTLSTerminatedTCP:
 		return "tls-terminated-tcp"
+	case serveTypeUDP:
+		return "udp"
 	default:
 		return "unknownServeType"
 	}
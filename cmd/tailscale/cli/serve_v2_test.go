@@ -482,6 +482,38 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "udp",
+			steps: []step{
+				{
+					command: cmd("serve --udp=53 --bg 5353"),
+					want: &ipn.ServeConfig{
+						UDP: map[uint16]*ipn.UDPPortHandler{53: {UDPForward: "127.0.0.1:5353"}},
+					},
+				},
+				{ // UDP ports don't conflict with TCP ports
+					command: cmd("serve --tcp=53 --bg tcp://localhost:5353"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{53: {TCPForward: "localhost:5353"}},
+						UDP: map[uint16]*ipn.UDPPortHandler{53: {UDPForward: "127.0.0.1:5353"}},
+					},
+				},
+				{
+					command: cmd("serve --udp=53 --bg --proxy-protocol=1 5353"),
+					wantErr: exactErrMsg(errHelp),
+				},
+				{ // handler doesn't exist
+					command: cmd("serve --udp=54 off"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --udp=53 off"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{53: {TCPForward: "localhost:5353"}},
+					},
+				},
+			},
+		},
 		{
 			name: "text",
 			steps: []step{{
@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+var servicesCmd = &ffcli.Command{
+	Name:       "services",
+	ShortUsage: "tailscale services [--json] [name]",
+	ShortHelp:  "List, declare and remove named services on your tailnet",
+	LongHelp: strings.TrimSpace(`
+'tailscale services' lists the named services that machines on your tailnet
+have declared, optionally only those with the given name, along with the
+machine and port to reach each at.
+
+Use 'tailscale services add' to declare a service running on this machine,
+so that others can find it by name instead of remembering its port number.
+`),
+	Exec: runServicesList,
+	FlagSet: func() *flag.FlagSet {
+		fs := newFlagSet("services")
+		fs.BoolVar(&servicesArgs.json, "json", false, "output in JSON format")
+		return fs
+	}(),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "add",
+			ShortUsage: "tailscale services add [--proto=tcp|udp] [--health=ok|down | --health-check] <name> <port>",
+			ShortHelp:  "Declare a service running on this machine",
+			LongHelp: strings.TrimSpace(`
+'tailscale services add' declares that a service with the given name is
+listening on the given port at this machine's Tailscale addresses. A service
+already declared with the same name is replaced.
+
+With --health-check, tailscaled connects to the service every 30 seconds and
+reports it as down to peers when it can't.
+`),
+			Exec: runServicesAdd,
+			FlagSet: func() *flag.FlagSet {
+				fs := newFlagSet("add")
+				fs.StringVar(&servicesArgs.proto, "proto", "tcp", `protocol of the service; "tcp" or "udp"`)
+				fs.StringVar(&servicesArgs.health, "health", "", `health of the service to report; "ok", "down" or empty for unknown`)
+				fs.BoolVar(&servicesArgs.healthCheck, "health-check", false, "periodically check that the service accepts TCP connections on this machine's loopback address, and report its health accordingly")
+				return fs
+			}(),
+		},
+		{
+			Name:       "remove",
+			ShortUsage: "tailscale services remove <name>",
+			ShortHelp:  "Remove a service declared on this machine",
+			Exec:       runServicesRemove,
+		},
+	},
+}
+
+var servicesArgs struct {
+	json        bool
+	proto       string
+	health      string
+	healthCheck bool
+}
+
+func runServicesList(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments, expected at most a service name")
+	}
+	svcs, err := localClient.TailnetServices(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if len(args) == 1 {
+		svcs = slices.DeleteFunc(svcs, func(s apitype.TailnetService) bool { return s.Name != args[0] })
+	}
+	if servicesArgs.json {
+		ec := json.NewEncoder(Stdout)
+		ec.SetIndent("", "  ")
+		return ec.Encode(svcs)
+	}
+	if len(svcs) == 0 {
+		outln("No services declared.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", "SERVICE", "MACHINE", "ADDRESS", "PROTO", "HEALTH")
+	for _, s := range svcs {
+		addr := "-"
+		if len(s.Addrs) > 0 {
+			addr = netip.AddrPortFrom(s.Addrs[0], s.Port).String()
+		}
+		health := string(s.Health)
+		if health == "" {
+			health = "-"
+		}
+		if !s.Online {
+			health = "offline"
+		}
+		machine := s.NodeName
+		if s.Self {
+			machine += " (this machine)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, machine, addr, s.Proto, health)
+	}
+	return nil
+}
+
+func runServicesAdd(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tailscale services add [--proto=tcp|udp] [--health=ok|down | --health-check] <name> <port>")
+	}
+	port, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil || port == 0 {
+		return fmt.Errorf("invalid port %q", args[1])
+	}
+	return localClient.DeclareService(ctx, tailcfg.DeclaredService{
+		Name:        args[0],
+		Proto:       tailcfg.ServiceProto(servicesArgs.proto),
+		Port:        uint16(port),
+		Health:      tailcfg.ServiceHealth(servicesArgs.health),
+		HealthCheck: servicesArgs.healthCheck,
+	})
+}
+
+func runServicesRemove(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale services remove <name>")
+	}
+	return localClient.RemoveDeclaredService(ctx, args[0])
+}
@@ -8,7 +8,10 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net/netip"
+	"os"
 	"os/exec"
 	"strings"
 
@@ -17,6 +20,7 @@
 	"tailscale.com/clientupdate"
 	"tailscale.com/cmd/tailscale/cli/ffcomplete"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
@@ -33,7 +37,13 @@
 
 Unlike "tailscale up", this command does not require the complete set of desired settings.
 
-Only settings explicitly mentioned will be set. There are no default values.`,
+Only settings explicitly mentioned will be set. There are no default values.
+
+Alternatively, --from-file reads the settings to change from a JSON file
+("-" for stdin) in the same format as "tailscale debug prefs" prints. Only
+the fields present in the file are set. The file's schema is printed by
+"tailscale debug prefs --schema". Use --dry-run to validate the settings
+without applying them.`,
 	FlagSet:   setFlagSet,
 	Exec:      runSet,
 	UsageFunc: usageFuncNoDefaultValues,
@@ -58,9 +68,17 @@ type setArgsT struct {
 	updateCheck            bool
 	updateApply            bool
 	postureChecking        bool
+	autoKeyRenewal         bool
+	derpPlaintextFallback  bool
+	validateDNSSEC         bool
+	dnsAliases             string
+	blockPeers             string
+	listenPort             uint
 	snat                   bool
 	statefulFiltering      bool
 	netfilterMode          string
+	fromFile               string
+	dryRun                 bool
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -80,7 +98,15 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.updateCheck, "update-check", true, "notify about available Tailscale updates")
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "automatically update to the latest available version")
 	setf.BoolVar(&setArgs.postureChecking, "posture-checking", false, hidden+"allow management plane to gather device posture information")
+	setf.BoolVar(&setArgs.autoKeyRenewal, "auto-key-renewal", true, "automatically renew the node key before it expires, if the control server allows it")
+	setf.BoolVar(&setArgs.validateDNSSEC, "dnssec", false, "validate DNSSEC signatures of DNS responses resolved through Tailscale DNS, failing those that don't validate")
+	setf.StringVar(&setArgs.dnsAliases, "dns-aliases", "", "comma-separated additional MagicDNS names for this machine (e.g. \"jellyfin,media\"), if permitted by the tailnet's policy, or empty string to remove them")
+	setf.StringVar(&setArgs.blockPeers, "block-peers", "", "comma-separated peers, by node key or name, to drop all traffic from and to regardless of the tailnet's policy, or empty string to unblock all")
+	setf.UintVar(&setArgs.listenPort, "listen-port", 0, "UDP port to listen on for peer-to-peer traffic instead of tailscaled's --port, falling back to another port while it's in use; 0 to use tailscaled's")
+	setf.BoolVar(&setArgs.derpPlaintextFallback, "derp-plaintext-fallback", false, "connect to DERP relay servers over unencrypted HTTP on port 80 if TLS to them is blocked; relayed traffic stays end-to-end encrypted")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "expose the web interface for managing this node over Tailscale at port 5252")
+	setf.StringVar(&setArgs.fromFile, "from-file", "", "read the settings to change from a JSON file (\"-\" for stdin) instead of flags")
+	setf.BoolVar(&setArgs.dryRun, "dry-run", false, "validate the settings and print the changes without applying them")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
 		st, err := localClient.Status(context.Background())
@@ -128,6 +154,30 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 
+	if setArgs.fromFile != "" {
+		var others []string
+		setFlagSet.Visit(func(f *flag.Flag) {
+			if !preflessFlag(f.Name) {
+				others = append(others, "--"+f.Name)
+			}
+		})
+		if len(others) > 0 {
+			return fmt.Errorf("--from-file can't be combined with %s", strings.Join(others, ", "))
+		}
+		maskedPrefs, err := readPrefsFile(setArgs.fromFile)
+		if err != nil {
+			return err
+		}
+		if maskedPrefs.IsEmpty() {
+			return fmt.Errorf("%s: no settings to change", setArgs.fromFile)
+		}
+		curPrefs, err := localClient.GetPrefs(ctx)
+		if err != nil {
+			return err
+		}
+		return applySetPrefs(ctx, st, curPrefs, maskedPrefs)
+	}
+
 	// Note that even though we set the values here regardless of whether the
 	// user passed the flag, the value is only used if the user passed the flag.
 	// See updateMaskedPrefsFromUpOrSetFlag.
@@ -151,11 +201,25 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			AppConnector: ipn.AppConnectorPrefs{
 				Advertise: setArgs.advertiseConnector,
 			},
-			PostureChecking:     setArgs.postureChecking,
-			NoStatefulFiltering: opt.NewBool(!setArgs.statefulFiltering),
+			PostureChecking:       setArgs.postureChecking,
+			NoAutoKeyRenewal:      !setArgs.autoKeyRenewal,
+			DERPPlaintextFallback: setArgs.derpPlaintextFallback,
+			ValidateDNSSEC:        setArgs.validateDNSSEC,
+			NoStatefulFiltering:   opt.NewBool(!setArgs.statefulFiltering),
 		},
 	}
 
+	if setArgs.dnsAliases != "" {
+		maskedPrefs.Prefs.AdvertiseDNSAliases = strings.Split(setArgs.dnsAliases, ",")
+	}
+	if setArgs.blockPeers != "" {
+		maskedPrefs.Prefs.BlockedPeers = strings.Split(setArgs.blockPeers, ",")
+	}
+	if setArgs.listenPort > math.MaxUint16 {
+		return fmt.Errorf("invalid --listen-port %d; must be at most %d", setArgs.listenPort, math.MaxUint16)
+	}
+	maskedPrefs.Prefs.ListenPort = uint16(setArgs.listenPort)
+
 	if effectiveGOOS() == "linux" {
 		nfMode, warning, err := netfilterModeFromFlag(setArgs.netfilterMode)
 		if err != nil {
@@ -202,7 +266,12 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			return err
 		}
 	}
+	return applySetPrefs(ctx, st, curPrefs, maskedPrefs)
+}
 
+// applySetPrefs checks and, unless --dry-run was given, applies the edits in
+// maskedPrefs on top of curPrefs.
+func applySetPrefs(ctx context.Context, st *ipnstate.Status, curPrefs *ipn.Prefs, maskedPrefs *ipn.MaskedPrefs) error {
 	if maskedPrefs.RunSSHSet {
 		wantSSH, haveSSH := maskedPrefs.RunSSH, curPrefs.RunSSH
 		if err := presentSSHToggleRisk(wantSSH, haveSSH, setArgs.acceptedRisks); err != nil {
@@ -232,18 +301,43 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 
-	_, err = localClient.EditPrefs(ctx, maskedPrefs)
-	if err != nil {
+	if setArgs.dryRun {
+		outln("Settings are valid; not applying (--dry-run):")
+		outln(maskedPrefs.Pretty())
+		return nil
+	}
+
+	if _, err := localClient.EditPrefs(ctx, maskedPrefs); err != nil {
 		return err
 	}
 
-	if setArgs.runWebClient && len(st.TailscaleIPs) > 0 {
+	if maskedPrefs.RunWebClientSet && maskedPrefs.RunWebClient && len(st.TailscaleIPs) > 0 {
 		printf("\nWeb interface now running at %s:%d", st.TailscaleIPs[0], web.ListenPort)
 	}
 
 	return nil
 }
 
+// readPrefsFile reads and validates the prefs document at path, or stdin if
+// path is "-". See ipn.ParsePrefsJSON for the format.
+func readPrefsFile(path string) (*ipn.MaskedPrefs, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mp, err := ipn.ParsePrefsJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mp, nil
+}
+
 // calcAdvertiseRoutesForSet returns the new value for Prefs.AdvertiseRoutes based on the
 // current value, the flags passed to "tailscale set".
 // advertiseExitNodeSet is whether the --advertise-exit-node flag was set.
@@ -4,8 +4,12 @@
 package cli
 
 import (
+	"errors"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"tailscale.com/ipn"
@@ -129,3 +133,34 @@ func TestCalcAdvertiseRoutesForSet(t *testing.T) {
 		})
 	}
 }
+
+func TestReadPrefsFile(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	if err := os.WriteFile(good, []byte(`{"ShieldsUp": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mp, err := readPrefsFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mp.ShieldsUpSet || !mp.ShieldsUp {
+		t.Errorf("got %v; want ShieldsUp set", mp.Pretty())
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"ShieldsUp": 1, "Bogus": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readPrefsFile(bad)
+	var ve *ipn.PrefsValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("err = %v; want PrefsValidationError", err)
+	}
+	if len(ve.Errors) != 2 {
+		t.Errorf("got %d field errors, want 2: %v", len(ve.Errors), err)
+	}
+	if !strings.HasPrefix(err.Error(), bad+": ") {
+		t.Errorf("error %q doesn't name the file", err)
+	}
+}
@@ -17,6 +17,7 @@
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/toqueteos/webbrowser"
@@ -29,7 +30,7 @@
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "tailscale status [--active] [--web] [--json]",
+	ShortUsage: "tailscale status [--active] [--watch] [--web] [--json]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
@@ -56,6 +57,7 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.BoolVar(&statusArgs.watch, "watch", false, "keep running and redraw the status whenever it changes (not applicable to web or JSON mode)")
 		return fs
 	})(),
 }
@@ -68,6 +70,7 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	watch   bool   // in CLI mode, redraw the status as it changes
 }
 
 func runStatus(ctx context.Context, args []string) error {
@@ -78,6 +81,12 @@ func runStatus(ctx context.Context, args []string) error {
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
 	}
+	if statusArgs.watch {
+		if statusArgs.json || statusArgs.web {
+			return errors.New("--watch can't be used with --json or --web")
+		}
+		return watchStatus(ctx, getStatus)
+	}
 	st, err := getStatus(ctx)
 	if err != nil {
 		return fixTailscaledConnectError(err)
@@ -149,6 +158,15 @@ func runStatus(ctx context.Context, args []string) error {
 		os.Exit(1)
 	}
 
+	Stdout.Write(formatStatus(st))
+	printFunnelStatus(ctx)
+	return nil
+}
+
+// formatStatus returns the text form of st that 'tailscale status' prints,
+// with the peers selected by statusArgs. st must be in the Running or
+// Starting state.
+func formatStatus(st *ipnstate.Status) []byte {
 	var buf bytes.Buffer
 	f := func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }
 	printPS := func(ps *ipnstate.PeerStatus) {
@@ -163,6 +181,9 @@ func runStatus(ctx context.Context, args []string) error {
 		var offline string
 		if !ps.Online {
 			offline = "; offline"
+			if !ps.LastSeen.IsZero() {
+				offline += ", last seen " + fmtAgo(time.Since(ps.LastSeen))
+			}
 		}
 		if !ps.Active {
 			if ps.ExitNode {
@@ -172,7 +193,7 @@ func runStatus(ctx context.Context, args []string) error {
 			} else if anyTraffic {
 				f("idle" + offline)
 			} else if !ps.Online {
-				f("offline")
+				f("%s", strings.TrimPrefix(offline, "; "))
 			} else {
 				f("-")
 			}
@@ -188,13 +209,18 @@ func runStatus(ctx context.Context, args []string) error {
 			} else if ps.CurAddr != "" {
 				f("direct %s", ps.CurAddr)
 			}
-			if !ps.Online {
-				f("; offline")
-			}
+			f("%s", offline)
 		}
 		if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if ps.Expired {
+			f("; key expired")
+		} else if ps.KeyExpiry != nil {
+			if left := time.Until(*ps.KeyExpiry); left < keyExpirySoon {
+				f("; key expires in %s", fmtExpiresIn(left))
+			}
+		}
 		f("\n")
 	}
 
@@ -226,17 +252,95 @@ func runStatus(ctx context.Context, args []string) error {
 			printPS(ps)
 		}
 	}
-	Stdout.Write(buf.Bytes())
 	if locBasedExitNode {
-		outln()
-		printf("# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
+		f("\n# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
 	}
 	if len(st.Health) > 0 {
-		outln()
-		printHealth()
+		f("\n# Health check:\n")
+		for _, m := range st.Health {
+			f("#     - %s\n", m)
+		}
+	}
+	if len(st.DisabledAddrFamilies) > 0 {
+		f("\n# Not using %s for peer-to-peer or DERP connections; it was disabled when starting tailscaled.\n", strings.Join(st.DisabledAddrFamilies, " or "))
+	}
+	return buf.Bytes()
+}
+
+// watchStatus prints the status as runStatus does, then redraws it each
+// time tailscaled reports a change to the netmap, its state or its
+// connections to peers, until ctx is done. It redraws at most once per
+// statusWatchInterval.
+func watchStatus(ctx context.Context, getStatus func(context.Context) (*ipnstate.Status, error)) error {
+	watcher, err := localClient.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyWatchEngineUpdates)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	defer watcher.Close()
+
+	var lastDraw time.Time
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n.State == nil && n.NetMap == nil && n.Engine == nil {
+			continue
+		}
+		if wait := statusWatchInterval - time.Since(lastDraw); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		st, err := getStatus(ctx)
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		lastDraw = time.Now()
+		var out []byte
+		if description, ok := isRunningOrStarting(st); ok {
+			out = formatStatus(st)
+		} else {
+			out = []byte(description + "\n")
+		}
+		// Clear the terminal and move to its top left corner.
+		printf("\x1b[H\x1b[2J# %s\n\n%s", lastDraw.Format(time.TimeOnly), out)
+	}
+}
+
+// statusWatchInterval is the least time between redraws of
+// 'tailscale status --watch'.
+const statusWatchInterval = time.Second
+
+// fmtAgo formats how long ago something happened, rounded down to days,
+// hours or minutes.
+func fmtAgo(d time.Duration) string {
+	if d < time.Minute {
+		return "just now"
+	}
+	return fmtExpiresIn(d) + " ago"
+}
+
+// keyExpirySoon is how soon a node key must expire for status to say so. It
+// matches tailscaled's largest default key expiry warning threshold.
+const keyExpirySoon = 7 * 24 * time.Hour
+
+// fmtExpiresIn formats the time left until a node key expires, rounded
+// down to days, hours or minutes.
+func fmtExpiresIn(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", max(d/time.Minute, 0))
 	}
-	printFunnelStatus(ctx)
-	return nil
 }
 
 // printFunnelStatus prints the status of the funnel, if it's running.
@@ -252,6 +356,12 @@ func printFunnelStatus(ctx context.Context) {
 	if !sc.IsFunnelOn() {
 		return
 	}
+	// The ports Funnel may use are only known with the self node's
+	// capabilities; if they can't be fetched, don't annotate the list.
+	var self *ipnstate.PeerStatus
+	if st, err := localClient.StatusWithoutPeers(ctx); err == nil {
+		self = st.Self
+	}
 	outln()
 	printf("# Funnel on:\n")
 	for hp, on := range sc.AllowFunnel {
@@ -269,8 +379,18 @@ func printFunnelStatus(ctx context.Context) {
 		if isTCP || p != 443 {
 			url += ":" + portStr
 		}
+		if self != nil {
+			if err := ipn.CheckFunnelPort(uint16(p), self); err != nil {
+				url += " (not reachable; " + err.Error() + ")"
+			}
+		}
 		printf("#     - %s\n", url)
 	}
+	if self != nil {
+		if ports := ipn.AllowedFunnelPorts(self); ports != "" {
+			printf("# Ports allowed for Funnel: %s\n", ports)
+		}
+	}
 	outln()
 }
 
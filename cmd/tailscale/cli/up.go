@@ -17,6 +17,7 @@
 	"os/signal"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -57,9 +58,10 @@
 If flags are specified, the flags must be the complete set of desired
 settings. An error is returned if any setting would be changed as a
 result of an unspecified flag's default value, unless the --reset flag
-is also used. (The flags --auth-key, --force-reauth, and --qr are not
-considered settings that need to be re-specified when modifying
-settings.)
+is also used to reset unspecified settings to their default values, or
+the --keep-unspecified flag to keep their current values. (The flags
+--auth-key, --force-reauth, and --qr are not considered settings that
+need to be re-specified when modifying settings.)
 `),
 	FlagSet: upFlagSet,
 	Exec: func(ctx context.Context, args []string) error {
@@ -100,8 +102,11 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	// of here. Setting preferences via "tailscale up" is deprecated.
 	upf.BoolVar(&upArgs.qr, "qr", false, "show QR code for login URLs")
 	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey`)
+	upf.StringVar(&upArgs.authKeyOrFile, "authkey", "", hidden+"alias for --auth-key")
+	upf.BoolVar(&upArgs.ephemeral, "ephemeral", false, "register as an ephemeral node, which is removed from the tailnet once it goes offline; its state isn't saved and it logs out when tailscaled stops (for containers and CI runners)")
 
 	upf.StringVar(&upArgs.server, "login-server", ipn.DefaultControlURL, "base URL of control server")
+	upf.StringVar(&upArgs.serverFallbacks, "login-server-fallbacks", "", "comma-separated base URLs of other instances of the --login-server control server, in order of priority, to fail over to when it's unreachable")
 	upf.BoolVar(&upArgs.acceptRoutes, "accept-routes", acceptRouteDefault(goos), "accept routes advertised by other Tailscale nodes")
 	upf.BoolVar(&upArgs.acceptDNS, "accept-dns", true, "accept DNS configuration from the admin panel")
 	upf.Var(notFalseVar{}, "host-routes", hidden+"install host routes to other Tailscale nodes (must be true as of Tailscale 1.67+)")
@@ -136,6 +141,7 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 		// Some flags are only for "up", not "login".
 		upf.BoolVar(&upArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
 		upf.BoolVar(&upArgs.reset, "reset", false, "reset unspecified settings to their default values")
+		upf.BoolVar(&upArgs.keepUnspecified, "keep-unspecified", false, "keep the current values of unspecified settings")
 		upf.BoolVar(&upArgs.forceReauth, "force-reauth", false, "force reauthentication")
 		registerAcceptRiskFlag(upf, &upArgs.acceptedRisks)
 	}
@@ -165,7 +171,9 @@ func defaultNetfilterMode() string {
 type upArgsT struct {
 	qr                     bool
 	reset                  bool
+	keepUnspecified        bool
 	server                 string
+	serverFallbacks        string
 	acceptRoutes           bool
 	acceptDNS              bool
 	exitNodeIP             string
@@ -183,6 +191,7 @@ type upArgsT struct {
 	statefulFiltering      bool
 	netfilterMode          string
 	authKeyOrFile          string // "secret" or "file:/path/to/secret"
+	ephemeral              bool
 	hostname               string
 	opUser                 string
 	json                   bool
@@ -253,6 +262,16 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 		return nil, fmt.Errorf("--exit-node-allow-lan-access can only be used with --exit-node")
 	}
 
+	var serverFallbacks []string
+	if upArgs.serverFallbacks != "" {
+		serverFallbacks = strings.Split(upArgs.serverFallbacks, ",")
+		for _, u := range serverFallbacks {
+			if err := ipn.CheckControlURL(u); err != nil {
+				return nil, fmt.Errorf("--login-server-fallbacks: %w", err)
+			}
+		}
+	}
+
 	var tags []string
 	if upArgs.advertiseTags != "" {
 		tags = strings.Split(upArgs.advertiseTags, ",")
@@ -270,6 +289,7 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 
 	prefs := ipn.NewPrefs()
 	prefs.ControlURL = upArgs.server
+	prefs.ControlURLFallbacks = serverFallbacks
 	prefs.WantRunning = true
 	prefs.RouteAll = upArgs.acceptRoutes
 	if distro.Get() == distro.Synology {
@@ -298,6 +318,7 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	prefs.ForceDaemon = upArgs.forceDaemon
 	prefs.OperatorUser = upArgs.opUser
 	prefs.ProfileName = upArgs.profileName
+	prefs.Ephemeral = upArgs.ephemeral
 	prefs.AppConnector.Advertise = upArgs.advertiseConnector
 
 	if goos == "linux" {
@@ -353,6 +374,14 @@ func netfilterModeFromFlag(v string) (_ preftype.NetfilterMode, warning string,
 // transition to running from a previously-logged-in but down state,
 // without changing any settings.
 func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, justEditMP *ipn.MaskedPrefs, err error) {
+	if env.upArgs.keepUnspecified {
+		if env.upArgs.reset {
+			return false, nil, errors.New("can't use both --reset and --keep-unspecified")
+		}
+		if err := keepUnspecifiedPrefs(prefs, curPrefs, env); err != nil {
+			return false, nil, err
+		}
+	}
 	if !env.upArgs.reset {
 		applyImplicitPrefs(prefs, curPrefs, env)
 
@@ -382,6 +411,9 @@ func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, jus
 
 	tagsChanged := !reflect.DeepEqual(curPrefs.AdvertiseTags, prefs.AdvertiseTags)
 
+	// Like the control URL, its fallbacks only take effect on Start.
+	controlURLFallbacksChanged := !slices.Equal(curPrefs.ControlURLFallbacks, prefs.ControlURLFallbacks)
+
 	simpleUp = env.flagSet.NFlag() == 0 &&
 		curPrefs.Persist != nil &&
 		curPrefs.Persist.UserProfile.LoginName != "" &&
@@ -391,6 +423,7 @@ func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, jus
 		!env.upArgs.forceReauth &&
 		env.upArgs.authKeyOrFile == "" &&
 		!controlURLChanged &&
+		!controlURLFallbacksChanged &&
 		!tagsChanged
 
 	if justEdit {
@@ -752,6 +785,7 @@ func init() {
 	addPrefFlagMapping("advertise-tags", "AdvertiseTags")
 	addPrefFlagMapping("hostname", "Hostname")
 	addPrefFlagMapping("login-server", "ControlURL")
+	addPrefFlagMapping("login-server-fallbacks", "ControlURLFallbacks")
 	addPrefFlagMapping("netfilter-mode", "NetfilterMode")
 	addPrefFlagMapping("shields-up", "ShieldsUp")
 	addPrefFlagMapping("snat-subnet-routes", "NoSNAT")
@@ -766,6 +800,13 @@ func init() {
 	addPrefFlagMapping("auto-update", "AutoUpdate.Apply")
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("posture-checking", "PostureChecking")
+	addPrefFlagMapping("auto-key-renewal", "NoAutoKeyRenewal")
+	addPrefFlagMapping("ephemeral", "Ephemeral")
+	addPrefFlagMapping("derp-plaintext-fallback", "DERPPlaintextFallback")
+	addPrefFlagMapping("listen-port", "ListenPort")
+	addPrefFlagMapping("dnssec", "ValidateDNSSEC")
+	addPrefFlagMapping("dns-aliases", "AdvertiseDNSAliases")
+	addPrefFlagMapping("block-peers", "BlockedPeers")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -788,7 +829,8 @@ func addPrefFlagMapping(flagName string, prefNames ...string) {
 // correspond to an ipn.Pref.
 func preflessFlag(flagName string) bool {
 	switch flagName {
-	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "host-routes":
+	case "auth-key", "authkey", "force-reauth", "reset", "keep-unspecified", "qr", "json", "timeout", "accept-risk", "host-routes",
+		"from-file", "dry-run":
 		return true
 	}
 	return false
@@ -812,9 +854,10 @@ func updateMaskedPrefsFromUpOrSetFlag(mp *ipn.MaskedPrefs, flagName string) {
 }
 
 const accidentalUpPrefix = "Error: changing settings via 'tailscale up' requires mentioning all\n" +
-	"non-default flags. To proceed, either re-run your command with --reset or\n" +
-	"use the command below to explicitly mention the current value of\n" +
-	"all non-default settings:\n\n" +
+	"non-default flags. To proceed, either re-run your command with --reset to\n" +
+	"reset unspecified settings, with --keep-unspecified to keep them, or use\n" +
+	"the command below to explicitly mention the current value of all\n" +
+	"non-default settings:\n\n" +
 	"\ttailscale up"
 
 // upCheckEnv are extra parameters describing the environment as
@@ -935,6 +978,65 @@ type isBool interface {
 	return errors.New(sb.String())
 }
 
+// keepUnspecifiedPrefs mutates prefs, as parsed from flags, to keep the
+// current value in curPrefs of each setting whose flag wasn't specified,
+// for --keep-unspecified.
+func keepUnspecifiedPrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) error {
+	flagIsSet := map[string]bool{}
+	env.flagSet.Visit(func(f *flag.Flag) {
+		flagIsSet[f.Name] = true
+	})
+
+	// AdvertiseRoutes is set by both --advertise-routes and
+	// --advertise-exit-node, either of which may be specified alone.
+	routesSet, exitNodeSet := flagIsSet["advertise-routes"], flagIsSet["advertise-exit-node"]
+	if routesSet != exitNodeSet {
+		routes, advertiseExitNode := env.upArgs.advertiseRoutes, env.upArgs.advertiseDefaultRoute
+		if !routesSet {
+			var sb strings.Builder
+			for i, r := range withoutExitNodes(curPrefs.AdvertiseRoutes) {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(r.String())
+			}
+			routes = sb.String()
+		} else {
+			advertiseExitNode = hasExitNodeRoutes(curPrefs.AdvertiseRoutes)
+		}
+		var err error
+		prefs.AdvertiseRoutes, err = netutil.CalcAdvertiseRoutes(routes, advertiseExitNode)
+		if err != nil {
+			return err
+		}
+	}
+
+	// specifiedPrefs are the prefs set by specified flags, which may
+	// share prefs with unspecified ones.
+	specifiedPrefs := map[string]bool{}
+	for flagName := range flagIsSet {
+		for _, pref := range prefsOfFlag[flagName] {
+			specifiedPrefs[pref] = true
+		}
+	}
+	env.flagSet.VisitAll(func(f *flag.Flag) {
+		if flagIsSet[f.Name] || preflessFlag(f.Name) {
+			return
+		}
+		for _, pref := range prefsOfFlag[f.Name] {
+			if specifiedPrefs[pref] {
+				continue
+			}
+			dst, src := reflect.ValueOf(prefs).Elem(), reflect.ValueOf(curPrefs).Elem()
+			for _, name := range strings.Split(pref, ".") {
+				dst, src = dst.FieldByName(name), src.FieldByName(name)
+			}
+			dst.Set(src)
+		}
+	})
+	return nil
+}
+
 // applyImplicitPrefs mutates prefs to add implicit preferences for the user operator.
 // If the operator flag is passed no action is taken, otherwise this only needs to be set if it doesn't
 // match the current user.
@@ -997,6 +1099,8 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.RunWebClient)
 		case "login-server":
 			set(prefs.ControlURL)
+		case "login-server-fallbacks":
+			set(strings.Join(prefs.ControlURLFallbacks, ","))
 		case "accept-routes":
 			set(prefs.RouteAll)
 		case "accept-dns":
@@ -1042,6 +1146,8 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.NetfilterMode.String())
 		case "unattended":
 			set(prefs.ForceDaemon)
+		case "ephemeral":
+			set(prefs.Ephemeral)
 		}
 	})
 	return ret
@@ -8,9 +8,11 @@
 	"encoding/json"
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/clientupdate"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/version"
 )
@@ -21,7 +23,7 @@
 	ShortHelp:  "Print Tailscale version",
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("version")
-		fs.BoolVar(&versionArgs.daemon, "daemon", false, "also print local node's daemon version")
+		fs.BoolVar(&versionArgs.daemon, "daemon", false, "also print local node's daemon version, build tags and features in use")
 		fs.BoolVar(&versionArgs.json, "json", false, "output in JSON format")
 		fs.BoolVar(&versionArgs.upstream, "upstream", false, "fetch and print the latest upstream release version from pkgs.tailscale.com")
 		return fs
@@ -41,12 +43,16 @@ func runVersion(ctx context.Context, args []string) error {
 	}
 	var err error
 	var st *ipnstate.Status
+	var di *ipn.DaemonInfo
 
 	if versionArgs.daemon {
 		st, err = localClient.StatusWithoutPeers(ctx)
 		if err != nil {
 			return err
 		}
+		// Older daemons don't have the daemon-info endpoint, so only print
+		// their version in that case.
+		di, _ = localClient.DaemonInfo(ctx)
 	}
 
 	var upstreamVer string
@@ -64,10 +70,12 @@ func runVersion(ctx context.Context, args []string) error {
 		}
 		out := struct {
 			version.Meta
-			Upstream string `json:"upstream,omitempty"`
+			Upstream string          `json:"upstream,omitempty"`
+			Daemon   *ipn.DaemonInfo `json:"daemon,omitempty"`
 		}{
 			Meta:     m,
 			Upstream: upstreamVer,
+			Daemon:   di,
 		}
 		e := json.NewEncoder(Stdout)
 		e.SetIndent("", "\t")
@@ -83,6 +91,19 @@ func runVersion(ctx context.Context, args []string) error {
 	}
 	printf("Client: %s\n", version.String())
 	printf("Daemon: %s\n", st.Version)
+	if di != nil {
+		printf("  go: %s %s/%s\n", di.GoVersion, di.GOOS, di.GOARCH)
+		if len(di.BuildTags) > 0 {
+			printf("  build tags: %s\n", strings.Join(di.BuildTags, ","))
+		}
+		printf("  netstack: %s\n", di.Netstack)
+		if di.Router != "" {
+			printf("  router: %s\n", di.Router)
+		}
+		if di.DNS != "" {
+			printf("  DNS: %s\n", di.DNS)
+		}
+	}
 	if versionArgs.upstream {
 		printf("Upstream: %s\n", upstreamVer)
 	}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.21 && !ts_omit_netstack
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"tailscale.com/feature"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tsd"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/netstack"
+)
+
+func init() {
+	feature.Register("netstack")
+}
+
+// newNetstack creates the gVisor netstack, registers it with sys and,
+// if onlyNetstack, makes dialer use it for tailnet connections. It
+// returns the func that starts it once the LocalBackend exists.
+func newNetstack(logf logger.Logf, sys *tsd.System, onlyNetstack bool, dialer *tsdial.Dialer) (start func(*ipnlocal.LocalBackend) error, _ error) {
+	tfs, _ := sys.DriveForLocal.GetOK()
+	ns, err := netstack.Create(logf,
+		sys.Tun.Get(),
+		sys.Engine.Get(),
+		sys.MagicSock.Get(),
+		sys.Dialer.Get(),
+		sys.DNSManager.Get(),
+		sys.ProxyMapper(),
+		tfs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if args.netstackTCPTuning != "" {
+		var tt netstack.TCPTuning
+		if err := json.Unmarshal([]byte(args.netstackTCPTuning), &tt); err != nil {
+			return nil, fmt.Errorf("invalid --netstack-tcp-tuning: %w", err)
+		}
+		if err := ns.SetTCPTuning(tt); err != nil {
+			return nil, fmt.Errorf("invalid --netstack-tcp-tuning: %w", err)
+		}
+	}
+	// Only register debug info if we have a debug mux
+	if debugMux != nil {
+		expvar.Publish("netstack", ns.ExpVar())
+	}
+	sys.Set(ns)
+	if debugMux != nil {
+		debugMux.HandleFunc("/debug/netstack/tcp", ns.ServeHTTPDebugTCP)
+	}
+	ns.ProcessLocalIPs = onlyNetstack
+	ns.ProcessSubnets = onlyNetstack || handleSubnetsInNetstack()
+
+	if onlyNetstack {
+		e := sys.Engine.Get()
+		dialer.UseNetstackForIP = func(ip netip.Addr) bool {
+			_, ok := e.PeerForIP(ip)
+			return ok
+		}
+		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			// Note: don't just return ns.DialContextTCP or we'll return
+			// *gonet.TCPConn(nil) instead of a nil interface which trips up
+			// callers.
+			tcpConn, err := ns.DialContextTCP(ctx, dst)
+			if err != nil {
+				return nil, err
+			}
+			return tcpConn, nil
+		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			// Note: don't just return ns.DialContextUDP or we'll return
+			// *gonet.UDPConn(nil) instead of a nil interface which trips up
+			// callers.
+			udpConn, err := ns.DialContextUDP(ctx, dst)
+			if err != nil {
+				return nil, err
+			}
+			return udpConn, nil
+		}
+	}
+	return ns.Start, nil
+}
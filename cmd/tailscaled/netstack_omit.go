@@ -0,0 +1,29 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.21 && ts_omit_netstack
+
+package main
+
+import (
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/omit"
+	"tailscale.com/tsd"
+	"tailscale.com/types/logger"
+)
+
+// newNetstack fails if userspace networking was asked for, as netstack
+// isn't linked in. Otherwise subnet routes are left to the OS.
+func newNetstack(logf logger.Logf, sys *tsd.System, onlyNetstack bool, dialer *tsdial.Dialer) (start func(*ipnlocal.LocalBackend) error, _ error) {
+	if onlyNetstack {
+		return nil, omit.Err
+	}
+	if args.netstackTCPTuning != "" {
+		logf("netstack: ignoring --netstack-tcp-tuning: %v", omit.Err)
+	}
+	if handleSubnetsInNetstack() {
+		logf("netstack: not handling subnet routes: %v", omit.Err)
+	}
+	return func(*ipnlocal.LocalBackend) error { return nil }, nil
+}
@@ -11,16 +11,16 @@
 package main // import "tailscale.com/cmd/tailscaled"
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
-	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/http/pprof"
-	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -35,11 +35,14 @@
 	"tailscale.com/control/controlclient"
 	"tailscale.com/drive/driveimpl"
 	"tailscale.com/envknob"
+	"tailscale.com/feature"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnserver"
 	"tailscale.com/ipn/store"
+	"tailscale.com/ipn/store/encstore"
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
 	"tailscale.com/net/dns"
@@ -54,6 +57,7 @@
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tsweb/varz"
 	"tailscale.com/types/flagtype"
@@ -65,7 +69,6 @@
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
-	"tailscale.com/wgengine/netstack"
 	"tailscale.com/wgengine/router"
 )
 
@@ -123,12 +126,19 @@ func defaultPort() uint16 {
 	port           uint16
 	statepath      string
 	statedir       string
+	encryptState   string // empty, or a KeyProtector spec for encstore.NewProtector
 	socketpath     string
 	birdSocketPath string
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
 	disableLogs    bool
+	userMode       bool   // run unprivileged as a per-user daemon; see applyUserMode
+	extraDERPMap   string // path of a JSON DERP map fragment to merge with control's
+	disableIPv4    bool
+	disableIPv6    bool
+
+	netstackTCPTuning string // empty, or JSON netstack.TCPTuning
 }
 
 var (
@@ -163,13 +173,19 @@ func main() {
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' (or 'kube://<secret-name>') to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.encryptState, "encrypt-state", "", "if non-empty, encrypt the state at rest with a key protected by this keystore: 'dpapi' (Windows), 'keychain' (macOS), 'tpm' (Linux, needs tpm2-tools), 'systemd-creds' (Linux), 'passphrase:<file>' or 'command:<program>' (a KMS or secrets manager hook, run as '<program> seal|unseal')")
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2)")
+	flag.StringVar(&args.extraDERPMap, "extra-derp-map", "", "path of a JSON DERP map whose Regions are added to the DERP map from the coordination server; regions with the same ID as one of the server's are ignored")
+	flag.BoolVar(&args.userMode, "user", false, "run as an unprivileged per-user daemon with userspace networking, per-user state and a socket only the current user can reach")
+	flag.BoolVar(&args.disableIPv4, "disable-ipv4", false, "don't use IPv4 for peer-to-peer or DERP connections, for networks where IPv4 is broken")
+	flag.BoolVar(&args.disableIPv6, "disable-ipv6", false, "don't use IPv6 for peer-to-peer or DERP connections, for networks where IPv6 is broken")
+	flag.StringVar(&args.netstackTCPTuning, "netstack-tcp-tuning", "", `if non-empty, JSON TCP tuning for netstack, overriding TS_NETSTACK_TCP_* (e.g. '{"RXBufMaxSize":8388608,"CongestionControl":"cubic"}')`)
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -208,6 +224,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	if args.userMode {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if err := applyUserMode(os.Getuid(), set); err != nil {
+			log.SetFlags(0)
+			log.Fatal(err)
+		}
+	}
+
 	if runtime.GOOS == "darwin" && os.Getuid() != 0 && !strings.Contains(args.tunname, "userspace-networking") && !args.cleanUp {
 		log.SetFlags(0)
 		log.Fatalf("tailscaled requires root; use sudo tailscaled (or use --tun=userspace-networking)")
@@ -223,6 +248,11 @@ func main() {
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
 
+	if args.disableIPv4 && args.disableIPv6 {
+		log.SetFlags(0)
+		log.Fatalf("--disable-ipv4 and --disable-ipv6 can't both be set")
+	}
+
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
 	if args.statepath == "" && args.statedir == "" {
@@ -299,6 +329,46 @@ type serverOptions struct {
 	LoginFlags controlclient.LoginFlags
 }
 
+// applyUserMode adjusts args for running tailscaled as an unprivileged,
+// per-user daemon (--user): networking is always done in userspace, and
+// the socket, state directory and port default to per-user values unless
+// set explicitly. uid is the user tailscaled runs as, and set the names of
+// the flags set on the command line.
+func applyUserMode(uid int, set map[string]bool) error {
+	if paths.UserTailscaledSocket() == "" {
+		return fmt.Errorf("--user is not supported on %s", runtime.GOOS)
+	}
+	if uid == 0 {
+		return errors.New("--user is for running tailscaled as a regular user, not as root")
+	}
+	if set["tun"] && args.tunname != "userspace-networking" {
+		return fmt.Errorf("--user requires --tun=userspace-networking; got --tun=%q", args.tunname)
+	}
+	args.tunname = "userspace-networking"
+	if !set["socket"] {
+		args.socketpath = paths.UserTailscaledSocket()
+	}
+	if !set["state"] && !set["statedir"] {
+		args.statedir = paths.UserTailscaledStateDir()
+	}
+	if !set["port"] {
+		// Several users may run their own tailscaled on the same
+		// host, so don't all fight over the default port.
+		args.port = 0
+	}
+	return nil
+}
+
+// cleanupSentinelPath returns the path of the file in which the engine
+// records the system changes it has made, or the empty string if there's
+// no state directory to keep it in.
+func cleanupSentinelPath() string {
+	if dir := ipnServerOpts().VarRoot; dir != "" {
+		return filepath.Join(dir, "tailscaled.sentinel")
+	}
+	return ""
+}
+
 func ipnServerOpts() (o serverOptions) {
 	goos := envknob.GOOS()
 
@@ -387,6 +457,7 @@ func run() (err error) {
 		logf = logger.RusagePrefixLog(logf)
 	}
 	logf = logger.RateLimitedFn(logf, 5*time.Second, 5, 100)
+	logf("optional features: %s", strings.Join(feature.Registered(), ", "))
 
 	if envknob.Bool("TS_PLEASE_PANIC") {
 		panic("TS_PLEASE_PANIC asked us to panic")
@@ -394,8 +465,10 @@ func run() (err error) {
 	// Always clean up, even if we're going to run the server. This covers cases
 	// such as when a system was rebooted without shutting down, or tailscaled
 	// crashed, and would for example restore system DNS configuration.
-	dns.CleanUp(logf, netMon, sys.HealthTracker(), args.tunname)
-	router.CleanUp(logf, netMon, args.tunname)
+	// A per-user daemon never changes system state, so has nothing to clean.
+	if !args.userMode {
+		cleanUpSystemState(logf, netMon, sys.HealthTracker())
+	}
 	// If the cleanUp flag was passed, then exit.
 	if args.cleanUp {
 		return nil
@@ -404,6 +477,11 @@ func run() (err error) {
 	if args.statepath == "" && args.statedir == "" {
 		log.Fatalf("--statedir (or at least --state) is required")
 	}
+	if args.userMode {
+		if err := paths.MkStateDir(filepath.Dir(args.socketpath)); err != nil {
+			return fmt.Errorf("creating socket directory: %w", err)
+		}
+	}
 	if err := trySynologyMigration(statePathOrDefault()); err != nil {
 		log.Printf("error in synology migration: %v", err)
 	}
@@ -417,6 +495,44 @@ func run() (err error) {
 	return startIPNServer(context.Background(), logf, pol.PublicID, sys)
 }
 
+// cleanUpSystemState undoes the routing and DNS changes a previous
+// tailscaled may have left behind. It only removes the cleanup sentinel
+// once they're all undone, so that the next start retries otherwise.
+func cleanUpSystemState(logf logger.Logf, netMon *netmon.Monitor, ht *health.Tracker) {
+	var errs []error
+	cleanUp := func(ifName string) {
+		if err := dns.CleanUp(logf, netMon, ht, ifName); err != nil {
+			logf("cleaning up DNS on %q: %v", ifName, err)
+			errs = append(errs, err)
+		}
+		if err := router.CleanUp(logf, netMon, ifName); err != nil {
+			logf("cleaning up routes on %q: %v", ifName, err)
+			errs = append(errs, err)
+		}
+	}
+
+	// If the previous run's cleanup sentinel says it left changes behind on
+	// differently-named interfaces, clean those up too.
+	sentinelPath := cleanupSentinelPath()
+	if st, err := wgengine.ReadCleanupState(sentinelPath); err == nil && st.Dirty() {
+		logf("previous tailscaled (pid %d) did not shut down cleanly on %q (routes=%v, dns=%v, stale=%q); cleaning up", st.PID, st.Interface, st.Routes, st.DNS, st.StaleInterface)
+		for _, ifName := range []string{st.Interface, st.StaleInterface} {
+			if ifName != "" && ifName != args.tunname {
+				cleanUp(ifName)
+			}
+		}
+	}
+	cleanUp(args.tunname)
+	if sentinelPath == "" {
+		return
+	}
+	if len(errs) > 0 {
+		logf("keeping cleanup sentinel %s to retry on the next start", sentinelPath)
+		return
+	}
+	os.Remove(sentinelPath)
+}
+
 var sigPipe os.Signal // set by sigpipe.go
 
 func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System) error {
@@ -461,6 +577,7 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 	}()
 
 	srv := ipnserver.New(logf, logID, sys.NetMon.Get())
+	srv.SetReadiness(sys.Readiness())
 	if debugMux != nil {
 		debugMux.HandleFunc("/debug/ipn", srv.ServeHTMLStatus)
 	}
@@ -522,6 +639,26 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	dialer := &tsdial.Dialer{Logf: logf} // mutated below (before used)
 	sys.Set(dialer)
 
+	// Subsystems start in the order of the dependency graph that
+	// tsd.Readiness reports: the state store, then the engine (with
+	// magicsock), then LocalBackend, which brings up control, DNS and the
+	// router.
+	store, err := store.New(logf, statePathOrDefault())
+	if err != nil {
+		return nil, fmt.Errorf("store.New: %w", err)
+	}
+	if args.encryptState != "" {
+		p, err := encstore.NewProtector(args.encryptState)
+		if err != nil {
+			return nil, err
+		}
+		store, err = encstore.New(logf, store, p)
+		if err != nil {
+			return nil, fmt.Errorf("encstore.New: %w", err)
+		}
+	}
+	sys.Set(store)
+
 	onlyNetstack, err := createEngine(logf, sys)
 	if err != nil {
 		return nil, fmt.Errorf("createEngine: %w", err)
@@ -533,41 +670,10 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		go runDebugServer(debugMux, args.debug)
 	}
 
-	ns, err := newNetstack(logf, sys)
+	startNetstack, err := newNetstack(logf, sys, onlyNetstack, dialer)
 	if err != nil {
 		return nil, fmt.Errorf("newNetstack: %w", err)
 	}
-	sys.Set(ns)
-	ns.ProcessLocalIPs = onlyNetstack
-	ns.ProcessSubnets = onlyNetstack || handleSubnetsInNetstack()
-
-	if onlyNetstack {
-		e := sys.Engine.Get()
-		dialer.UseNetstackForIP = func(ip netip.Addr) bool {
-			_, ok := e.PeerForIP(ip)
-			return ok
-		}
-		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
-			// Note: don't just return ns.DialContextTCP or we'll return
-			// *gonet.TCPConn(nil) instead of a nil interface which trips up
-			// callers.
-			tcpConn, err := ns.DialContextTCP(ctx, dst)
-			if err != nil {
-				return nil, err
-			}
-			return tcpConn, nil
-		}
-		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
-			// Note: don't just return ns.DialContextUDP or we'll return
-			// *gonet.UDPConn(nil) instead of a nil interface which trips up
-			// callers.
-			udpConn, err := ns.DialContextUDP(ctx, dst)
-			if err != nil {
-				return nil, err
-			}
-			return udpConn, nil
-		}
-	}
 	if socksListener != nil || httpProxyListener != nil {
 		var addrs []string
 		if httpProxyListener != nil {
@@ -592,12 +698,6 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 
 	opts := ipnServerOpts()
 
-	store, err := store.New(logf, statePathOrDefault())
-	if err != nil {
-		return nil, fmt.Errorf("store.New: %w", err)
-	}
-	sys.Set(store)
-
 	if w, ok := sys.Tun.GetOK(); ok {
 		w.Start()
 	}
@@ -609,6 +709,16 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	lb.SetVarRoot(opts.VarRoot)
 	if logPol != nil {
 		lb.SetLogFlusher(logPol.Logtail.StartFlush)
+		lb.SetLogSnapshotter(logPol.Logtail.SnapshotBuffer)
+	}
+	if args.extraDERPMap != "" {
+		dm, err := readExtraDERPMap(args.extraDERPMap)
+		if err != nil {
+			return nil, err
+		}
+		if err := lb.SetExtraDERPMap(dm); err != nil {
+			return nil, fmt.Errorf("--extra-derp-map: %w", err)
+		}
 	}
 	if root := lb.TailscaleVarRoot(); root != "" {
 		dnsfallback.SetCachePath(filepath.Join(root, "derpmap.cached.json"), logf)
@@ -618,7 +728,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		UseSocketOnly: args.socketpath != paths.DefaultTailscaledSocket(),
 	})
 	configureTaildrop(logf, lb)
-	if err := ns.Start(lb); err != nil {
+	if err := startNetstack(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
 	return lb, nil
@@ -671,12 +781,16 @@ func handleSubnetsInNetstack() bool {
 func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack bool, err error) {
 	conf := wgengine.Config{
 		ListenPort:    args.port,
+		DisableIPv4:   args.disableIPv4,
+		DisableIPv6:   args.disableIPv6,
 		NetMon:        sys.NetMon.Get(),
 		HealthTracker: sys.HealthTracker(),
 		Dialer:        sys.Dialer.Get(),
 		SetSubsystem:  sys.Set,
 		ControlKnobs:  sys.ControlKnobs(),
 		DriveForLocal: driveimpl.NewFileSystemForLocal(logf),
+
+		CleanupSentinelPath: cleanupSentinelPath(),
 	}
 
 	onlyNetstack = name == "userspace-networking"
@@ -777,27 +891,6 @@ func runDebugServer(mux *http.ServeMux, addr string) {
 	}
 }
 
-func newNetstack(logf logger.Logf, sys *tsd.System) (*netstack.Impl, error) {
-	tfs, _ := sys.DriveForLocal.GetOK()
-	ret, err := netstack.Create(logf,
-		sys.Tun.Get(),
-		sys.Engine.Get(),
-		sys.MagicSock.Get(),
-		sys.Dialer.Get(),
-		sys.DNSManager.Get(),
-		sys.ProxyMapper(),
-		tfs,
-	)
-	if err != nil {
-		return nil, err
-	}
-	// Only register debug info if we have a debug mux
-	if debugMux != nil {
-		expvar.Publish("netstack", ret.ExpVar())
-	}
-	return ret, nil
-}
-
 // mustStartProxyListeners creates listeners for local SOCKS and HTTP
 // proxies, if the respective addresses are not empty. socksAddr and
 // httpAddr can be the same, in which case socksListener will receive
@@ -895,3 +988,19 @@ func dieOnPipeReadErrorOfFD(fd int) {
 	f.Read(make([]byte, 1))
 	os.Exit(1)
 }
+
+// readExtraDERPMap reads the DERP map fragment for --extra-derp-map from
+// path.
+func readExtraDERPMap(path string) (*tailcfg.DERPMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("--extra-derp-map: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	dm := new(tailcfg.DERPMap)
+	if err := dec.Decode(dm); err != nil {
+		return nil, fmt.Errorf("--extra-derp-map: parsing %s: %w", path, err)
+	}
+	return dm, nil
+}
@@ -6,6 +6,7 @@
 import (
 	"testing"
 
+	"tailscale.com/paths"
 	"tailscale.com/tstest/deptest"
 )
 
@@ -34,3 +35,60 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestApplyUserMode(t *testing.T) {
+	if paths.UserTailscaledSocket() == "" {
+		if err := applyUserMode(1000, nil); err == nil {
+			t.Error("applyUserMode succeeded on a platform without per-user mode; want error")
+		}
+		t.Skip("per-user mode not supported on this platform")
+	}
+	orig := args
+	t.Cleanup(func() { args = orig })
+
+	reset := func() {
+		args = orig
+		args.tunname = "tailscale0"
+		args.socketpath = "/custom.sock"
+		args.statedir = "/custom-state"
+		args.port = 41641
+	}
+
+	reset()
+	if err := applyUserMode(0, nil); err == nil {
+		t.Error("applyUserMode as root succeeded; want error")
+	}
+
+	reset()
+	if err := applyUserMode(1000, map[string]bool{"tun": true}); err == nil {
+		t.Error("applyUserMode with --tun=tailscale0 succeeded; want error")
+	}
+
+	reset()
+	if err := applyUserMode(1000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if args.tunname != "userspace-networking" || args.socketpath != paths.UserTailscaledSocket() ||
+		args.statedir != paths.UserTailscaledStateDir() || args.port != 0 {
+		t.Errorf("with defaults: tun=%q socket=%q statedir=%q port=%d; want per-user values",
+			args.tunname, args.socketpath, args.statedir, args.port)
+	}
+
+	reset()
+	args.tunname = "userspace-networking"
+	if err := applyUserMode(1000, map[string]bool{"tun": true, "socket": true, "statedir": true, "port": true}); err != nil {
+		t.Fatal(err)
+	}
+	if args.socketpath != "/custom.sock" || args.statedir != "/custom-state" || args.port != 41641 {
+		t.Errorf("with flags set: socket=%q statedir=%q port=%d; want them kept",
+			args.socketpath, args.statedir, args.port)
+	}
+
+	reset()
+	if err := applyUserMode(1000, map[string]bool{"state": true}); err != nil {
+		t.Fatal(err)
+	}
+	if args.statedir != "/custom-state" {
+		t.Errorf("with --state set: statedir=%q; want it kept", args.statedir)
+	}
+}
@@ -194,6 +194,12 @@ func NewNoStart(opts Options) (_ *Auto, err error) {
 	c.mapCtx = sockstats.WithSockStats(c.mapCtx, sockstats.LabelControlClientAuto, opts.Logf)
 
 	c.unregisterHealthWatch = opts.HealthTracker.RegisterWatcher(direct.ReportHealthChange)
+
+	// Reconnect to control right away after failing over or back to
+	// another control server URL.
+	direct.mu.Lock()
+	direct.onServerURLChange = c.restartMap
+	direct.mu.Unlock()
 	return c, nil
 
 }
@@ -224,6 +230,7 @@ func (c *Auto) SetPaused(paused bool) {
 //
 // It should only be called for clients created by NewNoStart.
 func (c *Auto) Start() {
+	go c.sendCachedNetmap()
 	go c.authRoutine()
 	go c.mapRoutine()
 	go c.updateRoutine()
@@ -323,9 +330,13 @@ func (c *Auto) authRoutine() {
 
 		c.mu.Lock()
 		c.urlToVisit = goal.url
-		if goal.url != "" {
+		switch {
+		case goal.url != "":
 			c.state = StateURLVisitRequired
-		} else {
+		case goal.flags&LoginRenewKey != 0:
+			// The map poll carries on with the current node key while
+			// we register the new one.
+		default:
 			c.state = StateAuthenticating
 		}
 		c.mu.Unlock()
@@ -340,6 +351,15 @@ func (c *Auto) authRoutine() {
 			url, err = c.direct.TryLogin(ctx, goal.flags)
 			f = "TryLogin"
 		}
+		if errors.Is(err, ErrKeyRenewalNeedsLogin) {
+			// The node key renewal was declined; keep using the current
+			// one, whose map poll never stopped.
+			c.mu.Lock()
+			c.loginGoal = nil
+			c.mu.Unlock()
+			c.sendStatus("authRoutine-renewal-declined", err, "", nil)
+			continue
+		}
 		if err != nil {
 			c.direct.health.SetAuthRoutineInError(err)
 			report(err, f)
@@ -574,6 +594,30 @@ func (c *Auto) SetTKAHead(headHash string) {
 	c.updateControl()
 }
 
+// sendCachedNetmap reports the network map in the netmap cache, if there's
+// one for the current node key, so that the node can reach its peers before
+// its first map poll completes.
+func (c *Auto) sendCachedNetmap() {
+	nm := c.direct.cachedNetmap()
+	if nm == nil {
+		return
+	}
+	c.observerQueue.Add(func() {
+		c.mu.Lock()
+		// A network map from control supersedes the cached one. It's
+		// sent after inMapPoll is set, so it's either already been
+		// reported or will be after this.
+		stale := c.closed || c.inMapPoll
+		state := c.state
+		c.mu.Unlock()
+		if stale {
+			return
+		}
+		c.logf("sendStatus: reporting cached netmap")
+		c.observer.SetControlClientStatus(c, Status{NetMap: nm, state: state})
+	})
+}
+
 // sendStatus can not be called with the c.mu held.
 func (c *Auto) sendStatus(who string, err error, url string, nm *netmap.NetworkMap) {
 	c.mu.Lock()
@@ -626,7 +670,11 @@ func (c *Auto) Login(flags LoginFlags) {
 	c.loginGoal = &LoginGoal{
 		flags: flags,
 	}
-	c.cancelMapCtxLocked()
+	if flags&LoginRenewKey == 0 {
+		// A node key renewal keeps the map poll running with the
+		// current key until the new one is registered.
+		c.cancelMapCtxLocked()
+	}
 	c.cancelAuthCtxLocked()
 }
 
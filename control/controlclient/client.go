@@ -28,6 +28,13 @@
 	//
 	// See https://github.com/tailscale/tailscale/issues/6973.
 	LocalBackendStartKeyOSNeutral
+
+	// LoginRenewKey generates a new node key and registers it in place of
+	// the current one (sending it as the OldNodeKey), without forcing an
+	// interactive login. If the control server requires an interactive
+	// login to accept the new key, the renewal is abandoned and the current
+	// key is kept. The map poll carries on with the current key meanwhile.
+	LoginRenewKey
 )
 
 // Client represents a client connection to the control server.
@@ -62,3 +62,23 @@ func TestStatusEqual(t *testing.T) {
 		}
 	}
 }
+
+func TestAutoLoginRenewKeyKeepsMapPoll(t *testing.T) {
+	c := &Auto{logf: t.Logf}
+	login := func(flags LoginFlags) (mapStopped, authWoken bool) {
+		c.mu.Lock()
+		c.cancelMapCtxLocked()
+		c.cancelAuthCtxLocked()
+		mapCtx, authCtx := c.mapCtx, c.authCtx
+		c.mu.Unlock()
+		c.Login(flags)
+		return mapCtx.Err() != nil, authCtx.Err() != nil
+	}
+
+	if mapStopped, authWoken := login(LoginRenewKey); mapStopped || !authWoken {
+		t.Errorf("Login(LoginRenewKey): map poll stopped = %v, auth routine woken = %v; want false, true", mapStopped, authWoken)
+	}
+	if mapStopped, authWoken := login(LoginInteractive); !mapStopped || !authWoken {
+		t.Errorf("Login(LoginInteractive): map poll stopped = %v, auth routine woken = %v; want true, true", mapStopped, authWoken)
+	}
+}
@@ -66,7 +66,6 @@ type Direct struct {
 	dialer                     *tsdial.Dialer
 	dnsCache                   *dnscache.Resolver
 	controlKnobs               *controlknobs.Knobs // always non-nil
-	serverURL                  string              // URL of the tailcontrol server
 	clock                      tstime.Clock
 	logf                       logger.Logf
 	netMon                     *netmon.Monitor // non-nil
@@ -83,12 +82,21 @@ type Direct struct {
 	onTailnetDefaultAutoUpdate func(bool)                   // or nil
 	panicOnUse                 bool                         // if true, panic if client is used (for testing)
 
-	dialPlan ControlDialPlanner // can be nil
+	dialPlan    ControlDialPlanner // can be nil
+	netmapCache NetmapCache        // can be nil
+
+	// serverFailoverCh asks serverFailoverLoop to fail over to another
+	// control server URL. It's nil if there are no fallback URLs.
+	serverFailoverCh   chan struct{}
+	stopServerFailover context.CancelFunc // or nil
 
 	mu              sync.Mutex        // mutex guards the following fields
 	serverLegacyKey key.MachinePublic // original ("legacy") nacl crypto_box-based public key; only used for signRegisterRequest on Windows now
 	serverNoiseKey  key.MachinePublic
 
+	serverURLs        serverURLs // URLs of the tailcontrol server; see serverfailover.go
+	onServerURLChange func()     // or nil; called after switching serverURLs
+
 	sfGroup     singleflight.Group[struct{}, *NoiseClient] // protects noiseClient creation.
 	noiseClient *NoiseClient
 
@@ -101,6 +109,8 @@ type Direct struct {
 	endpoints    []tailcfg.Endpoint
 	tkaHead      string
 	lastPingURL  string // last PingRequest.URL received, for dup suppression
+
+	netmapCacheSaved time.Time // when netmapCache was last stored
 }
 
 // Observer is implemented by users of the control client (such as LocalBackend)
@@ -117,6 +127,7 @@ type Options struct {
 	Persist                    persist.Persist                    // initial persistent data
 	GetMachinePrivateKey       func() (key.MachinePrivate, error) // returns the machine key to use
 	ServerURL                  string                             // URL of the tailcontrol server
+	FallbackServerURLs         []string                           // optional URLs of other instances of the tailcontrol server, in order of priority
 	AuthKey                    string                             // optional node auth key for auto registration
 	Clock                      tstime.Clock
 	Hostinfo                   *tailcfg.Hostinfo // non-nil passes ownership, nil means to use default using os.Hostname, etc
@@ -154,6 +165,10 @@ type Options struct {
 	// If we receive a new DialPlan from the server, this value will be
 	// updated.
 	DialPlan ControlDialPlanner
+
+	// NetmapCache optionally stores the last network map, which the client
+	// reports when it starts, before its first map poll completes.
+	NetmapCache NetmapCache
 }
 
 // ControlDialPlanner is the interface optionally supplied when creating a
@@ -235,6 +250,7 @@ func NewDirect(opts Options) (*Direct, error) {
 	if err != nil {
 		return nil, err
 	}
+	serverURLs := newServerURLs(opts.ServerURL, opts.FallbackServerURLs, opts.Persist.ControlURL)
 	if opts.Clock == nil {
 		opts.Clock = tstime.StdClock{}
 	}
@@ -279,7 +295,13 @@ func NewDirect(opts Options) (*Direct, error) {
 		tr := http.DefaultTransport.(*http.Transport).Clone()
 		tr.Proxy = tshttpproxy.ProxyFromEnvironment
 		tshttpproxy.SetTransportGetProxyConnectHeader(tr)
-		tr.TLSClientConfig = tlsdial.Config(serverURL.Hostname(), opts.HealthTracker, tr.TLSClientConfig)
+		tlsHost := serverURL.Hostname()
+		if len(serverURLs.urls) > 1 {
+			// Let each dial set its own ServerName, as the
+			// fallback URLs can have other hosts.
+			tlsHost = ""
+		}
+		tr.TLSClientConfig = tlsdial.Config(tlsHost, opts.HealthTracker, tr.TLSClientConfig)
 		var dialFunc dialFunc
 		dialFunc, interceptedDial = makeScreenTimeDetectingDialFunc(d)
 		tr.DialContext = dnscache.Dialer(dialFunc, dnsCache)
@@ -298,7 +320,7 @@ func NewDirect(opts Options) (*Direct, error) {
 		interceptedDial:            interceptedDial,
 		controlKnobs:               opts.ControlKnobs,
 		getMachinePrivKey:          opts.GetMachinePrivateKey,
-		serverURL:                  opts.ServerURL,
+		serverURLs:                 serverURLs,
 		clock:                      opts.Clock,
 		logf:                       opts.Logf,
 		persist:                    opts.Persist.View(),
@@ -317,6 +339,7 @@ func NewDirect(opts Options) (*Direct, error) {
 		dialer:                     opts.Dialer,
 		dnsCache:                   dnsCache,
 		dialPlan:                   opts.DialPlan,
+		netmapCache:                opts.NetmapCache,
 	}
 	if opts.Hostinfo == nil {
 		c.SetHostinfo(hostinfo.New())
@@ -335,11 +358,21 @@ func NewDirect(opts Options) (*Direct, error) {
 	if strings.Contains(opts.ServerURL, "controlplane.tailscale.com") && envknob.Bool("TS_PANIC_IF_HIT_MAIN_CONTROL") {
 		c.panicOnUse = true
 	}
+	if len(serverURLs.urls) > 1 {
+		c.logf("control server URLs, in order of priority: %q; using %s", serverURLs.urls, serverURLs.current())
+		c.serverFailoverCh = make(chan struct{}, 1)
+		var ctx context.Context
+		ctx, c.stopServerFailover = context.WithCancel(context.Background())
+		go c.serverFailoverLoop(ctx)
+	}
 	return c, nil
 }
 
 // Close closes the underlying Noise connection(s).
 func (c *Direct) Close() error {
+	if c.stopServerFailover != nil {
+		c.stopServerFailover()
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.noiseClient != nil {
@@ -426,8 +459,8 @@ func (c *Direct) TryLogout(ctx context.Context) error {
 }
 
 func (c *Direct) TryLogin(ctx context.Context, flags LoginFlags) (url string, err error) {
-	if strings.Contains(c.serverURL, "controlplane.tailscale.com") && envknob.Bool("TS_PANIC_IF_HIT_MAIN_CONTROL") {
-		panic(fmt.Sprintf("[unexpected] controlclient: TryLogin called on %s; tainted=%v", c.serverURL, c.panicOnUse))
+	if serverURL := c.currentServerURL(); strings.Contains(serverURL, "controlplane.tailscale.com") && envknob.Bool("TS_PANIC_IF_HIT_MAIN_CONTROL") {
+		panic(fmt.Sprintf("[unexpected] controlclient: TryLogin called on %s; tainted=%v", serverURL, c.panicOnUse))
 	}
 	c.logf("[v1] direct.TryLogin(flags=%v)", flags)
 	return c.doLoginOrRegen(ctx, loginOpt{Flags: flags})
@@ -464,6 +497,11 @@ func (c *Direct) SetExpirySooner(ctx context.Context, expiry time.Time) error {
 	return err
 }
 
+// ErrKeyRenewalNeedsLogin is returned by TryLogin with LoginRenewKey when
+// the control server requires an interactive login to renew the node key.
+// Auto reports it to its Observer in a Status when it abandons a renewal.
+var ErrKeyRenewalNeedsLogin = errors.New("node key renewal requires an interactive login")
+
 type loginOpt struct {
 	Flags  LoginFlags
 	Regen  bool // generate a new nodekey, can be overridden in doLogin
@@ -504,6 +542,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	}
 	c.mu.Lock()
 	persist := c.persist.AsStruct()
+	serverURL := c.serverURLs.current()
 	tryingNewKey := c.tryingNewKey
 	serverKey := c.serverLegacyKey
 	serverNoiseKey := c.serverNoiseKey
@@ -534,11 +573,16 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 			c.logf("LoginInteractive -> regen=true")
 			regen = true
 		}
+		if (opt.Flags & LoginRenewKey) != 0 {
+			c.logf("LoginRenewKey -> regen=true")
+			regen = true
+		}
 	}
 
 	c.logf("doLogin(regen=%v, hasUrl=%v)", regen, opt.URL != "")
 	if serverKey.IsZero() {
-		keys, err := loadServerPubKeys(ctx, c.httpc, c.serverURL)
+		keys, err := loadServerPubKeys(ctx, c.httpc, serverURL)
+		c.noteServerResult(ctx, serverURL, err)
 		if err != nil && c.interceptedDial != nil && c.interceptedDial.Load() {
 			c.health.SetUnhealthy(macOSScreenTime, nil)
 		} else {
@@ -547,7 +591,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 		if err != nil {
 			return regen, opt.URL, nil, err
 		}
-		c.logf("control server key from %s: ts2021=%s, legacy=%v", c.serverURL, keys.PublicKey.ShortString(), keys.LegacyPublicKey.ShortString())
+		c.logf("control server key from %s: ts2021=%s, legacy=%v", serverURL, keys.PublicKey.ShortString(), keys.LegacyPublicKey.ShortString())
 
 		c.mu.Lock()
 		c.serverLegacyKey = keys.LegacyPublicKey
@@ -645,7 +689,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 			AuthKey: authKey,
 		}
 	}
-	err = signRegisterRequest(&request, c.serverURL, c.serverLegacyKey, machinePrivKey.Public())
+	err = signRegisterRequest(&request, serverURL, c.serverLegacyKey, machinePrivKey.Public())
 	if err != nil {
 		// If signing failed, clear all related fields
 		request.SignatureType = tailcfg.SignatureNone
@@ -671,7 +715,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	if err != nil {
 		return regen, opt.URL, nil, fmt.Errorf("getNoiseClient: %w", err)
 	}
-	url := fmt.Sprintf("%s/machine/register", c.serverURL)
+	url := fmt.Sprintf("%s/machine/register", serverURL)
 	// url = strings.Replace(url, "http:", "https:", 1)
 
 	bodyData, err := encode(request)
@@ -686,6 +730,7 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	addLBHeader(req, request.NodeKey)
 
 	res, err := httpc.Do(req)
+	c.noteServerResult(ctx, serverURL, err)
 	if err != nil {
 		return regen, opt.URL, nil, fmt.Errorf("register request: %w", err)
 	}
@@ -709,6 +754,15 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	c.logf("RegisterReq: got response; nodeKeyExpired=%v, machineAuthorized=%v; authURL=%v",
 		resp.NodeKeyExpired, resp.MachineAuthorized, resp.AuthURL != "")
 
+	if resp.AuthKeyExpired && authKey != "" {
+		c.logf("control reports the auth key has expired; not using it again")
+		c.clearAuthKey(authKey)
+		msg := "auth key expired; generate a new one and try again"
+		if resp.Error != "" {
+			msg = fmt.Sprintf("%s (auth key expired; generate a new one and try again)", resp.Error)
+		}
+		return false, "", nil, UserVisibleError(msg)
+	}
 	if resp.Error != "" {
 		return false, "", nil, UserVisibleError(resp.Error)
 	}
@@ -737,12 +791,23 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	//	- machine key no longer supported
 	//	- user is disabled
 
+	if resp.AuthURL != "" && (opt.Flags&LoginRenewKey) != 0 {
+		// Don't prompt the user for a renewal they didn't ask for; keep
+		// using the current key until it needs a login anyway.
+		c.logf("control requires an interactive login to renew the node key; keeping the current key")
+		return false, "", nil, ErrKeyRenewalNeedsLogin
+	}
 	if resp.AuthURL != "" {
 		c.logf("AuthURL is %v", resp.AuthURL)
 	} else {
 		c.logf("[v1] No AuthURL")
 	}
 
+	if resp.AuthURL == "" && resp.AuthKeySingleUse && authKey != "" {
+		c.logf("registered with a single-use auth key; not sending it again")
+		c.clearAuthKey(authKey)
+	}
+
 	c.mu.Lock()
 	if resp.AuthURL == "" {
 		// key rotation is complete
@@ -760,6 +825,16 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	return false, resp.AuthURL, nil, nil
 }
 
+// clearAuthKey stops c from sending an auth key in later registrations, if
+// its auth key still decodes to authKey (see tka.DecodeWrappedAuthkey).
+func (c *Direct) clearAuthKey(authKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if k, _, _, _ := tka.DecodeWrappedAuthkey(c.authKey, c.logf); k == authKey {
+		c.authKey = ""
+	}
+}
+
 // newEndpoints acquires c.mu and sets the local port and endpoints and reports
 // whether they've changed.
 //
@@ -850,7 +925,7 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 
 	c.mu.Lock()
 	persist := c.persist
-	serverURL := c.serverURL
+	serverURL := c.serverURLs.current()
 	serverNoiseKey := c.serverNoiseKey
 	hi := c.hostInfoLocked()
 	backendLogID := hi.BackendLogID
@@ -974,6 +1049,7 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 	addLBHeader(req, nodeKey)
 
 	res, err := httpc.Do(req)
+	c.noteServerResult(ctx, serverURL, err)
 	if err != nil {
 		vlogf("netmap: Do: %v", err)
 		return err
@@ -1024,6 +1100,10 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 	// KeepAlive set.
 	var gotNonKeepAliveMessage bool
 
+	// storedNetmapCache is whether this session's network map has been
+	// stored in c.netmapCache yet.
+	var storedNetmapCache bool
+
 	// If allowStream, then the server will use an HTTP long poll to
 	// return incremental results. There is always one response right
 	// away, followed by a delay, and eventually others.
@@ -1120,6 +1200,10 @@ func (c *Direct) sendMapRequest(ctx context.Context, isStreaming bool, nu Netmap
 		if err := sess.HandleNonKeepAliveMapResponse(ctx, &resp); err != nil {
 			return err
 		}
+		if c.netmapCache != nil {
+			c.storeNetmapCache(sess, nodeKey, !storedNetmapCache)
+			storedNetmapCache = true
+		}
 	}
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -1447,6 +1531,7 @@ func sleepAsRequested(ctx context.Context, logf logger.Logf, d time.Duration, cl
 // getNoiseClient returns the noise client, creating one if one doesn't exist.
 func (c *Direct) getNoiseClient() (*NoiseClient, error) {
 	c.mu.Lock()
+	serverURL := c.serverURLs.current()
 	serverNoiseKey := c.serverNoiseKey
 	nc := c.noiseClient
 	c.mu.Unlock()
@@ -1469,7 +1554,7 @@ func (c *Direct) getNoiseClient() (*NoiseClient, error) {
 		nc, err := NewNoiseClient(NoiseOpts{
 			PrivKey:       k,
 			ServerPubKey:  serverNoiseKey,
-			ServerURL:     c.serverURL,
+			ServerURL:     serverURL,
 			Dialer:        c.dialer,
 			DNSCache:      c.dnsCache,
 			Logf:          c.logf,
@@ -1482,7 +1567,9 @@ func (c *Direct) getNoiseClient() (*NoiseClient, error) {
 		}
 		c.mu.Lock()
 		defer c.mu.Unlock()
-		c.noiseClient = nc
+		if c.serverURLs.current() == serverURL {
+			c.noiseClient = nc
+		}
 		return nc, nil
 	})
 	if err != nil {
@@ -1705,4 +1792,6 @@ func isTCPLoopback(a net.Addr) bool {
 
 	metricSetDNS      = clientmetric.NewCounter("controlclient_setdns")
 	metricSetDNSError = clientmetric.NewCounter("controlclient_setdns_error")
+
+	metricControlServerSwitches = clientmetric.NewCounter("controlclient_server_url_switches")
 )
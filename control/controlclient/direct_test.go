@@ -4,10 +4,13 @@
 package controlclient
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +19,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstest/integration/testcontrol"
 	"tailscale.com/types/key"
 )
 
@@ -38,8 +42,8 @@ func TestNewDirect(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if c.serverURL != opts.ServerURL {
-		t.Errorf("c.serverURL got %v want %v", c.serverURL, opts.ServerURL)
+	if got := c.currentServerURL(); got != opts.ServerURL {
+		t.Errorf("c.currentServerURL() got %v want %v", got, opts.ServerURL)
 	}
 
 	// hi is stored without its NetInfo field.
@@ -146,3 +150,139 @@ func TestTsmpPing(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestAuthKey(t *testing.T) {
+	const authKey = "opensesame"
+	newClient := func(t *testing.T, control *testcontrol.Server) *Direct {
+		control.RequireAuthKey = authKey
+		control.HTTPTestServer = httptest.NewServer(control)
+		t.Cleanup(control.HTTPTestServer.Close)
+
+		hi := hostinfo.New()
+		hi.BackendLogID = "test"
+		k := key.NewMachine()
+		c, err := NewDirect(Options{
+			ServerURL: control.HTTPTestServer.URL,
+			AuthKey:   authKey,
+			Hostinfo:  hi,
+			GetMachinePrivateKey: func() (key.MachinePrivate, error) {
+				return k, nil
+			},
+			Dialer: tsdial.NewDialer(netmon.NewStatic()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ctx := context.Background()
+
+	t.Run("reusable", func(t *testing.T) {
+		c := newClient(t, &testcontrol.Server{})
+		if _, err := c.TryLogin(ctx, LoginDefault); err != nil {
+			t.Fatal(err)
+		}
+		if c.authKey != authKey {
+			t.Errorf("reusable auth key was cleared after registering")
+		}
+	})
+
+	t.Run("single-use", func(t *testing.T) {
+		c := newClient(t, &testcontrol.Server{AuthKeySingleUse: true})
+		if _, err := c.TryLogin(ctx, LoginDefault); err != nil {
+			t.Fatal(err)
+		}
+		if c.authKey != "" {
+			t.Errorf("single-use auth key wasn't cleared after registering")
+		}
+		// Re-registering (with a new node key) must not send the spent
+		// key, which control would reject.
+		oldKey := c.persist.PrivateNodeKey().Public()
+		if _, err := c.TryLogin(ctx, LoginRenewKey); err != nil {
+			t.Fatalf("re-registering after using a single-use key: %v", err)
+		}
+		if c.persist.PrivateNodeKey().Public() == oldKey {
+			t.Error("node key not renewed")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		c := newClient(t, &testcontrol.Server{AuthKeyExpired: true})
+		_, err := c.TryLogin(ctx, LoginDefault)
+		var uerr UserVisibleError
+		if !errors.As(err, &uerr) || !strings.Contains(uerr.UserVisibleError(), "expired") {
+			t.Fatalf("TryLogin with an expired key = %v; want a user-visible error about the expiry", err)
+		}
+		if c.authKey != "" {
+			t.Errorf("expired auth key wasn't cleared")
+		}
+	})
+}
+
+func TestLoginRenewKey(t *testing.T) {
+	newClient := func(t *testing.T, control *testcontrol.Server) *Direct {
+		control.HTTPTestServer = httptest.NewServer(control)
+		t.Cleanup(control.HTTPTestServer.Close)
+
+		hi := hostinfo.New()
+		hi.BackendLogID = "test"
+		k := key.NewMachine()
+		c, err := NewDirect(Options{
+			ServerURL: control.HTTPTestServer.URL,
+			Hostinfo:  hi,
+			GetMachinePrivateKey: func() (key.MachinePrivate, error) {
+				return k, nil
+			},
+			Dialer: tsdial.NewDialer(netmon.NewStatic()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ctx := context.Background()
+
+	t.Run("renewed", func(t *testing.T) {
+		c := newClient(t, &testcontrol.Server{})
+		if _, err := c.TryLogin(ctx, LoginDefault); err != nil {
+			t.Fatal(err)
+		}
+		oldKey := c.persist.PrivateNodeKey().Public()
+		if url, err := c.TryLogin(ctx, LoginRenewKey); err != nil || url != "" {
+			t.Fatalf("TryLogin(LoginRenewKey) = %q, %v; want no login URL", url, err)
+		}
+		if c.persist.PrivateNodeKey().Public() == oldKey {
+			t.Error("node key not renewed")
+		}
+		if got := c.persist.OldPrivateNodeKey().Public(); got != oldKey {
+			t.Errorf("old node key = %v; want %v", got.ShortString(), oldKey.ShortString())
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		// A control server wanting an interactive login for each new
+		// node key declines the renewal rather than have us prompt the
+		// user.
+		control := &testcontrol.Server{RequireAuth: true}
+		c := newClient(t, control)
+		url, err := c.TryLogin(ctx, LoginDefault)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !control.CompleteAuth(url) {
+			t.Fatalf("CompleteAuth(%q) failed", url)
+		}
+		if _, err := c.WaitLoginURL(ctx, url); err != nil {
+			t.Fatal(err)
+		}
+		oldKey := c.persist.PrivateNodeKey().Public()
+		if url, err := c.TryLogin(ctx, LoginRenewKey); !errors.Is(err, ErrKeyRenewalNeedsLogin) {
+			t.Fatalf("TryLogin(LoginRenewKey) = %q, %v; want %v", url, err, ErrKeyRenewalNeedsLogin)
+		}
+		if got := c.persist.PrivateNodeKey().Public(); got != oldKey {
+			t.Errorf("node key changed from %v to %v after a declined renewal", oldKey.ShortString(), got.ShortString())
+		}
+	})
+}
@@ -191,9 +191,11 @@ func (ms *mapSession) HandleNonKeepAliveMapResponse(ctx context.Context, resp *t
 	ms.updateStateFromResponse(resp)
 
 	if ms.tryHandleIncrementally(resp) {
+		metricMapResponseDelta.Add(1)
 		ms.occasionallyPrintSummary(ms.lastNetmapSummary)
 		return nil
 	}
+	metricMapResponseFull.Add(1)
 
 	// We have to rebuild the whole netmap (lots of garbage & work downstream of
 	// our UpdateFullNetmap call). This is the part we tried to avoid but
@@ -367,6 +369,12 @@ func (ms *mapSession) updateStateFromResponse(resp *tailcfg.MapResponse) {
 
 	patchifiedPeer      = clientmetric.NewCounter("controlclient_patchified_peer")
 	patchifiedPeerEqual = clientmetric.NewCounter("controlclient_patchified_peer_equal")
+
+	// metricMapResponseDelta and metricMapResponseFull count the
+	// non-keepalive MapResponses that were applied as discrete node
+	// mutations and those that required a full netmap rebuild.
+	metricMapResponseDelta = clientmetric.NewCounter("controlclient_map_response_delta")
+	metricMapResponseFull  = clientmetric.NewCounter("controlclient_map_response_full")
 )
 
 // updatePeersStateFromResponseres updates ms.peers and ms.sortedPeers from res. It takes ownership of res.
@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/opt"
+)
+
+// NetmapCache is the storage for the copy of the last network map that a
+// Direct client keeps, so that it can report a network map as soon as it
+// starts, before its first map poll completes. This brings up peers and
+// DERP connectivity immediately after a restart, and while the control
+// server is unreachable.
+type NetmapCache interface {
+	// Load returns the cached data last passed to Store, or nil if there's
+	// none.
+	Load() ([]byte, error)
+	// Store replaces the cached data.
+	Store([]byte) error
+}
+
+// netmapCacheInterval is how often the netmap cache is updated while a map
+// poll is receiving changes. It's also updated when each map poll gets its
+// first network map.
+const netmapCacheInterval = 10 * time.Minute
+
+// cachedNetmap is the JSON value kept in a NetmapCache.
+type cachedNetmap struct {
+	// NodeKey is the node key the network map was sent for. The cache is
+	// ignored if the node's key is different.
+	NodeKey key.NodePublic
+	Saved   time.Time
+
+	// Response is a MapResponse with the full state of the map session
+	// at the time, from which the session's network map can be rebuilt.
+	Response *tailcfg.MapResponse
+}
+
+// cachedMapResponse returns a MapResponse that yields ms's current network
+// map when given to a new mapSession.
+func (ms *mapSession) cachedMapResponse() *tailcfg.MapResponse {
+	resp := &tailcfg.MapResponse{
+		DNSConfig:                 ms.lastDNSConfig,
+		DERPMap:                   ms.lastDERPMap,
+		SSHPolicy:                 ms.lastSSHPolicy,
+		CollectServices:           opt.NewBool(ms.collectServices),
+		Domain:                    ms.lastDomain,
+		DomainDataPlaneAuditLogID: ms.lastDomainAuditLogID,
+		Health:                    ms.lastHealth,
+		TKAInfo:                   ms.lastTKAInfo,
+		MaxKeyDuration:            ms.lastMaxExpiry,
+	}
+	if ms.lastNode.Valid() {
+		resp.Node = ms.lastNode.AsStruct()
+	}
+	resp.Peers = make([]*tailcfg.Node, 0, len(ms.sortedPeers))
+	for _, vp := range ms.sortedPeers {
+		resp.Peers = append(resp.Peers, vp.AsStruct())
+	}
+	for _, up := range ms.lastUserProfile {
+		resp.UserProfiles = append(resp.UserProfiles, up)
+	}
+	resp.PacketFilters = map[string][]tailcfg.FilterRule{"*": nil}
+	for name, rules := range ms.namedPacketFilters {
+		resp.PacketFilters[name] = rules.AsSlice()
+	}
+	return resp
+}
+
+// storeNetmapCache saves the state of sess, for node key nodeKey, in
+// c.netmapCache, unless it was saved less than netmapCacheInterval ago and
+// force is false.
+func (c *Direct) storeNetmapCache(sess *mapSession, nodeKey key.NodePublic, force bool) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	if !force && now.Sub(c.netmapCacheSaved) < netmapCacheInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.netmapCacheSaved = now
+	c.mu.Unlock()
+
+	bs, err := json.Marshal(cachedNetmap{
+		NodeKey:  nodeKey,
+		Saved:    now,
+		Response: sess.cachedMapResponse(),
+	})
+	if err == nil {
+		err = c.netmapCache.Store(bs)
+	}
+	if err != nil {
+		c.logf("netmap cache: store: %v", err)
+	}
+}
+
+// cachedNetmap returns the network map in c.netmapCache, or nil if there
+// isn't one for the current node key.
+func (c *Direct) cachedNetmap() *netmap.NetworkMap {
+	c.mu.Lock()
+	nodePriv := c.persist.PrivateNodeKey()
+	c.mu.Unlock()
+	if c.netmapCache == nil || nodePriv.IsZero() {
+		return nil
+	}
+	bs, err := c.netmapCache.Load()
+	if err != nil {
+		c.logf("netmap cache: load: %v", err)
+		return nil
+	}
+	if bs == nil {
+		return nil
+	}
+	var cm cachedNetmap
+	if err := json.Unmarshal(bs, &cm); err != nil {
+		c.logf("netmap cache: parsing: %v", err)
+		return nil
+	}
+	if cm.Response == nil || cm.Response.Node == nil || cm.NodeKey != nodePriv.Public() {
+		return nil
+	}
+
+	var nu netmapCapture
+	sess := newMapSession(nodePriv, &nu, c.controlKnobs)
+	defer sess.Close()
+	sess.logf = c.logf
+	sess.altClock = c.clock
+	if mk, err := c.getMachinePrivKey(); err == nil {
+		sess.machinePubKey = mk.Public()
+	}
+	if err := sess.HandleNonKeepAliveMapResponse(context.Background(), cm.Response); err != nil {
+		c.logf("netmap cache: %v", err)
+		return nil
+	}
+	if nu.nm != nil {
+		c.logf("netmap cache: loaded network map from %v", cm.Saved.Round(time.Second))
+	}
+	return nu.nm
+}
+
+// netmapCapture is a NetmapUpdater that keeps the last full network map.
+type netmapCapture struct {
+	nm *netmap.NetworkMap
+}
+
+func (c *netmapCapture) UpdateFullNetmap(nm *netmap.NetworkMap) { c.nm = nm }
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"context"
+	"testing"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/key"
+	"tailscale.com/types/persist"
+)
+
+type memNetmapCache struct {
+	data   []byte
+	stores int
+}
+
+func (c *memNetmapCache) Load() ([]byte, error) { return c.data, nil }
+
+func (c *memNetmapCache) Store(bs []byte) error {
+	c.data = bs
+	c.stores++
+	return nil
+}
+
+func TestNetmapCache(t *testing.T) {
+	nodePriv := key.NewNode()
+	machinePriv := key.NewMachine()
+	var nu netmapCapture
+	ms := newTestMapSession(t, &nu)
+	ms.privateNodeKey = nodePriv
+	ms.publicNodeKey = nodePriv.Public()
+	ms.machinePubKey = machinePriv.Public()
+	err := ms.HandleNonKeepAliveMapResponse(context.Background(), &tailcfg.MapResponse{
+		Node: &tailcfg.Node{ID: 1, Name: "self.example.ts.net.", User: 3},
+		Peers: []*tailcfg.Node{
+			{ID: 2, Name: "peer.example.ts.net.", Key: key.NewNode().Public(), User: 3},
+		},
+		DNSConfig: &tailcfg.DNSConfig{Domains: []string{"example.com"}},
+		DERPMap: &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "r1"},
+		}},
+		PacketFilters: map[string][]tailcfg.FilterRule{
+			"base": {{
+				SrcIPs:   []string{"*"},
+				DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}},
+			}},
+		},
+		UserProfiles: []tailcfg.UserProfile{{ID: 3, LoginName: "user@example.com"}},
+		Domain:       "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nu.nm
+	if want == nil {
+		t.Fatal("no netmap")
+	}
+
+	cache := new(memNetmapCache)
+	c := &Direct{
+		logf:              t.Logf,
+		clock:             tstime.StdClock{},
+		controlKnobs:      new(controlknobs.Knobs),
+		netmapCache:       cache,
+		persist:           (&persist.Persist{PrivateNodeKey: nodePriv}).View(),
+		getMachinePrivKey: func() (key.MachinePrivate, error) { return machinePriv, nil },
+	}
+	c.storeNetmapCache(ms, nodePriv.Public(), true)
+	c.storeNetmapCache(ms, nodePriv.Public(), false)
+	if cache.stores != 1 {
+		t.Errorf("cache stored %d times; want 1 as the second store is too soon", cache.stores)
+	}
+
+	got := c.cachedNetmap()
+	if got == nil {
+		t.Fatal("cachedNetmap returned nil")
+	}
+	if got.SelfNode.ID() != 1 || got.NodeKey != nodePriv.Public() || got.MachineKey != machinePriv.Public() {
+		t.Errorf("self = %v, %v, %v; want node 1 with the node and machine keys", got.SelfNode.ID(), got.NodeKey, got.MachineKey)
+	}
+	if len(got.Peers) != 1 || got.Peers[0].ID() != 2 || got.Peers[0].Key() != want.Peers[0].Key() {
+		t.Errorf("peers = %v; want %v", got.Peers, want.Peers)
+	}
+	if got.DERPMap == nil || got.DERPMap.Regions[1] == nil || got.DERPMap.Regions[1].RegionCode != "r1" {
+		t.Errorf("DERP map = %+v; want region r1", got.DERPMap)
+	}
+	if len(got.DNS.Domains) != 1 || got.DNS.Domains[0] != "example.com" {
+		t.Errorf("DNS domains = %q; want [example.com]", got.DNS.Domains)
+	}
+	if got.PacketFilterRules.Len() != 1 || len(got.PacketFilter) != len(want.PacketFilter) {
+		t.Errorf("packet filter has %d rules, %d matches; want 1 rule, %d matches", got.PacketFilterRules.Len(), len(got.PacketFilter), len(want.PacketFilter))
+	}
+	if got.Domain != "example.com" || got.UserProfiles[3].LoginName != "user@example.com" {
+		t.Errorf("domain, user = %q, %q; want example.com, user@example.com", got.Domain, got.UserProfiles[3].LoginName)
+	}
+
+	// The cache is ignored once the node key changes.
+	c.persist = (&persist.Persist{PrivateNodeKey: key.NewNode()}).View()
+	if nm := c.cachedNetmap(); nm != nil {
+		t.Errorf("cachedNetmap for a new node key = %v; want nil", nm)
+	}
+}
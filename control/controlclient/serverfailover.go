@@ -0,0 +1,211 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// serverFailoverMaxFailures is how many consecutive requests to the
+	// control server URL in use must fail to connect before the client
+	// fails over to another of its URLs.
+	serverFailoverMaxFailures = 3
+
+	// serverFailbackInterval is how often, while failed over, the control
+	// server URLs of higher priority than the one in use are health
+	// checked, to fail back to them.
+	serverFailbackInterval = 30 * time.Second
+
+	// serverHealthCheckTimeout is how long a control server URL has to
+	// respond to a health check.
+	serverHealthCheckTimeout = 10 * time.Second
+)
+
+// serverURLs are the URLs of a control server with redundancy, in order of
+// priority, and which of them is in use. It's guarded by Direct.mu.
+type serverURLs struct {
+	urls     []string // urls[0] is Options.ServerURL; never modified
+	cur      int      // index in urls of the URL in use
+	failures int      // consecutive failures to connect to urls[cur]
+}
+
+// newServerURLs returns the serverURLs for the primary URL and its
+// fallbacks, starting with last if it's one of them, such as the URL that
+// last worked before a restart.
+func newServerURLs(primary string, fallbacks []string, last string) serverURLs {
+	urls := []string{primary}
+	for _, u := range fallbacks {
+		u = strings.TrimRight(u, "/")
+		if u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	s := serverURLs{urls: urls}
+	if i := slices.Index(urls, strings.TrimRight(last, "/")); i > 0 {
+		s.cur = i
+	}
+	return s
+}
+
+// current returns the URL in use.
+func (s *serverURLs) current() string {
+	return s.urls[s.cur]
+}
+
+// noteResult records whether a request to url failed to connect, and
+// reports whether the client should fail over to another URL.
+func (s *serverURLs) noteResult(url string, failed bool) (failOver bool) {
+	if url != s.current() {
+		return false // stale result from before a switch
+	}
+	if !failed {
+		s.failures = 0
+		return false
+	}
+	s.failures++
+	if s.failures < serverFailoverMaxFailures || len(s.urls) < 2 {
+		return false
+	}
+	s.failures = 0 // try again after as many failures if none is found
+	return true
+}
+
+// candidates returns the URLs to health check, in order: when failing over,
+// all URLs but the one in use, and otherwise those of higher priority than
+// it.
+func (s *serverURLs) candidates(failingOver bool) []string {
+	if !failingOver {
+		return s.urls[:s.cur]
+	}
+	return slices.Delete(slices.Clone(s.urls), s.cur, s.cur+1)
+}
+
+// setCurrent switches to url, and reports whether it was one of s's URLs
+// and not already in use.
+func (s *serverURLs) setCurrent(url string) bool {
+	i := slices.Index(s.urls, url)
+	if i < 0 || i == s.cur {
+		return false
+	}
+	s.cur = i
+	s.failures = 0
+	return true
+}
+
+// noteServerResult records whether a request to the control server at url
+// with context ctx failed to connect, as opposed to getting any response,
+// and triggers a failover after too many consecutive failures.
+func (c *Direct) noteServerResult(ctx context.Context, url string, err error) {
+	if c.serverFailoverCh == nil {
+		return // no fallbacks
+	}
+	if err != nil && ctx.Err() != nil {
+		return // canceled on purpose
+	}
+	c.mu.Lock()
+	failOver := c.serverURLs.noteResult(url, err != nil)
+	c.mu.Unlock()
+	if failOver {
+		c.logf("control server %s unreachable after %d attempts: %v; looking for another", url, serverFailoverMaxFailures, err)
+		select {
+		case c.serverFailoverCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serverFailoverLoop fails over to another control server URL when asked to
+// on c.serverFailoverCh, and periodically checks whether it can fail back to
+// a higher-priority one, until ctx is done.
+func (c *Direct) serverFailoverLoop(ctx context.Context) {
+	t, tChannel := c.clock.NewTicker(serverFailbackInterval)
+	defer t.Stop()
+	for {
+		var failingOver bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.serverFailoverCh:
+			failingOver = true
+		case <-tChannel:
+		}
+		c.mu.Lock()
+		cands := c.serverURLs.candidates(failingOver)
+		c.mu.Unlock()
+		for _, u := range cands {
+			if err := c.checkServerURL(ctx, u); err != nil {
+				c.logf("[v1] control server %s health check: %v", u, err)
+				continue
+			}
+			c.switchServerURL(u)
+			break
+		}
+	}
+}
+
+// checkServerURL reports whether the control server at url is reachable
+// and has the same keys as the one in use, if known.
+func (c *Direct) checkServerURL(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, serverHealthCheckTimeout)
+	defer cancel()
+	keys, err := loadServerPubKeys(ctx, c.httpc, url)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	want := c.serverNoiseKey
+	c.mu.Unlock()
+	if !want.IsZero() && keys.PublicKey != want {
+		return fmt.Errorf("has key %v; want %v, the key of the other control server URLs", keys.PublicKey.ShortString(), want.ShortString())
+	}
+	return nil
+}
+
+// switchServerURL switches to the control server URL url, dropping the
+// connections to the previous one, and records it in the persisted state
+// so that the client starts with it next time.
+func (c *Direct) switchServerURL(url string) {
+	c.mu.Lock()
+	old := c.serverURLs.current()
+	if !c.serverURLs.setCurrent(url) {
+		c.mu.Unlock()
+		return
+	}
+	nc := c.noiseClient
+	c.noiseClient = nil
+	if p := c.persist.AsStruct(); p != nil {
+		p.ControlURL = ""
+		if url != c.serverURLs.urls[0] {
+			p.ControlURL = url
+		}
+		c.persist = p.View()
+	}
+	onChange := c.onServerURLChange
+	c.mu.Unlock()
+
+	c.logf("switched control server from %s to %s", old, url)
+	metricControlServerSwitches.Add(1)
+	if nc != nil {
+		nc.Close()
+	}
+	// A dial plan from the previous URL's server may point at it.
+	if c.dialPlan != nil {
+		c.dialPlan.Store(nil)
+	}
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// currentServerURL returns the URL of the control server in use.
+func (c *Direct) currentServerURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serverURLs.current()
+}
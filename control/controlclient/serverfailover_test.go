@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewServerURLs(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallbacks []string
+		last      string
+		wantURLs  []string
+		wantCur   string
+	}{
+		{
+			name:     "no_fallbacks",
+			wantURLs: []string{"https://a.example.com"},
+			wantCur:  "https://a.example.com",
+		},
+		{
+			name:      "fallbacks",
+			fallbacks: []string{"https://b.example.com/", "https://a.example.com", "", "https://c.example.com", "https://b.example.com"},
+			wantURLs:  []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"},
+			wantCur:   "https://a.example.com",
+		},
+		{
+			name:      "last_fallback",
+			fallbacks: []string{"https://b.example.com", "https://c.example.com"},
+			last:      "https://c.example.com",
+			wantURLs:  []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"},
+			wantCur:   "https://c.example.com",
+		},
+		{
+			name:      "last_unknown",
+			fallbacks: []string{"https://b.example.com"},
+			last:      "https://old.example.com",
+			wantURLs:  []string{"https://a.example.com", "https://b.example.com"},
+			wantCur:   "https://a.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServerURLs("https://a.example.com", tt.fallbacks, tt.last)
+			if !reflect.DeepEqual(s.urls, tt.wantURLs) {
+				t.Errorf("urls = %q; want %q", s.urls, tt.wantURLs)
+			}
+			if got := s.current(); got != tt.wantCur {
+				t.Errorf("current = %q; want %q", got, tt.wantCur)
+			}
+		})
+	}
+}
+
+func TestServerURLsFailover(t *testing.T) {
+	const a, b, c = "https://a.example.com", "https://b.example.com", "https://c.example.com"
+	s := newServerURLs(a, []string{b, c}, "")
+
+	for i := range serverFailoverMaxFailures - 1 {
+		if s.noteResult(a, true) {
+			t.Fatalf("failover after %d failures", i+1)
+		}
+	}
+	if s.noteResult(a, false) {
+		t.Fatal("failover after success")
+	}
+	for i := range serverFailoverMaxFailures - 1 {
+		if s.noteResult(a, true) {
+			t.Fatalf("failover after %d failures following a success", i+1)
+		}
+	}
+	if s.noteResult(b, true) {
+		t.Fatal("failover after a failure of a URL not in use")
+	}
+	if !s.noteResult(a, true) {
+		t.Fatalf("no failover after %d failures", serverFailoverMaxFailures)
+	}
+	if got, want := s.candidates(true), []string{b, c}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failover candidates = %q; want %q", got, want)
+	}
+	if got := s.candidates(false); len(got) != 0 {
+		t.Errorf("failback candidates at primary = %q; want none", got)
+	}
+
+	if !s.setCurrent(c) {
+		t.Fatal("setCurrent(c) = false")
+	}
+	if s.setCurrent(c) {
+		t.Error("setCurrent(c) again = true")
+	}
+	if s.setCurrent("https://other.example.com") {
+		t.Error("setCurrent(unknown) = true")
+	}
+	if got, want := s.candidates(false), []string{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failback candidates = %q; want %q", got, want)
+	}
+	if got, want := s.candidates(true), []string{a, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failover candidates = %q; want %q", got, want)
+	}
+	if got, want := s.urls, []string{a, b, c}; !reflect.DeepEqual(got, want) {
+		t.Errorf("urls modified: %q; want %q", got, want)
+	}
+}
@@ -41,7 +41,8 @@
 // ProtocolVersion is bumped whenever there's a wire-incompatible change.
 //   - version 1 (zero on wire): consistent box headers, in use by employee dev nodes a bit
 //   - version 2: received packets have src addrs in frameRecvPacket at beginning
-const ProtocolVersion = 2
+//   - version 3: server accepts frameSendPackets
+const ProtocolVersion = 3
 
 // frameType is the one byte frame type at the beginning of the frame
 // header.  The second field is a big-endian uint32 describing the
@@ -124,8 +125,20 @@
 	// and how long to try total. See ServerRestartingMessage docs for
 	// more details on how the client should interpret them.
 	frameRestarting = frameType(0x15)
+
+	// frameSendPackets is sent from client to server to send several
+	// packets to the same peer in one frame, which the server splits into
+	// a frameRecvPacket each. It's only sent to servers of protocol
+	// version 3 or later.
+	frameSendPackets = frameType(0x16) // 32B dest pub key + 1+ (2B big-endian packet length + packet bytes)
 )
 
+// MaxPacketsFrameSize is the maximum length of a frameSendPackets frame,
+// not including its frame header. It's a TLS record's worth of packets,
+// which is enough to amortize the framing and TLS overhead of small
+// packets.
+const MaxPacketsFrameSize = 16 << 10
+
 // PeerGoneReasonType is a one byte reason code explaining why a
 // server does not have a path to the requested destination.
 type PeerGoneReasonType byte
@@ -12,6 +12,7 @@
 	"io"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go4.org/mem"
@@ -42,6 +43,8 @@ type Client struct {
 	peeked  int                      // bytes to discard on next Recv
 	readErr syncs.AtomicValue[error] // sticky (set by Recv)
 
+	serverVersion atomic.Int32 // protocol version from the server info frame, or 0 until Recv gets it
+
 	clock tstime.Clock
 }
 
@@ -60,6 +63,7 @@ type clientOpt struct {
 	ServerPub   key.NodePublic
 	CanAckPings bool
 	IsProber    bool
+	Clock       tstime.Clock
 }
 
 // MeshKey returns a ClientOpt to pass to the DERP server during connect to get
@@ -84,6 +88,13 @@ func CanAckPings(v bool) ClientOpt {
 	return clientOptFunc(func(o *clientOpt) { o.CanAckPings = v })
 }
 
+// Clock returns a ClientOpt to set the clock used for the client's timers.
+// If clock is nil, the returned ClientOpt is a no-op and the real clock
+// is used.
+func Clock(clock tstime.Clock) ClientOpt {
+	return clientOptFunc(func(o *clientOpt) { o.Clock = clock })
+}
+
 func NewClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf logger.Logf, opts ...ClientOpt) (*Client, error) {
 	var opt clientOpt
 	for _, o := range opts {
@@ -106,7 +117,10 @@ func newClient(privateKey key.NodePrivate, nc Conn, brw *bufio.ReadWriter, logf
 		meshKey:     opt.MeshKey,
 		canAckPings: opt.CanAckPings,
 		isProber:    opt.IsProber,
-		clock:       tstime.StdClock{},
+		clock:       opt.Clock,
+	}
+	if c.clock == nil {
+		c.clock = tstime.StdClock{}
 	}
 	if opt.ServerPub.IsZero() {
 		if err := c.recvServerKey(); err != nil {
@@ -234,6 +248,65 @@ func (c *Client) send(dstKey key.NodePublic, pkt []byte) (ret error) {
 	return c.bw.Flush()
 }
 
+// CanSendPackets reports whether the server is known to accept SendPackets
+// with more than one packet. It's false until Recv has received the
+// server's info frame.
+func (c *Client) CanSendPackets() bool { return c.serverVersion.Load() >= 3 }
+
+// SendPackets sends pkts to the Tailscale node identified by dstKey. If the
+// server supports it, the packets are sent in one frame, which must not be
+// larger than MaxPacketsFrameSize including a 2 byte length per packet.
+// Otherwise, they're sent one at a time, as by Send.
+func (c *Client) SendPackets(dstKey key.NodePublic, pkts [][]byte) (ret error) {
+	if len(pkts) == 1 || !c.CanSendPackets() {
+		for _, pkt := range pkts {
+			if err := c.send(dstKey, pkt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer func() {
+		if ret != nil {
+			ret = fmt.Errorf("derp.SendPackets: %w", ret)
+		}
+	}()
+
+	size := 0
+	for _, pkt := range pkts {
+		size += 2 + len(pkt)
+	}
+	if size > MaxPacketsFrameSize {
+		return fmt.Errorf("packets too big: %d", size)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.rate != nil {
+		pktLen := frameHeaderLen + key.NodePublicRawLen + size
+		if !c.rate.AllowN(c.clock.Now(), pktLen) {
+			return nil // drop
+		}
+	}
+	if err := writeFrameHeader(c.bw, frameSendPackets, uint32(key.NodePublicRawLen+size)); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(dstKey.AppendTo(nil)); err != nil {
+		return err
+	}
+	for _, pkt := range pkts {
+		var l [2]byte
+		bin.PutUint16(l[:], uint16(len(pkt)))
+		if _, err := c.bw.Write(l[:]); err != nil {
+			return err
+		}
+		if _, err := c.bw.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return c.bw.Flush()
+}
+
 func (c *Client) ForwardPacket(srcKey, dstKey key.NodePublic, pkt []byte) (err error) {
 	defer func() {
 		if err != nil {
@@ -526,6 +599,7 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 				TokenBucketBytesPerSecond: si.TokenBucketBytesPerSecond,
 				TokenBucketBytesBurst:     si.TokenBucketBytesBurst,
 			}
+			c.serverVersion.Store(int32(si.Version))
 			c.setSendRateLimiter(sm)
 			return sm, nil
 		case frameKeepAlive:
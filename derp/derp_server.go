@@ -110,6 +110,7 @@ type Server struct {
 	// Counters:
 	packetsSent, bytesSent       expvar.Int
 	packetsRecv, bytesRecv       expvar.Int
+	packetsFramesRecv            expvar.Int // frameSendPackets frames, each with packetsRecv packets
 	packetsRecvByKind            metrics.LabelMap
 	packetsRecvDisco             *expvar.Int
 	packetsRecvOther             *expvar.Int
@@ -152,6 +153,18 @@ type Server struct {
 	verifyClientsURL         string
 	verifyClientsURLFailOpen bool
 
+	// verifyClientsKeys, if non-nil, is the set of the only client keys
+	// accepted, for private relays. It's set by SetVerifyClientKeys.
+	verifyClientsKeys set.Set[key.NodePublic]
+
+	// verifyClientsFunc, if non-nil, is called to accept or reject each
+	// client. It's set by SetVerifyClientFunc.
+	verifyClientsFunc VerifyClientFunc
+
+	// usage, if non-nil, accounts for the traffic relayed between
+	// clients. It's set by SetUsageAccounting.
+	usage *usageAccounting
+
 	mu       sync.Mutex
 	closed   bool
 	netConns map[Conn]chan struct{} // chan is closed when conn closes
@@ -391,6 +404,34 @@ func (s *Server) SetVerifyClientURLFailOpen(v bool) {
 	s.verifyClientsURLFailOpen = v
 }
 
+// SetVerifyClientKeys restricts the clients this DERP server accepts to
+// those with the given node keys, for private relays. If keys is nil, clients
+// aren't restricted by key; an empty non-nil slice rejects all of them but
+// mesh peers.
+//
+// It must be called before serving begins.
+func (s *Server) SetVerifyClientKeys(keys []key.NodePublic) {
+	if keys == nil {
+		s.verifyClientsKeys = nil
+		return
+	}
+	s.verifyClientsKeys = set.SetOf(keys)
+}
+
+// VerifyClientFunc is the type of a hook deciding whether a DERP server
+// accepts a client with node key clientKey connecting from clientIP. A
+// non-nil error rejects the client.
+type VerifyClientFunc func(ctx context.Context, clientKey key.NodePublic, clientIP netip.Addr) error
+
+// SetVerifyClientFunc sets a hook to accept or reject each client, such as
+// by querying a control server. It's called in addition to the other
+// verification methods, after them. Mesh peers aren't subject to it.
+//
+// It must be called before serving begins.
+func (s *Server) SetVerifyClientFunc(f VerifyClientFunc) {
+	s.verifyClientsFunc = f
+}
+
 // HasMeshKey reports whether the server is configured with a mesh key.
 func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
 
@@ -426,6 +467,9 @@ func (s *Server) Close() error {
 		<-closed
 	}
 
+	if s.usage != nil {
+		s.usage.close()
+	}
 	return nil
 }
 
@@ -831,6 +875,8 @@ func (c *sclient) run(ctx context.Context) error {
 			err = c.handleFrameNotePreferred(ft, fl)
 		case frameSendPacket:
 			err = c.handleFrameSendPacket(ft, fl)
+		case frameSendPackets:
+			err = c.handleFrameSendPackets(ft, fl)
 		case frameForwardPacket:
 			err = c.handleFrameForwardPacket(ft, fl)
 		case frameWatchConns:
@@ -1002,6 +1048,31 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
+	return c.sendPacketTo(dstKey, contents)
+}
+
+// handleFrameSendPackets reads a frameSendPackets and sends each of its
+// packets on as if it had been sent in its own frameSendPacket.
+func (c *sclient) handleFrameSendPackets(ft frameType, fl uint32) error {
+	s := c.s
+
+	dstKey, pkts, err := s.recvPackets(c.br, fl)
+	if err != nil {
+		return fmt.Errorf("client %v: recvPackets: %v", c.key, err)
+	}
+	for _, contents := range pkts {
+		if err := c.sendPacketTo(dstKey, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendPacketTo sends contents, a packet from c, to dstKey: directly if
+// it's connected to this server, or via a mesh peer that it's connected
+// to.
+func (c *sclient) sendPacketTo(dstKey key.NodePublic, contents []byte) error {
+	s := c.s
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1180,6 +1251,11 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 		return nil
 	}
 
+	// static allowlist-based verification:
+	if s.verifyClientsKeys != nil && !s.verifyClientsKeys.Contains(clientKey) {
+		return fmt.Errorf("peer %v not authorized (not in allowed keys)", clientKey)
+	}
+
 	// tailscaled-based verification:
 	if s.verifyClientsLocalTailscaled {
 		_, err := localClient.WhoIsNodeKey(ctx, clientKey)
@@ -1232,6 +1308,13 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 		}
 		// TODO(bradfitz): add policy for configurable bandwidth rate per client?
 	}
+
+	// hook-based verification:
+	if s.verifyClientsFunc != nil {
+		if err := s.verifyClientsFunc(ctx, clientKey, clientIP); err != nil {
+			return fmt.Errorf("peer %v not authorized: %w", clientKey, err)
+		}
+	}
 	return nil
 }
 
@@ -1379,6 +1462,46 @@ func (s *Server) recvPacket(br *bufio.Reader, frameLen uint32) (dstKey key.NodeP
 	return dstKey, contents, nil
 }
 
+// recvPackets reads the destination and packets of a frameSendPackets
+// frame of length frameLen.
+func (s *Server) recvPackets(br *bufio.Reader, frameLen uint32) (dstKey key.NodePublic, pkts [][]byte, err error) {
+	if frameLen < keyLen {
+		return zpub, nil, errors.New("short send packets frame")
+	}
+	if frameLen-keyLen > MaxPacketsFrameSize {
+		return zpub, nil, fmt.Errorf("send packets frame longer (%d) than max of %v", frameLen-keyLen, MaxPacketsFrameSize)
+	}
+	if err := dstKey.ReadRawWithoutAllocating(br); err != nil {
+		return zpub, nil, err
+	}
+	b := make([]byte, frameLen-keyLen)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return zpub, nil, err
+	}
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return zpub, nil, errors.New("truncated packet length")
+		}
+		n := int(bin.Uint16(b))
+		b = b[2:]
+		if len(b) < n {
+			return zpub, nil, errors.New("truncated packet")
+		}
+		contents := b[:n:n]
+		b = b[n:]
+		pkts = append(pkts, contents)
+		s.packetsRecv.Add(1)
+		s.bytesRecv.Add(int64(len(contents)))
+		if disco.LooksLikeDiscoWrapper(contents) {
+			s.packetsRecvDisco.Add(1)
+		} else {
+			s.packetsRecvOther.Add(1)
+		}
+	}
+	s.packetsFramesRecv.Add(1)
+	return dstKey, pkts, nil
+}
+
 // zpub is the key.NodePublic zero value.
 var zpub key.NodePublic
 
@@ -1740,6 +1863,9 @@ func (c *sclient) sendPacket(srcKey key.NodePublic, contents []byte) (err error)
 		} else {
 			c.s.packetsSent.Add(1)
 			c.s.bytesSent.Add(int64(len(contents)))
+			if c.s.usage != nil {
+				c.s.usage.add(srcKey, c.key, len(contents))
+			}
 		}
 		c.debugLogf("sendPacket from %s: %v", srcKey.ShortString(), err)
 	}()
@@ -1937,6 +2063,7 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("counter_packets_received_kind", &s.packetsRecvByKind)
 	m.Set("packets_sent", &s.packetsSent)
 	m.Set("packets_received", &s.packetsRecv)
+	m.Set("counter_send_packets_frames_received", &s.packetsFramesRecv)
 	m.Set("unknown_frames", &s.unknownFrames)
 	m.Set("home_moves_in", &s.homeMovesIn)
 	m.Set("home_moves_out", &s.homeMovesOut)
@@ -16,9 +16,11 @@
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -712,6 +714,46 @@ func TestWatch(t *testing.T) {
 	w3.wantGone(t, c1.pub)
 }
 
+func TestSendPackets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	c1 := newRegularClient(t, ts, "c1")
+	c2 := newRegularClient(t, ts, "c2")
+	if !c1.c.CanSendPackets() {
+		t.Fatal("CanSendPackets = false; want true")
+	}
+
+	pkts := [][]byte{[]byte("hello"), {}, []byte("world")}
+	if err := c1.c.SendPackets(c2.pub, pkts); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range pkts {
+		m, err := c2.c.recvTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp, ok := m.(ReceivedPacket)
+		if !ok {
+			t.Fatalf("packet %d: got %T; want ReceivedPacket", i, m)
+		}
+		if rp.Source != c1.pub || string(rp.Data) != string(want) {
+			t.Errorf("packet %d: got %q from %v; want %q from %v", i, rp.Data, rp.Source, want, c1.pub)
+		}
+	}
+	if got := ts.s.packetsFramesRecv.Value(); got != 1 {
+		t.Errorf("packetsFramesRecv = %d; want 1", got)
+	}
+
+	big := make([]byte, MaxPacketsFrameSize)
+	if err := c1.c.SendPackets(c2.pub, [][]byte{big, big}); err == nil {
+		t.Error("SendPackets of oversized packets succeeded; want error")
+	}
+}
+
 type testFwd int
 
 func (testFwd) ForwardPacket(key.NodePublic, key.NodePublic, []byte) error {
@@ -1522,3 +1564,109 @@ func TestServerRepliesToPing(t *testing.T) {
 		}
 	}
 }
+
+func TestUsageAccounting(t *testing.T) {
+	s := NewServer(key.NewNode(), t.Logf)
+	var csvBuf bytes.Buffer
+	var reports []*UsageReport
+	prom := NewPrometheusUsageSink()
+	s.SetUsageAccounting(time.Hour, NewCSVUsageSink(&csvBuf, true), prom, usageSinkFunc(func(r *UsageReport) error {
+		reports = append(reports, r)
+		return nil
+	}))
+
+	a, b := pubAll(1), pubAll(2)
+	s.usage.add(a, b, 100)
+	s.usage.add(a, b, 50)
+	s.usage.add(b, a, 10)
+	s.Close() // exports the last report
+
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports; want 1", len(reports))
+	}
+	want := []UsageRecord{
+		{Src: a, Dst: b, Packets: 2, Bytes: 150},
+		{Src: b, Dst: a, Packets: 1, Bytes: 10},
+	}
+	if got := reports[0].Records; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %+v; want %+v", got, want)
+	}
+	if got, want := reports[0].ByClient()[a], (ClientUsage{PacketsSent: 2, BytesSent: 150, PacketsRecv: 1, BytesRecv: 10}); got != want {
+		t.Errorf("usage of a = %+v; want %+v", got, want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(csvBuf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "start,end,src,dst,packets,bytes" {
+		t.Fatalf("CSV = %q; want a header and 2 records", csvBuf.String())
+	}
+	if !strings.HasSuffix(lines[1], fmt.Sprintf(",%v,%v,2,150", a, b)) {
+		t.Errorf("first CSV record = %q; want 2 packets and 150 bytes from a to b", lines[1])
+	}
+
+	var promBuf bytes.Buffer
+	prom.WritePrometheus(&promBuf)
+	for _, want := range []string{
+		fmt.Sprintf("derp_usage_client_bytes_sent_total{key=%q} 150\n", a.String()),
+		fmt.Sprintf("derp_usage_client_bytes_recv_total{key=%q} 150\n", b.String()),
+		fmt.Sprintf("derp_usage_pair_packets_total{src=%q,dst=%q} 2\n", a.String(), b.String()),
+	} {
+		if !strings.Contains(promBuf.String(), want) {
+			t.Errorf("Prometheus output lacks %q; got:\n%s", want, promBuf.String())
+		}
+	}
+}
+
+type usageSinkFunc func(*UsageReport) error
+
+func (f usageSinkFunc) ExportUsage(r *UsageReport) error { return f(r) }
+
+func TestVerifyClient(t *testing.T) {
+	a, b := pubAll(1), pubAll(2)
+	clientIP := netip.MustParseAddr("1.2.3.4")
+	errDenied := errors.New("denied")
+	denyB := func(ctx context.Context, k key.NodePublic, ip netip.Addr) error {
+		if ip != clientIP {
+			t.Errorf("hook got IP %v; want %v", ip, clientIP)
+		}
+		if k == b {
+			return errDenied
+		}
+		return nil
+	}
+	mesh := &clientInfo{MeshKey: "abc"}
+
+	tests := []struct {
+		name    string
+		keys    []key.NodePublic
+		hook    VerifyClientFunc
+		client  key.NodePublic
+		info    *clientInfo
+		wantErr bool
+	}{
+		{name: "open", client: a},
+		{name: "allowed_key", keys: []key.NodePublic{a}, client: a},
+		{name: "unknown_key", keys: []key.NodePublic{a}, client: b, wantErr: true},
+		{name: "empty_keys", keys: []key.NodePublic{}, client: a, wantErr: true},
+		{name: "empty_keys_mesh_peer", keys: []key.NodePublic{}, client: a, info: mesh},
+		{name: "hook_allows", hook: denyB, client: a},
+		{name: "hook_denies", hook: denyB, client: b, wantErr: true},
+		{name: "hook_denies_mesh_peer", hook: denyB, client: b, info: mesh},
+		{name: "keys_and_hook", keys: []key.NodePublic{b}, hook: denyB, client: b, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(key.NewNode(), t.Logf)
+			defer s.Close()
+			s.SetMeshKey("abc")
+			s.SetVerifyClientKeys(tt.keys)
+			s.SetVerifyClientFunc(tt.hook)
+			err := s.verifyClient(context.Background(), tt.client, tt.info, clientIP)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyClient = %v; want error %v", err, tt.wantErr)
+			}
+			if tt.name == "hook_denies" && !errors.Is(err, errDenied) {
+				t.Errorf("verifyClient = %v; want it to wrap %v", err, errDenied)
+			}
+		})
+	}
+}
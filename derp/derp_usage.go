@@ -0,0 +1,325 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// UsageRecord is the traffic that a DERP server relayed from one client to
+// another during a UsageReport's period.
+//
+// Packets are accounted for by the server that delivers them to their
+// destination, so traffic between clients connected to different servers
+// of a mesh is only accounted for once, by the destination's server.
+type UsageRecord struct {
+	Src     key.NodePublic // zero for clients too old to send their key
+	Dst     key.NodePublic
+	Packets int64
+	Bytes   int64
+}
+
+// UsageReport is the traffic a DERP server relayed between Start and End.
+type UsageReport struct {
+	Start, End time.Time
+
+	// Records are the pairs of clients with traffic between them, sorted
+	// by Src then Dst.
+	Records []UsageRecord
+}
+
+// ClientUsage is the traffic a DERP server relayed from and to a client.
+type ClientUsage struct {
+	PacketsSent, BytesSent int64 // from the client
+	PacketsRecv, BytesRecv int64 // to the client
+}
+
+// ByClient returns the traffic in r summed up by client.
+func (r *UsageReport) ByClient() map[key.NodePublic]ClientUsage {
+	m := make(map[key.NodePublic]ClientUsage)
+	for _, rec := range r.Records {
+		if !rec.Src.IsZero() {
+			cu := m[rec.Src]
+			cu.PacketsSent += rec.Packets
+			cu.BytesSent += rec.Bytes
+			m[rec.Src] = cu
+		}
+		cu := m[rec.Dst]
+		cu.PacketsRecv += rec.Packets
+		cu.BytesRecv += rec.Bytes
+		m[rec.Dst] = cu
+	}
+	return m
+}
+
+// A UsageSink receives the periodic usage reports of a DERP server. See
+// Server.SetUsageAccounting.
+type UsageSink interface {
+	// ExportUsage exports r. It's called from a single goroutine, so
+	// implementations may take their time, but reports are not queued.
+	ExportUsage(r *UsageReport) error
+}
+
+type usagePair struct {
+	src, dst key.NodePublic
+}
+
+type usageCounts struct {
+	packets, bytes int64
+}
+
+// usageAccounting is the state of the usage accounting of a Server.
+type usageAccounting struct {
+	logf     logger.Logf
+	sinks    []UsageSink
+	interval time.Duration
+	stop     chan struct{} // closed by Server.Close
+	done     chan struct{} // closed when the export loop has exited
+
+	mu    sync.Mutex
+	start time.Time
+	pairs map[usagePair]*usageCounts
+}
+
+// SetUsageAccounting enables accounting for the traffic that s relays
+// between each pair of clients. Every interval, and when s is closed, the
+// traffic since the last export is reported to each of sinks.
+//
+// It must be called before s accepts connections.
+func (s *Server) SetUsageAccounting(interval time.Duration, sinks ...UsageSink) {
+	if interval <= 0 || len(sinks) == 0 {
+		panic("derp: SetUsageAccounting needs a positive interval and a sink")
+	}
+	if s.usage != nil {
+		panic("derp: SetUsageAccounting called twice")
+	}
+	u := &usageAccounting{
+		logf:     s.logf,
+		sinks:    sinks,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		start:    s.clock.Now(),
+		pairs:    make(map[usagePair]*usageCounts),
+	}
+	s.usage = u
+	go u.exportLoop(s.clock.Now)
+}
+
+// add records that a packet of n bytes was relayed from src to dst.
+func (u *usageAccounting) add(src, dst key.NodePublic, n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	p := usagePair{src, dst}
+	c := u.pairs[p]
+	if c == nil {
+		c = new(usageCounts)
+		u.pairs[p] = c
+	}
+	c.packets++
+	c.bytes += int64(n)
+}
+
+// takeReport returns the traffic since the last report and starts a new
+// period at now.
+func (u *usageAccounting) takeReport(now time.Time) *UsageReport {
+	u.mu.Lock()
+	pairs := u.pairs
+	r := &UsageReport{Start: u.start, End: now}
+	u.start = now
+	u.pairs = make(map[usagePair]*usageCounts, len(pairs))
+	u.mu.Unlock()
+
+	r.Records = make([]UsageRecord, 0, len(pairs))
+	for p, c := range pairs {
+		r.Records = append(r.Records, UsageRecord{
+			Src:     p.src,
+			Dst:     p.dst,
+			Packets: c.packets,
+			Bytes:   c.bytes,
+		})
+	}
+	slices.SortFunc(r.Records, func(a, b UsageRecord) int {
+		return cmp.Or(a.Src.Compare(b.Src), a.Dst.Compare(b.Dst))
+	})
+	return r
+}
+
+func (u *usageAccounting) exportLoop(now func() time.Time) {
+	defer close(u.done)
+	t := time.NewTicker(u.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			u.export(u.takeReport(now()))
+		case <-u.stop:
+			u.export(u.takeReport(now()))
+			return
+		}
+	}
+}
+
+func (u *usageAccounting) export(r *UsageReport) {
+	for _, sink := range u.sinks {
+		if err := sink.ExportUsage(r); err != nil {
+			u.logf("derp: exporting usage to %T: %v", sink, err)
+		}
+	}
+}
+
+// close stops u, after exporting the traffic since the last report.
+func (u *usageAccounting) close() {
+	close(u.stop)
+	<-u.done
+}
+
+// CSVUsageSink is a UsageSink that writes usage reports as CSV, one
+// record per line with the columns "start", "end", "src", "dst",
+// "packets" and "bytes". Times are in RFC 3339 format.
+type CSVUsageSink struct {
+	mu          sync.Mutex
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVUsageSink returns a CSVUsageSink writing to w. If header is true,
+// the column names are written before the first record, which callers
+// appending to an existing file should skip.
+func NewCSVUsageSink(w io.Writer, header bool) *CSVUsageSink {
+	return &CSVUsageSink{
+		w:           csv.NewWriter(w),
+		wroteHeader: !header,
+	}
+}
+
+// ExportUsage implements UsageSink.
+func (s *CSVUsageSink) ExportUsage(r *UsageReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.wroteHeader {
+		s.w.Write([]string{"start", "end", "src", "dst", "packets", "bytes"})
+		s.wroteHeader = true
+	}
+	start, end := r.Start.UTC().Format(time.RFC3339), r.End.UTC().Format(time.RFC3339)
+	for _, rec := range r.Records {
+		s.w.Write([]string{
+			start,
+			end,
+			rec.Src.String(),
+			rec.Dst.String(),
+			strconv.FormatInt(rec.Packets, 10),
+			strconv.FormatInt(rec.Bytes, 10),
+		})
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// PrometheusUsageSink is a UsageSink that sums up usage reports into
+// counters, which it serves over HTTP in the Prometheus text format:
+// derp_usage_client_{packets,bytes}_{sent,recv}_total by client key, and
+// derp_usage_pair_{packets,bytes}_total by source and destination key.
+//
+// It keeps a counter for each client and pair that ever had traffic, so
+// it's meant for DERP servers with a bounded set of clients, such as
+// private ones.
+type PrometheusUsageSink struct {
+	mu      sync.Mutex
+	clients map[key.NodePublic]ClientUsage
+	pairs   map[usagePair]usageCounts
+}
+
+// NewPrometheusUsageSink returns a new PrometheusUsageSink.
+func NewPrometheusUsageSink() *PrometheusUsageSink {
+	return &PrometheusUsageSink{
+		clients: make(map[key.NodePublic]ClientUsage),
+		pairs:   make(map[usagePair]usageCounts),
+	}
+}
+
+// ExportUsage implements UsageSink.
+func (s *PrometheusUsageSink) ExportUsage(r *UsageReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, cu := range r.ByClient() {
+		tot := s.clients[k]
+		tot.PacketsSent += cu.PacketsSent
+		tot.BytesSent += cu.BytesSent
+		tot.PacketsRecv += cu.PacketsRecv
+		tot.BytesRecv += cu.BytesRecv
+		s.clients[k] = tot
+	}
+	for _, rec := range r.Records {
+		p := usagePair{rec.Src, rec.Dst}
+		tot := s.pairs[p]
+		tot.packets += rec.Packets
+		tot.bytes += rec.Bytes
+		s.pairs[p] = tot
+	}
+	return nil
+}
+
+// ServeHTTP serves the counters in the Prometheus text format.
+func (s *PrometheusUsageSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.WritePrometheus(w)
+}
+
+// WritePrometheus writes the counters to w in the Prometheus text format.
+func (s *PrometheusUsageSink) WritePrometheus(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients := make([]key.NodePublic, 0, len(s.clients))
+	for k := range s.clients {
+		clients = append(clients, k)
+	}
+	slices.SortFunc(clients, key.NodePublic.Compare)
+	for _, m := range []struct {
+		name string
+		val  func(ClientUsage) int64
+	}{
+		{"derp_usage_client_packets_sent_total", func(cu ClientUsage) int64 { return cu.PacketsSent }},
+		{"derp_usage_client_bytes_sent_total", func(cu ClientUsage) int64 { return cu.BytesSent }},
+		{"derp_usage_client_packets_recv_total", func(cu ClientUsage) int64 { return cu.PacketsRecv }},
+		{"derp_usage_client_bytes_recv_total", func(cu ClientUsage) int64 { return cu.BytesRecv }},
+	} {
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		for _, k := range clients {
+			fmt.Fprintf(w, "%s{key=%q} %d\n", m.name, k.String(), m.val(s.clients[k]))
+		}
+	}
+
+	pairs := make([]usagePair, 0, len(s.pairs))
+	for p := range s.pairs {
+		pairs = append(pairs, p)
+	}
+	slices.SortFunc(pairs, func(a, b usagePair) int {
+		return cmp.Or(a.src.Compare(b.src), a.dst.Compare(b.dst))
+	})
+	for _, m := range []struct {
+		name string
+		val  func(usageCounts) int64
+	}{
+		{"derp_usage_pair_packets_total", func(c usageCounts) int64 { return c.packets }},
+		{"derp_usage_pair_bytes_total", func(c usageCounts) int64 { return c.bytes }},
+	} {
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		for _, p := range pairs {
+			fmt.Fprintf(w, "%s{src=%q,dst=%q} %d\n", m.name, p.src.String(), p.dst.String(), m.val(s.pairs[p]))
+		}
+	}
+}
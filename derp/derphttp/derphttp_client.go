@@ -43,6 +43,8 @@
 	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
 )
 
 // Client is a DERP-over-HTTP client.
@@ -69,6 +71,28 @@ type Client struct {
 	// In either case, additional timeouts may be added to the base context.
 	BaseContext func() context.Context
 
+	// ConnectTimeout, if non-zero, is the maximum time to spend making a
+	// connection, if the context it's made with doesn't limit it further.
+	// If zero, DefaultConnectTimeout is used.
+	ConnectTimeout time.Duration
+
+	// PlaintextFallback, if non-nil, reports whether a region client may
+	// connect to a DERP node over unencrypted HTTP on port 80 after a TLS
+	// handshake with it fails, as happens on networks that block or
+	// intercept TLS to unknown hosts. The fallback is only used for nodes
+	// with CanPort80 set whose server public key was learned over an
+	// earlier TLS connection, by this process or one whose keys were
+	// restored with AddServerKeyPins; the plaintext connection is refused unless
+	// the server proves it holds that key, by sealing its server info to
+	// us with it, before it's reported as connected. DERP's own NaCl
+	// encryption of its handshake, and the end-to-end encryption of the
+	// packets it relays, still apply.
+	PlaintextFallback func() bool
+
+	// DisableIPv4 and DisableIPv6, if true, prevent the Client from
+	// dialing DERP nodes over IPv4 or IPv6 respectively.
+	DisableIPv4, DisableIPv6 bool
+
 	privateKey key.NodePrivate
 	logf       logger.Logf
 	netMon     *netmon.Monitor // always non-nil
@@ -98,6 +122,9 @@ type Client struct {
 	connGen      int // incremented once per new connection; valid values are >0
 	serverPubKey key.NodePublic
 	tlsState     *tls.ConnectionState
+	serverInfo   *derp.ServerInfoMessage          // for PlaintextFallback, the server info read by connect, until Recv returns it
+	tlsFailed    map[string]time.Time             // DERP node name => when a TLS handshake with it last failed, for PlaintextFallback
+	upFailed     map[string]time.Time             // DERP node name => when a DERP HTTP upgrade with it last failed, for the WebSocket fallback
 	pingOut      map[derp.PingMessage]chan<- bool // chan to send to on pong
 	clock        tstime.Clock
 }
@@ -108,6 +135,8 @@ type ConnectedState struct {
 	Connecting bool
 	Closed     bool
 	LocalAddr  netip.AddrPort // if Connected
+	Plaintext  bool           // if Connected, whether using the unencrypted PlaintextFallback
+	WebSocket  bool           // if Connected, whether using the WebSocket fallback
 }
 
 func (c *Client) String() string {
@@ -190,6 +219,18 @@ func (c *Client) Connect(ctx context.Context) error {
 	return err
 }
 
+// DefaultConnectTimeout is the default maximum time (if the context doesn't
+// limit it further) for a Client to do all of: DNS + TCP + TLS + HTTP
+// Upgrade + DERP upgrade. See Client.ConnectTimeout.
+const DefaultConnectTimeout = 10 * time.Second
+
+func (c *Client) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
 // newContext returns a new context for setting up a new DERP connection.
 // It uses either c.BaseContext or returns context.Background.
 func (c *Client) newContext() context.Context {
@@ -242,6 +283,90 @@ func urlPort(u *url.URL) string {
 	return ""
 }
 
+// plaintextFallbackTLSRetry is how long after a failed TLS handshake with a
+// DERP node a Client connects to it using PlaintextFallback, before trying
+// TLS again.
+const plaintextFallbackTLSRetry = 5 * time.Minute
+
+// plaintextFallbackPort is the port dialed for PlaintextFallback. It's only
+// changed by tests.
+var plaintextFallbackPort = "80"
+
+// pinnedServerKeys maps DERP node hostnames to the server public keys
+// learned from them over TLS, to verify the server's identity when
+// connecting with PlaintextFallback. It's shared by all Clients, as
+// region clients come and go as the home region changes.
+var pinnedServerKeys syncs.Map[string, key.NodePublic]
+
+// ServerKeyPins returns the server public keys learned over TLS from DERP
+// nodes, by hostname, to save across restarts and restore with
+// AddServerKeyPins, so that PlaintextFallback also works when TLS is blocked
+// from the start.
+func ServerKeyPins() map[string]key.NodePublic {
+	pins := map[string]key.NodePublic{}
+	pinnedServerKeys.Range(func(host string, k key.NodePublic) bool {
+		pins[host] = k
+		return true
+	})
+	return pins
+}
+
+// AddServerKeyPins adds pins, as returned by ServerKeyPins in an earlier
+// run, to the server keys used to verify DERP nodes connected to with
+// PlaintextFallback. Keys learned over TLS since take precedence.
+func AddServerKeyPins(pins map[string]key.NodePublic) {
+	for host, k := range pins {
+		if !k.IsZero() {
+			pinnedServerKeys.LoadOrStore(host, k)
+		}
+	}
+}
+
+// plaintextFallbackLocked reports whether c should connect to node n over
+// unencrypted HTTP on port 80, having recently failed a TLS handshake with
+// it. See PlaintextFallback.
+//
+// c.mu must be held.
+func (c *Client) plaintextFallbackLocked(n *tailcfg.DERPNode, now time.Time) bool {
+	if !c.canPlaintextFallback(n) {
+		return false
+	}
+	failed, ok := c.tlsFailed[n.Name]
+	return ok && now.Sub(failed) < plaintextFallbackTLSRetry
+}
+
+// canPlaintextFallback reports whether PlaintextFallback is enabled and
+// may be used for node n.
+func (c *Client) canPlaintextFallback(n *tailcfg.DERPNode) bool {
+	if c.url != nil || n == nil || !n.CanPort80 || !c.useHTTPS() {
+		return false
+	}
+	if c.PlaintextFallback == nil || !c.PlaintextFallback() {
+		return false
+	}
+	_, ok := pinnedServerKeys.Load(n.HostName)
+	return ok
+}
+
+// websocketFallbackUpgradeRetry is how long after a failed DERP HTTP upgrade
+// with a DERP node a Client connects to it using WebSocket framing, before
+// trying the DERP upgrade again.
+const websocketFallbackUpgradeRetry = 5 * time.Minute
+
+// websocketFallbackLocked reports whether c should speak DERP to node n
+// inside a WebSocket connection, having recently failed to upgrade an HTTP
+// connection with it to DERP. That happens behind middleboxes that reject
+// or strip Upgrade headers other than the standard WebSocket one.
+//
+// c.mu must be held.
+func (c *Client) websocketFallbackLocked(n *tailcfg.DERPNode, now time.Time) bool {
+	if dialWebsocketOverConnFunc == nil || n == nil {
+		return false
+	}
+	failed, ok := c.upFailed[n.Name]
+	return ok && now.Sub(failed) < websocketFallbackUpgradeRetry
+}
+
 // debugDERPUseHTTP tells clients to connect to DERP via HTTP on port
 // 3340 instead of HTTPS on 443.
 var debugUseDERPHTTP = envknob.RegisterBool("TS_DEBUG_USE_DERP_HTTP")
@@ -312,6 +437,11 @@ func (c *Client) preferIPv6() bool {
 // dialWebsocketFunc is non-nil (set by websocket.go's init) when compiled in.
 var dialWebsocketFunc func(ctx context.Context, urlStr string) (net.Conn, error)
 
+// dialWebsocketOverConnFunc is non-nil (set by websocket_fallback.go's init)
+// when compiled in. It does a WebSocket handshake with urlStr over nc, an
+// already established TCP or TLS connection.
+var dialWebsocketOverConnFunc func(ctx context.Context, urlStr string, nc net.Conn) (net.Conn, error)
+
 func useWebsockets() bool {
 	if runtime.GOOS == "js" {
 		return true
@@ -336,14 +466,13 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	defer func() {
 		if err != nil {
 			c.atomicState.Store(ConnectedState{Connecting: false})
+			metricConnectErrors.Add(1)
+		} else {
+			metricConnects.Add(1)
 		}
 	}()
 
-	// timeout is the fallback maximum time (if ctx doesn't limit
-	// it further) to do all of: DNS + TCP + TLS + HTTP Upgrade +
-	// DERP upgrade.
-	const timeout = 10 * time.Second
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.connectTimeout())
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -382,6 +511,8 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 
 	var node *tailcfg.DERPNode // nil when using c.url to dial
 	var idealNodeInRegion bool
+	var plaintext bool  // using PlaintextFallback
+	var wsFallback bool // using WebSocket framing after a failed DERP upgrade
 	switch {
 	case useWebsockets():
 		var urlStr string
@@ -401,6 +532,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 			derp.MeshKey(c.MeshKey),
 			derp.CanAckPings(c.canAckPings),
 			derp.IsProber(c.IsProber),
+			derp.Clock(c.clock),
 		)
 		if err != nil {
 			return nil, 0, err
@@ -421,8 +553,15 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		tcpConn, err = c.dialURL(ctx)
 	default:
 		c.logf("%s: connecting to derp-%d (%v)", caller, reg.RegionID, reg.RegionCode)
-		tcpConn, node, err = c.dialRegion(ctx, reg)
+		now := c.clock.Now()
+		usePlaintext := func(n *tailcfg.DERPNode) bool { return c.plaintextFallbackLocked(n, now) }
+		tcpConn, node, err = c.dialRegion(ctx, reg, usePlaintext)
 		idealNodeInRegion = err == nil && reg.Nodes[0] == node
+		plaintext = err == nil && usePlaintext(node)
+		if plaintext {
+			c.logf("%s: using unencrypted HTTP fallback to %v", caller, node.HostName)
+		}
+		wsFallback = err == nil && c.websocketFallbackLocked(node, now)
 	}
 	if err != nil {
 		return nil, 0, err
@@ -456,7 +595,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	var serverPub key.NodePublic // or zero if unknown (if not using TLS or TLS middlebox eats it)
 	var serverProtoVersion int
 	var tlsState *tls.ConnectionState
-	if c.useHTTPS() {
+	if c.useHTTPS() && !plaintext {
 		tlsConn := c.tlsClient(tcpConn, node)
 		httpConn = tlsConn
 
@@ -464,6 +603,10 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		// be done implicitly on read/write) so we can check
 		// the ConnectionState.
 		if err := tlsConn.Handshake(); err != nil {
+			if c.canPlaintextFallback(node) {
+				c.logf("%s: TLS handshake with %v failed; falling back to unencrypted HTTP on reconnect", caller, node.HostName)
+				mak.Set(&c.tlsFailed, node.Name, c.clock.Now())
+			}
 			return nil, 0, err
 		}
 
@@ -487,10 +630,23 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		httpConn = tcpConn
 	}
 
+	urlStr := c.urlString(node)
+	if plaintext {
+		urlStr = fmt.Sprintf("http://%s/derp", node.HostName)
+	}
+	if wsFallback {
+		c.logf("%s: using WebSocket fallback to %v", caller, node.HostName)
+		wsConn, err := dialWebsocketOverConnFunc(ctx, urlStr, httpConn)
+		if err != nil {
+			return nil, 0, err
+		}
+		httpConn = wsConn
+	}
+
 	brw := bufio.NewReadWriter(bufio.NewReader(httpConn), bufio.NewWriter(httpConn))
 	var derpClient *derp.Client
 
-	req, err := http.NewRequest("GET", c.urlString(node), nil)
+	req, err := http.NewRequest("GET", urlStr, nil)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -509,7 +665,20 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		// https://github.com/tailscale/tailscale/issues/12724
 	}
 
-	if !serverPub.IsZero() && serverProtoVersion != 0 {
+	// upgradeFailed records that the DERP upgrade failed, so that the
+	// next connection to node uses the WebSocket fallback.
+	upgradeFailed := func() {
+		if dialWebsocketOverConnFunc != nil && node != nil {
+			c.logf("%s: DERP upgrade with %v failed; using WebSocket on reconnect", caller, node.HostName)
+			mak.Set(&c.upFailed, node.Name, c.clock.Now())
+		}
+	}
+
+	switch {
+	case wsFallback:
+		// The WebSocket handshake routed us to the server's DERP
+		// handler, so there's no upgrade request to send.
+	case !serverPub.IsZero() && serverProtoVersion != 0:
 		// parseMetaCert found the server's public key (no TLS
 		// middlebox was in the way), so skip the HTTP upgrade
 		// exchange.  See https://github.com/tailscale/tailscale/issues/693
@@ -523,7 +692,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		}
 		// No need to flush the HTTP request. the derp.Client's initial
 		// client auth frame will flush it.
-	} else {
+	default:
 		if err := req.Write(brw); err != nil {
 			return nil, 0, err
 		}
@@ -533,23 +702,55 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 
 		resp, err := http.ReadResponse(brw.Reader, req)
 		if err != nil {
+			upgradeFailed()
 			return nil, 0, err
 		}
 		if resp.StatusCode != http.StatusSwitchingProtocols {
+			upgradeFailed()
 			b, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			return nil, 0, fmt.Errorf("GET failed: %v: %s", err, b)
 		}
+		if node != nil {
+			delete(c.upFailed, node.Name)
+		}
 	}
 	derpClient, err = derp.NewClient(c.privateKey, httpConn, brw, c.logf,
 		derp.MeshKey(c.MeshKey),
 		derp.ServerPublicKey(serverPub),
 		derp.CanAckPings(c.canAckPings),
 		derp.IsProber(c.IsProber),
+		derp.Clock(c.clock),
 	)
 	if err != nil {
 		return nil, 0, err
 	}
+	var serverInfo *derp.ServerInfoMessage
+	if plaintext {
+		// Without TLS, the server is only authenticated by its DERP key,
+		// which must match the one learned over TLS. The key in the
+		// server's greeting isn't authenticated by itself, so also wait
+		// for its server info: a server that doesn't hold the private key
+		// can't seal it to us.
+		if pinned, _ := pinnedServerKeys.Load(node.HostName); derpClient.ServerPublicKey() != pinned {
+			go httpConn.Close()
+			return nil, 0, fmt.Errorf("unencrypted HTTP fallback: server key %v doesn't match %v learned over TLS", derpClient.ServerPublicKey().ShortString(), pinned.ShortString())
+		}
+		m, err := derpClient.Recv()
+		if err != nil {
+			go httpConn.Close()
+			return nil, 0, fmt.Errorf("unencrypted HTTP fallback: %w", err)
+		}
+		si, ok := m.(derp.ServerInfoMessage)
+		if !ok {
+			go httpConn.Close()
+			return nil, 0, fmt.Errorf("unencrypted HTTP fallback: got %T before server info", m)
+		}
+		serverInfo = &si
+	} else if tlsState != nil && node != nil {
+		pinnedServerKeys.Store(node.HostName, derpClient.ServerPublicKey())
+		delete(c.tlsFailed, node.Name)
+	}
 	if c.preferred {
 		if err := derpClient.NotePreferred(true); err != nil {
 			go httpConn.Close()
@@ -568,12 +769,15 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	c.client = derpClient
 	c.netConn = tcpConn
 	c.tlsState = tlsState
+	c.serverInfo = serverInfo
 	c.connGen++
 
 	localAddr, _ := c.client.LocalAddr()
 	c.atomicState.Store(ConnectedState{
 		Connected: true,
 		LocalAddr: localAddr,
+		Plaintext: plaintext,
+		WebSocket: wsFallback,
 	})
 	return c.client, c.connGen, nil
 }
@@ -618,7 +822,10 @@ func (c *Client) dialURL(ctx context.Context) (net.Conn, error) {
 // dialRegion returns a TCP connection to the provided region, trying
 // each node in order (with dialNode) until one connects or ctx is
 // done.
-func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.Conn, *tailcfg.DERPNode, error) {
+//
+// If usePlaintext is non-nil, nodes for which it returns true are dialed
+// on port 80 for PlaintextFallback instead.
+func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion, usePlaintext func(*tailcfg.DERPNode) bool) (net.Conn, *tailcfg.DERPNode, error) {
 	if len(reg.Nodes) == 0 {
 		return nil, nil, fmt.Errorf("no nodes for %s", c.targetString(reg))
 	}
@@ -630,7 +837,11 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 			}
 			continue
 		}
-		c, err := c.dialNode(ctx, n)
+		dial := c.dialNode
+		if usePlaintext != nil && usePlaintext(n) {
+			dial = c.dialNodePlaintext
+		}
+		c, err := dial(ctx, n)
 		if err == nil {
 			return c, n, nil
 		}
@@ -643,6 +854,14 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 
 func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
 	tlsConf := tlsdial.Config(c.tlsServerName(node), c.HealthTracker, c.TLSConfig)
+	if len(tlsConf.NextProtos) == 0 {
+		// Offer HTTP/1.1 with ALPN, as browsers do. Some middleboxes
+		// reset TLS connections whose ClientHello doesn't look like a
+		// browser's, and a missing ALPN extension is the most obvious
+		// difference. Browsers also offer h2, but DERP's upgrade
+		// requires HTTP/1.1.
+		tlsConf.NextProtos = []string{"http/1.1"}
+	}
 	if node != nil {
 		if node.InsecureForTests {
 			tlsConf.InsecureSkipVerify = true
@@ -661,7 +880,7 @@ func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
 // in the DERP map. TLS is initiated on the first node where a socket is
 // established.
 func (c *Client) DialRegionTLS(ctx context.Context, reg *tailcfg.DERPRegion) (tlsConn *tls.Conn, connClose io.Closer, node *tailcfg.DERPNode, err error) {
-	tcpConn, node, err := c.dialRegion(ctx, reg)
+	tcpConn, node, err := c.dialRegion(ctx, reg, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -725,7 +944,23 @@ func (c *Client) dialNode(ctx context.Context, n *tailcfg.DERPNode) (net.Conn, e
 	if proxyURL, err := tshttpproxy.ProxyFromEnvironment(proxyReq); err == nil && proxyURL != nil {
 		return c.dialNodeUsingProxy(ctx, n, proxyURL)
 	}
+	port := "443"
+	if n.DERPPort != 0 {
+		port = fmt.Sprint(n.DERPPort)
+	}
+	return c.dialNodePort(ctx, n, port)
+}
+
+// dialNodePlaintext returns a TCP connection to port 80 of node n, for
+// PlaintextFallback. HTTP proxies aren't used, as they'd need to be asked
+// to CONNECT to port 80.
+func (c *Client) dialNodePlaintext(ctx context.Context, n *tailcfg.DERPNode) (net.Conn, error) {
+	return c.dialNodePort(ctx, n, plaintextFallbackPort)
+}
 
+// dialNodePort is like dialNode, but dials port directly without any
+// HTTP proxy.
+func (c *Client) dialNodePort(ctx context.Context, n *tailcfg.DERPNode, port string) (net.Conn, error) {
 	type res struct {
 		c   net.Conn
 		err error
@@ -753,10 +988,6 @@ type res struct {
 				}
 			}
 			dst := cmp.Or(dstPrimary, n.HostName)
-			port := "443"
-			if n.DERPPort != 0 {
-				port = fmt.Sprint(n.DERPPort)
-			}
 			c, err := c.dialContext(ctx, proto, net.JoinHostPort(dst, port))
 			select {
 			case resc <- res{c, err}:
@@ -767,13 +998,16 @@ type res struct {
 			}
 		}()
 	}
-	if shouldDialProto(n.IPv4, netip.Addr.Is4) {
+	if !c.DisableIPv4 && shouldDialProto(n.IPv4, netip.Addr.Is4) {
 		startDial(n.IPv4, "tcp4")
 	}
-	if shouldDialProto(n.IPv6, netip.Addr.Is6) {
+	if !c.DisableIPv6 && shouldDialProto(n.IPv6, netip.Addr.Is6) {
 		startDial(n.IPv6, "tcp6")
 	}
 	if nwait == 0 {
+		if c.DisableIPv4 || c.DisableIPv6 {
+			return nil, errors.New("node has no addresses in an enabled address family")
+		}
 		return nil, errors.New("both IPv4 and IPv6 are explicitly disabled for node")
 	}
 
@@ -880,6 +1114,19 @@ func (c *Client) Send(dstKey key.NodePublic, b []byte) error {
 	return err
 }
 
+// SendPackets sends pkts to dstKey, coalesced into one frame if the
+// server supports it. See derp.Client.SendPackets.
+func (c *Client) SendPackets(dstKey key.NodePublic, pkts [][]byte) error {
+	client, _, err := c.connect(c.newContext(), "derphttp.Client.SendPackets")
+	if err != nil {
+		return err
+	}
+	if err := client.SendPackets(dstKey, pkts); err != nil {
+		c.closeForReconnect(client)
+	}
+	return err
+}
+
 func (c *Client) registerPing(m derp.PingMessage, ch chan<- bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -954,6 +1201,13 @@ func (c *Client) SendPing(data [8]byte) error {
 	return client.SendPing(data)
 }
 
+// UsingPlaintextFallback reports whether c is connected over unencrypted
+// HTTP, per PlaintextFallback, without any implicit connect or reconnect.
+func (c *Client) UsingPlaintextFallback() bool {
+	st := c.atomicState.Load()
+	return st.Connected && st.Plaintext
+}
+
 // LocalAddr reports c's local TCP address, without any implicit
 // connect or reconnect.
 func (c *Client) LocalAddr() (netip.AddrPort, error) {
@@ -1002,6 +1256,13 @@ func (c *Client) SendPong(data [8]byte) error {
 	return dc.SendPong(data)
 }
 
+// SetClock sets the clock used for c's timers and those of the DERP
+// connections it makes. It's for tests that want to control time and
+// must be called before c is used.
+func (c *Client) SetClock(clock tstime.Clock) {
+	c.clock = clock
+}
+
 // SetCanAckPings sets whether this client will reply to ping requests from the server.
 //
 // This only affects future connections.
@@ -1059,6 +1320,9 @@ func (c *Client) RecvDetail() (m derp.ReceivedMessage, connGen int, err error) {
 	if err != nil {
 		return nil, 0, err
 	}
+	if si, ok := c.takeServerInfo(connGen); ok {
+		return si, connGen, nil
+	}
 	for {
 		m, err = client.Recv()
 		switch m := m.(type) {
@@ -1077,6 +1341,19 @@ func (c *Client) RecvDetail() (m derp.ReceivedMessage, connGen int, err error) {
 	}
 }
 
+// takeServerInfo returns the server info that connect read to authenticate
+// the server of connection connGen, if any and not yet returned by Recv.
+func (c *Client) takeServerInfo(connGen int) (_ derp.ServerInfoMessage, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.serverInfo == nil || c.connGen != connGen {
+		return derp.ServerInfoMessage{}, false
+	}
+	si := *c.serverInfo
+	c.serverInfo = nil
+	return si, true
+}
+
 func (c *Client) isClosed() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -1119,6 +1396,7 @@ func (c *Client) closeForReconnect(brokenClient *derp.Client) {
 	if c.client != brokenClient {
 		return
 	}
+	metricBrokenConns.Add(1)
 	if c.netConn != nil {
 		c.netConn.Close()
 		c.netConn = nil
@@ -1128,6 +1406,12 @@ func (c *Client) closeForReconnect(brokenClient *derp.Client) {
 
 var ErrClientClosed = errors.New("derphttp.Client closed")
 
+var (
+	metricConnects      = clientmetric.NewCounter("derphttp_client_connects")
+	metricConnectErrors = clientmetric.NewCounter("derphttp_client_connect_errors")
+	metricBrokenConns   = clientmetric.NewCounter("derphttp_client_broken_conns")
+)
+
 func parseMetaCert(certs []*x509.Certificate) (serverPub key.NodePublic, serverProtoVersion int) {
 	for _, cert := range certs {
 		// Look for derpkey prefix added by initMetacert() on the server side.
@@ -18,6 +18,10 @@
 // following its HTTP request.
 const fastStartHeader = "Derp-Fast-Start"
 
+// serveWebSocketFunc is non-nil (set by websocket_server.go's init) when
+// serving DERP over WebSocket is compiled in.
+var serveWebSocketFunc func(s *derp.Server, w http.ResponseWriter, r *http.Request)
+
 // Handler returns an http.Handler to be mounted at /derp, serving s.
 func Handler(s *derp.Server) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +44,14 @@ func Handler(s *derp.Server) http.Handler {
 			return
 		}
 
+		// Very early versions of Tailscale set "Upgrade: WebSocket" but didn't actually
+		// speak WebSockets (they still assumed DERP's binary framing). So to distinguish
+		// clients that actually want WebSockets, look for an explicit "derp" subprotocol.
+		if up == "websocket" && serveWebSocketFunc != nil && strings.Contains(r.Header.Get("Sec-Websocket-Protocol"), "derp") {
+			serveWebSocketFunc(s, w, r)
+			return
+		}
+
 		fastStart := r.Header.Get(fastStartHeader) == "1"
 
 		h, ok := w.(http.Hijacker)
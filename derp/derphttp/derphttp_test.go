@@ -7,16 +7,21 @@
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"tailscale.com/derp"
 	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
 
@@ -465,6 +470,40 @@ func TestLocalAddrNoMutex(t *testing.T) {
 	}
 }
 
+func TestConnectTimeout(t *testing.T) {
+	// A server that accepts TCP connections but never answers the HTTP
+	// upgrade request.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	c, err := NewClient(key.NewNode(), "http://"+ln.Addr().String()+"/derp", t.Logf, netmon.NewStatic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ConnectTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := c.Connect(context.Background()); err == nil {
+		t.Fatal("Connect succeeded; want error")
+	}
+	if d := time.Since(start); d > DefaultConnectTimeout/2 {
+		t.Errorf("Connect took %v; want it bounded by ConnectTimeout", d)
+	}
+}
+
 func TestProbe(t *testing.T) {
 	h := Handler(nil)
 
@@ -485,3 +524,244 @@ func TestProbe(t *testing.T) {
 		}
 	}
 }
+
+func TestPlaintextFallback(t *testing.T) {
+	serverPrivateKey := key.NewNode()
+	s := derp.NewServer(serverPrivateKey, t.Logf)
+	defer s.Close()
+
+	tlsSrv := httptest.NewUnstartedServer(Handler(s))
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	plainSrv := httptest.NewServer(Handler(s))
+	defer plainSrv.Close()
+
+	// blockedLn accepts TCP connections and closes them, like a network
+	// blocking TLS to unknown hosts.
+	blockedLn, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockedLn.Close()
+	go func() {
+		for {
+			c, err := blockedLn.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	port := func(addr string) int {
+		ap := netip.MustParseAddrPort(addr)
+		return int(ap.Port())
+	}
+	_, plainPort, _ := net.SplitHostPort(plainSrv.Listener.Addr().String())
+	defer func(old string) { plaintextFallbackPort = old }(plaintextFallbackPort)
+	plaintextFallbackPort = plainPort
+
+	const hostName = "127.0.0.1"
+	newClient := func(derpPort int, fallback bool) *Client {
+		t.Helper()
+		region := &tailcfg.DERPRegion{
+			RegionID:   1,
+			RegionCode: "test",
+			Nodes: []*tailcfg.DERPNode{{
+				Name:             "1a",
+				RegionID:         1,
+				HostName:         hostName,
+				IPv4:             hostName,
+				IPv6:             "none",
+				DERPPort:         derpPort,
+				CanPort80:        true,
+				InsecureForTests: true,
+			}},
+		}
+		c := NewRegionClient(key.NewNode(), t.Logf, netmon.NewStatic(), func() *tailcfg.DERPRegion { return region })
+		c.PlaintextFallback = func() bool { return fallback }
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ctx := context.Background()
+	blockedPort := port(blockedLn.Addr().String())
+
+	t.Run("no-pinned-key", func(t *testing.T) {
+		pinnedServerKeys.Delete(hostName)
+		c := newClient(blockedPort, true)
+		for range 2 {
+			if err := c.Connect(ctx); err == nil {
+				t.Fatal("Connect succeeded without a pinned server key")
+			}
+		}
+	})
+
+	// Connecting over TLS pins the server's key.
+	c := newClient(port(tlsSrv.Listener.Addr().String()), true)
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("TLS Connect: %v", err)
+	}
+	if c.UsingPlaintextFallback() {
+		t.Error("UsingPlaintextFallback over TLS")
+	}
+	if got, _ := pinnedServerKeys.Load(hostName); got != serverPrivateKey.Public() {
+		t.Fatalf("pinned key = %v; want %v", got, serverPrivateKey.Public())
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		c := newClient(blockedPort, false)
+		for range 2 {
+			if err := c.Connect(ctx); err == nil {
+				t.Fatal("Connect succeeded with fallback disabled")
+			}
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		c := newClient(blockedPort, true)
+		if err := c.Connect(ctx); err == nil {
+			t.Fatal("first Connect succeeded; want TLS failure")
+		}
+		if err := c.Connect(ctx); err != nil {
+			t.Fatalf("plaintext Connect: %v", err)
+		}
+		if !c.UsingPlaintextFallback() {
+			t.Error("UsingPlaintextFallback = false; want true")
+		}
+		waitConnect(t, c)
+	})
+
+	t.Run("restored-pin", func(t *testing.T) {
+		// A key pinned by an earlier process makes the fallback work
+		// without a TLS connection in this one.
+		saved := ServerKeyPins()
+		pinnedServerKeys.Delete(hostName)
+		AddServerKeyPins(saved)
+		AddServerKeyPins(map[string]key.NodePublic{hostName: key.NewNode().Public()})
+		if got, _ := pinnedServerKeys.Load(hostName); got != serverPrivateKey.Public() {
+			t.Fatalf("restored pin = %v; want %v", got, serverPrivateKey.Public())
+		}
+		c := newClient(blockedPort, true)
+		if err := c.Connect(ctx); err == nil {
+			t.Fatal("first Connect succeeded; want TLS failure")
+		}
+		if err := c.Connect(ctx); err != nil {
+			t.Fatalf("plaintext Connect with restored pin: %v", err)
+		}
+		waitConnect(t, c)
+	})
+
+	t.Run("wrong-key", func(t *testing.T) {
+		pinnedServerKeys.Store(hostName, key.NewNode().Public())
+		defer pinnedServerKeys.Store(hostName, serverPrivateKey.Public())
+		c := newClient(blockedPort, true)
+		for range 2 {
+			if err := c.Connect(ctx); err == nil {
+				t.Fatal("Connect succeeded with a mismatched server key")
+			}
+		}
+	})
+
+	t.Run("impostor", func(t *testing.T) {
+		// The impostor sends the pinned key in its greeting, but doesn't
+		// hold its private key, so it can't seal its server info.
+		impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, brw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			writeFrame := func(typ byte, b []byte) {
+				brw.WriteByte(typ)
+				binary.Write(brw, binary.BigEndian, uint32(len(b)))
+				brw.Write(b)
+				brw.Flush()
+			}
+			fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: DERP\r\nConnection: Upgrade\r\n\r\n")
+			pub := serverPrivateKey.Public().Raw32()
+			writeFrame(0x01, append([]byte("DERP🔑"), pub[:]...)) // frameServerKey
+
+			// Skip the client info, then send server info the client
+			// can't open.
+			var hdr [5]byte
+			if _, err := io.ReadFull(brw, hdr[:]); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, brw, int64(binary.BigEndian.Uint32(hdr[1:]))); err != nil {
+				return
+			}
+			writeFrame(0x03, make([]byte, 24+64)) // frameServerInfo
+			io.Copy(io.Discard, brw)
+		}))
+		defer impostor.Close()
+		_, impostorPort, _ := net.SplitHostPort(impostor.Listener.Addr().String())
+		plaintextFallbackPort = impostorPort
+		defer func() { plaintextFallbackPort = plainPort }()
+
+		c := newClient(blockedPort, true)
+		if err := c.Connect(ctx); err == nil {
+			t.Fatal("first Connect succeeded; want TLS failure")
+		}
+		if err := c.Connect(ctx); err == nil {
+			t.Fatal("Connect succeeded to a server without the pinned key's private key")
+		}
+		if c.UsingPlaintextFallback() {
+			t.Error("UsingPlaintextFallback = true after a failed Connect")
+		}
+	})
+}
+
+func TestWebSocketFallback(t *testing.T) {
+	if dialWebsocketOverConnFunc == nil {
+		t.Skip("WebSocket fallback not compiled in")
+	}
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+
+	// The server is behind a middlebox that rejects Upgrade headers
+	// other than WebSocket.
+	h := Handler(s)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	srv.StartTLS()
+	defer srv.Close()
+
+	port := netip.MustParseAddrPort(srv.Listener.Addr().String()).Port()
+	region := &tailcfg.DERPRegion{
+		RegionID:   1,
+		RegionCode: "test",
+		Nodes: []*tailcfg.DERPNode{{
+			Name:             "1a",
+			RegionID:         1,
+			HostName:         "127.0.0.1",
+			IPv4:             "127.0.0.1",
+			IPv6:             "none",
+			DERPPort:         int(port),
+			InsecureForTests: true,
+		}},
+	}
+	c := NewRegionClient(key.NewNode(), t.Logf, netmon.NewStatic(), func() *tailcfg.DERPRegion { return region })
+	defer c.Close()
+
+	wsAccepts0 := WebSocketAccepts()
+	ctx := context.Background()
+	if err := c.Connect(ctx); err == nil {
+		t.Fatal("first Connect succeeded; want DERP upgrade failure")
+	}
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("WebSocket Connect: %v", err)
+	}
+	if !c.atomicState.Load().WebSocket {
+		t.Error("ConnectedState.WebSocket = false; want true")
+	}
+	if got := WebSocketAccepts() - wsAccepts0; got != 1 {
+		t.Errorf("server accepted %d WebSocket connections; want 1", got)
+	}
+	waitConnect(t, c)
+}
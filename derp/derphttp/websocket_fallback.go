@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package derphttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"nhooyr.io/websocket"
+	"tailscale.com/net/wsconn"
+)
+
+func init() {
+	dialWebsocketOverConnFunc = dialWebsocketOverConn
+}
+
+func dialWebsocketOverConn(ctx context.Context, urlStr string, nc net.Conn) (net.Conn, error) {
+	var used atomic.Bool
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		if used.Swap(true) {
+			return nil, errors.New("connection already used")
+		}
+		return nc, nil
+	}
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext:    dial,
+		DialTLSContext: dial, // nc is already a TLS connection for https URLs
+	}}
+	c, res, err := websocket.Dial(ctx, urlStr, &websocket.DialOptions{
+		HTTPClient:   hc,
+		Subprotocols: []string{"derp"},
+	})
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("websocket dial: %w (HTTP status %v)", err, res.Status)
+		}
+		return nil, fmt.Errorf("websocket dial: %w", err)
+	}
+	return wsconn.NetConn(context.Background(), c, websocket.MessageBinary, urlStr), nil
+}
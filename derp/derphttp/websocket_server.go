@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package derphttp
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"nhooyr.io/websocket"
+	"tailscale.com/derp"
+	"tailscale.com/net/wsconn"
+)
+
+func init() {
+	serveWebSocketFunc = serveWebSocket
+}
+
+// webSocketAccepts is the number of WebSocket connections accepted by
+// Handlers.
+var webSocketAccepts atomic.Int64
+
+// WebSocketAccepts returns the number of DERP connections over WebSocket
+// that Handlers in this process have accepted.
+func WebSocketAccepts() int64 {
+	return webSocketAccepts.Load()
+}
+
+// serveWebSocket accepts a DERP connection over WebSocket for s, as dialed
+// by js/wasm clients and by clients falling back from a failed DERP upgrade.
+func serveWebSocket(s *derp.Server, w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols:   []string{"derp"},
+		OriginPatterns: []string{"*"},
+		// Disable compression because we transmit WireGuard messages that
+		// are not compressible.
+		// Additionally, Safari has a broken implementation of compression
+		// (see https://github.com/nhooyr/websocket/issues/218) that makes
+		// enabling it actively harmful.
+		CompressionMode: websocket.CompressionDisabled,
+	})
+	if err != nil {
+		log.Printf("websocket.Accept: %v", err)
+		return
+	}
+	defer c.Close(websocket.StatusInternalError, "closing")
+	if c.Subprotocol() != "derp" {
+		c.Close(websocket.StatusPolicyViolation, "client must speak the derp subprotocol")
+		return
+	}
+	webSocketAccepts.Add(1)
+	wc := wsconn.NetConn(r.Context(), c, websocket.MessageBinary, r.RemoteAddr)
+	brw := bufio.NewReadWriter(bufio.NewReader(wc), bufio.NewWriter(wc))
+	s.Accept(r.Context(), wc, brw, r.RemoteAddr)
+}
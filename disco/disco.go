@@ -44,6 +44,7 @@
 	TypePing        = MessageType(0x01)
 	TypePong        = MessageType(0x02)
 	TypeCallMeMaybe = MessageType(0x03)
+	TypeAppMessage  = MessageType(0x04)
 )
 
 const v0 = byte(0)
@@ -83,6 +84,8 @@ func Parse(p []byte) (Message, error) {
 		return parsePong(ver, p)
 	case TypeCallMeMaybe:
 		return parseCallMeMaybe(ver, p)
+	case TypeAppMessage:
+		return parseAppMessage(ver, p)
 	default:
 		return nil, fmt.Errorf("unknown message type 0x%02x", byte(t))
 	}
@@ -257,6 +260,75 @@ func parsePong(ver uint8, p []byte) (m *Pong, err error) {
 	return m, nil
 }
 
+// AppMessage is a small message from a higher layer of the sender, such as
+// a request to negotiate a file transfer, for the same layer of the
+// recipient. It's only sent via DERP, which authenticates the node key of
+// the sender, so it can be exchanged with peers to which there's no direct
+// path.
+//
+// Topic identifies the layer the message is for, so that the recipient can
+// route it there. The recipient ignores messages for topics it has no
+// handler for.
+type AppMessage struct {
+	Topic   string // at most MaxAppMessageTopicLen bytes
+	Payload []byte // at most MaxAppMessagePayloadLen bytes
+}
+
+const (
+	// MaxAppMessageTopicLen is the maximum length of AppMessage.Topic.
+	MaxAppMessageTopicLen = 64
+
+	// MaxAppMessagePayloadLen is the maximum length of
+	// AppMessage.Payload. AppMessages are meant for small control
+	// messages, not bulk data.
+	MaxAppMessagePayloadLen = 4 << 10
+)
+
+// AppendMarshal appends the marshaled message to b. The message must have
+// been checked with Valid.
+func (m *AppMessage) AppendMarshal(b []byte) []byte {
+	ret, d := appendMsgHeader(b, TypeAppMessage, v0, 1+len(m.Topic)+len(m.Payload))
+	d[0] = byte(len(m.Topic))
+	d = d[1+copy(d[1:], m.Topic):]
+	copy(d, m.Payload)
+	return ret
+}
+
+// Valid reports whether m can be sent: its topic is non-empty and neither it
+// nor its payload are too long.
+func (m *AppMessage) Valid() error {
+	if m.Topic == "" {
+		return errors.New("empty topic")
+	}
+	if len(m.Topic) > MaxAppMessageTopicLen {
+		return fmt.Errorf("topic of %d bytes exceeds the maximum of %d", len(m.Topic), MaxAppMessageTopicLen)
+	}
+	if len(m.Payload) > MaxAppMessagePayloadLen {
+		return fmt.Errorf("payload of %d bytes exceeds the maximum of %d", len(m.Payload), MaxAppMessagePayloadLen)
+	}
+	return nil
+}
+
+func parseAppMessage(ver uint8, p []byte) (m *AppMessage, err error) {
+	if len(p) < 1 {
+		return nil, errShort
+	}
+	n := int(p[0])
+	p = p[1:]
+	if n == 0 || n > MaxAppMessageTopicLen {
+		return nil, fmt.Errorf("invalid topic length %d", n)
+	}
+	if len(p) < n {
+		return nil, errShort
+	}
+	m = &AppMessage{Topic: string(p[:n])}
+	if p = p[n:]; len(p) > MaxAppMessagePayloadLen {
+		return nil, fmt.Errorf("payload of %d bytes too long", len(p))
+	}
+	m.Payload = append([]byte(nil), p...)
+	return m, nil
+}
+
 // MessageSummary returns a short summary of m for logging purposes.
 func MessageSummary(m Message) string {
 	switch m := m.(type) {
@@ -266,6 +338,8 @@ func MessageSummary(m Message) string {
 		return fmt.Sprintf("pong tx=%x", m.TxID[:6])
 	case *CallMeMaybe:
 		return "call-me-maybe"
+	case *AppMessage:
+		return fmt.Sprintf("app-message topic=%q len=%d", m.Topic, len(m.Payload))
 	default:
 		return fmt.Sprintf("%#v", m)
 	}
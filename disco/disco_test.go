@@ -83,6 +83,19 @@ func TestMarshalAndParse(t *testing.T) {
 			},
 			want: "03 00 00 00 00 00 00 00 00 00 00 00 ff ff 01 02 03 04 02 37 20 01 00 00 00 00 00 00 00 00 00 00 00 00 34 56 03 15",
 		},
+		{
+			name: "app_message",
+			m: &AppMessage{
+				Topic:   "ab",
+				Payload: []byte("xyz"),
+			},
+			want: "04 00 02 61 62 78 79 7a",
+		},
+		{
+			name: "app_message_empty_payload",
+			m:    &AppMessage{Topic: "t"},
+			want: "04 00 01 74",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -109,6 +122,24 @@ func TestMarshalAndParse(t *testing.T) {
 	}
 }
 
+func TestParseAppMessageInvalid(t *testing.T) {
+	for _, p := range []string{
+		"04 00",       // no topic length
+		"04 00 00",    // empty topic
+		"04 00 03 61", // topic longer than message
+	} {
+		var b []byte
+		for _, f := range strings.Fields(p) {
+			var x byte
+			fmt.Sscanf(f, "%x", &x)
+			b = append(b, x)
+		}
+		if m, err := Parse(b); err == nil {
+			t.Errorf("Parse(%s) = %+v; want error", p, m)
+		}
+	}
+}
+
 func mustIPPort(s string) netip.AddrPort {
 	ipp, err := netip.ParseAddrPort(s)
 	if err != nil {
@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package feature tracks which optional subsystems are linked into the
+// binary.
+//
+// Each optional subsystem can be left out of a build with a ts_omit_FOO
+// build tag, which excludes the files that link it in and instead builds
+// stubs that report it as unavailable. Those files call Register from an
+// init func, so a binary can report what it was built with.
+//
+// The subsystems and their tags are:
+//
+//   - "netstack": ts_omit_netstack, userspace networking and subnet
+//     routing with gVisor in tailscaled
+//   - "portmapper": ts_omit_portmapper, NAT-PMP, PCP and UPnP port mapping
+//   - "serve": ts_omit_serve, Tailscale Serve and Funnel
+//   - "ssh": ts_omit_ssh, the Tailscale SSH server
+//   - "taildrop": ts_omit_taildrop, Taildrop file sharing
+package feature
+
+import (
+	"slices"
+	"sync"
+
+	"tailscale.com/util/set"
+)
+
+var (
+	mu         sync.Mutex
+	registered set.Set[string]
+)
+
+// Register records that the named optional subsystem is linked into the
+// binary. It's meant to be called from init funcs.
+func Register(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered.Make()
+	registered.Add(name)
+}
+
+// IsRegistered reports whether the named optional subsystem is linked into
+// the binary.
+func IsRegistered(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return registered.Contains(name)
+}
+
+// Registered returns the sorted names of the optional subsystems linked
+// into the binary.
+func Registered() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := registered.Slice()
+	slices.Sort(ret)
+	return ret
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package feature
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	if IsRegistered("test-b") {
+		t.Fatal("test-b registered before Register")
+	}
+	Register("test-b")
+	Register("test-a")
+	Register("test-b")
+	if !IsRegistered("test-a") || !IsRegistered("test-b") {
+		t.Error("registered features not reported as registered")
+	}
+	got := Registered()
+	if !slices.IsSorted(got) {
+		t.Errorf("Registered = %q; want sorted", got)
+	}
+	if n := len(slices.DeleteFunc(slices.Clone(got), func(s string) bool { return s != "test-a" && s != "test-b" })); n != 2 {
+		t.Errorf("Registered = %q; want test-a and test-b once each", got)
+	}
+}
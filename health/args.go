@@ -36,4 +36,7 @@
 	// ArgServerName provides a Warnable with comma delimited list of the hostname of the servers involved in the unhealthy state.
 	// If no nameservers were available to query, this will be an empty string.
 	ArgDNSServers Arg = "dns-servers"
+
+	// ArgRoutes provides a Warnable with a comma delimited list of the routes involved in the unhealthy state.
+	ArgRoutes Arg = "routes"
 )
@@ -93,6 +93,7 @@ type Tracker struct {
 	derpRegionConnected     map[int]bool
 	derpRegionHealthProblem map[int]string
 	derpRegionLastFrame     map[int]time.Time
+	derpRegionPlaintext     map[int]bool     // regions connected to over unencrypted HTTP
 	derpMap                 *tailcfg.DERPMap // last DERP map from control, could be nil if never received one
 	lastMapRequestHeard     time.Time        // time we got a 200 from control for a MapRequest
 	ipnState                string
@@ -706,6 +707,22 @@ func (t *Tracker) SetDERPRegionHealth(region int, problem string) {
 	t.selfCheckLocked()
 }
 
+// SetDERPRegionPlaintext records whether the connection to the provided DERP
+// region is using the unencrypted HTTP fallback rather than TLS.
+func (t *Tracker) SetDERPRegionPlaintext(region int, plaintext bool) {
+	if t.nil() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if plaintext {
+		mak.Set(&t.derpRegionPlaintext, region, true)
+	} else {
+		delete(t.derpRegionPlaintext, region)
+	}
+	t.selfCheckLocked()
+}
+
 // NoteDERPRegionReceivedFrame is called to note that a frame was received from
 // the given DERP region at the current time.
 func (t *Tracker) NoteDERPRegionReceivedFrame(region int) {
@@ -1085,6 +1102,17 @@ func (t *Tracker) updateBuiltinWarnablesLocked() {
 		t.setHealthyLocked(derpRegionErrorWarnable)
 	}
 
+	if len(t.derpRegionPlaintext) > 0 {
+		for regionID := range t.derpRegionPlaintext {
+			t.setUnhealthyLocked(derpPlaintextWarnable, Args{
+				ArgDERPRegionID:   fmt.Sprint(regionID),
+				ArgDERPRegionName: t.derpRegionNameLocked(regionID),
+			})
+		}
+	} else {
+		t.setHealthyLocked(derpPlaintextWarnable)
+	}
+
 	if len(t.controlHealth) > 0 {
 		for _, s := range t.controlHealth {
 			t.setUnhealthyLocked(controlHealthWarnable, Args{
@@ -160,6 +160,22 @@
 	},
 })
 
+// derpPlaintextWarnable is a Warnable that warns the user that a relay server is only reachable over unencrypted
+// HTTP, as TLS to it is being blocked or intercepted. Traffic stays end-to-end encrypted, but the connection to
+// the relay server is more easily identified and interfered with.
+var derpPlaintextWarnable = Register(&Warnable{
+	Code:      "derp-plaintext-fallback",
+	Title:     "Degraded relay server connection",
+	Severity:  SeverityLow,
+	DependsOn: []*Warnable{NetworkStatusWarnable},
+	Text: func(args Args) string {
+		if n := args[ArgDERPRegionName]; n != "" {
+			return fmt.Sprintf("Tailscale could not connect to the '%s' relay server using TLS, and is connected to it over unencrypted HTTP instead. Your traffic is still end-to-end encrypted, but this network may be blocking or intercepting TLS connections.", n)
+		}
+		return fmt.Sprintf("Tailscale could not connect to the relay server #%v using TLS, and is connected to it over unencrypted HTTP instead. Your traffic is still end-to-end encrypted, but this network may be blocking or intercepting TLS connections.", args[ArgDERPRegionID])
+	},
+})
+
 // noUDP4BindWarnable is a Warnable that warns the user that Tailscale couldn't listen for incoming UDP connections.
 var noUDP4BindWarnable = Register(&Warnable{
 	Code:                "no-udp4-bind",
@@ -146,6 +146,11 @@ type Notify struct {
 	// any changes to the user in the UI.
 	Health *health.State `json:",omitempty"`
 
+	// KeyExpiryWarning, if non-nil, warns that the node key of this node or
+	// of one of its peers expires soon. It's sent once for each warning
+	// threshold the time to expiry crosses.
+	KeyExpiryWarning *KeyExpiryWarning `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -188,10 +193,28 @@ func (n Notify) String() string {
 	if n.Health != nil {
 		sb.WriteString("Health{...} ")
 	}
+	if n.KeyExpiryWarning != nil {
+		fmt.Fprintf(&sb, "KeyExpiryWarning{%v in %v} ", n.KeyExpiryWarning.Name, n.KeyExpiryWarning.Threshold)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
 
+// KeyExpiryWarning warns that a node's key expires soon. See
+// Notify.KeyExpiryWarning.
+type KeyExpiryWarning struct {
+	NodeID tailcfg.StableNodeID
+	Name   string // the node's DNS name
+	Self   bool   // whether it's this node, rather than a peer
+
+	// Expiry is when the node's key expires.
+	Expiry time.Time
+
+	// Threshold is the warning threshold that the time left until Expiry
+	// has dropped below.
+	Threshold time.Duration
+}
+
 // PartialFile represents an in-progress incoming file transfer.
 type PartialFile struct {
 	Name         string    // e.g. "foo.jpg"
@@ -238,6 +261,7 @@ type OutgoingFile struct {
 var DebuggableComponents = []string{
 	"magicsock",
 	"sockstats",
+	"wgengine",
 }
 
 type Options struct {
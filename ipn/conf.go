@@ -36,6 +36,7 @@ type ConfigVAlpha struct {
 	NoStatefulFiltering opt.Bool `json:",omitempty"`
 
 	PostureChecking opt.Bool         `json:",omitempty"`
+	Ephemeral       opt.Bool         `json:",omitempty"` // register as an ephemeral node; see Prefs.Ephemeral
 	RunSSHServer    opt.Bool         `json:",omitempty"` // Tailscale SSH
 	RunWebClient    opt.Bool         `json:",omitempty"`
 	ShieldsUp       opt.Bool         `json:",omitempty"`
@@ -121,6 +122,10 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.PostureChecking = c.PostureChecking.EqualBool(true)
 		mp.PostureCheckingSet = true
 	}
+	if c.Ephemeral != "" {
+		mp.Ephemeral = c.Ephemeral.EqualBool(true)
+		mp.EphemeralSet = true
+	}
 	if c.RunSSHServer != "" {
 		mp.RunSSH = c.RunSSHServer.EqualBool(true)
 		mp.RunSSHSet = true
@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import "tailscale.com/version"
+
+// DaemonInfo describes how a running tailscaled was built and which
+// implementations it's using, so that it's clear which code paths a node
+// exercises.
+type DaemonInfo struct {
+	Version   version.Meta
+	GoVersion string   // as returned by runtime.Version
+	GOOS      string   // runtime.GOOS
+	GOARCH    string   // runtime.GOARCH
+	BuildTags []string `json:",omitempty"`
+
+	// Netstack is how netstack is used: "off" if it isn't, "subnets" if
+	// only for subnet routing and other traffic not to the TUN device, or
+	// "all" if there's no TUN device (userspace networking mode).
+	Netstack string
+
+	// Router is the Go type of the router that configures the OS network
+	// stack, such as "*router.linuxRouter", or empty if unknown.
+	Router string `json:",omitempty"`
+
+	// DNS is the Go type of the DNS OS configurator, such as
+	// "*dns.resolvedManager", or empty if unknown.
+	DNS string `json:",omitempty"`
+}
@@ -18,7 +18,7 @@
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,UDPPortHandler,HTTPHandler,WebServerConfig
 
 // View returns a readonly view of Prefs.
 func (p *Prefs) View() PrefsView {
@@ -65,7 +65,10 @@ func (v *PrefsView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v PrefsView) ControlURL() string                          { return v.ж.ControlURL }
+func (v PrefsView) ControlURL() string { return v.ж.ControlURL }
+func (v PrefsView) ControlURLFallbacks() views.Slice[string] {
+	return views.SliceOf(v.ж.ControlURLFallbacks)
+}
 func (v PrefsView) RouteAll() bool                              { return v.ж.RouteAll }
 func (v PrefsView) ExitNodeID() tailcfg.StableNodeID            { return v.ж.ExitNodeID }
 func (v PrefsView) ExitNodeIP() netip.Addr                      { return v.ж.ExitNodeIP }
@@ -93,7 +96,18 @@ func (v PrefsView) ProfileName() string                   { return v.ж.ProfileN
 func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpdate }
 func (v PrefsView) AppConnector() AppConnectorPrefs       { return v.ж.AppConnector }
 func (v PrefsView) PostureChecking() bool                 { return v.ж.PostureChecking }
-func (v PrefsView) NetfilterKind() string                 { return v.ж.NetfilterKind }
+func (v PrefsView) NoAutoKeyRenewal() bool                { return v.ж.NoAutoKeyRenewal }
+func (v PrefsView) Ephemeral() bool                       { return v.ж.Ephemeral }
+func (v PrefsView) DERPPlaintextFallback() bool           { return v.ж.DERPPlaintextFallback }
+func (v PrefsView) ValidateDNSSEC() bool                  { return v.ж.ValidateDNSSEC }
+func (v PrefsView) AdvertiseDNSAliases() views.Slice[string] {
+	return views.SliceOf(v.ж.AdvertiseDNSAliases)
+}
+func (v PrefsView) BlockedPeers() views.Slice[string] {
+	return views.SliceOf(v.ж.BlockedPeers)
+}
+func (v PrefsView) ListenPort() uint16    { return v.ж.ListenPort }
+func (v PrefsView) NetfilterKind() string { return v.ж.NetfilterKind }
 func (v PrefsView) DriveShares() views.SliceView[*drive.Share, drive.ShareView] {
 	return views.SliceOfViews[*drive.Share, drive.ShareView](v.ж.DriveShares)
 }
@@ -103,6 +117,7 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
 	ControlURL             string
+	ControlURLFallbacks    []string
 	RouteAll               bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
@@ -128,6 +143,13 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	AutoUpdate             AutoUpdatePrefs
 	AppConnector           AppConnectorPrefs
 	PostureChecking        bool
+	NoAutoKeyRenewal       bool
+	Ephemeral              bool
+	DERPPlaintextFallback  bool
+	ValidateDNSSEC         bool
+	AdvertiseDNSAliases    []string
+	BlockedPeers           []string
+	ListenPort             uint16
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	AllowSingleHosts       marshalAsTrueInJSON
@@ -185,6 +207,12 @@ func (v ServeConfigView) TCP() views.MapFn[uint16, *TCPPortHandler, TCPPortHandl
 	})
 }
 
+func (v ServeConfigView) UDP() views.MapFn[uint16, *UDPPortHandler, UDPPortHandlerView] {
+	return views.MapFnOf(v.ж.UDP, func(t *UDPPortHandler) UDPPortHandlerView {
+		return t.View()
+	})
+}
+
 func (v ServeConfigView) Web() views.MapFn[HostPort, *WebServerConfig, WebServerConfigView] {
 	return views.MapFnOf(v.ж.Web, func(t *WebServerConfig) WebServerConfigView {
 		return t.View()
@@ -205,6 +233,7 @@ func (v ServeConfigView) ETag() string { return v.ж.ETag }
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigViewNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	UDP         map[uint16]*UDPPortHandler
 	Web         map[HostPort]*WebServerConfig
 	AllowFunnel map[HostPort]bool
 	Foreground  map[string]*ServeConfig
@@ -260,13 +289,67 @@ func (v TCPPortHandlerView) HTTPS() bool          { return v.ж.HTTPS }
 func (v TCPPortHandlerView) HTTP() bool           { return v.ж.HTTP }
 func (v TCPPortHandlerView) TCPForward() string   { return v.ж.TCPForward }
 func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) ProxyProtocol() int   { return v.ж.ProxyProtocol }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
-	HTTPS        bool
-	HTTP         bool
-	TCPForward   string
-	TerminateTLS string
+	HTTPS         bool
+	HTTP          bool
+	TCPForward    string
+	TerminateTLS  string
+	ProxyProtocol int
+}{})
+
+// View returns a readonly view of UDPPortHandler.
+func (p *UDPPortHandler) View() UDPPortHandlerView {
+	return UDPPortHandlerView{ж: p}
+}
+
+// UDPPortHandlerView provides a read-only view over UDPPortHandler.
+//
+// Its methods should only be called if `Valid()` returns true.
+type UDPPortHandlerView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *UDPPortHandler
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v UDPPortHandlerView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v UDPPortHandlerView) AsStruct() *UDPPortHandler {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v UDPPortHandlerView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *UDPPortHandlerView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x UDPPortHandler
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v UDPPortHandlerView) UDPForward() string { return v.ж.UDPForward }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _UDPPortHandlerViewNeedsRegeneration = UDPPortHandler(struct {
+	UDPForward string
 }{})
 
 // View returns a readonly view of HTTPHandler.
@@ -314,15 +397,17 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string          { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string         { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string          { return v.ж.Text }
+func (v HTTPHandlerView) ForwardedHeader() bool { return v.ж.ForwardedHeader }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path            string
+	Proxy           string
+	Text            string
+	ForwardedHeader bool
 }{})
 
 // View returns a readonly view of WebServerConfig.
@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"strings"
+
+	"go4.org/netipx"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+// blockedPeers returns the node keys and Tailscale addresses of the peers
+// matching an entry of prefs.BlockedPeers. The returned IPSet is nil if no
+// peer is blocked.
+func blockedPeers(peers map[tailcfg.NodeID]tailcfg.NodeView, prefs ipn.PrefsView) (set.Set[key.NodePublic], *netipx.IPSet) {
+	if !prefs.Valid() || prefs.BlockedPeers().Len() == 0 {
+		return nil, nil
+	}
+	bp := prefs.BlockedPeers()
+	want := make(set.Set[string])
+	for i := range bp.Len() {
+		want.Add(strings.ToLower(strings.TrimSuffix(bp.At(i), ".")))
+	}
+
+	var keys set.Set[key.NodePublic]
+	var ipsb netipx.IPSetBuilder
+	for _, p := range peers {
+		if !peerMatchesBlocked(p, want) {
+			continue
+		}
+		mak.Set(&keys, p.Key(), struct{}{})
+		addrs := p.Addresses()
+		for i := range addrs.Len() {
+			ipsb.AddPrefix(addrs.At(i))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	ips, _ := ipsb.IPSet()
+	return keys, ips
+}
+
+// peerMatchesBlocked reports whether p matches one of the lowercased
+// Prefs.BlockedPeers entries in want: its node key, its MagicDNS name with
+// or without the tailnet suffix, or its hostname.
+func peerMatchesBlocked(p tailcfg.NodeView, want set.Set[string]) bool {
+	if want.Contains(p.Key().String()) {
+		return true
+	}
+	name := strings.ToLower(strings.TrimSuffix(p.Name(), "."))
+	if name != "" && (want.Contains(name) || want.Contains(dnsname.FirstLabel(name))) {
+		return true
+	}
+	if hi := p.Hostinfo(); hi.Valid() && hi.Hostname() != "" {
+		return want.Contains(strings.ToLower(hi.Hostname()))
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestBlockedPeers(t *testing.T) {
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	k3 := key.NewNode().Public()
+	peers := map[tailcfg.NodeID]tailcfg.NodeView{
+		1: (&tailcfg.Node{
+			ID:        1,
+			Key:       k1,
+			Name:      "laptop.tail.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+		2: (&tailcfg.Node{
+			ID:        2,
+			Key:       k2,
+			Name:      "phone.tail.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+			Hostinfo:  (&tailcfg.Hostinfo{Hostname: "Pixel"}).View(),
+		}).View(),
+		3: (&tailcfg.Node{
+			ID:        3,
+			Key:       k3,
+			Name:      "server.tail.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")},
+		}).View(),
+	}
+
+	tests := []struct {
+		name    string
+		blocked []string
+		want    []tailcfg.NodeID
+	}{
+		{"none", nil, nil},
+		{"short_name", []string{"Laptop"}, []tailcfg.NodeID{1}},
+		{"fqdn", []string{"laptop.tail.ts.net."}, []tailcfg.NodeID{1}},
+		{"hostname", []string{"pixel"}, []tailcfg.NodeID{2}},
+		{"node_key", []string{k3.String()}, []tailcfg.NodeID{3}},
+		{"several", []string{"laptop", "server"}, []tailcfg.NodeID{1, 3}},
+		{"unknown", []string{"printer"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := (&ipn.Prefs{BlockedPeers: tt.blocked}).View()
+			keys, ips := blockedPeers(peers, prefs)
+			if len(keys) != len(tt.want) {
+				t.Fatalf("blocked %d peers; want %d", len(keys), len(tt.want))
+			}
+			if len(tt.want) == 0 {
+				if ips != nil {
+					t.Errorf("IPSet = %v; want nil", ips)
+				}
+				return
+			}
+			for _, id := range tt.want {
+				p := peers[id]
+				if !keys.Contains(p.Key()) {
+					t.Errorf("peer %v not blocked", p.Name())
+				}
+				if addr := p.Addresses().At(0).Addr(); !ips.Contains(addr) {
+					t.Errorf("address %v of %v not in IPSet", addr, p.Name())
+				}
+			}
+		})
+	}
+}
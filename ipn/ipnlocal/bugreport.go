@@ -0,0 +1,219 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/hostinfo"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/goroutines"
+	"tailscale.com/version"
+)
+
+// SetLogSnapshotter sets a func to be called to write the recent, not yet
+// uploaded, log entries to a bug report bundle. See
+// logtail.Logger.SnapshotBuffer.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetLogSnapshotter(snapshotFunc func(io.Writer) (int64, error)) {
+	b.logSnapshotFunc = snapshotFunc
+}
+
+// bugReportNetmap is the summary of the current netmap included in bug
+// report bundles. It has no private data and only the short forms of
+// public keys, as printed in logs.
+type bugReportNetmap struct {
+	Name        string
+	NodeKey     string
+	Addresses   []netip.Prefix
+	Domain      string
+	DERPRegions []int
+	Peers       []bugReportPeer
+}
+
+type bugReportPeer struct {
+	Name       string
+	StableID   tailcfg.StableNodeID
+	NodeKey    string
+	Addresses  []netip.Prefix
+	AllowedIPs []netip.Prefix
+	DERP       string   `json:",omitempty"`
+	Tags       []string `json:",omitempty"`
+	Online     *bool    `json:",omitempty"`
+	Expired    bool     `json:",omitempty"`
+}
+
+// WriteBugReportBundle writes a gzipped tar archive of diagnostics to w,
+// for attaching to a bug report. marker is the correlation ID of the bug
+// report (see the LocalAPI "bugreport" endpoint) and is included in the
+// bundle, so the bundle can be matched with the node's uploaded logs.
+//
+// The bundle contains the recent logs not yet uploaded, the last netcheck
+// report, a summary of the current netmap, the packet filter rules, the
+// last router configuration, the network interface state and a goroutine
+// dump with the argument values scrubbed. Failures to collect individual
+// parts are recorded in the bundle's errors.txt rather than returned.
+func (b *LocalBackend) WriteBugReportBundle(ctx context.Context, w io.Writer, marker string) error {
+	now := b.clock.Now()
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	dir := "tailscale-bugreport-" + now.UTC().Format("20060102150405Z") + "/"
+
+	var errs []string
+	addErr := func(name string, err any) {
+		errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+	}
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    dir + name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v any) error {
+		j, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			addErr(name, err)
+			return nil
+		}
+		return add(name, append(j, '\n'))
+	}
+
+	var info bytes.Buffer
+	fmt.Fprintf(&info, "marker: %s\n", marker)
+	fmt.Fprintf(&info, "time: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&info, "version: %s\n", version.Long())
+	fmt.Fprintf(&info, "backend log ID: %s\n", b.backendLogID)
+	if err := b.health.OverallError(); err != nil {
+		fmt.Fprintf(&info, "health: %s\n", strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+	} else {
+		fmt.Fprintf(&info, "health: ok\n")
+	}
+	envknob.LogCurrent(func(format string, args ...any) {
+		fmt.Fprintf(&info, "envknob: "+format+"\n", args...)
+	})
+	if err := add("info.txt", info.Bytes()); err != nil {
+		return err
+	}
+	if err := addJSON("hostinfo.json", hostinfo.New()); err != nil {
+		return err
+	}
+
+	if b.logSnapshotFunc == nil {
+		addErr("logs.txt", "no log snapshotter configured")
+	} else {
+		var logs bytes.Buffer
+		if _, err := b.logSnapshotFunc(&logs); err != nil {
+			addErr("logs.txt", err)
+		}
+		if err := add("logs.txt", logs.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if report := b.MagicConn().GetLastNetcheckReport(ctx); report != nil {
+		if err := addJSON("netcheck.json", report); err != nil {
+			return err
+		}
+	} else {
+		addErr("netcheck.json", "no netcheck report available")
+	}
+
+	b.mu.Lock()
+	nm := b.netMap
+	summary := b.bugReportNetmapLocked()
+	rcfg := b.lastRouterConfig
+	b.mu.Unlock()
+
+	if nm == nil {
+		addErr("netmap.json", "no netmap")
+	} else {
+		if err := addJSON("netmap.json", summary); err != nil {
+			return err
+		}
+		if err := addJSON("filter.json", nm.PacketFilterRules); err != nil {
+			return err
+		}
+	}
+	if rcfg == nil {
+		addErr("router.json", "router not configured")
+	} else if err := addJSON("router.json", rcfg); err != nil {
+		return err
+	}
+	if st := b.NetMon().InterfaceState(); st != nil {
+		if err := add("interfaces.txt", []byte(st.String()+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := add("goroutines.txt", goroutines.ScrubbedGoroutineDump(true)); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		if err := add("errors.txt", []byte(strings.Join(errs, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// bugReportNetmapLocked returns the summary of the current netmap for bug
+// report bundles, or nil if there's no netmap.
+//
+// b.mu must be held.
+func (b *LocalBackend) bugReportNetmapLocked() *bugReportNetmap {
+	nm := b.netMap
+	if nm == nil {
+		return nil
+	}
+	s := &bugReportNetmap{
+		NodeKey: nm.NodeKey.ShortString(),
+		Domain:  nm.Domain,
+	}
+	if self := nm.SelfNode; self.Valid() {
+		s.Name = self.Name()
+		s.Addresses = self.Addresses().AsSlice()
+	}
+	if nm.DERPMap != nil {
+		s.DERPRegions = nm.DERPMap.RegionIDs()
+	}
+	for _, p := range b.peers {
+		s.Peers = append(s.Peers, bugReportPeer{
+			Name:       p.Name(),
+			StableID:   p.StableID(),
+			NodeKey:    p.Key().ShortString(),
+			Addresses:  p.Addresses().AsSlice(),
+			AllowedIPs: p.AllowedIPs().AsSlice(),
+			DERP:       p.DERP(),
+			Tags:       p.Tags().AsSlice(),
+			Online:     p.Online(),
+			Expired:    p.Expired(),
+		})
+	}
+	slices.SortFunc(s.Peers, func(a, b bugReportPeer) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return s
+}
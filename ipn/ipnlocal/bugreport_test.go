@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"path"
+	"strings"
+	"testing"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func TestWriteBugReportBundle(t *testing.T) {
+	b := newTestLocalBackend(t)
+	b.SetLogSnapshotter(func(w io.Writer) (int64, error) {
+		n, err := io.WriteString(w, `{"text":"hello from the log buffer"}`+"\n")
+		return int64(n), err
+	})
+	b.MagicConn().SetLastNetcheckReportForTest(context.Background(), &netcheck.Report{UDP: true})
+
+	peerKey := key.NewNode().Public()
+	b.mu.Lock()
+	b.setNetMapLocked(&netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			Name:      "self.example.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				ID:        2,
+				Name:      "peer.example.ts.net.",
+				Key:       peerKey,
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+			}).View(),
+		},
+	})
+	b.mu.Unlock()
+
+	const marker = "BUG-test-marker"
+	var buf bytes.Buffer
+	if err := b.WriteBugReportBundle(context.Background(), &buf, marker); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path.Base(hdr.Name)] = string(data)
+	}
+
+	for _, name := range []string{"info.txt", "hostinfo.json", "logs.txt", "netcheck.json", "netmap.json", "filter.json", "goroutines.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle is missing %s", name)
+		}
+	}
+	if !strings.Contains(files["info.txt"], marker) {
+		t.Errorf("info.txt doesn't contain the marker:\n%s", files["info.txt"])
+	}
+	if !strings.Contains(files["logs.txt"], "hello from the log buffer") {
+		t.Errorf("logs.txt = %q", files["logs.txt"])
+	}
+	if !strings.Contains(files["errors.txt"], "router.json") {
+		t.Errorf("errors.txt doesn't report the missing router config: %q", files["errors.txt"])
+	}
+
+	var nm bugReportNetmap
+	if err := json.Unmarshal([]byte(files["netmap.json"]), &nm); err != nil {
+		t.Fatalf("netmap.json: %v", err)
+	}
+	if nm.Name != "self.example.ts.net." || len(nm.Peers) != 1 || nm.Peers[0].Name != "peer.example.ts.net." {
+		t.Errorf("unexpected netmap summary: %+v", nm)
+	}
+	if strings.Contains(files["netmap.json"], peerKey.String()) {
+		t.Errorf("netmap.json contains a full node key:\n%s", files["netmap.json"])
+	}
+	if got, want := nm.Peers[0].NodeKey, peerKey.ShortString(); got != want {
+		t.Errorf("peer NodeKey = %q; want %q", got, want)
+	}
+}
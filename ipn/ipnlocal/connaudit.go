@@ -0,0 +1,340 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/filter"
+)
+
+const (
+	// connAuditMaxEntries and connAuditMaxAge are the retention limits of
+	// the connection audit log: it keeps at most connAuditMaxEntries
+	// entries, none older than connAuditMaxAge.
+	connAuditMaxEntries = 10000
+	connAuditMaxAge     = 30 * 24 * time.Hour
+
+	// connAuditFlushDelay is how long ended connections wait before
+	// they're written to the connection audit log, so that bursts of
+	// them are written together.
+	connAuditFlushDelay = 5 * time.Second
+
+	// connAuditMaxPending is the number of ended connections that can
+	// wait to be written. Past that, they're dropped.
+	connAuditMaxPending = 4096
+
+	// connAuditPruneInterval is how often the connection audit log file
+	// is rewritten to remove entries older than connAuditMaxAge.
+	connAuditPruneInterval = time.Hour
+)
+
+// connAuditFile is the name of the connection audit log file in
+// TailscaleVarRoot. It holds one JSON-encoded apitype.ConnAuditEntry per
+// line, oldest first.
+const connAuditFile = "conn-audit.jsonl"
+
+// connAuditLog is the connection audit log: the inbound connections that
+// the packet filter accepted, as reported by a filter.ConnLog, with the
+// identity of the peers that opened them.
+//
+// Connections are reported on the packet processing path, so they're
+// queued and written out later, along with their peer's identity, by a
+// timer. Entries are kept in memory as well as appended to a file, if
+// there's a directory to put it in, so that they survive restarts.
+type connAuditLog struct {
+	logf  logger.Logf
+	path  string // or empty to only keep entries in memory
+	whois func(netip.Addr) (tailcfg.NodeView, tailcfg.UserProfile, bool)
+	now   func() time.Time
+
+	mu         sync.Mutex
+	pending    []filter.AcceptedConn // ended connections not yet written
+	dropped    int                   // connections dropped since the last flush
+	flushTimer *time.Timer           // or nil if no flush is scheduled
+
+	fileMu    sync.Mutex // held while writing; guards the fields below
+	loaded    bool
+	entries   []apitype.ConnAuditEntry // oldest first
+	lastPrune time.Time
+}
+
+func newConnAuditLog(logf logger.Logf, dir string, whois func(netip.Addr) (tailcfg.NodeView, tailcfg.UserProfile, bool)) *connAuditLog {
+	l := &connAuditLog{
+		logf:  logger.WithPrefix(logf, "conn-audit: "),
+		whois: whois,
+		now:   time.Now,
+	}
+	if dir != "" {
+		l.path = filepath.Join(dir, connAuditFile)
+	}
+	return l
+}
+
+// add queues c to be written to the log. It doesn't block.
+func (l *connAuditLog) add(c filter.AcceptedConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) >= connAuditMaxPending {
+		l.dropped++
+		return
+	}
+	l.pending = append(l.pending, c)
+	if l.flushTimer == nil {
+		l.flushTimer = time.AfterFunc(connAuditFlushDelay, l.flush)
+	}
+}
+
+// flush writes the queued connections to the log.
+func (l *connAuditLog) flush() {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+	l.flushLocked()
+}
+
+// flushLocked is flush with l.fileMu held.
+func (l *connAuditLog) flushLocked() {
+	l.mu.Lock()
+	pending, dropped := l.pending, l.dropped
+	l.pending, l.dropped = nil, 0
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+		l.flushTimer = nil
+	}
+	l.mu.Unlock()
+
+	if dropped > 0 {
+		l.logf("dropped %d connections; too many to write", dropped)
+	}
+	l.loadLocked()
+	if len(pending) == 0 {
+		return
+	}
+	added := make([]apitype.ConnAuditEntry, 0, len(pending))
+	for _, c := range pending {
+		added = append(added, l.entryFor(c))
+	}
+	l.entries = append(l.entries, added...)
+	if l.pruneLocked() {
+		l.rewriteLocked()
+	} else {
+		l.appendLocked(added)
+	}
+}
+
+// entryFor returns the log entry for c, with the identity of its peer.
+func (l *connAuditLog) entryFor(c filter.AcceptedConn) apitype.ConnAuditEntry {
+	e := apitype.ConnAuditEntry{
+		Start:    c.Start,
+		End:      c.End,
+		Proto:    c.Proto,
+		Src:      c.Src,
+		Dst:      c.Dst,
+		BytesIn:  c.BytesIn,
+		BytesOut: c.BytesOut,
+	}
+	if n, u, ok := l.whois(c.Src.Addr()); ok {
+		e.NodeID = n.StableID()
+		e.NodeName = n.Name()
+		if n.IsTagged() {
+			e.Tags = n.Tags().AsSlice()
+		} else {
+			e.User = u.LoginName
+		}
+	}
+	return e
+}
+
+// loadLocked reads the entries in the log file, if it hasn't already.
+// l.fileMu must be held.
+func (l *connAuditLog) loadLocked() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+	if l.path == "" {
+		return
+	}
+	bs, err := os.ReadFile(l.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			l.logf("reading log: %v", err)
+		}
+		return
+	}
+	s := bufio.NewScanner(bytes.NewReader(bs))
+	s.Buffer(nil, 64<<10)
+	var bad int
+	for s.Scan() {
+		var e apitype.ConnAuditEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			bad++
+			continue
+		}
+		l.entries = append(l.entries, e)
+	}
+	if bad > 0 {
+		l.logf("skipped %d malformed entries", bad)
+	}
+	if l.pruneLocked() {
+		l.rewriteLocked()
+	}
+}
+
+// pruneLocked removes the entries past the retention limits and reports
+// whether it did, in which case the log file must be rewritten.
+//
+// To not rewrite the file for each new entry once the log is full, it
+// keeps up to a tenth more than connAuditMaxEntries entries before it
+// removes any, and removes old entries at most every
+// connAuditPruneInterval.
+func (l *connAuditLog) pruneLocked() bool {
+	n := len(l.entries)
+	now := l.now()
+	if now.Sub(l.lastPrune) >= connAuditPruneInterval {
+		l.lastPrune = now
+		cutoff := now.Add(-connAuditMaxAge)
+		i := 0
+		for i < len(l.entries) && l.entries[i].End.Before(cutoff) {
+			i++
+		}
+		l.entries = l.entries[i:]
+	}
+	if len(l.entries) > connAuditMaxEntries+connAuditMaxEntries/10 {
+		l.entries = l.entries[len(l.entries)-connAuditMaxEntries:]
+	}
+	if len(l.entries) == n {
+		return false
+	}
+	l.entries = append([]apitype.ConnAuditEntry(nil), l.entries...)
+	return true
+}
+
+// appendLocked appends es to the log file.
+func (l *connAuditLog) appendLocked(es []apitype.ConnAuditEntry) {
+	if l.path == "" {
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		l.logf("opening log: %v", err)
+		return
+	}
+	_, err = f.Write(marshalConnAuditEntries(es))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		l.logf("writing log: %v", err)
+	}
+}
+
+// rewriteLocked replaces the log file with l.entries.
+func (l *connAuditLog) rewriteLocked() {
+	if l.path == "" {
+		return
+	}
+	if err := atomicfile.WriteFile(l.path, marshalConnAuditEntries(l.entries), 0600); err != nil {
+		l.logf("rewriting log: %v", err)
+	}
+}
+
+func marshalConnAuditEntries(es []apitype.ConnAuditEntry) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range es {
+		enc.Encode(e) // can't fail; Encode adds the newline
+	}
+	return buf.Bytes()
+}
+
+// query returns the entries of connections that ended at or after since,
+// oldest first. If limit is positive, only the most recent limit entries
+// are returned.
+func (l *connAuditLog) query(since time.Time, limit int) []apitype.ConnAuditEntry {
+	l.fileMu.Lock()
+	defer l.fileMu.Unlock()
+	l.flushLocked()
+	if cutoff := l.now().Add(-connAuditMaxAge); since.Before(cutoff) {
+		since = cutoff
+	}
+	i := len(l.entries)
+	for i > 0 && !l.entries[i-1].End.Before(since) && (limit <= 0 || len(l.entries)-i < limit) {
+		i--
+	}
+	ret := make([]apitype.ConnAuditEntry, len(l.entries)-i)
+	copy(ret, l.entries[i:])
+	return ret
+}
+
+// connAudit returns b's connection audit log, creating it if needed.
+func (b *LocalBackend) connAudit() *connAuditLog {
+	b.connAuditOnce.Do(func() {
+		b.connAuditLog = newConnAuditLog(b.logf, b.TailscaleVarRoot(), func(ip netip.Addr) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+			return b.WhoIs("", netip.AddrPortFrom(ip, 0))
+		})
+	})
+	return b.connAuditLog
+}
+
+// onAcceptedConn is called by b.connLog with each inbound connection the
+// packet filter accepted, once it ends.
+func (b *LocalBackend) onAcceptedConn(c filter.AcceptedConn) {
+	b.connAudit().add(c)
+}
+
+// loadConnAuditEnabled turns the connection audit log on if it was on when
+// tailscaled last ran.
+func (b *LocalBackend) loadConnAuditEnabled() {
+	if v, err := ipn.ReadStoreInt(b.store, ipn.ConnAuditStateKey); err == nil && v != 0 {
+		b.connLog.SetEnabled(true)
+	}
+}
+
+// ConnAuditEnabled reports whether inbound connections are being recorded
+// in the connection audit log.
+func (b *LocalBackend) ConnAuditEnabled() bool {
+	return b.connLog.Enabled()
+}
+
+// SetConnAuditEnabled sets whether the inbound connections that peers open
+// to this node are recorded in the connection audit log. The setting
+// persists across restarts. Turning it off doesn't remove the entries
+// already recorded; they age out as usual.
+func (b *LocalBackend) SetConnAuditEnabled(v bool) error {
+	var iv int64
+	if v {
+		iv = 1
+	}
+	if err := ipn.PutStoreInt(b.store, ipn.ConnAuditStateKey, iv); err != nil {
+		return err
+	}
+	b.logf("connection audit log enabled: %v", v)
+	b.connLog.SetEnabled(v)
+	return nil
+}
+
+// ConnAudit returns the entries of the connection audit log for the
+// inbound connections that ended at or after since, oldest first. If limit
+// is positive, only the most recent limit entries are returned.
+//
+// Connections that are still open aren't included.
+func (b *LocalBackend) ConnAudit(since time.Time, limit int) []apitype.ConnAuditEntry {
+	b.connLog.Flush()
+	return b.connAudit().query(since, limit)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestConnAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	peer := (&tailcfg.Node{
+		StableID: "peer-id",
+		Name:     "peer.example.ts.net.",
+	}).View()
+	whois := func(ip netip.Addr) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+		if ip != netip.MustParseAddr("100.64.0.2") {
+			return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
+		}
+		return peer, tailcfg.UserProfile{LoginName: "user@example.com"}, true
+	}
+	now := time.Unix(1_700_000_000, 0)
+	newLog := func() *connAuditLog {
+		l := newConnAuditLog(t.Logf, dir, whois)
+		l.now = func() time.Time { return now }
+		return l
+	}
+	conn := func(src string, end time.Time) filter.AcceptedConn {
+		return filter.AcceptedConn{
+			Start:   end.Add(-time.Minute),
+			End:     end,
+			Proto:   ipproto.TCP,
+			Src:     netip.AddrPortFrom(netip.MustParseAddr(src), 1234),
+			Dst:     netip.MustParseAddrPort("100.64.0.1:22"),
+			BytesIn: 100,
+		}
+	}
+
+	l := newLog()
+	l.add(conn("100.64.0.2", now.Add(-2*time.Hour)))
+	l.add(conn("100.64.0.3", now.Add(-time.Hour)))
+	l.flush()
+
+	got := newLog().query(time.Time{}, 0)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries after reload; want 2", len(got))
+	}
+	if e := got[0]; e.NodeID != "peer-id" || e.NodeName != "peer.example.ts.net." || e.User != "user@example.com" || e.Dst.Port() != 22 || e.BytesIn != 100 {
+		t.Errorf("first entry = %+v; want one for peer-id to port 22", e)
+	}
+	if e := got[1]; e.NodeID != "" || e.Src.Addr() != netip.MustParseAddr("100.64.0.3") {
+		t.Errorf("second entry = %+v; want an unknown peer", e)
+	}
+	if got := l.query(now.Add(-90*time.Minute), 0); len(got) != 1 || got[0].Src.Addr() != netip.MustParseAddr("100.64.0.3") {
+		t.Errorf("query since 90m ago = %+v; want the second entry", got)
+	}
+	if got := l.query(time.Time{}, 1); len(got) != 1 || got[0].Src.Addr() != netip.MustParseAddr("100.64.0.3") {
+		t.Errorf("query with limit 1 = %+v; want the second entry", got)
+	}
+
+	// Entries past connAuditMaxAge are removed from the file.
+	now = now.Add(connAuditMaxAge - 90*time.Minute)
+	l = newLog()
+	if got := l.query(time.Time{}, 0); len(got) != 1 {
+		t.Fatalf("got %d entries; want 1 once the first is too old", len(got))
+	}
+	bs, err := os.ReadFile(filepath.Join(dir, connAuditFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(bs), "\n"); n != 1 {
+		t.Errorf("log file has %d lines; want 1", n)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"time"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// This file tracks how responsive the control plane is from this node's
+// point of view, so operators can set SLOs and alert on it:
+//
+//   - time to first netmap: from LocalBackend.Start until the first netmap
+//     arrives from the control server (including any login in between).
+//   - map propagation: from a netmap arriving from the control server until
+//     it has been applied to the engine (wgengine.Engine.Reconfig returned).
+//     If more netmaps arrive before the engine catches up, it's measured
+//     from the oldest one.
+//   - login duration: from asking the control client to log in until it
+//     reports that the login finished. For interactive logins this includes
+//     the time the user took to authenticate.
+
+// latencyMetric is a latency exported as clientmetrics: the most recent
+// value as a gauge, and the sum and count of all values as counters, from
+// which the average over any interval can be computed.
+type latencyMetric struct {
+	lastMS *clientmetric.Metric
+	sumMS  *clientmetric.Metric
+	count  *clientmetric.Metric
+}
+
+func newLatencyMetric(name string) latencyMetric {
+	return latencyMetric{
+		lastMS: clientmetric.NewGauge(name + "_last_ms"),
+		sumMS:  clientmetric.NewCounter(name + "_sum_ms"),
+		count:  clientmetric.NewCounter(name + "_count"),
+	}
+}
+
+func (m latencyMetric) observe(d time.Duration) {
+	ms := d.Milliseconds()
+	m.lastMS.Set(ms)
+	m.sumMS.Add(ms)
+	m.count.Add(1)
+}
+
+var (
+	metricTimeToFirstNetmap = newLatencyMetric("controlplane_time_to_first_netmap")
+	metricMapPropagation    = newLatencyMetric("controlplane_map_propagation")
+	metricLoginDuration     = newLatencyMetric("controlplane_login_duration")
+)
+
+// noteStartLocked starts measuring the time to the first netmap.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteStartLocked() {
+	b.firstNetmapWaitSince = b.clock.Now()
+}
+
+// noteLoginStartedLocked starts measuring the login duration, unless a
+// login is already in progress.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteLoginStartedLocked() {
+	if b.loginStartedAt.IsZero() {
+		b.loginStartedAt = b.clock.Now()
+	}
+}
+
+func (b *LocalBackend) noteLoginStarted() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.noteLoginStartedLocked()
+}
+
+// noteLoginFinishedLocked records the duration of the login in progress,
+// if any.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteLoginFinishedLocked() {
+	if b.loginStartedAt.IsZero() {
+		return
+	}
+	metricLoginDuration.observe(b.clock.Since(b.loginStartedAt))
+	b.loginStartedAt = time.Time{}
+}
+
+// noteNetmapReceivedLocked records the arrival of a new netmap from the
+// control server.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteNetmapReceivedLocked() {
+	now := b.clock.Now()
+	if !b.firstNetmapWaitSince.IsZero() {
+		metricTimeToFirstNetmap.observe(now.Sub(b.firstNetmapWaitSince))
+		b.firstNetmapWaitSince = time.Time{}
+	}
+	b.netmapGen++
+	if b.netmapPendingSince.IsZero() {
+		b.netmapPendingSince = now
+	}
+}
+
+// noteNetmapApplied records that the netmap of generation gen (see
+// LocalBackend.netmapGen) has been applied to the engine.
+func (b *LocalBackend) noteNetmapApplied(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.netmapGen || b.netmapPendingSince.IsZero() {
+		// Either nothing is pending, or a newer netmap arrived in the
+		// meantime and the engine hasn't caught up yet.
+		return
+	}
+	metricMapPropagation.observe(b.clock.Since(b.netmapPendingSince))
+	b.netmapPendingSince = time.Time{}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+func TestControlPlaneLatencyMetrics(t *testing.T) {
+	b := newTestLocalBackend(t)
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	b.clock = clock
+
+	count := func(m latencyMetric) int64 { return m.count.Value() }
+	firstNetmapCount := count(metricTimeToFirstNetmap)
+	propagationCount := count(metricMapPropagation)
+	loginCount := count(metricLoginDuration)
+
+	b.mu.Lock()
+	b.noteStartLocked()
+	b.noteLoginStartedLocked()
+	clock.Advance(2 * time.Second)
+	b.noteLoginStartedLocked() // already in progress; must not restart the measurement
+	clock.Advance(1 * time.Second)
+	b.noteLoginFinishedLocked()
+	b.noteLoginFinishedLocked() // no login in progress; ignored
+	clock.Advance(1 * time.Second)
+	b.noteNetmapReceivedLocked()
+	gen1 := b.netmapGen
+	clock.Advance(1 * time.Second)
+	b.noteNetmapReceivedLocked()
+	gen2 := b.netmapGen
+	b.mu.Unlock()
+
+	if got, want := count(metricLoginDuration)-loginCount, int64(1); got != want {
+		t.Errorf("login duration count = %d; want %d", got, want)
+	}
+	if got, want := metricLoginDuration.lastMS.Value(), int64(3000); got != want {
+		t.Errorf("login duration = %dms; want %dms", got, want)
+	}
+	if got, want := count(metricTimeToFirstNetmap)-firstNetmapCount, int64(1); got != want {
+		t.Errorf("time to first netmap count = %d; want %d", got, want)
+	}
+	if got, want := metricTimeToFirstNetmap.lastMS.Value(), int64(4000); got != want {
+		t.Errorf("time to first netmap = %dms; want %dms", got, want)
+	}
+
+	// The engine applying an outdated netmap doesn't count.
+	clock.Advance(1 * time.Second)
+	b.noteNetmapApplied(gen1)
+	if got := count(metricMapPropagation) - propagationCount; got != 0 {
+		t.Errorf("map propagation count after stale apply = %d; want 0", got)
+	}
+
+	// Applying the latest one is measured from the oldest pending netmap.
+	b.noteNetmapApplied(gen2)
+	if got, want := count(metricMapPropagation)-propagationCount, int64(1); got != want {
+		t.Errorf("map propagation count = %d; want %d", got, want)
+	}
+	if got, want := metricMapPropagation.lastMS.Value(), int64(2000); got != want {
+		t.Errorf("map propagation = %dms; want %dms", got, want)
+	}
+
+	// Reconfiguring again without a new netmap isn't a propagation.
+	b.noteNetmapApplied(gen2)
+	if got, want := count(metricMapPropagation)-propagationCount, int64(1); got != want {
+		t.Errorf("map propagation count after repeated apply = %d; want %d", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/ipn"
+	"tailscale.com/types/key"
+)
+
+// derpHistorySaveInterval is how often the DERP region history and server
+// keys are saved to the state store, in addition to at shutdown.
+const derpHistorySaveInterval = 15 * time.Minute
+
+// loadDERPRegionHistory restores the history of DERP region reliability,
+// which magicsock uses to pick the home DERP region, and the DERP server
+// keys from the state store, and starts saving them periodically.
+func (b *LocalBackend) loadDERPRegionHistory() {
+	b.loadDERPServerKeys()
+	bs, err := b.store.ReadState(ipn.DERPRegionHistoryStateKey)
+	switch {
+	case errors.Is(err, ipn.ErrStateNotExist) || err == nil && len(bs) == 0:
+	case err != nil:
+		b.logf("reading DERP region history: %v", err)
+	default:
+		if err := b.MagicConn().DERPRegionHistory().UnmarshalJSON(bs); err != nil {
+			b.logf("decoding DERP region history: %v", err)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.derpHistoryTimer = b.clock.AfterFunc(derpHistorySaveInterval, b.saveDERPRegionHistoryPeriodically)
+}
+
+func (b *LocalBackend) saveDERPRegionHistoryPeriodically() {
+	b.saveDERPRegionHistory()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.shutdownCalled {
+		b.derpHistoryTimer = b.clock.AfterFunc(derpHistorySaveInterval, b.saveDERPRegionHistoryPeriodically)
+	}
+}
+
+// saveDERPRegionHistory writes the history of DERP region reliability and
+// the DERP server keys to the state store.
+func (b *LocalBackend) saveDERPRegionHistory() {
+	b.saveDERPServerKeys()
+	bs, err := b.MagicConn().DERPRegionHistory().MarshalJSON()
+	if err != nil {
+		b.logf("encoding DERP region history: %v", err)
+		return
+	}
+	if err := ipn.WriteState(b.store, ipn.DERPRegionHistoryStateKey, bs); err != nil {
+		b.logf("writing DERP region history: %v", err)
+	}
+}
+
+// loadDERPServerKeys restores the public keys of DERP servers learned over
+// TLS by earlier runs from the state store, so that derphttp can fall back
+// to plaintext with a DERP server even if TLS to it is blocked from the
+// start.
+func (b *LocalBackend) loadDERPServerKeys() {
+	bs, err := b.store.ReadState(ipn.DERPServerKeysStateKey)
+	switch {
+	case errors.Is(err, ipn.ErrStateNotExist) || err == nil && len(bs) == 0:
+		return
+	case err != nil:
+		b.logf("reading DERP server keys: %v", err)
+		return
+	}
+	var pins map[string]key.NodePublic
+	if err := json.Unmarshal(bs, &pins); err != nil {
+		b.logf("decoding DERP server keys: %v", err)
+		return
+	}
+	derphttp.AddServerKeyPins(pins)
+}
+
+// saveDERPServerKeys writes the public keys of DERP servers learned over TLS
+// to the state store.
+func (b *LocalBackend) saveDERPServerKeys() {
+	pins := derphttp.ServerKeyPins()
+	if len(pins) == 0 {
+		return
+	}
+	bs, err := json.Marshal(pins)
+	if err != nil {
+		b.logf("encoding DERP server keys: %v", err)
+		return
+	}
+	if err := ipn.WriteState(b.store, ipn.DERPServerKeysStateKey, bs); err != nil {
+		b.logf("writing DERP server keys: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"tailscale.com/health"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// SetExtraDERPMap sets a locally configured DERP map fragment whose regions
+// are added to the DERP map provided by the control server. This lets
+// hybrid deployments add their own (e.g. on-prem) DERP regions without
+// running a custom control server.
+//
+// The precedence rules are:
+//
+//   - The control server's DERP map is authoritative. If it has a region
+//     with the same RegionID as a local region, the control server's region
+//     is used and the local one is ignored, which is reported as a health
+//     warning.
+//   - Local regions are only added to a DERP map provided by the control
+//     server. If the control server disables DERP (sends no DERP map), the
+//     local regions aren't used either.
+//   - The fragment may only contain Regions. The control server's
+//     HomeParams and OmitDefaultRegions settings always apply.
+//
+// The effective, merged DERP map is what the rest of the system, including
+// "tailscale debug derp-map" and netcheck, sees as the netmap's DERPMap.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetExtraDERPMap(dm *tailcfg.DERPMap) error {
+	if err := checkExtraDERPMap(dm); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.extraDERPMap = dm
+	return nil
+}
+
+// checkExtraDERPMap reports whether dm is a valid DERP map fragment for
+// SetExtraDERPMap.
+func checkExtraDERPMap(dm *tailcfg.DERPMap) error {
+	if dm == nil {
+		return nil
+	}
+	if dm.HomeParams != nil || dm.OmitDefaultRegions {
+		return errors.New("extra DERP map may only contain Regions")
+	}
+	if len(dm.Regions) == 0 {
+		return errors.New("extra DERP map has no regions")
+	}
+	for _, id := range dm.RegionIDs() {
+		r := dm.Regions[id]
+		switch {
+		case id <= 0:
+			return fmt.Errorf("extra DERP region %d: RegionID must be positive", id)
+		case r == nil:
+			return fmt.Errorf("extra DERP region %d: region is null", id)
+		case r.RegionID != id:
+			return fmt.Errorf("extra DERP region %d: RegionID is %d; must match its key", id, r.RegionID)
+		case len(r.Nodes) == 0:
+			return fmt.Errorf("extra DERP region %d: no nodes", id)
+		}
+		for i, n := range r.Nodes {
+			switch {
+			case n == nil:
+				return fmt.Errorf("extra DERP region %d: node %d is null", id, i)
+			case n.Name == "":
+				return fmt.Errorf("extra DERP region %d: node %d has no Name", id, i)
+			case n.RegionID != id:
+				return fmt.Errorf("extra DERP region %d: node %q has RegionID %d", id, n.Name, n.RegionID)
+			case n.HostName == "":
+				return fmt.Errorf("extra DERP region %d: node %q has no HostName", id, n.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeDERPMaps returns control with the regions of extra added, following
+// the precedence rules documented on LocalBackend.SetExtraDERPMap. It
+// doesn't modify either map. ignored are the IDs of the regions of extra
+// that were shadowed by a region of control.
+func mergeDERPMaps(control, extra *tailcfg.DERPMap) (merged *tailcfg.DERPMap, ignored []int) {
+	if control == nil || extra == nil {
+		return control, nil
+	}
+	merged = new(tailcfg.DERPMap)
+	*merged = *control
+	merged.Regions = maps.Clone(control.Regions)
+	if merged.Regions == nil {
+		merged.Regions = make(map[int]*tailcfg.DERPRegion, len(extra.Regions))
+	}
+	for _, id := range extra.RegionIDs() {
+		if _, ok := merged.Regions[id]; ok {
+			ignored = append(ignored, id)
+			continue
+		}
+		merged.Regions[id] = extra.Regions[id]
+	}
+	return merged, ignored
+}
+
+var extraDERPRegionConflictWarnable = health.Register(&health.Warnable{
+	Code:     "extra-derp-region-conflict",
+	Title:    "Local DERP regions ignored",
+	Severity: health.SeverityLow,
+	Text: func(args health.Args) string {
+		return "Some locally configured DERP regions have the same IDs as regions provided by the coordination server and are being ignored: " + args[health.ArgDERPRegionID]
+	},
+})
+
+// mergeExtraDERPMapLocked replaces the DERP map of nm, which must be a new
+// netmap from the control server, with the result of merging it with the
+// local DERP map fragment, if any.
+//
+// b.mu must be held.
+func (b *LocalBackend) mergeExtraDERPMapLocked(nm *netmap.NetworkMap) {
+	if b.extraDERPMap == nil || nm.DERPMap == nil {
+		return
+	}
+	var ignored []int
+	nm.DERPMap, ignored = mergeDERPMaps(nm.DERPMap, b.extraDERPMap)
+	if len(ignored) == 0 {
+		b.health.SetHealthy(extraDERPRegionConflictWarnable)
+		return
+	}
+	ids := make([]string, len(ignored))
+	for i, id := range ignored {
+		ids[i] = fmt.Sprint(id)
+	}
+	b.health.SetUnhealthy(extraDERPRegionConflictWarnable, health.Args{
+		health.ArgDERPRegionID: strings.Join(ids, ", "),
+	})
+}
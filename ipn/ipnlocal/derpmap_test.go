@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func testDERPRegion(id int, code string) *tailcfg.DERPRegion {
+	return &tailcfg.DERPRegion{
+		RegionID:   id,
+		RegionCode: code,
+		Nodes: []*tailcfg.DERPNode{{
+			Name:     code + "a",
+			RegionID: id,
+			HostName: code + ".example.com",
+		}},
+	}
+}
+
+func TestMergeDERPMaps(t *testing.T) {
+	control := &tailcfg.DERPMap{
+		HomeParams: &tailcfg.DERPHomeParams{RegionScore: map[int]float64{1: 2}},
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: testDERPRegion(1, "ctl1"),
+			2: testDERPRegion(2, "ctl2"),
+		},
+	}
+	extra := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			2:   testDERPRegion(2, "local2"),
+			900: testDERPRegion(900, "local900"),
+		},
+	}
+
+	merged, ignored := mergeDERPMaps(control, extra)
+	if want := []int{2}; !reflect.DeepEqual(ignored, want) {
+		t.Errorf("ignored = %v; want %v", ignored, want)
+	}
+	if got, want := merged.RegionIDs(), []int{1, 2, 900}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged regions = %v; want %v", got, want)
+	}
+	if got := merged.Regions[2].RegionCode; got != "ctl2" {
+		t.Errorf("region 2 = %q; want control's region", got)
+	}
+	if merged.HomeParams != control.HomeParams {
+		t.Error("control's HomeParams not kept")
+	}
+	if len(control.Regions) != 2 {
+		t.Errorf("control map modified: %v", control.RegionIDs())
+	}
+
+	if got, _ := mergeDERPMaps(nil, extra); got != nil {
+		t.Errorf("merge with nil control map = %v; want nil", got)
+	}
+	if got, _ := mergeDERPMaps(control, nil); got != control {
+		t.Errorf("merge with nil extra map = %v; want control's", got)
+	}
+}
+
+func TestCheckExtraDERPMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		dm      *tailcfg.DERPMap
+		wantErr string
+	}{
+		{"nil", nil, ""},
+		{"valid", &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{900: testDERPRegion(900, "x")}}, ""},
+		{"no-regions", &tailcfg.DERPMap{}, "no regions"},
+		{"omit-default", &tailcfg.DERPMap{
+			OmitDefaultRegions: true,
+			Regions:            map[int]*tailcfg.DERPRegion{900: testDERPRegion(900, "x")},
+		}, "may only contain Regions"},
+		{"key-mismatch", &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{901: testDERPRegion(900, "x")}}, "must match its key"},
+		{"no-nodes", &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{900: {RegionID: 900}}}, "no nodes"},
+		{"no-hostname", &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{900: {
+			RegionID: 900,
+			Nodes:    []*tailcfg.DERPNode{{Name: "a", RegionID: 900}},
+		}}}, "no HostName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExtraDERPMap(tt.dm)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v; want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeExtraDERPMapHealth(t *testing.T) {
+	b := newTestLocalBackend(t)
+	if err := b.SetExtraDERPMap(&tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1:   testDERPRegion(1, "local1"),
+			900: testDERPRegion(900, "local900"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	hasWarning := func() bool {
+		for _, s := range b.health.Strings() {
+			if strings.Contains(s, "DERP regions") {
+				return true
+			}
+		}
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	nm := &netmap.NetworkMap{DERPMap: &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{1: testDERPRegion(1, "ctl1")},
+	}}
+	b.mergeExtraDERPMapLocked(nm)
+	if got, want := nm.DERPMap.RegionIDs(), []int{1, 900}; !reflect.DeepEqual(got, want) {
+		t.Errorf("regions = %v; want %v", got, want)
+	}
+	if !hasWarning() {
+		t.Errorf("no health warning for conflicting region; got %q", b.health.Strings())
+	}
+
+	nm = &netmap.NetworkMap{DERPMap: &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{2: testDERPRegion(2, "ctl2")},
+	}}
+	b.mergeExtraDERPMapLocked(nm)
+	if hasWarning() {
+		t.Errorf("health warning remains after conflict resolved: %q", b.health.Strings())
+	}
+}
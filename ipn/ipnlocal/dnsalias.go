@@ -0,0 +1,81 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"slices"
+
+	xmaps "golang.org/x/exp/maps"
+	"tailscale.com/net/dns"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/dnsname"
+)
+
+// addDNSAliases adds MagicDNS records to dcfg.Hosts for the DNS aliases
+// that nodes request in Hostinfo.DNSAliases, resolving each to the same
+// addresses as the node's own name.
+//
+// Only the aliases of nodes that control has granted
+// tailcfg.NodeAttrDNSAliases are honored. An alias never replaces an
+// existing record, such as a node's name or one of nm.DNS.ExtraRecords,
+// and an alias requested by more than one node is ignored rather than
+// resolved to either of them.
+//
+// It must be called after dcfg.Hosts has been populated with the names of
+// the nodes.
+func addDNSAliases(dcfg *dns.Config, nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.NodeView, logf logger.Logf) {
+	suffix := nm.MagicDNSSuffix()
+	if suffix == "" {
+		return
+	}
+	owners := map[dnsname.FQDN][]dnsname.FQDN{} // alias => names of nodes requesting it
+	add := func(n tailcfg.NodeView, name string) {
+		if !n.Valid() || !n.HasCap(tailcfg.NodeAttrDNSAliases) || !n.Hostinfo().Valid() {
+			return
+		}
+		nodeFQDN, err := dnsname.ToFQDN(name)
+		if err != nil {
+			return
+		}
+		if _, ok := dcfg.Hosts[nodeFQDN]; !ok {
+			return
+		}
+		aliases := n.Hostinfo().DNSAliases()
+		for i := range aliases.Len() {
+			alias := aliases.At(i)
+			if err := tailcfg.CheckDNSAlias(alias); err != nil {
+				logf("dns: ignoring invalid alias %q of %s: %v", alias, nodeFQDN.WithoutTrailingDot(), err)
+				continue
+			}
+			fqdn, err := dnsname.ToFQDN(alias + "." + suffix)
+			if err != nil {
+				continue
+			}
+			if !slices.Contains(owners[fqdn], nodeFQDN) {
+				owners[fqdn] = append(owners[fqdn], nodeFQDN)
+			}
+		}
+	}
+	add(nm.SelfNode, nm.Name)
+	for _, peer := range peers {
+		add(peer, peer.Name())
+	}
+
+	aliases := xmaps.Keys(owners)
+	slices.Sort(aliases)
+	for _, alias := range aliases {
+		nodes := owners[alias]
+		if _, ok := dcfg.Hosts[alias]; ok {
+			logf("dns: ignoring alias %s of %s; the name is already in use", alias.WithoutTrailingDot(), nodes[0].WithoutTrailingDot())
+			continue
+		}
+		if len(nodes) > 1 {
+			logf("dns: ignoring alias %s requested by %d nodes", alias.WithoutTrailingDot(), len(nodes))
+			continue
+		}
+		dcfg.Hosts[alias] = slices.Clone(dcfg.Hosts[nodes[0]])
+	}
+}
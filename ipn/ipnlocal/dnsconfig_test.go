@@ -255,6 +255,28 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "dnssec",
+			nm: &netmap.NetworkMap{
+				DNS: tailcfg.DNSConfig{
+					Resolvers: []*dnstype.Resolver{
+						{Addr: "8.8.8.8"},
+					},
+				},
+			},
+			prefs: &ipn.Prefs{
+				CorpDNS:        true,
+				ValidateDNSSEC: true,
+			},
+			want: &dns.Config{
+				Hosts: map[dnsname.FQDN][]netip.Addr{},
+				DefaultResolvers: []*dnstype.Resolver{
+					{Addr: "8.8.8.8"},
+				},
+				Routes:         map[dnsname.FQDN][]*dnstype.Resolver{},
+				ValidateDNSSEC: true,
+			},
+		},
 		{
 			// Prior to fixing https://github.com/tailscale/tailscale/issues/2116,
 			// Android had cases where it needed FallbackResolvers. This was the
@@ -310,6 +332,51 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "dns_aliases",
+			nm: &netmap.NetworkMap{
+				Name: "myname.tail.ts.net",
+				SelfNode: (&tailcfg.Node{
+					Addresses: ipps("100.101.101.101"),
+					CapMap:    tailcfg.NodeCapMap{tailcfg.NodeAttrDNSAliases: nil},
+					Hostinfo: (&tailcfg.Hostinfo{
+						DNSAliases: []string{"shared"},
+					}).View(),
+				}).View(),
+			},
+			peers: nodeViews([]*tailcfg.Node{
+				{
+					ID:        1,
+					Name:      "peera.tail.ts.net",
+					Addresses: ipps("100.102.0.1"),
+					CapMap:    tailcfg.NodeCapMap{tailcfg.NodeAttrDNSAliases: nil},
+					Hostinfo: (&tailcfg.Hostinfo{
+						DNSAliases: []string{"jellyfin", "shared", "peerb", "Bad"},
+					}).View(),
+				},
+				{
+					ID:        2,
+					Name:      "peerb.tail.ts.net",
+					Addresses: ipps("100.102.0.2"),
+					Hostinfo: (&tailcfg.Hostinfo{
+						DNSAliases: []string{"no-cap"},
+					}).View(),
+				},
+			}),
+			prefs: &ipn.Prefs{},
+			want: &dns.Config{
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+				Hosts: map[dnsname.FQDN][]netip.Addr{
+					"myname.tail.ts.net.":   ips("100.101.101.101"),
+					"peera.tail.ts.net.":    ips("100.102.0.1"),
+					"peerb.tail.ts.net.":    ips("100.102.0.2"),
+					"jellyfin.tail.ts.net.": ips("100.102.0.1"),
+				},
+			},
+			wantLog: "dns: ignoring invalid alias \"Bad\" of peera.tail.ts.net: DNS aliases must be lowercase\n" +
+				"dns: ignoring alias peerb.tail.ts.net of peera.tail.ts.net; the name is already in use\n" +
+				"dns: ignoring alias shared.tail.ts.net requested by 2 nodes\n",
+		},
 		{
 			name: "not_exit_node_NOT_need_fallbacks",
 			nm: &netmap.NetworkMap{
@@ -4,8 +4,12 @@
 package ipnlocal
 
 import (
+	"slices"
+	"strings"
 	"time"
 
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -25,6 +29,39 @@
 // clock timings.
 const minClockDelta = 1 * time.Minute
 
+// defaultKeyExpiryWarnThresholds are how long before a node key expires
+// that a KeyExpiryWarning is sent, unless TS_KEY_EXPIRY_WARN_THRESHOLDS
+// says otherwise.
+var defaultKeyExpiryWarnThresholds = []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour}
+
+// keyExpiryWarnThresholds returns the key expiry warning thresholds from
+// TS_KEY_EXPIRY_WARN_THRESHOLDS, a comma-separated list of durations such
+// as "72h,1h", largest first. The value "off" turns the warnings off.
+func keyExpiryWarnThresholds(logf logger.Logf) []time.Duration {
+	v := envknob.String("TS_KEY_EXPIRY_WARN_THRESHOLDS")
+	switch v {
+	case "":
+		return defaultKeyExpiryWarnThresholds
+	case "off":
+		return nil
+	}
+	var ths []time.Duration
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f)
+		if err != nil || d <= 0 {
+			logf("ignoring invalid TS_KEY_EXPIRY_WARN_THRESHOLDS %q; using defaults", v)
+			return defaultKeyExpiryWarnThresholds
+		}
+		ths = append(ths, d)
+	}
+	slices.Sort(ths)
+	slices.Reverse(ths)
+	return slices.Compact(ths)
+}
+
 // expiryManager tracks the state of expired nodes and the delta from the
 // current clock time to the time returned from control, and allows mutating a
 // netmap to mark peers as expired based on the current delta-adjusted time.
@@ -38,13 +75,29 @@ type expiryManager struct {
 	//    time.Now().Add(clockDelta) == MapResponse.ControlTime
 	clockDelta syncs.AtomicValue[time.Duration]
 
+	// warnThresholds are how long before a node key expires to warn
+	// about it, largest first.
+	warnThresholds []time.Duration
+
+	// warned stores the nodes whose key expiry has been warned about, so
+	// that each threshold is only warned about once per expiry time.
+	warned map[tailcfg.StableNodeID]expiryWarned
+
 	logf  logger.Logf
 	clock tstime.Clock
 }
 
+// expiryWarned is the last key expiry warning sent about a node.
+type expiryWarned struct {
+	expiry    time.Time
+	threshold time.Duration
+}
+
 func newExpiryManager(logf logger.Logf) *expiryManager {
 	return &expiryManager{
 		previouslyExpired: map[tailcfg.StableNodeID]bool{},
+		warnThresholds:    keyExpiryWarnThresholds(logf),
+		warned:            map[tailcfg.StableNodeID]expiryWarned{},
 		logf:              logf,
 		clock:             tstime.StdClock{},
 	}
@@ -218,6 +271,99 @@ func (em *expiryManager) nextPeerExpiry(nm *netmap.NetworkMap, localNow time.Tim
 	return nextExpiry
 }
 
+// expiryWarnings returns a warning for each node in nm, including the self
+// node, whose key expires within one of em.warnThresholds that it hasn't
+// already been warned about.
+//
+// The localNow time should be the output of time.Now for the local system; it
+// will be adjusted by any stored clock skew from ControlTime.
+//
+// This function is safe to call concurrently with onControlTime but not
+// concurrently with any other call to expiryWarnings.
+func (em *expiryManager) expiryWarnings(nm *netmap.NetworkMap, localNow time.Time) []ipn.KeyExpiryWarning {
+	if nm == nil || len(em.warnThresholds) == 0 {
+		return nil
+	}
+	controlNow := localNow.Add(em.clockDelta.Load())
+	if controlNow.Before(flagExpiredPeersEpoch) {
+		return nil
+	}
+
+	var warnings []ipn.KeyExpiryWarning
+	warned := make(map[tailcfg.StableNodeID]expiryWarned)
+	check := func(n tailcfg.NodeView, self bool) {
+		expiry := n.KeyExpiry()
+		if expiry.IsZero() || n.Expired() || !expiry.After(controlNow) {
+			return
+		}
+		prev, ok := em.warned[n.StableID()]
+		if ok && prev.expiry.Equal(expiry) {
+			warned[n.StableID()] = prev
+		}
+		// Find the smallest threshold the time left is within.
+		left := expiry.Sub(controlNow)
+		i := slices.IndexFunc(em.warnThresholds, func(th time.Duration) bool { return th < left })
+		if i == 0 {
+			return // not within any threshold yet
+		}
+		if i < 0 {
+			i = len(em.warnThresholds)
+		}
+		th := em.warnThresholds[i-1]
+		if ok && prev.expiry.Equal(expiry) && prev.threshold <= th {
+			return // already warned
+		}
+		warned[n.StableID()] = expiryWarned{expiry: expiry, threshold: th}
+		warnings = append(warnings, ipn.KeyExpiryWarning{
+			NodeID:    n.StableID(),
+			Name:      n.Name(),
+			Self:      self,
+			Expiry:    expiry,
+			Threshold: th,
+		})
+	}
+	if nm.SelfNode.Valid() {
+		check(nm.SelfNode, true)
+	}
+	for _, peer := range nm.Peers {
+		check(peer, false)
+	}
+	em.warned = warned
+	return warnings
+}
+
+// nextExpiryWarning returns the time at which the next node in nm, including
+// the self node, comes within one of em.warnThresholds of its key expiry, or
+// the zero Time if none will.
+//
+// The localNow time should be the output of time.Now for the local system; it
+// will be adjusted by any stored clock skew from ControlTime.
+func (em *expiryManager) nextExpiryWarning(nm *netmap.NetworkMap, localNow time.Time) time.Time {
+	if nm == nil {
+		return time.Time{}
+	}
+	controlNow := localNow.Add(em.clockDelta.Load())
+	var next time.Time // zero if none
+	check := func(n tailcfg.NodeView) {
+		expiry := n.KeyExpiry()
+		if expiry.IsZero() || n.Expired() {
+			return
+		}
+		for _, th := range em.warnThresholds {
+			if at := expiry.Add(-th); at.After(controlNow) && (next.IsZero() || at.Before(next)) {
+				next = at
+			}
+		}
+	}
+	if nm.SelfNode.Valid() {
+		check(nm.SelfNode)
+	}
+	for _, peer := range nm.Peers {
+		check(peer)
+	}
+	return next
+}
+
 // ControlNow estimates the current time on the control server, calculated as
 // localNow + the delta between local and control server clocks as recorded
 // when the LocalBackend last received a time message from the control server.
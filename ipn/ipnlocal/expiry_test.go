@@ -275,6 +275,42 @@ func TestNextPeerExpiry(t *testing.T) {
 	})
 }
 
+func TestExpiryWarnings(t *testing.T) {
+	now := time.Unix(1673373129, 0)
+	node := func(id tailcfg.NodeID, expiry time.Time) *tailcfg.Node {
+		return &tailcfg.Node{ID: id, StableID: tailcfg.StableNodeID(fmt.Sprint(int64(id))), Name: fmt.Sprintf("n%d.", id), KeyExpiry: expiry}
+	}
+	nm := func(self *tailcfg.Node, peers ...*tailcfg.Node) *netmap.NetworkMap {
+		return &netmap.NetworkMap{SelfNode: self.View(), Peers: nodeViews(peers)}
+	}
+	em := newExpiryManager(t.Logf)
+	em.warnThresholds = []time.Duration{24 * time.Hour, time.Hour}
+	check := func(nm *netmap.NetworkMap, now time.Time, want ...string) {
+		t.Helper()
+		var got []string
+		for _, w := range em.expiryWarnings(nm, now) {
+			got = append(got, fmt.Sprintf("%s/%v/%v", w.NodeID, w.Self, w.Threshold))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("warnings = %q; want %q", got, want)
+		}
+	}
+
+	self := node(1, now.Add(30*time.Hour))
+	check(nm(self, node(2, now.Add(10*time.Hour)), node(3, time.Time{})), now, "2/false/24h0m0s")
+	// Each threshold is only warned about once.
+	check(nm(self, node(2, now.Add(10*time.Hour))), now)
+	// Crossing the next thresholds warns again.
+	later := now.Add(9*time.Hour + 30*time.Minute)
+	check(nm(self, node(2, now.Add(10*time.Hour))), later, "1/true/24h0m0s", "2/false/1h0m0s")
+	// A renewed key is warned about from scratch.
+	check(nm(self, node(2, now.Add(20*time.Hour))), later, "2/false/24h0m0s")
+
+	if got, want := em.nextExpiryWarning(nm(self, node(2, now.Add(20*time.Hour))), now), now.Add(6*time.Hour); !got.Equal(want) {
+		t.Errorf("nextExpiryWarning = %v; want %v", got, want)
+	}
+}
+
 func formatNodes(nodes []tailcfg.NodeView) string {
 	var sb strings.Builder
 	for i, n := range nodes {
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"math"
+	"net/netip"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/mak"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// PeerKeepalive returns how this node keeps its connection to the peer
+// with Tailscale IP ip alive.
+func (b *LocalBackend) PeerKeepalive(ip netip.Addr) (ipnstate.PeerKeepalive, error) {
+	peer, err := b.peerKeyForIP(ip)
+	if err != nil {
+		return ipnstate.PeerKeepalive{}, err
+	}
+	b.mu.Lock()
+	wgKeepalive := b.peerWGKeepalive[peer]
+	b.mu.Unlock()
+	return ipnstate.PeerKeepalive{
+		HeartbeatInterval:  b.MagicConn().PeerHeartbeatInterval(peer),
+		WireGuardKeepalive: time.Duration(wgKeepalive) * time.Second,
+	}, nil
+}
+
+// SetPeerKeepalive sets how this node keeps its connection to the peer
+// with Tailscale IP ip alive, replacing any setting made before. The zero
+// PeerKeepalive restores the defaults.
+//
+// Settings aren't persisted, so a battery-powered client that wants fewer
+// wakeups must apply them again when tailscaled restarts.
+func (b *LocalBackend) SetPeerKeepalive(ip netip.Addr, ka ipnstate.PeerKeepalive) error {
+	if ka.WireGuardKeepalive < 0 || ka.WireGuardKeepalive%time.Second != 0 ||
+		ka.WireGuardKeepalive > math.MaxUint16*time.Second {
+		return errors.New("WireGuard keepalive must be a whole number of seconds, at most 65535")
+	}
+	peer, err := b.peerKeyForIP(ip)
+	if err != nil {
+		return err
+	}
+	if err := b.MagicConn().SetPeerHeartbeatInterval(peer, ka.HeartbeatInterval); err != nil {
+		return err
+	}
+
+	secs := uint16(ka.WireGuardKeepalive / time.Second)
+	b.mu.Lock()
+	changed := b.peerWGKeepalive[peer] != secs
+	if secs == 0 {
+		delete(b.peerWGKeepalive, peer)
+	} else {
+		mak.Set(&b.peerWGKeepalive, peer, secs)
+	}
+	b.mu.Unlock()
+	if changed {
+		b.authReconfig()
+	}
+	return nil
+}
+
+// applyPeerKeepalive sets the WireGuard persistent keepalive of the peers
+// in cfg that SetPeerKeepalive was called for.
+func (b *LocalBackend) applyPeerKeepalive(cfg *wgcfg.Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.peerWGKeepalive) == 0 {
+		return
+	}
+	for i := range cfg.Peers {
+		if secs, ok := b.peerWGKeepalive[cfg.Peers[i].PublicKey]; ok {
+			cfg.Peers[i].PersistentKeepalive = secs
+		}
+	}
+}
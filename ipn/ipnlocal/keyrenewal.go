@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"time"
+
+	"tailscale.com/control/controlclient"
+	"tailscale.com/ipn"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/clientmetric"
+)
+
+// keyRenewalLeadTime is how long before its expiry the node key is renewed,
+// unless the key's remaining lifetime was too short when we first saw it;
+// see keyRenewalTime.
+const keyRenewalLeadTime = 7 * 24 * time.Hour
+
+var metricKeyRenewals = clientmetric.NewCounter("ipnlocal_node_key_renewals")
+
+// keyRenewalTime returns when to renew a node key that expires at expiry and
+// was first seen at firstSeen: keyRenewalLeadTime before it expires, or
+// halfway through its remaining lifetime when first seen if that's later,
+// so that short-lived keys aren't renewed as soon as they're issued.
+func keyRenewalTime(firstSeen, expiry time.Time) time.Time {
+	lead := min(keyRenewalLeadTime, expiry.Sub(firstSeen)/2)
+	return expiry.Add(-lead)
+}
+
+// scheduleKeyRenewalLocked (re)schedules the automatic renewal of the node
+// key for the new netmap nm from the control server. See
+// ipn.Prefs.NoAutoKeyRenewal.
+//
+// b.mu must be held.
+func (b *LocalBackend) scheduleKeyRenewalLocked(nm *netmap.NetworkMap) {
+	b.stopKeyRenewalLocked()
+	now := b.clock.Now()
+	if nm.NodeKey != b.keyRenewalNodeKey {
+		b.keyRenewalNodeKey = nm.NodeKey
+		b.keyRenewalFirstSeen = now
+	}
+	if nm.Expiry.IsZero() || !nm.Expiry.After(now) || b.keyRenewalAttempted == nm.NodeKey {
+		// Key expiry is disabled, the key has already expired and needs
+		// an interactive login, or we already tried.
+		return
+	}
+	nk := nm.NodeKey
+	d := keyRenewalTime(b.keyRenewalFirstSeen, nm.Expiry).Sub(now)
+	b.keyRenewalTimer = b.clock.AfterFunc(max(d, 0), func() { b.renewNodeKey(nk) })
+}
+
+// stopKeyRenewalLocked cancels the scheduled renewal of the node key, if
+// any, as when logging out or shutting down.
+//
+// b.mu must be held.
+func (b *LocalBackend) stopKeyRenewalLocked() {
+	if b.keyRenewalTimer != nil {
+		b.keyRenewalTimer.Stop()
+		b.keyRenewalTimer = nil
+	}
+}
+
+// keyRenewalDeclinedLocked handles the control server declining the renewal
+// of the node key without an interactive login: the node keeps using its
+// current key, so no login is in progress anymore.
+//
+// b.mu must be held.
+func (b *LocalBackend) keyRenewalDeclinedLocked() {
+	b.logf("node key renewal declined; keeping the current key until it needs a login")
+	b.loginStartedAt = time.Time{}
+}
+
+// renewNodeKey asks the control client to renew the node key nk, if it's
+// still the current node key and an automatic renewal is still wanted.
+func (b *LocalBackend) renewNodeKey(nk key.NodePublic) {
+	b.mu.Lock()
+	cc := b.cc
+	nm := b.netMap
+	ok := cc != nil &&
+		nm != nil &&
+		nm.NodeKey == nk &&
+		b.state.State() == ipn.Running &&
+		!b.keyExpired &&
+		b.authURL == "" &&
+		b.keyRenewalAttempted != nk &&
+		!b.pm.CurrentPrefs().NoAutoKeyRenewal()
+	if ok {
+		b.keyRenewalAttempted = nk
+		b.noteLoginStartedLocked()
+	}
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	b.logf("renewing node key %v, which expires at %v", nk.ShortString(), nm.Expiry.UTC().Format(time.RFC3339))
+	metricKeyRenewals.Add(1)
+	cc.Login(controlclient.LoginRenewKey)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/control/controlclient"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func TestKeyRenewalTime(t *testing.T) {
+	now := time.Unix(1000, 0)
+	day := 24 * time.Hour
+	tests := []struct {
+		name   string
+		expiry time.Time
+		want   time.Time
+	}{
+		{"long-lived", now.Add(180 * day), now.Add(173 * day)},
+		{"short-lived", now.Add(2 * day), now.Add(day)},
+		{"lead-time-boundary", now.Add(14 * day), now.Add(7 * day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyRenewalTime(now, tt.expiry); !got.Equal(tt.want) {
+				t.Errorf("keyRenewalTime = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleKeyRenewal(t *testing.T) {
+	b := newTestLocalBackend(t)
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	b.clock = clock
+	nk := key.NewNode().Public()
+
+	schedule := func(expiry time.Time) bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.scheduleKeyRenewalLocked(&netmap.NetworkMap{NodeKey: nk, Expiry: expiry})
+		return b.keyRenewalTimer != nil
+	}
+
+	if schedule(time.Time{}) {
+		t.Error("renewal scheduled for a key that doesn't expire")
+	}
+	if schedule(clock.Now().Add(-time.Minute)) {
+		t.Error("renewal scheduled for an expired key")
+	}
+	if !schedule(clock.Now().Add(30 * 24 * time.Hour)) {
+		t.Fatal("renewal not scheduled for an expiring key")
+	}
+	firstSeen := b.keyRenewalFirstSeen
+
+	// Later netmaps with the same key must not move the first-seen time,
+	// which would keep postponing short-lived keys' renewals.
+	clock.Advance(time.Hour)
+	if !schedule(clock.Now().Add(30 * 24 * time.Hour)) {
+		t.Fatal("renewal not rescheduled")
+	}
+	if !b.keyRenewalFirstSeen.Equal(firstSeen) {
+		t.Errorf("first seen time changed from %v to %v", firstSeen, b.keyRenewalFirstSeen)
+	}
+
+	b.keyRenewalAttempted = nk
+	if schedule(clock.Now().Add(30 * 24 * time.Hour)) {
+		t.Error("renewal rescheduled for a key we already tried to renew")
+	}
+}
+
+func TestKeyRenewalStoppedOnShutdown(t *testing.T) {
+	b := newTestLocalBackend(t)
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	b.clock = clock
+	b.mu.Lock()
+	b.scheduleKeyRenewalLocked(&netmap.NetworkMap{
+		NodeKey: key.NewNode().Public(),
+		Expiry:  clock.Now().Add(30 * 24 * time.Hour),
+	})
+	scheduled := b.keyRenewalTimer != nil
+	b.mu.Unlock()
+	if !scheduled {
+		t.Fatal("renewal not scheduled")
+	}
+
+	b.Shutdown()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keyRenewalTimer != nil {
+		t.Error("renewal still scheduled after Shutdown")
+	}
+}
+
+func TestKeyRenewalDeclined(t *testing.T) {
+	b := newTestLocalBackend(t)
+	cc := newClient(t, controlclient.Options{Logf: b.logf})
+	b.mu.Lock()
+	b.cc = cc
+	b.noteLoginStartedLocked()
+	b.mu.Unlock()
+
+	b.SetControlClientStatus(cc, controlclient.Status{Err: controlclient.ErrKeyRenewalNeedsLogin})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.loginStartedAt.IsZero() {
+		t.Error("declined renewal still counted as a login in progress")
+	}
+}
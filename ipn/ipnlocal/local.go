@@ -72,6 +72,7 @@
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/omit"
 	"tailscale.com/paths"
 	"tailscale.com/portlist"
 	"tailscale.com/syncs"
@@ -93,6 +94,7 @@
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/views"
+	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/deephash"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/httpm"
@@ -102,6 +104,7 @@
 	"tailscale.com/util/osuser"
 	"tailscale.com/util/rands"
 	"tailscale.com/util/set"
+	"tailscale.com/util/supervisor"
 	"tailscale.com/util/syspolicy"
 	"tailscale.com/util/systemd"
 	"tailscale.com/util/testenv"
@@ -177,10 +180,11 @@ type LocalBackend struct {
 	keyLogf               logger.Logf        // for printing list of peers on change
 	statsLogf             logger.Logf        // for printing peers stats on change
 	sys                   *tsd.System
-	health                *health.Tracker // always non-nil
-	e                     wgengine.Engine // non-nil; TODO(bradfitz): remove; use sys
-	store                 ipn.StateStore  // non-nil; TODO(bradfitz): remove; use sys
-	dialer                *tsdial.Dialer  // non-nil; TODO(bradfitz): remove; use sys
+	health                *health.Tracker        // always non-nil
+	supervisor            *supervisor.Supervisor // recovers from peerapi panics
+	e                     wgengine.Engine        // non-nil; TODO(bradfitz): remove; use sys
+	store                 ipn.StateStore         // non-nil; TODO(bradfitz): remove; use sys
+	dialer                *tsdial.Dialer         // non-nil; TODO(bradfitz): remove; use sys
 	pushDeviceToken       syncs.AtomicValue[string]
 	backendLogID          logid.PublicID
 	unregisterNetMon      func()
@@ -218,14 +222,27 @@ type LocalBackend struct {
 	// is never called.
 	getTCPHandlerForFunnelFlow func(srcAddr netip.AddrPort, dstPort uint16) (handler func(net.Conn))
 
+	// dropLog records the packets dropped by the packet filters, for
+	// debugging. Every filter passed to setFilter shares it.
+	dropLog *filter.DropLog
+
+	// connLog records the inbound connections accepted by the packet
+	// filters for the connection audit log, while it's enabled; see
+	// connaudit.go. Every filter passed to setFilter shares it.
+	connLog       *filter.ConnLog
+	connAuditOnce sync.Once
+	connAuditLog  *connAuditLog // set by connAudit
+
 	filterAtomic                 atomic.Pointer[filter.Filter]
 	containsViaIPFuncAtomic      syncs.AtomicValue[func(netip.Addr) bool]
 	shouldInterceptTCPPortAtomic syncs.AtomicValue[func(uint16) bool]
+	shouldInterceptUDPPortAtomic syncs.AtomicValue[func(uint16) bool]
 	numClientStatusCalls         atomic.Uint32
 
 	// The mutex protects the following elements.
 	mu             sync.Mutex
 	conf           *conffile.Config // latest parsed config, or nil if not in declarative mode
+	extraDERPMap   *tailcfg.DERPMap // local DERP map fragment from SetExtraDERPMap, or nil
 	pm             *profileManager  // mu guards access
 	filterHash     deephash.Sum
 	httpTestClient *http.Client       // for controlclient. nil by default, used by tests.
@@ -238,7 +255,7 @@ type LocalBackend struct {
 	ccAuto         *controlclient.Auto // if cc is of type *controlclient.Auto
 	machinePrivKey key.MachinePrivate
 	tka            *tkaState
-	state          ipn.State
+	state          ipn.StateMachine
 	capFileSharing bool // whether netMap contains the file sharing capability
 	capTailnetLock bool // whether netMap contains the tailnet lock capability
 	// hostinfo is mutated in-place while mu is held.
@@ -271,6 +288,40 @@ type LocalBackend struct {
 	fileWaiters      set.HandleSet[context.CancelFunc] // of wake-up funcs
 	notifyWatchers   map[string]*watchSession          // by session ID
 	lastStatusTime   time.Time                         // status.AsOf value of the last processed status update
+
+	// Control plane latency tracking; see ctrllatency.go.
+	firstNetmapWaitSince time.Time // when Start was called, until the first netmap arrives
+	loginStartedAt       time.Time // when the control client was asked to log in, if in progress
+	netmapGen            uint64    // incremented for each netmap from the control server
+	netmapPendingSince   time.Time // when the oldest netmap not yet applied to the engine arrived
+
+	// For bug report bundles; see bugreport.go.
+	logSnapshotFunc  func(io.Writer) (int64, error) // or nil if SetLogSnapshotter wasn't called
+	lastRouterConfig *router.Config                 // last router config applied by authReconfig, or nil
+
+	// Automatic node key renewal; see keyrenewal.go.
+	keyRenewalTimer     tstime.TimerController // or nil
+	keyRenewalNodeKey   key.NodePublic         // node key of the last netmap
+	keyRenewalFirstSeen time.Time              // when keyRenewalNodeKey was first seen
+	keyRenewalAttempted key.NodePublic         // last node key we tried to renew
+
+	// Declared services and their health checks; see services.go.
+	declaredServices       []tailcfg.DeclaredService        // for declaredServicesFor
+	declaredServicesFor    ipn.ProfileID                    // profile declaredServices were loaded for
+	declaredServicesLoaded bool                             // whether declaredServices is valid
+	serviceProbeTimer      tstime.TimerController           // or nil
+	serviceHealth          map[string]tailcfg.ServiceHealth // by service name, from health checks
+
+	// Route flap damping; see routedamp.go.
+	routeDamp      routeDamper
+	routeDampKey   routeDampKey           // prefs routeDamp's history is for
+	routeDampTimer tstime.TimerController // or nil
+
+	derpHistoryTimer tstime.TimerController // saves the DERP region history; see derphistory.go
+
+	peerWGKeepalive map[key.NodePublic]uint16 // WireGuard keepalive seconds by peer; see keepalive.go
+	subnetFailover  subnetFailover            // see subnetfailover.go
+
 	// directFileRoot, if non-empty, means to write received files
 	// directly to this directory, without staging them in an
 	// intermediate buffered directory for "pick-up" later. If
@@ -310,6 +361,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	udpForwarders      map[uint16]*udpForwarder          // serve UDP port => its forwarder
 
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -427,12 +479,12 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		statsLogf:             logger.LogOnChange(logf, 5*time.Minute, clock.Now),
 		sys:                   sys,
 		health:                sys.HealthTracker(),
+		supervisor:            supervisor.New(logf, sys.HealthTracker()),
 		e:                     e,
 		dialer:                dialer,
 		store:                 store,
 		pm:                    pm,
 		backendLogID:          logID,
-		state:                 ipn.NoState,
 		portpoll:              new(portlist.Poller),
 		em:                    newExpiryManager(logf),
 		gotPortPollRes:        make(chan struct{}),
@@ -443,8 +495,11 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		captiveCtx:            captiveCtx,
 		captiveCancel:         nil, // so that we start checkCaptivePortalLoop when Running
 		needsCaptiveDetection: make(chan bool),
+		dropLog:               filter.NewDropLog(dropLogSize),
 	}
+	b.connLog = filter.NewConnLog(b.onAcceptedConn)
 	mConn.SetNetInfoCallback(b.setNetInfo)
+	b.state.OnTransition(b.onStateTransition)
 
 	if sys.InitialConfig != nil {
 		if err := b.setConfigLocked(sys.InitialConfig); err != nil {
@@ -468,9 +523,14 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.e.SetJailedFilter(noneFilter)
 
 	b.setTCPPortsIntercepted(nil)
+	b.setUDPPortsIntercepted(nil)
 
 	b.statusChanged = sync.NewCond(&b.statusLock)
-	b.e.SetStatusCallback(b.setWgengineStatus)
+	b.e.Subscribe(func(ev wgengine.Event) {
+		if se, ok := ev.(wgengine.StatusEvent); ok {
+			b.setWgengineStatus(se.Status, se.Err)
+		}
+	})
 
 	b.prevIfState = netMon.InterfaceState()
 	// Call our linkChange code once with the current state, and
@@ -496,6 +556,9 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		}
 	}
 
+	b.loadDERPRegionHistory()
+	b.loadConnAuditEnabled()
+
 	// initialize Taildrive shares from saved state
 	fs, ok := b.sys.DriveForRemote.GetOK()
 	if ok {
@@ -536,6 +599,8 @@ func (b *LocalBackend) SetComponentDebugLogging(component string, until time.Tim
 	switch component {
 	case "magicsock":
 		setEnabled = b.MagicConn().SetDebugLoggingEnabled
+	case "wgengine":
+		setEnabled = b.e.SetDebugLoggingEnabled
 	case "sockstats":
 		if b.sockstatLogger != nil {
 			setEnabled = func(v bool) {
@@ -690,7 +755,7 @@ func (b *LocalBackend) pauseOrResumeControlClientLocked() {
 		return
 	}
 	networkUp := b.prevIfState.AnyInterfaceUp()
-	b.cc.SetPaused((b.state == ipn.Stopped && b.netMap != nil) || (!networkUp && !testenv.InTest() && !assumeNetworkUpdateForTest()))
+	b.cc.SetPaused((b.state.State() == ipn.Stopped && b.netMap != nil) || (!networkUp && !testenv.InTest() && !assumeNetworkUpdateForTest()))
 }
 
 // captivePortalDetectionInterval is the duration to wait in an unhealthy state with connectivity broken
@@ -712,8 +777,8 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	// If the PAC-ness of the network changed, reconfig wireguard+route to
 	// add/remove subnets.
 	if hadPAC != ifst.HasPAC() {
-		b.logf("linkChange: in state %v; PAC changed from %v->%v", b.state, hadPAC, ifst.HasPAC())
-		switch b.state {
+		b.logf("linkChange: in state %v; PAC changed from %v->%v", b.state.State(), hadPAC, ifst.HasPAC())
+		switch b.state.State() {
 		case ipn.NoState, ipn.Stopped:
 			// Do nothing.
 		default:
@@ -726,7 +791,7 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	b.updateFilterLocked(b.netMap, b.pm.CurrentPrefs())
 	updateExitNodeUsageWarning(b.pm.CurrentPrefs(), delta.New, b.health)
 
-	if peerAPIListenAsync && b.netMap != nil && b.state == ipn.Running {
+	if peerAPIListenAsync && b.netMap != nil && b.state.State() == ipn.Running {
 		want := b.netMap.GetAddresses().Len()
 		if len(b.peerAPIListeners) < want {
 			b.logf("linkChange: peerAPIListeners too low; trying again")
@@ -790,6 +855,26 @@ func (b *LocalBackend) onHealthChange(w *health.Warnable, us *health.UnhealthySt
 	}
 }
 
+// isEphemeralLocked reports whether the node registers as an ephemeral node,
+// either because tailscaled was started without persistent state or because
+// of the Ephemeral pref.
+//
+// b.mu must be held.
+func (b *LocalBackend) isEphemeralLocked() bool {
+	return b.loginFlags&controlclient.LoginEphemeral != 0 || b.pm.CurrentPrefs().Ephemeral()
+}
+
+// ephemeralLoginFlagLocked returns controlclient.LoginEphemeral if the node
+// registers as an ephemeral node, and controlclient.LoginDefault otherwise.
+//
+// b.mu must be held.
+func (b *LocalBackend) ephemeralLoginFlagLocked() controlclient.LoginFlags {
+	if b.isEphemeralLocked() {
+		return controlclient.LoginEphemeral
+	}
+	return controlclient.LoginDefault
+}
+
 // Shutdown halts the backend and all its sub-components. The backend
 // can no longer be used after Shutdown returns.
 func (b *LocalBackend) Shutdown() {
@@ -805,7 +890,7 @@ func (b *LocalBackend) Shutdown() {
 		b.captiveCancel()
 	}
 
-	if b.loginFlags&controlclient.LoginEphemeral != 0 {
+	if b.isEphemeralLocked() {
 		b.mu.Unlock()
 		ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
 		defer cancel()
@@ -833,15 +918,23 @@ func (b *LocalBackend) Shutdown() {
 	if b.notifyCancel != nil {
 		b.notifyCancel()
 	}
+	if b.derpHistoryTimer != nil {
+		b.derpHistoryTimer.Stop()
+		b.derpHistoryTimer = nil
+	}
+	b.stopKeyRenewalLocked()
 	b.mu.Unlock()
 	b.webClientShutdown()
+	b.saveDERPRegionHistory()
+	b.connLog.Close()
+	b.connAudit().flush()
 
 	if b.sockstatLogger != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		b.sockstatLogger.Shutdown(ctx)
 	}
-	if b.peerAPIServer != nil {
+	if b.peerAPIServer != nil && !omit.Taildrop {
 		b.peerAPIServer.taildrop.Shutdown()
 	}
 	b.stopOfflineAutoUpdate()
@@ -906,7 +999,7 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	sb.MutateStatus(func(s *ipnstate.Status) {
 		s.Version = version.Long()
 		s.TUN = !b.sys.IsNetstack()
-		s.BackendState = b.state.String()
+		s.BackendState = b.state.State().String()
 		s.AuthURL = b.authURL
 		if prefs := b.pm.CurrentPrefs(); prefs.Valid() && prefs.AutoUpdate().Check {
 			s.ClientVersion = b.lastClientVersion
@@ -994,6 +1087,7 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 		for _, pln := range b.peerAPIListeners {
 			ss.PeerAPIURL = append(ss.PeerAPIURL, pln.urlStr)
 		}
+		ss.DeclaredServices, _ = b.declaredServicesWithHealthLocked()
 	})
 	// TODO: hostinfo, and its networkinfo
 	// TODO: EngineStatus copy (and deprecate it?)
@@ -1025,20 +1119,21 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 		}
 		online := p.Online()
 		ps := &ipnstate.PeerStatus{
-			InNetworkMap:    true,
-			UserID:          p.User(),
-			AltSharerUserID: p.Sharer(),
-			TailscaleIPs:    tailscaleIPs,
-			HostName:        p.Hostinfo().Hostname(),
-			DNSName:         p.Name(),
-			OS:              p.Hostinfo().OS(),
-			LastSeen:        lastSeen,
-			Online:          online != nil && *online,
-			ShareeNode:      p.Hostinfo().ShareeNode(),
-			ExitNode:        p.StableID() != "" && p.StableID() == exitNodeID,
-			SSH_HostKeys:    p.Hostinfo().SSH_HostKeys().AsSlice(),
-			Location:        p.Hostinfo().Location(),
-			Capabilities:    p.Capabilities().AsSlice(),
+			InNetworkMap:     true,
+			UserID:           p.User(),
+			AltSharerUserID:  p.Sharer(),
+			TailscaleIPs:     tailscaleIPs,
+			HostName:         p.Hostinfo().Hostname(),
+			DNSName:          p.Name(),
+			OS:               p.Hostinfo().OS(),
+			LastSeen:         lastSeen,
+			Online:           online != nil && *online,
+			ShareeNode:       p.Hostinfo().ShareeNode(),
+			ExitNode:         p.StableID() != "" && p.StableID() == exitNodeID,
+			SSH_HostKeys:     p.Hostinfo().SSH_HostKeys().AsSlice(),
+			Location:         p.Hostinfo().Location(),
+			Capabilities:     p.Capabilities().AsSlice(),
+			DeclaredServices: p.Hostinfo().DeclaredServices().AsSlice(),
 		}
 		if cm := p.CapMap(); cm.Len() > 0 {
 			ps.CapMap = make(tailcfg.NodeCapMap, cm.Len())
@@ -1209,6 +1304,10 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		b.logf("Ignoring SetControlClientStatus from old client")
 		return
 	}
+	if errors.Is(st.Err, controlclient.ErrKeyRenewalNeedsLogin) {
+		b.keyRenewalDeclinedLocked()
+		return
+	}
 	if st.Err != nil {
 		// The following do not depend on any data for which we need b locked.
 		unlock.UnlockEarly()
@@ -1232,6 +1331,10 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	if st.NetMap != nil {
 		now := b.clock.Now()
 		b.em.flagExpiredPeers(st.NetMap, now)
+		for _, w := range b.em.expiryWarnings(st.NetMap, now) {
+			b.logf("node key of %v expires in %v, at %v", w.NodeID, w.Expiry.Sub(b.ControlNow(now)).Round(time.Minute), w.Expiry.UTC().Format(time.RFC3339))
+			b.sendLocked(ipn.Notify{KeyExpiryWarning: &w})
+		}
 
 		// Always stop the existing netmap timer if we have a netmap;
 		// it's possible that we have no nodes expiring, so we should
@@ -1245,9 +1348,13 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 			b.nmExpiryTimer = nil
 		}
 
-		// Figure out when the next node in the netmap is expiring so we can
-		// start a timer to reconfigure at that point.
+		// Figure out when the next node in the netmap is expiring, or is
+		// due a key expiry warning, so we can start a timer to reconfigure
+		// or warn at that point.
 		nextExpiry := b.em.nextPeerExpiry(st.NetMap, now)
+		if w := b.em.nextExpiryWarning(st.NetMap, now); !w.IsZero() && (nextExpiry.IsZero() || w.Before(nextExpiry)) {
+			nextExpiry = w
+		}
 		if !nextExpiry.IsZero() {
 			tmrDuration := nextExpiry.Sub(now) + 10*time.Second
 			b.nmExpiryTimer = b.clock.AfterFunc(tmrDuration, func() {
@@ -1277,6 +1384,8 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 			keyExpiryExtended = true
 		}
 		b.keyExpired = isExpired
+		b.scheduleKeyRenewalLocked(st.NetMap)
+		b.scheduleServiceProbesLocked()
 	}
 
 	unlock.UnlockEarly()
@@ -1327,6 +1436,9 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		b.authURL = st.URL
 		b.authURLTime = b.clock.Now()
 	}
+	if st.LoginFinished() {
+		b.noteLoginFinishedLocked()
+	}
 	if (wasBlocked || b.seamlessRenewalEnabled()) && st.LoginFinished() {
 		// Interactive login finished successfully (URL visited).
 		// After an interactive login, the user always wants
@@ -1401,6 +1513,8 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		if !envknob.TKASkipSignatureCheck() {
 			b.tkaFilterNetmapLocked(st.NetMap)
 		}
+		b.mergeExtraDERPMapLocked(st.NetMap)
+		b.noteNetmapReceivedLocked()
 		b.setNetMapLocked(st.NetMap)
 		b.updateFilterLocked(st.NetMap, prefs.View())
 	}
@@ -1850,13 +1964,14 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	}()
 	unlock := b.lockAndGetUnlock()
 	defer unlock()
+	b.noteStartLocked()
 
 	if opts.UpdatePrefs != nil {
 		if err := b.checkPrefsLocked(opts.UpdatePrefs); err != nil {
 			return err
 		}
 	}
-	if b.state != ipn.Running && b.conf != nil && b.conf.Parsed.AuthKey != nil && opts.AuthKey == "" {
+	if b.state.State() != ipn.Running && b.conf != nil && b.conf.Parsed.AuthKey != nil && opts.AuthKey == "" {
 		v := *b.conf.Parsed.AuthKey
 		if filename, ok := strings.CutPrefix(v, "file:"); ok {
 			b, err := os.ReadFile(filename)
@@ -1891,7 +2006,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		hostinfo.Services = b.hostinfo.Services // keep any previous services
 	}
 	b.hostinfo = hostinfo
-	b.state = ipn.NoState
+	b.state.Reset("starting")
 
 	if opts.UpdatePrefs != nil {
 		oldPrefs := b.pm.CurrentPrefs()
@@ -1971,6 +2086,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		Logf:                       logger.WithPrefix(b.logf, "control: "),
 		Persist:                    *persistv,
 		ServerURL:                  serverURL,
+		FallbackServerURLs:         prefs.ControlURLFallbacks().AsSlice(),
 		AuthKey:                    opts.AuthKey,
 		Hostinfo:                   hostinfo,
 		HTTPTestClient:             httpTestClient,
@@ -1986,6 +2102,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		Observer:                   b,
 		C2NHandler:                 http.HandlerFunc(b.handleC2N),
 		DialPlan:                   &b.dialPlan, // pointer because it can't be copied
+		NetmapCache:                b.netmapCacheLocked(),
 		ControlKnobs:               b.sys.ControlKnobs(),
 
 		// Don't warn about broken Linux IP forwarding when
@@ -2032,7 +2149,8 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		// Without this, the state machine transitions to "NeedsLogin" implying
 		// that user interaction is required, which is not the case and can
 		// regress tsnet.Server restarts.
-		cc.Login(controlclient.LoginDefault)
+		b.noteLoginStartedLocked()
+		cc.Login(b.ephemeralLoginFlagLocked())
 	}
 	b.stateMachineLockedOnEntry(unlock)
 
@@ -2132,6 +2250,17 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	if haveNetmap && netMap.SSHPolicy != nil {
 		sshPol = *netMap.SSHPolicy
 	}
+	var (
+		blockedKeys   set.Set[key.NodePublic]
+		blockedIPs    *netipx.IPSet
+		blockedRanges []netipx.IPRange
+	)
+	if haveNetmap {
+		blockedKeys, blockedIPs = blockedPeers(b.peers, prefs)
+	}
+	if blockedIPs != nil {
+		blockedRanges = blockedIPs.Ranges()
+	}
 
 	changed := deephash.Update(&b.filterHash, &struct {
 		HaveNetmap  bool
@@ -2141,10 +2270,18 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		LogNets     []netipx.IPRange
 		ShieldsUp   bool
 		SSHPolicy   tailcfg.SSHPolicy
-	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), shieldsUp, sshPol})
+		BlockedKeys set.Set[key.NodePublic]
+		BlockedIPs  []netipx.IPRange
+	}{haveNetmap, addrs, packetFilter, localNets.Ranges(), logNets.Ranges(), shieldsUp, sshPol, blockedKeys, blockedRanges})
 	if !changed {
 		return
 	}
+	if ms, ok := b.sys.MagicSock.GetOK(); ok {
+		ms.SetBlockedPeers(blockedKeys)
+	}
+	if len(blockedKeys) > 0 {
+		b.logf("[v1] netmap packet filter: blocking %d peers", len(blockedKeys))
+	}
 
 	if !haveNetmap {
 		b.logf("[v1] netmap packet filter: (not ready yet)")
@@ -2155,16 +2292,23 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	}
 
 	oldFilter := b.e.GetFilter()
+	var f *filter.Filter
 	if shieldsUp {
 		b.logf("[v1] netmap packet filter: (shields up)")
-		b.setFilter(filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf))
+		f = filter.NewShieldsUpFilter(localNets, logNets, oldFilter, b.logf)
 	} else {
 		b.logf("[v1] netmap packet filter: %v filters", len(packetFilter))
-		b.setFilter(filter.New(packetFilter, b.srcIPHasCapForFilter, localNets, logNets, oldFilter, b.logf))
+		f = filter.New(packetFilter, b.srcIPHasCapForFilter, localNets, logNets, oldFilter, b.logf)
 	}
+	f.SetBlockedIPs(blockedIPs)
+	b.setFilter(f)
 	// The filter for a jailed node is the exact same as a ShieldsUp filter.
 	oldJailedFilter := b.e.GetJailedFilter()
-	b.e.SetJailedFilter(filter.NewShieldsUpFilter(localNets, logNets, oldJailedFilter, b.logf))
+	jailedFilter := filter.NewShieldsUpFilter(localNets, logNets, oldJailedFilter, b.logf)
+	jailedFilter.SetBlockedIPs(blockedIPs)
+	jailedFilter.SetDropLog(b.dropLog)
+	jailedFilter.SetConnLog(b.connLog)
+	b.e.SetJailedFilter(jailedFilter)
 
 	if b.sshServer != nil {
 		go b.sshServer.OnPolicyChange()
@@ -2327,6 +2471,8 @@ func packetFilterPermitsUnlockedNodes(peers map[tailcfg.NodeID]tailcfg.NodeView,
 }
 
 func (b *LocalBackend) setFilter(f *filter.Filter) {
+	f.SetDropLog(b.dropLog)
+	f.SetConnLog(b.connLog)
 	b.filterAtomic.Store(f)
 	b.e.SetFilter(f)
 }
@@ -2585,8 +2731,8 @@ func (b *LocalBackend) WatchNotifications(ctx context.Context, mask ipn.NotifyWa
 		ini = &ipn.Notify{Version: version.Long()}
 		if mask&ipn.NotifyInitialState != 0 {
 			ini.SessionID = sessionID
-			ini.State = ptr.To(b.state)
-			if b.state == ipn.NeedsLogin && b.authURL != "" {
+			ini.State = ptr.To(b.state.State())
+			if b.state.State() == ipn.NeedsLogin && b.authURL != "" {
 				ini.BrowseToURL = ptr.To(b.authURL)
 			}
 		}
@@ -2756,7 +2902,7 @@ func (b *LocalBackend) sendLocked(n ipn.Notify) {
 	}
 
 	apiSrv := b.peerAPIServer
-	if mayDeref(apiSrv).taildrop.HasFilesWaiting() {
+	if !omit.Taildrop && mayDeref(apiSrv).taildrop.HasFilesWaiting() {
 		n.FilesWaiting = &empty.Message{}
 	}
 
@@ -2777,7 +2923,7 @@ func (b *LocalBackend) sendFileNotify() {
 		wakeWaiter()
 	}
 	apiSrv := b.peerAPIServer
-	if apiSrv == nil {
+	if apiSrv == nil || omit.Taildrop {
 		b.mu.Unlock()
 		return
 	}
@@ -2814,7 +2960,7 @@ func (b *LocalBackend) popBrowserAuthNow() {
 	}
 	b.tellClientToBrowseToURL(url)
 	if b.State() == ipn.Running {
-		b.enterState(ipn.Starting)
+		b.enterState(ipn.Starting, "browser auth required")
 	}
 }
 
@@ -2999,6 +3145,19 @@ func (b *LocalBackend) clearMachineKeyLocked() error {
 // efficient func for ShouldInterceptTCPPort to use, which is called on every
 // incoming packet.
 func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
+	b.shouldInterceptTCPPortAtomic.Store(portSetFunc(ports))
+}
+
+// setUDPPortsIntercepted populates b.shouldInterceptUDPPortAtomic with an
+// efficient func for ShouldInterceptUDPPort to use, which is called on every
+// incoming packet.
+func (b *LocalBackend) setUDPPortsIntercepted(ports []uint16) {
+	b.shouldInterceptUDPPortAtomic.Store(portSetFunc(ports))
+}
+
+// portSetFunc returns an efficient func that reports whether a port is
+// one of ports.
+func portSetFunc(ports []uint16) func(uint16) bool {
 	slices.Sort(ports)
 	uniq.ModifySlice(&ports)
 	var f func(uint16) bool
@@ -3029,19 +3188,21 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 			}
 		}
 	}
-	b.shouldInterceptTCPPortAtomic.Store(f)
+	return f
 }
 
 // setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic,
-// shouldInterceptTCPPortAtomic, and exposeRemoteWebClientAtomicBool from the prefs p,
+// shouldInterceptTCPPortAtomic, shouldInterceptUDPPortAtomic, and exposeRemoteWebClientAtomicBool from the prefs p,
 // which may be !Valid().
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setExposeRemoteWebClientAtomicBoolLocked(p)
+	b.MagicConn().SetDERPPlaintextFallback(p.Valid() && p.DERPPlaintextFallback())
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(ipset.FalseContainsIPFunc())
 		b.setTCPPortsIntercepted(nil)
+		b.setUDPPortsIntercepted(nil)
 		b.lastServeConfJSON = mem.B(nil)
 		b.serveConfig = ipn.ServeConfigView{}
 	} else {
@@ -3056,7 +3217,59 @@ func (b *LocalBackend) State() ipn.State {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	return b.state
+	return b.state.State()
+}
+
+// StateHistory returns the backend's most recent state transitions, oldest
+// first, for debugging.
+func (b *LocalBackend) StateHistory() []ipn.StateTransition {
+	return b.state.History()
+}
+
+// DaemonInfo returns how tailscaled was built and which netstack mode,
+// router and DNS configurator it's using.
+func (b *LocalBackend) DaemonInfo() *ipn.DaemonInfo {
+	di := &ipn.DaemonInfo{
+		Version:   version.GetMeta(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		BuildTags: version.BuildTags(),
+		Netstack:  "off",
+	}
+	switch {
+	case b.sys.IsNetstack():
+		di.Netstack = "all"
+	case b.sys.IsNetstackRouter():
+		di.Netstack = "subnets"
+	}
+	if r, ok := b.sys.Router.GetOK(); ok {
+		di.Router = fmt.Sprintf("%T", r)
+	}
+	if m, ok := b.sys.DNSManager.GetOK(); ok {
+		di.DNS = m.OSConfiguratorType()
+	}
+	return di
+}
+
+// dropLogSize is the number of dropped flows the backend remembers.
+const dropLogSize = 256
+
+// DroppedFlows returns the flows most recently dropped by the packet
+// filter, oldest first, for debugging. Each flow appears at most once
+// every few seconds.
+func (b *LocalBackend) DroppedFlows() []filter.DroppedFlow {
+	return b.dropLog.Entries()
+}
+
+var metricIllegalStateTransitions = clientmetric.NewCounter("ipnlocal_illegal_state_transitions")
+
+// onStateTransition is called for each change of b.state, with b.mu held.
+func (b *LocalBackend) onStateTransition(t ipn.StateTransition) {
+	if t.Illegal {
+		metricIllegalStateTransitions.Add(1)
+		b.logf("[unexpected] illegal ipn state transition %v -> %v (%s)", t.From, t.To, t.Reason)
+	}
 }
 
 // InServerMode reports whether the Tailscale backend is explicitly running in
@@ -3136,6 +3349,7 @@ func (b *LocalBackend) StartLoginInteractive(ctx context.Context) error {
 	url := b.authURL
 	timeSinceAuthURLCreated := b.clock.Since(b.authURLTime)
 	cc := b.cc
+	loginFlags := b.loginFlags | b.ephemeralLoginFlagLocked()
 	b.mu.Unlock()
 	b.logf("StartLoginInteractive: url=%v", url != "")
 
@@ -3145,7 +3359,8 @@ func (b *LocalBackend) StartLoginInteractive(ctx context.Context) error {
 	if url != "" && timeSinceAuthURLCreated < ((7*24*time.Hour)-(1*time.Hour)) {
 		b.popBrowserAuthNow()
 	} else {
-		cc.Login(b.loginFlags | controlclient.LoginInteractive)
+		b.noteLoginStarted()
+		cc.Login(loginFlags | controlclient.LoginInteractive)
 	}
 	return nil
 }
@@ -3341,6 +3556,9 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkProfileNameLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if p.Ephemeral && b.pm.CurrentProfile().ID != "" {
+		errs = append(errs, errEphemeralSavedProfile)
+	}
 	if err := b.checkSSHPrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
@@ -3353,6 +3571,16 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkAutoUpdatePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	for _, peer := range p.BlockedPeers {
+		if err := ipn.CheckBlockedPeer(peer); err != nil {
+			errs = append(errs, fmt.Errorf("invalid blocked peer %q: %w", peer, err))
+		}
+	}
+	for _, alias := range p.AdvertiseDNSAliases {
+		if err := tailcfg.CheckDNSAlias(alias); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DNS alias %q: %w", alias, err))
+		}
+	}
 	return multierr.New(errs...)
 }
 
@@ -3501,6 +3729,65 @@ func (b *LocalBackend) SetUseExitNodeEnabled(v bool) (ipn.PrefsView, error) {
 	return b.editPrefsLockedOnEntry(mp, unlock)
 }
 
+// EditAdvertisedRoutes adds the routes in add to, and removes the routes in
+// remove from, the routes that b advertises, leaving its other prefs as they
+// are. Adding or removing both of tsaddr.ExitRoutes starts or stops
+// advertising b as an exit node.
+//
+// Unlike setting AdvertiseRoutes with EditPrefs, it doesn't clear the routes
+// of the app connector, which are advertised alongside.
+func (b *LocalBackend) EditAdvertisedRoutes(add, remove []netip.Prefix) (ipn.PrefsView, error) {
+	unlock := b.lockAndGetUnlock()
+	defer unlock()
+
+	p0 := b.pm.CurrentPrefs()
+	routes, changed, err := editRoutes(p0.AdvertiseRoutes(), add, remove)
+	if err != nil {
+		return ipn.PrefsView{}, err
+	}
+	if !changed {
+		return p0, nil
+	}
+	mp := &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: routes},
+		AdvertiseRoutesSet: true,
+	}
+	return b.editPrefsLockedOnEntry(mp, unlock)
+}
+
+// editRoutes returns cur with the routes in add appended, unless already
+// present, and the routes in remove removed, and whether that changed cur.
+// It returns an error if a route isn't a valid masked prefix, or if the
+// result would have only one of tsaddr.ExitRoutes.
+func editRoutes(cur views.Slice[netip.Prefix], add, remove []netip.Prefix) (routes []netip.Prefix, changed bool, err error) {
+	for _, r := range append(slices.Clip(add), remove...) {
+		if !r.IsValid() || r != r.Masked() {
+			return nil, false, fmt.Errorf("invalid route %v; must be a masked CIDR prefix", r)
+		}
+	}
+	routes = make([]netip.Prefix, 0, cur.Len()+len(add))
+	for i := range cur.Len() {
+		r := cur.At(i)
+		if slices.Contains(remove, r) {
+			changed = true
+			continue
+		}
+		routes = append(routes, r)
+	}
+	for _, r := range add {
+		if !slices.Contains(routes, r) && !slices.Contains(remove, r) {
+			routes = append(routes, r)
+			changed = true
+		}
+	}
+	has4 := slices.Contains(routes, tsaddr.AllIPv4())
+	has6 := slices.Contains(routes, tsaddr.AllIPv6())
+	if has4 != has6 {
+		return nil, false, fmt.Errorf("%v and %v must be advertised together, to advertise an exit node", tsaddr.AllIPv4(), tsaddr.AllIPv6())
+	}
+	return routes, changed, nil
+}
+
 // MaybeClearAppConnector clears the routes from any AppConnector if
 // AdvertiseRoutes has been set in the MaskedPrefs.
 func (b *LocalBackend) MaybeClearAppConnector(mp *ipn.MaskedPrefs) error {
@@ -3659,12 +3946,13 @@ func (b *LocalBackend) setPrefsLockedOnEntry(newp *ipn.Prefs, unlock unlockOnce)
 	}
 
 	if newp.AutoUpdate.Apply.EqualBool(true) {
-		if b.state != ipn.Running {
+		if b.state.State() != ipn.Running {
 			b.maybeStartOfflineAutoUpdate(newp.View())
 		}
 	} else {
 		b.stopOfflineAutoUpdate()
 	}
+	loginFlags := b.ephemeralLoginFlagLocked()
 
 	unlock.UnlockEarly()
 
@@ -3678,7 +3966,8 @@ func (b *LocalBackend) setPrefsLockedOnEntry(newp *ipn.Prefs, unlock unlockOnce)
 
 	if !oldp.WantRunning() && newp.WantRunning {
 		b.logf("transitioning to running; doing Login...")
-		cc.Login(controlclient.LoginDefault)
+		b.noteLoginStarted()
+		cc.Login(loginFlags)
 	}
 
 	if oldp.WantRunning() != newp.WantRunning {
@@ -3781,6 +4070,9 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 			return nil
 		}, opts
 	}
+	if omit.Serve {
+		return nil, nil
+	}
 	if handler := b.tcpHandlerForServe(dst.Port(), src); handler != nil {
 		return handler, opts
 	}
@@ -3841,6 +4133,10 @@ func (b *LocalBackend) doSetHostinfoFilterServices() {
 	if b.egg {
 		peerAPIServices = append(peerAPIServices, tailcfg.Service{Proto: "egg", Port: 1})
 	}
+	declared, err := b.declaredServicesWithHealthLocked()
+	if err != nil {
+		b.logf("reading declared services: %v", err)
+	}
 
 	// TODO(maisem,bradfitz): store hostinfo as a view, not as a mutable struct.
 	hi := *b.hostinfo // shallow copy
@@ -3855,6 +4151,7 @@ func (b *LocalBackend) doSetHostinfoFilterServices() {
 	// the slice with no free capacity.
 	c := len(hi.Services)
 	hi.Services = append(hi.Services[:c:c], peerAPIServices...)
+	hi.DeclaredServices = declared
 	hi.PushDeviceToken = b.pushDeviceToken.Load()
 	cc.SetHostinfo(&hi)
 }
@@ -3962,6 +4259,7 @@ func (b *LocalBackend) authReconfig() {
 	blocked := b.blocked
 	prefs := b.pm.CurrentPrefs()
 	nm := b.netMap
+	netmapGen := b.netmapGen
 	hasPAC := b.prevIfState.HasPAC()
 	disableSubnetsIfPAC := nm.HasCap(tailcfg.NodeAttrDisableSubnetsIfPAC)
 	userDialUseRoutes := nm.HasCap(tailcfg.NodeAttrUserDialUseRoutes)
@@ -4009,11 +4307,28 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("wgcfg: %v", err)
 		return
 	}
+	b.applyPeerKeepalive(cfg)
+	b.applySubnetFailover(cfg, nm)
+	cfg.ListenPort = prefs.ListenPort()
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, b.sys.ControlKnobs(), version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
 
 	err = b.e.Reconfig(cfg, rcfg, dcfg)
+	if err == nil || err == wgengine.ErrNoChanges {
+		b.noteNetmapApplied(netmapGen)
+		b.sys.Readiness().SetReady(tsd.SubsystemDNS)
+		b.sys.Readiness().SetReady(tsd.SubsystemRouter)
+	} else {
+		reason := "applying config: " + err.Error()
+		b.sys.Readiness().SetNotReady(tsd.SubsystemDNS, reason)
+		b.sys.Readiness().SetNotReady(tsd.SubsystemRouter, reason)
+	}
+	if err == nil {
+		b.mu.Lock()
+		b.lastRouterConfig = rcfg
+		b.mu.Unlock()
+	}
 	if err == wgengine.ErrNoChanges {
 		return
 	}
@@ -4147,10 +4462,12 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		}
 		dcfg.Hosts[fqdn] = append(dcfg.Hosts[fqdn], ip)
 	}
+	addDNSAliases(dcfg, nm, peers, logf)
 
 	if !prefs.CorpDNS() {
 		return dcfg
 	}
+	dcfg.ValidateDNSSEC = prefs.ValidateDNSSEC()
 
 	for _, dom := range nm.DNS.Domains {
 		fqdn, err := dnsname.ToFQDN(dom)
@@ -4373,16 +4690,16 @@ func (b *LocalBackend) initPeerAPIListener() {
 		b.logf("peerapi starting without Taildrop directory configured")
 	}
 
-	ps := &peerAPIServer{
-		b: b,
-		taildrop: taildrop.ManagerOptions{
+	ps := &peerAPIServer{b: b}
+	if !omit.Taildrop {
+		ps.taildrop = taildrop.ManagerOptions{
 			Logf:           b.logf,
 			Clock:          tstime.DefaultClock{Clock: b.clock},
 			State:          b.store,
 			Dir:            fileRoot,
 			DirectFileMode: b.directFileRoot != "",
 			SendFileNotify: b.sendFileNotify,
-		}.New(),
+		}.New()
 	}
 	if dm, ok := b.sys.DNSManager.GetOK(); ok {
 		ps.resolver = dm.Resolver()
@@ -4420,7 +4737,7 @@ func (b *LocalBackend) initPeerAPIListener() {
 		}
 		pln.urlStr = "http://" + net.JoinHostPort(a.Addr().String(), strconv.Itoa(pln.port))
 		b.logf("peerapi: serving on %s", pln.urlStr)
-		go pln.serve()
+		b.supervisor.Go(b.ctx, supervisor.PeerAPI, pln.serve)
 		b.peerAPIListeners = append(b.peerAPIListeners, pln)
 	}
 
@@ -4515,6 +4832,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 
 	b.mu.Lock()
 	netfilterKind := b.capForcedNetfilter // protected by b.mu
+	routes := b.dampRoutesLocked(peerRoutes(b.logf, cfg.Peers, singleRouteThreshold), prefs)
 	b.mu.Unlock()
 
 	if prefs.NetfilterKind() != "" {
@@ -4538,7 +4856,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 		SNATSubnetRoutes:  !prefs.NoSNAT(),
 		StatefulFiltering: doStatefulFiltering,
 		NetfilterMode:     prefs.NetfilterMode(),
-		Routes:            peerRoutes(b.logf, cfg.Peers, singleRouteThreshold),
+		Routes:            routes,
 		NetfilterKind:     netfilterKind,
 	}
 
@@ -4621,6 +4939,7 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 	}
 	hi.RoutableIPs = prefs.AdvertiseRoutes().AsSlice()
 	hi.RequestTags = prefs.AdvertiseTags().AsSlice()
+	hi.DNSAliases = prefs.AdvertiseDNSAliases().AsSlice()
 	hi.ShieldsUp = prefs.ShieldsUp()
 	hi.AllowsUpdate = envknob.AllowsRemoteUpdate() || prefs.AutoUpdate().Apply.EqualBool(true)
 
@@ -4654,16 +4973,23 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 // places twiddle IPN internal state without going through here, so
 // really this is more "one of several places in which random things
 // happen".
-func (b *LocalBackend) enterState(newState ipn.State) {
+//
+// The reason describes what caused the transition; it's recorded in the
+// state history.
+func (b *LocalBackend) enterState(newState ipn.State, reason string) {
 	unlock := b.lockAndGetUnlock()
-	b.enterStateLockedOnEntry(newState, unlock)
+	b.enterStateLockedOnEntry(newState, reason, unlock)
 }
 
 // enterStateLockedOnEntry is like enterState but requires b.mu be held to call
 // it, but it unlocks b.mu when done (via unlock, a once func).
-func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, unlock unlockOnce) {
-	oldState := b.state
-	b.state = newState
+func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, reason string, unlock unlockOnce) {
+	oldState := b.state.State()
+	if newState == ipn.NoState {
+		b.state.Reset(reason)
+	} else {
+		b.state.Transition(newState, reason)
+	}
 	prefs := b.pm.CurrentPrefs()
 
 	// Some temporary (2024-05-05) debugging code to help us catch
@@ -4715,6 +5041,11 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State, unlock unlock
 	// prefs may change irrespective of state; WantRunning should be explicitly
 	// set before potential early return even if the state is unchanged.
 	b.health.SetIPNState(newState.String(), prefs.Valid() && prefs.WantRunning())
+	if newState == ipn.Running {
+		b.sys.Readiness().SetReady(tsd.SubsystemControl)
+	} else {
+		b.sys.Readiness().SetNotReady(tsd.SubsystemControl, "backend state is "+newState.String())
+	}
 	if oldState == newState {
 		return
 	}
@@ -4774,14 +5105,14 @@ func (b *LocalBackend) NodeKey() key.NodePublic {
 }
 
 // nextStateLocked returns the state the backend seems to be in, based on
-// its internal state.
+// its internal state, along with the reason for it.
 //
 // b.mu must be held
-func (b *LocalBackend) nextStateLocked() ipn.State {
+func (b *LocalBackend) nextStateLocked() (_ ipn.State, reason string) {
 	var (
 		cc         = b.cc
 		netMap     = b.netMap
-		state      = b.state
+		state      = b.state.State()
 		blocked    = b.blocked
 		st         = b.engineStatus
 		keyExpired = b.keyExpired
@@ -4796,12 +5127,15 @@ func (b *LocalBackend) nextStateLocked() ipn.State {
 
 	switch {
 	case !wantRunning && !loggedOut && !blocked && b.hasNodeKeyLocked():
-		return ipn.Stopped
+		return ipn.Stopped, "WantRunning is false"
 	case netMap == nil:
-		if (cc != nil && cc.AuthCantContinue()) || loggedOut {
+		if loggedOut {
+			return ipn.NeedsLogin, "logged out"
+		}
+		if cc != nil && cc.AuthCantContinue() {
 			// Auth was interrupted or waiting for URL visit,
 			// so it won't proceed without human help.
-			return ipn.NeedsLogin
+			return ipn.NeedsLogin, "auth can't continue"
 		}
 		switch state {
 		case ipn.Stopped:
@@ -4809,40 +5143,40 @@ func (b *LocalBackend) nextStateLocked() ipn.State {
 			// we can assume auth is in good shape (or we would
 			// have been in NeedsLogin), so transition to Starting
 			// right away.
-			return ipn.Starting
+			return ipn.Starting, "starting without netmap"
 		case ipn.NoState:
 			// Our first time connecting to control, and we
 			// don't know if we'll NeedsLogin or not yet.
 			// UIs should print "Loading..." in this state.
-			return ipn.NoState
+			return ipn.NoState, "waiting for control"
 		case ipn.Starting, ipn.Running, ipn.NeedsLogin:
-			return state
+			return state, "waiting for netmap"
 		default:
 			b.logf("unexpected no-netmap state transition for %v", state)
-			return state
+			return state, "waiting for netmap"
 		}
 	case !wantRunning:
-		return ipn.Stopped
+		return ipn.Stopped, "WantRunning is false"
 	case keyExpired:
 		// NetMap must be non-nil for us to get here.
 		// The node key expired, need to relogin.
-		return ipn.NeedsLogin
+		return ipn.NeedsLogin, "node key expired"
 	case netMap.GetMachineStatus() != tailcfg.MachineAuthorized:
 		// TODO(crawshaw): handle tailcfg.MachineInvalid
-		return ipn.NeedsMachineAuth
+		return ipn.NeedsMachineAuth, "machine not authorized"
 	case state == ipn.NeedsMachineAuth:
 		// (if we get here, we know MachineAuthorized == true)
-		return ipn.Starting
+		return ipn.Starting, "machine authorized"
 	case state == ipn.Starting:
 		if st.NumLive > 0 || st.LiveDERPs > 0 {
-			return ipn.Running
+			return ipn.Running, "peers or DERP connected"
 		} else {
-			return state
+			return state, "waiting for peers or DERP"
 		}
 	case state == ipn.Running:
-		return ipn.Running
+		return ipn.Running, "running"
 	default:
-		return ipn.Starting
+		return ipn.Starting, "got netmap"
 	}
 }
 
@@ -4860,7 +5194,8 @@ func (b *LocalBackend) stateMachine() {
 // stateMachineLockedOnEntry is like stateMachine but requires b.mu be held to
 // call it, but it unlocks b.mu when done (via unlock, a once func).
 func (b *LocalBackend) stateMachineLockedOnEntry(unlock unlockOnce) {
-	b.enterStateLockedOnEntry(b.nextStateLocked(), unlock)
+	newState, reason := b.nextStateLocked()
+	b.enterStateLockedOnEntry(newState, reason, unlock)
 }
 
 // lockAndGetUnlock locks b.mu and returns a sync.OnceFunc function that will
@@ -5008,10 +5343,12 @@ func (b *LocalBackend) ResetForClientDisconnect() {
 	b.keyExpired = false
 	b.authURL = ""
 	b.authURLTime = time.Time{}
+	b.loginStartedAt = time.Time{}
+	b.lastRouterConfig = nil
 	b.activeLogin = ""
 	b.resetDialPlan()
 	b.setAtomicValuesFromPrefsLocked(ipn.PrefsView{})
-	b.enterStateLockedOnEntry(ipn.Stopped, unlock)
+	b.enterStateLockedOnEntry(ipn.Stopped, "logged out", unlock)
 }
 
 func (b *LocalBackend) ShouldRunSSH() bool { return b.sshAtomicBool.Load() && envknob.CanSSHD() }
@@ -5525,23 +5862,26 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 		}
 	}
 
-	b.reloadServeConfigLocked(prefs)
-	if b.serveConfig.Valid() {
-		servePorts := make([]uint16, 0, 3)
-		b.serveConfig.RangeOverTCPs(func(port uint16, _ ipn.TCPPortHandlerView) bool {
-			if port > 0 {
-				servePorts = append(servePorts, uint16(port))
-			}
-			return true
-		})
-		handlePorts = append(handlePorts, servePorts...)
+	if !omit.Serve {
+		b.reloadServeConfigLocked(prefs)
+		if b.serveConfig.Valid() {
+			servePorts := make([]uint16, 0, 3)
+			b.serveConfig.RangeOverTCPs(func(port uint16, _ ipn.TCPPortHandlerView) bool {
+				if port > 0 {
+					servePorts = append(servePorts, uint16(port))
+				}
+				return true
+			})
+			handlePorts = append(handlePorts, servePorts...)
 
-		b.setServeProxyHandlersLocked()
+			b.setServeProxyHandlersLocked()
 
-		// don't listen on netmap addresses if we're in userspace mode
-		if !b.sys.IsNetstack() {
-			b.updateServeTCPPortNetMapAddrListenersLocked(servePorts)
+			// don't listen on netmap addresses if we're in userspace mode
+			if !b.sys.IsNetstack() {
+				b.updateServeTCPPortNetMapAddrListenersLocked(servePorts)
+			}
 		}
+		b.setUDPPortsIntercepted(b.updateServeUDPForwardersLocked())
 	}
 	// Kick off a Hostinfo update to control if WireIngress changed.
 	if wire := b.wantIngressLocked(); b.hostinfo != nil && b.hostinfo.WireIngress != wire {
@@ -5658,6 +5998,9 @@ func (b *LocalBackend) addFileWaiter(wakeWaiter context.CancelFunc) set.Handle {
 }
 
 func (b *LocalBackend) WaitingFiles() ([]apitype.WaitingFile, error) {
+	if omit.Taildrop {
+		return nil, omit.Err
+	}
 	b.mu.Lock()
 	apiSrv := b.peerAPIServer
 	b.mu.Unlock()
@@ -5700,6 +6043,9 @@ func (b *LocalBackend) AwaitWaitingFiles(ctx context.Context) ([]apitype.Waiting
 }
 
 func (b *LocalBackend) DeleteFile(name string) error {
+	if omit.Taildrop {
+		return omit.Err
+	}
 	b.mu.Lock()
 	apiSrv := b.peerAPIServer
 	b.mu.Unlock()
@@ -5707,6 +6053,9 @@ func (b *LocalBackend) DeleteFile(name string) error {
 }
 
 func (b *LocalBackend) OpenFile(name string) (rc io.ReadCloser, size int64, err error) {
+	if omit.Taildrop {
+		return nil, 0, omit.Err
+	}
 	b.mu.Lock()
 	apiSrv := b.peerAPIServer
 	b.mu.Unlock()
@@ -5728,7 +6077,7 @@ func (b *LocalBackend) FileTargets() ([]*apitype.FileTarget, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	nm := b.netMap
-	if b.state != ipn.Running || nm == nil {
+	if b.state.State() != ipn.Running || nm == nil {
 		return nil, errors.New("not connected to the tailnet")
 	}
 	if !b.capFileSharing {
@@ -6373,6 +6722,13 @@ func (b *LocalBackend) ShouldInterceptTCPPort(port uint16) bool {
 	return b.shouldInterceptTCPPortAtomic.Load()(port)
 }
 
+// ShouldInterceptUDPPort reports whether the given UDP port number to a
+// Tailscale IP (not a subnet router, service IP, etc) should be intercepted by
+// Tailscaled and handled in-process.
+func (b *LocalBackend) ShouldInterceptUDPPort(port uint16) bool {
+	return b.shouldInterceptUDPPortAtomic.Load()(port)
+}
+
 // SwitchProfile switches to the profile with the given id.
 // It will restart the backend on success.
 // If the profile is not known, it returns an errProfileNotFound.
@@ -6468,6 +6824,7 @@ func (b *LocalBackend) resetForProfileChangeLockedOnEntry(unlock unlockOnce) err
 		// down, so no need to do any work.
 		return nil
 	}
+	b.stopKeyRenewalLocked()
 	b.setNetMapLocked(nil) // Reset netmap.
 	// Reset the NetworkMap in the engine
 	b.e.SetNetworkMap(new(netmap.NetworkMap))
@@ -6477,7 +6834,7 @@ func (b *LocalBackend) resetForProfileChangeLockedOnEntry(unlock unlockOnce) err
 	b.lastServeConfJSON = mem.B(nil)
 	b.serveConfig = ipn.ServeConfigView{}
 	b.lastSuggestedExitNode = ""
-	b.enterStateLockedOnEntry(ipn.NoState, unlock) // Reset state; releases b.mu
+	b.enterStateLockedOnEntry(ipn.NoState, "profile changed", unlock) // Reset state; releases b.mu
 	b.health.SetLocalLogConfigHealth(nil)
 	return b.Start(ipn.Options{})
 }
@@ -6611,6 +6968,38 @@ func (b *LocalBackend) GetPeerEndpointChanges(ctx context.Context, ip netip.Addr
 	return chs, nil
 }
 
+// DebugPeerChaos returns the artificial degradation applied to packets
+// sent to the peer with Tailscale IP ip.
+func (b *LocalBackend) DebugPeerChaos(ip netip.Addr) (magicsock.PeerChaos, error) {
+	peer, err := b.peerKeyForIP(ip)
+	if err != nil {
+		return magicsock.PeerChaos{}, err
+	}
+	return b.MagicConn().PeerChaos(peer), nil
+}
+
+// DebugSetPeerChaos sets the artificial degradation applied to packets
+// sent to the peer with Tailscale IP ip. The zero PeerChaos removes it.
+func (b *LocalBackend) DebugSetPeerChaos(ip netip.Addr, pc magicsock.PeerChaos) error {
+	peer, err := b.peerKeyForIP(ip)
+	if err != nil {
+		return err
+	}
+	return b.MagicConn().SetPeerChaos(peer, pc)
+}
+
+// peerKeyForIP returns the node key of the peer with Tailscale IP ip.
+func (b *LocalBackend) peerKeyForIP(ip netip.Addr) (key.NodePublic, error) {
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return key.NodePublic{}, fmt.Errorf("no matching peer")
+	}
+	if pip.IsSelf {
+		return key.NodePublic{}, fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+	return pip.Node.Key(), nil
+}
+
 var breakTCPConns func() error
 
 func (b *LocalBackend) DebugBreakTCPConns() error {
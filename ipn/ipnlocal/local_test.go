@@ -557,6 +557,87 @@ func TestSetUseExitNodeEnabled(t *testing.T) {
 	}
 }
 
+func TestEditRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		name        string
+		cur         []netip.Prefix
+		add, remove []netip.Prefix
+		want        []netip.Prefix
+		wantChanged bool
+		wantErr     bool
+	}{
+		{
+			name:        "add",
+			cur:         []netip.Prefix{pfx("10.0.0.0/24")},
+			add:         []netip.Prefix{pfx("192.168.1.0/24")},
+			want:        []netip.Prefix{pfx("10.0.0.0/24"), pfx("192.168.1.0/24")},
+			wantChanged: true,
+		},
+		{
+			name: "add_existing",
+			cur:  []netip.Prefix{pfx("10.0.0.0/24")},
+			add:  []netip.Prefix{pfx("10.0.0.0/24")},
+			want: []netip.Prefix{pfx("10.0.0.0/24")},
+		},
+		{
+			name:        "remove",
+			cur:         []netip.Prefix{pfx("10.0.0.0/24"), pfx("192.168.1.0/24")},
+			remove:      []netip.Prefix{pfx("10.0.0.0/24")},
+			want:        []netip.Prefix{pfx("192.168.1.0/24")},
+			wantChanged: true,
+		},
+		{
+			name:   "remove_missing",
+			cur:    []netip.Prefix{pfx("10.0.0.0/24")},
+			remove: []netip.Prefix{pfx("192.168.1.0/24")},
+			want:   []netip.Prefix{pfx("10.0.0.0/24")},
+		},
+		{
+			name:        "add_exit_node",
+			cur:         []netip.Prefix{pfx("10.0.0.0/24")},
+			add:         tsaddr.ExitRoutes(),
+			want:        append([]netip.Prefix{pfx("10.0.0.0/24")}, tsaddr.ExitRoutes()...),
+			wantChanged: true,
+		},
+		{
+			name:        "remove_exit_node",
+			cur:         append([]netip.Prefix{pfx("10.0.0.0/24")}, tsaddr.ExitRoutes()...),
+			remove:      tsaddr.ExitRoutes(),
+			want:        []netip.Prefix{pfx("10.0.0.0/24")},
+			wantChanged: true,
+		},
+		{
+			name:    "half_exit_node",
+			cur:     tsaddr.ExitRoutes(),
+			remove:  []netip.Prefix{tsaddr.AllIPv6()},
+			wantErr: true,
+		},
+		{
+			name:    "unmasked",
+			add:     []netip.Prefix{pfx("10.0.0.1/24")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := editRoutes(views.SliceOf(tt.cur), tt.add, tt.remove)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v; want %v", changed, tt.wantChanged)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("routes = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestFileTargets(t *testing.T) {
 	b := new(LocalBackend)
 	_, err := b.FileTargets()
@@ -570,7 +651,7 @@ func TestFileTargets(t *testing.T) {
 		t.Errorf("non-running netmap: got %q; want %q", got, want)
 	}
 
-	b.state = ipn.Running
+	b.state.Transition(ipn.Running, "test")
 	_, err = b.FileTargets()
 	if got, want := fmt.Sprint(err), "file sharing not enabled by Tailscale admin"; got != want {
 		t.Errorf("without cap: got %q; want %q", got, want)
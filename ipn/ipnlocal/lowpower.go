@@ -0,0 +1,19 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import "tailscale.com/ipn/ipnstate"
+
+// LowPower returns the configuration and state of the engine's low power
+// mode.
+func (b *LocalBackend) LowPower() ipnstate.LowPower {
+	return b.MagicConn().LowPower()
+}
+
+// SetLowPower configures the engine's low power mode; see
+// magicsock.Conn.SetLowPower. Platform clients call it again whenever
+// the device switches between battery and external power.
+func (b *LocalBackend) SetLowPower(lp ipnstate.LowPower) error {
+	return b.MagicConn().SetLowPower(lp)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+
+	"tailscale.com/control/controlclient"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+)
+
+// disableNetmapCache turns off the netmap cache, so that a node has no
+// network map at startup until its first map poll completes.
+var disableNetmapCache = envknob.RegisterBool("TS_DISABLE_NETMAP_CACHE")
+
+// netmapCacheKey returns the StateKey of the netmap cache of the profile
+// stored under profileKey.
+func netmapCacheKey(profileKey ipn.StateKey) ipn.StateKey {
+	return profileKey + "-netmap"
+}
+
+// stateNetmapCache is a controlclient.NetmapCache kept in a StateStore,
+// which may encrypt it.
+type stateNetmapCache struct {
+	store ipn.StateStore
+	key   ipn.StateKey
+}
+
+func (c stateNetmapCache) Load() ([]byte, error) {
+	bs, err := c.store.ReadState(c.key)
+	if errors.Is(err, ipn.ErrStateNotExist) || len(bs) == 0 {
+		return nil, nil
+	}
+	return bs, err
+}
+
+func (c stateNetmapCache) Store(bs []byte) error {
+	return c.store.WriteState(c.key, bs)
+}
+
+// netmapCacheLocked returns the netmap cache of the current profile, or
+// nil if the profile isn't persisted (as for ephemeral nodes) or the cache
+// is disabled.
+//
+// b.mu must be held.
+func (b *LocalBackend) netmapCacheLocked() controlclient.NetmapCache {
+	key := b.pm.CurrentProfile().Key
+	if key == "" || b.pm.CurrentPrefs().Ephemeral() || disableNetmapCache() {
+		return nil
+	}
+	return stateNetmapCache{b.store, netmapCacheKey(key)}
+}
@@ -38,6 +38,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/sockstats"
+	"tailscale.com/omit"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/types/views"
@@ -62,6 +63,48 @@ type peerDNSQueryHandler interface {
 	HandlePeerDNSQuery(context.Context, []byte, netip.AddrPort, func(name string) bool) (res []byte, err error)
 }
 
+// PeerAPIHandler is the interface that handlers registered with
+// RegisterPeerAPIHandler use to learn about the request and the peer that
+// made it.
+type PeerAPIHandler interface {
+	Peer() tailcfg.NodeView       // the peer making the request
+	PeerCaps() tailcfg.PeerCapMap // the capabilities granted to the peer
+	Self() tailcfg.NodeView       // this node
+	IsSelf() bool                 // whether the peer is owned by the same user as this node
+	RemoteAddr() netip.AddrPort
+	LocalBackend() *LocalBackend
+	Logf(format string, a ...any)
+}
+
+// peerAPIHandlerFunc is a handler registered with RegisterPeerAPIHandler.
+type peerAPIHandlerFunc struct {
+	cap tailcfg.PeerCapability
+	f   func(PeerAPIHandler, http.ResponseWriter, *http.Request)
+}
+
+// peerAPIHandlers are the handlers registered with RegisterPeerAPIHandler,
+// keyed by path.
+var peerAPIHandlers = map[string]peerAPIHandlerFunc{}
+
+// RegisterPeerAPIHandler registers f to serve PeerAPI requests for path,
+// which must start with "/v0/" and not be served by a built-in handler.
+//
+// If cap is non-empty, f is only called for requests from peers owned by
+// the same user as this node or granted cap, and never from peers that
+// may only use the unsigned PeerAPI; others get a 403 response. If cap is
+// empty, f is called for any peer and must do its own access checks.
+//
+// It must be called during init, before any PeerAPI server is started.
+func RegisterPeerAPIHandler(path string, cap tailcfg.PeerCapability, f func(PeerAPIHandler, http.ResponseWriter, *http.Request)) {
+	if !strings.HasPrefix(path, "/v0/") {
+		panic(fmt.Sprintf("invalid PeerAPI handler path %q", path))
+	}
+	if _, ok := peerAPIHandlers[path]; ok {
+		panic(fmt.Sprintf("duplicate PeerAPI handler for %q", path))
+	}
+	peerAPIHandlers[path] = peerAPIHandlerFunc{cap: cap, f: f}
+}
+
 type peerAPIServer struct {
 	b        *LocalBackend
 	resolver peerDNSQueryHandler
@@ -154,11 +197,14 @@ func (pln *peerAPIListener) Close() error {
 	return nil
 }
 
+// serve accepts and serves connections on pln.ln until it's closed.
+//
+// It's run by the LocalBackend's supervisor, which restarts it if it panics,
+// so pln.ln is only closed when serve returns normally.
 func (pln *peerAPIListener) serve() {
 	if pln.ln == nil {
 		return
 	}
-	defer pln.ln.Close()
 	logf := pln.lb.logf
 	for {
 		c, err := pln.ln.Accept()
@@ -167,6 +213,7 @@ func (pln *peerAPIListener) serve() {
 		}
 		if err != nil {
 			logf("peerapi.Accept: %v", err)
+			pln.ln.Close()
 			return
 		}
 		ta, ok := c.RemoteAddr().(*net.TCPAddr)
@@ -230,6 +277,14 @@ func (h *peerAPIHandler) logf(format string, a ...any) {
 	h.ps.b.logf("peerapi: "+format, a...)
 }
 
+func (h *peerAPIHandler) Peer() tailcfg.NodeView       { return h.peerNode }
+func (h *peerAPIHandler) PeerCaps() tailcfg.PeerCapMap { return h.peerCaps() }
+func (h *peerAPIHandler) Self() tailcfg.NodeView       { return h.selfNode }
+func (h *peerAPIHandler) IsSelf() bool                 { return h.isSelf }
+func (h *peerAPIHandler) RemoteAddr() netip.AddrPort   { return h.remoteAddr }
+func (h *peerAPIHandler) LocalBackend() *LocalBackend  { return h.ps.b }
+func (h *peerAPIHandler) Logf(format string, a ...any) { h.logf(format, a...) }
+
 // isAddressValid reports whether addr is a valid destination address for this
 // node originating from the peer.
 func (h *peerAPIHandler) isAddressValid(addr netip.Addr) bool {
@@ -361,6 +416,21 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metricIngressCalls.Add(1)
 		h.handleServeIngress(w, r)
 		return
+	case "/v0/services":
+		h.handleServeServices(w, r)
+		return
+	case "/v0/speedtest":
+		metricSpeedtestCalls.Add(1)
+		h.handleServeSpeedtest(w, r)
+		return
+	}
+	if ph, ok := peerAPIHandlers[r.URL.Path]; ok {
+		if !h.canUseRegisteredHandler(ph.cap) {
+			http.Error(w, "denied; no "+string(ph.cap)+" capability", http.StatusForbidden)
+			return
+		}
+		ph.f(h, w, r)
+		return
 	}
 	who := h.peerUser.DisplayName
 	fmt.Fprintf(w, `<html>
@@ -379,6 +449,10 @@ func (h *peerAPIHandler) handleServeIngress(w http.ResponseWriter, r *http.Reque
 	// http.Errors only useful if hitting endpoint manually
 	// otherwise rely on log lines when debugging ingress connections
 	// as connection is hijacked for bidi and is encrypted tls
+	if omit.Serve {
+		http.Error(w, "denied; "+omit.Err.Error(), http.StatusForbidden)
+		return
+	}
 	if !h.canIngress() {
 		h.logf("ingress: denied; no ingress cap from %v", h.remoteAddr)
 		http.Error(w, "denied; no ingress cap", http.StatusForbidden)
@@ -433,9 +507,34 @@ func (h *peerAPIHandler) handleServeIngress(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "denied", http.StatusForbidden)
 	}
 
+	if strings.EqualFold(r.Header.Get("Tailscale-Ingress-Proto"), "udp") {
+		h.ps.b.HandleIngressUDPConn(h.peerNode, target, srcAddr, getConnOrReset, sendRST)
+		return
+	}
 	h.ps.b.HandleIngressTCPConn(h.peerNode, target, srcAddr, getConnOrReset, sendRST)
 }
 
+// handleServeServices serves the services declared on this node, so peers
+// can get the current list without waiting for it to reach them through
+// control.
+func (h *peerAPIHandler) handleServeServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	svcs, err := h.ps.b.DeclaredServices()
+	if err != nil {
+		h.logf("services: %v", err)
+		http.Error(w, "error reading services", http.StatusInternalServerError)
+		return
+	}
+	if svcs == nil {
+		svcs = []tailcfg.DeclaredService{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(svcs)
+}
+
 func (h *peerAPIHandler) handleServeInterfaces(w http.ResponseWriter, r *http.Request) {
 	if !h.canDebug() {
 		http.Error(w, "denied; no debug access", http.StatusForbidden)
@@ -632,6 +731,18 @@ func (h *peerAPIHandler) canWakeOnLAN() bool {
 
 var allowSelfIngress = envknob.RegisterBool("TS_ALLOW_SELF_INGRESS")
 
+// canUseRegisteredHandler reports whether h can use a handler registered
+// with RegisterPeerAPIHandler that requires cap.
+func (h *peerAPIHandler) canUseRegisteredHandler(cap tailcfg.PeerCapability) bool {
+	if cap == "" {
+		return true
+	}
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		return false
+	}
+	return h.isSelf || h.peerHasCap(cap)
+}
+
 // canIngress reports whether h can send ingress requests to this node.
 func (h *peerAPIHandler) canIngress() bool {
 	return h.peerHasCap(tailcfg.PeerCapabilityIngress) || (allowSelfIngress() && h.isSelf)
@@ -646,6 +757,10 @@ func (h *peerAPIHandler) peerCaps() tailcfg.PeerCapMap {
 }
 
 func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
+	if omit.Taildrop {
+		http.Error(w, omit.Err.Error(), http.StatusForbidden)
+		return
+	}
 	if !h.canPutFile() {
 		http.Error(w, taildrop.ErrNoTaildrop.Error(), http.StatusForbidden)
 		return
@@ -1276,4 +1391,5 @@ func (fl *fakePeerAPIListener) Addr() net.Addr { return fl.addr }
 	metricDNSCalls       = clientmetric.NewCounter("peerapi_dns")
 	metricWakeOnLANCalls = clientmetric.NewCounter("peerapi_wol")
 	metricIngressCalls   = clientmetric.NewCounter("peerapi_ingress")
+	metricSpeedtestCalls = clientmetric.NewCounter("peerapi_speedtest")
 )
@@ -116,6 +116,12 @@ func hexAll(v string) string {
 	return sb.String()
 }
 
+func init() {
+	RegisterPeerAPIHandler("/v0/test-registered", "example.com/cap/test", func(h PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "registered handler for %s", h.Peer().ComputedName())
+	})
+}
+
 func TestHandlePeerAPI(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -172,6 +178,45 @@ func TestHandlePeerAPI(t *testing.T) {
 				bodyContains("ServeHTTP"),
 			),
 		},
+		{
+			name:   "registered/deny-nonself-no-cap",
+			isSelf: false,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/test-registered", nil)},
+			checks: checks(
+				httpStatus(http.StatusForbidden),
+				bodyContains("no example.com/cap/test capability"),
+			),
+		},
+		{
+			name:   "registered/accept-self",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/test-registered", nil)},
+			checks: checks(
+				httpStatus(200),
+				bodyContains("registered handler for some-peer-name"),
+			),
+		},
+		{
+			name:   "speedtest/deny-nonself-no-cap",
+			isSelf: false,
+			reqs:   []*http.Request{httptest.NewRequest("POST", "/v0/speedtest", strings.NewReader("hello"))},
+			checks: checks(httpStatus(http.StatusForbidden)),
+		},
+		{
+			name:   "speedtest/bad-duration",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/speedtest?duration=1h", nil)},
+			checks: checks(httpStatus(http.StatusBadRequest)),
+		},
+		{
+			name:   "speedtest/accept-self-upload",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("POST", "/v0/speedtest", strings.NewReader("hello"))},
+			checks: checks(
+				httpStatus(200),
+				bodyContains(`"Bytes":5`),
+			),
+		},
 		{
 			name:       "reject_non_owner_put",
 			isSelf:     false,
@@ -23,6 +23,11 @@
 
 var errAlreadyMigrated = errors.New("profile migration already completed")
 
+// errEphemeralSavedProfile is returned when asked to make the node
+// ephemeral while it's logged in to a profile saved in the state store,
+// which control already knows as a non-ephemeral node.
+var errEphemeralSavedProfile = errors.New("can't make a logged in node ephemeral; log out first")
+
 var debug = envknob.RegisterBool("TS_DEBUG_PROFILES")
 
 // profileManager is a wrapper around a StateStore that manages
@@ -208,12 +213,20 @@ func (pm *profileManager) Reset() {
 // is logged into so that we can keep track of things like their domain name
 // across user switches to disambiguate the same account but a different tailnet.
 func (pm *profileManager) SetPrefs(prefsIn ipn.PrefsView, np ipn.NetworkProfile) error {
+	if prefsIn.Ephemeral() && pm.currentProfile.ID != "" {
+		return errEphemeralSavedProfile
+	}
 	prefs := prefsIn.AsStruct()
 	newPersist := prefs.Persist
 	if newPersist == nil || newPersist.NodeID == "" || newPersist.UserProfile.LoginName == "" {
 		// We don't know anything about this profile, so ignore it for now.
 		return pm.setPrefsLocked(prefs.View())
 	}
+	if prefs.Ephemeral {
+		// Ephemeral nodes only live in memory; don't create a profile
+		// that would persist them.
+		return pm.setPrefsLocked(prefs.View())
+	}
 	up := newPersist.UserProfile
 	if up.DisplayName == "" {
 		up.DisplayName = up.LoginName
@@ -280,7 +293,7 @@ func newUnusedID(knownProfiles map[ipn.ProfileID]*ipn.LoginProfile) (ipn.Profile
 
 // setPrefsLocked sets the current profile's prefs to the provided value.
 // It also saves the prefs to the StateStore, if the current profile
-// is not new.
+// is not new. Ephemeral prefs never get a saved profile.
 func (pm *profileManager) setPrefsLocked(clonedPrefs ipn.PrefsView) error {
 	pm.prefs = clonedPrefs
 	pm.updateHealth()
@@ -358,15 +371,15 @@ func (pm *profileManager) loadSavedPrefs(key ipn.StateKey) (ipn.PrefsView, error
 		return ipn.PrefsView{}, fmt.Errorf("parsing saved prefs: %v", err)
 	}
 	pm.logf("using backend prefs for %q: %v", key, savedPrefs.Pretty())
-
-	// Ignore any old stored preferences for https://login.tailscale.com
-	// as the control server that would override the new default of
-	// controlplane.tailscale.com.
-	if savedPrefs.ControlURL != "" &&
-		savedPrefs.ControlURL != ipn.DefaultControlURL &&
-		ipn.IsLoginServerSynonym(savedPrefs.ControlURL) {
-		savedPrefs.ControlURL = ""
+	if v, unknown, err := ipn.InspectSavedPrefs(bs); err == nil {
+		if v > ipn.CurrentPrefsVersion {
+			pm.logf("warning: prefs for %q were saved by a newer version (prefs version %d > %d)", key, v, ipn.CurrentPrefsVersion)
+		}
+		if len(unknown) > 0 {
+			pm.logf("warning: ignoring unknown prefs for %q: %v", key, unknown)
+		}
 	}
+
 	// Before
 	// https://github.com/tailscale/tailscale/pull/11814/commits/1613b18f8280c2bce786980532d012c9f0454fa2#diff-314ba0d799f70c8998940903efb541e511f352b39a9eeeae8d475c921d66c2ac
 	// prefs could set AutoUpdate.Apply=true via EditPrefs or tailnet
@@ -414,6 +427,7 @@ func (pm *profileManager) DeleteProfile(id ipn.ProfileID) error {
 	if err := pm.WriteState(kp.Key, nil); err != nil {
 		return err
 	}
+	pm.deleteNetmapCache(kp.Key)
 	delete(pm.knownProfiles, id)
 	return pm.writeKnownProfiles()
 }
@@ -430,12 +444,21 @@ func (pm *profileManager) DeleteAllProfiles() error {
 			pm.writeKnownProfiles()
 			return err
 		}
+		pm.deleteNetmapCache(kp.Key)
 		delete(pm.knownProfiles, kp.ID)
 	}
 	pm.NewProfile()
 	return pm.writeKnownProfiles()
 }
 
+// deleteNetmapCache deletes the netmap cache of the profile stored under
+// profileKey, which holds details of its tailnet.
+func (pm *profileManager) deleteNetmapCache(profileKey ipn.StateKey) {
+	if err := pm.WriteState(netmapCacheKey(profileKey), nil); err != nil {
+		pm.logf("deleting netmap cache: %v", err)
+	}
+}
+
 func (pm *profileManager) writeKnownProfiles() error {
 	b, err := json.Marshal(pm.knownProfiles)
 	if err != nil {
@@ -4,6 +4,8 @@
 package ipnlocal
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os/user"
 	"strconv"
@@ -613,3 +615,89 @@ func TestDefaultPrefs(t *testing.T) {
 		t.Errorf("defaultPrefs is %s, want %s; defaultPrefs should only modify WantRunning and LoggedOut, all other defaults should be in ipn.NewPrefs.", p2.Pretty(), p1.Pretty())
 	}
 }
+
+// TestProfileEphemeral tests that ephemeral nodes' profiles aren't persisted.
+func TestProfileEphemeral(t *testing.T) {
+	store := new(mem.Store)
+	pm, err := newProfileManagerWithGOOS(store, logger.Discard, new(health.Tracker), "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs := ipn.NewPrefs()
+	prefs.Ephemeral = true
+	prefs.Persist = &persist.Persist{
+		NodeID:         "node1",
+		PrivateNodeKey: key.NewNode(),
+		UserProfile: tailcfg.UserProfile{
+			ID:        1,
+			LoginName: "user1@example.com",
+		},
+	}
+	must.Do(pm.SetPrefs(prefs.View(), ipn.NetworkProfile{}))
+	if !pm.CurrentPrefs().Persist().Equals(prefs.Persist.View()) {
+		t.Errorf("ephemeral prefs weren't kept in memory: %v", pm.CurrentPrefs().Pretty())
+	}
+	if got := pm.Profiles(); len(got) != 0 {
+		t.Errorf("Profiles() = %v; want none", got)
+	}
+	j, err := store.ExportToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(j, []byte("node1")) {
+		t.Errorf("ephemeral node was persisted to the state store: %s", j)
+	}
+
+	pm, err = newProfileManagerWithGOOS(store, logger.Discard, new(health.Tracker), "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pm.CurrentPrefs().Persist().Valid() {
+		t.Errorf("ephemeral node survived a restart: %v", pm.CurrentPrefs().Pretty())
+	}
+}
+
+// TestProfileEphemeralAfterSave tests that a node with a saved profile can't
+// be made ephemeral, rather than silently no longer saving its prefs.
+func TestProfileEphemeralAfterSave(t *testing.T) {
+	store := new(mem.Store)
+	pm, err := newProfileManagerWithGOOS(store, logger.Discard, new(health.Tracker), "linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs := ipn.NewPrefs()
+	prefs.Persist = &persist.Persist{
+		NodeID:         "node1",
+		PrivateNodeKey: key.NewNode(),
+		UserProfile: tailcfg.UserProfile{
+			ID:        1,
+			LoginName: "user1@example.com",
+		},
+	}
+	must.Do(pm.SetPrefs(prefs.View(), ipn.NetworkProfile{}))
+	saved := pm.CurrentProfile()
+	if saved.ID == "" {
+		t.Fatal("profile wasn't saved")
+	}
+
+	eph := prefs.Clone()
+	eph.Ephemeral = true
+	eph.Hostname = "eph"
+	if err := pm.SetPrefs(eph.View(), ipn.NetworkProfile{}); !errors.Is(err, errEphemeralSavedProfile) {
+		t.Fatalf("SetPrefs(ephemeral) = %v; want %v", err, errEphemeralSavedProfile)
+	}
+	if pm.CurrentPrefs().Ephemeral() {
+		t.Error("saved profile was made ephemeral")
+	}
+
+	// Later saves still reach the state store.
+	prefs.Hostname = "later"
+	must.Do(pm.SetPrefs(prefs.View(), ipn.NetworkProfile{}))
+	got, err := pm.loadSavedPrefs(saved.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname() != "later" {
+		t.Errorf("saved Hostname = %q; want %q", got.Hostname(), "later")
+	}
+}
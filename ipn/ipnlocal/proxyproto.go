@@ -0,0 +1,61 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// proxyProtoV2Sig is the signature that starts a PROXY protocol version 2
+// header.
+const proxyProtoV2Sig = "\r\n\r\n\x00\r\nQUIT\n"
+
+// writeProxyHeader writes to w a PROXY protocol header of the given version,
+// 1 or 2, saying that a TCP connection from src to dst is being proxied.
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt.
+//
+// The header can't describe addresses of different families, so if only
+// one of src and dst is IPv4, it's written as an IPv4-mapped IPv6 address.
+func writeProxyHeader(w io.Writer, version int, src, dst netip.AddrPort) error {
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcIP.Is4() != dstIP.Is4() {
+		srcIP = netip.AddrFrom16(srcIP.As16())
+		dstIP = netip.AddrFrom16(dstIP.As16())
+	}
+	is4 := srcIP.Is4()
+
+	var hdr []byte
+	switch version {
+	case 1:
+		proto := "TCP6"
+		if is4 {
+			proto = "TCP4"
+		}
+		hdr = fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port(), dst.Port())
+	case 2:
+		hdr = append(hdr, proxyProtoV2Sig...)
+		hdr = append(hdr, 0x21) // version 2, PROXY command
+		if is4 {
+			hdr = append(hdr, 0x11) // TCP over IPv4
+			hdr = binary.BigEndian.AppendUint16(hdr, 12)
+			hdr = append(hdr, srcIP.AsSlice()...)
+			hdr = append(hdr, dstIP.AsSlice()...)
+		} else {
+			hdr = append(hdr, 0x21) // TCP over IPv6
+			hdr = binary.BigEndian.AppendUint16(hdr, 36)
+			s, d := srcIP.As16(), dstIP.As16()
+			hdr = append(hdr, s[:]...)
+			hdr = append(hdr, d[:]...)
+		}
+		hdr = binary.BigEndian.AppendUint16(hdr, src.Port())
+		hdr = binary.BigEndian.AppendUint16(hdr, dst.Port())
+	default:
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	_, err := w.Write(hdr)
+	return err
+}
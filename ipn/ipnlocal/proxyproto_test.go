@@ -0,0 +1,89 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestWriteProxyHeader(t *testing.T) {
+	v4src := netip.MustParseAddrPort("203.0.113.5:51000")
+	v4dst := netip.MustParseAddrPort("100.64.0.1:443")
+	v6dst := netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:443")
+	tests := []struct {
+		name     string
+		version  int
+		src, dst netip.AddrPort
+		want     string
+	}{
+		{
+			name:    "v1-ipv4",
+			version: 1,
+			src:     v4src,
+			dst:     v4dst,
+			want:    "PROXY TCP4 203.0.113.5 100.64.0.1 51000 443\r\n",
+		},
+		{
+			name:    "v1-mixed",
+			version: 1,
+			src:     v4src,
+			dst:     v6dst,
+			want:    "PROXY TCP6 ::ffff:203.0.113.5 fd7a:115c:a1e0::1 51000 443\r\n",
+		},
+		{
+			name:    "v2-ipv4",
+			version: 2,
+			src:     v4src,
+			dst:     v4dst,
+			want: proxyProtoV2Sig + "\x21\x11\x00\x0c" +
+				"\xcb\x00\x71\x05" + "\x64\x40\x00\x01" +
+				"\xc7\x38" + "\x01\xbb",
+		},
+		{
+			name:    "v2-mixed",
+			version: 2,
+			src:     v4src,
+			dst:     v6dst,
+			want: proxyProtoV2Sig + "\x21\x21\x00\x24" +
+				"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\xcb\x00\x71\x05" +
+				"\xfd\x7a\x11\x5c\xa1\xe0\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\xc7\x38" + "\x01\xbb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeProxyHeader(&buf, tt.version, tt.src, tt.dst); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := writeProxyHeader(new(bytes.Buffer), 3, v4src, v4dst); err == nil {
+		t.Error("version 3 succeeded; want error")
+	}
+}
+
+func TestForwardedHeaderValue(t *testing.T) {
+	tests := []struct {
+		src  string
+		host string
+		tls  bool
+		want string
+	}{
+		{"203.0.113.5", "foo.test.ts.net", true, `for=203.0.113.5;host="foo.test.ts.net";proto=https`},
+		{"2001:db8::1", "foo.test.ts.net:8443", true, `for="[2001:db8::1]";host="foo.test.ts.net:8443";proto=https`},
+		{"100.64.0.2", "", false, `for=100.64.0.2;proto=http`},
+	}
+	for _, tt := range tests {
+		if got := forwardedHeaderValue(netip.MustParseAddr(tt.src), tt.host, tt.tls); got != tt.want {
+			t.Errorf("forwardedHeaderValue(%s, %q, %v) = %s; want %s", tt.src, tt.host, tt.tls, got, tt.want)
+		}
+	}
+}
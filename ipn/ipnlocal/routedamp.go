@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"math"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+// Route flap damping parameters, modeled on BGP route flap damping (RFC
+// 2439). Each time an accepted route appears or disappears, its penalty
+// grows by routeFlapPenalty; penalties halve every routeDampHalfLife. A
+// route whose penalty reaches routeDampSuppress is suppressed: it isn't
+// installed again until its penalty decays below routeDampReuse.
+// routeDampMaxPenalty bounds how long that can take.
+//
+// Withdrawals are never held back: wireguard-go stops accepting a
+// withdrawn route's traffic from the peer at once, so keeping the route in
+// the system's routing table would only black-hole it.
+const (
+	routeFlapPenalty    = 1000
+	routeDampSuppress   = 2500
+	routeDampReuse      = 750
+	routeDampMaxPenalty = 12000 // 4 half-lives above routeDampReuse
+	routeDampHalfLife   = time.Minute
+)
+
+// routeDamper damps changes to the routes accepted from peers, so that a
+// subnet router or exit node that rapidly flaps its advertisements doesn't
+// cause as much churn in the system's routing table.
+//
+// The zero value is ready for use. It's not safe for concurrent use.
+type routeDamper struct {
+	routes map[netip.Prefix]*routeDampState
+}
+
+type routeDampState struct {
+	penalty    float64
+	updated    time.Time // when penalty was last updated
+	present    bool      // whether the route was in the last set seen
+	installed  bool      // whether the route is being installed
+	suppressed bool      // whether re-adding the route is being held
+}
+
+// dampable reports whether changes to the route p are subject to damping.
+// Routes to Tailscale addresses come and go with the nodes of the tailnet
+// rather than their advertisements, so they aren't.
+func dampable(p netip.Prefix) bool {
+	cgNAT, tsULA := tsaddr.CGNATRange(), tsaddr.TailscaleULARange()
+	switch {
+	case p == cgNAT, p == tsULA:
+		return false
+	case p.IsSingleIP() && (cgNAT.Contains(p.Addr()) || tsULA.Contains(p.Addr())):
+		return false
+	}
+	return true
+}
+
+// reset forgets the history of all routes, so that the next set of routes
+// passed to damp is installed as is.
+func (d *routeDamper) reset() {
+	d.routes = nil
+}
+
+// damp records routes as the routes currently accepted from peers at time
+// now, and returns the routes to install instead. It also returns the
+// routes being suppressed and, if any are, how long until at least one of
+// them can be reused, at which point damp should be called again.
+func (d *routeDamper) damp(routes []netip.Prefix, now time.Time) (install, suppressed []netip.Prefix, retry time.Duration) {
+	if d.routes == nil {
+		d.routes = make(map[netip.Prefix]*routeDampState)
+	}
+	present := make(map[netip.Prefix]bool, len(routes))
+	for _, r := range routes {
+		if !dampable(r) {
+			install = append(install, r)
+			continue
+		}
+		present[r] = true
+		if _, ok := d.routes[r]; !ok {
+			d.routes[r] = &routeDampState{updated: now, present: true, installed: true}
+		}
+	}
+	for r, st := range d.routes {
+		st.decay(now)
+		if p := present[r]; p != st.present {
+			st.present = p
+			st.penalty = min(st.penalty+routeFlapPenalty, routeDampMaxPenalty)
+		}
+		switch {
+		case !st.suppressed && st.penalty >= routeDampSuppress:
+			st.suppressed = true
+		case st.suppressed && st.penalty < routeDampReuse:
+			st.suppressed = false
+		}
+		if !st.suppressed || !st.present {
+			st.installed = st.present
+		}
+		if st.installed {
+			install = append(install, r)
+		}
+		if st.suppressed {
+			suppressed = append(suppressed, r)
+			if wait := st.untilReuse(); retry == 0 || wait < retry {
+				retry = wait
+			}
+		} else if !st.present && st.penalty < 1 {
+			delete(d.routes, r)
+		}
+	}
+	tsaddr.SortPrefixes(install)
+	tsaddr.SortPrefixes(suppressed)
+	return install, suppressed, retry
+}
+
+// decay decays st's penalty to what it is at time now.
+func (st *routeDampState) decay(now time.Time) {
+	if elapsed := now.Sub(st.updated); elapsed > 0 {
+		st.penalty *= math.Exp2(-float64(elapsed) / float64(routeDampHalfLife))
+		st.updated = now
+	}
+}
+
+// untilReuse returns how long it will take st's penalty to decay below
+// routeDampReuse.
+func (st *routeDampState) untilReuse() time.Duration {
+	if st.penalty < routeDampReuse {
+		return 0
+	}
+	d := time.Duration(math.Log2(st.penalty/routeDampReuse) * float64(routeDampHalfLife))
+	return d + time.Second // to be sure it has decayed below
+}
+
+var routeFlapDampingWarnable = health.Register(&health.Warnable{
+	Code:     "route-flap-damping",
+	Title:    "Flapping routes suppressed",
+	Severity: health.SeverityLow,
+	Text: func(args health.Args) string {
+		return "Some routes advertised by peers changed too often and won't be used again until they settle: " + args[health.ArgRoutes]
+	},
+})
+
+// dampRoutesLocked damps changes to routes, the routes accepted from peers,
+// and returns the routes to install in the system's routing table instead.
+// It updates the route damping health warning and schedules a
+// reconfiguration for when suppressed routes can be reused.
+//
+// b.mu must be held.
+func (b *LocalBackend) dampRoutesLocked(routes []netip.Prefix, prefs ipn.PrefsView) []netip.Prefix {
+	// Only damp changes in what peers advertise, not changes the user
+	// made by changing which routes to accept.
+	key := routeDampKey{prefs.RouteAll(), prefs.ExitNodeID(), prefs.ExitNodeIP()}
+	if key != b.routeDampKey {
+		b.routeDamp.reset()
+		b.routeDampKey = key
+	}
+	install, suppressed, retry := b.routeDamp.damp(routes, b.clock.Now())
+	if len(suppressed) == 0 {
+		b.health.SetHealthy(routeFlapDampingWarnable)
+	} else {
+		ss := make([]string, len(suppressed))
+		for i, p := range suppressed {
+			ss[i] = p.String()
+		}
+		b.logf("[v1] route flap damping: holding %v", ss)
+		b.health.SetUnhealthy(routeFlapDampingWarnable, health.Args{
+			health.ArgRoutes: strings.Join(ss, ", "),
+		})
+	}
+	if b.routeDampTimer != nil {
+		b.routeDampTimer.Stop()
+		b.routeDampTimer = nil
+	}
+	if retry > 0 && !b.shutdownCalled {
+		b.routeDampTimer = b.clock.AfterFunc(retry, b.authReconfig)
+	}
+	return install
+}
+
+// routeDampKey is the prefs that determine which of the routes advertised
+// by peers are accepted.
+type routeDampKey struct {
+	routeAll   bool
+	exitNodeID tailcfg.StableNodeID
+	exitNodeIP netip.Addr
+}
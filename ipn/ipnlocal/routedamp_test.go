@@ -0,0 +1,89 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteDamper(t *testing.T) {
+	var (
+		subnet = netip.MustParsePrefix("10.0.0.0/24")
+		peer   = netip.MustParsePrefix("100.64.0.1/32")
+		now    = time.Unix(1_700_000_000, 0)
+		d      routeDamper
+	)
+	step := func(name string, routes []netip.Prefix, wantInstall, wantSuppressed []netip.Prefix) time.Duration {
+		t.Helper()
+		install, suppressed, retry := d.damp(routes, now)
+		if !reflect.DeepEqual(install, wantInstall) {
+			t.Errorf("%s: install = %v; want %v", name, install, wantInstall)
+		}
+		if !reflect.DeepEqual(suppressed, wantSuppressed) {
+			t.Errorf("%s: suppressed = %v; want %v", name, suppressed, wantSuppressed)
+		}
+		if (retry > 0) != (len(wantSuppressed) > 0) {
+			t.Errorf("%s: retry = %v with %d routes suppressed", name, retry, len(wantSuppressed))
+		}
+		now = now.Add(time.Second)
+		return retry
+	}
+	both := []netip.Prefix{subnet, peer}
+	peerOnly := []netip.Prefix{peer}
+
+	step("initial", both, both, nil)
+	step("withdrawn", peerOnly, peerOnly, nil)
+	step("readvertised", both, both, nil)
+	// The third flap in a few seconds suppresses the route. It's
+	// withdrawn at once all the same, but not reinstalled when it comes
+	// back.
+	step("withdrawn again", peerOnly, peerOnly, []netip.Prefix{subnet})
+	retry := step("readvertised again", both, peerOnly, []netip.Prefix{subnet})
+
+	// Routes to Tailscale addresses are never damped.
+	for range 3 {
+		step("peer flap", []netip.Prefix{subnet}, nil, []netip.Prefix{subnet})
+		step("peer flap", both, peerOnly, []netip.Prefix{subnet})
+	}
+
+	// Once the penalty decays, the current state is installed.
+	now = now.Add(retry)
+	step("reused", both, both, nil)
+	step("withdrawn after reuse", peerOnly, peerOnly, nil)
+
+	// After long enough, the route's history is forgotten.
+	now = now.Add(time.Hour)
+	step("forgotten", peerOnly, peerOnly, nil)
+	if _, ok := d.routes[subnet]; ok {
+		t.Errorf("withdrawn route still tracked after an hour")
+	}
+}
+
+// TestRouteDamperWithdrawWhileSuppressed checks that withdrawing a damped
+// route takes effect at once, as wireguard-go no longer accepts its traffic
+// from the peer.
+func TestRouteDamperWithdrawWhileSuppressed(t *testing.T) {
+	exit := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}
+	now := time.Unix(1_700_000_000, 0)
+	var d routeDamper
+	var wasSuppressed bool
+	for i := range 10 {
+		routes := exit
+		if i%2 == 1 {
+			routes = nil
+		}
+		install, suppressed, _ := d.damp(routes, now)
+		wasSuppressed = wasSuppressed || len(suppressed) > 0
+		if routes == nil && len(install) > 0 {
+			t.Fatalf("flap %d: withdrawn route installed: %v (suppressed %v)", i, install, suppressed)
+		}
+		now = now.Add(time.Second)
+	}
+	if !wasSuppressed {
+		t.Error("flapping route was never suppressed")
+	}
+}
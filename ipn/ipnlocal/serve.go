@@ -29,9 +29,12 @@
 	"unicode/utf8"
 
 	"golang.org/x/net/http2"
+	"tailscale.com/feature"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/netutil"
+	"tailscale.com/omit"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/lazy"
@@ -41,6 +44,12 @@
 	"tailscale.com/version"
 )
 
+func init() {
+	if !omit.Serve {
+		feature.Register("serve")
+	}
+}
+
 const (
 	contentTypeHeader   = "Content-Type"
 	grpcBaseContentType = "application/grpc"
@@ -53,6 +62,10 @@
 
 var serveHTTPContextKey ctxkey.Key[*serveHTTPContext]
 
+// serveForwardedHeaderKey is set in the context of requests whose
+// ipn.HTTPHandler has ForwardedHeader set.
+var serveForwardedHeaderKey ctxkey.Key[bool]
+
 type serveHTTPContext struct {
 	SrcAddr  netip.AddrPort
 	DestPort uint16
@@ -252,6 +265,9 @@ func (b *LocalBackend) updateServeTCPPortNetMapAddrListenersLocked(ports []uint1
 // ETag is an optional parameter to enforce Optimistic Concurrency Control.
 // If it is an empty string, then the config will be overwritten.
 func (b *LocalBackend) SetServeConfig(config *ipn.ServeConfig, etag string) error {
+	if omit.Serve {
+		return omit.Err
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	return b.setServeConfigLocked(config, etag)
@@ -293,6 +309,11 @@ func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig, etag string
 		}
 	}
 
+	self := &ipnstate.PeerStatus{CapMap: nm.SelfNode.CapMap().AsMap()}
+	if err := ipn.CheckFunnelConfig(config, prevConfig, self); err != nil {
+		return err
+	}
+
 	var bs []byte
 	if config != nil {
 		j, err := json.Marshal(config)
@@ -344,6 +365,9 @@ func (b *LocalBackend) ServeConfig() ipn.ServeConfigView {
 // in the LocalBackend if it exists. It also ensures check, delete, and
 // set operations happen within the same mutex lock to avoid any races.
 func (b *LocalBackend) DeleteForegroundSession(sessionID string) error {
+	if omit.Serve {
+		return nil
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if !b.serveConfig.Valid() || !b.serveConfig.Foreground().Contains(sessionID) {
@@ -474,6 +498,16 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 				return nil
 			}
 			defer backConn.Close()
+			if v := tcph.ProxyProtocol(); v != 0 {
+				dst := netip.AddrPortFrom(netip.IPv6Unspecified(), dport)
+				if la, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+					dst = la.AddrPort()
+				}
+				if err := writeProxyHeader(backConn, v, srcAddr, dst); err != nil {
+					b.logf("localbackend: failed to send PROXY header to %s: %v", backDst, err)
+					return nil
+				}
+			}
 			if sni := tcph.TerminateTLS(); sni != "" {
 				conn = tls.Server(conn, &tls.Config{
 					GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -702,9 +736,36 @@ func addProxyForwardedHeaders(r *httputil.ProxyRequest) {
 	if r.In.TLS != nil {
 		r.Out.Header.Set("X-Forwarded-Proto", "https")
 	}
-	if c, ok := serveHTTPContextKey.ValueOk(r.Out.Context()); ok {
-		r.Out.Header.Set("X-Forwarded-For", c.SrcAddr.Addr().String())
+	c, ok := serveHTTPContextKey.ValueOk(r.Out.Context())
+	if !ok {
+		return
+	}
+	r.Out.Header.Set("X-Forwarded-For", c.SrcAddr.Addr().String())
+	if serveForwardedHeaderKey.Value(r.Out.Context()) {
+		r.Out.Header.Set("Forwarded", forwardedHeaderValue(c.SrcAddr.Addr(), r.In.Host, r.In.TLS != nil))
+	}
+}
+
+// forwardedHeaderValue returns the value of a Forwarded header (RFC 7239)
+// for a request from src for host, received over TLS if tls is true.
+func forwardedHeaderValue(src netip.Addr, host string, tls bool) string {
+	var sb strings.Builder
+	sb.WriteString("for=")
+	if src = src.Unmap(); src.Is6() {
+		sb.WriteString(strconv.Quote("[" + src.String() + "]"))
+	} else {
+		sb.WriteString(src.String())
+	}
+	if host != "" {
+		sb.WriteString(";host=")
+		sb.WriteString(strconv.Quote(host))
 	}
+	if tls {
+		sb.WriteString(";proto=https")
+	} else {
+		sb.WriteString(";proto=http")
+	}
+	return sb.String()
 }
 
 func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
@@ -771,6 +832,9 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
+		if h.ForwardedHeader() {
+			r = r.WithContext(serveForwardedHeaderKey.WithValue(r.Context(), true))
+		}
 		h := p.(http.Handler)
 		// Trim the mount point from the URL path before proxying. (#6571)
 		if r.URL.Path != "/" {
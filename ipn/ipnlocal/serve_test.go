@@ -698,6 +698,11 @@ func newTestBackend(t *testing.T) *LocalBackend {
 	b.netMap = &netmap.NetworkMap{
 		SelfNode: (&tailcfg.Node{
 			Name: "example.ts.net",
+			CapMap: tailcfg.NodeCapMap{
+				tailcfg.CapabilityHTTPS:                      nil,
+				tailcfg.NodeAttrFunnel:                       nil,
+				tailcfg.CapabilityFunnelPorts + "?ports=443": nil,
+			},
 		}).View(),
 		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
 			tailcfg.UserID(1): {
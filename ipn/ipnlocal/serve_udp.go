@@ -0,0 +1,333 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/omit"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
+	"tailscale.com/util/mak"
+)
+
+// udpSessionIdleTimeout is how long a UDP serve session lasts without
+// traffic in either direction. It matches netstack's timeout for the UDP
+// flows it forwards.
+const udpSessionIdleTimeout = 2 * time.Minute
+
+// maxUDPDatagramSize is the largest UDP payload a serve session forwards.
+const maxUDPDatagramSize = 65535
+
+// udpForwarder forwards the datagrams received on a serve UDP port to its
+// UDPForward backend. Like a NAT, it tracks a session per client address,
+// each with its own socket to the backend so that the backend's replies go
+// back to the right client, and ends sessions that have been idle for
+// udpSessionIdleTimeout.
+type udpForwarder struct {
+	logf    logger.Logf
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	backDst string // the UDPForward backend
+
+	mu       sync.Mutex
+	closed   bool
+	sessions map[netip.AddrPort]*udpSession // by client address
+}
+
+// udpSession is a udpForwarder's session for one client address.
+type udpSession struct {
+	client io.WriteCloser // writes a datagram back to the client
+	back   net.Conn       // connected to the backend
+	idle   *time.Timer    // ends the session when it fires
+}
+
+// forward sends the datagram pkt from the client at src to the backend,
+// starting a session for src if there isn't one. Replies from the backend
+// are written to client, which is closed when the session ends.
+func (f *udpForwarder) forward(src netip.AddrPort, pkt []byte, client io.WriteCloser) error {
+	s, err := f.session(src, client)
+	if err != nil {
+		return err
+	}
+	s.idle.Reset(udpSessionIdleTimeout)
+	_, err = s.back.Write(pkt)
+	return err
+}
+
+func (f *udpForwarder) session(src netip.AddrPort, client io.WriteCloser) (*udpSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, net.ErrClosed
+	}
+	if s, ok := f.sessions[src]; ok {
+		if s.client == client {
+			return s, nil
+		}
+		// The client reconnected over a different path; its old
+		// session's replies have nowhere to go.
+		f.endSessionLocked(src, s)
+	}
+
+	// Dialing UDP doesn't send anything, so this doesn't block for long.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	back, err := f.dial(ctx, "udp", f.backDst)
+	if err != nil {
+		return nil, err
+	}
+	s := &udpSession{client: client, back: back}
+	s.idle = time.AfterFunc(udpSessionIdleTimeout, func() { f.endSession(src, s) })
+	mak.Set(&f.sessions, src, s)
+	go f.copyReplies(src, s)
+	return s, nil
+}
+
+// copyReplies copies datagrams from the backend to the client of s until
+// the session ends.
+func (f *udpForwarder) copyReplies(src netip.AddrPort, s *udpSession) {
+	defer f.endSession(src, s)
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, err := s.back.Read(buf)
+		if err != nil {
+			return
+		}
+		s.idle.Reset(udpSessionIdleTimeout)
+		if _, err := s.client.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// endSession ends the session s for the client at src, if it hasn't
+// already ended.
+func (f *udpForwarder) endSession(src netip.AddrPort, s *udpSession) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sessions[src] == s {
+		f.endSessionLocked(src, s)
+	}
+}
+
+func (f *udpForwarder) endSessionLocked(src netip.AddrPort, s *udpSession) {
+	delete(f.sessions, src)
+	s.idle.Stop()
+	s.back.Close()
+	s.client.Close()
+}
+
+// endClient ends the session for the client at src if it's using client.
+func (f *udpForwarder) endClient(src netip.AddrPort, client io.WriteCloser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[src]; ok && s.client == client {
+		f.endSessionLocked(src, s)
+	}
+}
+
+// Close ends all of f's sessions and makes it refuse new ones.
+func (f *udpForwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for src, s := range f.sessions {
+		f.endSessionLocked(src, s)
+	}
+}
+
+// serveUDPClient forwards the datagrams read from client, which come from
+// the client at src, with f until client is closed or fails.
+func (b *LocalBackend) serveUDPClient(f *udpForwarder, src netip.AddrPort, client io.ReadWriteCloser) {
+	defer f.endClient(src, client)
+	defer client.Close()
+	buf := make([]byte, maxUDPDatagramSize)
+	for {
+		n, err := client.Read(buf)
+		if err != nil {
+			return
+		}
+		if err := f.forward(src, buf[:n], client); err != nil {
+			b.logf("serve: failed to forward UDP from %v to %s: %v", src, f.backDst, err)
+			return
+		}
+	}
+}
+
+// udpForwarderForServe returns the udpForwarder for the serve UDP port
+// dport, or nil if the port isn't being served.
+func (b *LocalBackend) udpForwarderForServe(dport uint16) *udpForwarder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.serveConfig.Valid() {
+		return nil
+	}
+	udph, ok := b.serveConfig.FindUDP(dport)
+	if !ok || udph.UDPForward() == "" {
+		return nil
+	}
+	if f, ok := b.udpForwarders[dport]; ok && f.backDst == udph.UDPForward() {
+		return f
+	}
+	return b.newUDPForwarderLocked(dport, udph.UDPForward())
+}
+
+// newUDPForwarderLocked replaces the udpForwarder for the serve UDP port
+// dport with a new one that forwards to backDst.
+//
+// b.mu must be held.
+func (b *LocalBackend) newUDPForwarderLocked(dport uint16, backDst string) *udpForwarder {
+	if old, ok := b.udpForwarders[dport]; ok {
+		old.Close()
+	}
+	f := &udpForwarder{
+		logf:    b.logf,
+		dial:    b.dialer.SystemDial,
+		backDst: backDst,
+	}
+	mak.Set(&b.udpForwarders, dport, f)
+	return f
+}
+
+// updateServeUDPForwardersLocked closes the udpForwarders of UDP ports that
+// are no longer served, or are now forwarded elsewhere, and returns the UDP
+// ports being served.
+//
+// b.mu must be held.
+func (b *LocalBackend) updateServeUDPForwardersLocked() (ports []uint16) {
+	want := map[uint16]string{}
+	if b.serveConfig.Valid() {
+		b.serveConfig.RangeOverUDPs(func(port uint16, _ ipn.UDPPortHandlerView) bool {
+			if udph, ok := b.serveConfig.FindUDP(port); ok && udph.UDPForward() != "" {
+				want[port] = udph.UDPForward()
+			}
+			return true
+		})
+	}
+	for port, f := range b.udpForwarders {
+		if want[port] != f.backDst {
+			f.Close()
+			delete(b.udpForwarders, port)
+		}
+	}
+	for port := range want {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// UDPHandlerForDst returns a handler for the UDP flow from src to dst, or
+// nil if tailscaled doesn't handle it.
+func (b *LocalBackend) UDPHandlerForDst(src, dst netip.AddrPort) func(nettype.ConnPacketConn) {
+	if omit.Serve || !b.isLocalIP(dst.Addr()) {
+		return nil
+	}
+	f := b.udpForwarderForServe(dst.Port())
+	if f == nil {
+		return nil
+	}
+	return func(c nettype.ConnPacketConn) {
+		b.serveUDPClient(f, src, c)
+	}
+}
+
+// HandleIngressUDPConn handles a UDP flow from srcAddr, a client on the
+// internet, relayed over Funnel by ingressPeer. The flow's datagrams are
+// carried over the connection returned by getConnOrReset, each prefixed by
+// its length as a big-endian uint16; see datagramStream.
+func (b *LocalBackend) HandleIngressUDPConn(ingressPeer tailcfg.NodeView, target ipn.HostPort, srcAddr netip.AddrPort, getConnOrReset func() (net.Conn, bool), sendRST func()) {
+	b.mu.Lock()
+	sc := b.serveConfig
+	b.mu.Unlock()
+
+	logf := logger.WithPrefix(b.logf, "handleIngressUDP: ")
+
+	if !sc.Valid() || !sc.HasFunnelForTarget(target) {
+		logf("got ingress flow for unconfigured %q; rejecting", target)
+		sendRST()
+		return
+	}
+	_, port, err := net.SplitHostPort(string(target))
+	if err != nil {
+		logf("got ingress flow for bad target %q; rejecting", target)
+		sendRST()
+		return
+	}
+	dport, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		logf("got ingress flow for bad target %q; rejecting", target)
+		sendRST()
+		return
+	}
+	f := b.udpForwarderForServe(uint16(dport))
+	if f == nil {
+		logf("got ingress flow for unserved UDP port %v; rejecting", dport)
+		sendRST()
+		return
+	}
+	c, ok := getConnOrReset()
+	if !ok {
+		logf("getConn didn't complete from %v to port %v", srcAddr, dport)
+		return
+	}
+	b.serveUDPClient(f, srcAddr, newDatagramStream(c))
+}
+
+// datagramStream carries datagrams over a stream connection, such as a
+// Funnel ingress connection, each prefixed by its length as a big-endian
+// uint16. Each Read returns one datagram and each Write sends one.
+type datagramStream struct {
+	c  net.Conn
+	br *bufio.Reader
+
+	wmu  sync.Mutex // guards wbuf and writes to c
+	wbuf []byte
+}
+
+func newDatagramStream(c net.Conn) *datagramStream {
+	return &datagramStream{c: c, br: bufio.NewReader(c)}
+}
+
+// Read reads the next datagram into p. It returns io.ErrShortBuffer if the
+// datagram doesn't fit.
+func (s *datagramStream) Read(p []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(s.br, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return io.ReadFull(s.br, p[:n])
+}
+
+// Write writes p as one datagram.
+func (s *datagramStream) Write(p []byte) (int, error) {
+	if len(p) > maxUDPDatagramSize {
+		return 0, io.ErrShortWrite
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.wbuf = binary.BigEndian.AppendUint16(s.wbuf[:0], uint16(len(p)))
+	s.wbuf = append(s.wbuf, p...)
+	if _, err := s.c.Write(s.wbuf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *datagramStream) Close() error {
+	return s.c.Close()
+}
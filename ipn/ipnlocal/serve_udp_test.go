@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// udpTestClient is a client of a udpForwarder whose replies go to a
+// channel.
+type udpTestClient struct {
+	replies chan string
+	closed  chan struct{}
+}
+
+func newUDPTestClient() *udpTestClient {
+	return &udpTestClient{replies: make(chan string, 10), closed: make(chan struct{})}
+}
+
+func (c *udpTestClient) Write(p []byte) (int, error) {
+	c.replies <- string(p)
+	return len(p), nil
+}
+
+func (c *udpTestClient) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return nil
+}
+
+func TestUDPForwarder(t *testing.T) {
+	// The backend replies to each datagram with its source address.
+	back, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer back.Close()
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			_, addr, err := back.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			back.WriteTo([]byte(addr.String()), addr)
+		}
+	}()
+
+	var d net.Dialer
+	f := &udpForwarder{logf: t.Logf, dial: d.DialContext, backDst: back.LocalAddr().String()}
+	defer f.Close()
+
+	recv := func(c *udpTestClient) string {
+		t.Helper()
+		select {
+		case r := <-c.replies:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for reply")
+			return ""
+		}
+	}
+
+	src1 := netip.MustParseAddrPort("100.64.0.1:1000")
+	src2 := netip.MustParseAddrPort("100.64.0.2:1000")
+	c1, c2 := newUDPTestClient(), newUDPTestClient()
+	if err := f.forward(src1, []byte("a"), c1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.forward(src2, []byte("b"), c2); err != nil {
+		t.Fatal(err)
+	}
+	addr1, addr2 := recv(c1), recv(c2)
+	if addr1 == addr2 {
+		t.Errorf("two clients share backend address %v; want a session each", addr1)
+	}
+
+	// Later datagrams from the same client use its session.
+	if err := f.forward(src1, []byte("a"), c1); err != nil {
+		t.Fatal(err)
+	}
+	if got := recv(c1); got != addr1 {
+		t.Errorf("second datagram from %v came from %v; want %v", src1, got, addr1)
+	}
+
+	f.endClient(src1, c1)
+	select {
+	case <-c1.closed:
+	default:
+		t.Error("client not closed when its session ended")
+	}
+	if _, ok := f.sessions[src1]; ok {
+		t.Error("session still tracked after it ended")
+	}
+
+	f.Close()
+	select {
+	case <-c2.closed:
+	default:
+		t.Error("client not closed when forwarder closed")
+	}
+	if err := f.forward(src1, []byte("a"), c1); err == nil {
+		t.Error("forward succeeded after Close")
+	}
+}
+
+func TestDatagramStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	s1, s2 := newDatagramStream(c1), newDatagramStream(c2)
+	defer s1.Close()
+	defer s2.Close()
+
+	msgs := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), maxUDPDatagramSize)}
+	go func() {
+		for _, m := range msgs {
+			if _, err := s1.Write(m); err != nil {
+				t.Errorf("Write: %v", err)
+				return
+			}
+		}
+	}()
+	buf := make([]byte, maxUDPDatagramSize)
+	for _, want := range msgs {
+		n, err := s2.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("Read %d bytes; want %d", n, len(want))
+		}
+	}
+
+	if _, err := s1.Write(make([]byte, maxUDPDatagramSize+1)); err != io.ErrShortWrite {
+		t.Errorf("Write of oversized datagram = %v; want %v", err, io.ErrShortWrite)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
+)
+
+// maxDeclaredServices is the maximum number of services that can be
+// declared on a node, to bound the size of its Hostinfo.
+const maxDeclaredServices = 64
+
+// checkDeclaredService reports whether svc is valid to declare.
+func checkDeclaredService(svc tailcfg.DeclaredService) error {
+	if err := dnsname.ValidLabel(svc.Name); err != nil {
+		return fmt.Errorf("invalid service name %q: %w", svc.Name, err)
+	}
+	if svc.Name != strings.ToLower(svc.Name) {
+		return fmt.Errorf("invalid service name %q: must be lowercase", svc.Name)
+	}
+	switch svc.Proto {
+	case tailcfg.TCP, tailcfg.UDP:
+	default:
+		return fmt.Errorf("invalid service protocol %q; want %q or %q", svc.Proto, tailcfg.TCP, tailcfg.UDP)
+	}
+	if svc.Port == 0 {
+		return errors.New("service port must be non-zero")
+	}
+	switch svc.Health {
+	case tailcfg.ServiceHealthUnknown, tailcfg.ServiceHealthOK, tailcfg.ServiceHealthDown:
+	default:
+		return fmt.Errorf("invalid service health %q", svc.Health)
+	}
+	if svc.HealthCheck {
+		if svc.Proto != tailcfg.TCP {
+			return errors.New("health checks are only supported for TCP services")
+		}
+		if svc.Health != tailcfg.ServiceHealthUnknown {
+			return errors.New("can't report the health of a service with a health check")
+		}
+	}
+	return nil
+}
+
+// DeclaredServices returns the services declared on this node for the
+// current profile, sorted by name, with the results of their health checks.
+func (b *LocalBackend) DeclaredServices() ([]tailcfg.DeclaredService, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.declaredServicesWithHealthLocked()
+}
+
+// declaredServicesLocked returns the services declared for the current
+// profile, loading them from the state store the first time. The caller
+// must not modify the returned slice.
+//
+// b.mu must be held.
+func (b *LocalBackend) declaredServicesLocked() ([]tailcfg.DeclaredService, error) {
+	profileID := b.pm.CurrentProfile().ID
+	if b.declaredServicesLoaded && b.declaredServicesFor == profileID {
+		return b.declaredServices, nil
+	}
+	var svcs []tailcfg.DeclaredService
+	bs, err := b.store.ReadState(ipn.DeclaredServicesKey(profileID))
+	switch {
+	case errors.Is(err, ipn.ErrStateNotExist) || err == nil && len(bs) == 0:
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(bs, &svcs); err != nil {
+			return nil, fmt.Errorf("decoding declared services: %w", err)
+		}
+	}
+	b.declaredServices = svcs
+	b.declaredServicesFor = profileID
+	b.declaredServicesLoaded = true
+	return svcs, nil
+}
+
+// declaredServicesWithHealthLocked returns a copy of the services declared
+// for the current profile with the latest results of their health checks
+// in their Health fields.
+//
+// b.mu must be held.
+func (b *LocalBackend) declaredServicesWithHealthLocked() ([]tailcfg.DeclaredService, error) {
+	svcs, err := b.declaredServicesLocked()
+	if err != nil {
+		return nil, err
+	}
+	svcs = slices.Clone(svcs)
+	for i, svc := range svcs {
+		if svc.HealthCheck {
+			svcs[i].Health = b.serviceHealth[svc.Name]
+		}
+	}
+	return svcs, nil
+}
+
+// DeclareService declares svc on this node, replacing any service already
+// declared with the same name. The declared services are sent to control
+// in Hostinfo, from where they reach peers.
+func (b *LocalBackend) DeclareService(svc tailcfg.DeclaredService) error {
+	if err := checkDeclaredService(svc); err != nil {
+		return err
+	}
+	return b.editDeclaredServices(func(svcs []tailcfg.DeclaredService) ([]tailcfg.DeclaredService, error) {
+		svcs = slices.DeleteFunc(svcs, func(s tailcfg.DeclaredService) bool { return s.Name == svc.Name })
+		if len(svcs) >= maxDeclaredServices {
+			return nil, fmt.Errorf("too many declared services; the limit is %d", maxDeclaredServices)
+		}
+		svcs = append(svcs, svc)
+		slices.SortFunc(svcs, func(a, b tailcfg.DeclaredService) int { return cmp.Compare(a.Name, b.Name) })
+		return svcs, nil
+	})
+}
+
+// RemoveDeclaredService removes the service with the given name declared
+// on this node. It's not an error if there's no such service.
+func (b *LocalBackend) RemoveDeclaredService(name string) error {
+	return b.editDeclaredServices(func(svcs []tailcfg.DeclaredService) ([]tailcfg.DeclaredService, error) {
+		return slices.DeleteFunc(svcs, func(s tailcfg.DeclaredService) bool { return s.Name == name }), nil
+	})
+}
+
+// editDeclaredServices replaces the declared services for the current
+// profile with the result of edit and sends them to control.
+func (b *LocalBackend) editDeclaredServices(edit func([]tailcfg.DeclaredService) ([]tailcfg.DeclaredService, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.isConfigLocked_Locked() {
+		return errors.New("can't reconfigure tailscaled when using a config file; config file is locked")
+	}
+	svcs, err := b.declaredServicesLocked()
+	if err != nil {
+		return err
+	}
+	svcs, err = edit(slices.Clone(svcs))
+	if err != nil {
+		return err
+	}
+	var bs []byte
+	if len(svcs) > 0 {
+		if bs, err = json.Marshal(svcs); err != nil {
+			return err
+		}
+	}
+	if err := b.store.WriteState(ipn.DeclaredServicesKey(b.pm.CurrentProfile().ID), bs); err != nil {
+		return fmt.Errorf("writing declared services to StateStore: %w", err)
+	}
+	b.declaredServices = svcs
+	b.scheduleServiceProbesLocked()
+	go b.doSetHostinfoFilterServices()
+	return nil
+}
+
+// TailnetServices returns the services declared on this node and on its
+// peers, sorted by service and node name.
+func (b *LocalBackend) TailnetServices() ([]apitype.TailnetService, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	nm := b.netMap
+	if nm == nil || !nm.SelfNode.Valid() {
+		return nil, errors.New("not connected to the tailnet")
+	}
+	self, err := b.declaredServicesWithHealthLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []apitype.TailnetService
+	add := func(n tailcfg.NodeView, svc tailcfg.DeclaredService, isSelf bool) {
+		ts := apitype.TailnetService{
+			DeclaredService: svc,
+			NodeID:          n.StableID(),
+			NodeName:        strings.TrimSuffix(n.Name(), "."),
+			Online:          isSelf || n.Online() != nil && *n.Online(),
+			Self:            isSelf,
+		}
+		if ts.NodeName == "" {
+			ts.NodeName = n.ComputedName()
+		}
+		for i := range n.Addresses().Len() {
+			if a := n.Addresses().At(i); a.IsSingleIP() {
+				ts.Addrs = append(ts.Addrs, a.Addr())
+			}
+		}
+		ret = append(ret, ts)
+	}
+	for _, svc := range self {
+		add(nm.SelfNode, svc, true)
+	}
+	for _, p := range b.peers {
+		if !p.Hostinfo().Valid() {
+			continue
+		}
+		svcs := p.Hostinfo().DeclaredServices()
+		for i := range svcs.Len() {
+			add(p, svcs.At(i), false)
+		}
+	}
+	slices.SortFunc(ret, func(a, b apitype.TailnetService) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.NodeName, b.NodeName),
+			cmp.Compare(a.Port, b.Port),
+		)
+	})
+	return ret, nil
+}
+
+// serviceProbeInterval is how often declared services with health checks
+// are checked.
+const serviceProbeInterval = 30 * time.Second
+
+// serviceProbeTimeout is how long a health check waits to connect.
+const serviceProbeTimeout = 5 * time.Second
+
+var metricServiceProbeFailures = clientmetric.NewCounter("ipnlocal_service_health_check_failures")
+
+// scheduleServiceProbesLocked starts checking the health of the declared
+// services that have health checks, if it's not already doing so.
+//
+// b.mu must be held.
+func (b *LocalBackend) scheduleServiceProbesLocked() {
+	if b.serviceProbeTimer != nil || b.shutdownCalled {
+		return
+	}
+	svcs, err := b.declaredServicesLocked()
+	if err != nil || !slices.ContainsFunc(svcs, func(s tailcfg.DeclaredService) bool { return s.HealthCheck }) {
+		return
+	}
+	b.serviceProbeTimer = b.clock.AfterFunc(0, b.probeServices)
+}
+
+// probeServices checks the health of the declared services that have
+// health checks, reports any changes to control, and schedules the next
+// checks while there are services to check.
+func (b *LocalBackend) probeServices() {
+	b.mu.Lock()
+	svcs, err := b.declaredServicesLocked()
+	b.mu.Unlock()
+	if err != nil {
+		b.logf("services: %v", err)
+	}
+
+	results := map[string]tailcfg.ServiceHealth{}
+	for _, svc := range svcs {
+		if !svc.HealthCheck {
+			continue
+		}
+		h := tailcfg.ServiceHealthOK
+		if err := b.probeService(svc.Port); err != nil {
+			h = tailcfg.ServiceHealthDown
+			metricServiceProbeFailures.Add(1)
+		}
+		results[svc.Name] = h
+	}
+
+	b.mu.Lock()
+	changed := !maps.Equal(b.serviceHealth, results)
+	for name, h := range results {
+		if old, ok := b.serviceHealth[name]; ok && old != h {
+			b.logf("services: %q is now %s", name, h)
+		}
+	}
+	b.serviceHealth = results
+	b.serviceProbeTimer = nil
+	if len(results) > 0 && !b.shutdownCalled {
+		b.serviceProbeTimer = b.clock.AfterFunc(serviceProbeInterval, b.probeServices)
+	}
+	b.mu.Unlock()
+
+	if changed {
+		b.doSetHostinfoFilterServices()
+	}
+}
+
+// probeService reports whether something accepts TCP connections on port,
+// on either the IPv4 or IPv6 loopback address.
+func (b *LocalBackend) probeService(port uint16) error {
+	ctx, cancel := context.WithTimeout(b.ctx, serviceProbeTimeout)
+	defer cancel()
+	var d net.Dialer
+	var firstErr error
+	for _, ip := range []netip.Addr{netip.AddrFrom4([4]byte{127, 0, 0, 1}), netip.IPv6Loopback()} {
+		c, err := d.DialContext(ctx, "tcp", netip.AddrPortFrom(ip, port).String())
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
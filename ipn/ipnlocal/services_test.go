@@ -0,0 +1,157 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func TestDeclareService(t *testing.T) {
+	b := newTestBackend(t)
+
+	for _, bad := range []tailcfg.DeclaredService{
+		{Name: "", Proto: tailcfg.TCP, Port: 80},
+		{Name: "Jellyfin", Proto: tailcfg.TCP, Port: 80},
+		{Name: "has space", Proto: tailcfg.TCP, Port: 80},
+		{Name: "web", Proto: "sctp", Port: 80},
+		{Name: "web", Proto: tailcfg.TCP},
+		{Name: "web", Proto: tailcfg.TCP, Port: 80, Health: "meh"},
+	} {
+		if err := b.DeclareService(bad); err == nil {
+			t.Errorf("DeclareService(%+v) succeeded; want error", bad)
+		}
+	}
+
+	jellyfin := tailcfg.DeclaredService{Name: "jellyfin", Proto: tailcfg.TCP, Port: 8096}
+	dns := tailcfg.DeclaredService{Name: "dns", Proto: tailcfg.UDP, Port: 53, Health: tailcfg.ServiceHealthOK}
+	for _, svc := range []tailcfg.DeclaredService{jellyfin, dns} {
+		if err := b.DeclareService(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jellyfin.Port = 8920
+	if err := b.DeclareService(jellyfin); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.DeclaredServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tailcfg.DeclaredService{dns, jellyfin}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DeclaredServices = %+v; want %+v", got, want)
+	}
+
+	if err := b.RemoveDeclaredService("dns"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RemoveDeclaredService("no-such-service"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.DeclaredServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tailcfg.DeclaredService{jellyfin}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after remove, DeclaredServices = %+v; want %+v", got, want)
+	}
+}
+
+func TestTailnetServices(t *testing.T) {
+	b := newTestBackend(t)
+	nas := tailcfg.DeclaredService{Name: "jellyfin", Proto: tailcfg.TCP, Port: 8096}
+	if err := b.DeclareService(nas); err != nil {
+		t.Fatal(err)
+	}
+	online := true
+	peerSvc := tailcfg.DeclaredService{Name: "grafana", Proto: tailcfg.TCP, Port: 3000, Health: tailcfg.ServiceHealthDown}
+	b.peers[154] = (&tailcfg.Node{
+		ID:        154,
+		StableID:  "stable154",
+		Name:      "monitor.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.150.151.154/32")},
+		Online:    &online,
+		Hostinfo: (&tailcfg.Hostinfo{
+			DeclaredServices: []tailcfg.DeclaredService{peerSvc},
+		}).View(),
+	}).View()
+
+	got, err := b.TailnetServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []apitype.TailnetService{
+		{
+			DeclaredService: peerSvc,
+			NodeID:          "stable154",
+			NodeName:        "monitor.example.ts.net",
+			Addrs:           []netip.Addr{netip.MustParseAddr("100.150.151.154")},
+			Online:          true,
+		},
+		{
+			DeclaredService: nas,
+			NodeName:        "example.ts.net",
+			Online:          true,
+			Self:            true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TailnetServices =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestServiceHealthChecks(t *testing.T) {
+	b := newTestBackend(t)
+	b.clock = tstest.NewClock(tstest.ClockOpts{})
+
+	if err := b.DeclareService(tailcfg.DeclaredService{Name: "dns", Proto: tailcfg.UDP, Port: 53, HealthCheck: true}); err == nil {
+		t.Error("declaring UDP service with health check succeeded; want error")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	if err := b.DeclareService(tailcfg.DeclaredService{Name: "web", Proto: tailcfg.TCP, Port: port, HealthCheck: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	health := func() tailcfg.ServiceHealth {
+		t.Helper()
+		svcs, err := b.DeclaredServices()
+		if err != nil || len(svcs) != 1 {
+			t.Fatalf("DeclaredServices = %v, %v; want one service", svcs, err)
+		}
+		return svcs[0].Health
+	}
+	if got := health(); got != tailcfg.ServiceHealthUnknown {
+		t.Errorf("before checking, health = %q; want unknown", got)
+	}
+	b.probeServices()
+	if got := health(); got != tailcfg.ServiceHealthOK {
+		t.Errorf("with listener, health = %q; want ok", got)
+	}
+	ln.Close()
+	b.probeServices()
+	if got := health(); got != tailcfg.ServiceHealthDown {
+		t.Errorf("without listener, health = %q; want down", got)
+	}
+
+	// The checked health isn't persisted as the declared health.
+	b.mu.Lock()
+	b.declaredServicesLoaded = false
+	svcs, err := b.declaredServicesLocked()
+	b.mu.Unlock()
+	if err != nil || len(svcs) != 1 || svcs[0].Health != tailcfg.ServiceHealthUnknown {
+		t.Errorf("stored services = %v, %v; want one with unknown health", svcs, err)
+	}
+}
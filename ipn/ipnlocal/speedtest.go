@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// MaxSpeedtestDuration is the longest that each direction of a peer
+// throughput test may run.
+const MaxSpeedtestDuration = 30 * time.Second
+
+// speedtestBlockSize is the size of the writes of a throughput test.
+const speedtestBlockSize = 32 << 10
+
+// speedtestUploadResponse is the PeerAPI response to a speedtest upload.
+type speedtestUploadResponse struct {
+	Bytes    int64
+	Duration time.Duration
+}
+
+// canSpeedtest reports whether h can run throughput tests against this node.
+func (h *peerAPIHandler) canSpeedtest() bool {
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		return false
+	}
+	return h.isSelf || h.peerHasCap(tailcfg.PeerCapabilitySpeedtest)
+}
+
+// handleServeSpeedtest serves the PeerAPI side of a throughput test. A GET
+// sends data for the requested duration; a POST receives data until the
+// request body ends and reports how much arrived.
+func (h *peerAPIHandler) handleServeSpeedtest(w http.ResponseWriter, r *http.Request) {
+	if !h.canSpeedtest() {
+		http.Error(w, "denied; no speedtest access", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		d, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil || d <= 0 || d > MaxSpeedtestDuration {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		h.logf("speedtest: sending to %v for %v", h.remoteAddr, d)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		buf := make([]byte, speedtestBlockSize)
+		for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	case "POST":
+		h.logf("speedtest: receiving from %v", h.remoteAddr)
+		// Bound how long a peer can keep the upload going.
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(MaxSpeedtestDuration + 10*time.Second))
+		start := time.Now()
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(speedtestUploadResponse{
+			Bytes:    n,
+			Duration: time.Since(start),
+		})
+	default:
+		http.Error(w, "only GET or POST allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// speedtestReader is the request body of a speedtest upload. It returns
+// zeros until its deadline.
+type speedtestReader struct {
+	deadline time.Time
+}
+
+func (r *speedtestReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+// Speedtest measures the throughput between this node and the peer with
+// Tailscale IP ip in both directions, each for duration d, over the peer's
+// PeerAPI. The peer must be owned by the same user or grant this node
+// tailcfg.PeerCapabilitySpeedtest.
+func (b *LocalBackend) Speedtest(ctx context.Context, ip netip.Addr, d time.Duration) ([]ipnstate.SpeedtestResult, error) {
+	if d <= 0 || d > MaxSpeedtestDuration {
+		return nil, fmt.Errorf("duration must be between 0 and %v", MaxSpeedtestDuration)
+	}
+	peer, base, err := b.pingPeerAPI(ctx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("reaching peer's PeerAPI: %w", err)
+	}
+	hc := &http.Client{Transport: b.Dialer().PeerAPITransport()}
+	url := base + "/v0/speedtest"
+
+	download := ipnstate.SpeedtestResult{Direction: "download"}
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"?duration="+d.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("download: %v", res.Status)
+	}
+	start := time.Now()
+	download.Bytes, err = io.Copy(io.Discard, res.Body)
+	download.Duration = time.Since(start)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	b.setSpeedtestPath(&download, peer)
+
+	upload := ipnstate.SpeedtestResult{Direction: "upload"}
+	req, err = http.NewRequestWithContext(ctx, "POST", url, &speedtestReader{deadline: time.Now().Add(d)})
+	if err != nil {
+		return nil, err
+	}
+	res, err = hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upload: %v", res.Status)
+	}
+	var ur speedtestUploadResponse
+	if err := json.NewDecoder(res.Body).Decode(&ur); err != nil {
+		return nil, fmt.Errorf("upload: %w", err)
+	}
+	if ur.Duration <= 0 {
+		return nil, errors.New("upload: peer reported no duration")
+	}
+	upload.Bytes, upload.Duration = ur.Bytes, ur.Duration
+	b.setSpeedtestPath(&upload, peer)
+
+	return []ipnstate.SpeedtestResult{download, upload}, nil
+}
+
+// setSpeedtestPath sets the path fields of r to how magicsock is currently
+// reaching peer.
+func (b *LocalBackend) setSpeedtestPath(r *ipnstate.SpeedtestResult, peer tailcfg.NodeView) {
+	sb := &ipnstate.StatusBuilder{WantPeers: true}
+	b.MagicConn().UpdateStatus(sb)
+	ps, ok := sb.Status().Peer[peer.Key()]
+	if !ok {
+		return
+	}
+	if ps.CurAddr != "" {
+		r.Endpoint = ps.CurAddr
+	} else {
+		r.DERPRegionCode = ps.Relay
+	}
+}
@@ -1,7 +1,7 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-//go:build linux || (darwin && !ios) || freebsd || openbsd
+//go:build (linux || (darwin && !ios) || freebsd || openbsd) && !ts_omit_ssh
 
 package ipnlocal
 
@@ -566,7 +566,7 @@ func TestStateMachine(t *testing.T) {
 	}
 
 	// undo the state hack above.
-	b.state = ipn.Starting
+	b.state.Transition(ipn.Starting, "test")
 
 	// User wants to logout.
 	store.awaitWrite()
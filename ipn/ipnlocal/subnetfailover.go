@@ -0,0 +1,340 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+const (
+	// subnetFailoverProbeInterval is how often the primary subnet routers
+	// with standbys are disco pinged while WireGuard is sending them
+	// traffic.
+	subnetFailoverProbeInterval = 2 * time.Second
+
+	// subnetFailoverActiveTimeout is how recently WireGuard must have sent
+	// a primary subnet router traffic for it to be probed every
+	// subnetFailoverProbeInterval.
+	subnetFailoverActiveTimeout = 30 * time.Second
+
+	// subnetFailoverMaxIdleInterval bounds how long an idle primary subnet
+	// router goes between probes. The interval doubles with each probe
+	// while the router is idle, starting from subnetFailoverProbeInterval.
+	// Routers aren't left unprobed entirely while idle, so that one that's
+	// down, whose routes' traffic has moved to a standby, can fail back.
+	subnetFailoverMaxIdleInterval = 2 * time.Minute
+
+	// subnetFailoverProbeTimeout is how long a disco ping to a primary
+	// subnet router has to get a response.
+	subnetFailoverProbeTimeout = 2 * time.Second
+
+	// subnetFailoverMaxMisses is how many consecutive disco pings a
+	// primary subnet router must miss before its routes fail over to a
+	// standby. A single response fails them back.
+	subnetFailoverMaxMisses = 3
+)
+
+// subnetFailover tracks the reachability of the primary subnet routers that
+// have standbys, as set by the control plane with
+// tailcfg.NodeAttrSubnetRouterStandby, so that their routes fail over to a
+// standby while they're down. The zero value is ready for use.
+type subnetFailover struct {
+	mu     sync.Mutex
+	probed map[key.NodePublic]*probedRouter // primary routers with standbys
+	down   set.Set[key.NodePublic]          // primary routers considered down
+	stop   context.CancelFunc               // stops the probe loop; nil if not running
+}
+
+type probedRouter struct {
+	ip     netip.Addr // Tailscale IP to ping
+	misses int        // consecutive disco pings without a response
+
+	idleInterval time.Duration // time between probes while idle; zero while active
+	nextIdle     time.Time     // when the router is next probed if idle
+}
+
+// subnetProber is what the probe loop of a subnetFailover uses to probe
+// the primary routers and report on them.
+type subnetProber struct {
+	clock tstime.Clock
+	logf  logger.Logf
+
+	// ping disco pings the router with the given Tailscale IP.
+	ping func(context.Context, netip.Addr) error
+
+	// lastSend returns when WireGuard last sent traffic to a router, or
+	// false if it never has.
+	lastSend func(key.NodePublic) (time.Time, bool)
+
+	// onChange is called when the set of routers considered down changes.
+	onChange func()
+}
+
+// subnetStandbys returns the node keys of the standby subnet routers in nm
+// by route, sorted by node ID.
+func subnetStandbys(nm *netmap.NetworkMap) map[netip.Prefix][]key.NodePublic {
+	if nm == nil {
+		return nil
+	}
+	var ret map[netip.Prefix][]key.NodePublic
+	for _, p := range nm.Peers {
+		if !p.HasCap(tailcfg.NodeAttrSubnetRouterStandby) {
+			continue
+		}
+		routes, err := tailcfg.UnmarshalNodeCapJSON[netip.Prefix](p.CapMap().AsMap(), tailcfg.NodeAttrSubnetRouterStandby)
+		if err != nil {
+			continue
+		}
+		for _, r := range routes {
+			if ret == nil {
+				ret = make(map[netip.Prefix][]key.NodePublic)
+			}
+			ret[r] = append(ret[r], p.Key())
+		}
+	}
+	return ret
+}
+
+// primariesWithStandbys returns the Tailscale IPs to probe of the primary
+// subnet routers in nm for routes in standbys, by node key.
+func primariesWithStandbys(nm *netmap.NetworkMap, standbys map[netip.Prefix][]key.NodePublic) map[key.NodePublic]netip.Addr {
+	if len(standbys) == 0 {
+		return nil
+	}
+	var ret map[key.NodePublic]netip.Addr
+	for _, p := range nm.Peers {
+		if p.Addresses().Len() == 0 {
+			continue
+		}
+		prs := p.PrimaryRoutes()
+		for i := range prs.Len() {
+			if _, ok := standbys[prs.At(i)]; ok {
+				if ret == nil {
+					ret = make(map[key.NodePublic]netip.Addr)
+				}
+				ret[p.Key()] = p.Addresses().At(0).Addr()
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// setProbed sets the primary routers to probe, starting or stopping the
+// probe loop as needed, and returns those considered down.
+func (f *subnetFailover) setProbed(ctx context.Context, p subnetProber, routers map[key.NodePublic]netip.Addr) set.Set[key.NodePublic] {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	probed := make(map[key.NodePublic]*probedRouter, len(routers))
+	for k, ip := range routers {
+		pr := &probedRouter{ip: ip}
+		if old, ok := f.probed[k]; ok && old.ip == ip {
+			*pr = *old
+		}
+		probed[k] = pr
+	}
+	f.probed = probed
+	for k := range f.down {
+		if _, ok := probed[k]; !ok {
+			f.down.Delete(k)
+		}
+	}
+
+	switch {
+	case len(probed) > 0 && f.stop == nil:
+		ctx, cancel := context.WithCancel(ctx)
+		f.stop = cancel
+		go f.probeLoop(ctx, p)
+	case len(probed) == 0 && f.stop != nil:
+		f.stop()
+		f.stop = nil
+	}
+	return f.down.Clone()
+}
+
+func (f *subnetFailover) probeLoop(ctx context.Context, p subnetProber) {
+	t, tc := p.clock.NewTicker(subnetFailoverProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tc:
+		}
+
+		ips := f.dueProbes(p.clock.Now(), p.lastSend)
+		if len(ips) == 0 {
+			continue
+		}
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			replied = make(map[key.NodePublic]bool, len(ips))
+		)
+		for k, ip := range ips {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(ctx, subnetFailoverProbeTimeout)
+				defer cancel()
+				err := p.ping(ctx, ip)
+				mu.Lock()
+				defer mu.Unlock()
+				replied[k] = err == nil
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		if f.noteProbes(p.logf, replied) {
+			p.onChange()
+		}
+	}
+}
+
+// dueProbes returns the Tailscale IPs of the probed routers to ping at
+// now, by node key. Routers that WireGuard sent traffic to within
+// subnetFailoverActiveTimeout are always due; idle ones are due with a
+// backoff of up to subnetFailoverMaxIdleInterval.
+func (f *subnetFailover) dueProbes(now time.Time, lastSend func(key.NodePublic) (time.Time, bool)) map[key.NodePublic]netip.Addr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ret map[key.NodePublic]netip.Addr
+	for k, pr := range f.probed {
+		if at, ok := lastSend(k); ok && now.Sub(at) < subnetFailoverActiveTimeout {
+			pr.idleInterval = 0
+			pr.nextIdle = time.Time{}
+		} else {
+			if now.Before(pr.nextIdle) {
+				continue
+			}
+			pr.idleInterval = min(max(2*pr.idleInterval, subnetFailoverProbeInterval), subnetFailoverMaxIdleInterval)
+			pr.nextIdle = now.Add(pr.idleInterval)
+		}
+		if ret == nil {
+			ret = make(map[key.NodePublic]netip.Addr)
+		}
+		ret[k] = pr.ip
+	}
+	return ret
+}
+
+// noteProbes records whether each probed router replied to its latest
+// probe, and reports whether that changed the set of routers considered
+// down.
+func (f *subnetFailover) noteProbes(logf logger.Logf, replied map[key.NodePublic]bool) (changed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, ok := range replied {
+		pr, found := f.probed[k]
+		if !found {
+			continue // no longer probed
+		}
+		if ok {
+			pr.misses = 0
+			if f.down.Contains(k) {
+				logf("subnet failover: primary router %v is back; failing back its routes", k.ShortString())
+				f.down.Delete(k)
+				changed = true
+			}
+			continue
+		}
+		pr.misses++
+		if pr.misses >= subnetFailoverMaxMisses && !f.down.Contains(k) {
+			logf("subnet failover: primary router %v missed %d disco pings; failing over its routes", k.ShortString(), pr.misses)
+			if f.down == nil {
+				f.down = make(set.Set[key.NodePublic])
+			}
+			f.down.Add(k)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// failOverRoutes moves the routes in standbys from the peers in cfg that
+// are down to the first of their standbys in cfg that isn't down, and
+// returns how many routes it moved.
+func failOverRoutes(cfg *wgcfg.Config, standbys map[netip.Prefix][]key.NodePublic, down set.Set[key.NodePublic]) (moved int) {
+	if len(down) == 0 || len(standbys) == 0 {
+		return 0
+	}
+	peerIdx := make(map[key.NodePublic]int, len(cfg.Peers))
+	for i, p := range cfg.Peers {
+		peerIdx[p.PublicKey] = i
+	}
+	for k := range down {
+		i, ok := peerIdx[k]
+		if !ok {
+			continue
+		}
+		p := &cfg.Peers[i]
+		p.AllowedIPs = slices.DeleteFunc(slices.Clone(p.AllowedIPs), func(r netip.Prefix) bool {
+			for _, sk := range standbys[r] {
+				j, ok := peerIdx[sk]
+				if !ok || sk == k || down.Contains(sk) {
+					continue
+				}
+				cfg.Peers[j].AllowedIPs = append(slices.Clip(cfg.Peers[j].AllowedIPs), r)
+				moved++
+				return true
+			}
+			return false
+		})
+	}
+	return moved
+}
+
+// applySubnetFailover updates the probing of the primary subnet routers in
+// nm that have standbys, and fails over in cfg the routes of those that are
+// down.
+func (b *LocalBackend) applySubnetFailover(cfg *wgcfg.Config, nm *netmap.NetworkMap) {
+	standbys := subnetStandbys(nm)
+	down := b.subnetFailover.setProbed(b.ctx, subnetProber{
+		clock:    b.clock,
+		logf:     b.logf,
+		ping:     b.pingSubnetRouter,
+		lastSend: b.lastWireGuardSend,
+		onChange: func() { go b.authReconfig() },
+	}, primariesWithStandbys(nm, standbys))
+	if n := failOverRoutes(cfg, standbys, down); n > 0 {
+		b.logf("[v1] subnet failover: %d routes failed over from %d primary routers to standbys", n, len(down))
+	}
+}
+
+// pingSubnetRouter disco pings the subnet router with Tailscale IP ip.
+func (b *LocalBackend) pingSubnetRouter(ctx context.Context, ip netip.Addr) error {
+	pr, err := b.Ping(ctx, ip, tailcfg.PingDisco, 0)
+	if err != nil {
+		return err
+	}
+	if pr.Err != "" {
+		return errors.New(pr.Err)
+	}
+	return nil
+}
+
+// lastWireGuardSend returns when WireGuard last sent traffic to the peer
+// with node key k.
+func (b *LocalBackend) lastWireGuardSend(k key.NodePublic) (time.Time, bool) {
+	ms, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return time.Time{}, false
+	}
+	return ms.LastWireGuardSendToNodeKey(k)
+}
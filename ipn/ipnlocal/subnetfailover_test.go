@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+func TestFailOverRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	primary := key.NewNode().Public()
+	standby1 := key.NewNode().Public()
+	standby2 := key.NewNode().Public()
+	subnet := pfx("10.0.0.0/24")
+	other := pfx("192.168.0.0/24")
+
+	makeCfg := func() *wgcfg.Config {
+		return &wgcfg.Config{Peers: []wgcfg.Peer{
+			{PublicKey: primary, AllowedIPs: []netip.Prefix{pfx("100.64.0.1/32"), subnet, other}},
+			{PublicKey: standby1, AllowedIPs: []netip.Prefix{pfx("100.64.0.2/32")}},
+			{PublicKey: standby2, AllowedIPs: []netip.Prefix{pfx("100.64.0.3/32")}},
+		}}
+	}
+	standbys := map[netip.Prefix][]key.NodePublic{
+		subnet: {standby1, standby2},
+	}
+
+	tests := []struct {
+		name  string
+		down  []key.NodePublic
+		want  [][]netip.Prefix // AllowedIPs by peer
+		moved int
+	}{
+		{
+			name: "none_down",
+			want: [][]netip.Prefix{
+				{pfx("100.64.0.1/32"), subnet, other},
+				{pfx("100.64.0.2/32")},
+				{pfx("100.64.0.3/32")},
+			},
+		},
+		{
+			name: "primary_down",
+			down: []key.NodePublic{primary},
+			want: [][]netip.Prefix{
+				{pfx("100.64.0.1/32"), other},
+				{pfx("100.64.0.2/32"), subnet},
+				{pfx("100.64.0.3/32")},
+			},
+			moved: 1,
+		},
+		{
+			name: "primary_and_first_standby_down",
+			down: []key.NodePublic{primary, standby1},
+			want: [][]netip.Prefix{
+				{pfx("100.64.0.1/32"), other},
+				{pfx("100.64.0.2/32")},
+				{pfx("100.64.0.3/32"), subnet},
+			},
+			moved: 1,
+		},
+		{
+			name: "all_down",
+			down: []key.NodePublic{primary, standby1, standby2},
+			want: [][]netip.Prefix{
+				{pfx("100.64.0.1/32"), subnet, other},
+				{pfx("100.64.0.2/32")},
+				{pfx("100.64.0.3/32")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := makeCfg()
+			if moved := failOverRoutes(cfg, standbys, set.SetOf(tt.down)); moved != tt.moved {
+				t.Errorf("moved %d routes; want %d", moved, tt.moved)
+			}
+			for i, p := range cfg.Peers {
+				if !slices.Equal(p.AllowedIPs, tt.want[i]) {
+					t.Errorf("peer %d AllowedIPs = %v; want %v", i, p.AllowedIPs, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSubnetFailoverNoteProbes(t *testing.T) {
+	k := key.NewNode().Public()
+	var f subnetFailover
+	f.probed = map[key.NodePublic]*probedRouter{
+		k: {ip: netip.MustParseAddr("100.64.0.1")},
+	}
+
+	for i := range subnetFailoverMaxMisses - 1 {
+		if f.noteProbes(logger.Discard, map[key.NodePublic]bool{k: false}) {
+			t.Fatalf("down after %d misses; want %d", i+1, subnetFailoverMaxMisses)
+		}
+	}
+	if !f.noteProbes(logger.Discard, map[key.NodePublic]bool{k: false}) {
+		t.Fatalf("not down after %d misses", subnetFailoverMaxMisses)
+	}
+	if !f.down.Contains(k) {
+		t.Fatal("router not considered down")
+	}
+	if f.noteProbes(logger.Discard, map[key.NodePublic]bool{k: false}) {
+		t.Error("changed on another miss while down")
+	}
+	if !f.noteProbes(logger.Discard, map[key.NodePublic]bool{k: true}) {
+		t.Fatal("not back up after a reply")
+	}
+	if f.down.Contains(k) {
+		t.Error("router still considered down")
+	}
+}
+
+func TestSubnetFailoverDueProbes(t *testing.T) {
+	active := key.NewNode().Public()
+	idle := key.NewNode().Public()
+	var f subnetFailover
+	f.probed = map[key.NodePublic]*probedRouter{
+		active: {ip: netip.MustParseAddr("100.64.0.1")},
+		idle:   {ip: netip.MustParseAddr("100.64.0.2")},
+	}
+
+	start := time.Unix(1700000000, 0)
+	lastSend := func(k key.NodePublic) (time.Time, bool) {
+		if k == active {
+			return start, true
+		}
+		return time.Time{}, false
+	}
+
+	// The idle router is due at 0s, 2s, 6s, 14s, ...: twice as long
+	// each time.
+	var idleProbes []time.Duration
+	for d := time.Duration(0); d < subnetFailoverActiveTimeout; d += subnetFailoverProbeInterval {
+		due := f.dueProbes(start.Add(d), lastSend)
+		if _, ok := due[active]; !ok {
+			t.Fatalf("active router not due at %v", d)
+		}
+		if _, ok := due[idle]; ok {
+			idleProbes = append(idleProbes, d)
+		}
+	}
+	want := []time.Duration{0, 2 * time.Second, 6 * time.Second, 14 * time.Second}
+	if !slices.Equal(idleProbes, want) {
+		t.Errorf("idle router due at %v; want %v", idleProbes, want)
+	}
+
+	// Once traffic to it stops, the active router backs off too.
+	if _, ok := f.dueProbes(start.Add(subnetFailoverActiveTimeout), lastSend)[active]; !ok {
+		t.Fatal("router not due right after going idle")
+	}
+	if _, ok := f.dueProbes(start.Add(subnetFailoverActiveTimeout+subnetFailoverProbeInterval/2), lastSend)[active]; ok {
+		t.Error("idle router due before its backoff")
+	}
+
+	// The idle backoff is bounded.
+	pr := f.probed[idle]
+	for range 20 {
+		f.dueProbes(pr.nextIdle, lastSend)
+	}
+	if pr.idleInterval != subnetFailoverMaxIdleInterval {
+		t.Errorf("idle interval = %v; want %v", pr.idleInterval, subnetFailoverMaxIdleInterval)
+	}
+}
@@ -8,9 +8,17 @@
 	"slices"
 	"strings"
 
+	"tailscale.com/feature"
 	"tailscale.com/ipn"
+	"tailscale.com/omit"
 )
 
+func init() {
+	if !omit.Taildrop {
+		feature.Register("taildrop")
+	}
+}
+
 // UpdateOutgoingFiles updates b.outgoingFiles to reflect the given updates and
 // sends an ipn.Notify with the full list of outgoingFiles.
 func (b *LocalBackend) UpdateOutgoingFiles(updates map[string]*ipn.OutgoingFile) {
@@ -21,12 +21,14 @@
 	"time"
 	"unicode"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnauth"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/localapi"
 	"tailscale.com/net/netmon"
+	"tailscale.com/tsd"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/util/mak"
@@ -47,6 +49,7 @@ type Server struct {
 	// connection (such as on Windows by default). Even if this
 	// is true, the ForceDaemon pref can override this.
 	resetOnZero bool
+	readiness   *tsd.Readiness // or nil; set by SetReadiness before Run
 
 	// mu guards the fields that follow.
 	// lock order: mu, then LocalBackend.mu
@@ -146,6 +149,36 @@ func (s *Server) serveServerStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// serveReadyz serves the /localapi/v0/readyz endpoint, which reports
+// whether tailscaled's subsystems have started, even before the
+// LocalBackend has. It responds 200 if the subsystem named by the
+// "subsystem" parameter (or every subsystem, if none) and those it depends
+// on are ready, and 503 otherwise, with an apitype.ReadyzResponse body.
+// With "wait=true", it waits until they're ready or the request is
+// canceled.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("subsystem")
+	var res *apitype.ReadyzResponse
+	var err error
+	if wait, _ := strconv.ParseBool(r.FormValue("wait")); wait {
+		res, err = s.readiness.Wait(r.Context(), name)
+		if err != nil && r.Context().Err() != nil {
+			err = nil // report where things got to
+		}
+	} else {
+		res, err = s.readiness.Report(name)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
 func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	if r.Method == "CONNECT" {
@@ -164,6 +197,10 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		s.serveServerStatus(w, r)
 		return
 	}
+	if r.Method == "GET" && r.URL.Path == "/localapi/v0/readyz" && s.readiness != nil {
+		s.serveReadyz(w, r)
+		return
+	}
 
 	lb, ok := s.awaitBackend(ctx)
 	if !ok {
@@ -468,6 +505,12 @@ func New(logf logger.Logf, logID logid.PublicID, netMon *netmon.Monitor) *Server
 	}
 }
 
+// SetReadiness sets the tracker of subsystem readiness that the server
+// reports on its readyz endpoint. It must be called before Run.
+func (s *Server) SetReadiness(r *tsd.Readiness) {
+	s.readiness = r
+}
+
 // SetLocalBackend sets the server's LocalBackend.
 //
 // It should only call be called after calling lb.Start.
@@ -58,6 +58,11 @@ type Status struct {
 	// problems are detected)
 	Health []string
 
+	// DisabledAddrFamilies lists the address families, "IPv4" or "IPv6",
+	// that tailscaled was configured not to use for peer-to-peer or
+	// DERP connections.
+	DisabledAddrFamilies []string `json:",omitempty"`
+
 	// This field is the legacy name of CurrentTailnet.MagicDNSSuffix.
 	//
 	// Deprecated: use CurrentTailnet.MagicDNSSuffix instead.
@@ -313,6 +318,10 @@ type PeerStatus struct {
 	KeyExpiry *time.Time `json:",omitempty"`
 
 	Location *tailcfg.Location `json:",omitempty"`
+
+	// DeclaredServices are the named services declared on the node, with
+	// their health as last reported.
+	DeclaredServices []tailcfg.DeclaredService `json:",omitempty"`
 }
 
 // HasCap reports whether ps has the given capability.
@@ -446,6 +455,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.SSH_HostKeys; v != nil {
 		e.SSH_HostKeys = v
 	}
+	if v := st.DeclaredServices; v != nil {
+		e.DeclaredServices = v
+	}
 	if v := st.Addrs; v != nil {
 		e.Addrs = v
 	}
@@ -708,6 +720,66 @@ func (pr *PingResult) ToPingResponse(pingType tailcfg.PingType) *tailcfg.PingRes
 	}
 }
 
+// SpeedtestResult is the result of one direction of a "tailscale debug
+// speedtest" throughput test between this node and a peer.
+type SpeedtestResult struct {
+	// Direction is "download" for data sent by the peer to this node, or
+	// "upload" for data sent by this node to the peer.
+	Direction string
+
+	Bytes    int64         // bytes received by the receiving node
+	Duration time.Duration // time the receiving node spent receiving them
+
+	// Endpoint is the peer's ip:port if direct UDP was used at the end
+	// of the transfer.
+	Endpoint string `json:",omitempty"`
+
+	// DERPRegionCode is the code of the DERP region that relayed the
+	// traffic, if no direct path was used at the end of the transfer.
+	DERPRegionCode string `json:",omitempty"`
+}
+
+// MbitsPerSecond returns the throughput of the transfer in megabits per
+// second.
+func (r SpeedtestResult) MbitsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / 1e6 / r.Duration.Seconds()
+}
+
+// PeerKeepalive is how this node keeps its connection to a peer alive.
+// The zero value means the defaults.
+type PeerKeepalive struct {
+	// HeartbeatInterval is how often disco heartbeats are sent to the
+	// peer's direct path while there's traffic to it. Zero means the
+	// default interval and negative means none.
+	HeartbeatInterval time.Duration `json:",omitempty"`
+
+	// WireGuardKeepalive is the interval of WireGuard persistent
+	// keepalives to the peer, in whole seconds. They're sent even when
+	// there's no traffic, so they keep NAT mappings open at the cost of
+	// waking up the node. Zero means none, which is the default.
+	WireGuardKeepalive time.Duration `json:",omitempty"`
+}
+
+// LowPower is the configuration and state of the engine's low power mode,
+// in which it stops its background network activity while the device is
+// on battery and nothing is using the tailnet.
+type LowPower struct {
+	// IdleAfter is how long the tunnel must be idle before background
+	// activity stops. Zero means low power mode is off.
+	IdleAfter time.Duration `json:",omitempty"`
+
+	// OnBattery is whether the device runs on battery, as last reported
+	// by the platform's client.
+	OnBattery bool `json:",omitempty"`
+
+	// Active is whether background activity is currently stopped. It's
+	// only set by the engine.
+	Active bool `json:",omitempty"`
+}
+
 // SortPeers sorts peers by either their DNS name, hostname, Tailscale IP,
 // or ultimately their current public key.
 func SortPeers(peers []*PeerStatus) {
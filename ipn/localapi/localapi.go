@@ -46,6 +46,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
+	"tailscale.com/omit"
 	"tailscale.com/tailcfg"
 	"tailscale.com/taildrop"
 	"tailscale.com/tka"
@@ -82,24 +83,33 @@
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
 	"bugreport":                   (*Handler).serveBugReport,
+	"bugreport-bundle":            (*Handler).serveBugReportBundle,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"check-udp-gro-forwarding":    (*Handler).serveCheckUDPGROForwarding,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"conn-audit":                  (*Handler).serveConnAudit,
+	"daemon-info":                 (*Handler).serveDaemonInfo,
 	"debug":                       (*Handler).serveDebug,
 	"debug-capture":               (*Handler).serveDebugCapture,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
 	"debug-dial-types":            (*Handler).serveDebugDialTypes,
+	"debug-dropped-flows":         (*Handler).serveDebugDroppedFlows,
 	"debug-log":                   (*Handler).serveDebugLog,
+	"debug-magicsock":             (*Handler).serveDebugMagicsock,
 	"debug-packet-filter-matches": (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
+	"debug-peer-chaos":            (*Handler).serveDebugPeerChaos,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"debug-speedtest":             (*Handler).serveDebugSpeedtest,
+	"debug-state-history":         (*Handler).serveDebugStateHistory,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"dial":                        (*Handler).serveDial,
 	"drive/fileserver-address":    (*Handler).serveDriveServerAddr,
 	"drive/shares":                (*Handler).serveShares,
+	"edit-advertised-routes":      (*Handler).serveEditAdvertisedRoutes,
 	"file-targets":                (*Handler).serveFileTargets,
 	"goroutines":                  (*Handler).serveGoroutines,
 	"handle-push-message":         (*Handler).serveHandlePushMessage,
@@ -107,7 +117,9 @@
 	"login-interactive":           (*Handler).serveLoginInteractive,
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
+	"low-power":                   (*Handler).serveLowPower,
 	"metrics":                     (*Handler).serveMetrics,
+	"peer-keepalive":              (*Handler).servePeerKeepalive,
 	"ping":                        (*Handler).servePing,
 	"pprof":                       (*Handler).servePprof,
 	"prefs":                       (*Handler).servePrefs,
@@ -115,6 +127,7 @@
 	"reload-config":               (*Handler).reloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
+	"services":                    (*Handler).serveServices,
 	"set-dns":                     (*Handler).serveSetDNS,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
 	"set-gui-visible":             (*Handler).serveSetGUIVisible,
@@ -344,14 +357,7 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 	}
 	defer h.b.TryFlushLogs() // kick off upload after bugreport's done logging
 
-	logMarker := func() string {
-		return fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, h.clock.Now().UTC().Format("20060102150405Z"), rands.HexString(16))
-	}
-	if envknob.NoLogsNoSupport() {
-		logMarker = func() string { return "BUG-NO-LOGS-NO-SUPPORT-this-node-has-had-its-logging-disabled" }
-	}
-
-	startMarker := logMarker()
+	startMarker := h.bugReportMarker()
 	h.logf("user bugreport: %s", startMarker)
 	if note := r.URL.Query().Get("note"); len(note) > 0 {
 		h.logf("user bugreport note: %s", note)
@@ -435,11 +441,52 @@ func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate another log marker and return it to the client.
-	endMarker := logMarker()
+	endMarker := h.bugReportMarker()
 	h.logf("user bugreport end: %s", endMarker)
 	fmt.Fprintln(w, endMarker)
 }
 
+// bugReportMarker returns a new bug report marker to log, which the user
+// shares with support to find the node's logs.
+func (h *Handler) bugReportMarker() string {
+	if envknob.NoLogsNoSupport() {
+		return "BUG-NO-LOGS-NO-SUPPORT-this-node-has-had-its-logging-disabled"
+	}
+	return fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, h.clock.Now().UTC().Format("20060102150405Z"), rands.HexString(16))
+}
+
+// serveBugReportBundle logs a bug report marker like serveBugReport and
+// responds with a diagnostics bundle (a gzipped tar archive, see
+// ipnlocal.LocalBackend.WriteBugReportBundle) containing it. The marker is
+// also returned in the Tailscale-Bugreport-Marker response header.
+func (h *Handler) serveBugReportBundle(w http.ResponseWriter, r *http.Request) {
+	// Require write access, as the bundle includes a goroutine dump; see
+	// serveGoroutines.
+	if !h.PermitWrite {
+		http.Error(w, "bugreport bundle access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer h.b.TryFlushLogs()
+
+	marker := h.bugReportMarker()
+	h.logf("user bugreport bundle: %s", marker)
+	if note := r.URL.Query().Get("note"); len(note) > 0 {
+		h.logf("user bugreport note: %s", note)
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Tailscale-Bugreport-Marker", marker)
+	if err := h.b.WriteBugReportBundle(r.Context(), w, marker); err != nil {
+		// The response has likely started already, so all we can do is
+		// log it; the client will see a truncated archive.
+		h.logf("user bugreport bundle: %v", err)
+	}
+}
+
 func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
 	h.serveWhoIsWithBackend(w, r, h.b)
 }
@@ -671,6 +718,72 @@ func (h *Handler) serveDebugPacketFilterRules(w http.ResponseWriter, r *http.Req
 	enc.Encode(nm.PacketFilterRules)
 }
 
+// serveDebugStateHistory returns the backend's most recent state
+// transitions, oldest first.
+func (h *Handler) serveDebugStateHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.StateHistory())
+}
+
+// serveDebugMagicsock returns a snapshot of magicsock's internal state: its
+// DERP connections and the paths it knows to each peer. The IP addresses of
+// peers' endpoints are redacted unless the "redact" parameter is "false",
+// which needs write access.
+func (h *Handler) serveDebugMagicsock(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	redact := r.FormValue("redact") != "false"
+	if !redact && !h.PermitWrite {
+		http.Error(w, "unredacted debug access denied", http.StatusForbidden)
+		return
+	}
+	st := h.b.MagicConn().DebugState(magicsock.DebugStateOptions{RedactAddrs: redact})
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(st)
+}
+
+// serveDaemonInfo returns how tailscaled was built and which
+// implementations it's using.
+func (h *Handler) serveDaemonInfo(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "daemon-info access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.DaemonInfo())
+}
+
+// serveDebugDroppedFlows returns the flows most recently dropped by the
+// packet filter, oldest first.
+func (h *Handler) serveDebugDroppedFlows(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(h.b.DroppedFlows())
+}
+
 func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -840,6 +953,22 @@ func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Request) {
+	if r.Method == httpm.GET {
+		// Report which components have debug logging on, and until when.
+		if !h.PermitRead {
+			http.Error(w, "debug access denied", http.StatusForbidden)
+			return
+		}
+		res := map[string]time.Time{}
+		for _, component := range ipn.DebuggableComponents {
+			if until := h.b.GetComponentDebugLogging(component); !until.IsZero() {
+				res[component] = until
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+		return
+	}
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
 		return
@@ -1260,6 +1389,249 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+// serveDebugSpeedtest runs a speedtest against the peer with Tailscale IP
+// "ip", for "duration" in each direction, and returns the results as JSON.
+func (h *Handler) serveDebugSpeedtest(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'duration' parameter", http.StatusBadRequest)
+		return
+	}
+	res, err := h.b.Speedtest(r.Context(), ip, d)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveDebugPeerChaos gets (GET) or sets (POST) the artificial latency and
+// packet loss applied to packets sent to the peer with Tailscale IP "ip".
+// When setting, the "latency" and "jitter" parameters are durations and
+// "drop" is the fraction of packets to drop; omitted ones are zero, so a
+// POST with none of them removes the degradation.
+func (h *Handler) serveDebugPeerChaos(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var pc magicsock.PeerChaos
+		for _, d := range []struct {
+			param string
+			dst   *time.Duration
+		}{
+			{"latency", &pc.Latency},
+			{"jitter", &pc.Jitter},
+		} {
+			if v := r.FormValue(d.param); v != "" {
+				if *d.dst, err = time.ParseDuration(v); err != nil {
+					http.Error(w, fmt.Sprintf("invalid %q: %v", d.param, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if v := r.FormValue("drop"); v != "" {
+			if pc.DropRate, err = strconv.ParseFloat(v, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid \"drop\": %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := h.b.DebugSetPeerChaos(ip, pc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	pc, err := h.b.DebugPeerChaos(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pc)
+}
+
+// servePeerKeepalive gets (GET) or sets (POST) how this node keeps its
+// connection to the peer with Tailscale IP "ip" alive. When setting, the
+// "heartbeat" and "wireguard" parameters are the intervals of disco
+// heartbeats and WireGuard persistent keepalives; omitted ones restore
+// the default.
+func (h *Handler) servePeerKeepalive(w http.ResponseWriter, r *http.Request) {
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		var ka ipnstate.PeerKeepalive
+		for _, d := range []struct {
+			param string
+			dst   *time.Duration
+		}{
+			{"heartbeat", &ka.HeartbeatInterval},
+			{"wireguard", &ka.WireGuardKeepalive},
+		} {
+			if v := r.FormValue(d.param); v != "" {
+				if *d.dst, err = time.ParseDuration(v); err != nil {
+					http.Error(w, fmt.Sprintf("invalid %q: %v", d.param, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if err := h.b.SetPeerKeepalive(ip, ka); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ka, err := h.b.PeerKeepalive(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ka)
+}
+
+// serveLowPower gets (GET) or sets (POST) the engine's low power mode.
+// When setting, "idle" is how long the tunnel must be idle before
+// background activity stops, or empty to turn the mode off, and
+// "battery" is whether the device is on battery.
+func (h *Handler) serveLowPower(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		var lp ipnstate.LowPower
+		if v := r.FormValue("idle"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid \"idle\": %v", err), http.StatusBadRequest)
+				return
+			}
+			lp.IdleAfter = d
+		}
+		if v := r.FormValue("battery"); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid \"battery\": %v", err), http.StatusBadRequest)
+				return
+			}
+			lp.OnBattery = on
+		}
+		if err := h.b.SetLowPower(lp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.LowPower())
+}
+
+// serveConnAudit returns the entries of the connection audit log, limited
+// by the optional "since" (RFC 3339) and "limit" parameters. A POST with
+// "enable" turns recording on or off instead, and returns no entries.
+func (h *Handler) serveConnAudit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		if v := r.FormValue("enable"); v != "" {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid \"enable\": %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := h.b.SetConnAuditEnabled(on); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apitype.ConnAuditResponse{Enabled: h.b.ConnAuditEnabled()})
+		return
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var since time.Time
+	if v := r.FormValue("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid \"since\": %v", err), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	var limit int
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid \"limit\": %v", err), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apitype.ConnAuditResponse{
+		Enabled: h.b.ConnAuditEnabled(),
+		Entries: h.b.ConnAudit(since, limit),
+	})
+}
+
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
 // handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
 // and returns true. Otherwise it returns false, in which case it doesn't write
@@ -1544,6 +1916,59 @@ func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(fts)
 }
 
+// serveServices lists the services declared in the tailnet on GET,
+// declares a service on this node on POST, and removes the service named by
+// the "name" query parameter from this node on DELETE.
+func (h *Handler) serveServices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "services access denied", http.StatusForbidden)
+			return
+		}
+		svcs, err := h.b.TailnetServices()
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		mak.NonNilSliceForJSON(&svcs)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(svcs)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "services access denied", http.StatusForbidden)
+			return
+		}
+		var svc tailcfg.DeclaredService
+		if err := json.NewDecoder(r.Body).Decode(&svc); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.DeclareService(svc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		if !h.PermitWrite {
+			http.Error(w, "services access denied", http.StatusForbidden)
+			return
+		}
+		name := r.FormValue("name")
+		if name == "" {
+			http.Error(w, "missing 'name' parameter", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.RemoveDeclaredService(name); err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // serveFilePut sends a file to another node.
 //
 // It's sometimes possible for clients to do this themselves, without
@@ -1573,6 +1998,11 @@ func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 	metricFilePutCalls.Add(1)
 
+	if omit.Taildrop {
+		http.Error(w, omit.Err.Error(), http.StatusNotImplemented)
+		return
+	}
+
 	if !h.PermitWrite {
 		http.Error(w, "file access denied", http.StatusForbidden)
 		return
@@ -2162,6 +2592,51 @@ func (h *Handler) serveSetUseExitNodeEnabled(w http.ResponseWriter, r *http.Requ
 	e.Encode(prefs)
 }
 
+// serveEditAdvertisedRoutes adds and removes routes advertised by the node,
+// given as the repeated "add" and "remove" query parameters, and returns the
+// resulting prefs.
+func (h *Handler) serveEditAdvertisedRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	parse := func(param string) ([]netip.Prefix, error) {
+		var ret []netip.Prefix
+		for _, s := range r.URL.Query()[param] {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %q parameter: %w", param, err)
+			}
+			ret = append(ret, p)
+		}
+		return ret, nil
+	}
+	add, err := parse("add")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	remove, err := parse("remove")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefs, err := h.b.EditAdvertisedRoutes(add, remove)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(prefs)
+}
+
 func (h *Handler) serveTKASign(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock sign access denied", http.StatusForbidden)
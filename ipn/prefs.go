@@ -10,6 +10,7 @@
 	"fmt"
 	"log"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -23,6 +24,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -69,6 +71,16 @@ type Prefs struct {
 	// calling Backend.Start().
 	ControlURL string
 
+	// ControlURLFallbacks are the URLs of other instances of the same
+	// control server as ControlURL, in order of priority, for self-hosted
+	// control servers with redundancy. They must share ControlURL's keys.
+	// The client fails over to the first of them that's reachable when
+	// ControlURL isn't, and back as soon as a higher-priority one is
+	// reachable again.
+	//
+	// Like ControlURL, it only takes effect in Backend.Start().
+	ControlURLFallbacks []string `json:",omitempty"`
+
 	// RouteAll specifies whether to accept subnets advertised by
 	// other nodes on the Tailscale network. Note that this does not
 	// include default routes (0.0.0.0/0 and ::/0), those are
@@ -230,6 +242,65 @@ type Prefs struct {
 	// posture checks.
 	PostureChecking bool
 
+	// NoAutoKeyRenewal disables the automatic renewal of the node key
+	// shortly before it expires. By default, the node generates a new node
+	// key and re-registers it with the control server without user
+	// interaction, if the control server allows it.
+	NoAutoKeyRenewal bool `json:",omitempty"`
+
+	// Ephemeral specifies whether the node registers with the control
+	// server as an ephemeral node, which control removes from the tailnet
+	// once it goes offline. An ephemeral node's profile (including its node
+	// key) isn't written to the state store, and the node logs out of the
+	// control server when tailscaled shuts down. This is meant for
+	// containers and CI runners.
+	//
+	// It only takes effect when the node registers, so it's meant to be
+	// set before logging in, along with an auth key.
+	Ephemeral bool `json:",omitempty"`
+
+	// DERPPlaintextFallback specifies whether to connect to a DERP server
+	// over unencrypted HTTP on port 80 when TLS connections to it fail, as
+	// happens on networks that block or intercept TLS to unknown hosts.
+	// DERP's own encryption still protects the connection: the server's
+	// public key learned over an earlier TLS connection is pinned, and
+	// the traffic relayed is end-to-end encrypted by WireGuard regardless.
+	// While a plaintext connection is in use, a health warning is shown.
+	DERPPlaintextFallback bool `json:",omitempty"`
+
+	// ValidateDNSSEC specifies whether DNS responses from upstream
+	// resolvers, for queries that go through the Tailscale DNS resolver
+	// at 100.100.100.100, are validated with DNSSEC. Responses that fail
+	// validation are replaced with SERVFAIL and raise a health warning.
+	// It only has an effect with CorpDNS set.
+	ValidateDNSSEC bool `json:",omitempty"`
+
+	// AdvertiseDNSAliases are additional MagicDNS names, as single DNS
+	// labels, that this node asks to be reachable at across the tailnet,
+	// such as "jellyfin" for jellyfin.<tailnet>.ts.net. Peers only honor
+	// them if the control server grants the node the
+	// tailcfg.NodeAttrDNSAliases capability, and never when they shadow a
+	// node's own name or are claimed by more than one node.
+	AdvertiseDNSAliases []string `json:",omitempty"`
+
+	// BlockedPeers are peers that this node refuses to talk to, whatever
+	// the tailnet's policy allows. Each entry is a node key
+	// ("nodekey:...") or a name, matched case-insensitively against a
+	// peer's MagicDNS name, with or without the tailnet suffix, and its
+	// hostname. Packets from and to blocked peers are dropped, and no
+	// paths to them are discovered.
+	//
+	// It's a local opt-out only; blocked peers still see this node in
+	// their netmaps.
+	BlockedPeers []string `json:",omitempty"`
+
+	// ListenPort is the UDP port to listen on for WireGuard and
+	// peer-to-peer traffic, overriding tailscaled's --port flag if
+	// non-zero. While the port can't be bound, such as when another
+	// process has it, an ephemeral port is used instead and the port is
+	// retried periodically.
+	ListenPort uint16 `json:",omitempty"`
+
 	// NetfilterKind specifies what netfilter implementation to use.
 	//
 	// Linux-only.
@@ -302,6 +373,7 @@ type MaskedPrefs struct {
 	Prefs
 
 	ControlURLSet             bool                `json:",omitempty"`
+	ControlURLFallbacksSet    bool                `json:",omitempty"`
 	RouteAllSet               bool                `json:",omitempty"`
 	ExitNodeIDSet             bool                `json:",omitempty"`
 	ExitNodeIPSet             bool                `json:",omitempty"`
@@ -327,6 +399,13 @@ type MaskedPrefs struct {
 	AutoUpdateSet             AutoUpdatePrefsMask `json:",omitempty"`
 	AppConnectorSet           bool                `json:",omitempty"`
 	PostureCheckingSet        bool                `json:",omitempty"`
+	NoAutoKeyRenewalSet       bool                `json:",omitempty"`
+	EphemeralSet              bool                `json:",omitempty"`
+	DERPPlaintextFallbackSet  bool                `json:",omitempty"`
+	ValidateDNSSECSet         bool                `json:",omitempty"`
+	AdvertiseDNSAliasesSet    bool                `json:",omitempty"`
+	BlockedPeersSet           bool                `json:",omitempty"`
+	ListenPortSet             bool                `json:",omitempty"`
 	NetfilterKindSet          bool                `json:",omitempty"`
 	DriveSharesSet            bool                `json:",omitempty"`
 }
@@ -533,6 +612,9 @@ func (p *Prefs) pretty(goos string) string {
 	if p.ControlURL != "" && p.ControlURL != DefaultControlURL {
 		fmt.Fprintf(&sb, "url=%q ", p.ControlURL)
 	}
+	if len(p.ControlURLFallbacks) > 0 {
+		fmt.Fprintf(&sb, "fallbacks=%q ", p.ControlURLFallbacks)
+	}
 	if p.Hostname != "" {
 		fmt.Fprintf(&sb, "host=%q ", p.Hostname)
 	}
@@ -542,6 +624,27 @@ func (p *Prefs) pretty(goos string) string {
 	if p.NetfilterKind != "" {
 		fmt.Fprintf(&sb, "netfilterKind=%s ", p.NetfilterKind)
 	}
+	if p.NoAutoKeyRenewal {
+		sb.WriteString("autoKeyRenewal=false ")
+	}
+	if p.Ephemeral {
+		sb.WriteString("ephemeral=true ")
+	}
+	if p.DERPPlaintextFallback {
+		sb.WriteString("derpPlaintextFallback=true ")
+	}
+	if p.ValidateDNSSEC {
+		sb.WriteString("dnssec=true ")
+	}
+	if len(p.AdvertiseDNSAliases) > 0 {
+		fmt.Fprintf(&sb, "dnsAliases=%s ", strings.Join(p.AdvertiseDNSAliases, ","))
+	}
+	if len(p.BlockedPeers) > 0 {
+		fmt.Fprintf(&sb, "blockedPeers=%s ", strings.Join(p.BlockedPeers, ","))
+	}
+	if p.ListenPort != 0 {
+		fmt.Fprintf(&sb, "listenPort=%d ", p.ListenPort)
+	}
 	sb.WriteString(p.AutoUpdate.Pretty())
 	sb.WriteString(p.AppConnector.Pretty())
 	if p.Persist != nil {
@@ -557,8 +660,13 @@ func (p PrefsView) ToBytes() []byte {
 	return p.ж.ToBytes()
 }
 
+// ToBytes returns p serialized as JSON for saving, along with
+// CurrentPrefsVersion. The result can be read back with PrefsFromBytes.
 func (p *Prefs) ToBytes() []byte {
-	data, err := json.MarshalIndent(p, "", "\t")
+	data, err := json.MarshalIndent(struct {
+		*Prefs
+		PrefsVersion int
+	}{p, CurrentPrefsVersion}, "", "\t")
 	if err != nil {
 		log.Fatalf("Prefs marshal: %v\n", err)
 	}
@@ -578,6 +686,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 	}
 
 	return p.ControlURL == p2.ControlURL &&
+		compareStrings(p.ControlURLFallbacks, p2.ControlURLFallbacks) &&
 		p.RouteAll == p2.RouteAll &&
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
@@ -603,6 +712,13 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.AutoUpdate.Equals(p2.AutoUpdate) &&
 		p.AppConnector == p2.AppConnector &&
 		p.PostureChecking == p2.PostureChecking &&
+		p.NoAutoKeyRenewal == p2.NoAutoKeyRenewal &&
+		p.Ephemeral == p2.Ephemeral &&
+		p.DERPPlaintextFallback == p2.DERPPlaintextFallback &&
+		p.ValidateDNSSEC == p2.ValidateDNSSEC &&
+		compareStrings(p.AdvertiseDNSAliases, p2.AdvertiseDNSAliases) &&
+		compareStrings(p.BlockedPeers, p2.BlockedPeers) &&
+		p.ListenPort == p2.ListenPort &&
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind
 }
@@ -636,6 +752,43 @@ func compareIPNets(a, b []netip.Prefix) bool {
 	return true
 }
 
+// CheckBlockedPeer reports whether s is a valid entry of
+// Prefs.BlockedPeers: a node key in its "nodekey:" text form, or a
+// non-empty name.
+func CheckBlockedPeer(s string) error {
+	if strings.HasPrefix(s, "nodekey:") {
+		var k key.NodePublic
+		if err := k.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("invalid node key: %w", err)
+		}
+		return nil
+	}
+	if strings.TrimSpace(s) == "" {
+		return errors.New("empty peer name")
+	}
+	return nil
+}
+
+// CheckControlURL reports whether s is a valid control server URL, such as
+// an entry of Prefs.ControlURLFallbacks: an http or https URL with a host
+// and no path beyond "/".
+func CheckControlURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", s)
+	}
+	if strings.Trim(u.Path, "/") != "" {
+		return fmt.Errorf("%q has a path; want only a scheme and host", s)
+	}
+	return nil
+}
+
 func compareStrings(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -875,12 +1028,17 @@ func (p *Prefs) ShouldWebClientBeRunning() bool {
 }
 
 // PrefsFromBytes deserializes Prefs from a JSON blob b into base. Values in
-// base are preserved, unless they are populated in the JSON blob.
+// base are preserved, unless they are populated in the JSON blob. Prefs saved
+// in an older format are migrated to CurrentPrefsVersion first; see
+// InspectSavedPrefs for prefs saved in a newer one.
 func PrefsFromBytes(b []byte, base *Prefs) error {
 	if len(b) == 0 {
 		return nil
 	}
-
+	b, err := migratePrefsJSON(b)
+	if err != nil {
+		return err
+	}
 	return json.Unmarshal(b, base)
 }
 
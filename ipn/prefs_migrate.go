@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// CurrentPrefsVersion is the version of the format of the saved prefs that
+// Prefs.ToBytes writes. Prefs saved before the format was versioned,
+// including legacy relaynode config files (see LoadPrefsWindows), are
+// version 0.
+//
+// When a change to Prefs requires rewriting saved prefs, such as renaming a
+// field or changing what its zero value means, increment it and add a
+// migration to prefsMigrations.
+const CurrentPrefsVersion = 1
+
+// prefsVersionKey is the key of the version in saved prefs.
+const prefsVersionKey = "PrefsVersion"
+
+// A prefsMigration migrates saved prefs, as the JSON object obj, from one
+// version to the next.
+type prefsMigration func(obj map[string]json.RawMessage) error
+
+// prefsMigrations are the migrations of saved prefs from each version to
+// the next: prefsMigrations[i] migrates from version i to version i+1.
+var prefsMigrations = [CurrentPrefsVersion]prefsMigration{
+	0: migratePrefsLegacyControlURL,
+}
+
+// migratePrefsLegacyControlURL clears a ControlURL of
+// https://login.tailscale.com, which was once the default, so that the
+// current default, DefaultControlURL, is used instead.
+func migratePrefsLegacyControlURL(obj map[string]json.RawMessage) error {
+	var u string
+	if raw, ok := obj["ControlURL"]; ok {
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return err
+		}
+	}
+	if u != DefaultControlURL && IsLoginServerSynonym(u) {
+		obj["ControlURL"] = json.RawMessage(`""`)
+	}
+	return nil
+}
+
+// migratePrefsJSON returns the saved prefs b migrated to
+// CurrentPrefsVersion, without the version, ready to be unmarshaled into a
+// Prefs. Prefs saved by a newer version are returned as is.
+func migratePrefsJSON(b []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	v, err := savedPrefsVersion(obj)
+	if err != nil {
+		return nil, err
+	}
+	if v >= CurrentPrefsVersion {
+		return b, nil
+	}
+	for ; v < CurrentPrefsVersion; v++ {
+		if err := prefsMigrations[v](obj); err != nil {
+			return nil, fmt.Errorf("migrating prefs from version %d: %w", v, err)
+		}
+	}
+	delete(obj, prefsVersionKey)
+	return json.Marshal(obj)
+}
+
+func savedPrefsVersion(obj map[string]json.RawMessage) (int, error) {
+	raw, ok := obj[prefsVersionKey]
+	if !ok {
+		return 0, nil
+	}
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s %s", prefsVersionKey, raw)
+	}
+	return v, nil
+}
+
+// InspectSavedPrefs returns the version of the format of the saved prefs b,
+// as written by Prefs.ToBytes, and the names of its fields that this
+// version of Prefs doesn't have.
+//
+// Unknown fields are expected in prefs saved by a newer version, such as
+// after a downgrade. They're ignored by PrefsFromBytes, and lost once the
+// prefs are saved again, so callers should warn about them.
+func InspectSavedPrefs(b []byte) (version int, unknownFields []string, err error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return 0, nil, err
+	}
+	version, err = savedPrefsVersion(obj)
+	if err != nil {
+		return 0, nil, err
+	}
+	fields := jsonFields(reflect.TypeFor[Prefs]())
+	for k := range obj {
+		if _, ok := fields[k]; !ok && k != prefsVersionKey {
+			unknownFields = append(unknownFields, k)
+		}
+	}
+	slices.Sort(unknownFields)
+	return version, unknownFields, nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefsFromBytesMigrates(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string // ControlURL
+		wantErr bool
+	}{
+		{
+			name: "unversioned_legacy_control_url",
+			in:   `{"ControlURL": "https://login.tailscale.com", "Hostname": "foo"}`,
+			want: "",
+		},
+		{
+			name: "unversioned_custom_control_url",
+			in:   `{"ControlURL": "https://example.com"}`,
+			want: "https://example.com",
+		},
+		{
+			name: "current_legacy_control_url",
+			in:   `{"ControlURL": "https://login.tailscale.com", "PrefsVersion": 1}`,
+			want: "https://login.tailscale.com",
+		},
+		{
+			name: "newer",
+			in:   `{"ControlURL": "https://login.tailscale.com", "PrefsVersion": 100, "NewThing": true}`,
+			want: "https://login.tailscale.com",
+		},
+		{
+			name:    "bad_version",
+			in:      `{"PrefsVersion": "one"}`,
+			wantErr: true,
+		},
+		{
+			name:    "negative_version",
+			in:      `{"PrefsVersion": -1}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrefs()
+			err := PrefsFromBytes([]byte(tt.in), p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if p.ControlURL != tt.want {
+				t.Errorf("ControlURL = %q; want %q", p.ControlURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefsToBytesVersion(t *testing.T) {
+	p := NewPrefs()
+	p.ControlURL = "https://login.tailscale.com"
+	p.Hostname = "foo"
+	b := p.ToBytes()
+
+	v, unknown, err := InspectSavedPrefs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != CurrentPrefsVersion {
+		t.Errorf("version = %d; want %d", v, CurrentPrefsVersion)
+	}
+	if len(unknown) > 0 {
+		t.Errorf("unknown fields = %q; want none", unknown)
+	}
+
+	// An explicitly set ControlURL saved in the current format must not be
+	// migrated away.
+	p2 := new(Prefs)
+	if err := PrefsFromBytes(b, p2); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Equals(p2) {
+		t.Errorf("round trip mismatch:\n got: %v\nwant: %v", p2.Pretty(), p.Pretty())
+	}
+}
+
+func TestInspectSavedPrefs(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		wantVersion int
+		wantUnknown []string
+		wantErr     bool
+	}{
+		{
+			name: "unversioned",
+			in:   `{"Hostname": "foo"}`,
+		},
+		{
+			name:        "newer",
+			in:          `{"PrefsVersion": 7, "Hostname": "foo", "Zed": 1, "Alpha": {}}`,
+			wantVersion: 7,
+			wantUnknown: []string{"Alpha", "Zed"},
+		},
+		{
+			name:    "not_object",
+			in:      `[1]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, unknown, err := InspectSavedPrefs([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error %v", err, tt.wantErr)
+			}
+			if v != tt.wantVersion {
+				t.Errorf("version = %d; want %d", v, tt.wantVersion)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) {
+				t.Errorf("unknown fields = %q; want %q", unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/preftype"
+)
+
+// readOnlyPrefs are the Prefs fields that have a MaskedPrefs Set field but
+// can't be set from a prefs document (see ParsePrefsJSON), mapped to the
+// reason why.
+var readOnlyPrefs = map[string]string{
+	"ControlURL":            "can only be changed with 'tailscale login --login-server'",
+	"InternalExitNodePrior": "is managed internally and can't be set",
+	"LoggedOut":             "can only be changed with 'tailscale login' or 'tailscale logout'",
+	"Egg":                   "is a debug setting and can't be set",
+}
+
+// PrefsFieldError describes why a single field of a prefs document was
+// rejected.
+type PrefsFieldError struct {
+	Field  string // JSON path of the field, such as "AutoUpdate.Apply" or "AdvertiseRoutes[1]"
+	Reason string // human-readable reason
+}
+
+func (e PrefsFieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// PrefsValidationError is the error returned by ParsePrefsJSON when a prefs
+// document has one or more invalid fields. It lists all of them, not just
+// the first.
+type PrefsValidationError struct {
+	Errors []PrefsFieldError
+}
+
+func (e *PrefsValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "invalid prefs: %d error(s):", len(e.Errors))
+	for _, fe := range e.Errors {
+		sb.WriteString("\n\t")
+		sb.WriteString(fe.Error())
+	}
+	return sb.String()
+}
+
+// ParsePrefsJSON parses a prefs document: a JSON object whose keys are a
+// subset of the fields of Prefs, in the same format as the JSON encoding of
+// Prefs (as printed by "tailscale debug prefs"). Only the fields present in
+// the document are marked as set in the returned MaskedPrefs.
+//
+// If any field is unknown, read-only or has an invalid value, it returns a
+// *PrefsValidationError describing every such field. The schema of accepted
+// documents is returned by PrefsSchema.
+func ParsePrefsJSON(b []byte) (*MaskedPrefs, error) {
+	mp := new(MaskedPrefs)
+	var errs []PrefsFieldError
+	decodePrefsObject(b, "", reflect.ValueOf(&mp.Prefs).Elem(), reflect.ValueOf(mp).Elem(), &errs)
+	if len(errs) == 0 {
+		errs = validateMaskedPrefs(mp)
+	}
+	if len(errs) > 0 {
+		return nil, &PrefsValidationError{Errors: errs}
+	}
+	return mp, nil
+}
+
+// decodePrefsObject decodes the JSON object b into the struct dst, setting
+// the corresponding bool fields of the mask struct for each field found.
+// path is the JSON path of b, used to name fields in errors.
+func decodePrefsObject(b []byte, path string, dst, mask reflect.Value, errs *[]PrefsFieldError) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil || obj == nil {
+		field := path
+		if field == "" {
+			field = "(document)"
+		}
+		*errs = append(*errs, PrefsFieldError{Field: field, Reason: "must be a JSON object"})
+		return
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	fields := jsonFields(dst.Type())
+	for _, k := range keys {
+		fieldPath := k
+		if path != "" {
+			fieldPath = path + "." + k
+		}
+		sf, ok := fields[k]
+		if !ok {
+			*errs = append(*errs, PrefsFieldError{Field: fieldPath, Reason: "unknown field"})
+			continue
+		}
+		m := mask.FieldByName(sf.Name + "Set")
+		if !m.IsValid() {
+			*errs = append(*errs, PrefsFieldError{Field: fieldPath, Reason: "is not a settable preference"})
+			continue
+		}
+		if path == "" {
+			if reason, ok := readOnlyPrefs[sf.Name]; ok {
+				*errs = append(*errs, PrefsFieldError{Field: fieldPath, Reason: reason})
+				continue
+			}
+		}
+		if m.Kind() == reflect.Struct {
+			decodePrefsObject(obj[k], fieldPath, dst.FieldByIndex(sf.Index), m, errs)
+			continue
+		}
+		v := reflect.New(sf.Type)
+		dec := json.NewDecoder(bytes.NewReader(obj[k]))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v.Interface()); err != nil {
+			*errs = append(*errs, PrefsFieldError{Field: fieldPath, Reason: decodeErrorReason(sf.Type, err)})
+			continue
+		}
+		dst.FieldByIndex(sf.Index).Set(v.Elem())
+		m.SetBool(true)
+	}
+}
+
+// decodeErrorReason returns the PrefsFieldError reason for an error from
+// decoding a JSON value into a value of type t.
+func decodeErrorReason(t reflect.Type, err error) string {
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		return fmt.Sprintf("invalid value: want %s, got %s", schemaTypeName(t), te.Value)
+	}
+	return "invalid value: " + strings.TrimPrefix(err.Error(), "json: ")
+}
+
+// validateMaskedPrefs checks the values of the set fields of mp that decode
+// fine as JSON but aren't valid preferences.
+func validateMaskedPrefs(mp *MaskedPrefs) (errs []PrefsFieldError) {
+	add := func(field, format string, args ...any) {
+		errs = append(errs, PrefsFieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+	if mp.AdvertiseRoutesSet {
+		var v4Default, v6Default bool
+		for i, p := range mp.AdvertiseRoutes {
+			switch {
+			case !p.IsValid():
+				add(fmt.Sprintf("AdvertiseRoutes[%d]", i), "invalid route")
+			case p != p.Masked():
+				add(fmt.Sprintf("AdvertiseRoutes[%d]", i), "%v has non-address bits set; expected %v", p, p.Masked())
+			case p == netip.PrefixFrom(netip.IPv4Unspecified(), 0):
+				v4Default = true
+			case p == netip.PrefixFrom(netip.IPv6Unspecified(), 0):
+				v6Default = true
+			}
+		}
+		if v4Default != v6Default {
+			add("AdvertiseRoutes", "to advertise an exit node, both 0.0.0.0/0 and ::/0 must be included")
+		}
+	}
+	if mp.AdvertiseTagsSet {
+		for i, tag := range mp.AdvertiseTags {
+			if err := tailcfg.CheckTag(tag); err != nil {
+				add(fmt.Sprintf("AdvertiseTags[%d]", i), "%v", err)
+			}
+		}
+	}
+	if mp.AdvertiseDNSAliasesSet {
+		for i, alias := range mp.AdvertiseDNSAliases {
+			if err := tailcfg.CheckDNSAlias(alias); err != nil {
+				add(fmt.Sprintf("AdvertiseDNSAliases[%d]", i), "%v", err)
+			}
+		}
+	}
+	if mp.ControlURLFallbacksSet {
+		for i, u := range mp.ControlURLFallbacks {
+			if err := CheckControlURL(u); err != nil {
+				add(fmt.Sprintf("ControlURLFallbacks[%d]", i), "%v", err)
+			}
+		}
+	}
+	if mp.BlockedPeersSet {
+		for i, peer := range mp.BlockedPeers {
+			if err := CheckBlockedPeer(peer); err != nil {
+				add(fmt.Sprintf("BlockedPeers[%d]", i), "%v", err)
+			}
+		}
+	}
+	if mp.ExitNodeIDSet && mp.ExitNodeIPSet && mp.ExitNodeID != "" && mp.ExitNodeIP.IsValid() {
+		add("ExitNodeIP", "can't be set together with ExitNodeID")
+	}
+	if mp.NetfilterModeSet {
+		switch mp.NetfilterMode {
+		case preftype.NetfilterOff, preftype.NetfilterNoDivert, preftype.NetfilterOn:
+		default:
+			add("NetfilterMode", "unknown netfilter mode %d", mp.NetfilterMode)
+		}
+	}
+	if mp.AutoUpdateSet.CheckSet && mp.AutoUpdateSet.ApplySet && !mp.AutoUpdate.Check && mp.AutoUpdate.Apply.EqualBool(true) {
+		add("AutoUpdate.Apply", "requires AutoUpdate.Check to be true")
+	}
+	return errs
+}
+
+// PrefsSchema returns a JSON Schema (draft 2020-12) describing the prefs
+// documents accepted by ParsePrefsJSON. It's meant to be encoded as JSON
+// and handed to configuration management systems.
+func PrefsSchema() map[string]any {
+	props := map[string]any{}
+	mt := reflect.TypeFor[MaskedPrefs]()
+	for name, sf := range jsonFields(reflect.TypeFor[Prefs]()) {
+		if _, ok := mt.FieldByName(sf.Name + "Set"); !ok {
+			continue
+		}
+		if _, ok := readOnlyPrefs[sf.Name]; ok {
+			continue
+		}
+		props[name] = typeSchema(sf.Type)
+	}
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "Tailscale preferences",
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[opt.Bool]():
+		return map[string]any{"type": []string{"boolean", "null"}}
+	case reflect.TypeFor[preftype.NetfilterMode]():
+		return map[string]any{
+			"type":        "integer",
+			"enum":        []preftype.NetfilterMode{preftype.NetfilterOff, preftype.NetfilterNoDivert, preftype.NetfilterOn},
+			"description": "0 (off), 1 (nodivert) or 2 (on)",
+		}
+	case reflect.TypeFor[netip.Addr]():
+		return map[string]any{"type": "string", "description": "IP address"}
+	case reflect.TypeFor[netip.Prefix]():
+		return map[string]any{"type": "string", "description": "CIDR prefix"}
+	}
+	if t.Implements(textMarshalerType) {
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": []string{"array", "null"}, "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		for name, sf := range jsonFields(t) {
+			props[name] = typeSchema(sf.Type)
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	panic(fmt.Sprintf("unsupported prefs type %v", t))
+}
+
+// schemaTypeName returns the JSON type name of t used in error messages.
+func schemaTypeName(t reflect.Type) string {
+	switch typ := typeSchema(t)["type"].(type) {
+	case string:
+		return typ
+	case []string:
+		return strings.Join(typ, " or ")
+	}
+	return "?"
+}
+
+// jsonFields returns the exported fields of the struct type t keyed by
+// their name in JSON.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	m := map[string]reflect.StructField{}
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		m[name] = sf
+	}
+	return m
+}
@@ -37,6 +37,7 @@ func TestPrefsEqual(t *testing.T) {
 
 	prefsHandles := []string{
 		"ControlURL",
+		"ControlURLFallbacks",
 		"RouteAll",
 		"ExitNodeID",
 		"ExitNodeIP",
@@ -62,6 +63,13 @@ func TestPrefsEqual(t *testing.T) {
 		"AutoUpdate",
 		"AppConnector",
 		"PostureChecking",
+		"NoAutoKeyRenewal",
+		"Ephemeral",
+		"DERPPlaintextFallback",
+		"ValidateDNSSEC",
+		"AdvertiseDNSAliases",
+		"BlockedPeers",
+		"ListenPort",
 		"NetfilterKind",
 		"DriveShares",
 		"AllowSingleHosts",
@@ -112,6 +120,16 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{ControlURL: "https://controlplane.tailscale.com"},
 			true,
 		},
+		{
+			&Prefs{ControlURLFallbacks: []string{"https://b.example.com"}},
+			&Prefs{ControlURLFallbacks: []string{"https://b.example.com"}},
+			true,
+		},
+		{
+			&Prefs{ControlURLFallbacks: []string{"https://b.example.com", "https://c.example.com"}},
+			&Prefs{ControlURLFallbacks: []string{"https://c.example.com", "https://b.example.com"}},
+			false,
+		},
 
 		{
 			&Prefs{RouteAll: true},
@@ -320,6 +338,51 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{PostureChecking: false},
 			false,
 		},
+		{
+			&Prefs{NoAutoKeyRenewal: true},
+			&Prefs{NoAutoKeyRenewal: false},
+			false,
+		},
+		{
+			&Prefs{Ephemeral: true},
+			&Prefs{Ephemeral: false},
+			false,
+		},
+		{
+			&Prefs{DERPPlaintextFallback: true},
+			&Prefs{DERPPlaintextFallback: false},
+			false,
+		},
+		{
+			&Prefs{ListenPort: 41641},
+			&Prefs{ListenPort: 41642},
+			false,
+		},
+		{
+			&Prefs{ValidateDNSSEC: true},
+			&Prefs{ValidateDNSSEC: false},
+			false,
+		},
+		{
+			&Prefs{AdvertiseDNSAliases: []string{"jellyfin"}},
+			&Prefs{AdvertiseDNSAliases: []string{"jellyfin"}},
+			true,
+		},
+		{
+			&Prefs{AdvertiseDNSAliases: []string{"jellyfin"}},
+			&Prefs{AdvertiseDNSAliases: []string{"plex"}},
+			false,
+		},
+		{
+			&Prefs{BlockedPeers: []string{"laptop"}},
+			&Prefs{BlockedPeers: []string{"laptop"}},
+			true,
+		},
+		{
+			&Prefs{BlockedPeers: []string{"laptop"}},
+			&Prefs{BlockedPeers: []string{"phone"}},
+			false,
+		},
 		{
 			&Prefs{NetfilterKind: "iptables"},
 			&Prefs{NetfilterKind: "iptables"},
@@ -1099,3 +1162,157 @@ type oldPrefs struct {
 		t.Fatal("AllowSingleHosts should be true")
 	}
 }
+
+func TestParsePrefsJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     *MaskedPrefs
+		wantErrs []PrefsFieldError
+	}{
+		{
+			name: "valid",
+			in: `{"RouteAll": true, "Hostname": "foo", "AdvertiseRoutes": ["10.0.0.0/8"],
+				"AutoUpdate": {"Apply": true}, "NoStatefulFiltering": null}`,
+			want: &MaskedPrefs{
+				Prefs: Prefs{
+					RouteAll:            true,
+					Hostname:            "foo",
+					AdvertiseRoutes:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+					AutoUpdate:          AutoUpdatePrefs{Apply: "true"},
+					NoStatefulFiltering: "unset",
+				},
+				RouteAllSet:            true,
+				HostnameSet:            true,
+				AdvertiseRoutesSet:     true,
+				AutoUpdateSet:          AutoUpdatePrefsMask{ApplySet: true},
+				NoStatefulFilteringSet: true,
+			},
+		},
+		{
+			name: "debug-prefs-names",
+			in:   `{"ForceDaemon": true, "AppConnector": {"Advertise": true}}`,
+			want: &MaskedPrefs{
+				Prefs: Prefs{
+					ForceDaemon:  true,
+					AppConnector: AppConnectorPrefs{Advertise: true},
+				},
+				ForceDaemonSet:  true,
+				AppConnectorSet: true,
+			},
+		},
+		{
+			name:     "not-object",
+			in:       `[1]`,
+			wantErrs: []PrefsFieldError{{"(document)", "must be a JSON object"}},
+		},
+		{
+			name: "decode-errors",
+			in: `{"RouteAll": "yes", "Bogus": 1, "Config": {}, "ControlURL": "https://example.com",
+				"AdvertiseRoutes": ["10.0.0/8"], "AutoUpdate": {"Check": 1, "Nope": true},
+				"AppConnector": {"Advertize": true}}`,
+			wantErrs: []PrefsFieldError{
+				{"AdvertiseRoutes", `invalid value: netip.ParsePrefix("10.0.0/8"): ParseAddr("10.0.0"): IPv4 address too short`},
+				{"AppConnector", `invalid value: unknown field "Advertize"`},
+				{"AutoUpdate.Check", "invalid value: want boolean, got number"},
+				{"AutoUpdate.Nope", "unknown field"},
+				{"Bogus", "unknown field"},
+				{"Config", "is not a settable preference"},
+				{"ControlURL", "can only be changed with 'tailscale login --login-server'"},
+				{"RouteAll", "invalid value: want boolean, got string"},
+			},
+		},
+		{
+			name: "invalid-values",
+			in: `{"AdvertiseRoutes": ["10.1.2.3/8", "0.0.0.0/0"], "AdvertiseTags": ["tag:ok", "bad"],
+				"ExitNodeID": "n1", "ExitNodeIP": "100.64.0.1", "NetfilterMode": 7,
+				"AutoUpdate": {"Check": false, "Apply": true}}`,
+			wantErrs: []PrefsFieldError{
+				{"AdvertiseRoutes[0]", "10.1.2.3/8 has non-address bits set; expected 10.0.0.0/8"},
+				{"AdvertiseRoutes", "to advertise an exit node, both 0.0.0.0/0 and ::/0 must be included"},
+				{"AdvertiseTags[1]", "tags must start with 'tag:'"},
+				{"ExitNodeIP", "can't be set together with ExitNodeID"},
+				{"NetfilterMode", "unknown netfilter mode 7"},
+				{"AutoUpdate.Apply", "requires AutoUpdate.Check to be true"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrefsJSON([]byte(tt.in))
+			if tt.wantErrs != nil {
+				var ve *PrefsValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("err = %v; want PrefsValidationError", err)
+				}
+				if !reflect.DeepEqual(ve.Errors, tt.wantErrs) {
+					t.Fatalf("errors:\n got: %q\nwant: %q", ve.Errors, tt.wantErrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got.Pretty(), tt.want.Pretty())
+			}
+		})
+	}
+}
+
+func TestPrefsSchema(t *testing.T) {
+	s := PrefsSchema()
+	if _, err := json.Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+	props := s["properties"].(map[string]any)
+
+	// Every settable field must be in the schema, and every field in the
+	// schema must be accepted by ParsePrefsJSON.
+	for _, f := range fieldsOf(reflect.TypeFor[MaskedPrefs]()) {
+		name, ok := strings.CutSuffix(f, "Set")
+		if !ok || strings.HasPrefix(name, "Internal") {
+			continue
+		}
+		_, inSchema := props[name]
+		_, readOnly := readOnlyPrefs[name]
+		if inSchema == readOnly {
+			t.Errorf("field %q: in schema = %v, read-only = %v", name, inSchema, readOnly)
+		}
+	}
+	for name, prop := range props {
+		val := "null"
+		if prop.(map[string]any)["type"] == "object" {
+			val = "{}"
+		}
+		mp, err := ParsePrefsJSON([]byte(fmt.Sprintf(`{%q: %s}`, name, val)))
+		if err != nil {
+			t.Errorf("field %q in schema but not accepted: %v", name, err)
+			continue
+		}
+		if val == "null" && mp.IsEmpty() {
+			t.Errorf("field %q in schema but not marked as set", name)
+		}
+	}
+}
+
+func TestCheckControlURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"https://control.example.com", false},
+		{"https://control.example.com/", false},
+		{"http://10.0.0.1:8080", false},
+		{"control.example.com", true},
+		{"ftp://control.example.com", true},
+		{"https://", true},
+		{"https://control.example.com/foo", true},
+	}
+	for _, tt := range tests {
+		err := CheckControlURL(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckControlURL(%q) = %v; want error %v", tt.in, err, tt.wantErr)
+		}
+	}
+}
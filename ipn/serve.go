@@ -31,6 +31,11 @@ type ServeConfig struct {
 	// the Tailscale IP addresses. (not subnet routers, etc)
 	TCP map[uint16]*TCPPortHandler `json:",omitempty"`
 
+	// UDP are the list of UDP port numbers that tailscaled should handle
+	// for the Tailscale IP addresses, and for Funnel if AllowFunnel is set
+	// for the port.
+	UDP map[uint16]*UDPPortHandler `json:",omitempty"`
+
 	// Web maps from "$SNI_NAME:$PORT" to a set of HTTP handlers
 	// keyed by mount point ("/", "/foo", etc)
 	Web map[HostPort]*WebServerConfig `json:",omitempty"`
@@ -125,6 +130,21 @@ type TCPPortHandler struct {
 	// SNI name with this value. It is only used if TCPForward is non-empty.
 	// (the HTTPS mode uses ServeConfig.Web)
 	TerminateTLS string `json:",omitempty"`
+
+	// ProxyProtocol, if non-zero, is the version of the PROXY protocol, 1
+	// or 2, with which tailscaled tells the TCPForward backend the original
+	// source and destination addresses of each connection, such as the
+	// public address of a Funnel client. The header is sent before any of
+	// the connection's data. It is only used if TCPForward is non-empty.
+	ProxyProtocol int `json:",omitempty"`
+}
+
+// UDPPortHandler describes what to do when handling UDP datagrams.
+type UDPPortHandler struct {
+	// UDPForward is the IP:port to forward datagrams to. Each client
+	// address gets its own session with the backend, so that the backend's
+	// replies can be sent back to it.
+	UDPForward string `json:",omitempty"`
 }
 
 // HTTPHandler is either a path or a proxy to serve.
@@ -136,6 +156,12 @@ type HTTPHandler struct {
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
+	// ForwardedHeader, if true, means that requests proxied to Proxy
+	// carry a Forwarded header (RFC 7239) describing the original client,
+	// in addition to the X-Forwarded-For, X-Forwarded-Host and
+	// X-Forwarded-Proto headers that are always set.
+	ForwardedHeader bool `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes? Redirects?
 }
@@ -203,6 +229,12 @@ func (sc *ServeConfig) IsTCPForwardingAny() bool {
 	return false
 }
 
+// IsUDPForwardingOnPort reports whether ServeConfig is currently forwarding
+// UDP on the given port.
+func (sc *ServeConfig) IsUDPForwardingOnPort(port uint16) bool {
+	return sc != nil && sc.UDP[port] != nil
+}
+
 // IsTCPForwardingOnPort reports whether if ServeConfig is currently forwarding
 // in TCPForward mode on the given port. This is exclusive of Web/HTTPS serving.
 func (sc *ServeConfig) IsTCPForwardingOnPort(port uint16) bool {
@@ -254,6 +286,23 @@ func (sc *ServeConfig) FindConfig(port uint16) (*ServeConfig, bool) {
 	return nil, false
 }
 
+// FindUDPConfig is like FindConfig, but finds a config that contains the
+// given UDP port.
+func (sc *ServeConfig) FindUDPConfig(port uint16) (*ServeConfig, bool) {
+	if sc == nil {
+		return nil, false
+	}
+	if _, ok := sc.UDP[port]; ok {
+		return sc, false
+	}
+	for _, sc := range sc.Foreground {
+		if _, ok := sc.UDP[port]; ok {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
 // SetWebHandler sets the given HTTPHandler at the specified host, port,
 // and mount in the serve config. sc.TCP is also updated to reflect web
 // serving usage of the given port.
@@ -300,6 +349,15 @@ func (sc *ServeConfig) SetTCPForwarding(port uint16, fwdAddr string, terminateTL
 	}
 }
 
+// SetUDPForwarding sets the fwdAddr (IP:port form) to which to forward
+// datagrams received on the given UDP port.
+func (sc *ServeConfig) SetUDPForwarding(port uint16, fwdAddr string) {
+	if sc == nil {
+		sc = new(ServeConfig)
+	}
+	mak.Set(&sc.UDP, port, &UDPPortHandler{UDPForward: fwdAddr})
+}
+
 // SetFunnel sets the sc.AllowFunnel value for the given host and port.
 func (sc *ServeConfig) SetFunnel(host string, port uint16, setOn bool) {
 	if sc == nil {
@@ -359,6 +417,15 @@ func (sc *ServeConfig) RemoveTCPForwarding(port uint16) {
 	}
 }
 
+// RemoveUDPForwarding deletes the UDP forwarding configuration for the
+// given port from the serve config.
+func (sc *ServeConfig) RemoveUDPForwarding(port uint16) {
+	delete(sc.UDP, port)
+	if len(sc.UDP) == 0 {
+		sc.UDP = nil
+	}
+}
+
 // IsFunnelOn reports whether if ServeConfig is currently allowing funnel
 // traffic for any host:port.
 //
@@ -406,60 +473,100 @@ func NodeCanFunnel(node *ipnstate.PeerStatus) error {
 	return nil
 }
 
-// CheckFunnelPort checks whether the given port is allowed for Funnel.
-// It uses the tailcfg.CapabilityFunnelPorts nodeAttr to determine the allowed
-// ports.
-func CheckFunnelPort(wantedPort uint16, node *ipnstate.PeerStatus) error {
-	deny := func(allowedPorts string) error {
-		if allowedPorts == "" {
-			return fmt.Errorf("port %d is not allowed for funnel", wantedPort)
+// CheckFunnelConfig checks that node may use Funnel on each host:port for
+// which sc, or one of its foreground configs, turns Funnel on. It reports
+// the first that node may not use, so that a misconfigured config can be
+// rejected before control silently drops its traffic.
+//
+// Host:ports on which prev already has Funnel on are not checked, so that
+// a config can still be edited after the allowed ports have changed.
+//
+// The node arg should be the ipnstate.Status.Self node.
+func CheckFunnelConfig(sc *ServeConfig, prev ServeConfigView, node *ipnstate.PeerStatus) error {
+	if sc == nil {
+		return nil
+	}
+	wasOn := func(hp HostPort) bool {
+		if !prev.Valid() {
+			return false
 		}
-		return fmt.Errorf("port %d is not allowed for funnel; allowed ports are: %v", wantedPort, allowedPorts)
+		if prev.AllowFunnel().Get(hp) {
+			return true
+		}
+		on := false
+		prev.Foreground().Range(func(_ string, v ServeConfigView) bool {
+			on = v.AllowFunnel().Get(hp)
+			return !on
+		})
+		return on
 	}
-	var portsStr string
-	parseAttr := func(attr string) (string, error) {
-		u, err := url.Parse(attr)
-		if err != nil {
-			return "", deny("")
+	check := func(sc *ServeConfig) error {
+		hps := make([]HostPort, 0, len(sc.AllowFunnel))
+		for hp := range sc.AllowFunnel {
+			hps = append(hps, hp)
 		}
-		portsStr := u.Query().Get("ports")
-		if portsStr == "" {
-			return "", deny("")
+		slices.Sort(hps)
+		for _, hp := range hps {
+			if !sc.AllowFunnel[hp] || wasOn(hp) {
+				continue
+			}
+			port, err := hp.Port()
+			if err != nil {
+				return fmt.Errorf("invalid Funnel host:port %q: %w", hp, err)
+			}
+			if err := CheckFunnelAccess(port, node); err != nil {
+				return fmt.Errorf("can't turn on Funnel for %s: %w", hp, err)
+			}
 		}
-		u.RawQuery = ""
-		if u.String() != string(tailcfg.CapabilityFunnelPorts) {
-			return "", deny("")
+		return nil
+	}
+	if err := check(sc); err != nil {
+		return err
+	}
+	for _, fg := range sc.Foreground {
+		if fg == nil {
+			continue
+		}
+		if err := check(fg); err != nil {
+			return err
 		}
-		return portsStr, nil
 	}
+	return nil
+}
+
+// AllowedFunnelPorts returns the ports that node may use for Funnel, as
+// listed by its tailcfg.CapabilityFunnelPorts nodeAttr: a comma-separated
+// list of ports and port ranges such as "443,8080-8090". It returns the
+// empty string if node has no valid such nodeAttr.
+//
+// The node arg should be the ipnstate.Status.Self node.
+func AllowedFunnelPorts(node *ipnstate.PeerStatus) string {
 	for attr := range node.CapMap {
 		attr := string(attr)
 		if !strings.HasPrefix(attr, string(tailcfg.CapabilityFunnelPorts)) {
 			continue
 		}
-		var err error
-		portsStr, err = parseAttr(attr)
+		u, err := url.Parse(attr)
 		if err != nil {
-			return err
+			return ""
 		}
-		break
-	}
-	if portsStr == "" {
-		for attr := range node.CapMap {
-			attr := string(attr)
-			if !strings.HasPrefix(attr, string(tailcfg.CapabilityFunnelPorts)) {
-				continue
-			}
-			var err error
-			portsStr, err = parseAttr(attr)
-			if err != nil {
-				return err
-			}
-			break
+		portsStr := u.Query().Get("ports")
+		u.RawQuery = ""
+		if u.String() != string(tailcfg.CapabilityFunnelPorts) {
+			return ""
 		}
+		return portsStr
 	}
+	return ""
+}
+
+// CheckFunnelPort checks whether the given port is allowed for Funnel.
+// It uses the tailcfg.CapabilityFunnelPorts nodeAttr to determine the allowed
+// ports.
+func CheckFunnelPort(wantedPort uint16, node *ipnstate.PeerStatus) error {
+	portsStr := AllowedFunnelPorts(node)
 	if portsStr == "" {
-		return deny("")
+		return fmt.Errorf("port %d is not allowed for funnel", wantedPort)
 	}
 	wantedPortString := strconv.Itoa(int(wantedPort))
 	for _, ps := range strings.Split(portsStr, ",") {
@@ -486,7 +593,7 @@ func CheckFunnelPort(wantedPort uint16, node *ipnstate.PeerStatus) error {
 			return nil
 		}
 	}
-	return deny(portsStr)
+	return fmt.Errorf("port %d is not allowed for funnel; allowed ports are: %v", wantedPort, portsStr)
 }
 
 // ExpandProxyTargetValue expands the supported target values to be proxied
@@ -564,6 +671,27 @@ func (v ServeConfigView) RangeOverTCPs(f func(port uint16, _ TCPPortHandlerView)
 	})
 }
 
+// RangeOverUDPs ranges over both background and foreground UDPs.
+// If the returned bool from the given f is false, then this function stops
+// iterating immediately and does not check other foreground configs.
+func (v ServeConfigView) RangeOverUDPs(f func(port uint16, _ UDPPortHandlerView) bool) {
+	parentCont := true
+	v.UDP().Range(func(k uint16, v UDPPortHandlerView) (cont bool) {
+		parentCont = f(k, v)
+		return parentCont
+	})
+	v.Foreground().Range(func(k string, v ServeConfigView) (cont bool) {
+		if !parentCont {
+			return false
+		}
+		v.UDP().Range(func(k uint16, v UDPPortHandlerView) (cont bool) {
+			parentCont = f(k, v)
+			return parentCont
+		})
+		return parentCont
+	})
+}
+
 // RangeOverWebs ranges over both background and foreground Webs.
 // If the returned bool from the given f is false, then this function stops
 // iterating immediately and does not check other foreground configs.
@@ -599,6 +727,20 @@ func (v ServeConfigView) FindTCP(port uint16) (res TCPPortHandlerView, ok bool)
 	return v.TCP().GetOk(port)
 }
 
+// FindUDP returns the first UDP that matches with the given port. It
+// prefers a foreground match first followed by a background search if none
+// existed.
+func (v ServeConfigView) FindUDP(port uint16) (res UDPPortHandlerView, ok bool) {
+	v.Foreground().Range(func(_ string, v ServeConfigView) (cont bool) {
+		res, ok = v.UDP().GetOk(port)
+		return !ok
+	})
+	if ok {
+		return res, ok
+	}
+	return v.UDP().GetOk(port)
+}
+
 // FindWeb returns the first Web that matches with the given HostPort. It
 // prefers a foreground match first followed by a background search if none
 // existed.
@@ -44,6 +44,68 @@ func TestCheckFunnelAccess(t *testing.T) {
 	}
 }
 
+func TestCheckFunnelConfig(t *testing.T) {
+	node := &ipnstate.PeerStatus{CapMap: tailcfg.NodeCapMap{
+		tailcfg.CapabilityHTTPS:                                 nil,
+		tailcfg.NodeAttrFunnel:                                  nil,
+		"https://tailscale.com/cap/funnel-ports?ports=443,8443": nil,
+	}}
+	funnel := func(hps ...HostPort) *ServeConfig {
+		sc := &ServeConfig{AllowFunnel: map[HostPort]bool{}}
+		for _, hp := range hps {
+			sc.AllowFunnel[hp] = true
+		}
+		return sc
+	}
+	tests := []struct {
+		name    string
+		sc      *ServeConfig
+		prev    *ServeConfig
+		wantErr string
+	}{
+		{name: "nil", sc: nil},
+		{name: "allowed", sc: funnel("foo.test.ts.net:443", "foo.test.ts.net:8443")},
+		{
+			name:    "disallowed",
+			sc:      funnel("foo.test.ts.net:443", "foo.test.ts.net:10000"),
+			wantErr: "can't turn on Funnel for foo.test.ts.net:10000: port 10000 is not allowed for funnel; allowed ports are: 443,8443",
+		},
+		{
+			name: "off",
+			sc:   &ServeConfig{AllowFunnel: map[HostPort]bool{"foo.test.ts.net:10000": false}},
+		},
+		{
+			name: "already-on",
+			sc:   funnel("foo.test.ts.net:10000"),
+			prev: funnel("foo.test.ts.net:10000"),
+		},
+		{
+			name: "foreground",
+			sc: &ServeConfig{Foreground: map[string]*ServeConfig{
+				"session": funnel("foo.test.ts.net:10000"),
+			}},
+			wantErr: "can't turn on Funnel for foo.test.ts.net:10000: port 10000 is not allowed for funnel; allowed ports are: 443,8443",
+		},
+		{
+			name:    "bad-hostport",
+			sc:      funnel("foo.test.ts.net"),
+			wantErr: `invalid Funnel host:port "foo.test.ts.net": address foo.test.ts.net: missing port in address`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckFunnelConfig(tt.sc, tt.prev.View(), node)
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("got error %q; want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestHasPathHandler(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,120 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// validTransitions maps each State to the States a backend may move to
+// from it. Moving to the current state is always allowed and isn't a
+// transition. Moving back to NoState is only done by StateMachine.Reset.
+var validTransitions = map[State][]State{
+	NoState:          {NeedsLogin, NeedsMachineAuth, Stopped, Starting},
+	InUseOtherUser:   {NeedsLogin, NeedsMachineAuth, Stopped, Starting},
+	NeedsLogin:       {NeedsMachineAuth, Stopped, Starting},
+	NeedsMachineAuth: {NeedsLogin, Stopped, Starting},
+	Stopped:          {NeedsLogin, NeedsMachineAuth, Starting},
+	Starting:         {NeedsLogin, NeedsMachineAuth, Stopped, Running},
+	Running:          {NeedsLogin, NeedsMachineAuth, Stopped, Starting},
+}
+
+// ValidTransition reports whether a backend may move from state from to
+// state to.
+func ValidTransition(from, to State) bool {
+	return from == to || slices.Contains(validTransitions[from], to)
+}
+
+// StateTransition is a change of a StateMachine's state.
+type StateTransition struct {
+	From   State
+	To     State
+	Time   time.Time
+	Reason string `json:",omitempty"` // what caused it, for debugging
+
+	// Illegal is whether the transition isn't one that ValidTransition
+	// allows. Illegal transitions still happen, as the state has to
+	// reflect what the backend is doing, but they indicate a bug.
+	Illegal bool `json:",omitempty"`
+}
+
+// maxStateHistory is the number of transitions a StateMachine remembers.
+const maxStateHistory = 32
+
+// StateMachine tracks a backend's State, checking that each transition is
+// valid and remembering the most recent ones.
+//
+// The zero value is a StateMachine in NoState. It's safe for concurrent
+// use.
+type StateMachine struct {
+	mu      sync.Mutex
+	state   State
+	history []StateTransition // oldest first, at most maxStateHistory
+	hooks   []func(StateTransition)
+}
+
+// State returns the current state.
+func (m *StateMachine) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Transition moves m to state to, for the given reason. It reports
+// whether the state changed, along with the transition if so.
+//
+// The transition hooks are called before Transition returns, with any
+// locks held by its caller.
+func (m *StateMachine) Transition(to State, reason string) (t StateTransition, changed bool) {
+	return m.transition(to, reason, false)
+}
+
+// Reset moves m back to NoState, as done when the backend restarts.
+// Unlike other transitions to NoState, it's never illegal.
+func (m *StateMachine) Reset(reason string) {
+	m.transition(NoState, reason, true)
+}
+
+func (m *StateMachine) transition(to State, reason string, reset bool) (StateTransition, bool) {
+	m.mu.Lock()
+	if m.state == to {
+		m.mu.Unlock()
+		return StateTransition{}, false
+	}
+	t := StateTransition{
+		From:    m.state,
+		To:      to,
+		Time:    time.Now(),
+		Reason:  reason,
+		Illegal: !reset && !ValidTransition(m.state, to),
+	}
+	m.state = to
+	if len(m.history) == maxStateHistory {
+		m.history = slices.Delete(m.history, 0, 1)
+	}
+	m.history = append(m.history, t)
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	for _, f := range hooks {
+		f(t)
+	}
+	return t, true
+}
+
+// OnTransition registers f to be called after each change of m's state.
+func (m *StateMachine) OnTransition(f func(StateTransition)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(slices.Clip(m.hooks), f)
+}
+
+// History returns m's most recent transitions, oldest first.
+func (m *StateMachine) History() []StateTransition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.history)
+}
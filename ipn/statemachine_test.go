@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import "testing"
+
+func TestStateMachine(t *testing.T) {
+	var m StateMachine
+	var hooked []StateTransition
+	m.OnTransition(func(t StateTransition) { hooked = append(hooked, t) })
+
+	steps := []struct {
+		to          State
+		wantChanged bool
+		wantIllegal bool
+	}{
+		{Starting, true, false},
+		{Starting, false, false},
+		{Running, true, false},
+		{NeedsLogin, true, false},
+		{Running, true, true}, // must go through Starting
+		{NoState, true, true}, // only Reset may go back to NoState
+	}
+	for i, s := range steps {
+		from := m.State()
+		tr, changed := m.Transition(s.to, "test")
+		if changed != s.wantChanged {
+			t.Fatalf("step %d: %v -> %v changed = %v; want %v", i, from, s.to, changed, s.wantChanged)
+		}
+		if tr.Illegal != s.wantIllegal {
+			t.Errorf("step %d: %v -> %v illegal = %v; want %v", i, from, s.to, tr.Illegal, s.wantIllegal)
+		}
+		if got := m.State(); got != s.to {
+			t.Errorf("step %d: state = %v; want %v", i, got, s.to)
+		}
+	}
+
+	m.Transition(Stopped, "test")
+	m.Reset("restart")
+	h := m.History()
+	if len(h) != 7 {
+		t.Fatalf("history has %d transitions; want 7", len(h))
+	}
+	if last := h[len(h)-1]; last.From != Stopped || last.To != NoState || last.Illegal || last.Reason != "restart" {
+		t.Errorf("last transition = %+v; want legal Stopped -> NoState for restart", last)
+	}
+	if len(hooked) != len(h) {
+		t.Errorf("hook saw %d transitions; want %d", len(hooked), len(h))
+	}
+
+	for range maxStateHistory {
+		m.Transition(Starting, "test")
+		m.Transition(Stopped, "test")
+	}
+	h = m.History()
+	if len(h) != maxStateHistory {
+		t.Errorf("history has %d transitions; want %d", len(h), maxStateHistory)
+	}
+	if last := h[len(h)-1]; last.To != Stopped {
+		t.Errorf("last transition is to %v; want Stopped", last.To)
+	}
+}
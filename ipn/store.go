@@ -58,6 +58,22 @@
 	// has ever been received (even if partially).
 	// Any non-empty value indicates that at least one file has been received.
 	TaildropReceivedKey = StateKey("_taildrop-received")
+
+	// DERPRegionHistoryStateKey is the key under which we store the
+	// JSON-encoded history of DERP region reliability, used to pick the
+	// home DERP region. It's not specific to a profile.
+	DERPRegionHistoryStateKey = StateKey("_derp-region-history")
+
+	// DERPServerKeysStateKey is the key under which we store the
+	// JSON-encoded public keys of DERP servers learned over TLS, by
+	// hostname, which let derphttp fall back to plaintext when TLS is
+	// blocked. It's not specific to a profile.
+	DERPServerKeysStateKey = StateKey("_derp-server-keys")
+
+	// ConnAuditStateKey is the key under which we store whether the
+	// connection audit log is enabled, as an int: 1 if it is, 0 or
+	// absent if not. It's not specific to a profile.
+	ConnAuditStateKey = StateKey("_conn-audit")
 )
 
 // CurrentProfileID returns the StateKey that stores the
@@ -71,6 +87,12 @@ func CurrentProfileKey(userID string) StateKey {
 	return StateKey("_current/" + userID)
 }
 
+// DeclaredServicesKey returns the StateKey that stores the JSON-encoded
+// []tailcfg.DeclaredService declared on this node for a profile.
+func DeclaredServicesKey(profileID ProfileID) StateKey {
+	return StateKey("_services/" + profileID)
+}
+
 // StateStore persists state, and produces it back on request.
 // Implementations of StateStore are expected to be safe for concurrent use.
 type StateStore interface {
@@ -94,6 +116,12 @@ func WriteState(store StateStore, id StateKey, v []byte) error {
 	return store.WriteState(id, v)
 }
 
+// StateStoreKeyLister is an optional interface that StateStores can
+// implement to list the keys they hold state for.
+type StateStoreKeyLister interface {
+	StateKeys() ([]StateKey, error)
+}
+
 // StateStoreDialerSetter is an optional interface that StateStores
 // can implement to allow the caller to set a custom dialer.
 type StateStoreDialerSetter interface {
@@ -0,0 +1,325 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package encstore provides an ipn.StateStore that encrypts the state it
+// persists in another StateStore, with a key protected by a platform
+// keystore or a passphrase.
+package encstore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+// keyStateKey is the StateKey under which the protected data key is kept
+// in the underlying store.
+const keyStateKey ipn.StateKey = "_encstore-key"
+
+// magic prefixes each encrypted value, followed by the nonce and the
+// ciphertext. Values without it predate encryption.
+const magic = "tsenc1:"
+
+// A KeyProtector protects the key that encrypts the state, such that only
+// this machine (or a holder of the passphrase) can recover it.
+type KeyProtector interface {
+	// Name is the name of the protector, as given to NewProtector.
+	Name() string
+	// Seal returns key protected, for keeping alongside the encrypted
+	// state.
+	Seal(key []byte) (sealed []byte, err error)
+	// Unseal recovers the key from sealed, as returned by Seal.
+	Unseal(sealed []byte) (key []byte, err error)
+}
+
+// platformProtectors are the platform keystore protectors available on this
+// platform, by name.
+var platformProtectors = map[string]func() (KeyProtector, error){}
+
+// NewProtector returns the KeyProtector described by spec, which is the
+// name of a platform keystore ("dpapi" on Windows, "keychain" on macOS,
+// "tpm" or "systemd-creds" on Linux), "passphrase:" followed by the path
+// of a file holding the passphrase, or "command:" followed by the path of
+// a program to seal and unseal the key (see CommandProtector).
+func NewProtector(spec string) (KeyProtector, error) {
+	if prog, ok := strings.CutPrefix(spec, "command:"); ok {
+		path, err := exec.LookPath(prog)
+		if err != nil {
+			return nil, fmt.Errorf("state encryption command: %w", err)
+		}
+		return CommandProtector(path), nil
+	}
+	if file, ok := strings.CutPrefix(spec, "passphrase:"); ok {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading passphrase: %w", err)
+		}
+		pass := bytes.TrimRight(bs, "\r\n")
+		if len(pass) == 0 {
+			return nil, fmt.Errorf("passphrase file %q is empty", file)
+		}
+		return PassphraseProtector(pass), nil
+	}
+	if newProtector, ok := platformProtectors[spec]; ok {
+		return newProtector()
+	}
+	var names []string
+	for name := range platformProtectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = append(names, "passphrase:<file>", "command:<program>")
+	return nil, fmt.Errorf("unknown state encryption %q; want one of %s", spec, strings.Join(names, ", "))
+}
+
+// sealedKey is the JSON value stored under keyStateKey.
+type sealedKey struct {
+	Protector string // KeyProtector.Name
+	Sealed    []byte
+
+	// Migrating is whether values stored before encryption was turned on
+	// may remain unencrypted. It's set when the key is created, and
+	// cleared once they've all been rewritten encrypted.
+	Migrating bool `json:",omitempty"`
+}
+
+// Store is an ipn.StateStore that encrypts the values it stores in another
+// StateStore with XChaCha20-Poly1305, authenticating each value's StateKey
+// too so that values can't be swapped. The key is generated when the
+// underlying store is first used and stored there protected by a
+// KeyProtector.
+//
+// Values stored before encryption was turned on are rewritten encrypted
+// by New, if the underlying store implements ipn.StateStoreKeyLister, and
+// otherwise as they're read. Once they all have been, a plaintext value
+// can only have been put there by someone tampering with the state, and
+// reading it is an error.
+type Store struct {
+	logf  logger.Logf
+	inner ipn.StateStore
+	aead  cipher.AEAD
+
+	// migrate is whether plaintext values are accepted and rewritten
+	// encrypted, because they may predate encryption.
+	migrate bool
+}
+
+// New returns a Store encrypting the state kept in inner with a key
+// protected by p.
+func New(logf logger.Logf, inner ipn.StateStore, p KeyProtector) (*Store, error) {
+	logf = logger.WithPrefix(logf, "encstore: ")
+	key, sk, err := loadOrCreateKey(logf, inner, p)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		logf:    logf,
+		inner:   inner,
+		aead:    aead,
+		migrate: sk.Migrating,
+	}
+	if s.migrate {
+		// Only record that the migration is done once all values are
+		// encrypted, so that an interrupted one resumes on restart.
+		if err := s.migrateAll(); err != nil {
+			logf("existing state will be encrypted as it's read: %v", err)
+		} else {
+			sk.Migrating = false
+			if err := writeSealedKey(inner, sk); err != nil {
+				logf("recording the end of the state encryption migration: %v", err)
+			} else {
+				s.migrate = false
+			}
+		}
+	}
+	return s, nil
+}
+
+// migrateAll rewrites encrypted all the values in s.inner stored before
+// encryption was turned on. It fails if s.inner can't list its keys.
+func (s *Store) migrateAll() error {
+	kl, ok := s.inner.(ipn.StateStoreKeyLister)
+	if !ok {
+		return fmt.Errorf("%v can't list its keys", s.inner)
+	}
+	ids, err := kl.StateKeys()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		if id == keyStateKey {
+			continue
+		}
+		bs, err := s.inner.ReadState(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %q: %w", id, err))
+			continue
+		}
+		if bytes.HasPrefix(bs, []byte(magic)) {
+			continue
+		}
+		if err := s.WriteState(id, bs); err != nil {
+			errs = append(errs, fmt.Errorf("encrypting %q: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadOrCreateKey returns the key stored in inner, unsealing it with p,
+// or creates one if there's none, along with its stored form.
+func loadOrCreateKey(logf logger.Logf, inner ipn.StateStore, p KeyProtector) (key []byte, _ sealedKey, _ error) {
+	var sk sealedKey
+	bs, err := inner.ReadState(keyStateKey)
+	if err == nil {
+		if err := json.Unmarshal(bs, &sk); err != nil {
+			return nil, sk, fmt.Errorf("parsing state encryption key: %w", err)
+		}
+		if sk.Protector != p.Name() {
+			return nil, sk, fmt.Errorf("state is encrypted with a key protected by %q, not %q", sk.Protector, p.Name())
+		}
+		key, err := p.Unseal(sk.Sealed)
+		if err != nil {
+			return nil, sk, fmt.Errorf("unsealing state encryption key with %s: %w", p.Name(), err)
+		}
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, sk, fmt.Errorf("unsealed state encryption key has %d bytes; want %d", len(key), chacha20poly1305.KeySize)
+		}
+		return key, sk, nil
+	}
+	if !errors.Is(err, ipn.ErrStateNotExist) {
+		return nil, sk, err
+	}
+
+	key = make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, sk, err
+	}
+	sealed, err := p.Seal(key)
+	if err != nil {
+		return nil, sk, fmt.Errorf("sealing state encryption key with %s: %w", p.Name(), err)
+	}
+	sk = sealedKey{Protector: p.Name(), Sealed: sealed, Migrating: true}
+	if err := writeSealedKey(inner, sk); err != nil {
+		return nil, sk, err
+	}
+	logf("created state encryption key protected by %s", p.Name())
+	return key, sk, nil
+}
+
+// writeSealedKey stores sk in inner.
+func writeSealedKey(inner ipn.StateStore, sk sealedKey) error {
+	bs, err := json.Marshal(sk)
+	if err != nil {
+		return err
+	}
+	return inner.WriteState(keyStateKey, bs)
+}
+
+func (s *Store) String() string { return fmt.Sprintf("encstore.Store(%v)", s.inner) }
+
+// ReadState implements the ipn.StateStore interface.
+func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	if id == keyStateKey {
+		return nil, ipn.ErrStateNotExist
+	}
+	bs, err := s.inner.ReadState(id)
+	if err != nil {
+		return nil, err
+	}
+	ct, ok := bytes.CutPrefix(bs, []byte(magic))
+	if !ok {
+		if !s.migrate {
+			return nil, fmt.Errorf("%q isn't encrypted, but the state was encrypted before; refusing to read it", id)
+		}
+		// Written before encryption was turned on.
+		if err := s.WriteState(id, bs); err != nil {
+			s.logf("encrypting existing %q: %v", id, err)
+		}
+		return bs, nil
+	}
+	ns := s.aead.NonceSize()
+	if len(ct) < ns {
+		return nil, fmt.Errorf("encrypted %q is truncated", id)
+	}
+	pt, err := s.aead.Open(nil, ct[:ns], ct[ns:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %q: %w", id, err)
+	}
+	return pt, nil
+}
+
+// WriteState implements the ipn.StateStore interface.
+func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
+	if id == keyStateKey {
+		return fmt.Errorf("%q is reserved", id)
+	}
+	ns := s.aead.NonceSize()
+	out := make([]byte, len(magic)+ns, len(magic)+ns+len(bs)+chacha20poly1305.Overhead)
+	copy(out, magic)
+	nonce := out[len(magic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out = s.aead.Seal(out, nonce, bs, []byte(id))
+	return s.inner.WriteState(id, out)
+}
+
+// PassphraseProtector is a KeyProtector that encrypts the key with a key
+// derived from a passphrase with Argon2id.
+type PassphraseProtector []byte
+
+const passphraseSaltSize = 16
+
+func (PassphraseProtector) Name() string { return "passphrase" }
+
+func (p PassphraseProtector) aead(salt []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.NewX(argon2.IDKey(p, salt, 3, 64*1024, 4, chacha20poly1305.KeySize))
+}
+
+// Seal implements KeyProtector. The sealed key is the salt, the nonce and
+// the encrypted key.
+func (p PassphraseProtector) Seal(key []byte) ([]byte, error) {
+	out := make([]byte, passphraseSaltSize+chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(out); err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(out[:passphraseSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[passphraseSaltSize:], key, nil), nil
+}
+
+// Unseal implements KeyProtector.
+func (p PassphraseProtector) Unseal(sealed []byte) ([]byte, error) {
+	if len(sealed) < passphraseSaltSize+chacha20poly1305.NonceSizeX {
+		return nil, errors.New("sealed key is truncated")
+	}
+	aead, err := p.aead(sealed[:passphraseSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	nonce := sealed[passphraseSaltSize : passphraseSaltSize+chacha20poly1305.NonceSizeX]
+	key, err := aead.Open(nil, nonce, sealed[len(nonce)+passphraseSaltSize:], nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return key, nil
+}
@@ -0,0 +1,265 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	inner := new(mem.Store)
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte(`{"PrivateNodeKey":"privkey:0123"}`)
+	if err := s.WriteState("_machinekey", secret); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inner.ReadState("_machinekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte(magic)) || bytes.Contains(raw, []byte("privkey")) {
+		t.Errorf("stored value %q isn't encrypted", raw)
+	}
+	got, err := s.ReadState("_machinekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("ReadState = %q; want %q", got, secret)
+	}
+	if _, err := s.ReadState("missing"); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("ReadState(missing) error = %v; want ErrStateNotExist", err)
+	}
+
+	// Reopening with the same passphrase reuses the stored key.
+	s2, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s2.ReadState("_machinekey"); err != nil || !bytes.Equal(got, secret) {
+		t.Errorf("after reopen, ReadState = %q, %v; want %q", got, err, secret)
+	}
+
+	if _, err := New(t.Logf, inner, PassphraseProtector("wrong")); err == nil {
+		t.Error("New with wrong passphrase succeeded; want error")
+	}
+}
+
+func TestStoreMigratesPlaintext(t *testing.T) {
+	inner := new(mem.Store)
+	old := []byte("plaintext prefs")
+	if err := inner.WriteState("profile-1234", old); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.ReadState("profile-1234")
+	if err != nil || !bytes.Equal(got, old) {
+		t.Fatalf("ReadState = %q, %v; want %q", got, err, old)
+	}
+	raw, _ := inner.ReadState("profile-1234")
+	if !bytes.HasPrefix(raw, []byte(magic)) {
+		t.Errorf("existing value wasn't rewritten encrypted: %q", raw)
+	}
+}
+
+func TestStoreMigratesUnreadPlaintext(t *testing.T) {
+	inner := new(mem.Store)
+	old := []byte("plaintext prefs")
+	if err := inner.WriteState("profile-1234", old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(t.Logf, inner, PassphraseProtector("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := inner.ReadState("profile-1234")
+	if !bytes.HasPrefix(raw, []byte(magic)) {
+		t.Errorf("existing value wasn't encrypted by New: %q", raw)
+	}
+
+	// After a restart, the value is still readable.
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadState("profile-1234"); err != nil || !bytes.Equal(got, old) {
+		t.Errorf("after restart, ReadState = %q, %v; want %q", got, err, old)
+	}
+}
+
+func TestStoreMigratesWithoutKeyLister(t *testing.T) {
+	// An underlying store that can't list its keys has its values
+	// encrypted as they're read, across restarts.
+	mem := new(mem.Store)
+	inner := struct{ ipn.StateStore }{mem}
+	old := []byte("plaintext prefs")
+	for _, id := range []ipn.StateKey{"profile-1", "profile-2"} {
+		if err := inner.WriteState(id, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadState("profile-1"); err != nil || !bytes.Equal(got, old) {
+		t.Fatalf("ReadState = %q, %v; want %q", got, err, old)
+	}
+
+	s, err = New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []ipn.StateKey{"profile-1", "profile-2"} {
+		if got, err := s.ReadState(id); err != nil || !bytes.Equal(got, old) {
+			t.Errorf("after restart, ReadState(%q) = %q, %v; want %q", id, got, err, old)
+		}
+		if raw, _ := inner.ReadState(id); !bytes.HasPrefix(raw, []byte(magic)) {
+			t.Errorf("%q wasn't rewritten encrypted: %q", id, raw)
+		}
+	}
+}
+
+func TestStoreRejectsPlaintextAfterMigration(t *testing.T) {
+	inner := new(mem.Store)
+	if _, err := New(t.Logf, inner, PassphraseProtector("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the key exists, a plaintext value can only have been planted.
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	planted := []byte("attacker prefs")
+	inner.WriteState("profile-1234", planted)
+	if got, err := s.ReadState("profile-1234"); err == nil {
+		t.Errorf("ReadState of plaintext value = %q; want error", got)
+	}
+	if raw, _ := inner.ReadState("profile-1234"); !bytes.Equal(raw, planted) {
+		t.Errorf("plaintext value was rewritten: %q", raw)
+	}
+}
+
+func TestStoreRejectsSwappedValues(t *testing.T) {
+	inner := new(mem.Store)
+	s, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("a", []byte("value a")); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := inner.ReadState("a")
+	inner.WriteState("b", raw)
+	if _, err := s.ReadState("b"); err == nil {
+		t.Error("ReadState of value copied from another key succeeded; want error")
+	}
+}
+
+func TestStoreReservedKey(t *testing.T) {
+	s, err := New(t.Logf, new(mem.Store), PassphraseProtector("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadState(keyStateKey); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("ReadState(%q) error = %v; want ErrStateNotExist", keyStateKey, err)
+	}
+	if err := s.WriteState(keyStateKey, []byte("x")); err == nil {
+		t.Errorf("WriteState(%q) succeeded; want error", keyStateKey)
+	}
+}
+
+type fakeProtector string
+
+func (p fakeProtector) Name() string                    { return string(p) }
+func (fakeProtector) Seal(key []byte) ([]byte, error)   { return key, nil }
+func (fakeProtector) Unseal(key []byte) ([]byte, error) { return key, nil }
+
+func TestStoreProtectorMismatch(t *testing.T) {
+	inner := new(mem.Store)
+	if _, err := New(t.Logf, inner, fakeProtector("dpapi")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(t.Logf, inner, PassphraseProtector("hunter2"))
+	if err == nil || !strings.Contains(err.Error(), `"dpapi"`) {
+		t.Errorf("New with different protector error = %v; want mismatch", err)
+	}
+}
+
+func TestNewProtector(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pass")
+	if err := os.WriteFile(file, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProtector("passphrase:" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := p.(PassphraseProtector); !ok || string(got) != "hunter2" {
+		t.Errorf("NewProtector = %#v; want PassphraseProtector(hunter2)", p)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(empty, []byte("\n"), 0600)
+	if _, err := NewProtector("passphrase:" + empty); err == nil {
+		t.Error("NewProtector with empty passphrase succeeded; want error")
+	}
+	if _, err := NewProtector("bogus"); err == nil {
+		t.Error("NewProtector(bogus) succeeded; want error")
+	}
+}
+
+func TestCommandProtector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+	script := filepath.Join(t.TempDir(), "kms")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+case "$1" in
+seal) printf 'sealed:'; cat ;;
+unseal) tail -c +8 ;;
+*) echo "bad op $1" >&2; exit 1 ;;
+esac
+`), 0700); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProtector("command:" + script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef\n0123456789abcdef")
+	sealed, err := p.Seal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, []byte("sealed:")) {
+		t.Errorf("Seal = %q; want output of the command", sealed)
+	}
+	if got, err := p.Unseal(sealed); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Unseal = %q, %v; want %q", got, err, key)
+	}
+
+	if _, err := NewProtector("command:" + filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewProtector with missing command succeeded; want error")
+	}
+	if _, err := CommandProtector("/bin/false").Seal(key); err == nil {
+		t.Error("Seal with failing command succeeded; want error")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// CommandProtector is a KeyProtector that has an external program seal
+// and unseal the key, so that it can be kept in a KMS, a secrets manager
+// or an HSM that tailscaled doesn't support itself. The value is the path
+// of the program.
+//
+// The program is run with a single argument, "seal" or "unseal", and is
+// given the key or the sealed key on stdin. It must write the sealed key
+// or the key to stdout, and exit with a non-zero status on failure.
+type CommandProtector string
+
+func (CommandProtector) Name() string { return "command" }
+
+// Seal implements KeyProtector.
+func (p CommandProtector) Seal(key []byte) ([]byte, error) {
+	return p.run("seal", key)
+}
+
+// Unseal implements KeyProtector.
+func (p CommandProtector) Unseal(sealed []byte) ([]byte, error) {
+	return p.run("unseal", sealed)
+}
+
+func (p CommandProtector) run(op string, in []byte) ([]byte, error) {
+	cmd := exec.Command(string(p), op)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", p, op, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s %s: no output", p, op)
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	platformProtectors["keychain"] = func() (KeyProtector, error) { return keychainProtector{}, nil }
+}
+
+const (
+	// keychainService is the service name of the keychain items holding
+	// state encryption keys.
+	keychainService = "com.tailscale.tailscaled.state"
+	// systemKeychain is the keychain the items are stored in, as
+	// tailscaled runs as root without a login keychain.
+	systemKeychain = "/Library/Keychains/System.keychain"
+)
+
+// keychainProtector is a KeyProtector that keeps the key in the macOS
+// System keychain, using the security(1) command. The sealed key is just
+// the name of the keychain item's account.
+type keychainProtector struct{}
+
+func (keychainProtector) Name() string { return "keychain" }
+
+func (keychainProtector) Seal(key []byte) ([]byte, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	account := "state-" + hex.EncodeToString(id[:])
+
+	// Pass the command on stdin rather than as arguments, which other
+	// processes can see.
+	cmd := exec.Command("/usr/bin/security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s %s\n",
+		keychainService, account, hex.EncodeToString(key), systemKeychain))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("adding keychain item: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return []byte(account), nil
+}
+
+func (keychainProtector) Unseal(sealed []byte) ([]byte, error) {
+	out, err := exec.Command("/usr/bin/security", "find-generic-password",
+		"-s", keychainService, "-a", string(sealed), "-w", systemKeychain).Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading keychain item %q: %w", sealed, err)
+	}
+	return hex.DecodeString(string(bytes.TrimSpace(out)))
+}
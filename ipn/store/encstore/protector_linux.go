@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func init() {
+	platformProtectors["tpm"] = func() (KeyProtector, error) {
+		if _, err := exec.LookPath("tpm2_unseal"); err != nil {
+			return nil, fmt.Errorf("tpm state encryption requires tpm2-tools: %w", err)
+		}
+		return tpmProtector{}, nil
+	}
+	platformProtectors["systemd-creds"] = func() (KeyProtector, error) {
+		if _, err := exec.LookPath("systemd-creds"); err != nil {
+			return nil, fmt.Errorf("systemd-creds state encryption requires systemd 250 or later: %w", err)
+		}
+		return systemdCredsProtector{}, nil
+	}
+}
+
+// systemdCredsName is the credential name that systemd-creds binds the
+// sealed key to.
+const systemdCredsName = "tailscaled-state-key"
+
+// systemdCredsProtector is a KeyProtector that seals the key with
+// systemd-creds(1), which uses the TPM if there is one and otherwise the
+// host key in /var/lib/systemd, readable only by root.
+type systemdCredsProtector struct{}
+
+func (systemdCredsProtector) Name() string { return "systemd-creds" }
+
+func (systemdCredsProtector) Seal(key []byte) ([]byte, error) {
+	return runTool("", bytes.NewReader(key), "systemd-creds", "encrypt", "--name="+systemdCredsName, "-", "-")
+}
+
+func (systemdCredsProtector) Unseal(sealed []byte) ([]byte, error) {
+	return runTool("", bytes.NewReader(sealed), "systemd-creds", "decrypt", "--name="+systemdCredsName, "-", "-")
+}
+
+// tpmProtector is a KeyProtector that seals the key to this machine's TPM
+// 2.0, using the tpm2-tools commands. The key is sealed under a primary key
+// derived from the TPM's owner hierarchy seed, which is recreated as
+// needed, so only this TPM can unseal it.
+//
+// The key is sealed without a PCR policy: it's bound to this machine, not
+// to its boot state, so it protects a copy of the state taken elsewhere but
+// not the state on this machine booted into another system.
+type tpmProtector struct{}
+
+// tpmSealed is the sealed form of a key: the public and private parts of
+// the TPM sealing object holding it.
+type tpmSealed struct {
+	Public  []byte
+	Private []byte
+}
+
+func (tpmProtector) Name() string { return "tpm" }
+
+func (tpmProtector) Seal(key []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "tailscaled-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := tpmCreatePrimary(dir); err != nil {
+		return nil, err
+	}
+	// The key is passed on stdin so that it's never written to disk.
+	if _, err := runTool(dir, bytes.NewReader(key), "tpm2_create", "-C", "primary.ctx", "-g", "sha256",
+		"-u", "seal.pub", "-r", "seal.priv", "-i", "-"); err != nil {
+		return nil, err
+	}
+	var s tpmSealed
+	if s.Public, err = os.ReadFile(filepath.Join(dir, "seal.pub")); err != nil {
+		return nil, err
+	}
+	if s.Private, err = os.ReadFile(filepath.Join(dir, "seal.priv")); err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+func (tpmProtector) Unseal(sealed []byte) ([]byte, error) {
+	var s tpmSealed
+	if err := json.Unmarshal(sealed, &s); err != nil {
+		return nil, fmt.Errorf("parsing sealed key: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "tailscaled-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "seal.pub"), s.Public, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "seal.priv"), s.Private, 0600); err != nil {
+		return nil, err
+	}
+	if err := tpmCreatePrimary(dir); err != nil {
+		return nil, err
+	}
+	if _, err := runTool(dir, nil, "tpm2_load", "-C", "primary.ctx", "-u", "seal.pub", "-r", "seal.priv", "-c", "seal.ctx"); err != nil {
+		return nil, err
+	}
+	return runTool(dir, nil, "tpm2_unseal", "-c", "seal.ctx")
+}
+
+// tpmCreatePrimary creates the primary key the key is sealed under, in
+// primary.ctx in dir. The same template always yields the same key.
+func tpmCreatePrimary(dir string) error {
+	_, err := runTool(dir, nil, "tpm2_createprimary", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", "primary.ctx")
+	return err
+}
+
+// runTool runs a command in dir, or the current directory if empty, and
+// returns its stdout.
+func runTool(dir string, stdin *bytes.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func init() {
+	platformProtectors["dpapi"] = func() (KeyProtector, error) { return dpapiProtector{}, nil }
+}
+
+// dpapiEntropy is mixed into the DPAPI encryption, so that other programs
+// running as the same user can't unseal the key without knowing it.
+var dpapiEntropy = []byte("tailscaled state encryption key")
+
+// dpapiProtector is a KeyProtector using the Windows Data Protection API,
+// which ties the sealed key to the account tailscaled runs as (usually
+// LocalSystem) on this machine.
+type dpapiProtector struct{}
+
+func (dpapiProtector) Name() string { return "dpapi" }
+
+func (dpapiProtector) Seal(key []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(dataBlob(key), nil, dataBlob(dpapiEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func (dpapiProtector) Unseal(sealed []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(dataBlob(sealed), nil, dataBlob(dpapiEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func dataBlob(b []byte) *windows.DataBlob {
+	if len(b) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(b)), Data: &b[0]}
+}
+
+// takeDataBlob returns a copy of the contents of b, which was allocated by
+// DPAPI, and frees it.
+func takeDataBlob(b *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(b.Data)))
+	return append([]byte(nil), unsafe.Slice(b.Data, b.Size)...)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+// KV is a minimal key-value store, for keeping state in storage that
+// doesn't have its own StateStore implementation. Use NewKVStore to adapt
+// one to an ipn.StateStore, or RegisterKV to make it selectable by New.
+//
+// Implementations must be safe for concurrent use.
+type KV interface {
+	// Get returns the value of key, or an error wrapping
+	// ipn.ErrStateNotExist if it has none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put sets the value of key.
+	Put(ctx context.Context, key string, value []byte) error
+}
+
+// KVProvider returns a KV for the provided path. The arg is of the form
+// "prefix:rest", where prefix was registered with RegisterKV.
+type KVProvider func(logf logger.Logf, arg string) (KV, error)
+
+// RegisterKV registers a prefix to be used for New, like Register, for a
+// store that keeps state in the KV returned by fn.
+func RegisterKV(prefix string, fn KVProvider) {
+	Register(prefix, func(logf logger.Logf, arg string) (ipn.StateStore, error) {
+		kv, err := fn(logf, arg)
+		if err != nil {
+			return nil, err
+		}
+		return NewKVStore(kv), nil
+	})
+}
+
+// kvTimeout is how long a KVStore waits for a KV operation.
+const kvTimeout = 30 * time.Second
+
+// KVStore is an ipn.StateStore that keeps state in a KV.
+//
+// State keys are path-escaped to make KV keys, so they only use
+// characters that are safe in URL paths. Values are cached after they're
+// first read or written, so each is read from the KV at most once.
+type KVStore struct {
+	kv KV
+
+	mu    sync.Mutex
+	cache map[ipn.StateKey][]byte
+}
+
+// NewKVStore returns a KVStore that keeps state in kv.
+func NewKVStore(kv KV) *KVStore {
+	return &KVStore{
+		kv:    kv,
+		cache: make(map[ipn.StateKey][]byte),
+	}
+}
+
+func (s *KVStore) String() string { return fmt.Sprintf("KVStore(%v)", s.kv) }
+
+// ReadState implements the ipn.StateStore interface.
+func (s *KVStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bs, ok := s.cache[id]; ok {
+		return bytes.Clone(bs), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	bs, err := s.kv.Get(ctx, url.PathEscape(string(id)))
+	if err != nil {
+		if errors.Is(err, ipn.ErrStateNotExist) {
+			return nil, ipn.ErrStateNotExist
+		}
+		return nil, err
+	}
+	s.cache[id] = bytes.Clone(bs)
+	return bs, nil
+}
+
+// WriteState implements the ipn.StateStore interface.
+func (s *KVStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.cache[id]; ok && bytes.Equal(cur, bs) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	if err := s.kv.Put(ctx, url.PathEscape(string(id)), bs); err != nil {
+		return err
+	}
+	s.cache[id] = bytes.Clone(bs)
+	return nil
+}
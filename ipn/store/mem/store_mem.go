@@ -9,6 +9,7 @@
 	"encoding/json"
 	"sync"
 
+	xmaps "golang.org/x/exp/maps"
 	"tailscale.com/ipn"
 	"tailscale.com/types/logger"
 )
@@ -38,6 +39,13 @@ func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
 	return bs, nil
 }
 
+// StateKeys implements the ipn.StateStoreKeyLister interface.
+func (s *Store) StateKeys() ([]ipn.StateKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return xmaps.Keys(s.cache), nil
+}
+
 // WriteState implements the StateStore interface.
 func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	s.mu.Lock()
@@ -19,7 +19,8 @@ func init() {
 
 func registerKubeStore() {
 	Register("kube:", func(logf logger.Logf, path string) (ipn.StateStore, error) {
-		secretName := strings.TrimPrefix(path, "kube:")
+		// Accept both "kube:name" and the URL form "kube://name".
+		secretName := strings.TrimPrefix(strings.TrimPrefix(path, "kube:"), "//")
 		return kubestore.New(logf, secretName)
 	})
 }
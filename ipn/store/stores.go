@@ -8,12 +8,15 @@
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 
+	xmaps "golang.org/x/exp/maps"
 	"tailscale.com/atomicfile"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
@@ -32,6 +35,7 @@
 
 func registerDefaultStores() {
 	Register("mem:", mem.New)
+	Register("file:", newFileStoreFromURL)
 
 	for _, f := range registerAvailableExternalStores {
 		f()
@@ -49,10 +53,13 @@ func registerDefaultStores() {
 //
 //   - if the string begins with "mem:", the suffix
 //     is ignored and an in-memory store is used.
+//   - if the string begins with "file:", the suffix is a
+//     path or the string is a file URL ("file:///var/lib/tailscale/state").
 //   - (Linux-only) if the string begins with "arn:",
 //     the suffix an AWS ARN for an SSM.
-//   - (Linux-only) if the string begins with "kube:",
+//   - (Linux-only) if the string begins with "kube:" or "kube://",
 //     the suffix is a Kubernetes secret name
+//   - if the string is any other URL ("scheme://..."), it's an error.
 //   - In all other cases, the path is treated as a filepath.
 func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 	regOnce.Do(registerDefaultStores)
@@ -63,6 +70,14 @@ func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 			return sf(logf, path)
 		}
 	}
+	if scheme, _, ok := strings.Cut(path, "://"); ok && isURLScheme(scheme) {
+		prefixes := make([]string, 0, len(knownStores))
+		for prefix := range knownStores {
+			prefixes = append(prefixes, prefix)
+		}
+		slices.Sort(prefixes)
+		return nil, fmt.Errorf("unknown state store %q; registered stores: %s", scheme+":", strings.Join(prefixes, ", "))
+	}
 	if runtime.GOOS == "windows" {
 		path = TryWindowsAppDataMigration(logf, path)
 	}
@@ -84,6 +99,47 @@ func Register(prefix string, fn Provider) {
 	mak.Set(&knownStores, prefix, fn)
 }
 
+// isURLScheme reports whether s is a URL scheme of more than one character
+// (so as not to be confused with a Windows drive letter).
+func isURLScheme(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newFileStoreFromURL returns a FileStore for a "file:" path, which is
+// either a file URL or "file:" followed by a plain path.
+func newFileStoreFromURL(logf logger.Logf, arg string) (ipn.StateStore, error) {
+	path := strings.TrimPrefix(arg, "file:")
+	if strings.HasPrefix(path, "//") {
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file URL %q: %w", arg, err)
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %q has non-local host %q", arg, u.Host)
+		}
+		path = u.Path
+		if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+			path = path[1:] // "/C:/ProgramData/..."
+		}
+		path = filepath.FromSlash(path)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("file store %q has no path", arg)
+	}
+	return NewFileStore(logf, path)
+}
+
 // TryWindowsAppDataMigration attempts to copy the Windows state file
 // from its old location to the new location. (Issue 2856)
 //
@@ -172,6 +228,13 @@ func (s *FileStore) ReadState(id ipn.StateKey) ([]byte, error) {
 	return bs, nil
 }
 
+// StateKeys implements the ipn.StateStoreKeyLister interface.
+func (s *FileStore) StateKeys() ([]ipn.StateKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return xmaps.Keys(s.cache), nil
+}
+
 // WriteState implements the StateStore interface.
 func (s *FileStore) WriteState(id ipn.StateKey, bs []byte) error {
 	s.mu.Lock()
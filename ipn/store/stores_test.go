@@ -4,13 +4,19 @@
 package store
 
 import (
+	"context"
+	"fmt"
+	"net/url"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tstest"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
 )
 
 func TestNewStore(t *testing.T) {
@@ -68,6 +74,85 @@ type store2 struct {
 	} else if _, ok := s.(*FileStore); !ok {
 		t.Fatalf("%q: got: %T, want: %T", path, s, new(FileStore))
 	}
+
+	path = "redis://localhost/0"
+	if _, err := New(t.Logf, path); err == nil || !strings.Contains(err.Error(), `"redis:"`) {
+		t.Fatalf("%q: got error %v, want unknown store", path, err)
+	}
+}
+
+func TestNewFileStoreFromURL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state")
+	for _, arg := range []string{
+		"file:" + path,
+		(&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(),
+	} {
+		s, err := newFileStoreFromURL(t.Logf, arg)
+		if err != nil {
+			t.Fatalf("%q: %v", arg, err)
+		}
+		if got := s.(*FileStore).Path(); filepath.Clean(got) != path {
+			t.Errorf("%q: path = %q; want %q", arg, got, path)
+		}
+	}
+	for _, arg := range []string{"file:", "file://otherhost/state"} {
+		if _, err := newFileStoreFromURL(t.Logf, arg); err == nil {
+			t.Errorf("%q: succeeded; want error", arg)
+		}
+	}
+}
+
+// mapKV is a KV backed by a map.
+type mapKV struct {
+	mu   sync.Mutex
+	m    map[string]string
+	gets int
+}
+
+func (kv *mapKV) Get(_ context.Context, key string) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.gets++
+	v, ok := kv.m[key]
+	if !ok {
+		return nil, fmt.Errorf("get %q: %w", key, ipn.ErrStateNotExist)
+	}
+	return []byte(v), nil
+}
+
+func (kv *mapKV) Put(_ context.Context, key string, value []byte) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	mak.Set(&kv.m, key, string(value))
+	return nil
+}
+
+func TestKVStore(t *testing.T) {
+	tstest.PanicOnLog()
+
+	kv := new(mapKV)
+	testStoreSemantics(t, NewKVStore(kv))
+
+	store := NewKVStore(kv)
+	if err := store.WriteState("_serve/profile-1", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.m["_serve%2Fprofile-1"]; !ok {
+		t.Errorf("keys = %v; want escaped state key", kv.m)
+	}
+	kv.gets = 0
+	for range 2 {
+		if bs, err := NewKVStore(kv).ReadState("foo"); err != nil || string(bs) != "bar" {
+			t.Errorf("ReadState(foo) = %q, %v; want bar", bs, err)
+		}
+		if _, err := store.ReadState("baz"); err != nil {
+			t.Error(err)
+		}
+	}
+	if kv.gets != 3 {
+		t.Errorf("KV gets = %d; want 3 (two new stores and one cached)", kv.gets)
+	}
 }
 
 func testStoreSemantics(t *testing.T, store ipn.StateStore) {
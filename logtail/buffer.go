@@ -7,6 +7,7 @@
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 )
 
@@ -24,6 +25,20 @@ type Buffer interface {
 	Write([]byte) (int, error)
 }
 
+// A SnapshotBuffer is a Buffer that can also copy out the log lines it holds
+// without consuming them. It's implemented by the disk-backed filch.Filch.
+type SnapshotBuffer interface {
+	Buffer
+
+	// Snapshot writes the log lines currently in the buffer to w, oldest
+	// first, leaving them in the buffer.
+	Snapshot(w io.Writer) (int64, error)
+}
+
+// ErrSnapshotUnsupported is returned by Logger.SnapshotBuffer if the
+// logger's Buffer isn't a SnapshotBuffer.
+var ErrSnapshotUnsupported = errors.New("logtail: buffer doesn't support snapshots")
+
 func NewMemoryBuffer(numEntries int) Buffer {
 	return &memBuffer{
 		pending: make(chan qentry, numEntries),
@@ -31,6 +31,7 @@ type Filch struct {
 	cur       *os.File
 	alt       *os.File
 	altscan   *bufio.Scanner
+	altread   int64 // number of bytes of alt returned by altscan so far
 	recovered int64
 
 	maxFileSize  int64
@@ -44,7 +45,7 @@ type Filch struct {
 	// so that the whole struct takes 4096 bytes
 	// (less on 32 bit platforms).
 	// This reduces allocation waste.
-	buf [4096 - 64]byte
+	buf [4096 - 72]byte
 }
 
 // TryReadline implements the logtail.Buffer interface.
@@ -67,20 +68,30 @@ func (f *Filch) TryReadLine() ([]byte, error) {
 	if _, err := f.alt.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
+	f.resetAltScan()
+	return f.scan()
+}
+
+// resetAltScan starts reading alt from its beginning.
+// f.mu must be held.
+func (f *Filch) resetAltScan() {
 	f.altscan = bufio.NewScanner(f.alt)
 	f.altscan.Buffer(f.buf[:], bufio.MaxScanTokenSize)
 	f.altscan.Split(splitLines)
-	return f.scan()
+	f.altread = 0
 }
 
 func (f *Filch) scan() ([]byte, error) {
 	if f.altscan.Scan() {
-		return f.altscan.Bytes(), nil
+		b := f.altscan.Bytes()
+		f.altread += int64(len(b))
+		return b, nil
 	}
 	err := f.altscan.Err()
 	err2 := f.alt.Truncate(0)
 	_, err3 := f.alt.Seek(0, io.SeekStart)
 	f.altscan = nil
+	f.altread = 0
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +121,11 @@ func (f *Filch) Write(b []byte) (int, error) {
 			if err := moveContents(f.alt, f.cur); err != nil {
 				return 0, err
 			}
+			if f.altscan != nil {
+				// alt was overwritten; don't keep reading the
+				// discarded logs buffered by the old scanner.
+				f.resetAltScan()
+			}
 		}
 	}
 	f.writeCounter++
@@ -123,6 +139,40 @@ func (f *Filch) Write(b []byte) (int, error) {
 	return f.cur.Write(b)
 }
 
+// Snapshot writes the logs currently held by f, oldest first, to w without
+// consuming them: they are still returned by later calls to TryReadLine.
+// It's meant for attaching the logs that haven't been uploaded yet to bug
+// reports.
+func (f *Filch) Snapshot(w io.Writer) (n int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, part := range []struct {
+		file *os.File
+		off  int64
+	}{
+		{f.alt, f.altread}, // being read out, so older
+		{f.cur, 0},
+	} {
+		if part.file == f.alt && f.altscan == nil {
+			continue // alt was fully read out and truncated
+		}
+		fi, err := part.file.Stat()
+		if err != nil {
+			return n, err
+		}
+		if fi.Size() <= part.off {
+			continue
+		}
+		m, err := io.Copy(w, io.NewSectionReader(part.file, part.off, fi.Size()-part.off))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 // Close closes the Filch, releasing all os resources.
 func (f *Filch) Close() (err error) {
 	f.mu.Lock()
@@ -224,9 +274,7 @@ func New(filePrefix string, opts Options) (f *Filch, err error) {
 		f.cur, f.alt = f1, f2 // does not matter
 	}
 	if f.recovered > 0 {
-		f.altscan = bufio.NewScanner(f.alt)
-		f.altscan.Buffer(f.buf[:], bufio.MaxScanTokenSize)
-		f.altscan.Split(splitLines)
+		f.resetAltScan()
 	}
 
 	f.OrigStderr = nil
@@ -112,6 +112,44 @@ func TestQueue(t *testing.T) {
 	f.close(t)
 }
 
+func (f *filchTest) snapshot(t *testing.T, want string) {
+	t.Helper()
+	var sb strings.Builder
+	n, err := f.Snapshot(&sb)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if got := sb.String(); got != want || n != int64(len(want)) {
+		t.Errorf("Snapshot = %q (n=%d), want %q", got, n, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	filePrefix := t.TempDir()
+	f := newFilchTest(t, filePrefix, Options{ReplaceStderr: false})
+	f.snapshot(t, "")
+	f.write(t, "one")
+	f.write(t, "two")
+	f.snapshot(t, "one\ntwo\n")
+	f.read(t, "one")
+	f.write(t, "three")
+	// "two" is being read out of alt, "three" was written to cur.
+	f.snapshot(t, "two\nthree\n")
+	f.read(t, "two")
+	f.read(t, "three")
+	f.readEOF(t)
+	f.snapshot(t, "")
+	f.write(t, "four")
+	f.close(t)
+
+	// Logs that survived a restart are included.
+	f = newFilchTest(t, filePrefix, Options{ReplaceStderr: false})
+	f.snapshot(t, "four\n")
+	f.read(t, "four")
+	f.readEOF(t)
+	f.close(t)
+}
+
 func TestRecover(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		filePrefix := t.TempDir()
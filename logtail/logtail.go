@@ -250,6 +250,21 @@ func (l *Logger) SetSockstatsLabel(label sockstats.Label) {
 // It exists for internal use only.
 func (l *Logger) PrivateID() logid.PrivateID { return l.privateID }
 
+// SnapshotBuffer writes the log entries that are buffered but not yet
+// uploaded to w, as JSON, one per line, oldest first, without removing them
+// from the buffer. Entries already read from the buffer for an upload that's
+// still in progress aren't included.
+//
+// It's meant for attaching recent logs to bug reports, and returns
+// ErrSnapshotUnsupported if the logger's Buffer isn't a SnapshotBuffer.
+func (l *Logger) SnapshotBuffer(w io.Writer) (int64, error) {
+	sb, ok := l.buffer.(SnapshotBuffer)
+	if !ok {
+		return 0, ErrSnapshotUnsupported
+	}
+	return sb.Snapshot(w)
+}
+
 // Shutdown gracefully shuts down the logger while completing any
 // remaining uploads.
 //
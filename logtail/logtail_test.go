@@ -10,12 +10,14 @@
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-json-experiment/json/jsontext"
 	"tailscale.com/envknob"
+	"tailscale.com/logtail/filch"
 	"tailscale.com/tstest"
 	"tailscale.com/tstime"
 	"tailscale.com/util/must"
@@ -554,3 +556,32 @@ func BenchmarkWriteJSON(b *testing.B) {
 		must.Get(l.Write(testdataJSONLog))
 	}
 }
+
+var _ SnapshotBuffer = (*filch.Filch)(nil)
+
+func TestSnapshotBuffer(t *testing.T) {
+	l := &Logger{buffer: NewMemoryBuffer(10)}
+	if _, err := l.SnapshotBuffer(io.Discard); err != ErrSnapshotUnsupported {
+		t.Errorf("memory buffer: err = %v; want ErrSnapshotUnsupported", err)
+	}
+
+	f, err := filch.New(filepath.Join(t.TempDir(), "snap"), filch.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l = &Logger{buffer: f}
+	for _, s := range []string{"one", "two"} {
+		must.Get(l.buffer.Write([]byte(s)))
+	}
+	var buf bytes.Buffer
+	if _, err := l.SnapshotBuffer(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "one\ntwo\n"; got != want {
+		t.Errorf("snapshot = %q; want %q", got, want)
+	}
+	if line := must.Get(l.buffer.TryReadLine()); string(line) != "one\n" {
+		t.Errorf("after snapshot, TryReadLine = %q; want first line still buffered", line)
+	}
+}
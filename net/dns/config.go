@@ -45,6 +45,11 @@ type Config struct {
 	// OnlyIPv6, if true, uses the IPv6 service IP (for MagicDNS)
 	// instead of the IPv4 version (100.100.100.100).
 	OnlyIPv6 bool
+	// ValidateDNSSEC, if true, validates the DNSSEC signatures of
+	// responses from DefaultResolvers and Routes. It makes queries to
+	// DefaultResolvers go through 100.100.100.100 rather than directly
+	// to them, so that they can be validated.
+	ValidateDNSSEC bool
 }
 
 func (c *Config) serviceIP() netip.Addr {
@@ -65,6 +70,9 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 
 	fmt.Fprintf(w, " SearchDomains:%v", c.SearchDomains)
 	fmt.Fprintf(w, " Hosts:%v", len(c.Hosts))
+	if c.ValidateDNSSEC {
+		w.WriteString(" ValidateDNSSEC")
+	}
 	w.WriteString("}")
 }
 
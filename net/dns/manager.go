@@ -8,6 +8,7 @@
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -116,6 +117,10 @@ func NewManager(logf logger.Logf, oscfg OSConfigurator, health *health.Tracker,
 // Resolver returns the Manager's DNS Resolver.
 func (m *Manager) Resolver() *resolver.Resolver { return m.resolver }
 
+// OSConfiguratorType returns the Go type of the Manager's OSConfigurator,
+// such as "*dns.resolvedManager", for debugging.
+func (m *Manager) OSConfiguratorType() string { return fmt.Sprintf("%T", m.os) }
+
 func (m *Manager) Set(cfg Config) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -219,6 +224,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.ValidateDNSSEC = cfg.ValidateDNSSEC
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	for suffix, resolvers := range cfg.Routes {
 		if len(resolvers) == 0 {
@@ -241,7 +247,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 		// case where cfg is entirely zero, in which case these
 		// configs clear all Tailscale DNS settings.
 		return rcfg, ocfg, nil
-	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes():
+	case cfg.hasDefaultIPResolversOnly() && !cfg.hasHostsWithoutSplitDNSRoutes() && !cfg.ValidateDNSSEC:
 		// Trivial CorpDNS configuration, just override the OS resolver.
 		//
 		// If there are hosts (ExtraRecords) that are not covered by an existing
@@ -540,18 +546,21 @@ func (m *Manager) FlushCaches() error {
 // No other state needs to be instantiated before this runs.
 //
 // health must not be nil
-func CleanUp(logf logger.Logf, netMon *netmon.Monitor, health *health.Tracker, interfaceName string) {
+//
+// It returns an error if the configuration might not have been fully
+// restored.
+func CleanUp(logf logger.Logf, netMon *netmon.Monitor, health *health.Tracker, interfaceName string) error {
 	oscfg, err := NewOSConfigurator(logf, nil, nil, interfaceName)
 	if err != nil {
-		logf("creating dns cleanup: %v", err)
-		return
+		return fmt.Errorf("creating dns cleanup: %w", err)
 	}
 	d := &tsdial.Dialer{Logf: logf}
 	d.SetNetMon(netMon)
 	dns := NewManager(logf, oscfg, health, d, nil, nil, runtime.GOOS)
 	if err := dns.Down(); err != nil {
-		logf("dns down: %v", err)
+		return fmt.Errorf("dns down: %w", err)
 	}
+	return nil
 }
 
 var (
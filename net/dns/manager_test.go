@@ -286,6 +286,22 @@ func TestManager(t *testing.T) {
 				SearchDomains: fqdns("tailscale.com", "universe.tf"),
 			},
 		},
+		{
+			name: "corp-dnssec",
+			in: Config{
+				DefaultResolvers: mustRes("1.1.1.1", "9.9.9.9"),
+				SearchDomains:    fqdns("tailscale.com", "universe.tf"),
+				ValidateDNSSEC:   true,
+			},
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			rs: resolver.Config{
+				Routes:         upstreams(".", "1.1.1.1", "9.9.9.9"),
+				ValidateDNSSEC: true,
+			},
+		},
 		{
 			name: "corp-magic",
 			in: Config{
@@ -0,0 +1,823 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+)
+
+// rootTrustAnchors are the DS records of the root zone's key signing keys,
+// KSK-2017 and KSK-2024, as published at
+// https://data.iana.org/root-anchors/root-anchors.xml.
+const rootTrustAnchors = `
+. IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+. IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16
+`
+
+// dnssecTrustAnchorsFile, if set, is the path to a file of DS or DNSKEY
+// records in zone file format to use as trust anchors instead of
+// rootTrustAnchors. Anchors for zones other than the root are allowed, for
+// instance for a signed internal zone.
+var dnssecTrustAnchorsFile = envknob.RegisterString("TS_DNSSEC_TRUST_ANCHORS")
+
+const (
+	// maxDNSSECCacheTTL is the longest we remember a zone's validated
+	// keys or the absence of a delegation.
+	maxDNSSECCacheTTL = time.Hour
+	// maxDNSSECCacheEntries bounds the size of the validator's cache.
+	maxDNSSECCacheEntries = 4096
+	// maxNSEC3Iterations is the number of NSEC3 hash iterations beyond which
+	// a zone is treated as insecure, per RFC 9276.
+	maxNSEC3Iterations = 150
+	// nsec3OptOut is the NSEC3 flag marking an opt-out span (RFC 5155).
+	nsec3OptOut = 1
+)
+
+// dnsFlagAuthenticData and dnsFlagCheckingDisabled are the AD and CD bits
+// of the DNS header's flags word (RFC 4035).
+const (
+	dnsFlagAuthenticData    = 0x20
+	dnsFlagCheckingDisabled = 0x10
+)
+
+// errDNSSECBogus is wrapped by errors reporting that a response failed
+// DNSSEC validation.
+var errDNSSECBogus = errors.New("DNSSEC validation failed")
+
+// dnssecValidationFailing is raised when a response from the upstream
+// resolvers fails DNSSEC validation and is replaced with SERVFAIL. It's
+// cleared once a response validates again.
+var dnssecValidationFailing = health.Register(&health.Warnable{
+	Code:     "dnssec-validation-failing",
+	Title:    "DNSSEC validation failed",
+	Severity: health.SeverityMedium,
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("A DNS response failed DNSSEC validation and was blocked: %v", args[health.ArgError])
+	},
+})
+
+// dnssecExchangeFunc sends a query for the records of type qtype at name
+// to an upstream resolver, with the DO bit set, and returns its response.
+type dnssecExchangeFunc func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+
+// cutStatus is what the DS records at a name, or their provable absence,
+// say about the zone cut at that name.
+type cutStatus int
+
+const (
+	cutNone     cutStatus = iota // name isn't a zone cut
+	cutSecure                    // name is a signed zone with validated keys
+	cutInsecure                  // name is an unsigned delegation
+)
+
+type cutEntry struct {
+	status  cutStatus
+	keys    []*dns.DNSKEY // if status is cutSecure
+	expires time.Time
+}
+
+// dnssecValidator validates the DNSSEC signatures of responses from upstream
+// resolvers. It builds a chain of trust from its trust anchors down to the
+// zone that signed each RRset, using DS and DNSKEY records fetched from the
+// same upstream resolvers, and caches the zone keys it validates.
+type dnssecValidator struct {
+	anchors    map[string][]*dns.DS // canonical zone name => DS records
+	anchorsErr error                // non-nil if the trust anchors failed to load
+	now        func() time.Time
+
+	mu   sync.Mutex
+	cuts map[string]cutEntry // canonical name => zone cut at it
+}
+
+// newDNSSECValidator returns a validator using the trust anchors from
+// TS_DNSSEC_TRUST_ANCHORS if set, else the root zone's. If the trust anchors
+// can't be loaded, every response fails validation.
+func newDNSSECValidator(logf logger.Logf) *dnssecValidator {
+	v := &dnssecValidator{now: time.Now}
+	v.anchors, v.anchorsErr = loadTrustAnchors()
+	if v.anchorsErr != nil {
+		logf("DNSSEC: loading trust anchors: %v", v.anchorsErr)
+	}
+	return v
+}
+
+func loadTrustAnchors() (map[string][]*dns.DS, error) {
+	file := dnssecTrustAnchorsFile()
+	if file == "" {
+		return parseTrustAnchors(strings.NewReader(rootTrustAnchors), "")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseTrustAnchors(f, file)
+}
+
+// parseTrustAnchors parses trust anchors in zone file format from r, which
+// was read from file (for error messages). DNSKEY records are turned into
+// their SHA-256 DS records.
+func parseTrustAnchors(r io.Reader, file string) (map[string][]*dns.DS, error) {
+	anchors := map[string][]*dns.DS{}
+	zp := dns.NewZoneParser(r, ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		var ds *dns.DS
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = rr
+		case *dns.DNSKEY:
+			if ds = rr.ToDS(dns.SHA256); ds == nil {
+				return nil, fmt.Errorf("invalid DNSKEY trust anchor for %q", rr.Hdr.Name)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s record for %q in trust anchors; want DS or DNSKEY", dns.TypeToString[rr.Header().Rrtype], rr.Header().Name)
+		}
+		zone := dns.CanonicalName(rr.Header().Name)
+		anchors[zone] = append(anchors[zone], ds)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if len(anchors) == 0 {
+		return nil, errors.New("no trust anchors")
+	}
+	return anchors, nil
+}
+
+// rrset is a set of records with the same owner name, type and class, and
+// the RRSIG records covering it.
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+func (s rrset) name() string { return dns.CanonicalName(s.rrs[0].Header().Name) }
+func (s rrset) typ() uint16  { return s.rrs[0].Header().Rrtype }
+
+func (s rrset) String() string {
+	return s.rrs[0].Header().Name + " " + dns.TypeToString[s.typ()]
+}
+
+// appendTo appends the records of s, followed by its signatures, to rrs.
+func (s rrset) appendTo(rrs []dns.RR) []dns.RR {
+	rrs = append(rrs, s.rrs...)
+	for _, sig := range s.sigs {
+		rrs = append(rrs, sig)
+	}
+	return rrs
+}
+
+// rrsetsOf groups the records of a message section into RRsets, in the
+// order they first appear. RRSIG records are attached to the RRset they
+// cover; those covering no RRset in rrs are dropped, as are OPT records.
+func rrsetsOf(rrs []dns.RR) []rrset {
+	type key struct {
+		name  string
+		typ   uint16
+		class uint16
+	}
+	var sets []rrset
+	idx := map[key]int{}
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeRRSIG || h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		k := key{dns.CanonicalName(h.Name), h.Rrtype, h.Class}
+		i, ok := idx[k]
+		if !ok {
+			i = len(sets)
+			idx[k] = i
+			sets = append(sets, rrset{})
+		}
+		sets[i].rrs = append(sets[i].rrs, rr)
+	}
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		if i, ok := idx[key{dns.CanonicalName(sig.Hdr.Name), sig.TypeCovered, sig.Hdr.Class}]; ok {
+			sets[i].sigs = append(sets[i].sigs, sig)
+		}
+	}
+	return sets
+}
+
+// validate validates the DNSSEC signatures of the answer and authority
+// sections of resp, using exchange for the DS and DNSKEY queries needed. It
+// reports whether resp is secure, with every RRset signed with a chain of
+// trust to a trust anchor, or insecure, from zones provably unsigned. It
+// returns an error wrapping errDNSSECBogus if resp is neither.
+//
+// Responses denying the existence of the queried name or type, and answers
+// expanded from wildcards, are only secure if the NSEC or NSEC3 records of
+// the authority section prove it (RFC 4035, section 5.4, and RFC 5155,
+// section 8); if they're from a signed zone and don't, resp is bogus.
+//
+// Records validate doesn't validate are removed from resp: the authority
+// section keeps only SOA, NSEC and NSEC3 records and the additional section
+// only the OPT record.
+func (v *dnssecValidator) validate(ctx context.Context, resp *dns.Msg, exchange dnssecExchangeFunc) (secure bool, err error) {
+	if v.anchorsErr != nil {
+		return false, fmt.Errorf("%w: no trust anchors: %v", errDNSSECBogus, v.anchorsErr)
+	}
+	if len(resp.Question) != 1 {
+		return false, fmt.Errorf("%w: response has %d questions", errDNSSECBogus, len(resp.Question))
+	}
+
+	secure = true
+	var answer, authority []dns.RR
+	var expanded []rrset // secure answers expanded from wildcards
+	for _, set := range rrsetsOf(resp.Answer) {
+		sig, err := v.validateRRset(ctx, exchange, set)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && sig != nil
+		if sig != nil && isWildcardExpansion(set.name(), sig) {
+			expanded = append(expanded, rrset{rrs: set.rrs, sigs: []*dns.RRSIG{sig}})
+		}
+		answer = set.appendTo(answer)
+	}
+	proofs := map[string]*denialProof{} // signer zone => its NSEC(3) records
+	for _, set := range rrsetsOf(resp.Ns) {
+		switch set.typ() {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		sig, err := v.validateRRset(ctx, exchange, set)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && sig != nil
+		if sig != nil && set.typ() != dns.TypeSOA {
+			zone := dns.CanonicalName(sig.SignerName)
+			if proofs[zone] == nil {
+				proofs[zone] = new(denialProof)
+			}
+			proofs[zone].add(set.rrs)
+		}
+		authority = set.appendTo(authority)
+	}
+
+	for _, set := range expanded {
+		sig := set.sigs[0]
+		ok, err := proofs[dns.CanonicalName(sig.SignerName)].proveWildcardAnswer(set.name(), sig)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && ok
+	}
+
+	q := resp.Question[0]
+	name, answered := answerTarget(q, answer)
+	if nxdomain := resp.Rcode == dns.RcodeNameError; nxdomain || !answered {
+		// Denials must be proven by the zone name is in, which for DS
+		// records is the parent side of the zone cut at name.
+		zoneOf := name
+		if q.Qtype == dns.TypeDS {
+			zoneOf = parentName(name)
+		}
+		zone, keys, err := v.enclosingZone(ctx, exchange, zoneOf)
+		if err != nil {
+			return false, err
+		}
+		if keys == nil {
+			secure = false
+		} else {
+			ok, err := proofs[zone].proveDenial(name, q.Qtype, nxdomain)
+			if err != nil {
+				return false, fmt.Errorf("%w (zone %s)", err, zone)
+			}
+			secure = secure && ok
+		}
+	}
+
+	resp.Answer = answer
+	resp.Ns = authority
+	resp.Extra = slices.DeleteFunc(resp.Extra, func(rr dns.RR) bool {
+		return rr.Header().Rrtype != dns.TypeOPT
+	})
+	return secure, nil
+}
+
+// answerTarget follows the CNAME records in answer from the name queried by
+// q to the name the answer is about, and reports whether answer has records
+// of the queried type at it.
+func answerTarget(q dns.Question, answer []dns.RR) (name string, answered bool) {
+	name = dns.CanonicalName(q.Name)
+	for range len(answer) + 1 { // bounds CNAME loops
+		var next string
+		for _, rr := range answer {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeRRSIG || dns.CanonicalName(h.Name) != name {
+				continue
+			}
+			if h.Rrtype == q.Qtype || q.Qtype == dns.TypeANY {
+				return name, true
+			}
+			if c, ok := rr.(*dns.CNAME); ok {
+				next = dns.CanonicalName(c.Target)
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	return name, false
+}
+
+// validateRRset validates set, returning the signature that validates it.
+// It returns a nil signature and no error if set is in an unsigned zone.
+func (v *dnssecValidator) validateRRset(ctx context.Context, exchange dnssecExchangeFunc, set rrset) (*dns.RRSIG, error) {
+	// Records must be signed by the zone they're in, which for DS
+	// records is the parent side of the zone cut at their owner name.
+	name := set.name()
+	if set.typ() == dns.TypeDS {
+		name = parentName(name)
+	}
+	zone, keys, err := v.enclosingZone(ctx, exchange, name)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return nil, nil
+	}
+	if len(set.sigs) == 0 {
+		return nil, fmt.Errorf("%w: %v is unsigned in signed zone %s", errDNSSECBogus, set, zone)
+	}
+	sig, _, err := v.verifyRRset(set, zone, keys)
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// verifyRRset verifies that one of set's signatures by zone is valid and
+// made with one of keys, returning it and the TTL to cache set for.
+func (v *dnssecValidator) verifyRRset(set rrset, zone string, keys []*dns.DNSKEY) (*dns.RRSIG, time.Duration, error) {
+	now := v.now()
+	lastErr := fmt.Errorf("%w: %v has no signature by %s", errDNSSECBogus, set, zone)
+	for _, sig := range set.sigs {
+		if dns.CanonicalName(sig.SignerName) != zone {
+			continue
+		}
+		if !sig.ValidityPeriod(now) {
+			lastErr = fmt.Errorf("%w: signature of %v by %s is expired or not yet valid", errDNSSECBogus, set, zone)
+			continue
+		}
+		for _, k := range keys {
+			if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err := sig.Verify(k, set.rrs); err != nil {
+				lastErr = fmt.Errorf("%w: signature of %v by %s: %v", errDNSSECBogus, set, zone, err)
+				continue
+			}
+			ttl := min(set.rrs[0].Header().Ttl, sig.OrigTtl)
+			return sig, min(time.Duration(ttl)*time.Second, maxDNSSECCacheTTL), nil
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// enclosingZone walks the chain of trust from the deepest trust anchor
+// enclosing name down to name, returning the closest zone enclosing name
+// and its validated keys. The keys are nil if there's an unsigned
+// delegation on the way (or no trust anchor), in which case zone is that
+// delegation.
+func (v *dnssecValidator) enclosingZone(ctx context.Context, exchange dnssecExchangeFunc, name string) (zone string, keys []*dns.DNSKEY, err error) {
+	name = dns.CanonicalName(name)
+	anchor := ""
+	for a := range v.anchors {
+		if dns.IsSubDomain(a, name) && (anchor == "" || dns.CountLabel(a) > dns.CountLabel(anchor)) {
+			anchor = a
+		}
+	}
+	if anchor == "" {
+		return name, nil, nil
+	}
+
+	e, ok := v.cachedCut(anchor)
+	if !ok {
+		e.status = cutSecure
+		var ttl time.Duration
+		e.keys, ttl, err = v.fetchKeys(ctx, exchange, anchor, v.anchors[anchor])
+		if err != nil {
+			return "", nil, err
+		}
+		if e.keys == nil {
+			e.status = cutInsecure
+		}
+		v.cacheCut(anchor, e, ttl)
+	}
+	if e.status == cutInsecure {
+		return anchor, nil, nil
+	}
+
+	zone, keys = anchor, e.keys
+	labels := dns.Split(name)
+	for i := len(labels) - dns.CountLabel(anchor) - 1; i >= 0; i-- {
+		child := name[labels[i]:]
+		e, err := v.cut(ctx, exchange, child, zone, keys)
+		if err != nil {
+			return "", nil, err
+		}
+		switch e.status {
+		case cutSecure:
+			zone, keys = child, e.keys
+		case cutInsecure:
+			return child, nil, nil
+		}
+	}
+	return zone, keys, nil
+}
+
+// cut returns what's at name in zone, which has the validated keys.
+func (v *dnssecValidator) cut(ctx context.Context, exchange dnssecExchangeFunc, name, zone string, keys []*dns.DNSKEY) (cutEntry, error) {
+	if e, ok := v.cachedCut(name); ok {
+		return e, nil
+	}
+	resp, err := exchange(ctx, name, dns.TypeDS)
+	if err != nil {
+		return cutEntry{}, fmt.Errorf("querying DS %s: %w", name, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return cutEntry{}, fmt.Errorf("querying DS %s: %s", name, dns.RcodeToString[resp.Rcode])
+	}
+	e, ds, ttl, err := v.classifyDS(resp, name, zone, keys)
+	if err != nil {
+		return cutEntry{}, err
+	}
+	if e.status == cutSecure {
+		var keysTTL time.Duration
+		e.keys, keysTTL, err = v.fetchKeys(ctx, exchange, name, ds)
+		if err != nil {
+			return cutEntry{}, err
+		}
+		if e.keys == nil {
+			e.status = cutInsecure
+		}
+		ttl = min(ttl, keysTTL)
+	}
+	v.cacheCut(name, e, ttl)
+	return e, nil
+}
+
+// classifyDS returns what resp, the response to a DS query for name, says
+// about the zone cut at name. zone is the closest zone enclosing name, with
+// the validated keys, whose signatures resp's records must have. If name
+// is a signed zone, the validated DS records are returned too.
+func (v *dnssecValidator) classifyDS(resp *dns.Msg, name, zone string, keys []*dns.DNSKEY) (_ cutEntry, ds []*dns.DS, ttl time.Duration, err error) {
+	for _, set := range rrsetsOf(resp.Answer) {
+		if set.typ() != dns.TypeDS || set.name() != name {
+			continue
+		}
+		_, ttl, err := v.verifyRRset(set, zone, keys)
+		if err != nil {
+			return cutEntry{}, nil, 0, err
+		}
+		for _, rr := range set.rrs {
+			ds = append(ds, rr.(*dns.DS))
+		}
+		return cutEntry{status: cutSecure}, ds, ttl, nil
+	}
+
+	// No DS records, so the authority section must prove their absence
+	// with records signed by zone.
+	for _, set := range rrsetsOf(resp.Ns) {
+		if set.typ() != dns.TypeNSEC && set.typ() != dns.TypeNSEC3 {
+			continue
+		}
+		_, ttl, err := v.verifyRRset(set, zone, keys)
+		if err != nil {
+			return cutEntry{}, nil, 0, err
+		}
+		for _, rr := range set.rrs {
+			var status cutStatus
+			var ok bool
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				status, ok, err = nsecDenial(rr, name)
+			case *dns.NSEC3:
+				status, ok, err = nsec3Denial(rr, name)
+			}
+			if err != nil {
+				return cutEntry{}, nil, 0, err
+			}
+			if ok {
+				return cutEntry{status: status}, nil, ttl, nil
+			}
+		}
+	}
+	return cutEntry{}, nil, 0, fmt.Errorf("%w: no DS records for %s in signed zone %s and no proof of their absence", errDNSSECBogus, name, zone)
+}
+
+// nsecDenial reports what NSEC record n says about the zone cut at name, if
+// it says anything, as reported by ok.
+func nsecDenial(n *dns.NSEC, name string) (_ cutStatus, ok bool, _ error) {
+	owner := dns.CanonicalName(n.Hdr.Name)
+	if owner == name {
+		return delegationFromTypes(n.TypeBitMap, name)
+	}
+	if nsecCovers(n, name) {
+		// name doesn't exist, so it's no zone cut.
+		return cutNone, true, nil
+	}
+	return 0, false, nil
+}
+
+// nsec3Denial is like nsecDenial, for NSEC3 records.
+func nsec3Denial(n *dns.NSEC3, name string) (_ cutStatus, ok bool, _ error) {
+	if n.Iterations > maxNSEC3Iterations {
+		return cutInsecure, true, nil
+	}
+	if n.Match(name) {
+		return delegationFromTypes(n.TypeBitMap, name)
+	}
+	if n.Cover(name) {
+		if n.Flags&nsec3OptOut != 0 {
+			// name may be an unsigned delegation.
+			return cutInsecure, true, nil
+		}
+		return cutNone, true, nil
+	}
+	return 0, false, nil
+}
+
+// delegationFromTypes returns what the types present at name, from an NSEC
+// or NSEC3 record owned by it, say about the zone cut at name.
+func delegationFromTypes(types []uint16, name string) (_ cutStatus, ok bool, _ error) {
+	switch {
+	case slices.Contains(types, dns.TypeDS):
+		return 0, false, fmt.Errorf("%w: DS records for %s are both denied and asserted", errDNSSECBogus, name)
+	case slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeSOA):
+		return cutInsecure, true, nil
+	}
+	return cutNone, true, nil
+}
+
+// nsecCovers reports whether the span of NSEC record n, from its owner name
+// to its next domain name in canonical order, contains name.
+func nsecCovers(n *dns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	if compareNames(owner, name) >= 0 {
+		return false
+	}
+	if compareNames(next, owner) <= 0 {
+		// The last NSEC record of a zone points back to its apex.
+		return dns.IsSubDomain(next, name)
+	}
+	return compareNames(name, next) < 0
+}
+
+// compareNames compares DNS names a and b in canonical order (RFC 4034,
+// section 6.1).
+func compareNames(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(la), len(lb))
+}
+
+// parentName returns the name one label up from canonical name, or "." for
+// the root.
+func parentName(name string) string {
+	labels := dns.Split(name)
+	if len(labels) < 2 {
+		return "."
+	}
+	return name[labels[1]:]
+}
+
+// supportedDNSSECAlgorithm reports whether keys of DNSSEC algorithm alg can
+// be validated. Zones signed only with other algorithms are insecure.
+func supportedDNSSECAlgorithm(alg uint8) bool {
+	switch alg {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		return true
+	}
+	return false
+}
+
+// fetchKeys fetches the DNSKEY records of zone and validates them against
+// its DS records ds. It returns nil keys if zone is insecure because no DS
+// record has a supported algorithm and digest type.
+func (v *dnssecValidator) fetchKeys(ctx context.Context, exchange dnssecExchangeFunc, zone string, ds []*dns.DS) (keys []*dns.DNSKEY, ttl time.Duration, err error) {
+	ds = slices.DeleteFunc(slices.Clone(ds), func(d *dns.DS) bool {
+		switch d.DigestType {
+		case dns.SHA1, dns.SHA256, dns.SHA384:
+			return !supportedDNSSECAlgorithm(d.Algorithm)
+		}
+		return true
+	})
+	if len(ds) == 0 {
+		return nil, maxDNSSECCacheTTL, nil
+	}
+
+	resp, err := exchange(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying DNSKEY %s: %w", zone, err)
+	}
+	var set rrset
+	for _, s := range rrsetsOf(resp.Answer) {
+		if s.typ() == dns.TypeDNSKEY && s.name() == zone {
+			set = s
+		}
+	}
+	// Only the keys a DS record refers to may sign the DNSKEY RRset.
+	var entryKeys []*dns.DNSKEY
+	for _, rr := range set.rrs {
+		k := rr.(*dns.DNSKEY)
+		if k.Flags&dns.ZONE == 0 || k.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		keys = append(keys, k)
+		if slices.ContainsFunc(ds, func(d *dns.DS) bool { return dsMatchesKey(d, k) }) {
+			entryKeys = append(entryKeys, k)
+		}
+	}
+	if len(entryKeys) == 0 {
+		return nil, 0, fmt.Errorf("%w: no DNSKEY of %s matches its DS records", errDNSSECBogus, zone)
+	}
+	_, ttl, err = v.verifyRRset(set, zone, entryKeys)
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, ttl, nil
+}
+
+func dsMatchesKey(d *dns.DS, k *dns.DNSKEY) bool {
+	if d.KeyTag != k.KeyTag() || d.Algorithm != k.Algorithm {
+		return false
+	}
+	kd := k.ToDS(d.DigestType)
+	return kd != nil && strings.EqualFold(kd.Digest, d.Digest)
+}
+
+func (v *dnssecValidator) cachedCut(name string) (cutEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.cuts[name]
+	if !ok || !v.now().Before(e.expires) {
+		return cutEntry{}, false
+	}
+	return e, true
+}
+
+func (v *dnssecValidator) cacheCut(name string, e cutEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	e.expires = v.now().Add(ttl)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.cuts) >= maxDNSSECCacheEntries {
+		clear(v.cuts)
+	}
+	if v.cuts == nil {
+		v.cuts = make(map[string]cutEntry)
+	}
+	v.cuts[name] = e
+}
+
+// setDNSSECOK sets the DO bit in the EDNS0 OPT record of DNS message msg,
+// in place, asking for DNSSEC records in the response. It reports whether
+// the bit was already set. It does nothing if msg has no OPT record.
+func setDNSSECOK(msg []byte) (wasSet bool) {
+	start, _, ok := findOPT(msg)
+	if !ok {
+		return false
+	}
+	// The DO bit is the top bit of the flags in the OPT record's TTL,
+	// after the root name, TYPE, CLASS, extended RCODE and version.
+	flags := msg[start+7 : start+9]
+	wasSet = flags[0]&0x80 != 0
+	flags[0] |= 0x80
+	return wasSet
+}
+
+// dnsFlagSet reports whether flag is set in the flags word of DNS message
+// msg.
+func dnsFlagSet(msg []byte, flag uint16) bool {
+	if len(msg) < headerBytes {
+		return false
+	}
+	return binary.BigEndian.Uint16(msg[2:4])&flag != 0
+}
+
+// stripDNSSECRecords removes the RRSIG, NSEC and NSEC3 records that the
+// client didn't ask for from resp, for clients that don't set the DO bit
+// (RFC 4035, section 3.2.1).
+func stripDNSSECRecords(resp *dns.Msg) {
+	var qtype uint16
+	if len(resp.Question) > 0 {
+		qtype = resp.Question[0].Qtype
+	}
+	strip := func(rr dns.RR) bool {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			return t != qtype
+		}
+		return false
+	}
+	resp.Answer = slices.DeleteFunc(resp.Answer, strip)
+	resp.Ns = slices.DeleteFunc(resp.Ns, strip)
+	resp.Extra = slices.DeleteFunc(resp.Extra, strip)
+}
+
+// dnssecExchange returns a dnssecExchangeFunc sending queries to resolvers,
+// the upstream resolvers of the response being validated, in order until
+// one responds.
+func (f *forwarder) dnssecExchange(resolvers []resolverAndDelay) dnssecExchangeFunc {
+	return func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		q.SetEdns0(upstreamEDNSSize, true)
+		pkt, err := q.Pack()
+		if err != nil {
+			return nil, err
+		}
+		fq := &forwardQuery{
+			txid:           getTxID(pkt),
+			packet:         pkt,
+			family:         "udp",
+			clientUDPSize:  maxResponseBytes, // retry truncated responses over TCP
+			closeOnCtxDone: new(closePool),
+		}
+		defer fq.closeOnCtxDone.Close()
+
+		err = errors.New("no upstream resolvers")
+		for _, rr := range resolvers {
+			var res []byte
+			res, err = f.send(ctx, fq, rr)
+			if err != nil {
+				continue
+			}
+			resp := new(dns.Msg)
+			if err = resp.Unpack(res); err != nil {
+				continue
+			}
+			return resp, nil
+		}
+		return nil, err
+	}
+}
+
+// validateDNSSEC validates upstream response resp to fq, which must have
+// fq.dnssec set, using resolvers for the queries needed. It returns the
+// response to send to the client instead: with the AD bit set if resp is
+// secure and the client asked for it, without the records validation
+// leaves out, and without DNSSEC records unless the client set the DO bit.
+func (f *forwarder) validateDNSSEC(ctx context.Context, fq *forwardQuery, resp []byte, resolvers []resolverAndDelay) ([]byte, error) {
+	if truncatedFlagSet(resp) {
+		// A truncated response can't be validated; send only its
+		// header so the client retries over TCP.
+		return truncateResponse(resp, true), nil
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(resp); err != nil {
+		return nil, fmt.Errorf("%w: parsing response: %v", errDNSSECBogus, err)
+	}
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		// Errors such as REFUSED carry nothing to validate.
+		return resp, nil
+	}
+	secure, err := fq.dnssec.validate(ctx, m, f.dnssecExchange(resolvers))
+	if err != nil {
+		return nil, err
+	}
+	m.AuthenticatedData = secure && (fq.clientDO || fq.clientAD)
+	if !fq.clientDO {
+		stripDNSSECRecords(m)
+		if opt := m.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	}
+	m.Compress = true
+	return m.Pack()
+}
@@ -0,0 +1,297 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// denialProof is the validly signed NSEC and NSEC3 records of a response
+// from one zone, to prove the nonexistence of names or types in it with.
+type denialProof struct {
+	nsec  []*dns.NSEC
+	nsec3 []*dns.NSEC3
+}
+
+func (d *denialProof) add(rrs []dns.RR) {
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			d.nsec = append(d.nsec, rr)
+		case *dns.NSEC3:
+			d.nsec3 = append(d.nsec3, rr)
+		}
+	}
+}
+
+// proveDenial checks that d proves that name doesn't exist, if nxdomain, or
+// else that it has no records of type qtype, directly or through a
+// wildcard (RFC 4035, section 5.4, and RFC 5155, sections 8.4 to 8.7). It
+// returns an error wrapping errDNSSECBogus if d doesn't, and reports
+// whether the proof is secure: NSEC3 records with too many iterations or an
+// opt-out span covering name only prove the denial insecurely.
+func (d *denialProof) proveDenial(name string, qtype uint16, nxdomain bool) (secure bool, err error) {
+	switch {
+	case d != nil && len(d.nsec) > 0:
+		if err := nsecProveDenial(d.nsec, name, qtype, nxdomain); err != nil {
+			return false, err
+		}
+		return true, nil
+	case d != nil && len(d.nsec3) > 0:
+		return nsec3ProveDenial(d.nsec3, name, qtype, nxdomain)
+	}
+	what := "records of type " + dns.TypeToString[qtype] + " at " + name
+	if nxdomain {
+		what = name
+	}
+	return false, fmt.Errorf("%w: no NSEC or NSEC3 records proving there are no %s", errDNSSECBogus, what)
+}
+
+// proveWildcardAnswer checks that d proves that owner, which has an RRset
+// signed with sig as expanded from a wildcard, doesn't exist itself, so
+// that the wildcard was rightly expanded (RFC 4035, section 5.3.4, and RFC
+// 5155, section 8.8). It reports whether the proof is secure, like
+// proveDenial.
+func (d *denialProof) proveWildcardAnswer(owner string, sig *dns.RRSIG) (secure bool, err error) {
+	if d != nil {
+		for _, n := range d.nsec {
+			if nsecCovers(n, owner) && !nsecDelegatesAbove(n, owner) {
+				return true, nil
+			}
+		}
+		if len(d.nsec3) > 0 {
+			if !nsec3Usable(d.nsec3) {
+				return false, nil
+			}
+			nextCloser := lastLabels(owner, int(sig.Labels)+1)
+			for _, n := range d.nsec3 {
+				if n.Cover(nextCloser) {
+					return n.Flags&nsec3OptOut == 0, nil
+				}
+			}
+		}
+	}
+	return false, fmt.Errorf("%w: no proof that %s, expanded from a wildcard, doesn't exist", errDNSSECBogus, owner)
+}
+
+// isWildcardExpansion reports whether sig signs an RRset owned by owner as
+// expanded from a wildcard, by having fewer labels than owner (RFC 4034,
+// section 3.1.3).
+func isWildcardExpansion(owner string, sig *dns.RRSIG) bool {
+	n := dns.CountLabel(owner)
+	if strings.HasPrefix(owner, "*.") {
+		n--
+	}
+	return int(sig.Labels) < n
+}
+
+// nsecProveDenial is proveDenial for NSEC records.
+func nsecProveDenial(nsecs []*dns.NSEC, name string, qtype uint16, nxdomain bool) error {
+	if !nxdomain {
+		for _, n := range nsecs {
+			if dns.CanonicalName(n.Hdr.Name) == name {
+				return denyType(n.TypeBitMap, name, qtype)
+			}
+		}
+		// An NSEC record covering name and pointing to one of its
+		// descendants shows it's an empty non-terminal, with no records.
+		for _, n := range nsecs {
+			if nsecCovers(n, name) && !nsecDelegatesAbove(n, name) && isStrictSubDomain(name, n.NextDomain) {
+				return nil
+			}
+		}
+	}
+
+	closest, err := nsecNoName(nsecs, name)
+	if err != nil {
+		return err
+	}
+	wildcard := wildcardAt(closest)
+	if nxdomain {
+		for _, n := range nsecs {
+			if nsecCovers(n, wildcard) && !nsecDelegatesAbove(n, wildcard) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: no NSEC record proves there's no wildcard %s for %s", errDNSSECBogus, wildcard, name)
+	}
+	for _, n := range nsecs {
+		if dns.CanonicalName(n.Hdr.Name) == wildcard {
+			return denyType(n.TypeBitMap, wildcard, qtype)
+		}
+	}
+	return fmt.Errorf("%w: no NSEC record for %s or wildcard %s", errDNSSECBogus, name, wildcard)
+}
+
+// nsecNoName returns the closest encloser of name, its deepest existing
+// ancestor, from an NSEC record of nsecs proving that name doesn't exist. It
+// returns an error if there's none.
+func nsecNoName(nsecs []*dns.NSEC, name string) (closest string, err error) {
+	for _, n := range nsecs {
+		if !nsecCovers(n, name) || nsecDelegatesAbove(n, name) || isStrictSubDomain(name, n.NextDomain) {
+			continue
+		}
+		closest = commonAncestor(name, dns.CanonicalName(n.Hdr.Name))
+		if next := commonAncestor(name, dns.CanonicalName(n.NextDomain)); dns.CountLabel(next) > dns.CountLabel(closest) {
+			closest = next
+		}
+		return closest, nil
+	}
+	return "", fmt.Errorf("%w: no NSEC record proves %s doesn't exist", errDNSSECBogus, name)
+}
+
+// nsecDelegatesAbove reports whether NSEC record n is owned by a strict
+// ancestor of name that's a delegation to another zone, or a DNAME, so it
+// comes from a zone that doesn't have the authority to deny name (RFC 6840,
+// section 4.1).
+func nsecDelegatesAbove(n *dns.NSEC, name string) bool {
+	owner := dns.CanonicalName(n.Hdr.Name)
+	if owner == name || !dns.IsSubDomain(owner, name) {
+		return false
+	}
+	return isDelegation(n.TypeBitMap) || slices.Contains(n.TypeBitMap, dns.TypeDNAME)
+}
+
+// nsec3ProveDenial is proveDenial for NSEC3 records.
+func nsec3ProveDenial(nsec3s []*dns.NSEC3, name string, qtype uint16, nxdomain bool) (secure bool, err error) {
+	if !nsec3Usable(nsec3s) {
+		return false, nil
+	}
+	for _, n := range nsec3s {
+		if !n.Match(name) {
+			continue
+		}
+		if nxdomain {
+			return false, fmt.Errorf("%w: NSEC3 record %s asserts %s exists", errDNSSECBogus, n.Hdr.Name, name)
+		}
+		return true, denyType(n.TypeBitMap, name, qtype)
+	}
+
+	closest, optOut, err := nsec3ClosestEncloser(nsec3s, name)
+	if err != nil {
+		return false, err
+	}
+	wildcard := wildcardAt(closest)
+	if nxdomain {
+		for _, n := range nsec3s {
+			if n.Cover(wildcard) {
+				return !optOut, nil
+			}
+		}
+		return false, fmt.Errorf("%w: no NSEC3 record proves there's no wildcard %s for %s", errDNSSECBogus, wildcard, name)
+	}
+	for _, n := range nsec3s {
+		if n.Match(wildcard) {
+			return !optOut, denyType(n.TypeBitMap, wildcard, qtype)
+		}
+	}
+	if qtype == dns.TypeDS && optOut {
+		// name may be an unsigned delegation in an opt-out span, which
+		// has no NSEC3 record of its own (RFC 5155, section 8.6).
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: no NSEC3 record for %s or wildcard %s", errDNSSECBogus, name, wildcard)
+}
+
+// nsec3ClosestEncloser returns the closest encloser of name, its deepest
+// existing ancestor, from a closest encloser proof (RFC 5155, section 8.3)
+// in nsec3s: a record matching it and one covering the next closer name,
+// one label longer towards name. It reports whether the latter has the
+// opt-out flag.
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (closest string, optOut bool, err error) {
+	labels := dns.Split(name)
+	for i := 1; i <= len(labels); i++ {
+		closest = "."
+		if i < len(labels) {
+			closest = name[labels[i]:]
+		}
+		for _, n := range nsec3s {
+			if !n.Match(closest) {
+				continue
+			}
+			if isDelegation(n.TypeBitMap) || slices.Contains(n.TypeBitMap, dns.TypeDNAME) {
+				return "", false, fmt.Errorf("%w: closest encloser %s of %s is a delegation", errDNSSECBogus, closest, name)
+			}
+			nextCloser := name[labels[i-1]:]
+			for _, c := range nsec3s {
+				if c.Cover(nextCloser) {
+					return closest, c.Flags&nsec3OptOut != 0, nil
+				}
+			}
+			return "", false, fmt.Errorf("%w: no NSEC3 record covers %s, the next closer name of %s", errDNSSECBogus, nextCloser, name)
+		}
+	}
+	return "", false, fmt.Errorf("%w: no NSEC3 record matches an ancestor of %s", errDNSSECBogus, name)
+}
+
+// nsec3Usable reports whether nsec3s can prove anything securely: they must
+// use a known hash algorithm, with few enough iterations (RFC 9276).
+func nsec3Usable(nsec3s []*dns.NSEC3) bool {
+	for _, n := range nsec3s {
+		if n.Hash != dns.SHA1 || n.Iterations > maxNSEC3Iterations {
+			return false
+		}
+	}
+	return true
+}
+
+// denyType checks that types, those present at name according to an NSEC
+// or NSEC3 record owned by it, prove that name has no records of type
+// qtype.
+func denyType(types []uint16, name string, qtype uint16) error {
+	switch {
+	case slices.Contains(types, qtype):
+		return fmt.Errorf("%w: %s records at %s are both denied and asserted", errDNSSECBogus, dns.TypeToString[qtype], name)
+	case slices.Contains(types, dns.TypeCNAME):
+		return fmt.Errorf("%w: %s has a CNAME record, missing from the response", errDNSSECBogus, name)
+	case qtype != dns.TypeDS && isDelegation(types):
+		// The parent side of a zone cut can only deny DS records; the
+		// child zone has the authority over the others.
+		return fmt.Errorf("%w: %s records at %s denied by the parent side of a delegation", errDNSSECBogus, dns.TypeToString[qtype], name)
+	}
+	return nil
+}
+
+// isDelegation reports whether types, those present at a name according to
+// an NSEC or NSEC3 record, are those of the parent side of a zone cut.
+func isDelegation(types []uint16) bool {
+	return slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeSOA)
+}
+
+// wildcardAt returns the wildcard name immediately below name.
+func wildcardAt(name string) string {
+	if name == "." {
+		return "*."
+	}
+	return "*." + name
+}
+
+// isStrictSubDomain reports whether child is a descendant of parent, other
+// than parent itself.
+func isStrictSubDomain(parent, child string) bool {
+	return dns.CanonicalName(parent) != dns.CanonicalName(child) && dns.IsSubDomain(parent, child)
+}
+
+// commonAncestor returns the deepest common ancestor of canonical names a
+// and b.
+func commonAncestor(a, b string) string {
+	return lastLabels(a, dns.CompareDomainName(a, b))
+}
+
+// lastLabels returns the ancestor of canonical name with its last n labels,
+// or name itself if it has no more than n.
+func lastLabels(name string, n int) string {
+	labels := dns.Split(name)
+	switch {
+	case n <= 0:
+		return "."
+	case n >= len(labels):
+		return name
+	}
+	return name[labels[len(labels)-n]:]
+}
@@ -0,0 +1,491 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var dnssecTestNow = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// testZone is a DNSSEC-signed zone with a single key.
+type testZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign returns rrs followed by their signature by z, valid for an hour
+// around dnssecTestNow.
+func (z *testZone) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	t.Helper()
+	h := rrs[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(dnssecTestNow.Add(time.Hour).Unix()),
+		Inception:  uint32(dnssecTestNow.Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func (z *testZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// dnssecTestTree is a signed root zone delegating to the signed com. zone,
+// which delegates to the signed example.com. zone and to the unsigned
+// insecure.com. zone.
+type dnssecTestTree struct {
+	root, com, example *testZone
+	responses          map[string]*dns.Msg // "name type" => response
+	queries            int
+}
+
+func newDNSSECTestTree(t *testing.T) *dnssecTestTree {
+	tr := &dnssecTestTree{
+		root:      newTestZone(t, "."),
+		com:       newTestZone(t, "com."),
+		example:   newTestZone(t, "example.com."),
+		responses: map[string]*dns.Msg{},
+	}
+	for _, z := range []*testZone{tr.root, tr.com, tr.example} {
+		tr.add(z.name, dns.TypeDNSKEY, z.sign(t, z.key), nil)
+	}
+	tr.add("com.", dns.TypeDS, tr.root.sign(t, tr.com.ds()), nil)
+	tr.add("example.com.", dns.TypeDS, tr.com.sign(t, tr.example.ds()), nil)
+	tr.add("insecure.com.", dns.TypeDS, nil,
+		tr.com.sign(t, mustRR(t, "insecure.com. 3600 IN NSEC jnsecure.com. NS RRSIG NSEC")))
+	tr.add("www.example.com.", dns.TypeDS, nil,
+		tr.example.sign(t, mustRR(t, "www.example.com. 3600 IN NSEC example.com. A RRSIG NSEC")))
+	return tr
+}
+
+func (tr *dnssecTestTree) add(name string, qtype uint16, answer, ns []dns.RR) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Response = true
+	m.Answer = answer
+	m.Ns = ns
+	tr.responses[name+" "+dns.TypeToString[qtype]] = m
+}
+
+func (tr *dnssecTestTree) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	tr.queries++
+	m, ok := tr.responses[name+" "+dns.TypeToString[qtype]]
+	if !ok {
+		return nil, errors.New("no such test response")
+	}
+	return m.Copy(), nil
+}
+
+func (tr *dnssecTestTree) validator() *dnssecValidator {
+	return &dnssecValidator{
+		anchors: map[string][]*dns.DS{".": {tr.root.ds()}},
+		now:     func() time.Time { return dnssecTestNow },
+	}
+}
+
+func responseWith(qname string, qtype uint16, answer ...dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(qname, qtype)
+	m.Response = true
+	m.Answer = answer
+	return m
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	tr := newDNSSECTestTree(t)
+	wwwA := mustRR(t, "www.example.com. 300 IN A 192.0.2.1")
+	signedWWW := tr.example.sign(t, wwwA)
+
+	tampered := tr.example.sign(t, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))
+	tampered[0].(*dns.A).A = net.ParseIP("192.0.2.66")
+
+	expired := tr.example.sign(t, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))
+	expired[1].(*dns.RRSIG).Expiration = uint32(dnssecTestNow.Add(-time.Minute).Unix())
+
+	tests := []struct {
+		name       string
+		resp       *dns.Msg
+		wantSecure bool
+		wantBogus  bool
+	}{
+		{
+			name:       "signed",
+			resp:       responseWith("www.example.com.", dns.TypeA, signedWWW...),
+			wantSecure: true,
+		},
+		{
+			name:      "tampered",
+			resp:      responseWith("www.example.com.", dns.TypeA, tampered...),
+			wantBogus: true,
+		},
+		{
+			name:      "expired",
+			resp:      responseWith("www.example.com.", dns.TypeA, expired...),
+			wantBogus: true,
+		},
+		{
+			name:      "stripped_signature",
+			resp:      responseWith("www.example.com.", dns.TypeA, wwwA),
+			wantBogus: true,
+		},
+		{
+			name:      "signed_by_wrong_zone",
+			resp:      responseWith("www.example.com.", dns.TypeA, tr.com.sign(t, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))...),
+			wantBogus: true,
+		},
+		{
+			name: "unsigned_delegation",
+			resp: responseWith("host.insecure.com.", dns.TypeA, mustRR(t, "host.insecure.com. 300 IN A 192.0.2.2")),
+		},
+		{
+			name:      "empty_from_signed_zone",
+			resp:      responseWith("www.example.com.", dns.TypeAAAA),
+			wantBogus: true,
+		},
+		{
+			name: "empty_from_unsigned_zone",
+			resp: responseWith("host.insecure.com.", dns.TypeAAAA),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secure, err := tr.validator().validate(context.Background(), tt.resp, tr.exchange)
+			if tt.wantBogus {
+				if !errors.Is(err, errDNSSECBogus) {
+					t.Fatalf("validate = %v, %v; want bogus", secure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secure != tt.wantSecure {
+				t.Errorf("secure = %v; want %v", secure, tt.wantSecure)
+			}
+		})
+	}
+}
+
+// addNoCut adds a signed response to tr proving there's no zone cut at
+// name, in example.com.
+func (tr *dnssecTestTree) addNoCut(t *testing.T, name string) {
+	name = dns.CanonicalName(name)
+	tr.add(name, dns.TypeDS, nil,
+		tr.example.sign(t, mustRR(t, name+" 3600 IN NSEC "+name+" A RRSIG NSEC")))
+}
+
+// exampleNSEC3 returns NSEC3 records for example.com., in which only the
+// apex and www exist, as a chain of records owned by their hashes, with
+// their types.
+func exampleNSEC3(t *testing.T, optOut bool) (apex, www *dns.NSEC3) {
+	hash := func(name string) string { return dns.HashName(name, dns.SHA1, 0, "") }
+	rec := func(owner, next string, types ...uint16) *dns.NSEC3 {
+		n := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: owner + ".example.com.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+			Hash:       dns.SHA1,
+			HashLength: 20,
+			NextDomain: next,
+			TypeBitMap: types,
+		}
+		if optOut {
+			n.Flags = nsec3OptOut
+		}
+		return n
+	}
+	ha, hw := hash("example.com."), hash("www.example.com.")
+	apex = rec(ha, hw, dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM)
+	www = rec(hw, ha, dns.TypeA, dns.TypeRRSIG)
+	return apex, www
+}
+
+func TestDNSSECValidateDenial(t *testing.T) {
+	tr := newDNSSECTestTree(t)
+	for _, name := range []string{"nope.example.com.", "m.example.com.", "a.example.com.", "*.example.com."} {
+		tr.addNoCut(t, name)
+	}
+	apexNSEC := func() []dns.RR {
+		return tr.example.sign(t, mustRR(t, "example.com. 3600 IN NSEC www.example.com. NS SOA RRSIG NSEC DNSKEY"))
+	}
+	wwwNSEC := func() []dns.RR {
+		return tr.example.sign(t, mustRR(t, "www.example.com. 3600 IN NSEC example.com. A RRSIG NSEC"))
+	}
+	apex3, www3 := exampleNSEC3(t, false)
+	apex3OptOut, www3OptOut := exampleNSEC3(t, true)
+	for _, n := range []*dns.NSEC3{apex3, www3} {
+		tr.addNoCut(t, n.Hdr.Name)
+	}
+	sign3 := func(ns ...*dns.NSEC3) []dns.RR {
+		var rrs []dns.RR
+		for _, n := range ns {
+			rrs = append(rrs, tr.example.sign(t, dns.Copy(n))...)
+		}
+		return rrs
+	}
+
+	// wildcardA returns the A record at owner expanded from
+	// *.example.com., with its signature.
+	wildcardA := func(owner string) []dns.RR {
+		rrs := tr.example.sign(t, mustRR(t, "*.example.com. 300 IN A 192.0.2.3"))
+		for _, rr := range rrs {
+			rr.Header().Name = owner
+		}
+		return rrs
+	}
+
+	denial := func(qname string, qtype uint16, nxdomain bool, ns ...[]dns.RR) *dns.Msg {
+		m := responseWith(qname, qtype)
+		if nxdomain {
+			m.Rcode = dns.RcodeNameError
+		}
+		for _, rrs := range ns {
+			m.Ns = append(m.Ns, rrs...)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name       string
+		resp       *dns.Msg
+		wantSecure bool
+		wantBogus  bool
+	}{
+		{
+			name:       "nxdomain_nsec",
+			resp:       denial("nope.example.com.", dns.TypeA, true, apexNSEC()),
+			wantSecure: true,
+		},
+		{
+			// A validly signed NSEC record replayed for a name it
+			// doesn't cover.
+			name:      "nxdomain_nsec_not_covering",
+			resp:      denial("nope.example.com.", dns.TypeA, true, wwwNSEC()),
+			wantBogus: true,
+		},
+		{
+			name:      "nxdomain_nsec_name_exists",
+			resp:      denial("www.example.com.", dns.TypeA, true, wwwNSEC()),
+			wantBogus: true,
+		},
+		{
+			name: "nxdomain_nsec_wildcard_not_denied",
+			resp: denial("nope.example.com.", dns.TypeA, true,
+				tr.example.sign(t, mustRR(t, "m.example.com. 3600 IN NSEC www.example.com. A RRSIG NSEC"))),
+			wantBogus: true,
+		},
+		{
+			name:       "nodata_nsec",
+			resp:       denial("www.example.com.", dns.TypeAAAA, false, wwwNSEC()),
+			wantSecure: true,
+		},
+		{
+			name:      "nodata_nsec_type_exists",
+			resp:      denial("www.example.com.", dns.TypeA, false, wwwNSEC()),
+			wantBogus: true,
+		},
+		{
+			name:      "nodata_nsec_not_matching",
+			resp:      denial("www.example.com.", dns.TypeAAAA, false, apexNSEC()),
+			wantBogus: true,
+		},
+		{
+			name:      "nodata_soa_only",
+			resp:      denial("www.example.com.", dns.TypeAAAA, false, tr.example.sign(t, mustRR(t, "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300"))),
+			wantBogus: true,
+		},
+		{
+			name:       "nxdomain_nsec3",
+			resp:       denial("nope.example.com.", dns.TypeA, true, sign3(apex3, www3)),
+			wantSecure: true,
+		},
+		{
+			name:      "nxdomain_nsec3_no_closest_encloser",
+			resp:      denial("nope.example.com.", dns.TypeA, true, sign3(www3)),
+			wantBogus: true,
+		},
+		{
+			name:      "nxdomain_nsec3_name_exists",
+			resp:      denial("www.example.com.", dns.TypeA, true, sign3(apex3, www3)),
+			wantBogus: true,
+		},
+		{
+			name: "nxdomain_nsec3_opt_out",
+			resp: denial("nope.example.com.", dns.TypeA, true, sign3(apex3OptOut, www3OptOut)),
+		},
+		{
+			name:       "nodata_nsec3",
+			resp:       denial("www.example.com.", dns.TypeAAAA, false, sign3(www3)),
+			wantSecure: true,
+		},
+		{
+			name:      "nodata_nsec3_type_exists",
+			resp:      denial("www.example.com.", dns.TypeA, false, sign3(apex3, www3)),
+			wantBogus: true,
+		},
+		{
+			name:       "wildcard_answer",
+			resp:       denial("a.example.com.", dns.TypeA, false, apexNSEC()),
+			wantSecure: true,
+		},
+		{
+			name:      "wildcard_answer_without_proof",
+			resp:      denial("a.example.com.", dns.TypeA, false, wwwNSEC()),
+			wantBogus: true,
+		},
+	}
+	for _, tt := range tests {
+		if strings.HasPrefix(tt.name, "wildcard_") {
+			tt.resp.Answer = wildcardA("a.example.com.")
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			secure, err := tr.validator().validate(context.Background(), tt.resp, tr.exchange)
+			if tt.wantBogus {
+				if !errors.Is(err, errDNSSECBogus) {
+					t.Fatalf("validate = %v, %v; want bogus", secure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secure != tt.wantSecure {
+				t.Errorf("secure = %v; want %v", secure, tt.wantSecure)
+			}
+		})
+	}
+}
+
+func TestDNSSECValidateDropsUnvalidated(t *testing.T) {
+	tr := newDNSSECTestTree(t)
+	resp := responseWith("www.example.com.", dns.TypeA, tr.example.sign(t, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))...)
+	resp.Ns = []dns.RR{mustRR(t, "example.com. 300 IN NS ns.attacker.test.")}
+	resp.Extra = []dns.RR{mustRR(t, "ns.attacker.test. 300 IN A 192.0.2.99")}
+	resp.SetEdns0(1232, true)
+
+	secure, err := tr.validator().validate(context.Background(), resp, tr.exchange)
+	if err != nil || !secure {
+		t.Fatalf("validate = %v, %v; want secure", secure, err)
+	}
+	if len(resp.Answer) != 2 {
+		t.Errorf("answer = %v; want A and RRSIG records", resp.Answer)
+	}
+	if len(resp.Ns) != 0 {
+		t.Errorf("authority = %v; want none", resp.Ns)
+	}
+	if len(resp.Extra) != 1 || resp.IsEdns0() == nil {
+		t.Errorf("additional = %v; want only OPT", resp.Extra)
+	}
+}
+
+func TestDNSSECValidatorCachesKeys(t *testing.T) {
+	tr := newDNSSECTestTree(t)
+	v := tr.validator()
+	for range 2 {
+		resp := responseWith("www.example.com.", dns.TypeA, tr.example.sign(t, mustRR(t, "www.example.com. 300 IN A 192.0.2.1"))...)
+		if _, err := v.validate(context.Background(), resp, tr.exchange); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// DNSKEY for ., com. and example.com., and DS for com., example.com.
+	// and www.example.com.
+	if tr.queries != 6 {
+		t.Errorf("queries = %d; want 6", tr.queries)
+	}
+}
+
+func TestDNSSECNoTrustAnchors(t *testing.T) {
+	tr := newDNSSECTestTree(t)
+	v := tr.validator()
+	v.anchorsErr = errors.New("bad file")
+	resp := responseWith("host.insecure.com.", dns.TypeA, mustRR(t, "host.insecure.com. 300 IN A 192.0.2.2"))
+	if _, err := v.validate(context.Background(), resp, tr.exchange); !errors.Is(err, errDNSSECBogus) {
+		t.Errorf("validate error = %v; want bogus", err)
+	}
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	anchors, err := parseTrustAnchors(strings.NewReader(rootTrustAnchors), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 1 || len(anchors["."]) != 2 {
+		t.Errorf("root anchors = %v; want 2 DS records for .", anchors)
+	}
+
+	z := newTestZone(t, "corp.example.")
+	anchors, err = parseTrustAnchors(strings.NewReader(z.key.String()+"\n"), "anchors.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := anchors["corp.example."]
+	if len(got) != 1 || !dsMatchesKey(got[0], z.key) {
+		t.Errorf("DNSKEY anchor = %v; want DS of %v", got, z.key)
+	}
+
+	if _, err := parseTrustAnchors(strings.NewReader("example. 300 IN A 192.0.2.1\n"), "bad.zone"); err == nil {
+		t.Error("parsing A record as trust anchor succeeded; want error")
+	}
+}
+
+func TestSetDNSSECOK(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(1232, false)
+	pkt, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setDNSSECOK(pkt) {
+		t.Error("setDNSSECOK reported DO already set")
+	}
+	if !setDNSSECOK(pkt) {
+		t.Error("setDNSSECOK didn't report DO set")
+	}
+	var got dns.Msg
+	if err := got.Unpack(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if opt := got.IsEdns0(); opt == nil || !opt.Do() || opt.UDPSize() != 1232 {
+		t.Errorf("OPT = %v; want DO set and size unchanged", opt)
+	}
+}
@@ -36,6 +36,7 @@
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/race"
+	"tailscale.com/util/supervisor"
 	"tailscale.com/version"
 )
 
@@ -113,14 +114,15 @@ func getRCode(packet []byte) dns.RCode {
 	return dns.RCode(packet[3] & 0x0F)
 }
 
+// optFixedBytes is the size of an OPT record with no option codes.
+const optFixedBytes = 11
+
 // clampEDNSSize attempts to limit the maximum EDNS response size. This is not
 // an exhaustive solution, instead only easy cases are currently handled in the
 // interest of speed and reduced complexity. Only OPT records at the very end of
 // the message with no option codes are addressed.
 // TODO: handle more situations if we discover that they happen often
 func clampEDNSSize(packet []byte, maxSize uint16) {
-	// optFixedBytes is the size of an OPT record with no option codes.
-	const optFixedBytes = 11
 	const edns0Version = 0
 
 	if len(packet) < headerBytes+optFixedBytes {
@@ -165,6 +167,159 @@ func clampEDNSSize(packet []byte, maxSize uint16) {
 	binary.BigEndian.PutUint16(opt[3:5], maxSize)
 }
 
+// upstreamEDNSSize is the EDNS0 UDP payload size advertised to upstream
+// resolvers. It's the size recommended by DNS Flag Day 2020 to avoid IP
+// fragmentation, as fragments are dropped on some networks, failing large
+// (e.g. DNSSEC or SRV) responses. Larger responses are retried over TCP.
+const upstreamEDNSSize = 1232
+
+// minUDPResponseBytes is the largest UDP response a client that doesn't
+// use EDNS0 accepts, per RFC 1035.
+const minUDPResponseBytes = 512
+
+// skipName returns the offset just past the possibly compressed domain name
+// at offset off of DNS message msg, or -1 if it's malformed.
+func skipName(msg []byte, off int) int {
+	for off < len(msg) {
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1
+		case l&0xC0 == 0xC0: // compression pointer
+			if off+2 > len(msg) {
+				return -1
+			}
+			return off + 2
+		case l&0xC0 != 0:
+			return -1
+		}
+		off += 1 + l
+	}
+	return -1
+}
+
+// questionsEnd returns the offset just past the question section of DNS
+// message msg, or -1 if it's malformed.
+func questionsEnd(msg []byte) int {
+	if len(msg) < headerBytes {
+		return -1
+	}
+	off := headerBytes
+	for range binary.BigEndian.Uint16(msg[4:6]) {
+		off = skipName(msg, off)
+		if off < 0 || off+4 > len(msg) {
+			return -1
+		}
+		off += 4 // type and class
+	}
+	return off
+}
+
+// findOPT returns the offsets of the start and end of the EDNS0 OPT
+// pseudo-record in the additional section of DNS message msg. It reports
+// false if msg has none or is malformed.
+func findOPT(msg []byte) (start, end int, ok bool) {
+	off := questionsEnd(msg)
+	if off < 0 {
+		return 0, 0, false
+	}
+	an := int(binary.BigEndian.Uint16(msg[6:8]))
+	ns := int(binary.BigEndian.Uint16(msg[8:10]))
+	ar := int(binary.BigEndian.Uint16(msg[10:12]))
+	for i := range an + ns + ar {
+		rrStart := off
+		off = skipName(msg, off)
+		if off < 0 || off+10 > len(msg) {
+			return 0, 0, false
+		}
+		typ := dns.Type(binary.BigEndian.Uint16(msg[off : off+2]))
+		rrEnd := off + 10 + int(binary.BigEndian.Uint16(msg[off+8:off+10]))
+		if rrEnd > len(msg) {
+			return 0, 0, false
+		}
+		// The OPT record's name is the root, a single zero byte.
+		if i >= an+ns && typ == dns.TypeOPT && off == rrStart+1 {
+			return rrStart, rrEnd, true
+		}
+		off = rrEnd
+	}
+	return 0, 0, false
+}
+
+// appendOPT appends an EDNS0 OPT record with no options advertising size
+// to DNS message msg, which must have no OPT record.
+func appendOPT(msg []byte, size uint16) []byte {
+	binary.BigEndian.PutUint16(msg[10:12], binary.BigEndian.Uint16(msg[10:12])+1)
+	msg = append(msg, 0) // root name
+	msg = binary.BigEndian.AppendUint16(msg, uint16(dns.TypeOPT))
+	msg = binary.BigEndian.AppendUint16(msg, size)
+	return append(msg,
+		0,    // extended RCODE
+		0,    // EDNS0 version
+		0, 0, // flags
+		0, 0, // RDLEN
+	)
+}
+
+// ednsForUpstream returns DNS query q as sent to upstream resolvers, with an
+// EDNS0 OPT record advertising at most upstreamEDNSSize, so that large
+// responses aren't truncated to 512 bytes nor fragmented. It adds an OPT
+// record if q has none, as reported by added, in which case the response
+// must be passed through stripOPT. It also returns the size of the largest
+// UDP response the client accepts.
+//
+// q may be modified in place.
+func ednsForUpstream(q []byte) (upstream []byte, clientSize int, added bool) {
+	if questionsEnd(q) < 0 {
+		// Malformed; forward it as is.
+		return q, minUDPResponseBytes, false
+	}
+	start, _, ok := findOPT(q)
+	if !ok {
+		up := make([]byte, len(q), len(q)+optFixedBytes)
+		copy(up, q)
+		return appendOPT(up, upstreamEDNSSize), minUDPResponseBytes, true
+	}
+	sizeBytes := q[start+3 : start+5] // the OPT record's CLASS
+	size := binary.BigEndian.Uint16(sizeBytes)
+	if size > upstreamEDNSSize {
+		binary.BigEndian.PutUint16(sizeBytes, upstreamEDNSSize)
+	}
+	return q, min(max(int(size), minUDPResponseBytes), maxResponseBytes), false
+}
+
+// stripOPT returns DNS message msg without its EDNS0 OPT record, if any.
+func stripOPT(msg []byte) []byte {
+	start, end, ok := findOPT(msg)
+	if !ok {
+		return msg
+	}
+	out := make([]byte, 0, len(msg)-(end-start))
+	out = append(out, msg[:start]...)
+	out = append(out, msg[end:]...)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])-1)
+	return out
+}
+
+// truncateResponse returns a copy of DNS response msg with only its header
+// and question section, with the truncated flag set, telling the client to
+// retry over TCP. If edns, it includes an OPT record, as the query had one.
+// It returns msg unchanged if it's malformed.
+func truncateResponse(msg []byte, edns bool) []byte {
+	qEnd := questionsEnd(msg)
+	if qEnd < 0 {
+		return msg
+	}
+	out := make([]byte, qEnd, qEnd+optFixedBytes)
+	copy(out, msg)
+	binary.BigEndian.PutUint16(out[2:4], binary.BigEndian.Uint16(out[2:4])|dnsFlagTruncated)
+	clear(out[6:12]) // answer, authority, and additional counts
+	if edns {
+		out = appendOPT(out, maxResponseBytes)
+	}
+	return out
+}
+
 // dnsForwarderFailing should be raised when the forwarder is unable to reach the
 // upstream resolvers. This is a high severity warning as it results in "no internet".
 // This warning must be cleared when the forwarder is working again.
@@ -208,6 +363,10 @@ type forwarder struct {
 	dialer  *tsdial.Dialer
 	health  *health.Tracker // always non-nil
 
+	// supervisor recovers from panics while forwarding a query, which then
+	// fails like it would on a network error.
+	supervisor *supervisor.Supervisor
+
 	controlKnobs *controlknobs.Knobs // or nil
 
 	ctx       context.Context    // good until Close
@@ -231,6 +390,9 @@ type forwarder struct {
 	// resolver lookup.
 	cloudHostFallback []resolverAndDelay
 
+	// dnssec validates the DNSSEC signatures of responses, if non-nil.
+	dnssec *dnssecValidator
+
 	// missingUpstreamRecovery, if non-nil, is set called when a SERVFAIL is
 	// returned due to missing upstream resolvers.
 	//
@@ -248,6 +410,7 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 		linkSel:                 linkSel,
 		dialer:                  dialer,
 		health:                  health,
+		supervisor:              supervisor.New(logf, health),
 		controlKnobs:            knobs,
 		missingUpstreamRecovery: func() {},
 	}
@@ -382,6 +545,26 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 	f.cloudHostFallback = cloudHostFallback
 }
 
+// setDNSSEC sets whether responses from upstream resolvers are validated
+// with DNSSEC.
+func (f *forwarder) setDNSSEC(validate bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case !validate:
+		f.dnssec = nil
+		f.health.SetHealthy(dnssecValidationFailing)
+	case f.dnssec == nil:
+		f.dnssec = newDNSSECValidator(f.logf)
+	}
+}
+
+func (f *forwarder) dnssecValidator() *dnssecValidator {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dnssec
+}
+
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
 
 func (f *forwarder) packetListener(ip netip.Addr) (nettype.PacketListenerWithNetIP, error) {
@@ -554,12 +737,13 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 			return resp, nil
 		}
 
-		// If this is a UDP query, return it regardless of whether the
-		// response is truncated or not; the client can retry
+		// If this is a UDP query from a client that doesn't accept
+		// responses larger than what the upstream DNS server could send
+		// over UDP, return the truncated response; the client can retry
 		// communicating with tailscaled over TCP. There's no point
 		// falling back to TCP for a truncated query if we can't return
 		// the results to the client.
-		if isUDPQuery {
+		if isUDPQuery && fq.clientUDPSize <= upstreamEDNSSize {
 			return resp, nil
 		}
 
@@ -569,8 +753,8 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 			return resp, nil
 		}
 
-		// This is a TCP query from the client, and the UDP response
-		// from the upstream DNS server is truncated; map this to an
+		// The client can take a larger response than the truncated UDP
+		// response from the upstream DNS server; map this to an
 		// error to cause our retry helper to immediately kick off the
 		// TCP retry.
 		explicitRetry.Store(true)
@@ -843,6 +1027,20 @@ type forwardQuery struct {
 	packet []byte
 	family string // "tcp" or "udp"
 
+	// clientUDPSize is the size of the largest UDP response the client
+	// accepts, if family is "udp".
+	clientUDPSize int
+	// addedOPT is whether packet has an EDNS0 OPT record added to the
+	// client's query by ednsForUpstream, to be stripped from the response.
+	addedOPT bool
+
+	// dnssec, if non-nil, validates the response, which must be passed
+	// through forwarder.validateDNSSEC. clientDO and clientAD are whether
+	// the client's query had the DO and AD bits set.
+	dnssec   *dnssecValidator
+	clientDO bool
+	clientAD bool
+
 	// closeOnCtxDone lets send register values to Close if the
 	// caller's ctx expires. This avoids send from allocating its
 	// own waiting goroutine to interrupt the ReadFrom, as memory
@@ -856,6 +1054,20 @@ type forwardQuery struct {
 	// ...
 }
 
+// responseForClient returns the upstream response resp as returned to the
+// client, undoing ednsForUpstream and truncating it if it's too large for
+// a UDP client (or was truncated on the way here, possibly mid-record).
+func (fq *forwardQuery) responseForClient(resp []byte) []byte {
+	if fq.addedOPT {
+		resp = stripOPT(resp)
+	}
+	if fq.family == "udp" && (len(resp) > fq.clientUDPSize || truncatedFlagSet(resp)) {
+		metricDNSFwdTruncatedToClient.Add(1)
+		resp = truncateResponse(resp, !fq.addedOPT)
+	}
+	return resp
+}
+
 // forwardWithDestChan forwards the query to all upstream nameservers
 // and waits for the first response.
 //
@@ -901,8 +1113,6 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		fl.addName(string(domain))
 	}
 
-	clampEDNSSize(query.bs, maxResponseBytes)
-
 	if len(resolvers) == 0 {
 		resolvers = f.resolvers(domain)
 		if len(resolvers) == 0 {
@@ -937,10 +1147,16 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	fq := &forwardQuery{
 		txid:           getTxID(query.bs),
-		packet:         query.bs,
 		family:         query.family,
 		closeOnCtxDone: new(closePool),
 	}
+	fq.packet, fq.clientUDPSize, fq.addedOPT = ednsForUpstream(query.bs)
+	if v := f.dnssecValidator(); v != nil && !dnsFlagSet(query.bs, dnsFlagCheckingDisabled) {
+		// Clients setting the CD bit do their own validation.
+		fq.dnssec = v
+		fq.clientAD = dnsFlagSet(query.bs, dnsFlagAuthenticData)
+		fq.clientDO = setDNSSECOK(fq.packet)
+	}
 	defer fq.closeOnCtxDone.Close()
 
 	resc := make(chan []byte, 1) // it's fine buffered or not
@@ -956,7 +1172,13 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 					return
 				}
 			}
-			resb, err := f.send(ctx, fq, *rr)
+			var resb []byte
+			var err error
+			if perr := f.supervisor.Do(supervisor.DNSForwarder, func() {
+				resb, err = f.send(ctx, fq, *rr)
+			}); perr != nil {
+				err = perr
+			}
 			if err != nil {
 				err = fmt.Errorf("resolving using %q: %w", rr.name.Addr, err)
 				select {
@@ -977,6 +1199,24 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			if fq.dnssec != nil {
+				var err error
+				v, err = f.validateDNSSEC(ctx, fq, v, resolvers)
+				if err != nil {
+					metricDNSFwdDNSSECBogus.Add(1)
+					f.logf("%v", err)
+					f.health.SetUnhealthy(dnssecValidationFailing, health.Args{health.ArgError: err.Error()})
+					res, err := servfailResponse(query)
+					if err != nil {
+						f.logf("building servfail response: %v", err)
+						return nil
+					}
+					v = res.bs
+				} else {
+					f.health.SetHealthy(dnssecValidationFailing)
+				}
+			}
+			v = fq.responseForClient(v)
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
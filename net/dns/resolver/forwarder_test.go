@@ -5,6 +5,7 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -269,6 +270,10 @@ func FuzzClampEDNSSize(f *testing.F) {
 type testDNSServerOptions struct {
 	SkipUDP bool
 	SkipTCP bool
+
+	// UDPResponse, if non-nil, is the response sent over UDP instead
+	// of the response given to runDNSServer.
+	UDPResponse []byte
 }
 
 func runDNSServer(tb testing.TB, opts *testDNSServerOptions, response []byte, onRequest func(bool, []byte)) (port uint16) {
@@ -364,9 +369,13 @@ func runDNSServer(tb testing.TB, opts *testDNSServerOptions, response []byte, on
 		}()
 	}
 
+	udpResponse := response
+	if opts != nil && opts.UDPResponse != nil {
+		udpResponse = opts.UDPResponse
+	}
 	handleUDP := func(addr netip.AddrPort, req []byte) {
 		onRequest(false, req)
-		if _, err := udpLn.WriteToUDPAddrPort(response, addr); err != nil {
+		if _, err := udpLn.WriteToUDPAddrPort(udpResponse, addr); err != nil {
 			tb.Logf("error writing response: %v", err)
 		}
 	}
@@ -658,3 +667,219 @@ func TestForwarderTCPFallbackError(t *testing.T) {
 		t.Errorf("wanted errServerFailure, got: %v", err)
 	}
 }
+
+// makeDNSMessage returns a DNS message for an A query of domain with the
+// given header, numAnswers answers, and an EDNS0 OPT record advertising
+// ednsSize if it's non-zero.
+func makeDNSMessage(tb testing.TB, domain string, hdr dns.Header, numAnswers int, ednsSize uint16) []byte {
+	tb.Helper()
+	name := dns.MustNewName(domain)
+	builder := dns.NewBuilder(nil, hdr)
+	builder.StartQuestions()
+	builder.Question(dns.Question{
+		Name:  name,
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	})
+	builder.StartAnswers()
+	for i := range numAnswers {
+		builder.AResource(dns.ResourceHeader{
+			Name:  name,
+			Class: dns.ClassINET,
+			TTL:   300,
+		}, dns.AResource{
+			A: [4]byte{127, 0, 0, byte(i)},
+		})
+	}
+	if ednsSize != 0 {
+		builder.StartAdditionals()
+		var rh dns.ResourceHeader
+		if err := rh.SetEDNS0(int(ednsSize), dns.RCodeSuccess, false); err != nil {
+			tb.Fatal(err)
+		}
+		builder.OPTResource(rh, dns.OPTResource{})
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return msg
+}
+
+// ednsSize returns the EDNS0 UDP payload size advertised by DNS message
+// msg, or 0 if it has no OPT record.
+func ednsSize(tb testing.TB, msg []byte) uint16 {
+	tb.Helper()
+	var p dns.Parser
+	if _, err := p.Start(msg); err != nil {
+		tb.Fatal(err)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		tb.Fatal(err)
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			return 0
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if h.Type == dns.TypeOPT {
+			return uint16(h.Class)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestEDNSForUpstream(t *testing.T) {
+	const domain = "example.com."
+	tests := []struct {
+		name           string
+		ednsSize       uint16 // of the client's query; 0 for none
+		wantUpstream   uint16
+		wantClientSize int
+		wantAdded      bool
+	}{
+		{"no-edns", 0, upstreamEDNSSize, minUDPResponseBytes, true},
+		{"small", 512, 512, 512, false},
+		{"tiny", 100, 100, minUDPResponseBytes, false},
+		{"large", 4096, upstreamEDNSSize, maxResponseBytes, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := makeDNSMessage(t, domain, dns.Header{ID: 123, RecursionDesired: true}, 0, tt.ednsSize)
+			orig := bytes.Clone(q)
+			up, clientSize, added := ednsForUpstream(q)
+			if got := ednsSize(t, up); got != tt.wantUpstream {
+				t.Errorf("upstream EDNS size = %d; want %d", got, tt.wantUpstream)
+			}
+			if clientSize != tt.wantClientSize {
+				t.Errorf("clientSize = %d; want %d", clientSize, tt.wantClientSize)
+			}
+			if added != tt.wantAdded {
+				t.Errorf("added = %v; want %v", added, tt.wantAdded)
+			}
+			if added {
+				if got := stripOPT(up); !bytes.Equal(got, orig) {
+					t.Errorf("stripOPT(upstream) = %x; want %x", got, orig)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateResponse(t *testing.T) {
+	const domain = "example.com."
+	resp := makeDNSMessage(t, domain, dns.Header{ID: 123, Response: true}, 50, 0)
+	for _, edns := range []bool{false, true} {
+		got := truncateResponse(resp, edns)
+		var p dns.Parser
+		h, err := p.Start(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.Truncated || h.ID != 123 {
+			t.Errorf("edns=%v: header = %+v; want truncated with ID 123", edns, h)
+		}
+		q, err := p.Question()
+		if err != nil || q.Name.String() != domain {
+			t.Errorf("edns=%v: question = %v, %v", edns, q, err)
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.AllAnswers(); err != nil {
+			t.Fatal(err)
+		} else if n := binary.BigEndian.Uint16(got[6:8]); n != 0 {
+			t.Errorf("edns=%v: %d answers; want 0", edns, n)
+		}
+		wantSize := uint16(0)
+		if edns {
+			wantSize = maxResponseBytes
+		}
+		if got := ednsSize(t, got); got != wantSize {
+			t.Errorf("edns=%v: EDNS size = %d; want %d", edns, got, wantSize)
+		}
+	}
+}
+
+// Test that UDP queries are forwarded advertising upstreamEDNSSize, that
+// truncated responses are retried over TCP if the client can take them,
+// and that responses too large for the client are truncated.
+func TestForwarderEDNS(t *testing.T) {
+	enableDebug(t)
+
+	const domain = "edns.tailscale.com."
+	// mediumResponse fits in maxResponseBytes but not upstreamEDNSSize.
+	mediumResponse := makeDNSMessage(t, domain, dns.Header{Response: true}, 100, 0)
+	if n := len(mediumResponse); n <= upstreamEDNSSize || n > maxResponseBytes {
+		t.Fatalf("len(mediumResponse) = %d", n)
+	}
+	truncatedResponse := truncateResponse(mediumResponse, false)
+
+	tests := []struct {
+		name      string
+		ednsSize  uint16 // of the client's query; 0 for none
+		wantTCP   bool   // whether the forwarder should retry over TCP
+		wantTrunc bool   // whether the client should get a truncated response
+	}{
+		{"no-edns", 0, false, true},
+		{"edns-small", 1024, false, true},
+		{"edns-large", 4096, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sawTCP atomic.Bool
+			opts := &testDNSServerOptions{UDPResponse: truncatedResponse}
+			port := runDNSServer(t, opts, mediumResponse, func(isTCP bool, gotRequest []byte) {
+				if isTCP {
+					sawTCP.Store(true)
+					return
+				}
+				if got, want := ednsSize(t, gotRequest), min(upstreamEDNSSize, cmp.Or(tt.ednsSize, upstreamEDNSSize)); got != want {
+					t.Errorf("upstream query EDNS size = %d; want %d", got, want)
+				}
+			})
+
+			netMon, err := netmon.New(t.Logf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dialer tsdial.Dialer
+			dialer.SetNetMon(netMon)
+			fwd := newForwarder(t.Logf, netMon, nil, &dialer, new(health.Tracker), nil)
+
+			q := makeDNSMessage(t, domain, dns.Header{RecursionDesired: true}, 0, tt.ednsSize)
+			rr := resolverAndDelay{
+				name: &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)},
+			}
+			ch := make(chan packet, 1)
+			if err := fwd.forwardWithDestChan(context.Background(), packet{q, "udp", netip.AddrPort{}}, ch, rr); err != nil {
+				t.Fatal(err)
+			}
+			resp := (<-ch).bs
+
+			if got := sawTCP.Load(); got != tt.wantTCP {
+				t.Errorf("retried over TCP = %v; want %v", got, tt.wantTCP)
+			}
+			if got := truncatedFlagSet(resp); got != tt.wantTrunc {
+				t.Errorf("response truncated = %v; want %v", got, tt.wantTrunc)
+			}
+			if !tt.wantTrunc && !bytes.Equal(resp, mediumResponse) {
+				t.Errorf("got %d byte response; want the %d byte TCP response", len(resp), len(mediumResponse))
+			}
+			if tt.ednsSize == 0 && ednsSize(t, resp) != 0 {
+				t.Error("response to query without EDNS has an OPT record")
+			}
+		})
+	}
+}
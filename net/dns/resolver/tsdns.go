@@ -77,6 +77,10 @@ type Config struct {
 	// LocalDomains is a list of DNS name suffixes that should not be
 	// routed to upstream resolvers.
 	LocalDomains []dnsname.FQDN
+	// ValidateDNSSEC is whether responses to queries forwarded to the
+	// resolvers in Routes are validated with DNSSEC. Responses failing
+	// validation are replaced with SERVFAIL.
+	ValidateDNSSEC bool
 }
 
 // WriteToBufioWriter write a debug version of c for logs to w, omitting
@@ -102,6 +106,9 @@ func (c *Config) WriteToBufioWriter(w *bufio.Writer) {
 	if arpa > 0 {
 		fmt.Fprintf(w, "+%darpa", arpa)
 	}
+	if c.ValidateDNSSEC {
+		w.WriteString(" ValidateDNSSEC")
+	}
 	if c := cloudenv.Get(); c != "" {
 		fmt.Fprintf(w, ", cloud=%q", string(c))
 	}
@@ -276,6 +283,7 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	}
 
 	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setDNSSEC(cfg.ValidateDNSSEC)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -1364,6 +1372,10 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdErrorType = clientmetric.NewCounter("dns_query_fwd_error_type")
 	metricDNSFwdTruncated = clientmetric.NewCounter("dns_query_fwd_truncated")
 
+	metricDNSFwdTruncatedToClient = clientmetric.NewCounter("dns_query_fwd_truncated_to_client")
+
+	metricDNSFwdDNSSECBogus = clientmetric.NewCounter("dns_query_fwd_dnssec_bogus")
+
 	metricDNSFwdUDP            = clientmetric.NewCounter("dns_query_fwd_udp")       // on entry
 	metricDNSFwdUDPWrote       = clientmetric.NewCounter("dns_query_fwd_udp_wrote") // sent UDP packet
 	metricDNSFwdUDPErrorWrite  = clientmetric.NewCounter("dns_query_fwd_udp_error_write")
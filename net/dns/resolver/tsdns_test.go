@@ -594,7 +594,7 @@ func TestDelegate(t *testing.T) {
 		{
 			"smalltxt",
 			dnspacket("small.txt.", dns.TypeTXT, 8000),
-			dnsResponse{txt: smallTXT, rcode: dns.RCodeSuccess, requestEdns: true, requestEdnsSize: maxResponseBytes},
+			dnsResponse{txt: smallTXT, rcode: dns.RCodeSuccess, requestEdns: true, requestEdnsSize: upstreamEDNSSize},
 		},
 		{
 			"smalltxtedns",
@@ -615,7 +615,7 @@ func TestDelegate(t *testing.T) {
 				txt:              medTXT,
 				rcode:            dns.RCodeSuccess,
 				requestEdns:      true,
-				requestEdnsSize:  2000,
+				requestEdnsSize:  upstreamEDNSSize,
 				responseEdns:     true,
 				responseEdnsSize: 1500,
 			},
@@ -627,7 +627,7 @@ func TestDelegate(t *testing.T) {
 				txt:              largeTXT,
 				rcode:            dns.RCodeSuccess,
 				requestEdns:      true,
-				requestEdnsSize:  maxResponseBytes,
+				requestEdnsSize:  upstreamEDNSSize,
 				responseEdns:     true,
 				responseEdnsSize: maxResponseBytes,
 			},
@@ -0,0 +1,161 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// regionHistoryHalfLife is how long it takes the connection counts
+	// in a RegionHistory to decay by half. It's long so that a region
+	// that failed yesterday is still treated with some suspicion today.
+	regionHistoryHalfLife = 24 * time.Hour
+
+	// regionHistoryMinFailures is the (decayed) number of connection
+	// failures below which a region's failure rate is ignored, so that
+	// a single dropped connection doesn't count against a region.
+	regionHistoryMinFailures = 3
+
+	// regionHistoryMinSamples is the number of latency samples below
+	// which a region's latency variance is ignored.
+	regionHistoryMinSamples = 10
+
+	// regionLatencyAlpha is the weight of each new latency sample in the
+	// moving average and variance of a region's latency.
+	regionLatencyAlpha = 0.1
+
+	// regionFailureWeight is how much a region's failure rate (0 to 1)
+	// adds to the multiplier applied to its latency. A region that drops
+	// every connection is treated as 3x as far away.
+	regionFailureWeight = 2
+)
+
+// RegionHistory records how reliable each DERP region has been, so that
+// home region selection can avoid regions that are nearby but flaky. It
+// tracks connection failures and the variance of probe latencies, and is
+// meant to be persisted across restarts with its MarshalJSON and
+// UnmarshalJSON methods.
+//
+// The zero value is ready for use. It's safe for concurrent use.
+type RegionHistory struct {
+	mu      sync.Mutex
+	regions map[int]*regionStats
+}
+
+// regionStats is the history of one DERP region. Its fields are exported
+// for JSON.
+type regionStats struct {
+	Connects    float64   // decayed number of successful connections
+	Failures    float64   // decayed number of failed or dropped connections
+	Updated     time.Time // when Connects and Failures were last decayed
+	Samples     int       // number of latency samples, up to regionHistoryMinSamples
+	LatencyMean float64   // moving average of latency, in seconds
+	LatencyVar  float64   // moving variance of latency, in seconds squared
+}
+
+func (h *RegionHistory) statsLocked(regionID int, now time.Time) *regionStats {
+	if h.regions == nil {
+		h.regions = make(map[int]*regionStats)
+	}
+	st, ok := h.regions[regionID]
+	if !ok {
+		st = &regionStats{Updated: now}
+		h.regions[regionID] = st
+	}
+	if elapsed := now.Sub(st.Updated); elapsed > 0 {
+		f := math.Exp2(-float64(elapsed) / float64(regionHistoryHalfLife))
+		st.Connects *= f
+		st.Failures *= f
+		st.Updated = now
+	}
+	return st
+}
+
+// NoteConnect records that a connection to the DERP region was
+// established at time now.
+func (h *RegionHistory) NoteConnect(regionID int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statsLocked(regionID, now).Connects++
+}
+
+// NoteConnectFailure records that connecting to the DERP region failed, or
+// that an established connection to it broke, at time now.
+func (h *RegionHistory) NoteConnectFailure(regionID int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statsLocked(regionID, now).Failures++
+}
+
+// noteLatency records a probe latency of d to the DERP region at time now.
+func (h *RegionHistory) noteLatency(regionID int, d time.Duration, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.statsLocked(regionID, now)
+	x := d.Seconds()
+	if st.Samples == 0 {
+		st.LatencyMean = x
+		st.LatencyVar = 0
+	} else {
+		diff := x - st.LatencyMean
+		st.LatencyMean += regionLatencyAlpha * diff
+		st.LatencyVar = (1 - regionLatencyAlpha) * (st.LatencyVar + regionLatencyAlpha*diff*diff)
+	}
+	if st.Samples < regionHistoryMinSamples {
+		st.Samples++
+	}
+}
+
+// latencyMultiplier returns the factor, at least 1, by which the DERP
+// region's latency should be scaled when comparing it to other regions, to
+// account for how unreliable it has been.
+func (h *RegionHistory) latencyMultiplier(regionID int, now time.Time) float64 {
+	if h == nil {
+		return 1
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.regions[regionID]; !ok {
+		return 1
+	}
+	st := h.statsLocked(regionID, now)
+	m := 1.0
+	if st.Failures >= regionHistoryMinFailures {
+		m += regionFailureWeight * st.Failures / (st.Failures + st.Connects)
+	}
+	if st.Samples >= regionHistoryMinSamples && st.LatencyMean > 0 {
+		// The coefficient of variation: how large the latency's
+		// standard deviation is compared to its average.
+		m += min(math.Sqrt(st.LatencyVar)/st.LatencyMean, 1)
+	}
+	return m
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h *RegionHistory) MarshalJSON() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return json.Marshal(h.regions)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, replacing the history in h.
+func (h *RegionHistory) UnmarshalJSON(b []byte) error {
+	var regions map[int]*regionStats
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return err
+	}
+	for id, st := range regions {
+		if st == nil {
+			delete(regions, id)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.regions = regions
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestRegionHistory(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	var h RegionHistory
+	if got := h.latencyMultiplier(1, now); got != 1 {
+		t.Errorf("multiplier of unknown region = %v; want 1", got)
+	}
+
+	h.NoteConnect(1, now)
+	h.NoteConnectFailure(1, now)
+	h.NoteConnectFailure(1, now)
+	if got := h.latencyMultiplier(1, now); got != 1 {
+		t.Errorf("multiplier after 2 failures = %v; want 1", got)
+	}
+	h.NoteConnectFailure(1, now)
+	if got, want := h.latencyMultiplier(1, now), 2.5; got != want {
+		t.Errorf("multiplier after 3 of 4 connections failed = %v; want %v", got, want)
+	}
+
+	// Steady latencies don't count against a region, but jittery ones do.
+	for i := range 20 {
+		h.noteLatency(2, 20*time.Millisecond, now)
+		h.noteLatency(3, time.Duration(10+20*(i%2))*time.Millisecond, now)
+	}
+	if got := h.latencyMultiplier(2, now); got != 1 {
+		t.Errorf("multiplier of steady region = %v; want 1", got)
+	}
+	if got := h.latencyMultiplier(3, now); got <= 1.2 {
+		t.Errorf("multiplier of jittery region = %v; want > 1.2", got)
+	}
+
+	// The history survives a round trip through JSON.
+	bs, err := json.Marshal(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h2 RegionHistory
+	if err := json.Unmarshal(bs, &h2); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 2, 3} {
+		if got, want := h2.latencyMultiplier(id, now), h.latencyMultiplier(id, now); got != want {
+			t.Errorf("after JSON round trip, multiplier of region %d = %v; want %v", id, got, want)
+		}
+	}
+
+	// Failures are forgotten over time.
+	if got := h.latencyMultiplier(1, now.Add(3*regionHistoryHalfLife)); got != 1 {
+		t.Errorf("multiplier after 3 half-lives = %v; want 1", got)
+	}
+}
+
+func TestPreferredDERPAvoidsFlakyRegion(t *testing.T) {
+	now := time.Unix(123, 0)
+	c := &Client{
+		TimeNow: func() time.Time { return now },
+		History: new(RegionHistory),
+	}
+	for range 3 {
+		c.History.NoteConnect(1, now)
+		c.History.NoteConnectFailure(1, now)
+	}
+	r := &Report{RegionLatency: map[int]time.Duration{
+		1: 10 * time.Millisecond,
+		2: 15 * time.Millisecond,
+	}}
+	rs := &reportState{c: c, start: now}
+	c.addReportHistoryAndSetPreferredDERP(rs, r, (&tailcfg.DERPMap{}).View())
+	if r.PreferredDERP != 2 {
+		t.Errorf("PreferredDERP = %v; want 2, not the nearer but flaky region 1", r.PreferredDERP)
+	}
+}
@@ -37,6 +37,7 @@
 	"tailscale.com/net/stun"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
 	"tailscale.com/types/opt"
@@ -208,6 +209,11 @@ type Client struct {
 	// TimeNow, if non-nil, is used instead of time.Now.
 	TimeNow func() time.Time
 
+	// Clock, if non-nil, is used for the timers that pace and time out
+	// probes, and for the current time if TimeNow is nil.
+	// If nil, the real clock is used.
+	Clock tstime.Clock
+
 	// SendPacket is required to send a packet to the specified address. For
 	// convenience it shares a signature with WriteToUDPAddrPort.
 	SendPacket func([]byte, netip.AddrPort) (int, error)
@@ -230,6 +236,17 @@ type Client struct {
 	// If false, the default net.Resolver will be used, with no caching.
 	UseDNSCache bool
 
+	// History, if non-nil, records the reliability of DERP regions, and
+	// is used to prefer reliable regions over flaky ones when picking
+	// PreferredDERP. The Client records the latencies it measures in it;
+	// connection results are recorded by its owner.
+	History *RegionHistory
+
+	// DisableIPv4 and DisableIPv6, if true, prevent the Client from
+	// probing over IPv4 or IPv6 respectively, as if the machine had no
+	// connectivity of that family.
+	DisableIPv4, DisableIPv6 bool
+
 	// For tests
 	testEnoughRegions      int
 	testCaptivePortalDelay time.Duration
@@ -556,7 +573,7 @@ type reportState struct {
 	report   *Report                            // to be returned by GetReport
 	inFlight map[stun.TxID]func(netip.AddrPort) // called without c.mu held
 	gotEP4   netip.AddrPort
-	timers   []*time.Timer
+	timers   []tstime.TimerController
 }
 
 func (rs *reportState) anyUDP() bool {
@@ -636,7 +653,7 @@ func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort
 		if !rs.incremental {
 			timeout *= 2
 		}
-		rs.timers = append(rs.timers, time.AfterFunc(timeout, rs.stopProbes))
+		rs.timers = append(rs.timers, rs.c.clock().AfterFunc(timeout, rs.stopProbes))
 	}
 
 	switch {
@@ -815,13 +832,21 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 	}
 
 	ifState := c.NetMon.InterfaceState()
+	if c.DisableIPv4 || c.DisableIPv6 {
+		st := *ifState
+		st.HaveV4 = st.HaveV4 && !c.DisableIPv4
+		st.HaveV6 = st.HaveV6 && !c.DisableIPv6
+		ifState = &st
+	}
 
 	// See if IPv6 works at all, or if it's been hard disabled at the
 	// OS level.
-	v6udp, err := nettype.MakePacketListenerWithNetIP(netns.Listener(c.logf, c.NetMon)).ListenPacket(ctx, "udp6", "[::1]:0")
-	if err == nil {
-		rs.report.OSHasIPv6 = true
-		v6udp.Close()
+	if !c.DisableIPv6 {
+		v6udp, err := nettype.MakePacketListenerWithNetIP(netns.Listener(c.logf, c.NetMon)).ListenPacket(ctx, "udp6", "[::1]:0")
+		if err == nil {
+			rs.report.OSHasIPv6 = true
+			v6udp.Close()
+		}
 	}
 
 	if !c.SkipExternalNetwork && c.PortMapper != nil {
@@ -844,7 +869,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 		ch := make(chan struct{})
 		captivePortalDone = ch
 
-		tmr := time.AfterFunc(c.captivePortalDelay(), func() {
+		tmr := c.clock().AfterFunc(c.captivePortalDelay(), func() {
 			defer close(ch)
 			d := captivedetection.NewDetector(c.logf)
 			found := d.Detect(ctx, c.NetMon, dm, preferredDERP)
@@ -883,11 +908,11 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 		}(probeSet)
 	}
 
-	stunTimer := time.NewTimer(stunProbeTimeout)
+	stunTimer, stunTimerC := c.clock().NewTimer(stunProbeTimeout)
 	defer stunTimer.Stop()
 
 	select {
-	case <-stunTimer.C:
+	case <-stunTimerC:
 	case <-ctx.Done():
 	case <-wg.DoneChan():
 		// All of our probes finished, so if we have >0 responses, we
@@ -1244,7 +1269,14 @@ func (c *Client) timeNow() time.Time {
 	if c.TimeNow != nil {
 		return c.TimeNow()
 	}
-	return time.Now()
+	return c.clock().Now()
+}
+
+func (c *Client) clock() tstime.Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return tstime.StdClock{}
 }
 
 const (
@@ -1309,6 +1341,18 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 		}
 	}
 
+	// Likewise, scale them by how unreliable each region has been, so a
+	// nearby region that keeps dropping connections loses out to one
+	// that's a bit further away.
+	if c.History != nil {
+		for regionID, d := range r.RegionLatency {
+			c.History.noteLatency(regionID, d, now)
+		}
+		for regionID, d := range bestRecent {
+			bestRecent[regionID] = time.Duration(float64(d) * c.History.latencyMultiplier(regionID, now))
+		}
+	}
+
 	// Then, pick which currently-alive DERP server from the
 	// current report has the best latency over the past maxAge.
 	var (
@@ -1323,6 +1367,7 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 		if score := scores.Get(regionID); score > 0 {
 			d = time.Duration(float64(d) * score)
 		}
+		d = time.Duration(float64(d) * c.History.latencyMultiplier(regionID, now))
 
 		if regionID == prevDERP {
 			oldRegionCurLatency = d
@@ -1404,9 +1449,9 @@ func (rs *reportState) runProbe(ctx context.Context, dm *tailcfg.DERPMap, probe
 	}
 
 	if probe.delay > 0 {
-		delayTimer := time.NewTimer(probe.delay)
+		delayTimer, delayTimerC := c.clock().NewTimer(probe.delay)
 		select {
-		case <-delayTimer.C:
+		case <-delayTimerC:
 		case <-ctx.Done():
 			delayTimer.Stop()
 			return
@@ -1427,11 +1472,11 @@ func (rs *reportState) runProbe(ctx context.Context, dm *tailcfg.DERPMap, probe
 	txID := stun.NewTxID()
 	req := stun.Request(txID)
 
-	sent := time.Now() // after DNS lookup above
+	sent := c.clock().Now() // after DNS lookup above
 
 	rs.mu.Lock()
 	rs.inFlight[txID] = func(ipp netip.AddrPort) {
-		rs.addNodeLatency(node, ipp, time.Since(sent))
+		rs.addNodeLatency(node, ipp, c.clock().Since(sent))
 		cancelSet() // abort other nodes in this set
 	}
 	rs.mu.Unlock()
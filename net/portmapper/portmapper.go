@@ -22,19 +22,28 @@
 	"go4.org/mem"
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/envknob"
+	"tailscale.com/feature"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/sockstats"
+	"tailscale.com/omit"
 	"tailscale.com/syncs"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/supervisor"
 )
 
 var disablePortMapperEnv = envknob.RegisterBool("TS_DISABLE_PORTMAPPER")
 
+func init() {
+	if !omit.Portmapper {
+		feature.Register("portmapper")
+	}
+}
+
 // DebugKnobs contains debug configuration that can be provided when creating a
 // Client. The zero value is valid for use.
 type DebugKnobs struct {
@@ -58,7 +67,7 @@ type DebugKnobs struct {
 }
 
 func (k *DebugKnobs) disableAll() bool {
-	if disablePortMapperEnv() {
+	if omit.Portmapper || disablePortMapperEnv() {
 		return true
 	}
 	if k.DisableAll != nil {
@@ -90,8 +99,9 @@ type Client struct {
 	ipAndGateway func() (gw, ip netip.Addr, ok bool)
 	onChange     func() // or nil
 	debug        DebugKnobs
-	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
-	testUPnPPort uint16 // if non-zero, uPnPPort to use for tests
+	supervisor   *supervisor.Supervisor // or nil
+	testPxPPort  uint16                 // if non-zero, pxpPort to use for tests
+	testUPnPPort uint16                 // if non-zero, uPnPPort to use for tests
 
 	mu sync.Mutex // guards following, and all fields thereof
 
@@ -238,6 +248,13 @@ func (c *Client) SetGatewayLookupFunc(f func() (gw, myIP netip.Addr, ok bool)) {
 	c.ipAndGateway = f
 }
 
+// SetSupervisor sets the Supervisor that recovers from panics while
+// creating port mappings in the background. It must be called before the
+// client is used. If not called, such panics aren't recovered from.
+func (c *Client) SetSupervisor(s *supervisor.Supervisor) {
+	c.supervisor = s
+}
+
 // NoteNetworkDown should be called when the network has transitioned to a down state.
 // It's too late to release port mappings at this point (the user might've just turned off
 // their wifi), but we can make sure we invalidate mappings for later when the network
@@ -453,7 +470,7 @@ func (c *Client) GetCachedMappingOrStartCreatingOne() (external netip.AddrPort,
 func (c *Client) maybeStartMappingLocked() {
 	if !c.runningCreate {
 		c.runningCreate = true
-		go c.createMapping()
+		go c.supervisor.Do(supervisor.PortMapper, c.createMapping)
 	}
 }
 
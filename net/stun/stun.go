@@ -19,6 +19,7 @@
 	attrNumFingerprint   = 0x8028
 	attrMappedAddress    = 0x0001
 	attrXorMappedAddress = 0x0020
+	attrResponseOrigin   = 0x802b // RFC 5780 Section 7.3
 	// This alternative attribute type is not
 	// mentioned in the RFC, but the shift into
 	// the "comprehension-optional" range seems
@@ -153,17 +154,41 @@ func foreachAttr(b []byte, fn func(attrType uint16, a []byte) error) error {
 
 // Response generates a binding response.
 func Response(txID TxID, addrPort netip.AddrPort) []byte {
-	addr := addrPort.Addr()
+	return ResponseWithOptions(txID, addrPort, ResponseOptions{})
+}
 
-	var fam byte
-	if addr.Is4() {
-		fam = 1
-	} else if addr.Is6() {
-		fam = 2
-	} else {
+// ResponseOptions are optional attributes that ResponseWithOptions adds to a
+// binding response.
+type ResponseOptions struct {
+	// Origin, if valid, is the address the response is sent from. It is
+	// included as a RESPONSE-ORIGIN attribute (RFC 5780 Section 7.3).
+	Origin netip.AddrPort
+
+	// Fingerprint, if true, appends a FINGERPRINT attribute (RFC 5389
+	// Section 15.5) so clients can distinguish the response from other
+	// protocols multiplexed on the same port.
+	Fingerprint bool
+}
+
+// ResponseWithOptions generates a binding response with the optional
+// attributes described by opts. It returns nil if addrPort is not a valid
+// IPv4 or IPv6 address.
+func ResponseWithOptions(txID TxID, addrPort netip.AddrPort, opts ResponseOptions) []byte {
+	addr := addrPort.Addr()
+	if !addr.Is4() && !addr.Is6() {
 		return nil
 	}
-	attrsLen := 8 + addr.BitLen()/8
+	origin := opts.Origin
+	if origin.IsValid() && !origin.Addr().Is4() && !origin.Addr().Is6() {
+		origin = netip.AddrPort{}
+	}
+	attrsLen := addrAttrLen(addr)
+	if origin.IsValid() {
+		attrsLen += addrAttrLen(origin.Addr())
+	}
+	if opts.Fingerprint {
+		attrsLen += lenFingerprint
+	}
 	b := make([]byte, 0, headerLen+attrsLen)
 
 	// Header
@@ -172,12 +197,12 @@ func Response(txID TxID, addrPort netip.AddrPort) []byte {
 	b = append(b, magicCookie...)
 	b = append(b, txID[:]...)
 
-	// Attributes (well, one)
+	// XOR-MAPPED-ADDRESS, RFC5389 Section 15.2.
 	b = appendU16(b, attrXorMappedAddress)
 	b = appendU16(b, uint16(4+addr.BitLen()/8))
 	b = append(b,
 		0, // unused byte
-		fam)
+		addrFamily(addr))
 	b = appendU16(b, addrPort.Port()^0x2112) // first half of magicCookie
 	ipa := addr.As16()
 	for i, o := range ipa[16-addr.BitLen()/8:] {
@@ -187,15 +212,52 @@ func Response(txID TxID, addrPort netip.AddrPort) []byte {
 			b = append(b, o^txID[i-len(magicCookie)])
 		}
 	}
+
+	// RESPONSE-ORIGIN, RFC5780 Section 7.3. Same layout as MAPPED-ADDRESS.
+	if origin.IsValid() {
+		oa := origin.Addr()
+		b = appendU16(b, attrResponseOrigin)
+		b = appendU16(b, uint16(4+oa.BitLen()/8))
+		b = append(b, 0, addrFamily(oa))
+		b = appendU16(b, origin.Port())
+		b = append(b, oa.AsSlice()...)
+	}
+
+	// FINGERPRINT, RFC5389 Section 15.5. The header length above already
+	// accounts for it, as the RFC requires.
+	if opts.Fingerprint {
+		fp := fingerPrint(b)
+		b = appendU16(b, attrNumFingerprint)
+		b = appendU16(b, 4)
+		b = appendU32(b, fp)
+	}
 	return b
 }
 
+// addrAttrLen returns the length, including the 4 byte attribute header, of
+// an address attribute carrying addr.
+func addrAttrLen(addr netip.Addr) int {
+	return 4 + 4 + addr.BitLen()/8
+}
+
+// addrFamily returns the STUN address family number of addr.
+func addrFamily(addr netip.Addr) byte {
+	if addr.Is4() {
+		return 0x01
+	}
+	return 0x02
+}
+
 // ParseResponse parses a successful binding response STUN packet.
 // The IP address is extracted from the XOR-MAPPED-ADDRESS attribute.
+//
+// If the response carries a FINGERPRINT attribute, it must be the last
+// attribute and must match, or ErrWrongFingerprint is returned.
 func ParseResponse(b []byte) (tID TxID, addr netip.AddrPort, err error) {
 	if !Is(b) {
 		return tID, netip.AddrPort{}, ErrNotSTUN
 	}
+	full := b
 	copy(tID[:], b[8:8+len(tID)])
 	if b[0] != 0x01 || b[1] != 0x01 {
 		return tID, netip.AddrPort{}, ErrNotSuccessResponse
@@ -207,8 +269,14 @@ func ParseResponse(b []byte) (tID TxID, addr netip.AddrPort, err error) {
 	} else if len(b) > attrsLen {
 		b = b[:attrsLen] // trim trailing packet bytes
 	}
+	msg := full[:headerLen+attrsLen]
 
 	var fallbackAddr netip.AddrPort
+	var (
+		sawFP   bool
+		gotFP   uint32
+		afterFP bool // an attribute followed FINGERPRINT
+	)
 
 	// Read through the attributes.
 	// The the addr+port reported by XOR-MAPPED-ADDRESS
@@ -216,7 +284,16 @@ func ParseResponse(b []byte) (tID TxID, addr netip.AddrPort, err error) {
 	// present but the STUN server responds with
 	// MAPPED-ADDRESS we fall back to it.
 	if err := foreachAttr(b, func(attrType uint16, attr []byte) error {
+		if sawFP {
+			afterFP = true
+		}
 		switch attrType {
+		case attrNumFingerprint:
+			if len(attr) != 4 {
+				return ErrMalformedAttrs
+			}
+			sawFP = true
+			gotFP = binary.BigEndian.Uint32(attr)
 		case attrXorMappedAddress, attrXorMappedAddressAlt:
 			ipSlice, port, err := xorMappedAddress(tID, attr)
 			if err != nil {
@@ -239,6 +316,14 @@ func ParseResponse(b []byte) (tID TxID, addr netip.AddrPort, err error) {
 	}); err != nil {
 		return TxID{}, netip.AddrPort{}, err
 	}
+	if sawFP {
+		if afterFP {
+			return tID, netip.AddrPort{}, ErrMalformedAttrs
+		}
+		if gotFP != fingerPrint(msg[:len(msg)-lenFingerprint]) {
+			return tID, netip.AddrPort{}, ErrWrongFingerprint
+		}
+	}
 
 	if addr.IsValid() {
 		return tID, addr, nil
@@ -299,3 +299,42 @@ func TestAttrOrderForXdpDERP(t *testing.T) {
 		t.Fatal("unexpected software attr value")
 	}
 }
+
+func TestResponseWithOptions(t *testing.T) {
+	tx := stun.NewTxID()
+	addr := netip.MustParseAddrPort("1.2.3.4:5678")
+	tests := []struct {
+		name string
+		opts stun.ResponseOptions
+	}{
+		{"none", stun.ResponseOptions{}},
+		{"fingerprint", stun.ResponseOptions{Fingerprint: true}},
+		{"origin4", stun.ResponseOptions{Origin: netip.MustParseAddrPort("5.6.7.8:3478")}},
+		{"origin6-fingerprint", stun.ResponseOptions{
+			Origin:      netip.MustParseAddrPort("[fd7a::1]:3478"),
+			Fingerprint: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := stun.ResponseWithOptions(tx, addr, tt.opts)
+			tx2, addr2, err := stun.ParseResponse(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx2 != tx {
+				t.Errorf("TxID = %x; want %x", tx2, tx)
+			}
+			if addr2 != addr {
+				t.Errorf("addr = %v; want %v", addr2, addr)
+			}
+			if !tt.opts.Fingerprint {
+				return
+			}
+			res[len(res)-1] ^= 0xff
+			if _, _, err := stun.ParseResponse(res); err != stun.ErrWrongFingerprint {
+				t.Errorf("corrupted fingerprint: err = %v; want %v", err, stun.ErrWrongFingerprint)
+			}
+		})
+	}
+}
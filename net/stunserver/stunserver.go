@@ -17,6 +17,8 @@
 
 	"tailscale.com/metrics"
 	"tailscale.com/net/stun"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/util/lru"
 )
 
 var (
@@ -27,6 +29,7 @@
 	stunNotSTUN     = stunDisposition.Get("not_stun")
 	stunWriteError  = stunDisposition.Get("write_error")
 	stunSuccess     = stunDisposition.Get("success")
+	stunRateLimited = stunDisposition.Get("rate_limited")
 
 	stunIPv4 = stunAddrFamily.Get("ipv4")
 	stunIPv6 = stunAddrFamily.Get("ipv6")
@@ -38,9 +41,22 @@ func init() {
 	expvar.Publish("stun", stats)
 }
 
+// maxRateLimitedClients is the maximum number of per-source-IP rate limiters
+// a STUNServer retains. The least recently seen clients are evicted first.
+const maxRateLimitedClients = 64 << 10
+
 type STUNServer struct {
-	ctx context.Context // ctx signals service shutdown
-	pc  *net.UDPConn    // pc is the UDP listener
+	ctx    context.Context // ctx signals service shutdown
+	pc     *net.UDPConn    // pc is the UDP listener
+	origin netip.AddrPort  // origin is sent as RESPONSE-ORIGIN, if valid
+
+	// limit and burst configure per-source-IP rate limiting.
+	// A zero limit disables rate limiting.
+	limit rate.Limit
+	burst int
+	// limiters holds the per-source-IP limiters. It's only accessed
+	// from the Serve goroutine.
+	limiters *lru.Cache[netip.Addr, *rate.Limiter]
 }
 
 // New creates a new STUN server. The server is shutdown when ctx is done.
@@ -48,6 +64,32 @@ func New(ctx context.Context) *STUNServer {
 	return &STUNServer{ctx: ctx}
 }
 
+// SetRateLimit limits each source IP address to r requests per second with
+// bursts of up to burst requests. Requests over the limit are dropped
+// without a response. A zero r disables rate limiting, which is the
+// default. It must be called before Serve.
+func (s *STUNServer) SetRateLimit(r rate.Limit, burst int) {
+	s.limit = r
+	s.burst = max(burst, 1)
+	s.limiters = nil
+	if r > 0 {
+		s.limiters = &lru.Cache[netip.Addr, *rate.Limiter]{MaxEntries: maxRateLimitedClients}
+	}
+}
+
+// allow reports whether a request from addr is within the rate limit.
+func (s *STUNServer) allow(addr netip.Addr) bool {
+	if s.limiters == nil {
+		return true
+	}
+	lim, ok := s.limiters.GetOk(addr)
+	if !ok {
+		lim = rate.NewLimiter(s.limit, s.burst)
+		s.limiters.Set(addr, lim)
+	}
+	return lim.Allow()
+}
+
 // Listen binds the listen socket for the server at listenAddr.
 func (s *STUNServer) Listen(listenAddr string) error {
 	uaddr, err := net.ResolveUDPAddr("udp", listenAddr)
@@ -59,6 +101,11 @@ func (s *STUNServer) Listen(listenAddr string) error {
 		return err
 	}
 	log.Printf("STUN server listening on %v", s.LocalAddr())
+	// Only advertise RESPONSE-ORIGIN when bound to a specific address;
+	// the wildcard address tells clients nothing.
+	if ap := s.pc.LocalAddr().(*net.UDPAddr).AddrPort(); !ap.Addr().IsUnspecified() {
+		s.origin = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	}
 	// close the listener on shutdown in order to break out of the read loop
 	go func() {
 		<-s.ctx.Done()
@@ -96,13 +143,21 @@ func (s *STUNServer) Serve() error {
 			stunNotSTUN.Add(1)
 			continue
 		}
+		addr, _ := netip.AddrFromSlice(ua.IP)
+		addr = addr.Unmap()
+		if !s.allow(addr) {
+			stunRateLimited.Add(1)
+			continue
+		}
 		if ua.IP.To4() != nil {
 			stunIPv4.Add(1)
 		} else {
 			stunIPv6.Add(1)
 		}
-		addr, _ := netip.AddrFromSlice(ua.IP)
-		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
+		res := stun.ResponseWithOptions(txid, netip.AddrPortFrom(addr, uint16(ua.Port)), stun.ResponseOptions{
+			Origin:      s.origin,
+			Fingerprint: true,
+		})
 		_, err = s.pc.WriteTo(res, ua)
 		if err != nil {
 			stunWriteError.Add(1)
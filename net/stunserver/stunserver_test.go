@@ -11,6 +11,7 @@
 	"time"
 
 	"tailscale.com/net/stun"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/util/must"
 )
 
@@ -58,6 +59,47 @@ func TestSTUNServer(t *testing.T) {
 	}
 }
 
+func TestSTUNServerRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx)
+	s.SetRateLimit(rate.Every(time.Hour), 2)
+	must.Do(s.Listen("127.0.0.1:0"))
+	go s.Serve()
+
+	c := must.Get(net.DialUDP("udp", nil, s.LocalAddr().(*net.UDPAddr)))
+	defer c.Close()
+
+	var buf [64 << 10]byte
+	query := func() error {
+		txid := stun.NewTxID()
+		if _, err := c.Write(stun.Request(txid)); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, err := c.Read(buf[:])
+		if err != nil {
+			return err
+		}
+		tid, _, err := stun.ParseResponse(buf[:n])
+		if err != nil {
+			t.Fatalf("failed to parse STUN response: %v", err)
+		}
+		if tid != txid {
+			t.Fatalf("STUN response has wrong transaction ID; got %x, want %x", tid, txid)
+		}
+		return nil
+	}
+	for i := range 2 {
+		if err := query(); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if err := query(); err == nil {
+		t.Fatal("request over rate limit got a response")
+	}
+}
+
 func BenchmarkServerSTUN(b *testing.B) {
 	b.ReportAllocs()
 	ctx, cancel := context.WithCancel(context.Background())
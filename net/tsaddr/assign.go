@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsaddr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// prefixContains reports whether p contains all of q.
+func prefixContains(p, q netip.Prefix) bool {
+	return p.Addr().BitLen() == q.Addr().BitLen() && p.Bits() <= q.Bits() && p.Contains(q.Addr())
+}
+
+// ValidateNodeRange reports whether p can be used as a range to assign
+// node addresses from, as an alternative to CGNATRange or
+// TailscaleULARange. Such a range must be a masked prefix within the CGNAT
+// range, an RFC 1918 range or the IPv6 ULA range, with room for at least
+// two nodes.
+//
+// Features that map addresses between CGNATRange and TailscaleULARange,
+// such as Tailscale4To6, don't work with other ranges.
+func ValidateNodeRange(p netip.Prefix) error {
+	if !p.IsValid() {
+		return errors.New("invalid prefix")
+	}
+	if p != p.Masked() {
+		return fmt.Errorf("%v is not masked; want %v", p, p.Masked())
+	}
+	if p.Bits() > p.Addr().BitLen()-2 {
+		return fmt.Errorf("%v is too small", p)
+	}
+	private := false
+	for _, r := range []string{"100.64.0.0/10", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		private = private || prefixContains(netip.MustParsePrefix(r), p)
+	}
+	if !private {
+		return fmt.Errorf("%v is not within the CGNAT, RFC 1918 or IPv6 ULA ranges", p)
+	}
+	for _, r := range []netip.Prefix{ChromeOSVMRange(), TailscaleViaRange(), Tailscale4To6Range(), TailscaleEphemeral6Range()} {
+		if prefixContains(r, p) {
+			return fmt.Errorf("%v is within reserved range %v", p, r)
+		}
+	}
+	return nil
+}
+
+// IsAssignableNodeAddr reports whether ip may be assigned to a node from
+// the range p, which should have been checked with ValidateNodeRange. It
+// excludes the first address of p and, for IPv4, the last, as well as the
+// addresses of Tailscale's own services and the ranges Tailscale reserves
+// for other uses.
+func IsAssignableNodeAddr(p netip.Prefix, ip netip.Addr) bool {
+	if !p.Contains(ip) || ip == p.Addr() {
+		return false
+	}
+	if ip.Is4() {
+		return ip != lastAddr(p) && ip != TailscaleServiceIP() && !ChromeOSVMRange().Contains(ip)
+	}
+	return ip != TailscaleServiceIPv6() &&
+		!TailscaleViaRange().Contains(ip) &&
+		!Tailscale4To6Range().Contains(ip) &&
+		!TailscaleEphemeral6Range().Contains(ip)
+}
+
+// lastAddr returns the last address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(a)*8; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	ip, _ := netip.AddrFromSlice(a)
+	return ip
+}
+
+// NodeAddrFromKey returns a stable address for a node in the range p,
+// derived from a hash of nodeKey (such as the raw bytes of its node public
+// key), so that control servers can assign the same address to a node
+// every time without storing it. The range should have been checked with
+// ValidateNodeRange.
+//
+// If the derived address isn't assignable (see IsAssignableNodeAddr) or
+// inUse reports that it's already taken, the following addresses are
+// tried in turn, wrapping around at the end of p. For a given nodeKey and
+// set of taken addresses the result is always the same. inUse may be nil
+// if no addresses are taken. It returns an error if p has no free
+// addresses.
+func NodeAddrFromKey(p netip.Prefix, nodeKey []byte, inUse func(netip.Addr) bool) (netip.Addr, error) {
+	if !p.IsValid() {
+		return netip.Addr{}, errors.New("invalid prefix")
+	}
+	p = p.Masked()
+	sum := sha256.Sum256(nodeKey)
+	a := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(a)*8; i++ {
+		mask := byte(0x80 >> (i % 8))
+		a[i/8] = a[i/8]&^mask | sum[i/8]&mask
+	}
+	start, _ := netip.AddrFromSlice(a)
+
+	for ip := start; ; {
+		if IsAssignableNodeAddr(p, ip) && (inUse == nil || !inUse(ip)) {
+			return ip, nil
+		}
+		ip = ip.Next()
+		if !ip.IsValid() || !p.Contains(ip) {
+			ip = p.Addr()
+		}
+		if ip == start {
+			return netip.Addr{}, fmt.Errorf("no free addresses in %v", p)
+		}
+	}
+}
@@ -89,3 +89,100 @@ func TestUnmapVia(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateNodeRange(t *testing.T) {
+	tests := []struct {
+		p      string
+		wantOK bool
+	}{
+		{"100.64.0.0/10", true},
+		{"10.0.0.0/8", true},
+		{"10.20.0.0/16", true},
+		{"172.16.0.0/12", true},
+		{"192.168.1.0/24", true},
+		{"192.168.1.0/30", true},
+		{"fd7a:115c:a1e0::/48", true},
+		{"fd00:1234::/64", true},
+		{"192.168.1.0/31", false},  // too small
+		{"10.0.0.1/8", false},      // not masked
+		{"8.8.8.0/24", false},      // public
+		{"172.0.0.0/8", false},     // partly public
+		{"100.115.92.0/24", false}, // ChromeOS VMs
+		{"2001:db8::/32", false},
+		{"fd7a:115c:a1e0:b1a::/80", false}, // via range
+	}
+	for _, tt := range tests {
+		err := ValidateNodeRange(netip.MustParsePrefix(tt.p))
+		if (err == nil) != tt.wantOK {
+			t.Errorf("ValidateNodeRange(%v) = %v; want ok=%v", tt.p, err, tt.wantOK)
+		}
+	}
+}
+
+func TestIsAssignableNodeAddr(t *testing.T) {
+	tests := []struct {
+		p    string
+		ip   string
+		want bool
+	}{
+		{"10.1.0.0/16", "10.1.2.3", true},
+		{"10.1.0.0/16", "10.1.0.0", false},
+		{"10.1.0.0/16", "10.1.255.255", false},
+		{"10.1.0.0/16", "10.2.0.1", false},
+		{"100.64.0.0/10", "100.100.100.100", false},
+		{"100.64.0.0/10", "100.115.92.5", false},
+		{"fd7a:115c:a1e0::/48", "fd7a:115c:a1e0::1", true},
+		{"fd7a:115c:a1e0::/48", "fd7a:115c:a1e0::", false},
+		{"fd7a:115c:a1e0::/48", "fd7a:115c:a1e0::53", false},
+		{"fd7a:115c:a1e0::/48", "fd7a:115c:a1e0:b1a::1", false},
+	}
+	for _, tt := range tests {
+		got := IsAssignableNodeAddr(netip.MustParsePrefix(tt.p), netip.MustParseAddr(tt.ip))
+		if got != tt.want {
+			t.Errorf("IsAssignableNodeAddr(%v, %v) = %v; want %v", tt.p, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNodeAddrFromKey(t *testing.T) {
+	for _, ps := range []string{"100.64.0.0/10", "10.0.0.0/8", "fd7a:115c:a1e0::/48"} {
+		p := netip.MustParsePrefix(ps)
+		a1, err := NodeAddrFromKey(p, []byte("node1"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := NodeAddrFromKey(p, []byte("node1"), nil); again != a1 {
+			t.Errorf("%v: address for the same key changed from %v to %v", p, a1, again)
+		}
+		if !IsAssignableNodeAddr(p, a1) {
+			t.Errorf("%v: got unassignable address %v", p, a1)
+		}
+		if a2, _ := NodeAddrFromKey(p, []byte("node2"), nil); a2 == a1 {
+			t.Errorf("%v: two keys both got %v", p, a1)
+		}
+		taken, err := NodeAddrFromKey(p, []byte("node1"), func(ip netip.Addr) bool { return ip == a1 })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken == a1 {
+			t.Errorf("%v: got address %v that's in use", p, a1)
+		}
+	}
+
+	// A /30 has two assignable addresses.
+	p := netip.MustParsePrefix("192.168.0.4/30")
+	used := map[netip.Addr]bool{}
+	for i := range 2 {
+		ip, err := NodeAddrFromKey(p, []byte{byte(i)}, func(ip netip.Addr) bool { return used[ip] })
+		if err != nil {
+			t.Fatal(err)
+		}
+		used[ip] = true
+	}
+	if !used[netip.MustParseAddr("192.168.0.5")] || !used[netip.MustParseAddr("192.168.0.6")] {
+		t.Errorf("assigned %v; want 192.168.0.5 and 192.168.0.6", used)
+	}
+	if ip, err := NodeAddrFromKey(p, []byte{2}, func(ip netip.Addr) bool { return used[ip] }); err == nil {
+		t.Errorf("got %v from a full range; want error", ip)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"net/netip"
+	"slices"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/net/packet/checksum"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/wgengine/filter"
+)
+
+// MulticastAction is what a Wrapper does with a multicast or broadcast
+// packet that the OS sends into the tunnel, such as an mDNS, LLMNR or SSDP
+// query.
+type MulticastAction int
+
+const (
+	// MulticastDefault hands the packet to the packet filter, which
+	// drops (and may log) it. It's what happens without a
+	// MulticastPolicy.
+	MulticastDefault MulticastAction = iota
+	// MulticastDrop drops the packet silently.
+	MulticastDrop
+	// MulticastForward sends a unicast copy of the packet to each of the
+	// MulticastDecision's Peers that the packet filter allows it to
+	// reach, and drops the original.
+	MulticastForward
+)
+
+// MulticastDecision is the result of a MulticastPolicy.
+type MulticastDecision struct {
+	Action MulticastAction
+
+	// Peers are the Tailscale IPs of the peers to send copies to, for
+	// MulticastForward. Those of a different address family than the
+	// packet are skipped.
+	Peers []netip.Addr
+
+	// Limit, if non-nil, limits the rate of packets that this decision
+	// applies to. Packets over the limit are dropped silently. It's
+	// ignored for MulticastDefault.
+	Limit *rate.Limiter
+}
+
+// MulticastPolicy decides what to do with a multicast or broadcast packet
+// sent by the OS. It's called on the packet processing path, so it must not
+// block, and must not retain p.
+type MulticastPolicy func(p *packet.Parsed) MulticastDecision
+
+// SetMulticastPolicy sets the policy for the multicast and broadcast
+// packets that the OS sends into the tunnel. A nil policy restores the
+// default, which is to hand them to the packet filter.
+func (t *Wrapper) SetMulticastPolicy(pol MulticastPolicy) {
+	if pol == nil {
+		t.multicastPolicy.Store(nil)
+		return
+	}
+	t.multicastPolicy.Store(&pol)
+}
+
+// isMulticastOrBroadcast reports whether p is addressed to a multicast
+// group or the IPv4 limited broadcast address.
+func isMulticastOrBroadcast(p *packet.Parsed) bool {
+	dst := p.Dst.Addr()
+	return dst.IsMulticast() || dst == netip.AddrFrom4([4]byte{255, 255, 255, 255})
+}
+
+// applyMulticastPolicy applies t's MulticastPolicy, if any, to p, an
+// outbound multicast or broadcast packet. It reports whether the policy
+// handled p, in which case p must not be processed further.
+func (t *Wrapper) applyMulticastPolicy(p *packet.Parsed, pc *peerConfigTable) (_ filter.Response, handled bool) {
+	pol := t.multicastPolicy.Load()
+	if pol == nil {
+		return filter.Accept, false
+	}
+	d := (*pol)(p)
+	if d.Action != MulticastDefault && d.Limit != nil && !d.Limit.Allow() {
+		metricMulticastRateLimited.Add(1)
+		return filter.DropSilently, true
+	}
+	switch d.Action {
+	case MulticastDrop:
+		metricMulticastDrop.Add(1)
+		return filter.DropSilently, true
+	case MulticastForward:
+		metricMulticastForward.Add(1)
+		t.forwardMulticast(p, pc, d.Peers)
+		return filter.DropSilently, true
+	}
+	metricMulticastDefault.Add(1)
+	return filter.Accept, false
+}
+
+// forwardMulticast injects a copy of p addressed to each of peers, subject
+// to the packet filter.
+func (t *Wrapper) forwardMulticast(p *packet.Parsed, pc *peerConfigTable, peers []netip.Addr) {
+	var q packet.Parsed
+	var copies [][]byte
+	for _, peer := range peers {
+		if peer.Is4() != p.Dst.Addr().Is4() {
+			continue
+		}
+		q.Decode(slices.Clone(p.Buffer()))
+		checksum.UpdateDstAddr(&q, peer)
+		filt := t.filter.Load()
+		if pc.outboundPacketIsJailed(&q) {
+			filt = t.jailedFilter.Load()
+		}
+		if filt == nil || filt.RunOut(&q, t.filterFlags) != filter.Accept {
+			metricMulticastForwardDenied.Add(1)
+			continue
+		}
+		copies = append(copies, q.Buffer())
+	}
+	if len(copies) == 0 {
+		return
+	}
+	// Inject from another goroutine: this runs in Read, which is what
+	// drains injected packets.
+	go func() {
+		for _, pkt := range copies {
+			if err := t.InjectOutbound(pkt); err != nil {
+				t.limitedLogf("multicast: forwarding: %v", err)
+				continue
+			}
+			metricMulticastForwardCopies.Add(1)
+		}
+	}()
+}
+
+var (
+	metricMulticastDefault       = clientmetric.NewCounter("tstun_multicast_default")
+	metricMulticastDrop          = clientmetric.NewCounter("tstun_multicast_drop")
+	metricMulticastRateLimited   = clientmetric.NewCounter("tstun_multicast_ratelimited")
+	metricMulticastForward       = clientmetric.NewCounter("tstun_multicast_forward")
+	metricMulticastForwardCopies = clientmetric.NewCounter("tstun_multicast_forward_copies")
+	metricMulticastForwardDenied = clientmetric.NewCounter("tstun_multicast_forward_denied")
+)
@@ -53,7 +53,7 @@ func New(logf logger.Logf, tunName string) (tun.Device, string, error) {
 		dev.Close()
 		return nil, "", err
 	}
-	if err := setLinkFeatures(dev); err != nil {
+	if err := setLinkFeatures(dev, logf); err != nil {
 		logf("setting link features: %v", err)
 	}
 	if err := setLinkAttrs(dev); err != nil {
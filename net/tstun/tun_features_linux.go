@@ -4,16 +4,52 @@
 package tstun
 
 import (
+	"errors"
+	"syscall"
+
 	"github.com/tailscale/wireguard-go/tun"
 	"tailscale.com/envknob"
+	"tailscale.com/types/logger"
 )
 
-func setLinkFeatures(dev tun.Device) error {
-	if envknob.Bool("TS_TUN_DISABLE_UDP_GRO") {
-		linuxDev, ok := dev.(tun.LinuxDevice)
-		if ok {
-			linuxDev.DisableUDPGRO()
-		}
+// setLinkFeatures turns off the offloads that the TS_TUN_DISABLE_*_GRO
+// knobs ask for. wireguard-go's Linux TUN negotiates TSO, USO and checksum
+// offload with the kernel (IFF_VNET_HDR and TUNSETOFFLOAD) and handles the
+// virtio-net headers, splitting segmented packets on read and coalescing
+// them on write, so there's nothing to turn on.
+func setLinkFeatures(dev tun.Device, logf logger.Logf) error {
+	disableUDP := envknob.Bool("TS_TUN_DISABLE_UDP_GRO")
+	disableTCP := envknob.Bool("TS_TUN_DISABLE_TCP_GRO")
+	if !disableUDP && !disableTCP {
+		return nil
+	}
+	linuxDev, ok := dev.(tun.LinuxDevice)
+	if !ok {
+		logf("tstun: %T doesn't support disabling GRO; ignoring TS_TUN_DISABLE_*_GRO", dev)
+		return nil
+	}
+	if disableUDP {
+		linuxDev.DisableUDPGRO()
+	}
+	if disableTCP {
+		linuxDev.DisableTCPGRO()
 	}
 	return nil
 }
+
+// disableGROOnWriteError turns off TCP and UDP GRO on dev if err, from
+// writing a batch of packets to it, shows that the kernel rejected the
+// packets that GRO coalesced, as some kernels and virtual NICs with
+// broken virtio-net header handling do. It reports whether it did.
+func disableGROOnWriteError(dev tun.Device, err error) bool {
+	if !errors.Is(err, syscall.EINVAL) {
+		return false
+	}
+	linuxDev, ok := dev.(tun.LinuxDevice)
+	if !ok {
+		return false
+	}
+	linuxDev.DisableTCPGRO()
+	linuxDev.DisableUDPGRO()
+	return true
+}
@@ -7,8 +7,13 @@
 
 import (
 	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/types/logger"
 )
 
-func setLinkFeatures(dev tun.Device) error {
+func setLinkFeatures(dev tun.Device, logf logger.Logf) error {
 	return nil
 }
+
+func disableGROOnWriteError(dev tun.Device, err error) bool {
+	return false
+}
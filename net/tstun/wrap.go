@@ -22,7 +22,6 @@
 	"github.com/tailscale/wireguard-go/device"
 	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/mem"
-	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
 	"tailscale.com/net/connstats"
 	"tailscale.com/net/packet"
@@ -88,6 +87,8 @@ type Wrapper struct {
 	started atomic.Bool   // whether Start has been called
 	startCh chan struct{} // closed in Start
 
+	groDisabled atomic.Bool // whether GRO was turned off after tdev rejected coalesced packets
+
 	closeOnce sync.Once
 
 	// lastActivityAtomic is read/written atomically.
@@ -153,6 +154,9 @@ type Wrapper struct {
 	filter atomic.Pointer[filter.Filter]
 	// filterFlags control the verbosity of logging packet drops/accepts.
 	filterFlags filter.RunFlags
+	// multicastPolicy, if non-nil, decides what to do with outbound
+	// multicast and broadcast packets. See SetMulticastPolicy.
+	multicastPolicy atomic.Pointer[MulticastPolicy]
 	// jailedFilter is the packet filter for jailed nodes.
 	// Can be nil, which means drop all packets.
 	jailedFilter atomic.Pointer[filter.Filter]
@@ -207,7 +211,7 @@ type Wrapper struct {
 type tunInjectedRead struct {
 	// Only one of packet or data should be set, and are read in that order of
 	// precedence.
-	packet *stack.PacketBuffer
+	packet *packetBuffer
 	data   []byte
 }
 
@@ -848,6 +852,12 @@ func (t *Wrapper) filterPacketOutboundToWireGuard(p *packet.Parsed, pc *peerConf
 		}
 	}
 
+	if isMulticastOrBroadcast(p) {
+		if res, handled := t.applyMulticastPolicy(p, pc); handled {
+			return res
+		}
+	}
+
 	// If the outbound packet is to a jailed peer, use our jailed peer
 	// packet filter.
 	var filt *filter.Filter
@@ -955,44 +965,11 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	return buffsPos, res.err
 }
 
-const (
-	minTCPHeaderSize = 20
-)
-
-func stackGSOToTunGSO(pkt []byte, gso stack.GSO) (tun.GSOOptions, error) {
-	options := tun.GSOOptions{
-		CsumStart:  gso.L3HdrLen,
-		CsumOffset: gso.CsumOffset,
-		GSOSize:    gso.MSS,
-		NeedsCsum:  gso.NeedsCsum,
-	}
-	switch gso.Type {
-	case stack.GSONone:
-		options.GSOType = tun.GSONone
-		return options, nil
-	case stack.GSOTCPv4:
-		options.GSOType = tun.GSOTCPv4
-	case stack.GSOTCPv6:
-		options.GSOType = tun.GSOTCPv6
-	default:
-		return tun.GSOOptions{}, fmt.Errorf("unsupported gVisor GSOType: %v", gso.Type)
-	}
-	// options.HdrLen is both layer 3 and 4 together, whereas gVisor only
-	// gives us layer 3 length. We have to gather TCP header length
-	// ourselves.
-	if len(pkt) < int(gso.L3HdrLen)+minTCPHeaderSize {
-		return tun.GSOOptions{}, errors.New("gVisor GSOTCP packet length too short")
-	}
-	tcphLen := uint16(pkt[int(gso.L3HdrLen)+12] >> 4 * 4)
-	options.HdrLen = gso.L3HdrLen + tcphLen
-	return options, nil
-}
-
-func invertGSOChecksum(pkt []byte, gso stack.GSO) {
-	if gso.NeedsCsum != true {
+func invertGSOChecksum(pkt []byte, gso tun.GSOOptions) {
+	if !gso.NeedsCsum {
 		return
 	}
-	at := int(gso.L3HdrLen + gso.CsumOffset)
+	at := int(gso.CsumStart + gso.CsumOffset)
 	if at+1 > len(pkt)-1 {
 		return
 	}
@@ -1002,16 +979,16 @@ func invertGSOChecksum(pkt []byte, gso stack.GSO) {
 
 // injectedRead handles injected reads, which bypass filters.
 func (t *Wrapper) injectedRead(res tunInjectedRead, outBuffs [][]byte, sizes []int, offset int) (n int, err error) {
-	var gso stack.GSO
+	var gso tun.GSOOptions // of res.packet
 
 	pkt := outBuffs[0][offset:]
 	if res.packet != nil {
-		bufN := copy(pkt, res.packet.NetworkHeader().Slice())
-		bufN += copy(pkt[bufN:], res.packet.TransportHeader().Slice())
-		bufN += copy(pkt[bufN:], res.packet.Data().AsRange().ToSlice())
-		gso = res.packet.GSOOptions
+		var bufN int
+		bufN, gso, err = readPacketBuffer(pkt, res.packet)
+		if err != nil {
+			return 0, err
+		}
 		pkt = pkt[:bufN]
-		defer res.packet.DecRef() // defer DecRef so we may continue to reference it
 	} else {
 		sizes[0] = copy(pkt, res.data)
 		pkt = pkt[:sizes[0]]
@@ -1042,12 +1019,7 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, outBuffs [][]byte, sizes []i
 	}
 
 	if res.packet != nil {
-		var gsoOptions tun.GSOOptions
-		gsoOptions, err = stackGSOToTunGSO(pkt, gso)
-		if err != nil {
-			return 0, err
-		}
-		n, err = tun.GSOSplit(pkt, gsoOptions, outBuffs, sizes, offset)
+		n, err = tun.GSOSplit(pkt, gso, outBuffs, sizes, offset)
 	}
 
 	if stats := t.stats.Load(); stats != nil {
@@ -1205,7 +1177,15 @@ func (t *Wrapper) tdevWrite(buffs [][]byte, offset int) (int, error) {
 			stats.UpdateRxVirtual((buffs)[i][offset:])
 		}
 	}
-	return t.tdev.Write(buffs, offset)
+	n, err := t.tdev.Write(buffs, offset)
+	if err != nil && len(buffs) > 1 && !t.groDisabled.Load() && disableGROOnWriteError(t.tdev, err) {
+		// The packets in this batch are lost, but the transports
+		// retransmit them, and later batches aren't coalesced.
+		t.groDisabled.Store(true)
+		metricGRODisabled.Add(1)
+		t.logf("tstun: disabled GRO after writing coalesced packets failed: %v", err)
+	}
+	return n, err
 }
 
 func (t *Wrapper) GetFilter() *filter.Filter {
@@ -1224,39 +1204,6 @@ func (t *Wrapper) SetJailedFilter(filt *filter.Filter) {
 	t.jailedFilter.Store(filt)
 }
 
-// InjectInboundPacketBuffer makes the Wrapper device behave as if a packet
-// with the given contents was received from the network.
-// It takes ownership of one reference count on the packet. The injected
-// packet will not pass through inbound filters.
-//
-// This path is typically used to deliver synthesized packets to the
-// host networking stack.
-func (t *Wrapper) InjectInboundPacketBuffer(pkt *stack.PacketBuffer) error {
-	buf := make([]byte, PacketStartOffset+pkt.Size())
-
-	n := copy(buf[PacketStartOffset:], pkt.NetworkHeader().Slice())
-	n += copy(buf[PacketStartOffset+n:], pkt.TransportHeader().Slice())
-	n += copy(buf[PacketStartOffset+n:], pkt.Data().AsRange().ToSlice())
-	if n != pkt.Size() {
-		panic("unexpected packet size after copy")
-	}
-	pkt.DecRef()
-
-	pc := t.peerConfig.Load()
-
-	p := parsedPacketPool.Get().(*packet.Parsed)
-	defer parsedPacketPool.Put(p)
-	p.Decode(buf[PacketStartOffset:])
-	captHook := t.captureHook.Load()
-	if captHook != nil {
-		captHook(capture.SynthesizedToLocal, t.now(), p.Buffer(), p.CaptureMeta)
-	}
-
-	pc.dnat(p)
-
-	return t.InjectInboundDirect(buf, PacketStartOffset)
-}
-
 // InjectInboundDirect makes the Wrapper device behave as if a packet
 // with the given contents was received from the network.
 // It blocks and does not take ownership of the packet.
@@ -1339,28 +1286,6 @@ func (t *Wrapper) InjectOutbound(pkt []byte) error {
 	return nil
 }
 
-// InjectOutboundPacketBuffer logically behaves as InjectOutbound. It takes ownership of one
-// reference count on the packet, and the packet may be mutated. The packet refcount will be
-// decremented after the injected buffer has been read.
-func (t *Wrapper) InjectOutboundPacketBuffer(pkt *stack.PacketBuffer) error {
-	size := pkt.Size()
-	if size > MaxPacketSize {
-		pkt.DecRef()
-		return errPacketTooBig
-	}
-	if size == 0 {
-		pkt.DecRef()
-		return nil
-	}
-	if capt := t.captureHook.Load(); capt != nil {
-		b := pkt.ToBuffer()
-		capt(capture.SynthesizedToPeer, t.now(), b.Flatten(), packet.CaptureMeta{})
-	}
-
-	t.injectOutbound(tunInjectedRead{packet: pkt})
-	return nil
-}
-
 func (t *Wrapper) BatchSize() int {
 	if runtime.GOOS == "linux" {
 		// Always setup Linux to handle vectors, even in the very rare case that
@@ -1394,6 +1319,8 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
 	metricPacketOutDropSelfDisco = clientmetric.NewCounter("tstun_out_to_wg_drop_self_disco")
+
+	metricGRODisabled = clientmetric.NewCounter("tstun_gro_disabled")
 )
 
 func (t *Wrapper) InstallCaptureHook(cb capture.Callback) {
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netstack
+
+package tstun
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tailscale/wireguard-go/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"tailscale.com/net/packet"
+	"tailscale.com/wgengine/capture"
+)
+
+// packetBuffer is a packet injected by netstack.
+type packetBuffer = stack.PacketBuffer
+
+// readPacketBuffer copies pkt into dst, releasing it, and returns the
+// number of bytes copied and pkt's GSO options.
+func readPacketBuffer(dst []byte, pkt *packetBuffer) (int, tun.GSOOptions, error) {
+	defer pkt.DecRef()
+	n := copy(dst, pkt.NetworkHeader().Slice())
+	n += copy(dst[n:], pkt.TransportHeader().Slice())
+	n += copy(dst[n:], pkt.Data().AsRange().ToSlice())
+	gso, err := stackGSOToTunGSO(dst[:n], pkt.GSOOptions)
+	return n, gso, err
+}
+
+const (
+	minTCPHeaderSize = 20
+)
+
+func stackGSOToTunGSO(pkt []byte, gso stack.GSO) (tun.GSOOptions, error) {
+	options := tun.GSOOptions{
+		CsumStart:  gso.L3HdrLen,
+		CsumOffset: gso.CsumOffset,
+		GSOSize:    gso.MSS,
+		NeedsCsum:  gso.NeedsCsum,
+	}
+	switch gso.Type {
+	case stack.GSONone:
+		options.GSOType = tun.GSONone
+		return options, nil
+	case stack.GSOTCPv4:
+		options.GSOType = tun.GSOTCPv4
+	case stack.GSOTCPv6:
+		options.GSOType = tun.GSOTCPv6
+	default:
+		return tun.GSOOptions{}, fmt.Errorf("unsupported gVisor GSOType: %v", gso.Type)
+	}
+	// options.HdrLen is both layer 3 and 4 together, whereas gVisor only
+	// gives us layer 3 length. We have to gather TCP header length
+	// ourselves.
+	if len(pkt) < int(gso.L3HdrLen)+minTCPHeaderSize {
+		return tun.GSOOptions{}, errors.New("gVisor GSOTCP packet length too short")
+	}
+	tcphLen := uint16(pkt[int(gso.L3HdrLen)+12] >> 4 * 4)
+	options.HdrLen = gso.L3HdrLen + tcphLen
+	return options, nil
+}
+
+// InjectInboundPacketBuffer makes the Wrapper device behave as if a packet
+// with the given contents was received from the network.
+// It takes ownership of one reference count on the packet. The injected
+// packet will not pass through inbound filters.
+//
+// This path is typically used to deliver synthesized packets to the
+// host networking stack.
+func (t *Wrapper) InjectInboundPacketBuffer(pkt *stack.PacketBuffer) error {
+	buf := make([]byte, PacketStartOffset+pkt.Size())
+
+	n := copy(buf[PacketStartOffset:], pkt.NetworkHeader().Slice())
+	n += copy(buf[PacketStartOffset+n:], pkt.TransportHeader().Slice())
+	n += copy(buf[PacketStartOffset+n:], pkt.Data().AsRange().ToSlice())
+	if n != pkt.Size() {
+		panic("unexpected packet size after copy")
+	}
+	pkt.DecRef()
+
+	pc := t.peerConfig.Load()
+
+	p := parsedPacketPool.Get().(*packet.Parsed)
+	defer parsedPacketPool.Put(p)
+	p.Decode(buf[PacketStartOffset:])
+	captHook := t.captureHook.Load()
+	if captHook != nil {
+		captHook(capture.SynthesizedToLocal, t.now(), p.Buffer(), p.CaptureMeta)
+	}
+
+	pc.dnat(p)
+
+	return t.InjectInboundDirect(buf, PacketStartOffset)
+}
+
+// InjectOutboundPacketBuffer logically behaves as InjectOutbound. It takes ownership of one
+// reference count on the packet, and the packet may be mutated. The packet refcount will be
+// decremented after the injected buffer has been read.
+func (t *Wrapper) InjectOutboundPacketBuffer(pkt *stack.PacketBuffer) error {
+	size := pkt.Size()
+	if size > MaxPacketSize {
+		pkt.DecRef()
+		return errPacketTooBig
+	}
+	if size == 0 {
+		pkt.DecRef()
+		return nil
+	}
+	if capt := t.captureHook.Load(); capt != nil {
+		b := pkt.ToBuffer()
+		capt(capture.SynthesizedToPeer, t.now(), b.Flatten(), packet.CaptureMeta{})
+	}
+
+	t.injectOutbound(tunInjectedRead{packet: pkt})
+	return nil
+}
@@ -0,0 +1,19 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build ts_omit_netstack
+
+package tstun
+
+import (
+	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/omit"
+)
+
+// packetBuffer stands in for netstack's packet type. Without netstack,
+// no packetBuffers are ever injected.
+type packetBuffer struct{}
+
+func readPacketBuffer(dst []byte, pkt *packetBuffer) (int, tun.GSOOptions, error) {
+	return 0, tun.GSOOptions{}, omit.Err
+}
@@ -11,8 +11,10 @@
 	"fmt"
 	"net/netip"
 	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 	"unicode"
@@ -919,3 +921,92 @@ type captureRecord struct {
 			captured, want)
 	}
 }
+
+func TestMulticastPolicy(t *testing.T) {
+	chtun, tun := newChannelTUN(t.Logf, true)
+	defer tun.Close()
+
+	peer := netip.MustParseAddr("5.6.7.8")
+	action := MulticastDrop
+	tun.SetMulticastPolicy(func(p *packet.Parsed) MulticastDecision {
+		return MulticastDecision{Action: action, Peers: []netip.Addr{peer}}
+	})
+
+	buf := make([]byte, MaxPacketSize)
+	sizes := make([]int, 1)
+	read := func() int {
+		t.Helper()
+		n, err := tun.Read([][]byte{buf}, sizes, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	drops := metricMulticastDrop.Value()
+	chtun.Outbound <- udp4("1.2.3.4", "224.0.0.251", 98, 98)
+	if n := read(); n != 0 {
+		t.Fatalf("read %d packets with MulticastDrop; want 0", n)
+	}
+	if got := metricMulticastDrop.Value() - drops; got != 1 {
+		t.Errorf("drop counter went up by %d; want 1", got)
+	}
+
+	action = MulticastForward
+	chtun.Outbound <- udp4("1.2.3.4", "224.0.0.251", 98, 98)
+	if n := read(); n != 0 {
+		t.Fatalf("read %d packets of the original multicast packet; want 0", n)
+	}
+	if n := read(); n != 1 {
+		t.Fatalf("read %d forwarded packets; want 1", n)
+	}
+	var p packet.Parsed
+	p.Decode(buf[:sizes[0]])
+	if want := netip.AddrPortFrom(peer, 98); p.Dst != want {
+		t.Errorf("forwarded packet went to %v; want %v", p.Dst, want)
+	}
+}
+
+// groTUN is a tun.LinuxDevice whose writes of more than one packet fail
+// with EINVAL until GRO is disabled, like a kernel rejecting coalesced
+// packets.
+type groTUN struct {
+	*fakeTUN
+	tcpGRO, udpGRO bool
+}
+
+func (t *groTUN) Write(b [][]byte, offset int) (int, error) {
+	if len(b) > 1 && (t.tcpGRO || t.udpGRO) {
+		return 0, syscall.EINVAL
+	}
+	return len(b), nil
+}
+
+func (t *groTUN) DisableTCPGRO() { t.tcpGRO = false }
+func (t *groTUN) DisableUDPGRO() { t.udpGRO = false }
+
+func TestDisableGROOnWriteError(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("GRO is only disabled on Linux")
+	}
+	dev := &groTUN{fakeTUN: NewFake().(*fakeTUN), tcpGRO: true, udpGRO: true}
+	tun := Wrap(t.Logf, dev)
+	defer tun.Close()
+
+	pkts := [][]byte{make([]byte, 40), make([]byte, 40)}
+	if _, err := tun.tdevWrite(pkts[:1], 0); err != nil {
+		t.Fatalf("writing one packet: %v", err)
+	}
+	if !dev.tcpGRO || !dev.udpGRO {
+		t.Fatal("GRO disabled without an error")
+	}
+	if _, err := tun.tdevWrite(pkts, 0); err == nil {
+		t.Fatal("writing coalesced packets succeeded")
+	}
+	if dev.tcpGRO || dev.udpGRO {
+		t.Errorf("GRO still enabled after EINVAL: tcp=%v, udp=%v", dev.tcpGRO, dev.udpGRO)
+	}
+	if _, err := tun.tdevWrite(pkts, 0); err != nil {
+		t.Errorf("writing packets after disabling GRO: %v", err)
+	}
+}
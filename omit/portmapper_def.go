@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_portmapper
+
+package omit
+
+// Portmapper is whether NAT-PMP, PCP and UPnP port mapping should be
+// omitted from the build.
+const Portmapper = false
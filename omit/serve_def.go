@@ -0,0 +1,9 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_serve
+
+package omit
+
+// Serve is whether Tailscale Serve and Funnel should be omitted from the build.
+const Serve = false
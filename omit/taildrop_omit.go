@@ -0,0 +1,9 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build ts_omit_taildrop
+
+package omit
+
+// Taildrop is whether Taildrop file sharing should be omitted from the build.
+const Taildrop = true
@@ -52,6 +52,11 @@ func DefaultTailscaledSocket() string {
 var (
 	stateFileFunc func() string
 
+	// userStateDirFunc and userSocketFunc return the defaults for a
+	// tailscaled running in per-user mode.
+	userStateDirFunc func() string
+	userSocketFunc   func() string
+
 	// ensureStateDirPerms applies a restrictive ACL/chmod
 	// to the provided directory.
 	ensureStateDirPerms = func(string) error { return nil }
@@ -70,6 +75,26 @@ func DefaultTailscaledStateFile() string {
 	return ""
 }
 
+// UserTailscaledStateDir returns the default state directory of a
+// tailscaled running in per-user mode (tailscaled --user) as the current
+// user, or the empty string if per-user mode isn't supported.
+func UserTailscaledStateDir() string {
+	if f := userStateDirFunc; f != nil {
+		return f()
+	}
+	return ""
+}
+
+// UserTailscaledSocket returns the default socket path of a tailscaled
+// running in per-user mode (tailscaled --user) as the current user, or
+// the empty string if per-user mode isn't supported.
+func UserTailscaledSocket() string {
+	if f := userSocketFunc; f != nil {
+		return f()
+	}
+	return ""
+}
+
 // MkStateDir ensures that dirPath, the daemon's configuration directory
 // containing machine keys etc, both exists and has the correct permissions.
 // We want it to only be accessible to the user the daemon is running under.
@@ -17,6 +17,8 @@
 
 func init() {
 	stateFileFunc = stateFileUnix
+	userStateDirFunc = userStateDirUnix
+	userSocketFunc = userSocketUnix
 	ensureStateDirPerms = ensureStateDirPermsUnix
 }
 
@@ -61,6 +63,20 @@ func stateFileUnix() string {
 	return filepath.Join(xdgDataHome(), "tailscale", "tailscaled.state")
 }
 
+func userStateDirUnix() string {
+	return filepath.Join(xdgDataHome(), "tailscale")
+}
+
+// userSocketUnix returns $XDG_RUNTIME_DIR/tailscale/tailscaled.sock,
+// falling back to the per-user state directory if $XDG_RUNTIME_DIR is
+// unset.
+func userSocketUnix() string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return filepath.Join(d, "tailscale", "tailscaled.sock")
+	}
+	return filepath.Join(userStateDirUnix(), "tailscaled.sock")
+}
+
 func xdgDataHome() string {
 	if e := os.Getenv("XDG_DATA_HOME"); e != "" {
 		return e
@@ -147,7 +147,11 @@
 //   - 102: 2024-07-12: NodeAttrDisableMagicSockCryptoRouting support
 //   - 103: 2024-07-24: Client supports NodeAttrDisableCaptivePortalDetection
 //   - 104: 2024-08-03: SelfNodeV6MasqAddrForThisPeer now works
-const CurrentCapabilityVersion CapabilityVersion = 104
+//   - 105: 2026-10-16: Client understands RegisterResponse.AuthKeySingleUse and AuthKeyExpired
+//   - 106: 2026-10-16: Client sends Hostinfo.DNSAliases and resolves those of peers with NodeAttrDNSAliases
+//   - 107: 2026-10-16: Client understands NetPortRange.ICMPTypes
+//   - 108: 2026-10-16: Client fails over subnet routes to peers with NodeAttrSubnetRouterStandby
+const CurrentCapabilityVersion CapabilityVersion = 108
 
 type StableID string
 
@@ -649,6 +653,19 @@ func CheckTag(tag string) error {
 	return nil
 }
 
+// CheckDNSAlias validates alias for use as a MagicDNS alias in
+// Hostinfo.DNSAliases. Aliases are single lowercase DNS labels, which are
+// qualified with the tailnet's MagicDNS suffix.
+func CheckDNSAlias(alias string) error {
+	if err := dnsname.ValidLabel(alias); err != nil {
+		return err
+	}
+	if alias != strings.ToLower(alias) {
+		return errors.New("DNS aliases must be lowercase")
+	}
+	return nil
+}
+
 // CheckRequestTags checks that all of h.RequestTags are valid.
 func (h *Hostinfo) CheckRequestTags() error {
 	if h == nil {
@@ -707,6 +724,41 @@ type Service struct {
 	// TODO(apenwarr): add "tags" here for each service?
 }
 
+// ServiceHealth is the health of a DeclaredService.
+type ServiceHealth string
+
+const (
+	ServiceHealthUnknown = ServiceHealth("")
+	ServiceHealthOK      = ServiceHealth("ok")
+	ServiceHealthDown    = ServiceHealth("down")
+)
+
+// DeclaredService is a named service that a node's operator has declared
+// runs on the node, so that peers can find it by name instead of by port
+// number. Unlike Services, which are discovered from the node's listening
+// sockets, declared services are only ever set explicitly.
+type DeclaredService struct {
+	// Name is the name of the service, like "jellyfin". It's a DNS label:
+	// lowercase ASCII letters, digits and dashes.
+	Name string
+
+	// Proto is TCP or UDP.
+	Proto ServiceProto
+
+	// Port is the port the service listens on at the node's Tailscale
+	// addresses.
+	Port uint16
+
+	// Health is the health of the service as last reported for it, or
+	// ServiceHealthUnknown if it hasn't been reported.
+	Health ServiceHealth `json:",omitempty"`
+
+	// HealthCheck is whether the node periodically checks the service's
+	// health itself, by connecting to its port, and reports the result in
+	// Health. It's only valid for TCP services.
+	HealthCheck bool `json:",omitempty"`
+}
+
 // Location represents geographical location data about a
 // Tailscale host. Location is optional and only set if
 // explicitly declared by a node.
@@ -768,30 +820,32 @@ type Hostinfo struct {
 	// App is used to disambiguate Tailscale clients that run using tsnet.
 	App string `json:",omitempty"` // "k8s-operator", "golinks", ...
 
-	Desktop         opt.Bool       `json:",omitempty"` // if a desktop was detected on Linux
-	Package         string         `json:",omitempty"` // Tailscale package to disambiguate ("choco", "appstore", etc; "" for unknown)
-	DeviceModel     string         `json:",omitempty"` // mobile phone model ("Pixel 3a", "iPhone12,3")
-	PushDeviceToken string         `json:",omitempty"` // macOS/iOS APNs device token for notifications (and Android in the future)
-	Hostname        string         `json:",omitempty"` // name of the host the client runs on
-	ShieldsUp       bool           `json:",omitempty"` // indicates whether the host is blocking incoming connections
-	ShareeNode      bool           `json:",omitempty"` // indicates this node exists in netmap because it's owned by a shared-to user
-	NoLogsNoSupport bool           `json:",omitempty"` // indicates that the user has opted out of sending logs and support
-	WireIngress     bool           `json:",omitempty"` // indicates that the node wants the option to receive ingress connections
-	AllowsUpdate    bool           `json:",omitempty"` // indicates that the node has opted-in to admin-console-drive remote updates
-	Machine         string         `json:",omitempty"` // the current host's machine type (uname -m)
-	GoArch          string         `json:",omitempty"` // GOARCH value (of the built binary)
-	GoArchVar       string         `json:",omitempty"` // GOARM, GOAMD64, etc (of the built binary)
-	GoVersion       string         `json:",omitempty"` // Go version binary was built with
-	RoutableIPs     []netip.Prefix `json:",omitempty"` // set of IP ranges this client can route
-	RequestTags     []string       `json:",omitempty"` // set of ACL tags this node wants to claim
-	WoLMACs         []string       `json:",omitempty"` // MAC address(es) to send Wake-on-LAN packets to wake this node (lowercase hex w/ colons)
-	Services        []Service      `json:",omitempty"` // services advertised by this machine
-	NetInfo         *NetInfo       `json:",omitempty"`
-	SSH_HostKeys    []string       `json:"sshHostKeys,omitempty"` // if advertised
-	Cloud           string         `json:",omitempty"`
-	Userspace       opt.Bool       `json:",omitempty"` // if the client is running in userspace (netstack) mode
-	UserspaceRouter opt.Bool       `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
-	AppConnector    opt.Bool       `json:",omitempty"` // if the client is running the app-connector service
+	Desktop          opt.Bool          `json:",omitempty"` // if a desktop was detected on Linux
+	Package          string            `json:",omitempty"` // Tailscale package to disambiguate ("choco", "appstore", etc; "" for unknown)
+	DeviceModel      string            `json:",omitempty"` // mobile phone model ("Pixel 3a", "iPhone12,3")
+	PushDeviceToken  string            `json:",omitempty"` // macOS/iOS APNs device token for notifications (and Android in the future)
+	Hostname         string            `json:",omitempty"` // name of the host the client runs on
+	ShieldsUp        bool              `json:",omitempty"` // indicates whether the host is blocking incoming connections
+	ShareeNode       bool              `json:",omitempty"` // indicates this node exists in netmap because it's owned by a shared-to user
+	NoLogsNoSupport  bool              `json:",omitempty"` // indicates that the user has opted out of sending logs and support
+	WireIngress      bool              `json:",omitempty"` // indicates that the node wants the option to receive ingress connections
+	AllowsUpdate     bool              `json:",omitempty"` // indicates that the node has opted-in to admin-console-drive remote updates
+	Machine          string            `json:",omitempty"` // the current host's machine type (uname -m)
+	GoArch           string            `json:",omitempty"` // GOARCH value (of the built binary)
+	GoArchVar        string            `json:",omitempty"` // GOARM, GOAMD64, etc (of the built binary)
+	GoVersion        string            `json:",omitempty"` // Go version binary was built with
+	RoutableIPs      []netip.Prefix    `json:",omitempty"` // set of IP ranges this client can route
+	RequestTags      []string          `json:",omitempty"` // set of ACL tags this node wants to claim
+	WoLMACs          []string          `json:",omitempty"` // MAC address(es) to send Wake-on-LAN packets to wake this node (lowercase hex w/ colons)
+	Services         []Service         `json:",omitempty"` // services advertised by this machine
+	DeclaredServices []DeclaredService `json:",omitempty"` // named services declared on this machine
+	DNSAliases       []string          `json:",omitempty"` // additional MagicDNS names (single labels) this node wants; see NodeAttrDNSAliases
+	NetInfo          *NetInfo          `json:",omitempty"`
+	SSH_HostKeys     []string          `json:"sshHostKeys,omitempty"` // if advertised
+	Cloud            string            `json:",omitempty"`
+	Userspace        opt.Bool          `json:",omitempty"` // if the client is running in userspace (netstack) mode
+	UserspaceRouter  opt.Bool          `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
+	AppConnector     opt.Bool          `json:",omitempty"` // if the client is running the app-connector service
 
 	// Location represents geographical location data about a
 	// Tailscale host. Location is optional and only set if
@@ -1178,6 +1232,17 @@ type RegisterResponse struct {
 	// Error indicates that authorization failed. If this is non-empty,
 	// other status fields should be ignored.
 	Error string
+
+	// AuthKeySingleUse is whether the request's auth key was a single-use
+	// key, which this registration used up. The client should not send it
+	// again; later registrations of the node are authorized by its old
+	// node key instead.
+	AuthKeySingleUse bool `json:",omitempty"`
+
+	// AuthKeyExpired is set along with Error when the request's auth key
+	// was rejected because it has expired. The client should not retry
+	// with the same key.
+	AuthKeyExpired bool `json:",omitempty"`
 }
 
 // EndpointType distinguishes different sources of MapRequest.Endpoint values.
@@ -1345,6 +1410,20 @@ type NetPortRange struct {
 	IP    string // IP, CIDR, Range, or "*" (same formats as FilterRule.SrcIPs)
 	Bits  *int   // deprecated; the 2020 way to turn IP into a CIDR. See FilterRule.SrcBits.
 	Ports PortRange
+
+	// ICMPTypes, if non-empty, limits the ICMP and ICMPv6 messages allowed
+	// to IP to those of the listed types. Ports doesn't apply to ICMP.
+	// If empty, all ICMP messages to IP are allowed.
+	ICMPTypes []ICMPTypeCodes `json:",omitempty"`
+}
+
+// ICMPTypeCodes matches ICMP or ICMPv6 messages of one type.
+type ICMPTypeCodes struct {
+	Type int // 0-255
+
+	// Codes are the codes of the messages to match, 0-255. If empty,
+	// messages with any code match.
+	Codes []int `json:",omitempty"`
 }
 
 // CapGrant grants capabilities in a FilterRule.
@@ -1382,6 +1461,9 @@ type CapGrant struct {
 	// PeerCapabilityDebugPeer grants the ability for a peer to read this node's
 	// goroutines, metrics, magicsock internal state, etc.
 	PeerCapabilityDebugPeer PeerCapability = "https://tailscale.com/cap/debug-peer"
+	// PeerCapabilitySpeedtest grants the ability for a peer to run throughput
+	// tests ("tailscale debug speedtest") against this node.
+	PeerCapabilitySpeedtest PeerCapability = "tailscale.com/cap/speedtest"
 	// PeerCapabilityWakeOnLAN grants the ability to send a Wake-On-LAN packet.
 	PeerCapabilityWakeOnLAN PeerCapability = "https://tailscale.com/cap/wake-on-lan"
 	// PeerCapabilityIngress grants the ability for a peer to send ingress traffic.
@@ -2333,6 +2415,21 @@ type Oauth2Token struct {
 	// NodeAttrDisableCaptivePortalDetection instructs the client to not perform captive portal detection
 	// automatically when the network state changes.
 	NodeAttrDisableCaptivePortalDetection NodeCapability = "disable-captive-portal-detection"
+
+	// NodeAttrDNSAliases, when set on a node, means that the control plane
+	// permits the node's Hostinfo.DNSAliases, and clients should resolve
+	// each alias under the tailnet's MagicDNS suffix to the node's
+	// addresses. Aliases of nodes without this attribute are ignored.
+	NodeAttrDNSAliases NodeCapability = "dns-aliases"
+
+	// NodeAttrSubnetRouterStandby, when set on a peer, means that the
+	// control plane approved the peer as a standby subnet router for the
+	// routes in the attribute's values, each a JSON string with a CIDR
+	// prefix. While the primary subnet router for one of those routes (see
+	// Node.PrimaryRoutes) doesn't respond to disco pings, clients send the
+	// route's traffic to the standby instead, without waiting for the
+	// control plane to pick a new primary.
+	NodeAttrSubnetRouterStandby NodeCapability = "subnet-router-standby"
 )
 
 // SetDNSRequest is a request to add a DNS record.
@@ -138,6 +138,8 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	dst.RequestTags = append(src.RequestTags[:0:0], src.RequestTags...)
 	dst.WoLMACs = append(src.WoLMACs[:0:0], src.WoLMACs...)
 	dst.Services = append(src.Services[:0:0], src.Services...)
+	dst.DeclaredServices = append(src.DeclaredServices[:0:0], src.DeclaredServices...)
+	dst.DNSAliases = append(src.DNSAliases[:0:0], src.DNSAliases...)
 	dst.NetInfo = src.NetInfo.Clone()
 	dst.SSH_HostKeys = append(src.SSH_HostKeys[:0:0], src.SSH_HostKeys...)
 	if dst.Location != nil {
@@ -148,42 +150,44 @@ func (src *Hostinfo) Clone() *Hostinfo {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoCloneNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	WoLMACs         []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
-	AppConnector    opt.Bool
-	Location        *Location
+	IPNVersion       string
+	FrontendLogID    string
+	BackendLogID     string
+	OS               string
+	OSVersion        string
+	Container        opt.Bool
+	Env              string
+	Distro           string
+	DistroVersion    string
+	DistroCodeName   string
+	App              string
+	Desktop          opt.Bool
+	Package          string
+	DeviceModel      string
+	PushDeviceToken  string
+	Hostname         string
+	ShieldsUp        bool
+	ShareeNode       bool
+	NoLogsNoSupport  bool
+	WireIngress      bool
+	AllowsUpdate     bool
+	Machine          string
+	GoArch           string
+	GoArchVar        string
+	GoVersion        string
+	RoutableIPs      []netip.Prefix
+	RequestTags      []string
+	WoLMACs          []string
+	Services         []Service
+	DeclaredServices []DeclaredService
+	DNSAliases       []string
+	NetInfo          *NetInfo
+	SSH_HostKeys     []string
+	Cloud            string
+	Userspace        opt.Bool
+	UserspaceRouter  opt.Bool
+	AppConnector     opt.Bool
+	Location         *Location
 }{})
 
 // Clone makes a deep copy of NetInfo.
@@ -315,6 +319,8 @@ func (src *RegisterResponse) Clone() *RegisterResponse {
 	AuthURL           string
 	NodeKeySignature  tkatype.MarshaledSignature
 	Error             string
+	AuthKeySingleUse  bool
+	AuthKeyExpired    bool
 }{})
 
 // Clone makes a deep copy of RegisterResponseAuth.
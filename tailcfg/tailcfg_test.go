@@ -60,6 +60,8 @@ func TestHostinfoEqual(t *testing.T) {
 		"RequestTags",
 		"WoLMACs",
 		"Services",
+		"DeclaredServices",
+		"DNSAliases",
 		"NetInfo",
 		"SSH_HostKeys",
 		"Cloud",
@@ -312,12 +312,16 @@ func (v HostinfoView) RoutableIPs() views.Slice[netip.Prefix] { return views.Sli
 func (v HostinfoView) RequestTags() views.Slice[string]       { return views.SliceOf(v.ж.RequestTags) }
 func (v HostinfoView) WoLMACs() views.Slice[string]           { return views.SliceOf(v.ж.WoLMACs) }
 func (v HostinfoView) Services() views.Slice[Service]         { return views.SliceOf(v.ж.Services) }
-func (v HostinfoView) NetInfo() NetInfoView                   { return v.ж.NetInfo.View() }
-func (v HostinfoView) SSH_HostKeys() views.Slice[string]      { return views.SliceOf(v.ж.SSH_HostKeys) }
-func (v HostinfoView) Cloud() string                          { return v.ж.Cloud }
-func (v HostinfoView) Userspace() opt.Bool                    { return v.ж.Userspace }
-func (v HostinfoView) UserspaceRouter() opt.Bool              { return v.ж.UserspaceRouter }
-func (v HostinfoView) AppConnector() opt.Bool                 { return v.ж.AppConnector }
+func (v HostinfoView) DeclaredServices() views.Slice[DeclaredService] {
+	return views.SliceOf(v.ж.DeclaredServices)
+}
+func (v HostinfoView) DNSAliases() views.Slice[string]   { return views.SliceOf(v.ж.DNSAliases) }
+func (v HostinfoView) NetInfo() NetInfoView              { return v.ж.NetInfo.View() }
+func (v HostinfoView) SSH_HostKeys() views.Slice[string] { return views.SliceOf(v.ж.SSH_HostKeys) }
+func (v HostinfoView) Cloud() string                     { return v.ж.Cloud }
+func (v HostinfoView) Userspace() opt.Bool               { return v.ж.Userspace }
+func (v HostinfoView) UserspaceRouter() opt.Bool         { return v.ж.UserspaceRouter }
+func (v HostinfoView) AppConnector() opt.Bool            { return v.ж.AppConnector }
 func (v HostinfoView) Location() *Location {
 	if v.ж.Location == nil {
 		return nil
@@ -330,42 +334,44 @@ func (v HostinfoView) Equal(v2 HostinfoView) bool { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoViewNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	WoLMACs         []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
-	AppConnector    opt.Bool
-	Location        *Location
+	IPNVersion       string
+	FrontendLogID    string
+	BackendLogID     string
+	OS               string
+	OSVersion        string
+	Container        opt.Bool
+	Env              string
+	Distro           string
+	DistroVersion    string
+	DistroCodeName   string
+	App              string
+	Desktop          opt.Bool
+	Package          string
+	DeviceModel      string
+	PushDeviceToken  string
+	Hostname         string
+	ShieldsUp        bool
+	ShareeNode       bool
+	NoLogsNoSupport  bool
+	WireIngress      bool
+	AllowsUpdate     bool
+	Machine          string
+	GoArch           string
+	GoArchVar        string
+	GoVersion        string
+	RoutableIPs      []netip.Prefix
+	RequestTags      []string
+	WoLMACs          []string
+	Services         []Service
+	DeclaredServices []DeclaredService
+	DNSAliases       []string
+	NetInfo          *NetInfo
+	SSH_HostKeys     []string
+	Cloud            string
+	Userspace        opt.Bool
+	UserspaceRouter  opt.Bool
+	AppConnector     opt.Bool
+	Location         *Location
 }{})
 
 // View returns a readonly view of NetInfo.
@@ -643,7 +649,9 @@ func (v RegisterResponseView) AuthURL() string         { return v.ж.AuthURL }
 func (v RegisterResponseView) NodeKeySignature() views.ByteSlice[tkatype.MarshaledSignature] {
 	return views.ByteSliceOf(v.ж.NodeKeySignature)
 }
-func (v RegisterResponseView) Error() string { return v.ж.Error }
+func (v RegisterResponseView) Error() string          { return v.ж.Error }
+func (v RegisterResponseView) AuthKeySingleUse() bool { return v.ж.AuthKeySingleUse }
+func (v RegisterResponseView) AuthKeyExpired() bool   { return v.ж.AuthKeyExpired }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _RegisterResponseViewNeedsRegeneration = RegisterResponse(struct {
@@ -654,6 +662,8 @@ func (v RegisterResponseView) Error() string { return v.ж.Error }
 	AuthURL           string
 	NodeKeySignature  tkatype.MarshaledSignature
 	Error             string
+	AuthKeySingleUse  bool
+	AuthKeyExpired    bool
 }{})
 
 // View returns a readonly view of RegisterResponseAuth.
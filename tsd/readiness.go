@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// The subsystems whose readiness a Readiness tracks.
+const (
+	SubsystemStateStore = "store"
+	SubsystemEngine     = "engine"
+	SubsystemMagicSock  = "magicsock"
+	SubsystemControl    = "control"
+	SubsystemDNS        = "dns"
+	SubsystemRouter     = "router"
+)
+
+// subsystemGraph is the dependency graph of tailscaled's startup, in the
+// order the subsystems start: each can only be ready once the ones it
+// depends on are.
+var subsystemGraph = []struct {
+	name string
+	deps []string
+}{
+	{SubsystemStateStore, nil},
+	{SubsystemEngine, []string{SubsystemStateStore}},
+	{SubsystemMagicSock, []string{SubsystemEngine}},
+	{SubsystemControl, []string{SubsystemMagicSock}},
+	{SubsystemDNS, []string{SubsystemControl}},
+	{SubsystemRouter, []string{SubsystemControl}},
+}
+
+// Readiness tracks which of a System's subsystems are ready, for
+// readiness probes. The zero value is ready for use, with no subsystems
+// ready.
+type Readiness struct {
+	mu      sync.Mutex
+	ready   map[string]bool
+	reason  map[string]string // why a subsystem isn't ready, if known
+	changed chan struct{}     // if non-nil, closed on the next change
+}
+
+// SetReady marks the named subsystem as ready.
+func (r *Readiness) SetReady(name string) {
+	r.set(name, true, "")
+}
+
+// SetNotReady marks the named subsystem as not ready, for the given
+// reason.
+func (r *Readiness) SetNotReady(name, reason string) {
+	r.set(name, false, reason)
+}
+
+func (r *Readiness) set(name string, ready bool, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ready[name] == ready && r.reason[name] == reason {
+		return
+	}
+	if r.ready == nil {
+		r.ready = make(map[string]bool)
+		r.reason = make(map[string]string)
+	}
+	r.ready[name] = ready
+	r.reason[name] = reason
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+// Report reports the readiness of the named subsystem and those it
+// depends on, or of all subsystems if name is empty.
+func (r *Readiness) Report(name string) (*apitype.ReadyzResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reportLocked(name)
+}
+
+func (r *Readiness) reportLocked(name string) (*apitype.ReadyzResponse, error) {
+	want := map[string]bool{}
+	if name == "" {
+		for _, s := range subsystemGraph {
+			want[s.name] = true
+		}
+	} else {
+		want[name] = true
+	}
+	// Walk the graph backwards to add the dependencies of the wanted
+	// subsystems.
+	for i := len(subsystemGraph) - 1; i >= 0; i-- {
+		if s := subsystemGraph[i]; want[s.name] {
+			for _, d := range s.deps {
+				want[d] = true
+			}
+		}
+	}
+
+	res := &apitype.ReadyzResponse{Ready: true}
+	ready := map[string]bool{}
+	for _, s := range subsystemGraph {
+		if !want[s.name] {
+			continue
+		}
+		delete(want, s.name)
+		sr := apitype.SubsystemReadiness{
+			Name:      s.name,
+			Ready:     r.ready[s.name],
+			Reason:    r.reason[s.name],
+			DependsOn: slices.Clone(s.deps),
+		}
+		for _, d := range s.deps {
+			if !ready[d] {
+				sr.Ready = false
+				sr.Reason = fmt.Sprintf("waiting for %s", d)
+				break
+			}
+		}
+		if !sr.Ready && sr.Reason == "" {
+			sr.Reason = "starting"
+		}
+		ready[s.name] = sr.Ready
+		res.Ready = res.Ready && sr.Ready
+		res.Subsystems = append(res.Subsystems, sr)
+	}
+	if len(want) > 0 {
+		return nil, fmt.Errorf("unknown subsystem %q", name)
+	}
+	return res, nil
+}
+
+// Wait waits until the named subsystem and those it depends on, or all
+// subsystems if name is empty, are ready, or until ctx is done. It returns
+// the last report, along with ctx's error if ctx is done first.
+func (r *Readiness) Wait(ctx context.Context, name string) (*apitype.ReadyzResponse, error) {
+	for {
+		r.mu.Lock()
+		res, err := r.reportLocked(name)
+		if err != nil || res.Ready {
+			r.mu.Unlock()
+			return res, err
+		}
+		if r.changed == nil {
+			r.changed = make(chan struct{})
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	var r Readiness
+	readyNames := func(name string) (ready, notReady []string) {
+		t.Helper()
+		res, err := r.Report(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range res.Subsystems {
+			if s.Ready {
+				ready = append(ready, s.Name)
+			} else {
+				notReady = append(notReady, s.Name)
+			}
+		}
+		if res.Ready != (len(notReady) == 0) {
+			t.Errorf("Report(%q).Ready = %v with %v not ready", name, res.Ready, notReady)
+		}
+		return ready, notReady
+	}
+
+	if ready, _ := readyNames(""); len(ready) != 0 {
+		t.Errorf("ready at start: %v", ready)
+	}
+
+	// A subsystem isn't ready until those it depends on are.
+	r.SetReady(SubsystemEngine)
+	r.SetReady(SubsystemMagicSock)
+	if ready, _ := readyNames(SubsystemMagicSock); len(ready) != 0 {
+		t.Errorf("ready without state store: %v", ready)
+	}
+	r.SetReady(SubsystemStateStore)
+	if _, notReady := readyNames(SubsystemMagicSock); len(notReady) != 0 {
+		t.Errorf("not ready: %v", notReady)
+	}
+	if _, notReady := readyNames(""); len(notReady) != 3 {
+		t.Errorf("not ready = %v; want control, dns and router", notReady)
+	}
+
+	res, _ := r.Report(SubsystemDNS)
+	if len(res.Subsystems) != 5 {
+		t.Errorf("report for dns has %d subsystems; want it and its 4 dependencies", len(res.Subsystems))
+	}
+	if _, err := r.Report("bogus"); err == nil {
+		t.Error("Report of unknown subsystem succeeded")
+	}
+
+	// Wait returns once everything is ready.
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Wait(context.Background(), "")
+		done <- err
+	}()
+	r.SetReady(SubsystemControl)
+	r.SetReady(SubsystemDNS)
+	r.SetNotReady(SubsystemRouter, "testing")
+	select {
+	case <-done:
+		t.Fatal("Wait returned before router was ready")
+	case <-time.After(10 * time.Millisecond):
+	}
+	r.SetReady(SubsystemRouter)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return once ready")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.SetNotReady(SubsystemControl, "logged out")
+	res, err := r.Wait(ctx, SubsystemRouter)
+	if err == nil || res.Ready {
+		t.Errorf("Wait with canceled context = %v, %v; want not ready and error", res.Ready, err)
+	}
+}
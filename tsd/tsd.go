@@ -66,6 +66,7 @@ type System struct {
 	proxyMap     proxymap.Mapper
 
 	healthTracker health.Tracker
+	readiness     Readiness
 }
 
 // NetstackImpl is the interface that *netstack.Impl implements.
@@ -88,6 +89,7 @@ func (s *System) Set(v any) {
 		s.Dialer.Set(v)
 	case wgengine.Engine:
 		s.Engine.Set(v)
+		s.readiness.SetReady(SubsystemEngine)
 	case router.Router:
 		s.Router.Set(v)
 	case *tstun.Wrapper:
@@ -100,8 +102,10 @@ type ft interface {
 		s.Tun.Set(v)
 	case *magicsock.Conn:
 		s.MagicSock.Set(v)
+		s.readiness.SetReady(SubsystemMagicSock)
 	case ipn.StateStore:
 		s.StateStore.Set(v)
+		s.readiness.SetReady(SubsystemStateStore)
 	case NetstackImpl:
 		s.Netstack.Set(v)
 	case drive.FileSystemForLocal:
@@ -142,6 +146,13 @@ func (s *System) HealthTracker() *health.Tracker {
 	return &s.healthTracker
 }
 
+// Readiness returns the readiness of the system's subsystems. Setting
+// the state store, engine or magicsock marks them ready; the rest are
+// marked by LocalBackend.
+func (s *System) Readiness() *Readiness {
+	return &s.readiness
+}
+
 // SubSystem represents some subsystem of the Tailscale node daemon.
 //
 // A subsystem can be set to a value, and then later retrieved. A subsystem
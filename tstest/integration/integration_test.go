@@ -345,6 +345,32 @@ func TestConfigFileAuthKey(t *testing.T) {
 	d1.MustCleanShutdown(t)
 }
 
+func TestOneNodeUpExpiredAuthKey(t *testing.T) {
+	tstest.Shard(t)
+	tstest.Parallel(t)
+	const authKey = "opensesame"
+	env := newTestEnv(t, configureControl(func(control *testcontrol.Server) {
+		control.RequireAuthKey = authKey
+		control.AuthKeyExpired = true
+	}))
+	n1 := newTestNode(t, env)
+
+	d1 := n1.StartDaemon()
+	defer d1.MustCleanShutdown(t)
+	n1.AwaitResponding()
+
+	cmd := n1.Tailscale("up", "--login-server="+n1.env.controlURL(), "--authkey="+authKey)
+	cmd.Stdout = nil // in case --verbose-tailscale was set
+	cmd.Stderr = nil // in case --verbose-tailscale was set
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("up with an expired auth key succeeded: %s", out)
+	}
+	if !strings.Contains(string(out), "auth key expired") {
+		t.Errorf("up with an expired auth key didn't say so: %s", out)
+	}
+}
+
 func TestTwoNodes(t *testing.T) {
 	tstest.Shard(t)
 	tstest.Parallel(t)
@@ -54,6 +54,13 @@ type Server struct {
 	MagicDNSDomain string
 	HandleC2N      http.Handler // if non-nil, used for /some-c2n-path/ in tests
 
+	// AuthKeySingleUse is whether RequireAuthKey is spent by the first
+	// registration that uses it, after which nodes can only re-register
+	// with their old node keys.
+	AuthKeySingleUse bool
+	// AuthKeyExpired is whether RequireAuthKey is rejected as expired.
+	AuthKeyExpired bool
+
 	// ExplicitBaseURL or HTTPTestServer must be set.
 	ExplicitBaseURL string           // e.g. "http://127.0.0.1:1234" with no trailing URL
 	HTTPTestServer  *httptest.Server // if non-nil, used to get BaseURL
@@ -98,6 +105,7 @@ type Server struct {
 	nodeKeyAuthed map[key.NodePublic]bool // key => true once authenticated
 	msgToSend     map[key.NodePublic]any  // value is *tailcfg.PingRequest or entire *tailcfg.MapResponse
 	allExpired    bool                    // All nodes will be told their node key is expired.
+	authKeySpent  bool                    // whether a single-use RequireAuthKey was used
 }
 
 // BaseURL returns the server's base URL, without trailing slash.
@@ -603,13 +611,37 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		j, _ := json.MarshalIndent(req, "", "\t")
 		log.Printf("Got %T: %s", req, j)
 	}
-	if s.RequireAuthKey != "" && (req.Auth == nil || req.Auth.AuthKey != s.RequireAuthKey) {
-		res := must.Get(s.encode(false, tailcfg.RegisterResponse{
-			Error: "invalid authkey",
-		}))
-		w.WriteHeader(200)
-		w.Write(res)
-		return
+	var authKey string
+	if req.Auth != nil {
+		authKey = req.Auth.AuthKey
+	}
+	var authKeySpentNow bool
+	if s.RequireAuthKey != "" {
+		s.mu.Lock()
+		// Nodes that are already registered may re-register (to
+		// rotate their node key, for instance) without an auth key.
+		known := s.nodes[req.NodeKey] != nil || (!req.OldNodeKey.IsZero() && s.nodes[req.OldNodeKey] != nil)
+		var resp tailcfg.RegisterResponse
+		switch {
+		case authKey == "" && known:
+		case authKey != s.RequireAuthKey:
+			resp.Error = "invalid authkey"
+		case s.AuthKeyExpired:
+			resp.Error = "authkey expired"
+			resp.AuthKeyExpired = true
+		case s.authKeySpent:
+			resp.Error = "authkey already used"
+		case s.AuthKeySingleUse:
+			s.authKeySpent = true
+			authKeySpentNow = true
+		}
+		s.mu.Unlock()
+		if resp.Error != "" {
+			res := must.Get(s.encode(false, resp))
+			w.WriteHeader(200)
+			w.Write(res)
+			return
+		}
 	}
 
 	// If this is a followup request, wait until interactive followup URL visit complete.
@@ -684,6 +716,7 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		NodeKeyExpired:    allExpired,
 		MachineAuthorized: machineAuthorized,
 		AuthURL:           authURL,
+		AuthKeySingleUse:  authKeySpentNow,
 	})
 	if err != nil {
 		go panic(fmt.Sprintf("serveRegister: encode: %v", err))
@@ -0,0 +1,92 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlab
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// LinkConditions describes the impairments applied to packets crossing
+// a Network, to simulate a WAN link rather than an ideal in-memory one.
+//
+// The zero value is a perfect link: no delay, no loss, no reordering.
+type LinkConditions struct {
+	// Latency is the base one-way delay added to each packet.
+	Latency time.Duration
+
+	// Jitter is the maximum extra delay added to each packet, chosen
+	// uniformly from [0, Jitter). Jitter larger than the gap between
+	// packets reorders them.
+	Jitter time.Duration
+
+	// LatencyFunc, if non-nil, returns the one-way delay for each packet
+	// and replaces Latency and Jitter. It can be used to model arbitrary
+	// latency distributions. It is called with the Network's lock held
+	// and must not call back into the Network.
+	LatencyFunc func(r *rand.Rand) time.Duration
+
+	// LossRate is the probability, in the range [0, 1], that a packet is
+	// silently dropped.
+	LossRate float64
+
+	// ReorderRate is the probability, in the range [0, 1], that a packet
+	// is held back by an additional ReorderDelay, letting packets sent
+	// after it overtake it.
+	ReorderRate float64
+
+	// ReorderDelay is the extra delay applied to packets selected by
+	// ReorderRate. If zero, 10ms is used.
+	ReorderDelay time.Duration
+
+	// Seed seeds the random source used for the decisions above,
+	// making them reproducible across test runs. If zero, a random
+	// seed is used.
+	Seed uint64
+}
+
+// SetLinkConditions sets the impairments applied to packets crossing n.
+// It replaces any previously set conditions and resets n's random
+// source from lc.Seed.
+func (n *Network) SetLinkConditions(lc LinkConditions) {
+	seed := lc.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.link = lc
+	n.linkRand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// impairLocked decides the fate of a packet crossing n according to its
+// LinkConditions. It reports whether the packet should be dropped and,
+// if not, how long its delivery should be delayed.
+//
+// n.mu must be held.
+func (n *Network) impairLocked() (delay time.Duration, drop bool) {
+	lc, r := &n.link, n.linkRand
+	if r == nil {
+		return 0, false
+	}
+	if lc.LossRate > 0 && r.Float64() < lc.LossRate {
+		return 0, true
+	}
+	if lc.LatencyFunc != nil {
+		delay = lc.LatencyFunc(r)
+	} else {
+		delay = lc.Latency
+		if lc.Jitter > 0 {
+			delay += time.Duration(r.Int64N(int64(lc.Jitter)))
+		}
+	}
+	if lc.ReorderRate > 0 && r.Float64() < lc.ReorderRate {
+		if lc.ReorderDelay > 0 {
+			delay += lc.ReorderDelay
+		} else {
+			delay += 10 * time.Millisecond
+		}
+	}
+	return max(delay, 0), false
+}
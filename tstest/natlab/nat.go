@@ -69,6 +69,78 @@ func (t NATType) key(src, dst netip.AddrPort) natKey {
 	return k
 }
 
+// NATKind is a common combination of NAT mapping and filtering
+// behavior, named after the classic cone NAT taxonomy of RFC 3489.
+type NATKind int
+
+const (
+	// FullConeNAT has endpoint-independent mapping and filtering:
+	// once a LAN ip:port has a mapping, anyone can reach it.
+	FullConeNAT NATKind = iota
+	// RestrictedConeNAT has endpoint-independent mapping and
+	// address-dependent filtering.
+	RestrictedConeNAT
+	// PortRestrictedConeNAT has endpoint-independent mapping and
+	// address-and-port-dependent filtering.
+	PortRestrictedConeNAT
+	// SymmetricNAT has address-and-port-dependent mapping and
+	// filtering. It's the hardest kind of NAT to traverse.
+	SymmetricNAT
+)
+
+func (k NATKind) String() string {
+	switch k {
+	case FullConeNAT:
+		return "full-cone"
+	case RestrictedConeNAT:
+		return "restricted-cone"
+	case PortRestrictedConeNAT:
+		return "port-restricted-cone"
+	case SymmetricNAT:
+		return "symmetric"
+	}
+	return fmt.Sprintf("NATKind(%d)", int(k))
+}
+
+// NewSNAT44 returns an SNAT44 with the mapping and filtering behavior of
+// kind, attached to m and translating packets from lanIf onto wanIf.
+// The returned NAT can be further configured before it's assigned to
+// m.PacketHandler.
+func NewSNAT44(kind NATKind, m *Machine, wanIf, lanIf *Interface) *SNAT44 {
+	mapping, filtering := EndpointIndependentNAT, EndpointIndependentFirewall
+	switch kind {
+	case FullConeNAT:
+	case RestrictedConeNAT:
+		filtering = AddressDependentFirewall
+	case PortRestrictedConeNAT:
+		filtering = AddressAndPortDependentFirewall
+	case SymmetricNAT:
+		mapping, filtering = AddressAndPortDependentNAT, AddressAndPortDependentFirewall
+	default:
+		panic(fmt.Sprintf("unknown NAT kind %v", kind))
+	}
+	return &SNAT44{
+		Machine:           m,
+		ExternalInterface: wanIf,
+		Type:              mapping,
+		Firewall: &Firewall{
+			TrustedInterface: lanIf,
+			Type:             filtering,
+		},
+	}
+}
+
+// NewCGNATNetwork returns a Network using the RFC 6598 shared address
+// space, as found between a carrier-grade NAT and its subscribers' home
+// routers. Attaching a home NAT's WAN interface to it and giving it a
+// CGNAT box as default gateway models double NAT.
+func NewCGNATNetwork(name string) *Network {
+	return &Network{
+		Name:    name,
+		Prefix4: mustPrefix("100.64.0.0/10"),
+	}
+}
+
 // DefaultMappingTimeout is the default timeout for a NAT mapping.
 const DefaultMappingTimeout = 30 * time.Second
 
@@ -93,6 +165,18 @@ type SNAT44 struct {
 	// outbound direction and after translation in the inbound
 	// direction.
 	Firewall PacketHandler
+	// PortPreservation, if true, makes the NAT try to map a LAN
+	// ip:port to the same port on its WAN address, falling back to a
+	// random port if that port is already in use. Many home routers
+	// behave this way.
+	PortPreservation bool
+	// Hairpin, if true, lets hosts behind the NAT reach each other
+	// via the NAT's WAN mappings: a packet sent from the LAN to one
+	// of the NAT's own WAN ip:port mappings is translated and
+	// looped back onto the LAN, as if it had arrived from the
+	// outside. If false, such packets are dropped, as many
+	// real-world NATs do.
+	Hairpin bool
 	// TimeNow is a function that returns the current time. If
 	// nil, time.Now is used.
 	TimeNow func() time.Time
@@ -135,6 +219,10 @@ func (n *SNAT44) HandleOut(p *Packet, oif *Interface) *Packet {
 }
 
 func (n *SNAT44) HandleIn(p *Packet, iif *Interface) *Packet {
+	if iif != n.ExternalInterface && p.Dst.Addr() == n.ExternalInterface.V4() {
+		// A LAN host is trying to reach one of our WAN mappings.
+		return n.handleHairpin(p)
+	}
 	if iif != n.ExternalInterface {
 		// NAT can't apply, defer to firewall.
 		if n.Firewall != nil {
@@ -191,22 +279,7 @@ func (n *SNAT44) HandleForward(p *Packet, iif, oif *Interface) *Packet {
 		defer n.mu.Unlock()
 		n.initLocked()
 
-		k := n.Type.key(p.Src, p.Dst)
-		now := n.timeNow()
-		m := n.byLAN[k]
-		if m == nil || now.After(m.deadline) {
-			pc, wanAddr := n.allocateMappedPort()
-			m = &mapping{
-				lanSrc: p.Src,
-				lanDst: p.Dst,
-				wanSrc: wanAddr,
-				pc:     pc,
-			}
-			n.byLAN[k] = m
-			n.byWAN[wanAddr] = m
-		}
-		m.deadline = now.Add(n.mappingTimeout())
-		p.Src = m.wanSrc
+		p.Src = n.mappingLocked(p.Src, p.Dst).wanSrc
 		p.Trace("snat from %v", p.Src)
 		return p
 	case iif == n.ExternalInterface:
@@ -225,13 +298,72 @@ func (n *SNAT44) HandleForward(p *Packet, iif, oif *Interface) *Packet {
 	}
 }
 
-func (n *SNAT44) allocateMappedPort() (net.PacketConn, netip.AddrPort) {
+// mappingLocked returns the live mapping for a packet from lanSrc to dst,
+// allocating a new one if needed, and extends its lifetime.
+//
+// n.mu must be held.
+func (n *SNAT44) mappingLocked(lanSrc, dst netip.AddrPort) *mapping {
+	k := n.Type.key(lanSrc, dst)
+	now := n.timeNow()
+	m := n.byLAN[k]
+	if m == nil || now.After(m.deadline) {
+		pc, wanAddr := n.allocateMappedPort(lanSrc.Port())
+		m = &mapping{
+			lanSrc: lanSrc,
+			lanDst: dst,
+			wanSrc: wanAddr,
+			pc:     pc,
+		}
+		n.byLAN[k] = m
+		n.byWAN[wanAddr] = m
+	}
+	m.deadline = now.Add(n.mappingTimeout())
+	return m
+}
+
+// handleHairpin handles a packet from the LAN addressed to the NAT's own
+// WAN IP.
+func (n *SNAT44) handleHairpin(p *Packet) *Packet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.initLocked()
+
+	dst := n.byWAN[p.Dst]
+	if dst == nil || n.timeNow().After(dst.deadline) {
+		// Not one of our mappings; it's for a local socket on the
+		// NAT box itself.
+		return p
+	}
+	if !n.Hairpin {
+		p.Trace("hairpin disabled, drop")
+		return nil
+	}
+	// Translate both ends, so that the destination sees the packet
+	// coming from the sender's WAN mapping, just like traffic from
+	// outside the NAT. Replies then hairpin back the same way.
+	p.Src = n.mappingLocked(p.Src, p.Dst).wanSrc
+	p.Dst = dst.lanSrc
+	p.Trace("hairpin %v -> %v", p.Src, p.Dst)
+	return p
+}
+
+// allocateMappedPort reserves a port on the NAT's WAN address for a new
+// mapping. If n.PortPreservation is set, it first tries to reserve
+// lanPort.
+func (n *SNAT44) allocateMappedPort(lanPort uint16) (net.PacketConn, netip.AddrPort) {
 	// Clean up old entries before trying to allocate, to free up any
 	// expired ports.
 	n.gc()
 
 	ip := n.ExternalInterface.V4()
-	pc, err := n.Machine.ListenPacket(context.Background(), "udp", net.JoinHostPort(ip.String(), "0"))
+	var pc net.PacketConn
+	var err error
+	if n.PortPreservation && lanPort != 0 {
+		pc, err = n.Machine.ListenPacket(context.Background(), "udp", netip.AddrPortFrom(ip, lanPort).String())
+	}
+	if pc == nil {
+		pc, err = n.Machine.ListenPacket(context.Background(), "udp", net.JoinHostPort(ip.String(), "0"))
+	}
 	if err != nil {
 		panic(fmt.Sprintf("ran out of NAT ports: %v", err))
 	}
@@ -107,6 +107,8 @@ type Network struct {
 	defaultGW *Interface // optional
 	lastV4    netip.Addr
 	lastV6    netip.Addr
+	link      LinkConditions // impairments; see SetLinkConditions
+	linkRand  *rand.Rand     // nil until SetLinkConditions
 }
 
 func (n *Network) SetDefaultGateway(gwIf *Interface) {
@@ -200,10 +202,20 @@ func (n *Network) write(p *Packet) (num int, err error) {
 		iface = n.defaultGW
 	}
 
+	delay, drop := n.impairLocked()
+	if drop {
+		p.Trace("dropped by link conditions")
+		return len(p.Payload), nil
+	}
+
 	// Pretend it went across the network. Make a copy so nobody
 	// can later mess with caller's memory.
-	p.Trace("-> mach=%s if=%s", iface.machine.Name, iface.name)
-	go iface.machine.deliverIncomingPacket(p, iface)
+	p.Trace("-> mach=%s if=%s delay=%v", iface.machine.Name, iface.name, delay)
+	if delay > 0 {
+		time.AfterFunc(delay, func() { iface.machine.deliverIncomingPacket(p, iface) })
+	} else {
+		go iface.machine.deliverIncomingPacket(p, iface)
+	}
 	return len(p.Payload), nil
 }
 
@@ -507,3 +507,277 @@ func testNAT(t *testing.T, n *SNAT44, lanIf, wanIf *Interface, tests []natTest)
 		}
 	}
 }
+
+func TestLinkConditions(t *testing.T) {
+	internet := NewInternet()
+
+	foo := &Machine{Name: "foo"}
+	bar := &Machine{Name: "bar"}
+	ifFoo := foo.Attach("eth0", internet)
+	ifBar := bar.Attach("eth0", internet)
+
+	ctx := context.Background()
+	fooPC, err := foo.ListenPacket(ctx, "udp4", netip.AddrPortFrom(ifFoo.V4(), 123).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	barAddr := netip.AddrPortFrom(ifBar.V4(), 456)
+	barPC, err := bar.ListenPacket(ctx, "udp4", barAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// natlab conns don't support read deadlines, so read in the
+	// background and time out on the channel instead.
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := barPC.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			got <- string(buf[:n])
+		}
+	}()
+	t.Cleanup(func() { barPC.Close() })
+
+	// send sends msg from foo to bar and returns how long it took to
+	// arrive, or an error if it didn't arrive within timeout.
+	send := func(msg string, timeout time.Duration) (time.Duration, error) {
+		start := time.Now()
+		if _, err := fooPC.WriteTo([]byte(msg), net.UDPAddrFromAddrPort(barAddr)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-got:
+			if m != msg {
+				t.Fatalf("read %q; want %q", m, msg)
+			}
+			return time.Since(start), nil
+		case <-time.After(timeout):
+			return 0, fmt.Errorf("%q not received after %v", msg, timeout)
+		}
+	}
+
+	internet.SetLinkConditions(LinkConditions{Latency: 50 * time.Millisecond, Seed: 1})
+	d, err := send("delayed", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d < 50*time.Millisecond {
+		t.Errorf("packet arrived after %v; want at least 50ms", d)
+	}
+
+	internet.SetLinkConditions(LinkConditions{LossRate: 1, Seed: 1})
+	if _, err := send("lost", 100*time.Millisecond); err == nil {
+		t.Error("packet arrived on a link with 100% loss")
+	}
+
+	internet.SetLinkConditions(LinkConditions{})
+	if _, err := send("perfect", 5*time.Second); err != nil {
+		t.Errorf("perfect link: %v", err)
+	}
+}
+
+func TestImpairSeeded(t *testing.T) {
+	lc := LinkConditions{
+		Latency:     10 * time.Millisecond,
+		Jitter:      20 * time.Millisecond,
+		LossRate:    0.3,
+		ReorderRate: 0.2,
+		Seed:        42,
+	}
+	run := func() (ret []time.Duration) {
+		n := NewInternet()
+		n.SetLinkConditions(lc)
+		for range 100 {
+			delay, drop := n.impairLocked()
+			if drop {
+				delay = -1
+			}
+			ret = append(ret, delay)
+		}
+		return ret
+	}
+	a, b := run(), run()
+	var drops int
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("decision %d differs between runs with same seed: %v vs %v", i, a[i], b[i])
+		}
+		if a[i] < 0 {
+			drops++
+			continue
+		}
+		if a[i] < lc.Latency {
+			t.Errorf("delay %v below base latency %v", a[i], lc.Latency)
+		}
+	}
+	if drops == 0 || drops == len(a) {
+		t.Errorf("got %d/%d drops at loss rate %v", drops, len(a), lc.LossRate)
+	}
+}
+
+func TestNATPortPreservation(t *testing.T) {
+	internet := NewInternet()
+	lan := &Network{
+		Name:    "LAN",
+		Prefix4: mustPrefix("192.168.0.0/24"),
+	}
+	m := &Machine{Name: "NAT"}
+	wanIf := m.Attach("wan", internet)
+	lanIf := m.Attach("lan", lan)
+
+	n := NewSNAT44(PortRestrictedConeNAT, m, wanIf, lanIf)
+	n.PortPreservation = true
+
+	out := func(src string) netip.AddrPort {
+		t.Helper()
+		p := n.HandleForward(&Packet{Src: ipp(src), Dst: ipp("2.2.2.2:5678")}, lanIf, wanIf)
+		if p == nil {
+			t.Fatalf("packet from %v dropped", src)
+		}
+		return p.Src
+	}
+
+	if got, want := out("192.168.0.20:1234"), netip.AddrPortFrom(wanIf.V4(), 1234); got != want {
+		t.Errorf("first mapping = %v; want %v", got, want)
+	}
+	// A second LAN host using the same port can't have it preserved.
+	if got := out("192.168.0.21:1234"); got.Port() == 1234 {
+		t.Errorf("second mapping = %v; want a different port", got)
+	}
+}
+
+func TestNATHairpin(t *testing.T) {
+	for _, hairpin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hairpin=%v", hairpin), func(t *testing.T) {
+			internet := NewInternet()
+			lan := &Network{
+				Name:    "LAN",
+				Prefix4: mustPrefix("192.168.0.0/24"),
+			}
+			m := &Machine{Name: "NAT"}
+			wanIf := m.Attach("wan", internet)
+			lanIf := m.Attach("lan", lan)
+
+			n := NewSNAT44(FullConeNAT, m, wanIf, lanIf)
+			n.Hairpin = hairpin
+
+			a, b := ipp("192.168.0.20:1234"), ipp("192.168.0.21:2345")
+			server := ipp("2.2.2.2:5678")
+			aWAN := n.HandleForward(&Packet{Src: a, Dst: server}, lanIf, wanIf).Src
+			bWAN := n.HandleForward(&Packet{Src: b, Dst: server}, lanIf, wanIf).Src
+
+			p := n.HandleIn(&Packet{Src: b, Dst: aWAN}, lanIf)
+			if !hairpin {
+				if p != nil {
+					t.Fatalf("hairpin packet got through as %v -> %v; want drop", p.Src, p.Dst)
+				}
+				return
+			}
+			if p == nil {
+				t.Fatal("hairpin packet dropped")
+			}
+			if p.Src != bWAN || p.Dst != a {
+				t.Errorf("hairpin packet = %v -> %v; want %v -> %v", p.Src, p.Dst, bWAN, a)
+			}
+		})
+	}
+}
+
+func TestNewSNAT44(t *testing.T) {
+	tests := []struct {
+		kind          NATKind
+		wantMapping   NATType
+		wantFiltering FirewallType
+	}{
+		{FullConeNAT, EndpointIndependentNAT, EndpointIndependentFirewall},
+		{RestrictedConeNAT, EndpointIndependentNAT, AddressDependentFirewall},
+		{PortRestrictedConeNAT, EndpointIndependentNAT, AddressAndPortDependentFirewall},
+		{SymmetricNAT, AddressAndPortDependentNAT, AddressAndPortDependentFirewall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind.String(), func(t *testing.T) {
+			n := NewSNAT44(tt.kind, &Machine{}, nil, nil)
+			if n.Type != tt.wantMapping {
+				t.Errorf("mapping = %v; want %v", n.Type, tt.wantMapping)
+			}
+			if got := n.Firewall.(*Firewall).Type; got != tt.wantFiltering {
+				t.Errorf("filtering = %v; want %v", got, tt.wantFiltering)
+			}
+		})
+	}
+}
+
+func TestDoubleNAT(t *testing.T) {
+	internet := NewInternet()
+	cgnat := NewCGNATNetwork("cgnat")
+	home := &Network{
+		Name:    "home",
+		Prefix4: mustPrefix("192.168.0.0/24"),
+	}
+
+	client := &Machine{Name: "client"}
+	router := &Machine{Name: "router"}
+	cgn := &Machine{Name: "cgn"}
+	server := &Machine{Name: "server"}
+
+	client.Attach("eth0", home)
+	routerWAN := router.Attach("wan", cgnat)
+	routerLAN := router.Attach("lan", home)
+	cgnWAN := cgn.Attach("wan", internet)
+	cgnLAN := cgn.Attach("lan", cgnat)
+	ifServer := server.Attach("eth0", internet)
+
+	if !mustPrefix("100.64.0.0/10").Contains(routerWAN.V4()) {
+		t.Fatalf("router WAN IP %v not in CGNAT space", routerWAN.V4())
+	}
+
+	home.SetDefaultGateway(routerLAN)
+	cgnat.SetDefaultGateway(cgnLAN)
+	router.PacketHandler = NewSNAT44(PortRestrictedConeNAT, router, routerWAN, routerLAN)
+	cgn.PacketHandler = NewSNAT44(SymmetricNAT, cgn, cgnWAN, cgnLAN)
+
+	ctx := context.Background()
+	clientPC, err := client.ListenPacket(ctx, "udp4", ":123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPC, err := server.ListenPacket(ctx, "udp4", ":456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const msg, reply = "hello", "world"
+	serverAddr := netip.AddrPortFrom(ifServer.V4(), 456)
+	if _, err := clientPC.WriteTo([]byte(msg), net.UDPAddrFromAddrPort(serverAddr)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, addr, err := serverPC.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != msg {
+		t.Errorf("read %q; want %q", buf[:n], msg)
+	}
+	if got := addr.(*net.UDPAddr).AddrPort().Addr(); got != cgnWAN.V4() {
+		t.Errorf("server saw packet from %v; want CGN WAN IP %v", got, cgnWAN.V4())
+	}
+
+	if _, err := serverPC.WriteTo([]byte(reply), addr); err != nil {
+		t.Fatal(err)
+	}
+	n, addr, err = clientPC.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != reply {
+		t.Errorf("read %q; want %q", buf[:n], reply)
+	}
+	if addr.String() != serverAddr.String() {
+		t.Errorf("reply from %v; want %v", addr, serverAddr)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlab
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Outcome is how two machines of a Scenario end up talking to each other.
+type Outcome int
+
+const (
+	// OutcomeDirect is when NAT traversal finds a direct path.
+	OutcomeDirect Outcome = iota
+	// OutcomeDERP is when NAT traversal fails and traffic stays relayed.
+	OutcomeDERP
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeDirect:
+		return "direct"
+	case OutcomeDERP:
+		return "DERP"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// A Side describes how one of the two machines of a Scenario reaches the
+// internet. The zero value is a machine attached to the internet directly,
+// without a firewall.
+type Side struct {
+	// Firewall is whether the machine runs a stateful,
+	// address-and-port-dependent firewall of its own.
+	Firewall bool
+
+	// NAT is whether the machine is on a LAN behind a NAT of kind
+	// NATKind.
+	NAT     bool
+	NATKind NATKind
+
+	// CGNAT is whether the NAT is itself behind a symmetric
+	// carrier-grade NAT, modeling double NAT. It requires NAT.
+	CGNAT bool
+}
+
+// String returns a short description of s, such as "fw+nat:symmetric", for
+// names of tests and reports.
+func (s Side) String() string {
+	var parts []string
+	if s.Firewall {
+		parts = append(parts, "fw")
+	}
+	if s.NAT {
+		parts = append(parts, "nat:"+s.NATKind.String())
+	}
+	if s.CGNAT {
+		parts = append(parts, "cgnat")
+	}
+	if len(parts) == 0 {
+		return "public"
+	}
+	return strings.Join(parts, "+")
+}
+
+// hardMapping reports whether the mapping of s's NAT depends on the
+// destination, so peers can't learn the port to reach it at from a STUN
+// server.
+func (s Side) hardMapping() bool {
+	return s.CGNAT || (s.NAT && s.NATKind == SymmetricNAT)
+}
+
+// portFiltering reports whether s only lets in packets from the exact
+// ip:port it sent to.
+func (s Side) portFiltering() bool {
+	return s.Firewall || s.CGNAT ||
+		(s.NAT && (s.NATKind == PortRestrictedConeNAT || s.NATKind == SymmetricNAT))
+}
+
+// ExpectedOutcome returns the outcome of NAT traversal between machines
+// reaching the internet as a and b, by the usual rules: a side with a
+// destination-dependent mapping can only be reached directly by a side
+// that doesn't filter by port, so two such sides, or one and a side that
+// filters by port, stay on DERP.
+func ExpectedOutcome(a, b Side) Outcome {
+	if (a.hardMapping() && (b.hardMapping() || b.portFiltering())) ||
+		(b.hardMapping() && a.portFiltering()) {
+		return OutcomeDERP
+	}
+	return OutcomeDirect
+}
+
+// A Scenario is a NAT traversal test case: two machines, reaching the
+// internet as A and B, and the outcome expected of NAT traversal between
+// them.
+type Scenario struct {
+	A, B Side
+	Want Outcome
+}
+
+// Name returns a name for s, such as "public/nat:symmetric", suitable for
+// subtests.
+func (s Scenario) Name() string {
+	return s.A.String() + "/" + s.B.String()
+}
+
+// Matrix returns a Scenario for each unordered pair of sides, including a
+// side paired with itself, expecting the outcomes from ExpectedOutcome.
+func Matrix(sides []Side) []Scenario {
+	var ret []Scenario
+	for i, a := range sides {
+		for _, b := range sides[i:] {
+			ret = append(ret, Scenario{A: a, B: b, Want: ExpectedOutcome(a, b)})
+		}
+	}
+	return ret
+}
+
+// DefaultSides are the sides of the default NAT traversal matrix: each
+// kind of NAT, with and without a firewall or CGNAT where it makes a
+// difference.
+var DefaultSides = []Side{
+	{},
+	{Firewall: true},
+	{NAT: true, NATKind: FullConeNAT},
+	{NAT: true, NATKind: RestrictedConeNAT},
+	{NAT: true, NATKind: PortRestrictedConeNAT},
+	{NAT: true, NATKind: SymmetricNAT},
+	{NAT: true, NATKind: PortRestrictedConeNAT, CGNAT: true},
+}
+
+// A ScenarioNet is the virtual network built for a Scenario.
+type ScenarioNet struct {
+	Internet *Network
+
+	// STUN is a machine on the internet, to run STUN and DERP servers on.
+	STUN   *Machine
+	STUNIP netip.Addr
+
+	// A and B are the machines reaching the internet as the Scenario's
+	// sides, and AIP and BIP their IPs on their own networks.
+	A, B     *Machine
+	AIP, BIP netip.Addr
+}
+
+// Build builds a virtual network for s.
+func (s Scenario) Build() *ScenarioNet {
+	inet := NewInternet()
+	stun := &Machine{Name: "stun"}
+	sn := &ScenarioNet{
+		Internet: inet,
+		STUN:     stun,
+		STUNIP:   stun.Attach("eth0", inet).V4(),
+	}
+	sn.A, sn.AIP = s.A.build("a", 1, inet)
+	sn.B, sn.BIP = s.B.build("b", 2, inet)
+	return sn
+}
+
+// build returns a new machine named name reaching inet as s, and its IP on
+// its own network. lan is a number for the subnet of s's LAN, if any,
+// unique in the Scenario.
+func (s Side) build(name string, lan int, inet *Network) (*Machine, netip.Addr) {
+	if s.CGNAT && !s.NAT {
+		panic("natlab: Side with CGNAT but no NAT")
+	}
+	m := &Machine{Name: name}
+	if s.Firewall {
+		m.PacketHandler = &Firewall{}
+	}
+	if !s.NAT {
+		return m, m.Attach("eth0", inet).V4()
+	}
+
+	upstream := inet
+	if s.CGNAT {
+		cgnat := NewCGNATNetwork(name + "-cgnat")
+		cgn := &Machine{Name: name + "-cgn"}
+		cgnWAN := cgn.Attach("wan", inet)
+		cgnLAN := cgn.Attach("lan", cgnat)
+		cgnat.SetDefaultGateway(cgnLAN)
+		cgn.PacketHandler = NewSNAT44(SymmetricNAT, cgn, cgnWAN, cgnLAN)
+		upstream = cgnat
+	}
+	home := &Network{
+		Name:    name + "-lan",
+		Prefix4: mustPrefix(fmt.Sprintf("192.168.%d.0/24", lan)),
+	}
+	nat := &Machine{Name: name + "-nat"}
+	natWAN := nat.Attach("wan", upstream)
+	natLAN := nat.Attach("lan", home)
+	home.SetDefaultGateway(natLAN)
+	nat.PacketHandler = NewSNAT44(s.NATKind, nat, natWAN, natLAN)
+	return m, m.Attach("eth0", home).V4()
+}
+
+// A ScenarioResult is the result of running a Scenario.
+type ScenarioResult struct {
+	Scenario Scenario
+	Got      Outcome
+	Err      error         // if non-nil, the machines couldn't talk at all
+	Took     time.Duration // how long it took to get the outcome
+}
+
+// OK reports whether r got the expected outcome.
+func (r ScenarioResult) OK() bool {
+	return r.Err == nil && r.Got == r.Scenario.Want
+}
+
+// WriteReport writes a Markdown table of results to w, one row per
+// Scenario, flagging those that didn't get the expected outcome.
+func WriteReport(w io.Writer, results []ScenarioResult) error {
+	var sb strings.Builder
+	sb.WriteString("| A | B | want | got | took | |\n")
+	sb.WriteString("|---|---|---|---|---|---|\n")
+	var failed int
+	for _, r := range results {
+		got, status := r.Got.String(), "ok"
+		if r.Err != nil {
+			got = "error: " + strings.ReplaceAll(r.Err.Error(), "|", `\|`)
+		}
+		if !r.OK() {
+			status = "**FAIL**"
+			failed++
+		}
+		fmt.Fprintf(&sb, "| %v | %v | %v | %s | %v | %s |\n",
+			r.Scenario.A, r.Scenario.B, r.Scenario.Want, got, r.Took.Round(time.Millisecond), status)
+	}
+	fmt.Fprintf(&sb, "\n%d of %d scenarios got the expected outcome.\n", len(results)-failed, len(results))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlab
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpectedOutcome(t *testing.T) {
+	var (
+		public    = Side{}
+		fw        = Side{Firewall: true}
+		fullCone  = Side{NAT: true, NATKind: FullConeNAT}
+		restCone  = Side{NAT: true, NATKind: RestrictedConeNAT}
+		portCone  = Side{NAT: true, NATKind: PortRestrictedConeNAT}
+		symmetric = Side{NAT: true, NATKind: SymmetricNAT}
+		double    = Side{NAT: true, NATKind: PortRestrictedConeNAT, CGNAT: true}
+	)
+	tests := []struct {
+		a, b Side
+		want Outcome
+	}{
+		{public, public, OutcomeDirect},
+		{fw, fw, OutcomeDirect},
+		{portCone, portCone, OutcomeDirect},
+		{fullCone, symmetric, OutcomeDirect},
+		{restCone, symmetric, OutcomeDirect},
+		{public, symmetric, OutcomeDirect},
+		{fw, symmetric, OutcomeDERP},
+		{portCone, symmetric, OutcomeDERP},
+		{symmetric, symmetric, OutcomeDERP},
+		{double, portCone, OutcomeDERP},
+		{double, fullCone, OutcomeDirect},
+	}
+	for _, tt := range tests {
+		if got := ExpectedOutcome(tt.a, tt.b); got != tt.want {
+			t.Errorf("ExpectedOutcome(%v, %v) = %v; want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := ExpectedOutcome(tt.b, tt.a); got != tt.want {
+			t.Errorf("ExpectedOutcome(%v, %v) = %v; want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	sides := DefaultSides
+	scenarios := Matrix(sides)
+	if got, want := len(scenarios), len(sides)*(len(sides)+1)/2; got != want {
+		t.Fatalf("got %d scenarios; want %d", got, want)
+	}
+	names := map[string]bool{}
+	for _, s := range scenarios {
+		if names[s.Name()] {
+			t.Errorf("duplicate scenario %q", s.Name())
+		}
+		names[s.Name()] = true
+		if s.Want != ExpectedOutcome(s.A, s.B) {
+			t.Errorf("%s: Want = %v; want %v", s.Name(), s.Want, ExpectedOutcome(s.A, s.B))
+		}
+	}
+	if !names["public/nat:symmetric"] {
+		t.Errorf("missing public/nat:symmetric in %v", names)
+	}
+}
+
+// TestScenarioBuild checks that each side of a built scenario can reach
+// the STUN machine and get its reply back.
+func TestScenarioBuild(t *testing.T) {
+	for _, side := range DefaultSides {
+		t.Run(side.String(), func(t *testing.T) {
+			sn := Scenario{A: side, B: side}.Build()
+			for _, m := range []*Machine{sn.A, sn.B} {
+				if err := roundTrip(m, sn.STUN, sn.STUNIP); err != nil {
+					t.Errorf("%s: %v", m.Name, err)
+				}
+			}
+			if !side.NAT && sn.AIP == sn.BIP {
+				t.Errorf("A and B on the internet have the same IP %v", sn.AIP)
+			}
+		})
+	}
+}
+
+func roundTrip(client, server *Machine, serverIP netip.Addr) error {
+	ctx := context.Background()
+	clientPC, err := client.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer clientPC.Close()
+	serverPC, err := server.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer serverPC.Close()
+
+	serverAddr := netip.AddrPortFrom(serverIP, uint16(serverPC.LocalAddr().(*net.UDPAddr).Port))
+	if _, err := clientPC.WriteTo([]byte("ping"), net.UDPAddrFromAddrPort(serverAddr)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	_, addr, err := serverPC.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	if _, err := serverPC.WriteTo([]byte("pong"), addr); err != nil {
+		return err
+	}
+	n, _, err := clientPC.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != "pong" {
+		return errors.New("bad reply")
+	}
+	return nil
+}
+
+func TestWriteReport(t *testing.T) {
+	results := []ScenarioResult{
+		{Scenario: Scenario{A: Side{}, B: Side{}, Want: OutcomeDirect}, Got: OutcomeDirect, Took: time.Second},
+		{Scenario: Scenario{A: Side{Firewall: true}, B: Side{NAT: true, NATKind: SymmetricNAT}, Want: OutcomeDERP}, Got: OutcomeDirect},
+		{Scenario: Scenario{Want: OutcomeDirect}, Err: errors.New("lost ping | timeout")},
+	}
+	var sb strings.Builder
+	if err := WriteReport(&sb, results); err != nil {
+		t.Fatal(err)
+	}
+	got := sb.String()
+	for _, want := range []string{
+		"| public | public | direct | direct | 1s | ok |\n",
+		"| fw | nat:symmetric | DERP | direct | 0s | **FAIL** |\n",
+		`| error: lost ping \| timeout |`,
+		"1 of 3 scenarios got the expected outcome.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, got)
+		}
+	}
+}
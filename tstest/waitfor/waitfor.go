@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package waitfor contains test helpers that wait for a node's status to
+// reach a condition, such as having a direct path to a peer, until a
+// deadline rather than sleeping for a fixed time. They're built on
+// tstest.WaitFor, but can't go into tstest for circular dependency reasons.
+package waitfor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// Chan waits for up to timeout for a receive from ch to proceed, such as
+// because ch was closed. It returns an error describing ch as what if it
+// times out.
+func Chan[T any](timeout time.Duration, what string, ch <-chan T) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+	}
+}
+
+// A StatusFunc returns the current status of a node, such as
+// LocalBackend.Status, or a status built with magicsock.Conn.UpdateStatus.
+type StatusFunc func() *ipnstate.Status
+
+// Endpoints waits for up to timeout for the node with status st to know
+// at least one of its own endpoints, and returns them.
+func Endpoints(timeout time.Duration, st StatusFunc) ([]string, error) {
+	var addrs []string
+	err := tstest.WaitFor(timeout, func() error {
+		if s := st(); s.Self != nil {
+			addrs = s.Self.Addrs
+		}
+		if len(addrs) == 0 {
+			return errors.New("no self endpoints")
+		}
+		return nil
+	})
+	return addrs, err
+}
+
+// HomeDERP waits for up to timeout for the node with status st to have a
+// home DERP region, and returns its region code.
+func HomeDERP(timeout time.Duration, st StatusFunc) (string, error) {
+	var region string
+	err := tstest.WaitFor(timeout, func() error {
+		if s := st(); s.Self != nil {
+			region = s.Self.Relay
+		}
+		if region == "" {
+			return errors.New("no home DERP region")
+		}
+		return nil
+	})
+	return region, err
+}
+
+// DirectPath waits for up to timeout for the node with status st to have a
+// direct path to peer, rather than via DERP, and returns the peer's address
+// on that path. While it waits, it logs the peer's addresses to logf about
+// once a second.
+func DirectPath(timeout time.Duration, logf logger.Logf, st StatusFunc, peer key.NodePublic) (string, error) {
+	var addr string
+	var lastLog time.Time
+	err := tstest.WaitFor(timeout, func() error {
+		ps := st().Peer[peer]
+		if ps == nil {
+			return fmt.Errorf("peer %v unknown", peer.ShortString())
+		}
+		if ps.CurAddr != "" {
+			addr = ps.CurAddr
+			return nil
+		}
+		if now := time.Now(); now.Sub(lastLog) > time.Second {
+			logf("no direct path to %v yet, addrs %v, relay %q", peer.ShortString(), ps.Addrs, ps.Relay)
+			lastLog = now
+		}
+		return fmt.Errorf("no direct path to %v; peer addrs %v, relay %q", peer.ShortString(), ps.Addrs, ps.Relay)
+	})
+	return addr, err
+}
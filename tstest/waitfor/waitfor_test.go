@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package waitfor
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestChan(t *testing.T) {
+	ch := make(chan struct{})
+	if err := Chan(10*time.Millisecond, "open channel", ch); err == nil {
+		t.Fatal("Chan didn't time out")
+	}
+	close(ch)
+	if err := Chan(time.Second, "closed channel", ch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	peer := key.NewNode().Public()
+	var calls atomic.Int32
+	// st reports more connectivity with each call: endpoints first, then
+	// a home DERP region, then a direct path to peer.
+	st := func() *ipnstate.Status {
+		n := calls.Add(1)
+		s := &ipnstate.Status{
+			Self: &ipnstate.PeerStatus{},
+			Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+				peer: {Addrs: []string{"192.0.2.1:41641"}, Relay: "nyc"},
+			},
+		}
+		if n >= 2 {
+			s.Self.Addrs = []string{"198.51.100.1:41641"}
+		}
+		if n >= 3 {
+			s.Self.Relay = "sfo"
+		}
+		if n >= 4 {
+			s.Peer[peer].CurAddr = "192.0.2.1:41641"
+		}
+		return s
+	}
+
+	if addrs, err := Endpoints(time.Second, st); err != nil || len(addrs) != 1 {
+		t.Fatalf("Endpoints = %v, %v", addrs, err)
+	}
+	if region, err := HomeDERP(time.Second, st); err != nil || region != "sfo" {
+		t.Fatalf("HomeDERP = %q, %v; want sfo", region, err)
+	}
+	if addr, err := DirectPath(time.Second, t.Logf, st, peer); err != nil || addr != "192.0.2.1:41641" {
+		t.Fatalf("DirectPath = %q, %v", addr, err)
+	}
+	if _, err := DirectPath(50*time.Millisecond, t.Logf, st, key.NewNode().Public()); err == nil {
+		t.Fatal("DirectPath to unknown peer didn't time out")
+	}
+}
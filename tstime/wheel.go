@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstime
+
+import (
+	"sync"
+	"time"
+)
+
+// Wheel coalesces timers so that periodic work from many sources (such as
+// per-peer keepalives) wakes the CPU a bounded number of times: timer
+// expirations are rounded up to the next multiple of the wheel's
+// resolution, and all timers that expire at the same multiple run on a
+// single wakeup. A Wheel thus wakes at most once per resolution interval,
+// no matter how many timers it has.
+//
+// The price is that timers fire up to one resolution interval late, so a
+// Wheel is only suitable for timers that tolerate that.
+type Wheel struct {
+	clock Clock
+	res   time.Duration
+	start time.Time
+
+	mu      sync.Mutex
+	slots   map[int64]map[*wheelTimer]bool // timers by slot number; see slotLocked
+	timer   TimerController                // underlying timer, or nil if not armed
+	gen     int64                          // incremented each time timer is replaced
+	armedAt int64                          // if timer is non-nil, the slot it's set to fire at
+	wakeups int64
+}
+
+// NewWheel returns a new Wheel that runs its timers on clock with the given
+// resolution, which must be positive.
+func NewWheel(clock Clock, resolution time.Duration) *Wheel {
+	if resolution <= 0 {
+		panic("tstime.NewWheel: non-positive resolution")
+	}
+	return &Wheel{
+		clock: clock,
+		res:   resolution,
+		start: clock.Now(),
+		slots: make(map[int64]map[*wheelTimer]bool),
+	}
+}
+
+// Resolution returns the resolution w was created with.
+func (w *Wheel) Resolution() time.Duration {
+	return w.res
+}
+
+// Wakeups returns the number of times w has woken up to run timers.
+func (w *Wheel) Wakeups() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wakeups
+}
+
+// AfterFunc is like Clock.AfterFunc, but f is called up to one resolution
+// interval after d has elapsed, together with the other timers of w that
+// expire around the same time. As with time.AfterFunc, f is called in its
+// own goroutine.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) TimerController {
+	t := &wheelTimer{w: w, f: f}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addLocked(t, d)
+	return t
+}
+
+// elapsedLocked returns the time elapsed since w was created.
+func (w *Wheel) elapsedLocked() time.Duration {
+	return w.clock.Since(w.start)
+}
+
+// slotLocked returns the number of the slot in which a timer set to expire
+// after d needs to run: slot n runs n resolution intervals after w was
+// created.
+func (w *Wheel) slotLocked(d time.Duration) int64 {
+	at := w.elapsedLocked() + max(d, 0)
+	return int64((at + w.res - 1) / w.res)
+}
+
+func (w *Wheel) addLocked(t *wheelTimer, d time.Duration) {
+	n := w.slotLocked(d)
+	s := w.slots[n]
+	if s == nil {
+		s = make(map[*wheelTimer]bool)
+		w.slots[n] = s
+	}
+	s[t] = true
+	t.slot = n
+	t.pending = true
+	if w.timer == nil || n < w.armedAt {
+		w.armLocked(n)
+	}
+}
+
+// removeLocked removes t from its slot, reporting whether it was pending.
+func (w *Wheel) removeLocked(t *wheelTimer) bool {
+	if !t.pending {
+		return false
+	}
+	t.pending = false
+	s := w.slots[t.slot]
+	delete(s, t)
+	if len(s) == 0 {
+		delete(w.slots, t.slot)
+		if w.timer != nil && w.armedAt == t.slot {
+			// Don't wake up for nothing.
+			w.rearmLocked()
+		}
+	}
+	return true
+}
+
+// armLocked sets the underlying timer to fire at slot n.
+//
+// fire runs in a new goroutine, and the timer is replaced rather than reset,
+// as Clock implementations (such as tstest.Clock) may not allow using the
+// clock or resetting a timer from the timer's own func.
+func (w *Wheel) armLocked(n int64) {
+	w.stopLocked()
+	d := max(time.Duration(n)*w.res-w.elapsedLocked(), 0)
+	w.gen++
+	gen := w.gen
+	w.armedAt = n
+	w.timer = w.clock.AfterFunc(d, func() { go w.fire(gen) })
+}
+
+// stopLocked stops the underlying timer, if any.
+func (w *Wheel) stopLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// rearmLocked sets the underlying timer to fire at the earliest slot with
+// any timers, or stops it if there are none.
+func (w *Wheel) rearmLocked() {
+	first, ok := int64(0), false
+	for n := range w.slots {
+		if !ok || n < first {
+			first, ok = n, true
+		}
+	}
+	if ok {
+		w.armLocked(first)
+		return
+	}
+	w.stopLocked()
+}
+
+// fire is called by the underlying timer of generation gen to run the due
+// timers.
+func (w *Wheel) fire(gen int64) {
+	w.mu.Lock()
+	w.wakeups++
+	if gen == w.gen {
+		// It fired; there's nothing left to stop.
+		w.timer = nil
+	}
+	cur := int64(w.elapsedLocked() / w.res)
+	var due []func()
+	for n, s := range w.slots {
+		if n > cur {
+			continue
+		}
+		for t := range s {
+			t.pending = false
+			due = append(due, t.f)
+		}
+		delete(w.slots, n)
+	}
+	w.rearmLocked()
+	w.mu.Unlock()
+
+	for _, f := range due {
+		go f()
+	}
+}
+
+// wheelTimer is a timer of a Wheel.
+type wheelTimer struct {
+	w *Wheel
+	f func()
+
+	// The following fields are guarded by w.mu.
+	pending bool  // whether the timer is waiting in a slot
+	slot    int64 // if pending, its slot number
+}
+
+// Reset follows the same semantics as time.Timer.Reset, except that the
+// timer fires up to one resolution interval late.
+func (t *wheelTimer) Reset(d time.Duration) bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	wasPending := t.w.removeLocked(t)
+	t.w.addLocked(t, d)
+	return wasPending
+}
+
+// Stop follows the same semantics as time.Timer.Stop.
+func (t *wheelTimer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	return t.w.removeLocked(t)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstime_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+)
+
+func newTestWheel(res time.Duration) (*tstest.Clock, *tstime.Wheel) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	return clock, tstime.NewWheel(clock, res)
+}
+
+// waitFired waits for n timers to have fired, as wheel timers run in
+// their own goroutines.
+func waitFired(t *testing.T, fired *atomic.Int64, n int64) {
+	t.Helper()
+	if err := tstest.WaitFor(5*time.Second, func() error {
+		if got := fired.Load(); got != n {
+			return fmt.Errorf("%d of %d timers fired", got, n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWheelCoalesces(t *testing.T) {
+	clock, w := newTestWheel(time.Second)
+
+	var fired atomic.Int64
+	for i := range 100 {
+		w.AfterFunc(time.Duration(i+1)*7*time.Millisecond, func() { fired.Add(1) })
+	}
+	clock.Advance(999 * time.Millisecond)
+	if got := fired.Load(); got != 0 {
+		t.Fatalf("%d timers fired before the end of the slot", got)
+	}
+	clock.Advance(time.Millisecond)
+	waitFired(t, &fired, 100)
+	if got := w.Wakeups(); got != 1 {
+		t.Errorf("wakeups = %d; want 1", got)
+	}
+}
+
+func TestWheelStopReset(t *testing.T) {
+	clock, w := newTestWheel(time.Second)
+
+	var fired atomic.Int64
+	tm := w.AfterFunc(time.Second, func() { fired.Add(1) })
+	if !tm.Stop() {
+		t.Error("Stop of pending timer = false")
+	}
+	if tm.Stop() {
+		t.Error("Stop of stopped timer = true")
+	}
+	clock.Advance(2 * time.Second)
+	if got := w.Wakeups(); got != 0 {
+		t.Errorf("wakeups with no pending timers = %d; want 0", got)
+	}
+
+	if tm.Reset(time.Second) {
+		t.Error("Reset of stopped timer = true")
+	}
+	if !tm.Reset(3 * time.Second) {
+		t.Error("Reset of pending timer = false")
+	}
+	clock.Advance(2 * time.Second)
+	if got := fired.Load(); got != 0 {
+		t.Fatalf("timer fired %d times before its reset deadline", got)
+	}
+	clock.Advance(time.Second)
+	waitFired(t, &fired, 1)
+	if tm.Stop() {
+		t.Error("Stop of fired timer = true")
+	}
+}
+
+// TestWheelIdleWakeups checks that many peers' keepalives, as an idle
+// tailscaled with many peers has, wake the CPU at most once per resolution
+// interval rather than once per keepalive.
+func TestWheelIdleWakeups(t *testing.T) {
+	const (
+		res      = time.Second
+		peers    = 200
+		interval = 2 * time.Second
+		duration = time.Minute
+	)
+	clock, w := newTestWheel(res)
+
+	var fired atomic.Int64
+	want := int64(0)
+	for p := range peers {
+		// Spread the peers' keepalives out, as they would be in practice.
+		offset := time.Duration(p) * interval / peers
+		for at := offset + interval; at <= duration; at += interval {
+			w.AfterFunc(at, func() { fired.Add(1) })
+			want++
+		}
+	}
+	for elapsed := time.Duration(0); elapsed < duration; elapsed += 100 * time.Millisecond {
+		clock.Advance(100 * time.Millisecond)
+	}
+	waitFired(t, &fired, want)
+
+	if got, max := w.Wakeups(), int64(duration/res); got > max {
+		t.Errorf("%d keepalives woke up the CPU %d times in %v; want at most %d", want, got, duration, max)
+	}
+}
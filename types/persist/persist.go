@@ -43,6 +43,12 @@ type Persist struct {
 	// prevent bootstrapping TKA onto a key authority which was forcibly
 	// disabled.
 	DisallowedTKAStateIDs []string `json:",omitempty"`
+
+	// ControlURL is the control server URL that was last reachable, of
+	// the URL the node was configured with and its fallbacks (see
+	// ipn.Prefs.ControlURLFallbacks), so that the client starts with it
+	// after a restart. Empty means the configured URL.
+	ControlURL string `json:",omitempty"`
 }
 
 // PublicNodeKey returns the public key for the node key.
@@ -101,7 +107,8 @@ func (p *Persist) Equals(p2 *Persist) bool {
 		p.UserProfile.Equal(&p2.UserProfile) &&
 		p.NetworkLockKey.Equal(p2.NetworkLockKey) &&
 		p.NodeID == p2.NodeID &&
-		reflect.DeepEqual(nilIfEmpty(p.DisallowedTKAStateIDs), nilIfEmpty(p2.DisallowedTKAStateIDs))
+		reflect.DeepEqual(nilIfEmpty(p.DisallowedTKAStateIDs), nilIfEmpty(p2.DisallowedTKAStateIDs)) &&
+		p.ControlURL == p2.ControlURL
 }
 
 func (p *Persist) Pretty() string {
@@ -33,4 +33,5 @@ func (src *Persist) Clone() *Persist {
 	NetworkLockKey                  key.NLPrivate
 	NodeID                          tailcfg.StableNodeID
 	DisallowedTKAStateIDs           []string
+	ControlURL                      string
 }{})
@@ -21,7 +21,7 @@ func fieldsOf(t reflect.Type) (fields []string) {
 }
 
 func TestPersistEqual(t *testing.T) {
-	persistHandles := []string{"LegacyFrontendPrivateMachineKey", "PrivateNodeKey", "OldPrivateNodeKey", "UserProfile", "NetworkLockKey", "NodeID", "DisallowedTKAStateIDs"}
+	persistHandles := []string{"LegacyFrontendPrivateMachineKey", "PrivateNodeKey", "OldPrivateNodeKey", "UserProfile", "NetworkLockKey", "NodeID", "DisallowedTKAStateIDs", "ControlURL"}
 	if have := fieldsOf(reflect.TypeFor[Persist]()); !reflect.DeepEqual(have, persistHandles) {
 		t.Errorf("Persist.Equal check might be out of sync\nfields: %q\nhandled: %q\n",
 			have, persistHandles)
@@ -126,6 +126,16 @@ func TestPersistEqual(t *testing.T) {
 			&Persist{DisallowedTKAStateIDs: nil},
 			true,
 		},
+		{
+			&Persist{ControlURL: "https://b.example.com"},
+			&Persist{},
+			false,
+		},
+		{
+			&Persist{ControlURL: "https://b.example.com"},
+			&Persist{ControlURL: "https://b.example.com"},
+			true,
+		},
 	}
 	for i, test := range tests {
 		if got := test.a.Equals(test.b); got != test.want {
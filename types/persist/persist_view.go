@@ -73,6 +73,7 @@ func (v PersistView) NodeID() tailcfg.StableNodeID       { return v.ж.NodeID }
 func (v PersistView) DisallowedTKAStateIDs() views.Slice[string] {
 	return views.SliceOf(v.ж.DisallowedTKAStateIDs)
 }
+func (v PersistView) ControlURL() string { return v.ж.ControlURL }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PersistViewNeedsRegeneration = Persist(struct {
@@ -84,4 +85,5 @@ func (v PersistView) DisallowedTKAStateIDs() views.Slice[string] {
 	NetworkLockKey                  key.NLPrivate
 	NodeID                          tailcfg.StableNodeID
 	DisallowedTKAStateIDs           []string
+	ControlURL                      string
 }{})
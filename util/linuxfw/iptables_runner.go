@@ -652,22 +652,25 @@ func (i *iptablesRunner) DelMagicsockPortRule(port uint16, network string) error
 }
 
 // IPTablesCleanUp removes all Tailscale added iptables rules.
-// Any errors that occur are logged to the provided logf.
-func IPTablesCleanUp(logf logger.Logf) {
+// Any errors that occur are logged to the provided logf, and returned
+// unless they're from iptables being unavailable, in which case there are
+// no rules to remove.
+func IPTablesCleanUp(logf logger.Logf) error {
 	if distro.Get() == distro.Gokrazy {
 		// Gokrazy uses nftables and doesn't have the "iptables" command.
 		// Avoid log spam on cleanup. (#12277)
-		return
+		return nil
 	}
-	err := clearRules(iptables.ProtocolIPv4, logf)
-	if err != nil {
-		logf("linuxfw: clear iptables: %v", err)
+	err4 := clearRules(iptables.ProtocolIPv4, logf)
+	if err4 != nil {
+		logf("linuxfw: clear iptables: %v", err4)
 	}
 
-	err = clearRules(iptables.ProtocolIPv6, logf)
-	if err != nil {
-		logf("linuxfw: clear ip6tables: %v", err)
+	err6 := clearRules(iptables.ProtocolIPv6, logf)
+	if err6 != nil {
+		logf("linuxfw: clear ip6tables: %v", err6)
 	}
+	return multierr.New(err4, err6)
 }
 
 // delTSHook deletes hook in a chain that jumps to a ts-chain. If the hook does not
@@ -703,7 +706,9 @@ func delChain(ipt iptablesInterface, table, chain string) error {
 func clearRules(proto iptables.Protocol, logf logger.Logf) error {
 	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
-		return err
+		// Without iptables, there can't be any rules to clear.
+		logf("linuxfw: not clearing rules: %v", err)
+		return nil
 	}
 
 	var errs []error
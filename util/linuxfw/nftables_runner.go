@@ -1966,7 +1966,7 @@ func (n *nftablesRunner) DelStatefulRule(tunname string) error {
 // cleanupChain removes a jump rule from hookChainName to tsChainName, and then
 // the entire chain tsChainName. Errors are logged, but attempts to remove both
 // the jump rule and chain continue even if one errors.
-func cleanupChain(logf logger.Logf, conn *nftables.Conn, table *nftables.Table, hookChainName, tsChainName string) {
+func cleanupChain(logf logger.Logf, conn *nftables.Conn, table *nftables.Table, hookChainName, tsChainName string) error {
 	// remove the jump first, before removing the jump destination.
 	defaultChain, err := getChainFromTable(conn, table, hookChainName)
 	if err != nil && !errors.Is(err, errorChainNotFound{table.Name, hookChainName}) {
@@ -1988,37 +1988,48 @@ func cleanupChain(logf logger.Logf, conn *nftables.Conn, table *nftables.Table,
 		conn.DelChain(tsChain)
 		err = conn.Flush()
 		logf("cleanup: delete and flush chain %s: %s", tsChainName, err)
+		if err != nil {
+			return fmt.Errorf("delete and flush chain %s: %w", tsChainName, err)
+		}
 	}
+	return nil
 }
 
 // NfTablesCleanUp removes all Tailscale added nftables rules.
-// Any errors that occur are logged to the provided logf.
-func NfTablesCleanUp(logf logger.Logf) {
+// Any errors that occur are logged to the provided logf, and returned
+// unless they're from nftables being unavailable, in which case there are
+// no rules to remove.
+func NfTablesCleanUp(logf logger.Logf) error {
 	conn, err := nftables.New()
 	if err != nil {
 		logf("cleanup: nftables connection: %s", err)
+		return nil
 	}
 
 	tables, err := conn.ListTables() // both v4 and v6
 	if err != nil {
 		logf("cleanup: list tables: %s", err)
+		return nil
 	}
 
+	var errs []error
 	for _, table := range tables {
 		// These table names were used briefly in 1.48.0.
 		if table.Name == "ts-filter" || table.Name == "ts-nat" {
 			conn.DelTable(table)
 			if err := conn.Flush(); err != nil {
 				logf("cleanup: flush delete table %s: %s", table.Name, err)
+				errs = append(errs, fmt.Errorf("flush delete table %s: %w", table.Name, err))
 			}
 		}
 
 		if table.Name == "filter" {
-			cleanupChain(logf, conn, table, "INPUT", chainNameInput)
-			cleanupChain(logf, conn, table, "FORWARD", chainNameForward)
+			errs = append(errs, cleanupChain(logf, conn, table, "INPUT", chainNameInput))
+			errs = append(errs, cleanupChain(logf, conn, table, "FORWARD", chainNameForward))
 		}
 		if table.Name == "nat" {
-			cleanupChain(logf, conn, table, "POSTROUTING", chainNamePostrouting)
+			errs = append(errs, cleanupChain(logf, conn, table, "POSTROUTING", chainNamePostrouting))
 		}
 	}
+	return errors.Join(errs...)
 }
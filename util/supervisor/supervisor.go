@@ -0,0 +1,224 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package supervisor runs the goroutines of non-critical components,
+// recovering from their panics instead of letting them bring down the whole
+// process.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+)
+
+// The components that can be supervised. Each has its own health
+// Warnable, which is raised when it panics.
+const (
+	DNSForwarder = "dns-forwarder"
+	Netcheck     = "netcheck"
+	PeerAPI      = "peerapi"
+	PortMapper   = "portmapper"
+)
+
+// componentTitles are the human-readable names of the components, for their
+// health warnings.
+var componentTitles = map[string]string{
+	DNSForwarder: "DNS forwarder",
+	Netcheck:     "network condition checker",
+	PeerAPI:      "peer API server",
+	PortMapper:   "port mapper",
+}
+
+var warnables = func() map[string]*health.Warnable {
+	m := make(map[string]*health.Warnable)
+	for component, title := range componentTitles {
+		m[component] = health.Register(&health.Warnable{
+			Code:     health.WarnableCode("component-panic-" + component),
+			Title:    "Internal error in the " + title,
+			Severity: health.SeverityMedium,
+			Text: func(args health.Args) string {
+				return fmt.Sprintf("Tailscale recovered from an internal error in the %s, which may not work until it's restarted: %v", title, args[health.ArgError])
+			},
+		})
+	}
+	return m
+}()
+
+var metricPanics = clientmetric.NewCounter("supervisor_recovered_panics")
+
+const (
+	// minRestartDelay and maxRestartDelay bound how long Go waits before
+	// restarting a function that panicked. The delay doubles with each
+	// panic of the component, starting from minRestartDelay.
+	minRestartDelay = time.Second
+	maxRestartDelay = 5 * time.Minute
+
+	// healthyAfter is how long a component must go without panicking for
+	// its health warning to be cleared and its restart delay reset.
+	healthyAfter = 10 * time.Minute
+)
+
+// A Supervisor runs the functions of components, recovering from their
+// panics. A panic is logged with its stack trace, counted, and raises the
+// component's health warning, which is cleared once the component has gone
+// a while without panicking.
+//
+// A nil Supervisor runs functions without recovering from their panics.
+type Supervisor struct {
+	logf logger.Logf
+	ht   *health.Tracker // or nil
+
+	// minDelay and maxDelay are minRestartDelay and maxRestartDelay,
+	// except in tests.
+	minDelay, maxDelay time.Duration
+
+	// testHookGoReturned, if non-nil, is called when a goroutine started
+	// by Go stops restarting its function.
+	testHookGoReturned func(component string)
+
+	mu     sync.Mutex
+	states map[string]*componentState // keyed by component
+}
+
+// componentState is the state of a component that panicked recently.
+type componentState struct {
+	panics     int         // number of panics since the component was last healthy
+	lastPanic  time.Time   // when the component last panicked
+	clearTimer *time.Timer // fires healthyAfter lastPanic
+}
+
+// New returns a new Supervisor that logs to logf and raises health warnings
+// on ht, which may be nil.
+func New(logf logger.Logf, ht *health.Tracker) *Supervisor {
+	return &Supervisor{
+		logf:     logger.WithPrefix(logf, "supervisor: "),
+		ht:       ht,
+		minDelay: minRestartDelay,
+		maxDelay: maxRestartDelay,
+	}
+}
+
+// PanicError is the error returned by Do when the function it ran
+// panicked.
+type PanicError struct {
+	Component string
+	Value     any // the value passed to panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v", e.Component, e.Value)
+}
+
+// Do runs f as part of component and returns nil, unless f panics, in which
+// case Do recovers and returns a *PanicError.
+//
+// It's meant for components that do their work in calls made on demand,
+// whose next call acts as a restart.
+func (s *Supervisor) Do(component string, f func()) (err error) {
+	if s == nil {
+		f()
+		return nil
+	}
+	s.checkComponent(component)
+	defer func() {
+		if v := recover(); v != nil {
+			s.notePanic(component, v, debug.Stack())
+			err = &PanicError{Component: component, Value: v}
+		}
+	}()
+	f()
+	return nil
+}
+
+// Go runs f in a new goroutine as part of component. If f panics, Go
+// recovers and runs f again after a delay that doubles with each panic of
+// the component. f isn't run again once it returns normally or ctx is done.
+func (s *Supervisor) Go(ctx context.Context, component string, f func()) {
+	if s == nil {
+		go f()
+		return
+	}
+	s.checkComponent(component)
+	go func() {
+		if hook := s.testHookGoReturned; hook != nil {
+			defer hook(component)
+		}
+		for {
+			if s.Do(component, f) == nil {
+				return
+			}
+			t := time.NewTimer(s.restartDelay(component))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			s.logf("restarting %s", component)
+		}
+	}()
+}
+
+func (s *Supervisor) checkComponent(component string) {
+	if warnables[component] == nil {
+		panic(fmt.Sprintf("supervisor: unknown component %q", component))
+	}
+}
+
+// notePanic records that component panicked with value v.
+func (s *Supervisor) notePanic(component string, v any, stack []byte) {
+	metricPanics.Add(1)
+	s.logf("recovered from panic in %s: %v\n%s", component, v, stack)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.states[component]
+	if st == nil {
+		st = &componentState{}
+		if s.states == nil {
+			s.states = make(map[string]*componentState)
+		}
+		s.states[component] = st
+	}
+	st.panics++
+	st.lastPanic = time.Now()
+	if st.clearTimer != nil {
+		st.clearTimer.Stop()
+	}
+	st.clearTimer = time.AfterFunc(healthyAfter, func() { s.clear(component) })
+	s.ht.SetUnhealthy(warnables[component], health.Args{health.ArgError: fmt.Sprint(v)})
+}
+
+// clear marks component as healthy again, unless it panicked again less
+// than healthyAfter ago.
+func (s *Supervisor) clear(component string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.states[component]
+	if st == nil || time.Since(st.lastPanic) < healthyAfter {
+		return
+	}
+	delete(s.states, component)
+	s.ht.SetHealthy(warnables[component])
+}
+
+// restartDelay returns how long to wait before restarting component after
+// a panic.
+func (s *Supervisor) restartDelay(component string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.minDelay
+	if st := s.states[component]; st != nil {
+		for i := 1; i < st.panics && d < s.maxDelay; i++ {
+			d *= 2
+		}
+	}
+	return min(d, s.maxDelay)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/health"
+)
+
+func TestDo(t *testing.T) {
+	ht := new(health.Tracker)
+	s := New(t.Logf, ht)
+
+	if err := s.Do(Netcheck, func() {}); err != nil {
+		t.Fatalf("Do = %v; want nil", err)
+	}
+	if _, ok := ht.CurrentState().Warnings[warnables[Netcheck].Code]; ok {
+		t.Fatal("warning raised without a panic")
+	}
+
+	err := s.Do(Netcheck, func() { panic("boom") })
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Component != Netcheck || pe.Value != "boom" {
+		t.Fatalf("Do = %v; want a PanicError for %q", err, Netcheck)
+	}
+	w, ok := ht.CurrentState().Warnings[warnables[Netcheck].Code]
+	if !ok {
+		t.Fatal("no warning raised after a panic")
+	}
+	if !strings.Contains(w.Text, "boom") {
+		t.Errorf("warning text = %q; want it to contain the panic value", w.Text)
+	}
+
+	s.clear(Netcheck)
+	if _, ok := ht.CurrentState().Warnings[warnables[Netcheck].Code]; !ok {
+		t.Error("warning cleared right after the panic")
+	}
+	s.mu.Lock()
+	s.states[Netcheck].lastPanic = time.Now().Add(-healthyAfter)
+	s.mu.Unlock()
+	s.clear(Netcheck)
+	if _, ok := ht.CurrentState().Warnings[warnables[Netcheck].Code]; ok {
+		t.Error("warning not cleared after healthyAfter")
+	}
+}
+
+func TestGo(t *testing.T) {
+	s := New(t.Logf, nil)
+	s.minDelay = time.Millisecond
+	s.maxDelay = 4 * time.Millisecond
+
+	var runs atomic.Int32
+	done := make(chan struct{})
+	s.Go(context.Background(), PeerAPI, func() {
+		if runs.Add(1) < 4 {
+			panic("boom")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("function not restarted; ran %d times", runs.Load())
+	}
+	if got := s.restartDelay(PeerAPI); got != s.maxDelay {
+		t.Errorf("restart delay after 3 panics = %v; want %v", got, s.maxDelay)
+	}
+
+	// Once ctx is done, the function isn't restarted.
+	ctx, cancel := context.WithCancel(context.Background())
+	s.minDelay = time.Hour
+	returned := make(chan string, 1)
+	s.testHookGoReturned = func(component string) { returned <- component }
+	runs.Store(0)
+	s.Go(ctx, PortMapper, func() {
+		runs.Add(1)
+		cancel()
+		panic("boom")
+	})
+	select {
+	case c := <-returned:
+		if c != PortMapper {
+			t.Errorf("goroutine for %q returned; want %q", c, PortMapper)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutine didn't return after ctx was done")
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("function ran %d times; want 1", n)
+	}
+}
+
+func TestUnknownComponent(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an unknown component")
+		}
+	}()
+	New(t.Logf, nil).Do("bogus", func() {})
+}
@@ -7,6 +7,7 @@
 import (
 	"fmt"
 	"runtime/debug"
+	"slices"
 	"strings"
 
 	tailscaleroot "tailscale.com"
@@ -144,6 +145,25 @@ func (i embeddedInfo) commitAbbrev() string {
 	return ret
 })
 
+var buildTags = lazy.SyncFunc(func() []string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	for _, s := range bi.Settings {
+		if s.Key == "-tags" && s.Value != "" {
+			return strings.Split(s.Value, ",")
+		}
+	}
+	return nil
+})
+
+// BuildTags returns the build tags that the binary was built with, or nil
+// if there were none or they're unknown.
+func BuildTags() []string {
+	return slices.Clone(buildTags())
+}
+
 func gitCommit() string {
 	if gitCommitStamp != "" {
 		return gitCommitStamp
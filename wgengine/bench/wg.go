@@ -96,7 +96,12 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 	wait.Add(2)
 
 	var e1waitDoneOnce sync.Once
-	e1.SetStatusCallback(func(st *wgengine.Status, err error) {
+	e1.Subscribe(func(ev wgengine.Event) {
+		se, ok := ev.(wgengine.StatusEvent)
+		if !ok {
+			return
+		}
+		st, err := se.Status, se.Err
 		if errors.Is(err, wgengine.ErrEngineClosing) {
 			return
 		}
@@ -128,7 +133,12 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 	})
 
 	var e2waitDoneOnce sync.Once
-	e2.SetStatusCallback(func(st *wgengine.Status, err error) {
+	e2.Subscribe(func(ev wgengine.Event) {
+		se, ok := ev.(wgengine.StatusEvent)
+		if !ok {
+			return
+		}
+		st, err := se.Status, se.Err
 		if errors.Is(err, wgengine.ErrEngineClosing) {
 			return
 		}
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"net/netip"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// Event is an engine event delivered to the callbacks registered with
+// Engine.Subscribe. Its concrete type is one of the ...Event types in this
+// package (StatusEvent, PeerUpEvent, etc). Subscribers should type switch on
+// it and ignore the types they don't know about, as more may be added.
+type Event interface {
+	isEngineEvent()
+}
+
+// EventCallback is the type of callbacks registered with Engine.Subscribe.
+//
+// Callbacks are called one at a time from a goroutine of the engine's, in
+// the order the events were published, and never with any engine lock
+// held, so they may call back into the Engine. Callbacks should not block
+// for long, as that delays delivery of later events to all subscribers.
+type EventCallback func(Event)
+
+// StatusEvent is published whenever the engine status may have changed, or
+// in response to Engine.RequestStatus.
+//
+// Exactly one of Status or Err is non-nil.
+type StatusEvent struct {
+	Status *Status
+	Err    error
+}
+
+// PeerUpEvent is published when a peer is added to the WireGuard
+// configuration, once the Reconfig adding it succeeds.
+type PeerUpEvent struct {
+	NodeKey key.NodePublic
+}
+
+// PeerDownEvent is published when a peer is removed from the WireGuard
+// configuration, once the Reconfig removing it succeeds.
+type PeerDownEvent struct {
+	NodeKey key.NodePublic
+}
+
+// EndpointsEvent is published when the set of local endpoints at which
+// peers may reach this node changes.
+type EndpointsEvent struct {
+	Endpoints []tailcfg.Endpoint // shared between subscribers; must not be modified
+}
+
+// RoutesEvent is published after the OS router has been reconfigured with
+// new addresses or routes.
+type RoutesEvent struct {
+	LocalAddrs []netip.Prefix
+	Routes     []netip.Prefix
+}
+
+// DERPHomeEvent is published when the home DERP region changes.
+type DERPHomeEvent struct {
+	RegionID int // or 0 if there's no home DERP region
+}
+
+func (StatusEvent) isEngineEvent()    {}
+func (PeerUpEvent) isEngineEvent()    {}
+func (PeerDownEvent) isEngineEvent()  {}
+func (EndpointsEvent) isEngineEvent() {}
+func (RoutesEvent) isEngineEvent()    {}
+func (DERPHomeEvent) isEngineEvent()  {}
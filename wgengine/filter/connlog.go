@@ -0,0 +1,221 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/util/mak"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+type AcceptedConn = filtertype.AcceptedConn
+
+const (
+	// connLogTCPIdle and connLogIdle are how long a ConnLog waits without
+	// seeing packets of a TCP connection, or of a connection of any other
+	// protocol, before it considers it ended.
+	connLogTCPIdle = time.Hour
+	connLogIdle    = 2 * time.Minute
+
+	// connLogSweepInterval is how often a ConnLog looks for idle
+	// connections while recording new ones.
+	connLogSweepInterval = time.Minute
+
+	// connLogMaxConns is the number of open connections a ConnLog tracks.
+	// Past that, the least recently active one is considered ended.
+	connLogMaxConns = 4096
+)
+
+// ConnLog records the inbound connections that Filters accept, with the
+// number of bytes they carried, for auditing. A connection is reported to
+// the ConnLog's callback once it ends: when a TCP FIN or RST is seen, when
+// it's been idle for a while, or when the ConnLog is closed.
+//
+// Only connections that peers open are recorded, not replies to
+// connections this node opened. Like DropLog, one ConnLog is meant to be
+// shared by the successive Filters of a node; see Filter.SetConnLog.
+type ConnLog struct {
+	callback func(AcceptedConn)
+	now      func() time.Time // or nil for time.Now
+	enabled  atomic.Bool
+
+	mu        sync.Mutex
+	conns     map[flowtrack.Tuple]*AcceptedConn // keyed by the inbound tuple
+	lastSweep time.Time
+}
+
+// NewConnLog returns a ConnLog that calls f with each connection once it
+// ends. f is called on the packet processing path, so it must not block.
+//
+// The ConnLog starts out disabled; see SetEnabled.
+func NewConnLog(f func(AcceptedConn)) *ConnLog {
+	return &ConnLog{callback: f}
+}
+
+// SetEnabled sets whether l records connections. While it's disabled, it
+// costs Filters nothing beyond an atomic load per packet. Disabling it
+// reports the connections still open, as Close does.
+func (l *ConnLog) SetEnabled(v bool) {
+	if l.enabled.Swap(v) && !v {
+		l.Close()
+	}
+}
+
+func (l *ConnLog) timeNow() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// Enabled reports whether l records connections.
+func (l *ConnLog) Enabled() bool { return l.enabled.Load() }
+
+// noteIn is called with each inbound packet q that a Filter accepted. isNew
+// is whether q is allowed to open a new connection, as opposed to being
+// accepted as part of one that's already open.
+func (l *ConnLog) noteIn(q *packet.Parsed, isNew bool) {
+	if !l.enabled.Load() {
+		return
+	}
+	t := l.timeNow()
+	flow := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
+	n := int64(len(q.Buffer()))
+
+	l.mu.Lock()
+	c, ok := l.conns[flow]
+	if !ok {
+		if !isNew {
+			l.mu.Unlock()
+			return
+		}
+		ended := l.sweepLocked(t)
+		if len(l.conns) >= connLogMaxConns {
+			ended = append(ended, l.removeOldestLocked())
+		}
+		mak.Set(&l.conns, flow, &AcceptedConn{
+			Start:   t,
+			End:     t,
+			Proto:   q.IPProto,
+			Src:     q.Src,
+			Dst:     q.Dst,
+			BytesIn: n,
+		})
+		l.mu.Unlock()
+		l.report(ended)
+		return
+	}
+	c.End = t
+	c.BytesIn += n
+	var ended []AcceptedConn
+	if isTCPEnd(q) {
+		delete(l.conns, flow)
+		ended = append(ended, *c)
+	}
+	l.mu.Unlock()
+	l.report(ended)
+}
+
+// noteOut is called with each outbound packet q that a Filter accepted.
+func (l *ConnLog) noteOut(q *packet.Parsed) {
+	if !l.enabled.Load() {
+		return
+	}
+	flow := flowtrack.MakeTuple(q.IPProto, q.Dst, q.Src)
+
+	l.mu.Lock()
+	c, ok := l.conns[flow]
+	if !ok {
+		l.mu.Unlock()
+		return
+	}
+	c.End = l.timeNow()
+	c.BytesOut += int64(len(q.Buffer()))
+	var ended []AcceptedConn
+	if isTCPEnd(q) {
+		delete(l.conns, flow)
+		ended = append(ended, *c)
+	}
+	l.mu.Unlock()
+	l.report(ended)
+}
+
+// Flush reports the connections that have been idle long enough to be
+// considered ended. It's called by the ConnLog itself as new connections
+// are recorded, and may be called by its owner to not wait for that.
+func (l *ConnLog) Flush() {
+	l.mu.Lock()
+	l.lastSweep = time.Time{}
+	ended := l.sweepLocked(l.timeNow())
+	l.mu.Unlock()
+	l.report(ended)
+}
+
+// Close reports all open connections as ended, as when the node goes
+// down.
+func (l *ConnLog) Close() {
+	l.mu.Lock()
+	ended := make([]AcceptedConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		ended = append(ended, *c)
+	}
+	l.conns = nil
+	l.mu.Unlock()
+	l.report(ended)
+}
+
+// sweepLocked removes and returns the connections that have been idle for
+// too long as of now, at most once every connLogSweepInterval.
+func (l *ConnLog) sweepLocked(now time.Time) []AcceptedConn {
+	if now.Sub(l.lastSweep) < connLogSweepInterval {
+		return nil
+	}
+	l.lastSweep = now
+	var ended []AcceptedConn
+	for flow, c := range l.conns {
+		idle := connLogIdle
+		if c.Proto == ipproto.TCP {
+			idle = connLogTCPIdle
+		}
+		if now.Sub(c.End) >= idle {
+			delete(l.conns, flow)
+			ended = append(ended, *c)
+		}
+	}
+	return ended
+}
+
+// removeOldestLocked removes and returns the least recently active
+// connection. l.conns must not be empty.
+func (l *ConnLog) removeOldestLocked() AcceptedConn {
+	var oldest flowtrack.Tuple
+	var oc *AcceptedConn
+	for flow, c := range l.conns {
+		if oc == nil || c.End.Before(oc.End) {
+			oldest, oc = flow, c
+		}
+	}
+	delete(l.conns, oldest)
+	return *oc
+}
+
+func (l *ConnLog) report(ended []AcceptedConn) {
+	if l.callback == nil {
+		return
+	}
+	for _, c := range ended {
+		l.callback(c)
+	}
+}
+
+// isTCPEnd reports whether q is a TCP packet that ends its connection.
+func isTCPEnd(q *packet.Parsed) bool {
+	return q.IPProto == ipproto.TCP && q.TCPFlags&(packet.TCPFin|packet.TCPRst) != 0
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"sync"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+type DroppedFlow = filtertype.DroppedFlow
+
+// dropLogFlowInterval is how often a DropLog records a packet of the same
+// flow, so that one busy flow doesn't push all others out of the log.
+const dropLogFlowInterval = 10 * time.Second
+
+// dropLogMaxFlows is the number of flows a DropLog remembers for rate
+// limiting.
+const dropLogMaxFlows = 512
+
+// DropLog records the packets that Filters drop, to help answer why one
+// node can't reach another without capturing packets. It keeps the most
+// recent drops, at most one per flow every dropLogFlowInterval.
+//
+// One DropLog is meant to be shared by the successive Filters of a node;
+// see Filter.SetDropLog.
+type DropLog struct {
+	mu       sync.Mutex
+	entries  []DroppedFlow // ring buffer of len size once full
+	next     int           // index in entries of the next entry to write
+	size     int
+	flows    flowtrack.Cache[time.Time] // flow => when last recorded
+	callback func(DroppedFlow)
+	now      func() time.Time // or nil for time.Now
+}
+
+// NewDropLog returns a DropLog that keeps the size most recent drops.
+func NewDropLog(size int) *DropLog {
+	return &DropLog{
+		size:  size,
+		flows: flowtrack.Cache[time.Time]{MaxEntries: dropLogMaxFlows},
+	}
+}
+
+// SetCallback sets a func to be called with each drop that's recorded.
+// It's called on the packet processing path, so it must not block.
+func (l *DropLog) SetCallback(f func(DroppedFlow)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.callback = f
+}
+
+// Entries returns the recorded drops, oldest first.
+func (l *DropLog) Entries() []DroppedFlow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]DroppedFlow, 0, len(l.entries))
+	if len(l.entries) == l.size {
+		ret = append(ret, l.entries[l.next:]...)
+	}
+	return append(ret, l.entries[:l.next]...)
+}
+
+// record records that q, flowing in direction dir, was dropped for the
+// given reason, unless a packet of the same flow was recorded recently.
+func (l *DropLog) record(q *packet.Parsed, dir direction, why string) {
+	if l.size <= 0 {
+		return
+	}
+	now := time.Now
+	if l.now != nil {
+		now = l.now
+	}
+	t := now()
+	flow := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
+
+	l.mu.Lock()
+	if last, ok := l.flows.Get(flow); ok && t.Sub(*last) < dropLogFlowInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.flows.Add(flow, t)
+	e := DroppedFlow{
+		Time:   t,
+		Proto:  q.IPProto,
+		Src:    q.Src,
+		Dst:    q.Dst,
+		Dir:    dir.String(),
+		Reason: why,
+	}
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, e)
+	} else {
+		l.entries[l.next] = e
+	}
+	l.next = (l.next + 1) % l.size
+	cb := l.callback
+	l.mu.Unlock()
+
+	if cb != nil {
+		cb(e)
+	}
+}
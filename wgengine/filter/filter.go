@@ -64,6 +64,18 @@ type Filter struct {
 	// incoming packets don't get accepted by matches above.
 	state *filterState
 
+	// dropLog, if non-nil, records the packets the filter drops.
+	dropLog *DropLog
+
+	// connLog, if non-nil, records the inbound connections the filter
+	// accepts.
+	connLog *ConnLog
+
+	// blocked, if non-nil, reports whether an IP address belongs to a
+	// peer that the node blocked locally. Packets from and to such peers
+	// are dropped, whatever the matches allow.
+	blocked func(netip.Addr) bool
+
 	shieldsUp bool
 }
 
@@ -120,6 +132,7 @@ func (r Response) IsDrop() bool {
 	NetPortRange = filtertype.NetPortRange
 	PortRange    = filtertype.PortRange
 	CapMatch     = filtertype.CapMatch
+	ICMPType     = filtertype.ICMPType
 )
 
 // NewAllowAllForTest returns a packet filter that accepts
@@ -363,7 +376,8 @@ func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Pr
 		pkt.TCPFlags = packet.TCPSyn
 	}
 
-	return f.RunIn(pkt, 0)
+	r, _ := f.runIn(pkt, 0)
+	return r
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -401,6 +415,25 @@ func (f *Filter) CapsWithValues(srcIP, dstIP netip.Addr) tailcfg.PeerCapMap {
 	return out
 }
 
+// SetDropLog makes f record the packets it drops in l. It must be called
+// before f is used.
+func (f *Filter) SetDropLog(l *DropLog) { f.dropLog = l }
+
+// SetConnLog makes f record the inbound connections it accepts in l. It
+// must be called before f is used.
+func (f *Filter) SetConnLog(l *ConnLog) { f.connLog = l }
+
+// SetBlockedIPs makes f drop all packets from and to the IP addresses in
+// s, the addresses of peers the node blocked locally, regardless of the
+// packet filter rules. It must be called before f is used.
+func (f *Filter) SetBlockedIPs(s *netipx.IPSet) {
+	if s == nil || len(s.Ranges()) == 0 {
+		f.blocked = nil
+		return
+	}
+	f.blocked = ipset.NewContainsIPFunc(views.SliceOf(s.Prefixes()))
+}
+
 // ShieldsUp reports whether this is a "shields up" (block everything
 // incoming) filter.
 func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
@@ -408,24 +441,40 @@ func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 // RunIn determines whether this node is allowed to receive q from a
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
+	r, why := f.runIn(q, rf)
+	if r == Drop && why != "" && f.dropLog != nil {
+		f.dropLog.record(q, in, why)
+	}
+	if r == Accept && f.connLog != nil {
+		// "tcp ok" and "ok" are the packets that a rule allows to open
+		// a connection; see runIn4 and runIn6.
+		f.connLog.noteIn(q, why == "tcp ok" || why == "ok")
+	}
+	return r
+}
+
+// runIn is RunIn without recording drops in f.dropLog. It returns why
+// the packet was accepted or dropped, or the empty string if f.pre decided.
+func (f *Filter) runIn(q *packet.Parsed, rf RunFlags) (r Response, why string) {
 	dir := in
-	r := f.pre(q, rf, dir)
+	r = f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
 		// already logged
-		return r
+		return r, ""
 	}
 
-	var why string
-	switch q.IPVersion {
-	case 4:
+	switch {
+	case f.blocked != nil && f.blocked(q.Src.Addr()):
+		r, why = Drop, "blocked peer"
+	case q.IPVersion == 4:
 		r, why = f.runIn4(q)
-	case 6:
+	case q.IPVersion == 6:
 		r, why = f.runIn6(q)
 	default:
 		r, why = Drop, "not-ip"
 	}
 	f.logRateLimit(rf, q, dir, r, why)
-	return r
+	return r, why
 }
 
 // RunOut determines whether this node is allowed to send q to a
@@ -439,6 +488,12 @@ func (f *Filter) RunOut(q *packet.Parsed, rf RunFlags) Response {
 	}
 	r, why := f.runOut(q)
 	f.logRateLimit(rf, q, dir, r, why)
+	if r == Drop && f.dropLog != nil && !omitDropLogging(q, dir) {
+		f.dropLog.record(q, dir, why)
+	}
+	if r == Accept && f.connLog != nil {
+		f.connLog.noteOut(q)
+	}
 	return r
 }
 
@@ -470,7 +525,7 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q, f.srcIPHasCap) {
+		} else if f.matches4.matchICMP(q, f.srcIPHasCap) {
 			// If any port is open to an IP, allow ICMP to it.
 			return Accept, "icmp ok"
 		}
@@ -530,7 +585,7 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q, f.srcIPHasCap) {
+		} else if f.matches6.matchICMP(q, f.srcIPHasCap) {
 			// If any port is open to an IP, allow ICMP to it.
 			return Accept, "icmp ok"
 		}
@@ -575,6 +630,9 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 
 // runIn runs the output-specific part of the filter logic.
 func (f *Filter) runOut(q *packet.Parsed) (r Response, why string) {
+	if f.blocked != nil && f.blocked(q.Dst.Addr()) {
+		return Drop, "blocked peer"
+	}
 	switch q.IPProto {
 	case ipproto.UDP, ipproto.SCTP:
 		tuple := flowtrack.MakeTuple(q.IPProto, q.Dst, q.Src) // src/dst reversed
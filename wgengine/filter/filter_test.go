@@ -14,6 +14,7 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -472,6 +473,169 @@ func TestOmitDropLogging(t *testing.T) {
 	}
 }
 
+func TestDropLog(t *testing.T) {
+	filt := newFilter(t.Logf)
+	dl := NewDropLog(2)
+	now := time.Unix(1000, 0)
+	dl.now = func() time.Time { return now }
+	var called int
+	dl.SetCallback(func(DroppedFlow) { called++ })
+	filt.SetDropLog(dl)
+
+	drop := func(src string, dport uint16) {
+		t.Helper()
+		p := parsed(ipproto.TCP, src, "1.2.3.4", 999, dport)
+		if got := filt.RunIn(&p, 0); got != Drop {
+			t.Fatalf("RunIn(%v) = %v; want Drop", p, got)
+		}
+	}
+	dsts := func() (ret []uint16) {
+		for _, e := range dl.Entries() {
+			ret = append(ret, e.Dst.Port())
+		}
+		return ret
+	}
+
+	drop("8.1.1.1", 21)
+	drop("8.1.1.1", 21) // same flow, rate limited
+	if got := dsts(); !slices.Equal(got, []uint16{21}) {
+		t.Fatalf("dropped ports = %v; want [21]", got)
+	}
+	drop("8.1.1.1", 23)
+	drop("8.1.1.1", 25) // evicts 21
+	if got := dsts(); !slices.Equal(got, []uint16{23, 25}) {
+		t.Fatalf("dropped ports = %v; want [23 25]", got)
+	}
+	now = now.Add(dropLogFlowInterval)
+	drop("8.1.1.1", 23)
+	if got := dsts(); !slices.Equal(got, []uint16{25, 23}) {
+		t.Fatalf("dropped ports = %v; want [25 23]", got)
+	}
+	if called != 4 {
+		t.Errorf("callback called %d times; want 4", called)
+	}
+	e := dl.Entries()[1]
+	if e.Dir != "in" || e.Proto != ipproto.TCP || e.Src != netip.MustParseAddrPort("8.1.1.1:999") || e.Reason == "" {
+		t.Errorf("entry = %+v; want in TCP from 8.1.1.1:999 with a reason", e)
+	}
+
+	// Check synthesizes packets; its drops aren't real, so aren't recorded.
+	if got := filt.CheckTCP(netip.MustParseAddr("8.3.3.3"), netip.MustParseAddr("1.2.3.4"), 22); got != Drop {
+		t.Fatalf("CheckTCP = %v; want Drop", got)
+	}
+	if called != 4 {
+		t.Errorf("CheckTCP drop was recorded")
+	}
+}
+
+func TestBlockedIPs(t *testing.T) {
+	filt := newFilter(t.Logf)
+	var b netipx.IPSetBuilder
+	b.AddPrefix(netip.MustParsePrefix("8.1.1.1/32"))
+	blocked, _ := b.IPSet()
+	filt.SetBlockedIPs(blocked)
+
+	// Allowed by the rules, but from a blocked peer.
+	p := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+	if got := filt.RunIn(&p, 0); got != Drop {
+		t.Errorf("RunIn from blocked peer = %v; want Drop", got)
+	}
+	p = parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22)
+	if got := filt.RunIn(&p, 0); got != Accept {
+		t.Errorf("RunIn from other peer = %v; want Accept", got)
+	}
+
+	p = parsed(ipproto.TCP, "1.2.3.4", "8.1.1.1", 22, 999)
+	if got := filt.RunOut(&p, 0); got != Drop {
+		t.Errorf("RunOut to blocked peer = %v; want Drop", got)
+	}
+	p = parsed(ipproto.TCP, "1.2.3.4", "8.2.2.2", 22, 999)
+	if got := filt.RunOut(&p, 0); got != Accept {
+		t.Errorf("RunOut to other peer = %v; want Accept", got)
+	}
+}
+
+func TestConnLog(t *testing.T) {
+	filt := newFilter(t.Logf)
+	now := time.Unix(1000, 0)
+	var got []AcceptedConn
+	cl := NewConnLog(func(c AcceptedConn) { got = append(got, c) })
+	cl.now = func() time.Time { return now }
+	filt.SetConnLog(cl)
+
+	p := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+	filt.RunIn(&p, 0)
+	cl.Close()
+	if len(got) != 0 {
+		t.Fatalf("disabled ConnLog recorded %+v", got)
+	}
+	cl.SetEnabled(true)
+
+	run := func(dir direction, proto ipproto.Proto, src, dst string, sport, dport uint16, flags packet.TCPFlag) {
+		t.Helper()
+		p := parsed(proto, src, dst, sport, dport)
+		p.TCPFlags = flags
+		var r Response
+		if dir == in {
+			r = filt.RunIn(&p, 0)
+		} else {
+			r = filt.RunOut(&p, 0)
+		}
+		if r != Accept {
+			t.Fatalf("Run%v(%v) = %v; want Accept", dir, p, r)
+		}
+	}
+	n := int64(len(dummyPacket))
+
+	run(in, ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22, packet.TCPSyn)
+	run(out, ipproto.TCP, "1.2.3.4", "8.1.1.1", 22, 999, packet.TCPSynAck)
+	run(in, ipproto.TCP, "8.1.1.1", "1.2.3.4", 1000, 22, packet.TCPAck) // not a tracked connection
+	now = now.Add(time.Second)
+	run(in, ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22, packet.TCPAck)
+	if len(got) != 0 {
+		t.Fatalf("connections reported before they ended: %+v", got)
+	}
+	run(in, ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22, packet.TCPFin|packet.TCPAck)
+	want := AcceptedConn{
+		Start:    time.Unix(1000, 0),
+		End:      time.Unix(1001, 0),
+		Proto:    ipproto.TCP,
+		Src:      netip.MustParseAddrPort("8.1.1.1:999"),
+		Dst:      netip.MustParseAddrPort("1.2.3.4:22"),
+		BytesIn:  3 * n,
+		BytesOut: n,
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("reported %+v; want %+v", got, want)
+	}
+
+	// Outbound connections aren't recorded.
+	run(out, ipproto.TCP, "1.2.3.4", "8.1.1.1", 1234, 80, packet.TCPSyn)
+	run(in, ipproto.TCP, "8.1.1.1", "1.2.3.4", 80, 1234, packet.TCPSynAck)
+
+	// UDP flows end once idle.
+	got = nil
+	run(in, ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
+	run(in, ipproto.UDP, "8.1.1.1", "1.2.3.4", 999, 22, 0)
+	cl.Flush()
+	if len(got) != 0 {
+		t.Fatalf("active UDP flow reported: %+v", got)
+	}
+	now = now.Add(connLogIdle)
+	cl.Flush()
+	if len(got) != 1 || got[0].Proto != ipproto.UDP || got[0].BytesIn != 2*n {
+		t.Fatalf("reported %+v; want one UDP flow of %d bytes", got, 2*n)
+	}
+
+	// Disabling the log reports the connections still open.
+	got = nil
+	run(in, ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22, packet.TCPSyn)
+	cl.SetEnabled(false)
+	if len(got) != 1 || got[0].Src.Addr() != netip.MustParseAddr("8.2.2.2") {
+		t.Fatalf("reported %+v; want the connection from 8.2.2.2", got)
+	}
+}
+
 func TestLoggingPrivacy(t *testing.T) {
 	tstest.Replace(t, &dropBucket, rate.NewLimiter(2^32, 2^32))
 	tstest.Replace(t, &acceptBucket, dropBucket)
@@ -858,6 +1022,45 @@ func TestMatchesFromFilterRules(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "icmp_types",
+			in: []tailcfg.FilterRule{
+				{
+					IPProto: []int{int(ipproto.ICMPv4)},
+					SrcIPs:  []string{"100.64.1.1"},
+					DstPorts: []tailcfg.NetPortRange{{
+						IP:    "1.2.3.4",
+						Ports: tailcfg.PortRangeAny,
+						ICMPTypes: []tailcfg.ICMPTypeCodes{
+							{Type: 8},
+							{Type: 3, Codes: []int{3, 4}},
+						},
+					}},
+				},
+			},
+			want: []Match{
+				{
+					IPProto: views.SliceOf([]ipproto.Proto{
+						ipproto.ICMPv4,
+					}),
+					Dsts: []NetPortRange{
+						{
+							Net:   netip.MustParsePrefix("1.2.3.4/32"),
+							Ports: PortRange{0, 65535},
+							ICMPTypes: []ICMPType{
+								{Type: 8, AnyCode: true},
+								{Type: 3, Code: 3},
+								{Type: 3, Code: 4},
+							},
+						},
+					},
+					Srcs: []netip.Prefix{
+						netip.MustParsePrefix("100.64.1.1/32"),
+					},
+					Caps: []CapMatch{},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -878,6 +1081,36 @@ func TestMatchesFromFilterRules(t *testing.T) {
 	}
 }
 
+func TestMatchICMP(t *testing.T) {
+	echoOnly := netports("1.2.3.4/32:*")
+	echoOnly[0].ICMPTypes = []ICMPType{{Type: 8, Code: 0}}
+	anyICMP := netports("1.2.3.4/32:*")
+
+	tests := []struct {
+		name      string
+		dsts      []NetPortRange
+		typ, code uint8
+		want      bool
+	}{
+		{"echo_allowed", echoOnly, 8, 0, true},
+		{"echo_other_code", echoOnly, 8, 1, false},
+		{"other_type", echoOnly, 13, 0, false},
+		{"no_types_allows_all", anyICMP, 13, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := matches{m(nets("0.0.0.0/0"), tt.dsts)}
+			// The first two bytes of the ICMP header, which raw4 fills
+			// with the source port, are the type and code.
+			var p packet.Parsed
+			p.Decode(raw4(ipproto.ICMPv4, "5.6.7.8", "1.2.3.4", uint16(tt.typ)<<8|uint16(tt.code), 0, 0))
+			if got := ms.matchICMP(&p, nil); got != tt.want {
+				t.Errorf("got = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewAllowAllForTest(t *testing.T) {
 	f := NewAllowAllForTest(logger.Discard)
 	src := netip.MustParseAddr("100.100.2.3")
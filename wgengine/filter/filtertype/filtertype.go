@@ -8,13 +8,14 @@
 	"fmt"
 	"net/netip"
 	"strings"
+	"time"
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner --type=Match,CapMatch
+//go:generate go run tailscale.com/cmd/cloner --type=Match,CapMatch,NetPortRange
 
 // PortRange is a range of TCP and UDP ports.
 type PortRange struct {
@@ -38,14 +39,54 @@ func (pr PortRange) Contains(port uint16) bool {
 	return port >= pr.First && port <= pr.Last
 }
 
+// ICMPType matches ICMP or ICMPv6 messages of one type and, unless AnyCode
+// is set, one code.
+type ICMPType struct {
+	Type    uint8
+	Code    uint8
+	AnyCode bool
+}
+
+func (t ICMPType) String() string {
+	if t.AnyCode {
+		return fmt.Sprintf("%d", t.Type)
+	}
+	return fmt.Sprintf("%d/%d", t.Type, t.Code)
+}
+
 // NetPortRange combines an IP address prefix and PortRange.
 type NetPortRange struct {
 	Net   netip.Prefix
 	Ports PortRange
+
+	// ICMPTypes, if non-empty, are the ICMP messages allowed to Net.
+	// If empty, all are.
+	ICMPTypes []ICMPType
 }
 
 func (npr NetPortRange) String() string {
-	return fmt.Sprintf("%v:%v", npr.Net, npr.Ports)
+	if len(npr.ICMPTypes) == 0 {
+		return fmt.Sprintf("%v:%v", npr.Net, npr.Ports)
+	}
+	types := make([]string, len(npr.ICMPTypes))
+	for i, t := range npr.ICMPTypes {
+		types[i] = t.String()
+	}
+	return fmt.Sprintf("%v:%v;icmp=%v", npr.Net, npr.Ports, strings.Join(types, ","))
+}
+
+// AllowsICMP reports whether npr allows ICMP or ICMPv6 messages with the
+// given type and code to Net.
+func (npr NetPortRange) AllowsICMP(typ, code uint8) bool {
+	if len(npr.ICMPTypes) == 0 {
+		return true
+	}
+	for _, t := range npr.ICMPTypes {
+		if t.Type == typ && (t.AnyCode || t.Code == code) {
+			return true
+		}
+	}
+	return false
 }
 
 // CapMatch is a capability grant match predicate.
@@ -114,3 +155,26 @@ func (m Match) String() string {
 	}
 	return fmt.Sprintf("%v%v=>%v", m.IPProto, ss, ds)
 }
+
+// DroppedFlow is a flow of packets that a packet filter dropped, as
+// recorded by a filter.DropLog.
+type DroppedFlow struct {
+	Time   time.Time // when the packet was dropped
+	Proto  ipproto.Proto
+	Src    netip.AddrPort
+	Dst    netip.AddrPort
+	Dir    string // "in" for packets from peers, "out" for packets to them
+	Reason string // why the packet was dropped
+}
+
+// AcceptedConn is an inbound connection that a packet filter accepted, as
+// recorded by a filter.ConnLog once the connection ended.
+type AcceptedConn struct {
+	Start    time.Time // when the first packet was accepted
+	End      time.Time // when the last packet was seen
+	Proto    ipproto.Proto
+	Src      netip.AddrPort // the peer that opened the connection
+	Dst      netip.AddrPort
+	BytesIn  int64 // IP bytes received from Src
+	BytesOut int64 // IP bytes sent to Src
+}
@@ -24,7 +24,12 @@ func (src *Match) Clone() *Match {
 	dst.IPProto = src.IPProto
 	dst.Srcs = append(src.Srcs[:0:0], src.Srcs...)
 	dst.SrcCaps = append(src.SrcCaps[:0:0], src.SrcCaps...)
-	dst.Dsts = append(src.Dsts[:0:0], src.Dsts...)
+	if src.Dsts != nil {
+		dst.Dsts = make([]NetPortRange, len(src.Dsts))
+		for i := range dst.Dsts {
+			dst.Dsts[i] = *src.Dsts[i].Clone()
+		}
+	}
 	if src.Caps != nil {
 		dst.Caps = make([]CapMatch, len(src.Caps))
 		for i := range dst.Caps {
@@ -62,3 +67,22 @@ func (src *CapMatch) Clone() *CapMatch {
 	Cap    tailcfg.PeerCapability
 	Values []tailcfg.RawMessage
 }{})
+
+// Clone makes a deep copy of NetPortRange.
+// The result aliases no memory with the original.
+func (src *NetPortRange) Clone() *NetPortRange {
+	if src == nil {
+		return nil
+	}
+	dst := new(NetPortRange)
+	*dst = *src
+	dst.ICMPTypes = append(src.ICMPTypes[:0:0], src.ICMPTypes...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _NetPortRangeCloneNeedsRegeneration = NetPortRange(struct {
+	Net       netip.Prefix
+	Ports     PortRange
+	ICMPTypes []ICMPType
+}{})
@@ -8,6 +8,7 @@
 
 	"tailscale.com/net/packet"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/views"
 	"tailscale.com/wgengine/filter/filtertype"
 )
@@ -59,14 +60,18 @@ func srcMatches(m *filtertype.Match, srcAddr netip.Addr, hasCap CapTestFunc) boo
 // It it used in the fast path of evaluating filter rules so should be fast.
 type CapTestFunc = func(srcIP netip.Addr, cap tailcfg.NodeCapability) bool
 
-func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
+// matchICMP reports whether the ICMP or ICMPv6 packet q matches any Match
+// in ms by IP address alone, ignoring protocols and ports, as long as the
+// destination allows q's ICMP type and code.
+func (ms matches) matchICMP(q *packet.Parsed, hasCap CapTestFunc) bool {
 	srcAddr := q.Src.Addr()
+	typ, code := icmpTypeCode(q)
 	for _, m := range ms {
 		if !m.SrcsContains(srcAddr) {
 			continue
 		}
 		for _, dst := range m.Dsts {
-			if dst.Net.Contains(q.Dst.Addr()) {
+			if dst.Net.Contains(q.Dst.Addr()) && dst.AllowsICMP(typ, code) {
 				return true
 			}
 		}
@@ -83,6 +88,16 @@ func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
 	return false
 }
 
+// icmpTypeCode returns the type and code of the ICMP or ICMPv6 packet q.
+func icmpTypeCode(q *packet.Parsed) (typ, code uint8) {
+	if q.IPProto == ipproto.ICMPv6 {
+		h := q.ICMP6Header()
+		return uint8(h.Type), uint8(h.Code)
+	}
+	h := q.ICMP4Header()
+	return uint8(h.Type), uint8(h.Code)
+}
+
 // matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
 // Match if for the right IP Protocol and IP address, but ports are
 // ignored, as long as the match is for the entire uint16 port range.
@@ -83,6 +83,13 @@ func MatchesFromFilterRules(pf []tailcfg.FilterRule) ([]Match, error) {
 				erracc = fmt.Errorf("unexpected capability %q in DstPorts", cap)
 				continue
 			}
+			icmpTypes, err := icmpTypesFromTailcfg(d.ICMPTypes)
+			if err != nil {
+				if erracc == nil {
+					erracc = err
+				}
+				continue
+			}
 			for _, net := range nets {
 				m.Dsts = append(m.Dsts, NetPortRange{
 					Net: net,
@@ -90,6 +97,7 @@ func MatchesFromFilterRules(pf []tailcfg.FilterRule) ([]Match, error) {
 						First: d.Ports.First,
 						Last:  d.Ports.Last,
 					},
+					ICMPTypes: icmpTypes,
 				})
 			}
 		}
@@ -116,6 +124,29 @@ func MatchesFromFilterRules(pf []tailcfg.FilterRule) ([]Match, error) {
 	return mm, erracc
 }
 
+// icmpTypesFromTailcfg converts the ICMPTypes of a tailcfg.NetPortRange.
+// Out of range types and codes are an error, rather than being dropped,
+// as dropping them all would allow all ICMP messages.
+func icmpTypesFromTailcfg(tcs []tailcfg.ICMPTypeCodes) ([]ICMPType, error) {
+	var types []ICMPType
+	for _, tc := range tcs {
+		if tc.Type < 0 || tc.Type > 0xff {
+			return nil, fmt.Errorf("invalid ICMP type %d", tc.Type)
+		}
+		if len(tc.Codes) == 0 {
+			types = append(types, ICMPType{Type: uint8(tc.Type), AnyCode: true})
+			continue
+		}
+		for _, c := range tc.Codes {
+			if c < 0 || c > 0xff {
+				return nil, fmt.Errorf("invalid code %d for ICMP type %d", c, tc.Type)
+			}
+			types = append(types, ICMPType{Type: uint8(tc.Type), Code: uint8(c)})
+		}
+	}
+	return types, nil
+}
+
 var (
 	zeroIP4 = netaddr.IPv4(0, 0, 0, 0)
 	zeroIP6 = netip.AddrFrom16([16]byte{})
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+	"fmt"
+
+	"tailscale.com/disco"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// An AppMessageHandler handles the payload of an application message
+// received from peer from.
+type AppMessageHandler func(from key.NodePublic, payload []byte)
+
+var errAppMessageNotSent = errors.New("application message not sent")
+
+// SendAppMessage sends a small application message to peer on behalf of a
+// higher layer, such as file sharing or SSH, identified by topic.
+//
+// The message is sent as a disco message via the peer's home DERP region,
+// so it reaches the peer even if there's no direct path to it. It's
+// encrypted and authenticated with the disco keys of both nodes, and the
+// DERP server authenticates the node key of the sender. Delivery isn't
+// guaranteed; callers that need a reply must retry on their own.
+//
+// The peer's handler for topic, if any, is called with the payload. See
+// disco.MaxAppMessageTopicLen and disco.MaxAppMessagePayloadLen for the
+// size limits.
+func (c *Conn) SendAppMessage(peer key.NodePublic, topic string, payload []byte) error {
+	m := &disco.AppMessage{Topic: topic, Payload: payload}
+	if err := m.Valid(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errConnClosed
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown peer %v", peer.ShortString())
+	}
+	epDisco := ep.disco.Load()
+	if epDisco == nil {
+		return fmt.Errorf("peer %v doesn't support disco", peer.ShortString())
+	}
+	ep.mu.Lock()
+	derpAddr := ep.derpAddr
+	ep.mu.Unlock()
+	if !derpAddr.IsValid() {
+		return fmt.Errorf("peer %v has no home DERP region", peer.ShortString())
+	}
+
+	sent, err := c.sendDiscoMessage(derpAddr, peer, epDisco.key, m, discoVerboseLog)
+	if err != nil {
+		return err
+	}
+	if !sent {
+		return errAppMessageNotSent
+	}
+	metricSentDiscoAppMessage.Add(1)
+	return nil
+}
+
+// SetAppMessageHandler sets the handler of the application messages for
+// topic that peers send with SendAppMessage. A nil h removes the handler;
+// messages for topics with no handler are dropped.
+//
+// Each message is handled in a new goroutine, so h must not depend on the
+// order of messages.
+func (c *Conn) SetAppMessageHandler(topic string, h AppMessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h == nil {
+		delete(c.appMsgHandlers, topic)
+		return
+	}
+	mak.Set(&c.appMsgHandlers, topic, h)
+}
+
+// handleAppMessageLocked handles dm, received from disco key dk via DERP
+// from node derpNodeSrc.
+//
+// c.mu must be held.
+func (c *Conn) handleAppMessageLocked(dm *disco.AppMessage, dk key.DiscoPublic, derpNodeSrc key.NodePublic) {
+	ep, ok := c.peerMap.endpointForNodeKey(derpNodeSrc)
+	if !ok {
+		metricRecvDiscoAppMessageBadNode.Add(1)
+		return
+	}
+	if epDisco := ep.disco.Load(); epDisco == nil || epDisco.key != dk {
+		metricRecvDiscoAppMessageBadNode.Add(1)
+		c.logf("[unexpected] application message from peer via DERP whose netmap discokey != disco source")
+		return
+	}
+	h := c.appMsgHandlers[dm.Topic]
+	if h == nil {
+		metricRecvDiscoAppMessageNoHandler.Add(1)
+		return
+	}
+	c.dlogf("[v1] magicsock: disco: got application message for %q from %v, len %d", dm.Topic, derpNodeSrc.ShortString(), len(dm.Payload))
+	go h(derpNodeSrc, dm.Payload)
+}
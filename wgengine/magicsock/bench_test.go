@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tailscale/wireguard-go/tun/tuntest"
+	"tailscale.com/envknob"
+	"tailscale.com/net/packet"
+	"tailscale.com/tstest"
+	"tailscale.com/tstest/natlab"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/logger"
+)
+
+// BenchmarkTwoDevice measures the packet path between two magicsock and
+// wireguard-go stacks on a natlab network, over a direct UDP path and over
+// DERP. Profiles come from the usual go test flags, for example:
+//
+//	go test -run=NONE -bench=TwoDevice -benchmem -cpuprofile=cpu.out -memprofile=mem.out ./wgengine/magicsock
+func BenchmarkTwoDevice(b *testing.B) {
+	for _, path := range []string{"direct", "derp"} {
+		b.Run(path, func(b *testing.B) {
+			m1, m2 := newBenchStacks(b, path == "derp")
+			b.Run("latency", func(b *testing.B) {
+				benchLatency(b, m1, m2)
+			})
+			for _, size := range []int{128, 1280} {
+				b.Run(fmt.Sprintf("throughput-%d", size), func(b *testing.B) {
+					benchThroughput(b, m1, m2, size)
+				})
+			}
+		})
+	}
+}
+
+// newBenchStacks returns two meshed magicStacks on a natlab network that
+// have exchanged traffic from m1 to m2, over DERP if derpOnly and
+// otherwise over a direct path.
+func newBenchStacks(b *testing.B, derpOnly bool) (m1, m2 *magicStack) {
+	var logf logger.Logf = logger.Discard
+	if testing.Verbose() {
+		logf = b.Logf
+	}
+	if derpOnly {
+		envknob.Setenv("TS_DEBUG_ALWAYS_USE_DERP", "true")
+		b.Cleanup(func() { envknob.Setenv("TS_DEBUG_ALWAYS_USE_DERP", "") })
+	}
+
+	mstun := &natlab.Machine{Name: "stun"}
+	mach1 := &natlab.Machine{Name: "m1"}
+	mach2 := &natlab.Machine{Name: "m2"}
+	inet := natlab.NewInternet()
+	sif := mstun.Attach("eth0", inet)
+	mach1.Attach("eth0", inet)
+	mach2.Attach("eth0", inet)
+
+	derpMap, cleanup := runDERPAndStun(b, logf, mstun, sif.V4())
+	b.Cleanup(cleanup)
+	m1 = newMagicStack(b, logger.WithPrefix(logf, "conn1: "), mach1, derpMap)
+	b.Cleanup(m1.Close)
+	m2 = newMagicStack(b, logger.WithPrefix(logf, "conn2: "), mach2, derpMap)
+	b.Cleanup(m2.Close)
+	b.Cleanup(meshStacks(logf, nil, m1, m2))
+
+	// Send pings until one gets through and magicsock has settled on the
+	// path being measured. Active discovery needs the traffic to find a
+	// direct path.
+	pkt := tuntest.Ping(m2.IP(), m1.IP())
+	err := tstest.WaitFor(30*time.Second, func() error {
+		m1.tun.Outbound <- pkt
+		select {
+		case <-m2.tun.Inbound:
+		case <-time.After(time.Second):
+			return errors.New("ping didn't transit")
+		}
+		ps := m1.Status().Peer[m2.Public()]
+		if ps == nil {
+			return errors.New("m2 not yet a peer of m1")
+		}
+		if direct := ps.CurAddr != ""; direct == derpOnly {
+			return fmt.Errorf("direct path = %v; want %v", direct, !derpOnly)
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return m1, m2
+}
+
+// drainInbound discards packets still arriving at m, such as ones that
+// a previous benchmark gave up on.
+func drainInbound(m *magicStack) {
+	for {
+		select {
+		case <-m.tun.Inbound:
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+// benchLatency measures the time for a packet to get from src to dst.
+func benchLatency(b *testing.B, src, dst *magicStack) {
+	drainInbound(dst)
+	b.ReportAllocs()
+	pkt := tuntest.Ping(dst.IP(), src.IP())
+	timeout := time.NewTimer(time.Hour)
+	defer timeout.Stop()
+	b.ResetTimer()
+	for range b.N {
+		src.tun.Outbound <- pkt
+		timeout.Reset(5 * time.Second)
+		select {
+		case <-dst.tun.Inbound:
+		case <-timeout.C:
+			b.Fatal("timed out waiting for packet")
+		}
+	}
+}
+
+// benchThroughput measures the rate at which IP packets of size bytes can
+// be sent from src to dst, keeping up to 32 of them in flight. Packets
+// that don't arrive within a second are counted as lost.
+func benchThroughput(b *testing.B, src, dst *magicStack, size int) {
+	drainInbound(dst)
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	h := packet.UDP4Header{
+		IP4Header: packet.IP4Header{IPProto: ipproto.UDP, Src: src.IP(), Dst: dst.IP()},
+		SrcPort:   1234,
+		DstPort:   5678,
+	}
+	pkt := packet.Generate(h, make([]byte, size-h.Len()))
+
+	inFlight := make(chan struct{}, 32)
+	sent := make(chan struct{})
+	idle := time.NewTimer(time.Hour)
+	defer idle.Stop()
+	b.ResetTimer()
+
+	go func() {
+		defer close(sent)
+		for range b.N {
+			inFlight <- struct{}{}
+			src.tun.Outbound <- pkt
+		}
+	}()
+	var rx, lost int
+	for rx+lost < b.N {
+		idle.Reset(time.Second)
+		select {
+		case <-dst.tun.Inbound:
+			select {
+			case <-inFlight:
+				rx++
+			default: // a late packet, already counted as lost
+			}
+		case <-idle.C:
+			for n := len(inFlight); n > 0; n-- {
+				<-inFlight
+				lost++
+			}
+		}
+	}
+	<-sent
+	b.StopTimer()
+	b.ReportMetric(float64(lost)/float64(b.N), "lost/op")
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// maxChaosLatency is the most latency PeerChaos can add to a packet, to
+// bound the memory held by delayed packets.
+const maxChaosLatency = 10 * time.Second
+
+// PeerChaos is artificial degradation that a Conn applies to the WireGuard
+// packets it sends to a peer, so that developers can see how their
+// software behaves over a bad network path. Disco messages aren't
+// affected, so paths are still discovered and kept alive as usual.
+//
+// The zero value applies no degradation.
+type PeerChaos struct {
+	// Latency is how long each packet is held before it's sent.
+	Latency time.Duration `json:",omitempty"`
+
+	// Jitter is the most that a packet's latency is randomly increased
+	// by. Packets with different latencies can be reordered.
+	Jitter time.Duration `json:",omitempty"`
+
+	// DropRate is the fraction of packets, from 0 to 1, that are
+	// dropped instead of sent.
+	DropRate float64 `json:",omitempty"`
+}
+
+// IsZero reports whether pc applies no degradation.
+func (pc PeerChaos) IsZero() bool {
+	return pc == PeerChaos{}
+}
+
+func (pc PeerChaos) check() error {
+	if pc.Latency < 0 || pc.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if pc.Latency+pc.Jitter > maxChaosLatency {
+		return errors.New("latency plus jitter must be at most " + maxChaosLatency.String())
+	}
+	if !(pc.DropRate >= 0 && pc.DropRate <= 1) {
+		return errors.New("drop rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// SetPeerChaos sets the degradation applied to packets sent to peer,
+// replacing any set before. The zero PeerChaos removes it.
+//
+// It's a debugging aid and isn't persisted.
+func (c *Conn) SetPeerChaos(peer key.NodePublic, pc PeerChaos) error {
+	if err := pc.check(); err != nil {
+		return err
+	}
+	c.chaosMu.Lock()
+	defer c.chaosMu.Unlock()
+	if pc.IsZero() {
+		delete(c.chaos, peer)
+	} else {
+		mak.Set(&c.chaos, peer, pc)
+	}
+	c.chaosActive.Store(len(c.chaos) > 0)
+	c.logf("magicsock: chaos for peer %v set to %+v", peer.ShortString(), pc)
+	return nil
+}
+
+// PeerChaos returns the degradation applied to packets sent to peer.
+func (c *Conn) PeerChaos(peer key.NodePublic) PeerChaos {
+	c.chaosMu.Lock()
+	defer c.chaosMu.Unlock()
+	return c.chaos[peer]
+}
+
+// sendWithChaos sends buffs to de like de.send, after dropping and
+// delaying them as configured by pc. Delayed packets are copied, as
+// buffs is reused once sendWithChaos returns.
+func (c *Conn) sendWithChaos(de *endpoint, pc PeerChaos, buffs [][]byte) error {
+	if pc.DropRate > 0 {
+		kept := buffs[:0:0]
+		for _, b := range buffs {
+			if rand.Float64() >= pc.DropRate {
+				kept = append(kept, b)
+			}
+		}
+		metricSendDataChaosDropped.Add(int64(len(buffs) - len(kept)))
+		if len(kept) == 0 {
+			return nil
+		}
+		buffs = kept
+	}
+	if pc.Latency == 0 && pc.Jitter == 0 {
+		return de.send(buffs)
+	}
+
+	delayed := make([][]byte, len(buffs))
+	for i, b := range buffs {
+		delayed[i] = append([]byte(nil), b...)
+	}
+	delay := pc.Latency
+	if pc.Jitter > 0 {
+		delay += rand.N(pc.Jitter)
+	}
+	time.AfterFunc(delay, func() {
+		if c.closing.Load() {
+			return
+		}
+		de.send(delayed)
+	})
+	return nil
+}
@@ -4,6 +4,7 @@
 package magicsock
 
 import (
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
@@ -23,14 +24,28 @@
 // It's accessible either from tailscaled's debug port (at
 // /debug/magicsock) or via peerapi to a peer that's owned by the same
 // user (so they can e.g. inspect their phones).
+//
+// With the "json" query parameter, it serves c's DebugState as JSON
+// instead, with addresses redacted if the "redact" parameter is also set.
 func (c *Conn) ServeHTTPDebug(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("json") != "" {
+		st := c.DebugState(DebugStateOptions{RedactAddrs: r.FormValue("redact") != ""})
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(st)
+		return
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	now := time.Now()
+	now := c.clock.Now()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, "<h1>magicsock</h1>")
+	fmt.Fprintf(w, "<p>timer wakeups: %d (at most one per %v)</p>\n", c.timers.Wakeups(), c.timers.Resolution())
+	fmt.Fprintf(w, "<p>peers awaiting paced discovery: %d</p>\n", c.discoPacer.queueLen())
 
 	fmt.Fprintf(w, "<h2 id=derp><a href=#derp>#</a> DERP</h2><ul>")
 	if c.derpMap != nil {
@@ -131,8 +146,8 @@ func printEndpointHTML(w io.Writer, ep *endpoint) {
 		return // no activity ever
 	}
 
-	now := time.Now()
-	mnow := mono.Now()
+	now := ep.c.clock.Now()
+	mnow := ep.c.monoNow()
 	fmtMono := func(m mono.Time) string {
 		if m == 0 {
 			return "-"
@@ -184,6 +199,18 @@ func printEndpointHTML(w io.Writer, ep *endpoint) {
 	}
 	io.WriteString(w, "</ul>")
 
+	if len(ep.pathHistory) > 0 {
+		io.WriteString(w, "<p>Path history:</p><ul>")
+		for i := len(ep.pathHistory) - 1; i >= 0; i-- {
+			pc := ep.pathHistory[i]
+			fmt.Fprintf(w, "<li>%v ago: %s", now.Sub(pc.When).Round(time.Second), html.EscapeString(pc.To))
+			if pc.Why != "" {
+				fmt.Fprintf(w, " (%s)", html.EscapeString(pc.Why))
+			}
+			io.WriteString(w, "</li>\n")
+		}
+		io.WriteString(w, "</ul>")
+	}
 }
 
 func peerDebugName(p tailcfg.NodeView) string {
@@ -40,6 +40,9 @@
 	// debugDERPUseHTTP tells clients to connect to DERP via HTTP on port 3340 instead of
 	// HTTPS on 443.
 	debugUseDERPHTTP = envknob.RegisterBool("TS_DEBUG_USE_DERP_HTTP")
+	// debugDisableDERPCoalesce disables sending queued packets for the same
+	// peer in one DERP frame.
+	debugDisableDERPCoalesce = envknob.RegisterBool("TS_DEBUG_DISABLE_DERP_COALESCE")
 	// debugEnableSilentDisco disables the use of heartbeatTimer on the endpoint struct
 	// and attempts to handle disco silently. See issue #540 for details.
 	debugEnableSilentDisco = envknob.RegisterBool("TS_DEBUG_ENABLE_SILENT_DISCO")
@@ -22,6 +22,7 @@ func logDerpVerbose() bool             { return false }
 func debugReSTUNStopOnIdle() bool      { return false }
 func debugAlwaysDERP() bool            { return false }
 func debugUseDERPHTTP() bool           { return false }
+func debugDisableDERPCoalesce() bool   { return false }
 func debugEnableSilentDisco() bool     { return false }
 func debugSendCallMeUnknownPeer() bool { return false }
 func debugPMTUD() bool                 { return false }
@@ -0,0 +1,220 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// DebugStateOptions are options for Conn.DebugState.
+type DebugStateOptions struct {
+	// RedactAddrs, if true, replaces the IP addresses of peers' UDP
+	// endpoints with placeholders of the form "redacted-N", so that the
+	// snapshot can be shared without revealing where peers are. Within
+	// one snapshot, the same address always gets the same placeholder.
+	// Ports and DERP addresses are kept.
+	RedactAddrs bool
+}
+
+// DebugState is a snapshot of the internal state of a Conn, for debugging
+// and for tests that check its invariants. It can be encoded as JSON.
+//
+// Its format is not stable.
+type DebugState struct {
+	// HomeDERP is the region ID of the home DERP region, or 0 if none.
+	HomeDERP int
+
+	// DERPConns are the open DERP connections, sorted by region ID.
+	DERPConns []DebugDERPConn
+
+	// Peers are the peers known to the Conn, sorted by node key.
+	Peers []DebugPeer
+
+	// AddrsByKey are the UDP endpoints from which each peer has been
+	// seen to send, which are the reverse of NodeOfAddr.
+	AddrsByKey map[key.NodePublic][]string
+
+	// NodeOfAddr maps the UDP endpoints in AddrsByKey back to the node
+	// that uses them.
+	NodeOfAddr map[string]key.NodePublic
+
+	// EndpointOfDisco maps each disco key to the nodes using it.
+	// Usually there's one node per disco key.
+	EndpointOfDisco map[key.DiscoPublic][]key.NodePublic
+
+	// PendingCallMeMaybe are the peers to which a CallMeMaybe will be
+	// sent once our own endpoints have been refreshed.
+	PendingCallMeMaybe []key.NodePublic
+
+	// DERPHomeHistory are the most recent decisions about moving the
+	// home DERP region, oldest first.
+	DERPHomeHistory []DERPHomeDecision `json:",omitempty"`
+}
+
+// DebugDERPConn is the state of one DERP connection in a DebugState.
+type DebugDERPConn struct {
+	RegionID  int
+	Created   time.Time
+	LastWrite time.Time
+}
+
+// DebugPeer is the state of one peer in a DebugState.
+type DebugPeer struct {
+	NodeKey  key.NodePublic
+	NodeID   tailcfg.NodeID
+	DiscoKey key.DiscoPublic // zero if the peer doesn't support disco
+	DERPAddr string          `json:",omitempty"` // fallback DERP path, as a magic DERP address
+	BestAddr string          `json:",omitempty"` // best UDP path, if any
+
+	// Endpoints are the candidate UDP endpoints being tracked for the
+	// peer, sorted.
+	Endpoints []string
+
+	// CallMeMaybeEndpoints are the endpoints the peer most recently
+	// sent in a CallMeMaybe message, sorted.
+	CallMeMaybeEndpoints []string `json:",omitempty"`
+}
+
+// addrRedactor formats UDP endpoints for a DebugState.
+type addrRedactor struct {
+	redact bool
+	ips    map[netip.Addr]int // IP => N of its "redacted-N" placeholder
+}
+
+func (r *addrRedactor) format(ap netip.AddrPort) string {
+	if !ap.IsValid() {
+		return ""
+	}
+	if !r.redact || ap.Addr() == tailcfg.DerpMagicIPAddr {
+		return ap.String()
+	}
+	n, ok := r.ips[ap.Addr()]
+	if !ok {
+		n = len(r.ips) + 1
+		if r.ips == nil {
+			r.ips = make(map[netip.Addr]int)
+		}
+		r.ips[ap.Addr()] = n
+	}
+	return fmt.Sprintf("redacted-%d:%d", n, ap.Port())
+}
+
+// formatAll formats aps, sorted first so that placeholders are assigned
+// in a stable order.
+func (r *addrRedactor) formatAll(aps []netip.AddrPort) []string {
+	sort.Slice(aps, func(i, j int) bool { return ipPortLess(aps[i], aps[j]) })
+	ss := make([]string, len(aps))
+	for i, ap := range aps {
+		ss[i] = r.format(ap)
+	}
+	return ss
+}
+
+// DebugState returns a snapshot of c's internal state.
+func (c *Conn) DebugState(opts DebugStateOptions) *DebugState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &addrRedactor{redact: opts.RedactAddrs}
+	st := &DebugState{
+		HomeDERP:        c.myDerp,
+		AddrsByKey:      make(map[key.NodePublic][]string),
+		NodeOfAddr:      make(map[string]key.NodePublic),
+		EndpointOfDisco: make(map[key.DiscoPublic][]key.NodePublic),
+	}
+
+	for rid, ad := range c.activeDerp {
+		st.DERPConns = append(st.DERPConns, DebugDERPConn{
+			RegionID:  rid,
+			Created:   ad.createTime,
+			LastWrite: *ad.lastWrite,
+		})
+	}
+	sort.Slice(st.DERPConns, func(i, j int) bool {
+		return st.DERPConns[i].RegionID < st.DERPConns[j].RegionID
+	})
+
+	keys := make([]key.NodePublic, 0, len(c.peerMap.byNodeKey))
+	for k := range c.peerMap.byNodeKey {
+		keys = append(keys, k)
+	}
+	sortNodeKeys(keys)
+	for _, k := range keys {
+		pi := c.peerMap.byNodeKey[k]
+		st.Peers = append(st.Peers, pi.ep.debugState(r))
+		addrs := r.formatAll(pi.ipPorts.Slice())
+		st.AddrsByKey[k] = addrs
+		for _, a := range addrs {
+			st.NodeOfAddr[a] = k
+		}
+	}
+	// Addresses in byIPPort but not in their peer's ipPorts would
+	// violate peerMap's invariants; include them so that they show up.
+	var stray []netip.AddrPort
+	for ipp := range c.peerMap.byIPPort {
+		stray = append(stray, ipp)
+	}
+	for i, a := range r.formatAll(stray) {
+		if _, ok := st.NodeOfAddr[a]; !ok {
+			st.NodeOfAddr[a] = c.peerMap.byIPPort[stray[i]].ep.publicKey
+		}
+	}
+
+	for dk, nodes := range c.peerMap.nodesOfDisco {
+		nks := nodes.Slice()
+		sortNodeKeys(nks)
+		st.EndpointOfDisco[dk] = nks
+	}
+
+	for de := range c.onEndpointRefreshed {
+		st.PendingCallMeMaybe = append(st.PendingCallMeMaybe, de.publicKey)
+	}
+	sortNodeKeys(st.PendingCallMeMaybe)
+	st.DERPHomeHistory = append([]DERPHomeDecision(nil), c.derpHome.history...)
+	return st
+}
+
+func sortNodeKeys(ks []key.NodePublic) {
+	sort.Slice(ks, func(i, j int) bool { return ks[i].Less(ks[j]) })
+}
+
+// debugState returns the DebugPeer for de, formatting its addresses
+// with r.
+//
+// de.c.mu must be held.
+func (de *endpoint) debugState(r *addrRedactor) DebugPeer {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	p := DebugPeer{
+		NodeKey:  de.publicKey,
+		NodeID:   de.nodeID,
+		DERPAddr: r.format(de.derpAddr),
+		BestAddr: r.format(de.bestAddr.AddrPort),
+	}
+	if d := de.disco.Load(); d != nil {
+		p.DiscoKey = d.key
+	}
+	eps := make([]netip.AddrPort, 0, len(de.endpointState))
+	for ipp := range de.endpointState {
+		eps = append(eps, ipp)
+	}
+	p.Endpoints = r.formatAll(eps)
+	var cmm []netip.AddrPort
+	for ipp, ok := range de.isCallMeMaybeEP {
+		if ok {
+			cmm = append(cmm, ipp)
+		}
+	}
+	if len(cmm) > 0 {
+		p.CallMeMaybeEndpoints = r.formatAll(cmm)
+	}
+	return p
+}
@@ -6,6 +6,7 @@
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -26,7 +27,6 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
-	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/mak"
@@ -176,6 +176,17 @@ func (c *Conn) maybeSetNearestDERP(report *netcheck.Report) (preferredDERP int)
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
 		// one.
 		preferredDERP = c.pickDERPFallback()
+	} else {
+		c.mu.Lock()
+		myDerp := c.myDerp
+		move := c.derpHome.decide(c.clock.Now(), myDerp, report)
+		streak := c.derpHome.streak
+		c.mu.Unlock()
+		if !move {
+			c.dlogf("[v1] magicsock: staying on home derp-%d; derp-%d preferred by %d of %d reports", myDerp, preferredDERP, streak, derpHomeSwitchReports)
+			metricDERPHomeChangeDeferred.Add(1)
+			return myDerp
+		}
 	}
 	if !c.setNearestDERP(preferredDERP) {
 		preferredDERP = 0
@@ -193,17 +204,31 @@ func (c *Conn) derpRegionCodeLocked(regionID int) string {
 	return ""
 }
 
+// setMyDerpLocked sets the home DERP region to regionID, notifying
+// Options.DERPHomeFunc if it changed.
+//
+// c.mu must be held.
+func (c *Conn) setMyDerpLocked(regionID int) {
+	if c.myDerp == regionID {
+		return
+	}
+	c.myDerp = regionID
+	if c.derpHomeFunc != nil { // nil in some tests
+		c.derpHomeFunc(regionID)
+	}
+}
+
 // c.mu must NOT be held.
 func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if !c.wantDerpLocked() {
-		c.myDerp = 0
+		c.setMyDerpLocked(0)
 		c.health.SetMagicSockDERPHome(0, c.homeless)
 		return false
 	}
 	if c.homeless {
-		c.myDerp = 0
+		c.setMyDerpLocked(0)
 		c.health.SetMagicSockDERPHome(0, c.homeless)
 		return false
 	}
@@ -214,7 +239,7 @@ func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	if c.myDerp != 0 && derpNum != 0 {
 		metricDERPHomeChange.Add(1)
 	}
-	c.myDerp = derpNum
+	c.setMyDerpLocked(derpNum)
 	c.health.SetMagicSockDERPHome(derpNum, c.homeless)
 
 	if c.privateKey.IsZero() {
@@ -245,6 +270,38 @@ func (c *Conn) startDerpHomeConnectLocked() {
 	c.goDerpConnect(c.myDerp)
 }
 
+// errNoDERPHome is returned by ConnectDERPHome when there's no home DERP
+// region to connect to, or the Conn won't use DERP, as when it's closed,
+// the network is down or it has no private key.
+var errNoDERPHome = errors.New("no home DERP region to connect to")
+
+// ConnectDERPHome connects to the home DERP region, unless already
+// connected, and waits for the connection to be made or ctx to be done.
+//
+// The connection is otherwise made in the background, as soon as the home
+// region is known; ConnectDERPHome is for callers that need to wait for it,
+// for as long as they choose. If ctx is done first, the connection keeps
+// being attempted in the background.
+func (c *Conn) ConnectDERPHome(ctx context.Context) error {
+	c.mu.Lock()
+	regionID := c.myDerp
+	c.mu.Unlock()
+	if regionID == 0 {
+		return errNoDERPHome
+	}
+	if c.derpWriteChanForRegion(regionID, key.NodePublic{}) == nil {
+		return errNoDERPHome
+	}
+	c.mu.Lock()
+	ad, ok := c.activeDerp[regionID]
+	c.mu.Unlock()
+	if !ok {
+		// Raced with a close of the connection, as on a key change.
+		return errNoDERPHome
+	}
+	return ad.c.Connect(ctx)
+}
+
 // goDerpConnect starts a goroutine to start connecting to the given
 // DERP region ID.
 //
@@ -342,7 +399,7 @@ func (c *Conn) derpWriteChanForRegion(regionID int, peer key.NodePublic) chan<-
 	// below when we have both.)
 	ad, ok := c.activeDerp[regionID]
 	if ok {
-		*ad.lastWrite = time.Now()
+		*ad.lastWrite = c.clock.Now()
 		c.setPeerLastDerpLocked(peer, regionID, regionID)
 		return ad.writeCh
 	}
@@ -357,7 +414,7 @@ func (c *Conn) derpWriteChanForRegion(regionID int, peer key.NodePublic) chan<-
 		if r, ok := c.derpRoute[peer]; ok {
 			if ad, ok := c.activeDerp[r.regionID]; ok && ad.c == r.dc {
 				c.setPeerLastDerpLocked(peer, r.regionID, regionID)
-				*ad.lastWrite = time.Now()
+				*ad.lastWrite = c.clock.Now()
 				return ad.writeCh
 			}
 		}
@@ -396,8 +453,12 @@ func (c *Conn) derpWriteChanForRegion(regionID int, peer key.NodePublic) chan<-
 		return derpMap.Regions[regionID]
 	})
 	dc.HealthTracker = c.health
+	dc.PlaintextFallback = c.derpPlaintextFallbackAllowed
+	dc.DisableIPv4 = c.disableV4
+	dc.DisableIPv6 = c.disableV6
 
 	dc.SetCanAckPings(true)
+	dc.SetClock(c.clock)
 	dc.NotePreferred(c.myDerp == regionID)
 	dc.SetAddressFamilySelector(derpAddrFamSelector{c})
 	dc.DNSCache = dnscache.Get()
@@ -409,8 +470,8 @@ func (c *Conn) derpWriteChanForRegion(regionID int, peer key.NodePublic) chan<-
 	ad.writeCh = ch
 	ad.cancel = cancel
 	ad.lastWrite = new(time.Time)
-	*ad.lastWrite = time.Now()
-	ad.createTime = time.Now()
+	*ad.lastWrite = c.clock.Now()
+	ad.createTime = c.clock.Now()
 	c.activeDerp[regionID] = ad
 	metricNumDERPConns.Set(int64(len(c.activeDerp)))
 	c.logActiveDerpLocked()
@@ -522,6 +583,7 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 
 	defer c.health.SetDERPRegionConnectedState(regionID, false)
 	defer c.health.SetDERPRegionHealth(regionID, "")
+	defer c.health.SetDERPRegionPlaintext(regionID, false)
 
 	// peerPresent is the set of senders we know are present on this
 	// connection, based on messages we've received from the server.
@@ -534,6 +596,7 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 		msg, connGen, err := dc.RecvDetail()
 		if err != nil {
 			c.health.SetDERPRegionConnectedState(regionID, false)
+			c.health.SetDERPRegionPlaintext(regionID, false)
 			// Forget that all these peers have routes.
 			for peer := range peerPresent {
 				delete(peerPresent, peer)
@@ -553,6 +616,7 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 			}
 
 			c.logf("magicsock: [%p] derp.Recv(derp-%d): %v", dc, regionID, err)
+			c.derpHistory.NoteConnectFailure(regionID, c.clock.Now())
 
 			// If our DERP connection broke, it might be because our network
 			// conditions changed. Start that check.
@@ -569,7 +633,7 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 		}
 		bo.BackOff(ctx, nil) // reset
 
-		now := time.Now()
+		now := c.clock.Now()
 		if lastPacketTime.IsZero() || now.Sub(lastPacketTime) > frameReceiveRecordRate {
 			c.health.NoteDERPRegionReceivedFrame(regionID)
 			lastPacketTime = now
@@ -579,7 +643,9 @@ func (c *Conn) runDerpReader(ctx context.Context, regionID int, dc *derphttp.Cli
 		case derp.ServerInfoMessage:
 			c.health.SetDERPRegionConnectedState(regionID, true)
 			c.health.SetDERPRegionHealth(regionID, "") // until declared otherwise
+			c.health.SetDERPRegionPlaintext(regionID, dc.UsingPlaintextFallback())
 			c.logf("magicsock: derp-%d connected; connGen=%v", regionID, connGen)
+			c.derpHistory.NoteConnect(regionID, c.clock.Now())
 			continue
 		case derp.ReceivedPacket:
 			pkt = m
@@ -658,11 +724,22 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 		return
 	}
 
+	var (
+		pending *derpWriteRequest // dequeued but not yet sent
+		pkts    [][]byte
+	)
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		case wr := <-ch:
+		var wr derpWriteRequest
+		if pending != nil {
+			wr, pending = *pending, nil
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case wr = <-ch:
+			}
+		}
+		if debugDisableDERPCoalesce() {
 			err := dc.Send(wr.pubKey, wr.b)
 			if err != nil {
 				c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
@@ -670,7 +747,37 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 			} else {
 				metricSendDERP.Add(1)
 			}
+			continue
 		}
+
+		// Send the packets for the same peer that are already queued
+		// along with wr, in one frame if the server supports it, to
+		// save per-frame TLS and TCP overhead on chatty flows. This
+		// doesn't wait for more packets, so it adds no latency.
+		pkts = append(pkts[:0], wr.b)
+		size := 2 + len(wr.b)
+	coalesce:
+		for size < derp.MaxPacketsFrameSize {
+			select {
+			case next := <-ch:
+				if next.pubKey != wr.pubKey || size+2+len(next.b) > derp.MaxPacketsFrameSize {
+					pending = &next
+					break coalesce
+				}
+				pkts = append(pkts, next.b)
+				size += 2 + len(next.b)
+			default:
+				break coalesce
+			}
+		}
+		err := dc.SendPackets(wr.pubKey, pkts)
+		if err != nil {
+			c.logf("magicsock: derp.SendPackets(%v, %d packets): %v", wr.addr, len(pkts), err)
+			metricSendDERPError.Add(int64(len(pkts)))
+		} else {
+			metricSendDERP.Add(int64(len(pkts)))
+		}
+		clear(pkts)
 	}
 }
 
@@ -725,7 +832,7 @@ func (c *Conn) processDERPReadResult(dm derpReadResult, b []byte) (n int, ep *en
 		return 0, nil
 	}
 
-	ep.noteRecvActivity(ipp, mono.Now())
+	ep.noteRecvActivity(ipp, c.monoNow())
 	if stats := c.stats.Load(); stats != nil {
 		stats.UpdateRxPhysical(ep.nodeAddr, ipp, dm.n)
 	}
@@ -739,6 +846,20 @@ func (c *Conn) SetOnlyTCP443(v bool) {
 	c.onlyTCP443.Store(v)
 }
 
+// SetDERPPlaintextFallback sets whether DERP connections may fall back to
+// unencrypted HTTP on port 80 when TLS to a DERP server fails. It's ignored
+// when restricted to TCP port 443 (see SetOnlyTCP443).
+// See ipn.Prefs.DERPPlaintextFallback.
+func (c *Conn) SetDERPPlaintextFallback(v bool) {
+	c.derpPlaintextFallback.Store(v)
+}
+
+// derpPlaintextFallbackAllowed reports whether DERP connections may use
+// derphttp.Client.PlaintextFallback.
+func (c *Conn) derpPlaintextFallbackAllowed() bool {
+	return c.derpPlaintextFallback.Load() && !c.onlyTCP443.Load()
+}
+
 // SetDERPMap controls which (if any) DERP servers are used.
 // A nil value means to disable DERP; it's disabled by default.
 func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
@@ -789,7 +910,7 @@ func (c *Conn) SetDERPMap(dm *tailcfg.DERPMap) {
 			}
 			changes = true
 			if rid == c.myDerp {
-				c.myDerp = 0
+				c.setMyDerpLocked(0)
 			}
 			c.closeDerpLocked(rid, "derp-region-redefined")
 		}
@@ -845,9 +966,12 @@ func (c *Conn) maybeCloseDERPsOnRebind(okayLocalIPs []netip.Prefix) {
 		regionID := regionID
 		dc := ad.c
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			ctx, cancel := context.WithTimeout(c.connCtx, derpRebindPingTimeout)
 			defer cancel()
 			if err := dc.Ping(ctx); err != nil {
+				if c.connCtx.Err() != nil {
+					return // closing
+				}
 				c.mu.Lock()
 				defer c.mu.Unlock()
 				c.closeOrReconnectDERPLocked(regionID, "rebind-ping-fail")
@@ -877,7 +1001,7 @@ func (c *Conn) closeOrReconnectDERPLocked(regionID int, why string) {
 // It is the responsibility of the caller to call logActiveDerpLocked after any set of closes.
 func (c *Conn) closeDerpLocked(regionID int, why string) {
 	if ad, ok := c.activeDerp[regionID]; ok {
-		c.logf("magicsock: closing connection to derp-%v (%v), age %v", regionID, why, time.Since(ad.createTime).Round(time.Second))
+		c.logf("magicsock: closing connection to derp-%v (%v), age %v", regionID, why, c.clock.Since(ad.createTime).Round(time.Second))
 		go ad.c.Close()
 		ad.cancel()
 		delete(c.activeDerp, regionID)
@@ -887,7 +1011,7 @@ func (c *Conn) closeDerpLocked(regionID int, why string) {
 
 // c.mu must be held.
 func (c *Conn) logActiveDerpLocked() {
-	now := time.Now()
+	now := c.clock.Now()
 	c.logf("magicsock: %v active derp conns%s", len(c.activeDerp), logger.ArgWriter(func(buf *bufio.Writer) {
 		if len(c.activeDerp) == 0 {
 			return
@@ -925,7 +1049,7 @@ func (c *Conn) cleanStaleDerp() {
 	}
 	c.derpCleanupTimerArmed = false
 
-	tooOld := time.Now().Add(-derpInactiveCleanupTime)
+	tooOld := c.clock.Now().Add(-derpInactiveCleanupTime)
 	dirty := false
 	someNonHomeOpen := false
 	for i, ad := range c.activeDerp {
@@ -959,7 +1083,7 @@ func (c *Conn) scheduleCleanStaleDerpLocked() {
 	if c.derpCleanupTimer != nil {
 		c.derpCleanupTimer.Reset(derpCleanStaleInterval)
 	} else {
-		c.derpCleanupTimer = time.AfterFunc(derpCleanStaleInterval, c.cleanStaleDerp)
+		c.derpCleanupTimer = c.timers.AfterFunc(derpCleanStaleInterval, c.cleanStaleDerp)
 	}
 }
 
@@ -1005,4 +1129,8 @@ func (s derpAddrFamSelector) PreferIPv6() bool {
 	// derpCleanStaleInterval is how often cleanStaleDerp runs when there
 	// are potentially-stale DERP connections to close.
 	derpCleanStaleInterval = 15 * time.Second
+
+	// derpRebindPingTimeout is how long a DERP connection that survived a
+	// rebind has to answer a ping before it's closed or reconnected.
+	derpRebindPingTimeout = 3 * time.Second
 )
@@ -5,6 +5,7 @@
 
 import (
 	"testing"
+	"time"
 
 	"tailscale.com/net/netcheck"
 )
@@ -14,3 +15,56 @@ func CheckDERPHeuristicTimes(t *testing.T) {
 		t.Errorf("PreferredDERPFrameTime too low; should be at least frameReceiveRecordRate")
 	}
 }
+
+func TestDERPHomeDecide(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	report := func(preferred int, lat map[int]time.Duration) *netcheck.Report {
+		return &netcheck.Report{PreferredDERP: preferred, RegionLatency: lat}
+	}
+	fast2 := map[int]time.Duration{1: 80 * time.Millisecond, 2: 20 * time.Millisecond}
+
+	var s derpHomeState
+	if !s.decide(now, 0, report(1, fast2)) {
+		t.Fatal("initial home selection deferred")
+	}
+	if len(s.history) != 0 {
+		t.Errorf("initial home selection recorded: %+v", s.history)
+	}
+
+	// Another region must be preferred derpHomeSwitchReports times in a
+	// row, and a report preferring the home resets the count.
+	if s.decide(now, 1, report(2, fast2)) {
+		t.Fatal("switched after one report")
+	}
+	if !s.decide(now, 1, report(1, fast2)) {
+		t.Fatal("staying on home not allowed")
+	}
+	for i := 1; i < derpHomeSwitchReports; i++ {
+		if s.decide(now, 1, report(2, fast2)) {
+			t.Fatalf("switched after %d reports", i)
+		}
+	}
+	if !s.decide(now, 1, report(2, fast2)) {
+		t.Fatalf("didn't switch after %d reports", derpHomeSwitchReports)
+	}
+
+	// A home that didn't answer STUN is left right away.
+	if !s.decide(now, 2, report(1, map[int]time.Duration{1: 80 * time.Millisecond})) {
+		t.Fatal("stayed on unreachable home")
+	}
+
+	last := s.history[len(s.history)-1]
+	if !last.Switched || last.From != 2 || last.To != 1 || last.ToLatency != 80*time.Millisecond {
+		t.Errorf("last decision = %+v; want a switch from 2 to 1", last)
+	}
+	if got, want := len(s.history), 1+derpHomeSwitchReports+1; got != want {
+		t.Errorf("got %d decisions; want %d", got, want)
+	}
+
+	for range derpHomeHistorySize * 2 {
+		s.decide(now, 1, report(2, fast2))
+	}
+	if len(s.history) != derpHomeHistorySize {
+		t.Errorf("history has %d decisions; want at most %d", len(s.history), derpHomeHistorySize)
+	}
+}
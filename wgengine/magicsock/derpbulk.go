@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+const (
+	// derpBulkWindow is the period over which the rate of traffic sent to
+	// a peer over DERP is measured.
+	derpBulkWindow = time.Second
+
+	// derpBulkMinRate is the rate of traffic, in bytes per second, sent to
+	// a peer over DERP only, above which discovery of a direct path to the
+	// peer becomes more aggressive. Below it, the relayed traffic is cheap
+	// enough that regular discovery is fine.
+	derpBulkMinRate = 64 << 10
+
+	// derpBulkPingInterval is the minimum time between disco pings to an
+	// endpoint of a peer while discovery is aggressive, in place of
+	// discoPingInterval.
+	derpBulkPingInterval = time.Second
+
+	// derpBulkMaxDuration is how long discovery stays aggressive without
+	// finding a direct path before giving up, as there's likely none.
+	derpBulkMaxDuration = 15 * time.Second
+
+	// derpBulkMinBackoff and derpBulkMaxBackoff bound how long after
+	// giving up discovery can't become aggressive again. The backoff
+	// doubles with each consecutive attempt that gave up.
+	derpBulkMinBackoff = 30 * time.Second
+	derpBulkMaxBackoff = 10 * time.Minute
+)
+
+// derpBulkState tracks the rate of traffic an endpoint sends over DERP
+// only, to make discovery of a direct path more aggressive during bulk
+// transfers over DERP, escaping the relay sooner. It's guarded by
+// endpoint.mu.
+type derpBulkState struct {
+	windowStart mono.Time // start of the current rate window; zero if none
+	windowBytes int64     // bytes sent over DERP in the current window
+
+	activeSince  mono.Time     // when discovery became aggressive; zero if it isn't
+	backoffUntil mono.Time     // discovery can't become aggressive until then
+	backoff      time.Duration // last backoff; zero after a direct path is found
+}
+
+// active reports whether discovery is aggressive.
+func (s *derpBulkState) active() bool {
+	return !s.activeSince.IsZero()
+}
+
+// noteDERPSend records that n bytes were sent at now over DERP only, and
+// reports whether that made discovery aggressive.
+func (s *derpBulkState) noteDERPSend(now mono.Time, n int) (started bool) {
+	if s.active() {
+		if now.Sub(s.activeSince) >= derpBulkMaxDuration {
+			s.giveUp(now)
+		}
+		return false
+	}
+	if now.Before(s.backoffUntil) {
+		return false
+	}
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+	s.windowBytes += int64(n)
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < derpBulkWindow {
+		return false
+	}
+	rate := float64(s.windowBytes) / elapsed.Seconds()
+	s.windowStart, s.windowBytes = 0, 0
+	if rate < derpBulkMinRate {
+		return false
+	}
+	s.activeSince = now
+	metricDERPBulkProbingStarted.Add(1)
+	return true
+}
+
+// giveUp stops aggressive discovery, which didn't find a direct path, and
+// backs off.
+func (s *derpBulkState) giveUp(now mono.Time) {
+	s.activeSince = 0
+	s.backoff = min(max(2*s.backoff, derpBulkMinBackoff), derpBulkMaxBackoff)
+	s.backoffUntil = now.Add(s.backoff)
+	metricDERPBulkProbingGaveUp.Add(1)
+}
+
+// noteDirect records that traffic is going over a direct path, which stops
+// aggressive discovery and resets the backoff.
+func (s *derpBulkState) noteDirect() {
+	if s.active() {
+		metricDERPBulkProbingSucceeded.Add(1)
+	}
+	*s = derpBulkState{}
+}
+
+// noteSendPathForBulkLocked updates de.derpBulk for the sending of buffs
+// to udpAddr and derpAddr, as returned by addrForSendLocked.
+//
+// de.mu must be held.
+func (de *endpoint) noteSendPathForBulkLocked(now mono.Time, udpAddr, derpAddr netip.AddrPort, buffs [][]byte) {
+	if udpAddr.IsValid() {
+		de.derpBulk.noteDirect()
+		return
+	}
+	if !derpAddr.IsValid() || de.c.udpBlocked.Load() {
+		return
+	}
+	n := 0
+	for _, b := range buffs {
+		n += len(b)
+	}
+	wasActive := de.derpBulk.active()
+	if de.derpBulk.noteDERPSend(now, n) {
+		de.c.dlogf("[v1] magicsock: disco: bulk traffic to %v (%v) over DERP; probing for a direct path more often", de.publicKey.ShortString(), de.discoShort())
+		// Refresh our own endpoints, including port mappings, so that
+		// the next CallMeMaybe has fresh candidates. ReSTUN takes
+		// Conn.mu, which must not be acquired with de.mu held.
+		go de.c.ReSTUN("derp-bulk-transfer")
+	} else if wasActive && !de.derpBulk.active() {
+		de.c.dlogf("[v1] magicsock: disco: no direct path to %v (%v) found; backing off for %v", de.publicKey.ShortString(), de.discoShort(), de.derpBulk.backoff)
+	}
+}
+
+// discoPingIntervalLocked returns the minimum time between disco pings to
+// each of de's endpoints for discovery.
+//
+// de.mu must be held.
+func (de *endpoint) discoPingIntervalLocked() time.Duration {
+	if de.derpBulk.active() {
+		return min(derpBulkPingInterval, discoPingInterval)
+	}
+	return discoPingInterval
+}
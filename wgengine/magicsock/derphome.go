@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"time"
+
+	"tailscale.com/net/netcheck"
+)
+
+const (
+	// derpHomeSwitchReports is the number of consecutive netcheck reports
+	// that must prefer the same other DERP region before the home region
+	// moves there, as long as the current home is still reachable.
+	//
+	// netcheck already only prefers another region when it's
+	// significantly faster than the current home; this adds that it must
+	// be so consistently, so that one noisy report doesn't move the home,
+	// which every peer then has to learn about via control.
+	derpHomeSwitchReports = 3
+
+	// derpHomeHistorySize is the number of home DERP decisions a Conn
+	// remembers, for debugging.
+	derpHomeHistorySize = 32
+)
+
+// DERPHomeDecision is a decision about whether to move the home DERP region
+// to the region a netcheck report preferred.
+type DERPHomeDecision struct {
+	Time     time.Time
+	From     int  // home region at the time, or 0 if none
+	To       int  // region the netcheck report preferred
+	Switched bool // whether the home moved to To
+	Reason   string
+
+	// FromLatency and ToLatency are the latencies of the two regions in
+	// the netcheck report, or zero if the report has none.
+	FromLatency time.Duration `json:",omitempty"`
+	ToLatency   time.Duration `json:",omitempty"`
+}
+
+// derpHomeState is the state a Conn keeps to decide when to move its home
+// DERP region. It's guarded by Conn.mu.
+type derpHomeState struct {
+	candidate int // region preferred over the home by the latest reports, or 0
+	streak    int // number of consecutive reports that preferred candidate
+
+	history []DERPHomeDecision // oldest first; at most derpHomeHistorySize
+}
+
+// decide reports whether the home DERP region should move from home to
+// the region preferred by netcheck report r, and records the decision.
+//
+// The home moves right away if there's none yet, or if r has no latency for
+// it, which means it didn't answer STUN probes. Otherwise, the preferred
+// region must stay the same for derpHomeSwitchReports reports in a row.
+func (s *derpHomeState) decide(now time.Time, home int, r *netcheck.Report) bool {
+	want := r.PreferredDERP
+	if want == home || want == 0 {
+		s.candidate, s.streak = 0, 0
+		return true
+	}
+	if home == 0 {
+		// Initial selection; nothing to compare against.
+		s.candidate, s.streak = 0, 0
+		return true
+	}
+	d := DERPHomeDecision{
+		Time:        now,
+		From:        home,
+		To:          want,
+		FromLatency: r.RegionLatency[home],
+		ToLatency:   r.RegionLatency[want],
+	}
+	if s.candidate != want {
+		s.candidate, s.streak = want, 0
+	}
+	s.streak++
+	switch {
+	case d.FromLatency == 0:
+		d.Switched = true
+		d.Reason = "home region unreachable over STUN"
+	case s.streak >= derpHomeSwitchReports:
+		d.Switched = true
+		d.Reason = fmt.Sprintf("faster in %d reports in a row", s.streak)
+	default:
+		d.Reason = fmt.Sprintf("faster in %d of %d reports needed", s.streak, derpHomeSwitchReports)
+	}
+	if d.Switched {
+		s.candidate, s.streak = 0, 0
+	}
+	s.noteDecision(d)
+	return d.Switched
+}
+
+func (s *derpHomeState) noteDecision(d DERPHomeDecision) {
+	if len(s.history) >= derpHomeHistorySize {
+		s.history = append(s.history[:0], s.history[1:]...)
+	}
+	s.history = append(s.history, d)
+}
+
+// DERPHomeHistory returns the most recent decisions about moving the home
+// DERP region to another one, oldest first. Selecting an initial home
+// region isn't included.
+func (c *Conn) DERPHomeHistory() []DERPHomeDecision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]DERPHomeDecision(nil), c.derpHome.history...)
+}
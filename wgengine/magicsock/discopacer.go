@@ -0,0 +1,319 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tailscale/wireguard-go/device"
+	"tailscale.com/tempfork/heap"
+	"tailscale.com/tstime"
+)
+
+const (
+	// discoPacerMinNewPeers is the number of peers a network map must add
+	// for the initial discovery of peers to be paced. Smaller changes
+	// don't cause enough simultaneous discovery to be worth pacing.
+	discoPacerMinNewPeers = 64
+
+	// discoPacerWindow is how long after a network map adding at least
+	// discoPacerMinNewPeers peers the initial discovery of peers is paced.
+	// It covers the burst of traffic to many peers that typically follows
+	// startup.
+	discoPacerWindow = 30 * time.Second
+
+	// discoPacerInterval is how often a peer's initial discovery may start
+	// while pacing, once discoPacerBurst discoveries have started.
+	discoPacerInterval = 10 * time.Millisecond
+
+	// discoPacerBurst is how many peers' initial discoveries may start at
+	// once while pacing.
+	discoPacerBurst = 32
+)
+
+// discoPacer paces the initial disco discovery (disco pings to all of a
+// peer's endpoints, plus a CallMeMaybe over DERP) and WireGuard handshake
+// initiation of peers while a network map adding many peers at once, as
+// happens at startup, is being loaded, to avoid CPU and network spikes and
+// DERP write storms. Discoveries beyond the allowed rate are queued and
+// started later, most recently seen peers first. Meanwhile, the latest
+// handshake initiation to each queued peer is held, and sent once its
+// discovery starts; other packets go over DERP as they do before discovery
+// finds a direct path.
+//
+// Later discoveries of a peer (after its direct path expires, for
+// instance) aren't paced.
+//
+// Its methods are no-ops on a nil discoPacer, as used by tests that
+// construct a Conn directly.
+type discoPacer struct {
+	c *Conn
+
+	mu          sync.Mutex
+	activeUntil time.Time                      // pacing is active until then; zero if never
+	tokens      float64                        // discoveries that may start without waiting
+	refilledAt  time.Time                      // when tokens was last updated
+	queue       discoPacerHeap                 // endpoints waiting for discovery
+	queued      map[*endpoint]*discoPacerEntry // endpoints in queue
+	seq         int64                          // incremented for each queued endpoint
+	timer       tstime.TimerController         // drains queue; nil if not armed
+	closed      bool
+}
+
+func newDiscoPacer(c *Conn) *discoPacer {
+	return &discoPacer{
+		c:      c,
+		queued: make(map[*endpoint]*discoPacerEntry),
+	}
+}
+
+// noteNewPeers is called by SetNetworkMap with the number of peers the new
+// network map added, to start pacing if there are many.
+func (p *discoPacer) noteNewPeers(n int) {
+	if p == nil || n < discoPacerMinNewPeers {
+		return
+	}
+	now := p.c.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.activeLocked(now) {
+		p.tokens = discoPacerBurst
+		p.refilledAt = now
+	}
+	p.activeUntil = now.Add(discoPacerWindow)
+	metricDiscoPacerWindows.Add(1)
+}
+
+func (p *discoPacer) activeLocked(now time.Time) bool {
+	return now.Before(p.activeUntil)
+}
+
+// refillLocked adds the tokens earned since the last refill.
+func (p *discoPacer) refillLocked(now time.Time) {
+	if elapsed := now.Sub(p.refilledAt); elapsed > 0 {
+		p.tokens = min(p.tokens+float64(elapsed)/float64(discoPacerInterval), discoPacerBurst)
+		p.refilledAt = now
+	}
+}
+
+// allowLocked reports whether de may start discovery now. If not, de is
+// queued, and its discovery is started by the pacer later.
+//
+// de.mu must be held.
+func (p *discoPacer) allowLocked(de *endpoint) bool {
+	if p == nil {
+		return true
+	}
+	if !de.lastFullPing.IsZero() {
+		// Not the initial discovery.
+		return true
+	}
+	now := p.c.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || !p.activeLocked(now) {
+		return true
+	}
+	if p.queued[de] != nil {
+		return false
+	}
+	p.refillLocked(now)
+	if p.tokens >= 1 && len(p.queue) == 0 {
+		p.tokens--
+		return true
+	}
+	p.seq++
+	e := &discoPacerEntry{
+		de:       de,
+		lastSeen: de.lastSeenFromControl,
+		seq:      p.seq,
+	}
+	heap.Push(&p.queue, e)
+	p.queued[de] = e
+	metricDiscoPacerQueued.Add(1)
+	p.armLocked(now)
+	return false
+}
+
+// armLocked arms the timer to drain the queue once a token is available, if
+// it isn't armed already.
+func (p *discoPacer) armLocked(now time.Time) {
+	if p.timer != nil || len(p.queue) == 0 {
+		return
+	}
+	d := time.Duration((1 - p.tokens) * float64(discoPacerInterval))
+	// The timer's func may be called with the clock's lock held (as
+	// tstest.Clock does), so drain in a new goroutine.
+	p.timer = p.c.clock.AfterFunc(max(d, time.Millisecond), func() { go p.drain() })
+}
+
+// drain starts the discovery of as many queued endpoints as the rate
+// allows, and rearms the timer if any remain.
+func (p *discoPacer) drain() {
+	now := p.c.clock.Now()
+	p.mu.Lock()
+	p.timer = nil
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.refillLocked(now)
+	var due []*discoPacerEntry
+	for len(p.queue) > 0 && (p.tokens >= 1 || !p.activeLocked(now)) {
+		e := heap.Pop(&p.queue)
+		delete(p.queued, e.de)
+		due = append(due, e)
+		if p.activeLocked(now) {
+			p.tokens--
+		}
+	}
+	p.armLocked(now)
+	p.mu.Unlock()
+
+	for _, e := range due {
+		e.de.startPacedDiscovery()
+		if e.handshake != nil {
+			// WireGuard retries the handshake if this fails.
+			e.de.send([][]byte{e.handshake})
+		}
+	}
+}
+
+// isHandshakeInitiation reports whether pkt is a WireGuard handshake
+// initiation message.
+func isHandshakeInitiation(pkt []byte) bool {
+	return len(pkt) == device.MessageInitiationSize && binary.LittleEndian.Uint32(pkt) == device.MessageInitiationType
+}
+
+// holdHandshake holds the WireGuard handshake initiation pkt to de, to be
+// sent once de's discovery starts instead of over DERP right away. Only the
+// latest one is held, as each supersedes the previous. It reports whether
+// pkt was held, which it isn't if de isn't queued for discovery.
+func (p *discoPacer) holdHandshake(de *endpoint, pkt []byte) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.queued[de]
+	if e == nil {
+		return false
+	}
+	if e.handshake == nil {
+		metricDiscoPacerHandshakesHeld.Add(1)
+	}
+	e.handshake = append(e.handshake[:0], pkt...)
+	return true
+}
+
+// forget removes de from the queue, if queued, as it's been deleted.
+func (p *discoPacer) forget(de *endpoint) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.queued[de]; e != nil {
+		heap.Remove(&p.queue, e.index)
+		delete(p.queued, de)
+	}
+}
+
+// queueLen returns the number of endpoints waiting for discovery.
+func (p *discoPacer) queueLen() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// validate checks that p's queue and its index are consistent.
+func (p *discoPacer) validate() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) != len(p.queued) {
+		return fmt.Errorf("disco pacer has %d queued endpoints but %d indexed", len(p.queue), len(p.queued))
+	}
+	for i, e := range p.queue {
+		if e.index != i || p.queued[e.de] != e {
+			return fmt.Errorf("disco pacer entry %d for %v is misindexed", i, e.de.publicKey.ShortString())
+		}
+	}
+	return nil
+}
+
+// close stops p. Queued endpoints are dropped.
+func (p *discoPacer) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.queue = nil
+	clear(p.queued)
+}
+
+// discoPacerEntry is an endpoint waiting in a discoPacerHeap.
+type discoPacerEntry struct {
+	de       *endpoint
+	lastSeen time.Time // de's last seen time according to control, when queued
+	seq      int64     // queueing order, to break ties
+	index    int       // index in the containing discoPacerHeap
+
+	handshake []byte // latest held WireGuard handshake initiation, or nil
+}
+
+// discoPacerHeap is a heap of endpoints waiting for discovery, ordered by
+// descending last seen time (i.e. most recently seen first), then by
+// queueing order.
+type discoPacerHeap []*discoPacerEntry
+
+var _ heap.Interface[*discoPacerEntry] = (*discoPacerHeap)(nil)
+
+// Len implements heap.Interface.
+func (h discoPacerHeap) Len() int { return len(h) }
+
+// Less implements heap.Interface.
+func (h discoPacerHeap) Less(i, j int) bool {
+	if !h[i].lastSeen.Equal(h[j].lastSeen) {
+		return h[i].lastSeen.After(h[j].lastSeen)
+	}
+	return h[i].seq < h[j].seq
+}
+
+// Swap implements heap.Interface.
+func (h discoPacerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push implements heap.Interface.
+func (h *discoPacerHeap) Push(e *discoPacerEntry) {
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+// Pop implements heap.Interface.
+func (h *discoPacerHeap) Pop() *discoPacerEntry {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
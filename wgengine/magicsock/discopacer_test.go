@@ -0,0 +1,184 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/tailscale/wireguard-go/device"
+	"tailscale.com/net/stun"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+)
+
+func TestDiscoPacer(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	c := newConn(t.Logf)
+	c.setClock(clock)
+	p := c.discoPacer
+
+	newEndpoint := func(lastSeen time.Time) *endpoint {
+		return &endpoint{
+			c:                   c,
+			publicKey:           key.NewNode().Public(),
+			sentPing:            map[stun.TxID]sentPing{},
+			endpointState:       map[netip.AddrPort]*endpointState{},
+			lastSeenFromControl: lastSeen,
+		}
+	}
+	allow := func(de *endpoint) bool {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		return p.allowLocked(de)
+	}
+	started := func(de *endpoint) bool {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		return !de.lastFullPing.IsZero()
+	}
+
+	// Without a large netmap, discovery isn't paced.
+	if !allow(newEndpoint(time.Time{})) {
+		t.Fatal("discovery paced without a large netmap")
+	}
+
+	p.noteNewPeers(discoPacerMinNewPeers)
+	for i := range discoPacerBurst {
+		if !allow(newEndpoint(time.Time{})) {
+			t.Fatalf("discovery %d of the initial burst paced", i)
+		}
+	}
+
+	// The rest are queued, and started most recently seen first.
+	base := clock.Now().Add(-time.Hour)
+	old := newEndpoint(base)
+	recent := newEndpoint(base.Add(time.Minute))
+	unknown := newEndpoint(time.Time{})
+	for _, de := range []*endpoint{old, unknown, recent} {
+		if allow(de) {
+			t.Fatal("discovery beyond the burst allowed")
+		}
+	}
+	if allow(recent) {
+		t.Error("queued endpoint allowed")
+	}
+	if got := p.queueLen(); got != 3 {
+		t.Fatalf("queueLen = %d; want 3", got)
+	}
+
+	waitStarted := func(de *endpoint) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !started(de); {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for paced discovery")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, de := range []*endpoint{recent, old, unknown} {
+		clock.Advance(discoPacerInterval)
+		waitStarted(de)
+		for _, later := range []*endpoint{recent, old, unknown}[i+1:] {
+			if started(later) {
+				t.Fatalf("discovery %d started out of order", i+1)
+			}
+		}
+	}
+
+	// Later discoveries aren't paced.
+	if !allow(recent) {
+		t.Error("discovery after the initial one paced")
+	}
+
+	// Nor is initial discovery once the window is over.
+	clock.Advance(discoPacerWindow)
+	if !allow(newEndpoint(time.Time{})) {
+		t.Error("discovery paced after the pacing window")
+	}
+}
+
+func TestDiscoPacerForget(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	c := newConn(t.Logf)
+	c.setClock(clock)
+	p := c.discoPacer
+
+	p.noteNewPeers(discoPacerMinNewPeers)
+	p.mu.Lock()
+	p.tokens = 0
+	p.mu.Unlock()
+
+	var eps []*endpoint
+	for range 3 {
+		de := &endpoint{c: c, sentPing: map[stun.TxID]sentPing{}, endpointState: map[netip.AddrPort]*endpointState{}}
+		de.mu.Lock()
+		if p.allowLocked(de) {
+			t.Fatal("discovery allowed without tokens")
+		}
+		de.mu.Unlock()
+		eps = append(eps, de)
+	}
+	eps[1].stopAndReset()
+	if got := p.queueLen(); got != 2 {
+		t.Fatalf("queueLen after deleting an endpoint = %d; want 2", got)
+	}
+	p.close()
+	if got := p.queueLen(); got != 0 {
+		t.Errorf("queueLen after close = %d; want 0", got)
+	}
+}
+
+func TestDiscoPacerHoldHandshake(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(1000, 0)})
+	c := newConn(t.Logf)
+	c.setClock(clock)
+	p := c.discoPacer
+
+	initiation := func(b byte) []byte {
+		pkt := make([]byte, device.MessageInitiationSize)
+		binary.LittleEndian.PutUint32(pkt, device.MessageInitiationType)
+		pkt[len(pkt)-1] = b
+		return pkt
+	}
+	if !isHandshakeInitiation(initiation(0)) {
+		t.Fatal("handshake initiation not recognized")
+	}
+	if isHandshakeInitiation(make([]byte, device.MessageInitiationSize)) {
+		t.Fatal("non-initiation recognized as a handshake initiation")
+	}
+
+	de := &endpoint{c: c, sentPing: map[stun.TxID]sentPing{}, endpointState: map[netip.AddrPort]*endpointState{}}
+	if p.holdHandshake(de, initiation(1)) {
+		t.Fatal("handshake held for an endpoint that isn't queued")
+	}
+
+	p.noteNewPeers(discoPacerMinNewPeers)
+	p.mu.Lock()
+	p.tokens = 0
+	p.mu.Unlock()
+	de.mu.Lock()
+	if p.allowLocked(de) {
+		t.Fatal("discovery allowed without tokens")
+	}
+	de.mu.Unlock()
+
+	pkt := initiation(1)
+	if !p.holdHandshake(de, pkt) {
+		t.Fatal("handshake not held for a queued endpoint")
+	}
+	pkt[len(pkt)-1] = 9 // the caller may reuse its buffer
+	if !p.holdHandshake(de, initiation(2)) {
+		t.Fatal("second handshake not held")
+	}
+	p.mu.Lock()
+	got := p.queued[de].handshake
+	p.mu.Unlock()
+	if !bytes.Equal(got, initiation(2)) {
+		t.Error("held handshake isn't the latest one")
+	}
+}
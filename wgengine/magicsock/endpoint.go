@@ -16,6 +16,7 @@
 	"reflect"
 	"runtime"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +30,7 @@
 	"tailscale.com/net/stun"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -74,11 +76,12 @@ type endpoint struct {
 	// mu protects all following fields.
 	mu sync.Mutex // Lock ordering: Conn.mu, then endpoint.mu
 
-	heartBeatTimer *time.Timer    // nil when idle
-	lastSendExt    mono.Time      // last time there were outgoing packets sent to this peer from an external trigger (e.g. wireguard-go or disco pingCLI)
-	lastSendAny    mono.Time      // last time there were outgoing packets sent this peer from any trigger, internal or external to magicsock
-	lastFullPing   mono.Time      // last time we pinged all disco or wireguard only endpoints
-	derpAddr       netip.AddrPort // fallback/bootstrap path, if non-zero (non-zero for well-behaved clients)
+	heartBeatTimer tstime.TimerController // nil when idle
+	lastSendExt    mono.Time              // last time there were outgoing packets sent to this peer from an external trigger (e.g. wireguard-go or disco pingCLI)
+	lastSendAny    mono.Time              // last time there were outgoing packets sent this peer from any trigger, internal or external to magicsock
+	lastSendWG     mono.Time              // last time wireguard-go sent packets to this peer
+	lastFullPing   mono.Time              // last time we pinged all disco or wireguard only endpoints
+	derpAddr       netip.AddrPort         // fallback/bootstrap path, if non-zero (non-zero for well-behaved clients)
 
 	bestAddr           addrQuality // best non-DERP path; zero if none; mutate via setBestAddrLocked()
 	bestAddrAt         mono.Time   // time best address re-confirmed
@@ -95,6 +98,78 @@ type endpoint struct {
 
 	expired         bool // whether the node has expired
 	isWireguardOnly bool // whether the endpoint is WireGuard only
+
+	// lastSeenFromControl is when control last saw the node online (now,
+	// if it's online), or zero if unknown. It prioritizes the node's
+	// discovery when discoPacer paces it.
+	lastSeenFromControl time.Time
+
+	// lastSendPath is the path that the last send used, and pathWhy is
+	// why bestAddr or derpAddr last changed. They feed pathHistory, the
+	// most recent changes of the send path.
+	lastSendPath sendPath
+	pathWhy      string
+	pathHistory  []PathChange // oldest first; at most pathHistorySize
+
+	// derpBulk makes discovery more aggressive while lots of traffic
+	// goes over DERP only.
+	derpBulk derpBulkState
+}
+
+// pathHistorySize is the number of path changes each endpoint remembers.
+const pathHistorySize = 32
+
+// sendPath is the set of addresses that endpoint.send sends to.
+type sendPath struct {
+	udp, derp netip.AddrPort
+}
+
+func (p sendPath) String() string {
+	var parts []string
+	if p.udp.IsValid() {
+		parts = append(parts, "direct "+p.udp.String())
+	}
+	if p.derp.IsValid() {
+		parts = append(parts, fmt.Sprintf("DERP %d", p.derp.Port()))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// PathChange is a change of the path that magicsock sends a peer's packets
+// over. This is not a stable interface and could change at any time.
+type PathChange struct {
+	When time.Time // when the first packet was sent over the new path
+	From string    `json:",omitempty"` // previous path, or empty if none
+	To   string    // "direct ip:port", "DERP region", or both joined by " + "
+	Why  string    `json:",omitempty"` // the cause of the change, if known
+}
+
+// notePathLocked records the path that a send is about to use in
+// de.pathHistory, if it's different from the last send's.
+//
+// de.mu must be held.
+func (de *endpoint) notePathLocked(udpAddr, derpAddr netip.AddrPort) {
+	p := sendPath{udpAddr, derpAddr}
+	if p == de.lastSendPath {
+		return
+	}
+	why := de.pathWhy
+	switch {
+	case udpAddr.IsValid() && derpAddr.IsValid():
+		why = "direct path unconfirmed"
+	case derpAddr.IsValid() && de.bestAddr.IsValid() && de.c.udpBlocked.Load():
+		why = "UDP blocked"
+	}
+	if len(de.pathHistory) == pathHistorySize {
+		de.pathHistory = slices.Delete(de.pathHistory, 0, 1)
+	}
+	de.pathHistory = append(de.pathHistory, PathChange{
+		When: de.c.clock.Now(),
+		From: de.lastSendPath.String(),
+		To:   p.String(),
+		Why:  why,
+	})
+	de.lastSendPath = p
 }
 
 func (de *endpoint) setBestAddrLocked(v addrQuality) {
@@ -132,7 +207,7 @@ type probeUDPLifetime struct {
 
 	// timer is nil when idle. A non-nil timer indicates we intend to probe a
 	// timeout cliff in the future.
-	timer *time.Timer
+	timer tstime.TimerController
 
 	// bestAddr contains the endpoint.bestAddr.AddrPort at the time a cycle was
 	// scheduled to start. A probing cycle is 1:1 with the current
@@ -335,7 +410,7 @@ type endpointDisco struct {
 type sentPing struct {
 	to      netip.AddrPort
 	at      mono.Time
-	timer   *time.Timer // timeout timer
+	timer   tstime.TimerController // timeout timer
 	purpose discoPingPurpose
 	size    int                    // size of the disco message
 	resCB   *pingResultAndCallback // or nil for internal use
@@ -400,8 +475,9 @@ type EndpointChange struct {
 	To   any       `json:",omitempty"` // information about the new state
 }
 
-// shouldDeleteLocked reports whether we should delete this endpoint.
-func (st *endpointState) shouldDeleteLocked() bool {
+// shouldDeleteLocked reports whether we should delete this endpoint,
+// given the current time now.
+func (st *endpointState) shouldDeleteLocked(now time.Time) bool {
 	switch {
 	case !st.callMeMaybeTime.IsZero():
 		return false
@@ -410,7 +486,7 @@ func (st *endpointState) shouldDeleteLocked() bool {
 		return st.index == indexSentinelDeleted
 	default:
 		// This was an endpoint discovered at runtime.
-		return time.Since(st.lastGotPing) > sessionActiveTimeout
+		return now.Sub(st.lastGotPing) > sessionActiveTimeout
 	}
 }
 
@@ -440,18 +516,19 @@ func (st *endpointState) addPongReplyLocked(r pongReply) {
 
 func (de *endpoint) deleteEndpointLocked(why string, ep netip.AddrPort) {
 	de.debugUpdates.Add(EndpointChange{
-		When: time.Now(),
+		When: de.c.clock.Now(),
 		What: "deleteEndpointLocked-" + why,
 		From: ep,
 	})
 	delete(de.endpointState, ep)
 	if de.bestAddr.AddrPort == ep {
 		de.debugUpdates.Add(EndpointChange{
-			When: time.Now(),
+			When: de.c.clock.Now(),
 			What: "deleteEndpointLocked-bestAddr-" + why,
 			From: de.bestAddr,
 		})
 		de.setBestAddrLocked(addrQuality{})
+		de.pathWhy = "endpoint removed (" + why + ")"
 	}
 }
 
@@ -536,6 +613,13 @@ func (de *endpoint) addrForSendLocked(now mono.Time) (udpAddr, derpAddr netip.Ad
 		return udpAddr, netip.AddrPort{}, false
 	}
 
+	if de.c.udpBlocked.Load() && !de.isWireguardOnly && de.derpAddr.IsValid() {
+		// UDP is blocked on this network, so until discovery finds a
+		// path that works (such as on the LAN), use only DERP rather
+		// than also an untrusted UDP path; see Conn.noteUDPReachability.
+		return netip.AddrPort{}, de.derpAddr, false
+	}
+
 	if de.isWireguardOnly {
 		// If the endpoint is wireguard-only, we don't have a DERP
 		// address to send to, so we have to send to the UDP address.
@@ -665,7 +749,7 @@ func (de *endpoint) maybeProbeUDPLifetimeLocked() (afterInactivityFor time.Durat
 		// lower disco pub key node probes higher
 		return afterInactivityFor, false
 	}
-	if !p.cycleActive && time.Since(p.cycleStartedAt) < p.config.CycleCanStartEvery {
+	if !p.cycleActive && de.c.clock.Since(p.cycleStartedAt) < p.config.CycleCanStartEvery {
 		// This is conservative as it doesn't account for afterInactivityFor use
 		// by the caller, potentially delaying the start of the next cycle. We
 		// assume the cycle could start immediately following
@@ -701,7 +785,9 @@ func (de *endpoint) scheduleHeartbeatForLifetimeLocked(after time.Duration, via
 	de.c.dlogf("[v1] magicsock: disco: scheduling UDP lifetime probe for cliff=%v via=%v to %v (%v)",
 		p.currentCliffDurationEndpointLocked(), via, de.publicKey.ShortString(), de.discoShort())
 	p.bestAddr = de.bestAddr.AddrPort
-	p.timer = time.AfterFunc(after, de.heartbeatForLifetime)
+	// Not de.c.timers: these probes must fire within
+	// udpLifetimeProbeSchedulingTolerance of when they're scheduled.
+	p.timer = de.c.clock.AfterFunc(after, de.heartbeatForLifetime)
 	if via == heartbeatForLifetimeViaSelf {
 		metricUDPLifetimeCliffsRescheduled.Add(1)
 	} else {
@@ -728,6 +814,11 @@ func (de *endpoint) heartbeatForLifetime() {
 		return
 	}
 	p.timer = nil
+	if de.c.lowPowerIdle() {
+		// Don't wake the radio to probe an idle path. The cycle resumes
+		// at the tail end of another session.
+		return
+	}
 	if !p.bestAddr.IsValid() || de.bestAddr.AddrPort != p.bestAddr {
 		// best path changed
 		p.resetCycleEndpointLocked()
@@ -738,7 +829,7 @@ func (de *endpoint) heartbeatForLifetime() {
 		p.resetCycleEndpointLocked()
 		return
 	}
-	inactiveFor := mono.Now().Sub(max(de.lastRecvUDPAny.LoadAtomic(), de.lastSendAny))
+	inactiveFor := de.c.monoNow().Sub(max(de.lastRecvUDPAny.LoadAtomic(), de.lastSendAny))
 	delta := afterInactivityFor - inactiveFor
 	if delta.Abs() > udpLifetimeProbeSchedulingTolerance {
 		if delta < 0 {
@@ -755,15 +846,16 @@ func (de *endpoint) heartbeatForLifetime() {
 		}
 	}
 	if p.currentCliff == 0 {
-		p.cycleStartedAt = time.Now()
+		p.cycleStartedAt = de.c.clock.Now()
 		p.cycleActive = true
 	}
 	de.c.dlogf("[v1] magicsock: disco: sending disco ping for UDP lifetime probe cliff=%v to %v (%v)",
 		p.currentCliffDurationEndpointLocked(), de.publicKey.ShortString(), de.discoShort())
-	de.startDiscoPingLocked(de.bestAddr.AddrPort, mono.Now(), pingHeartbeatForUDPLifetime, 0, nil)
+	de.startDiscoPingLocked(de.bestAddr.AddrPort, de.c.monoNow(), pingHeartbeatForUDPLifetime, 0, nil)
 }
 
-// heartbeat is called every heartbeatInterval to keep the best UDP path alive,
+// heartbeat is called every heartbeatInterval, or the interval set for the
+// peer by Conn.SetPeerHeartbeatInterval, to keep the best UDP path alive,
 // kick off discovery of other paths, or schedule the probing of UDP path
 // lifetime on the tail end of an active session.
 func (de *endpoint) heartbeat() {
@@ -776,8 +868,13 @@ func (de *endpoint) heartbeat() {
 	}
 	de.heartBeatTimer = nil
 
-	if de.heartbeatDisabled {
-		// If control override to disable heartBeatTimer set, return early.
+	now := de.c.monoNow()
+	if _, ok := de.nextHeartbeatLocked(now); !ok {
+		// Heartbeats are disabled by control, or for this peer.
+		return
+	}
+	if de.c.lowPowerIdle() {
+		// Resumed by the next send.
 		return
 	}
 
@@ -786,7 +883,6 @@ func (de *endpoint) heartbeat() {
 		return
 	}
 
-	now := mono.Now()
 	if now.Sub(de.lastSendExt) > sessionActiveTimeout {
 		// Session's idle. Stop heartbeating.
 		de.c.dlogf("[v1] magicsock: disco: ending heartbeats for idle session to %v (%v)", de.publicKey.ShortString(), de.discoShort())
@@ -827,7 +923,9 @@ func (de *endpoint) heartbeat() {
 		de.sendDiscoPingsLocked(now, true)
 	}
 
-	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	if d, ok := de.nextHeartbeatLocked(now); ok {
+		de.heartBeatTimer = de.c.timers.AfterFunc(d, de.heartbeat)
+	}
 }
 
 // setHeartbeatDisabled sets heartbeatDisabled to the provided value.
@@ -862,8 +960,10 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 
 func (de *endpoint) noteTxActivityExtTriggerLocked(now mono.Time) {
 	de.lastSendExt = now
-	if de.heartBeatTimer == nil && !de.heartbeatDisabled {
-		de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	if de.heartBeatTimer == nil {
+		if d, ok := de.nextHeartbeatLocked(now); ok {
+			de.heartBeatTimer = de.c.timers.AfterFunc(d, de.heartbeat)
+		}
 	}
 }
 
@@ -901,7 +1001,7 @@ func (de *endpoint) discoPing(res *ipnstate.PingResult, size int, cb func(*ipnst
 
 	resCB := &pingResultAndCallback{res: res, cb: cb}
 
-	now := mono.Now()
+	now := de.c.monoNow()
 	udpAddr, derpAddr := de.addrForPingSizeLocked(now, size)
 
 	if derpAddr.IsValid() {
@@ -933,19 +1033,30 @@ func (de *endpoint) send(buffs [][]byte) error {
 		return errExpired
 	}
 
-	now := mono.Now()
+	now := de.c.monoNow()
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
+	de.notePathLocked(udpAddr, derpAddr)
+	if !de.isWireguardOnly {
+		de.noteSendPathForBulkLocked(now, udpAddr, derpAddr, buffs)
+	}
 
+	var paced bool // de's initial discovery is queued by the discoPacer
 	if de.isWireguardOnly {
 		if startWGPing {
 			de.sendWireGuardOnlyPingsLocked(now)
 		}
 	} else if !udpAddr.IsValid() || now.After(de.trustBestAddrUntil) {
-		de.sendDiscoPingsLocked(now, true)
+		if de.c.discoPacer.allowLocked(de) {
+			de.sendDiscoPingsLocked(now, true)
+		} else {
+			paced = true
+		}
 	}
 	de.noteTxActivityExtTriggerLocked(now)
 	de.lastSendAny = now
+	de.lastSendWG = now
 	de.mu.Unlock()
+	de.c.resumeFromLowPower("low-power-tx")
 
 	if !udpAddr.IsValid() && !derpAddr.IsValid() {
 		return errNoUDPOrDERP
@@ -973,6 +1084,9 @@ func (de *endpoint) send(buffs [][]byte) error {
 	if derpAddr.IsValid() {
 		allOk := true
 		for _, buff := range buffs {
+			if paced && !udpAddr.IsValid() && isHandshakeInitiation(buff) && de.c.discoPacer.holdHandshake(de, buff) {
+				continue
+			}
 			ok, _ := de.c.sendAddr(derpAddr, de.publicKey, buff)
 			if stats := de.c.stats.Load(); stats != nil {
 				stats.UpdateTxPhysical(de.nodeAddr, derpAddr, len(buff))
@@ -1031,7 +1145,7 @@ func (de *endpoint) discoPingTimeout(txid stun.TxID) {
 	if !ok {
 		return
 	}
-	if debugDisco() || !de.bestAddr.IsValid() || mono.Now().After(de.trustBestAddrUntil) {
+	if debugDisco() || !de.bestAddr.IsValid() || de.c.monoNow().After(de.trustBestAddrUntil) {
 		de.c.dlogf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort())
 	}
 	de.removeSentDiscoPingLocked(txid, sp, discoPingTimedOut)
@@ -1176,7 +1290,7 @@ func (de *endpoint) startDiscoPingLocked(ep netip.AddrPort, now mono.Time, purpo
 		de.sentPing[txid] = sentPing{
 			to:      ep,
 			at:      now,
-			timer:   time.AfterFunc(pingTimeoutDuration, func() { de.discoPingTimeout(txid) }),
+			timer:   de.c.timers.AfterFunc(pingTimeoutDuration, func() { de.discoPingTimeout(txid) }),
 			purpose: purpose,
 			resCB:   resCB,
 			size:    s,
@@ -1189,19 +1303,40 @@ func (de *endpoint) startDiscoPingLocked(ep netip.AddrPort, now mono.Time, purpo
 
 }
 
+// startPacedDiscovery starts the initial discovery of de, which was deferred
+// by discoPacer, unless it's no longer needed.
+func (de *endpoint) startPacedDiscovery() {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.expired || de.isWireguardOnly || !de.lastFullPing.IsZero() {
+		return
+	}
+	now := de.c.monoNow()
+	if de.bestAddr.IsValid() && now.Before(de.trustBestAddrUntil) {
+		return
+	}
+	de.sendDiscoPingsLocked(now, true)
+}
+
 // sendDiscoPingsLocked starts pinging all of ep's endpoints.
 func (de *endpoint) sendDiscoPingsLocked(now mono.Time, sendCallMeMaybe bool) {
 	de.lastFullPing = now
+	udpBlocked := de.c.udpBlocked.Load()
 	var sentAny bool
 	for ep, st := range de.endpointState {
-		if st.shouldDeleteLocked() {
+		if st.shouldDeleteLocked(de.c.clock.Now()) {
 			de.deleteEndpointLocked("sendPingsLocked", ep)
 			continue
 		}
 		if runtime.GOOS == "js" {
 			continue
 		}
-		if !st.lastPing.IsZero() && now.Sub(st.lastPing) < discoPingInterval {
+		if udpBlocked && !isLANAddr(ep.Addr()) {
+			// Pings over UDP across the internet would go unanswered,
+			// but a peer on the same LAN may still be reachable.
+			continue
+		}
+		if !st.lastPing.IsZero() && now.Sub(st.lastPing) < de.discoPingIntervalLocked() {
 			continue
 		}
 
@@ -1225,6 +1360,12 @@ func (de *endpoint) sendDiscoPingsLocked(now mono.Time, sendCallMeMaybe bool) {
 	}
 }
 
+// isLANAddr reports whether ip is a private or link-local address, such as
+// a peer on the same LAN might have.
+func isLANAddr(ip netip.Addr) bool {
+	return ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
 // sendWireGuardOnlyPingsLocked evaluates all available addresses for
 // a WireGuard only endpoint and initates an ICMP ping for useable
 // addresses.
@@ -1249,15 +1390,22 @@ func (de *endpoint) sendWireGuardOnlyPingsLocked(now mono.Time) {
 		if ipp.Addr().Is6() && de.c.noV6.Load() {
 			continue
 		}
+		if de.c.addrFamilyDisabled(ipp.Addr()) {
+			continue
+		}
 
 		go de.sendWireGuardOnlyPing(ipp, now)
 	}
 }
 
+// wireguardOnlyPingTimeout is how long sendWireGuardOnlyPing waits for an
+// ICMP echo reply.
+const wireguardOnlyPingTimeout = 5 * time.Second
+
 // sendWireGuardOnlyPing sends a ICMP ping to a WireGuard only address to
 // discover the latency.
 func (de *endpoint) sendWireGuardOnlyPing(ipp netip.AddrPort, now mono.Time) {
-	ctx, cancel := context.WithTimeout(de.c.connCtx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(de.c.connCtx, wireguardOnlyPingTimeout)
 	defer cancel()
 
 	de.setLastPing(ipp, now)
@@ -1321,6 +1469,12 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 		de.setProbeUDPLifetimeConfigLocked(nil)
 	}
 	de.expired = n.Expired()
+	switch {
+	case n.Online() != nil && *n.Online():
+		de.lastSeenFromControl = de.c.clock.Now()
+	case n.LastSeen() != nil:
+		de.lastSeenFromControl = *n.LastSeen()
+	}
 
 	epDisco := de.disco.Load()
 	var discoKey key.DiscoPublic
@@ -1335,7 +1489,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 			short: n.DiscoKey().ShortString(),
 		})
 		de.debugUpdates.Add(EndpointChange{
-			When: time.Now(),
+			When: de.c.clock.Now(),
 			What: "updateFromNode-resetLocked",
 		})
 		de.resetLocked()
@@ -1343,7 +1497,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 	if n.DERP() == "" {
 		if de.derpAddr.IsValid() {
 			de.debugUpdates.Add(EndpointChange{
-				When: time.Now(),
+				When: de.c.clock.Now(),
 				What: "updateFromNode-remove-DERP",
 				From: de.derpAddr,
 			})
@@ -1353,11 +1507,12 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 		newDerp, _ := netip.ParseAddrPort(n.DERP())
 		if de.derpAddr != newDerp {
 			de.debugUpdates.Add(EndpointChange{
-				When: time.Now(),
+				When: de.c.clock.Now(),
 				What: "updateFromNode-DERP",
 				From: de.derpAddr,
 				To:   newDerp,
 			})
+			de.pathWhy = "DERP home changed"
 		}
 		de.derpAddr = newDerp
 	}
@@ -1384,6 +1539,9 @@ func (de *endpoint) setEndpointsLocked(eps interface {
 			de.c.logf("magicsock: bogus netmap endpoint from %v", eps)
 			continue
 		}
+		if de.c.addrFamilyDisabled(ipp.Addr()) {
+			continue
+		}
 		if st, ok := de.endpointState[ipp]; ok {
 			st.index = int16(i)
 		} else {
@@ -1393,7 +1551,7 @@ func (de *endpoint) setEndpointsLocked(eps interface {
 	}
 	if len(newIpps) > 0 {
 		de.debugUpdates.Add(EndpointChange{
-			When: time.Now(),
+			When: de.c.clock.Now(),
 			What: "updateFromNode-new-Endpoints",
 			To:   newIpps,
 		})
@@ -1402,7 +1560,7 @@ func (de *endpoint) setEndpointsLocked(eps interface {
 	// Now delete anything unless it's still in the network map or
 	// was a recently discovered endpoint.
 	for ep, st := range de.endpointState {
-		if st.shouldDeleteLocked() {
+		if st.shouldDeleteLocked(de.c.clock.Now()) {
 			de.deleteEndpointLocked("updateFromNode", ep)
 		}
 	}
@@ -1428,21 +1586,21 @@ func (de *endpoint) addCandidateEndpoint(ep netip.AddrPort, forRxPingTxID stun.T
 			// Already-known endpoint from the network map.
 			return duplicatePing
 		}
-		st.lastGotPing = time.Now()
+		st.lastGotPing = de.c.clock.Now()
 		return duplicatePing
 	}
 
 	// Newly discovered endpoint. Exciting!
 	de.c.dlogf("[v1] magicsock: disco: adding %v as candidate endpoint for %v (%s)", ep, de.discoShort(), de.publicKey.ShortString())
 	de.endpointState[ep] = &endpointState{
-		lastGotPing:     time.Now(),
+		lastGotPing:     de.c.clock.Now(),
 		lastGotPingTxID: forRxPingTxID,
 	}
 
 	// If for some reason this gets very large, do some cleanup.
 	if size := len(de.endpointState); size > 100 {
 		for ep, st := range de.endpointState {
-			if st.shouldDeleteLocked() {
+			if st.shouldDeleteLocked(de.c.clock.Now()) {
 				de.deleteEndpointLocked("addCandidateEndpoint", ep)
 			}
 		}
@@ -1452,11 +1610,99 @@ func (de *endpoint) addCandidateEndpoint(ep netip.AddrPort, forRxPingTxID stun.T
 	return false
 }
 
+// isVerifiedAddrLocked reports whether de is known to be reachable at ipp,
+// because it has answered a disco ping we sent there.
+//
+// de.mu must be held.
+func (de *endpoint) isVerifiedAddrLocked(ipp netip.AddrPort) bool {
+	if de.bestAddr.AddrPort == ipp {
+		return true
+	}
+	st, ok := de.endpointState[ipp]
+	return ok && len(st.recentPongs) > 0
+}
+
+// hasPingInFlightLocked reports whether de has an unanswered disco ping
+// outstanding to ipp.
+//
+// de.mu must be held.
+func (de *endpoint) hasPingInFlightLocked(ipp netip.AddrPort) bool {
+	for _, sp := range de.sentPing {
+		if sp.to == ipp {
+			return true
+		}
+	}
+	return false
+}
+
+// isVerifiedAddr reports whether de is known to be reachable at ipp.
+// See isVerifiedAddrLocked.
+func (de *endpoint) isVerifiedAddr(ipp netip.AddrPort) bool {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	return de.isVerifiedAddrLocked(ipp)
+}
+
+// challengeAddr starts a disco ping to ipp, a source address de was seen
+// sending from, unless ipp is already verified or a ping to it is already
+// in flight. Only a pong to such a ping makes us attribute packets from
+// ipp to de (see handlePongConnLocked), so that an off-path attacker
+// replaying de's disco messages from a spoofed address can't steer de's
+// traffic.
+func (de *endpoint) challengeAddr(ipp netip.AddrPort) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.isVerifiedAddrLocked(ipp) || de.hasPingInFlightLocked(ipp) {
+		return
+	}
+	if _, ok := de.endpointState[ipp]; !ok {
+		return
+	}
+	de.c.dlogf("[v1] magicsock: disco: challenging unverified source %v of %v (%s)", ipp, de.discoShort(), de.publicKey.ShortString())
+	de.startDiscoPingLocked(ipp, de.c.monoNow(), pingDiscovery, 0, nil)
+}
+
+// PathVerification is the verification state of one UDP path to a peer.
+type PathVerification struct {
+	Addr netip.AddrPort
+
+	// Verified is whether the peer has answered a disco ping sent to
+	// Addr. Packets from Addr are only attributed to the peer once
+	// it's verified.
+	Verified bool
+
+	// Pending is whether a disco ping to Addr is awaiting a pong.
+	Pending bool
+
+	// Learned is whether Addr was learned from an incoming disco ping
+	// rather than from the network map or a call-me-maybe.
+	Learned bool
+}
+
+// pathVerifications returns the verification state of each of de's known
+// UDP paths, sorted by address.
+func (de *endpoint) pathVerifications() []PathVerification {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	ret := make([]PathVerification, 0, len(de.endpointState))
+	for ipp, st := range de.endpointState {
+		ret = append(ret, PathVerification{
+			Addr:     ipp,
+			Verified: de.isVerifiedAddrLocked(ipp),
+			Pending:  de.hasPingInFlightLocked(ipp),
+			Learned:  !st.lastGotPing.IsZero(),
+		})
+	}
+	slices.SortFunc(ret, func(a, b PathVerification) int { return a.Addr.Compare(b.Addr) })
+	return ret
+}
+
 // clearBestAddrLocked clears the bestAddr and related fields such that future
 // packets will re-evaluate the best address to send to next.
 //
 // de.mu must be held.
-func (de *endpoint) clearBestAddrLocked() {
+func (de *endpoint) clearBestAddrLocked(why string) {
+	de.pathWhy = why
 	de.setBestAddrLocked(addrQuality{})
 	de.bestAddrAt = 0
 	de.trustBestAddrUntil = 0
@@ -1469,7 +1715,7 @@ func (de *endpoint) noteBadEndpoint(ipp netip.AddrPort) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("send error")
 
 	if st, ok := de.endpointState[ipp]; ok {
 		st.clear()
@@ -1483,7 +1729,7 @@ func (de *endpoint) noteConnectivityChange() {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("network change")
 
 	for k := range de.endpointState {
 		de.endpointState[k].clear()
@@ -1552,7 +1798,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 		}
 	}
 
-	now := mono.Now()
+	now := de.c.monoNow()
 	latency := now.Sub(sp.at)
 
 	if !isDerp {
@@ -1595,7 +1841,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 		if betterAddr(thisPong, de.bestAddr) {
 			de.c.logf("magicsock: disco: node %v %v now using %v mtu=%v tx=%x", de.publicKey.ShortString(), de.discoShort(), sp.to, thisPong.wireMTU, m.TxID[:6])
 			de.debugUpdates.Add(EndpointChange{
-				When: time.Now(),
+				When: de.c.clock.Now(),
 				What: "handlePingLocked-bestAddr-update",
 				From: de.bestAddr,
 				To:   thisPong,
@@ -1603,8 +1849,9 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			de.setBestAddrLocked(thisPong)
 		}
 		if de.bestAddr.AddrPort == thisPong.AddrPort {
+			de.pathWhy = "pong"
 			de.debugUpdates.Add(EndpointChange{
-				When: time.Now(),
+				When: de.c.clock.Now(),
 				What: "handlePingLocked-bestAddr-latency",
 				From: de.bestAddr,
 				To:   thisPong,
@@ -1719,7 +1966,7 @@ func (de *endpoint) handleCallMeMaybe(m *disco.CallMeMaybe) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	now := time.Now()
+	now := de.c.clock.Now()
 	for ep := range de.isCallMeMaybeEP {
 		de.isCallMeMaybeEP[ep] = false // mark for deletion
 	}
@@ -1731,6 +1978,9 @@ func (de *endpoint) handleCallMeMaybe(m *disco.CallMeMaybe) {
 			// for these.
 			continue
 		}
+		if de.c.addrFamilyDisabled(ep.Addr()) {
+			continue
+		}
 		mak.Set(&de.isCallMeMaybeEP, ep, true)
 		if es, ok := de.endpointState[ep]; ok {
 			es.callMeMaybeTime = now
@@ -1741,7 +1991,7 @@ func (de *endpoint) handleCallMeMaybe(m *disco.CallMeMaybe) {
 	}
 	if len(newEPs) > 0 {
 		de.debugUpdates.Add(EndpointChange{
-			When: time.Now(),
+			When: de.c.clock.Now(),
 			What: "handleCallMeMaybe-new-endpoints",
 			To:   newEPs,
 		})
@@ -1772,7 +2022,7 @@ func (de *endpoint) handleCallMeMaybe(m *disco.CallMeMaybe) {
 	for _, st := range de.endpointState {
 		st.lastPing = 0
 	}
-	de.sendDiscoPingsLocked(mono.Now(), false)
+	de.sendDiscoPingsLocked(de.c.monoNow(), false)
 }
 
 func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
@@ -1785,7 +2035,7 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 		return
 	}
 
-	now := mono.Now()
+	now := de.c.monoNow()
 	ps.LastWrite = de.lastSendExt.WallTime()
 	ps.Active = now.Sub(de.lastSendExt) < sessionActiveTimeout
 
@@ -1812,7 +2062,7 @@ func (de *endpoint) stopAndReset() {
 	}
 
 	de.debugUpdates.Add(EndpointChange{
-		When: time.Now(),
+		When: de.c.clock.Now(),
 		What: "stopAndReset-resetLocked",
 	})
 	de.resetLocked()
@@ -1820,6 +2070,7 @@ func (de *endpoint) stopAndReset() {
 		de.heartBeatTimer.Stop()
 		de.heartBeatTimer = nil
 	}
+	de.c.discoPacer.forget(de)
 }
 
 // resetLocked clears all the endpoint's p2p state, reverting it to a
@@ -1828,7 +2079,7 @@ func (de *endpoint) stopAndReset() {
 func (de *endpoint) resetLocked() {
 	de.lastSendExt = 0
 	de.lastFullPing = 0
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("reset")
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
@@ -1848,4 +2099,5 @@ func (de *endpoint) setDERPHome(regionID uint16) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 	de.derpAddr = netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID))
+	de.pathWhy = "DERP home changed"
 }
@@ -9,6 +9,8 @@
 	"time"
 
 	"github.com/dsnet/try"
+	"tailscale.com/tstime"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 )
 
@@ -302,6 +304,7 @@ func(lifetime *probeUDPLifetime) time.Duration {
 			de := &endpoint{
 				c: &Conn{
 					discoPublic: tt.localDisco,
+					clock:       tstime.StdClock{},
 				},
 				bestAddr: tt.bestAddr,
 			}
@@ -324,3 +327,103 @@ func(lifetime *probeUDPLifetime) time.Duration {
 		})
 	}
 }
+
+func Test_endpoint_notePathLocked(t *testing.T) {
+	de := &endpoint{c: &Conn{clock: tstime.StdClock{}}}
+	udp := netip.MustParseAddrPort("1.2.3.4:41641")
+	derp := netip.MustParseAddrPort("127.3.3.40:5")
+
+	de.notePathLocked(netip.AddrPort{}, derp)
+	de.notePathLocked(netip.AddrPort{}, derp)
+	de.pathWhy = "pong"
+	de.notePathLocked(udp, netip.AddrPort{})
+	de.notePathLocked(udp, derp)
+
+	want := []PathChange{
+		{To: "DERP 5"},
+		{From: "DERP 5", To: "direct 1.2.3.4:41641", Why: "pong"},
+		{From: "direct 1.2.3.4:41641", To: "direct 1.2.3.4:41641 + DERP 5", Why: "direct path unconfirmed"},
+	}
+	if len(de.pathHistory) != len(want) {
+		t.Fatalf("got %d path changes; want %d: %+v", len(de.pathHistory), len(want), de.pathHistory)
+	}
+	for i, got := range de.pathHistory {
+		got.When = time.Time{}
+		if got != want[i] {
+			t.Errorf("change %d = %+v; want %+v", i, got, want[i])
+		}
+	}
+
+	for range pathHistorySize {
+		de.notePathLocked(netip.AddrPort{}, derp)
+		de.notePathLocked(udp, netip.AddrPort{})
+	}
+	if len(de.pathHistory) != pathHistorySize {
+		t.Errorf("history has %d changes; want at most %d", len(de.pathHistory), pathHistorySize)
+	}
+}
+
+func TestDERPBulkState(t *testing.T) {
+	var s derpBulkState
+	now := mono.Time(1e9)
+	step := derpBulkWindow / 10
+	perStep := derpBulkMinRate / 10
+
+	// Traffic below the threshold doesn't make discovery aggressive.
+	for range 20 {
+		if s.noteDERPSend(now, perStep/2) {
+			t.Fatal("started at half the minimum rate")
+		}
+		now = now.Add(step)
+	}
+
+	// Traffic above it does, within a window or two.
+	started := false
+	for range 20 {
+		if s.noteDERPSend(now, 2*perStep) {
+			started = true
+			break
+		}
+		now = now.Add(step)
+	}
+	if !started || !s.active() {
+		t.Fatal("didn't start at twice the minimum rate")
+	}
+
+	// Without a direct path, it gives up and backs off.
+	now = now.Add(derpBulkMaxDuration)
+	s.noteDERPSend(now, 2*perStep)
+	if s.active() {
+		t.Fatal("still active after derpBulkMaxDuration")
+	}
+	if s.backoff != derpBulkMinBackoff {
+		t.Errorf("backoff = %v; want %v", s.backoff, derpBulkMinBackoff)
+	}
+	for range 20 {
+		now = now.Add(step)
+		if s.noteDERPSend(now, 2*perStep) {
+			t.Fatal("started again during the backoff")
+		}
+	}
+
+	// After the backoff, it starts again, and gives up with a longer one.
+	now = now.Add(derpBulkMinBackoff)
+	for i := 0; !s.active(); i++ {
+		if i > 20 {
+			t.Fatal("didn't start again after the backoff")
+		}
+		s.noteDERPSend(now, 2*perStep)
+		now = now.Add(step)
+	}
+	now = now.Add(derpBulkMaxDuration)
+	s.noteDERPSend(now, 0)
+	if s.backoff != 2*derpBulkMinBackoff {
+		t.Errorf("backoff = %v; want %v", s.backoff, 2*derpBulkMinBackoff)
+	}
+
+	// A direct path resets everything.
+	s.noteDirect()
+	if s != (derpBulkState{}) {
+		t.Errorf("state after noteDirect = %+v; want zero", s)
+	}
+}
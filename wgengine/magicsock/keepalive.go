@@ -0,0 +1,70 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// minPeerHeartbeatInterval is the shortest heartbeat interval that
+// SetPeerHeartbeatInterval accepts.
+const minPeerHeartbeatInterval = timerWheelResolution
+
+// SetPeerHeartbeatInterval sets how often disco heartbeats are sent to
+// peer's best UDP path while a session with it is active, replacing any
+// interval set before. Zero restores the default; a negative interval
+// disables heartbeats to peer, which saves power on battery-powered
+// devices at the cost of noticing path changes later.
+//
+// It isn't persisted, but outlives the peer's removal from the netmap.
+func (c *Conn) SetPeerHeartbeatInterval(peer key.NodePublic, d time.Duration) error {
+	if d > 0 && d < minPeerHeartbeatInterval {
+		return errors.New("heartbeat interval must be at least " + minPeerHeartbeatInterval.String())
+	}
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if d == 0 {
+		delete(c.heartbeatInterval, peer)
+	} else {
+		mak.Set(&c.heartbeatInterval, peer, d)
+	}
+	c.logf("magicsock: heartbeat interval for peer %v set to %v", peer.ShortString(), d)
+	return nil
+}
+
+// PeerHeartbeatInterval returns the heartbeat interval set for peer by
+// SetPeerHeartbeatInterval, or zero if it uses the default.
+func (c *Conn) PeerHeartbeatInterval(peer key.NodePublic) time.Duration {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	return c.heartbeatInterval[peer]
+}
+
+// nextHeartbeatLocked returns how long after now de's next heartbeat is
+// due, or false if de shouldn't send heartbeats.
+//
+// Heartbeats are aligned to multiples of their interval on the monotonic
+// clock, so that the heartbeats of all peers with the same interval fire
+// together. An idle node with many active sessions then wakes up once per
+// interval, not once per peer.
+//
+// de.mu must be held.
+func (de *endpoint) nextHeartbeatLocked(now mono.Time) (time.Duration, bool) {
+	if de.heartbeatDisabled {
+		return 0, false
+	}
+	d := de.c.PeerHeartbeatInterval(de.publicKey)
+	if d < 0 {
+		return 0, false
+	}
+	if d == 0 {
+		d = heartbeatInterval
+	}
+	return d - time.Duration(now)%d, true
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"errors"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// minLowPowerIdle is the shortest IdleAfter that SetLowPower accepts, so
+// that low power mode doesn't get in the way of active sessions.
+const minLowPowerIdle = sessionActiveTimeout
+
+// SetLowPower configures low power mode, in which c stops its background
+// activity (periodic STUN and netcheck, disco heartbeats and UDP lifetime
+// probes) while the device is on battery and no packets have passed
+// through the tunnel for lp.IdleAfter. It's resumed as soon as packets are
+// sent, the network changes enough for c to Rebind, or the device is no
+// longer on battery.
+//
+// The zero IdleAfter turns low power mode off. lp.Active is ignored.
+func (c *Conn) SetLowPower(lp ipnstate.LowPower) error {
+	if lp.IdleAfter < 0 || (lp.IdleAfter > 0 && lp.IdleAfter < minLowPowerIdle) {
+		return errors.New("low power idle time must be zero or at least " + minLowPowerIdle.String())
+	}
+	c.lowPowerIdleAfter.Store(lp.IdleAfter)
+	c.onBattery.Store(lp.OnBattery)
+	c.logf("magicsock: low power mode: idle after %v, on battery %v", lp.IdleAfter, lp.OnBattery)
+	if !c.wantLowPower() {
+		c.resumeFromLowPower("low-power-config")
+	}
+	return nil
+}
+
+// LowPower returns the low power mode configuration and whether c has
+// currently stopped its background activity because of it.
+func (c *Conn) LowPower() ipnstate.LowPower {
+	return ipnstate.LowPower{
+		IdleAfter: c.lowPowerIdleAfter.Load(),
+		OnBattery: c.onBattery.Load(),
+		Active:    c.lowPowerSuspended.Load(),
+	}
+}
+
+// wantLowPower reports whether low power mode is on, the device is on
+// battery, and the tunnel has been idle long enough for c to stop its
+// background activity.
+func (c *Conn) wantLowPower() bool {
+	idleAfter := c.lowPowerIdleAfter.Load()
+	if idleAfter == 0 || !c.onBattery.Load() || c.idleFunc == nil {
+		return false
+	}
+	return c.idleFunc() >= idleAfter
+}
+
+// lowPowerIdle is like wantLowPower, but also records that background
+// activity was stopped, for resumeFromLowPower.
+func (c *Conn) lowPowerIdle() bool {
+	if !c.wantLowPower() {
+		return false
+	}
+	if !c.lowPowerSuspended.Swap(true) {
+		metricLowPowerSuspended.Add(1)
+		c.logf("magicsock: low power mode: idle on battery; pausing background activity")
+	}
+	return true
+}
+
+// resumeFromLowPower restarts the background activity stopped by low power
+// mode, if any. It's cheap enough to call for each packet sent.
+func (c *Conn) resumeFromLowPower(why string) {
+	if !c.lowPowerSuspended.Load() || !c.lowPowerSuspended.CompareAndSwap(true, false) {
+		return
+	}
+	metricLowPowerResumed.Add(1)
+	c.logf("magicsock: low power mode: resuming background activity (%s)", why)
+	go c.ReSTUN(why)
+}
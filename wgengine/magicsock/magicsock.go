@@ -58,6 +58,7 @@
 	"tailscale.com/util/mak"
 	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
+	"tailscale.com/util/supervisor"
 	"tailscale.com/util/testenv"
 	"tailscale.com/util/uniq"
 	"tailscale.com/wgengine/capture"
@@ -87,12 +88,32 @@ type Conn struct {
 	logf                   logger.Logf
 	epFunc                 func([]tailcfg.Endpoint)
 	derpActiveFunc         func()
+	derpHomeFunc           func(regionID int)
 	idleFunc               func() time.Duration // nil means unknown
 	testOnlyPacketListener nettype.PacketListener
-	noteRecvActivity       func(key.NodePublic) // or nil, see Options.NoteRecvActivity
-	netMon                 *netmon.Monitor      // must be non-nil
-	health                 *health.Tracker      // or nil
-	controlKnobs           *controlknobs.Knobs  // or nil
+	noteRecvActivity       func(key.NodePublic)   // or nil, see Options.NoteRecvActivity
+	netMon                 *netmon.Monitor        // must be non-nil
+	health                 *health.Tracker        // or nil
+	supervisor             *supervisor.Supervisor // or nil; recovers from netcheck and portmapper panics
+	controlKnobs           *controlknobs.Knobs    // or nil
+	clock                  tstime.Clock           // never nil; see Options.Clock
+
+	// clockStart and monoStart are the times, according to clock and
+	// package mono respectively, at which a non-default clock was
+	// installed. They let monoNow follow clock. monoStart is zero when
+	// the real clock is in use.
+	clockStart time.Time
+	monoStart  mono.Time
+
+	// timers runs the periodic timers that tolerate firing up to
+	// timerWheelResolution late (heartbeats, disco ping timeouts, re-STUNs,
+	// etc), coalescing them so that an idle Conn with many peers wakes up
+	// a bounded number of times. It runs on clock.
+	timers *tstime.Wheel
+
+	// discoPacer paces the initial discovery of peers after a network
+	// map adds many of them at once.
+	discoPacer *discoPacer
 
 	// ================================================================
 	// No locking required to access these fields, either because
@@ -109,6 +130,12 @@ type Conn struct {
 	pconn4 RebindingUDPConn
 	pconn6 RebindingUDPConn
 
+	// disableV4 and disableV6 are whether IPv4 and IPv6 are disabled
+	// entirely, per Options.DisableIPv4 and Options.DisableIPv6. A
+	// disabled family's socket is never bound, and its addresses are
+	// neither advertised nor used for peers.
+	disableV4, disableV6 bool
+
 	receiveBatchPool sync.Pool
 
 	// closeDisco4 and closeDisco6 are io.Closers to shut down the raw
@@ -121,6 +148,23 @@ type Conn struct {
 	// conditions, including the closest DERP relay and NAT mappings.
 	netChecker *netcheck.Client
 
+	// derpHistory records the reliability of DERP regions for
+	// netChecker's choice of home region.
+	derpHistory netcheck.RegionHistory
+
+	// derpHome is the state used to decide when to move the home DERP
+	// region. It's guarded by mu.
+	derpHome derpHomeState
+
+	// blockedPeers are the node keys of the peers that the node blocked
+	// locally, with which no disco messages are exchanged. It's guarded
+	// by mu.
+	blockedPeers set.Set[key.NodePublic]
+
+	// appMsgHandlers are the handlers of application messages from
+	// peers, keyed by topic. It's guarded by mu.
+	appMsgHandlers map[string]AppMessageHandler
+
 	// portMapper is the NAT-PMP/PCP/UPnP prober/client, for requesting
 	// port mappings from NAT devices.
 	portMapper *portmapper.Client
@@ -205,13 +249,41 @@ type Conn struct {
 
 	onlyTCP443 atomic.Bool
 
+	// udpBlocked is whether netcheck has found that UDP is blocked on the
+	// current network while DERP is still reachable, so peer traffic
+	// starts out over DERP only, and only LAN paths are discovered. See
+	// noteUDPReachability.
+	udpBlocked atomic.Bool
+
+	// udpBlockedReports is the number of consecutive netcheck reports
+	// that found UDP to be blocked.
+	udpBlockedReports int
+
+	// derpPlaintextFallback is whether DERP connections may fall back to
+	// unencrypted HTTP; see SetDERPPlaintextFallback.
+	derpPlaintextFallback atomic.Bool
+
+	// chaosActive is whether chaos has any entries, so that Send can
+	// skip looking up a peer's PeerChaos in the common case.
+	chaosActive atomic.Bool
+	chaosMu     sync.Mutex
+	chaos       map[key.NodePublic]PeerChaos // set by SetPeerChaos
+
+	heartbeatMu       sync.Mutex
+	heartbeatInterval map[key.NodePublic]time.Duration // set by SetPeerHeartbeatInterval
+
+	// Low power mode; see lowpower.go.
+	lowPowerIdleAfter syncs.AtomicValue[time.Duration] // zero means off
+	onBattery         atomic.Bool
+	lowPowerSuspended atomic.Bool // whether background activity was stopped
+
 	closed  bool        // Close was called
 	closing atomic.Bool // Close is in progress (or done)
 
 	// derpCleanupTimer is the timer that fires to occasionally clean
 	// up idle DERP connections. It's only used when there is a non-home
 	// DERP connection in use.
-	derpCleanupTimer *time.Timer
+	derpCleanupTimer tstime.TimerController
 
 	// derpCleanupTimerArmed is whether derpCleanupTimer is
 	// scheduled to fire within derpCleanStaleInterval.
@@ -219,7 +291,11 @@ type Conn struct {
 
 	// periodicReSTUNTimer, when non-nil, is an AfterFunc timer
 	// that will call Conn.doPeriodicSTUN.
-	periodicReSTUNTimer *time.Timer
+	periodicReSTUNTimer tstime.TimerController
+
+	// preferredPortRetryTimer, when non-nil, is an AfterFunc timer that
+	// will call Conn.retryPreferredPort.
+	preferredPortRetryTimer tstime.TimerController
 
 	// endpointsUpdateActive indicates that updateEndpoints is
 	// currently running. It's used to deduplicate concurrent endpoint
@@ -279,6 +355,7 @@ type Conn struct {
 	peers            views.Slice[tailcfg.NodeView] // from last SetNetworkMap update
 	lastFlags        debugFlags                    // at time of last SetNetworkMap
 	firstAddrForTest netip.Addr                    // from last SetNetworkMap update; for tests only
+	numLegacyPeers   int                           // peers without disco keys in the last SetNetworkMap update
 	privateKey       key.NodePrivate               // WireGuard private key for this node
 	everHadKey       bool                          // whether we ever had a non-zero private key
 	myDerp           int                           // nearest DERP region ID; 0 means none/unknown
@@ -358,6 +435,12 @@ type Options struct {
 	// a connection is made to a DERP server.
 	DERPActiveFunc func()
 
+	// DERPHomeFunc optionally provides a func to be called when the home
+	// DERP region changes. A regionID of 0 means there's no home DERP
+	// region. It's called with the Conn's lock held, in the order of the
+	// changes, so it must not block or call back into the Conn.
+	DERPHomeFunc func(regionID int)
+
 	// IdleFunc optionally provides a func to return how long
 	// it's been since a TUN packet was sent or received.
 	IdleFunc func() time.Duration
@@ -402,6 +485,21 @@ type Options struct {
 	// DisablePortMapper, if true, disables the portmapper.
 	// This is primarily useful in tests.
 	DisablePortMapper bool
+
+	// DisableIPv4 and DisableIPv6, if true, disable all use of IPv4 or
+	// IPv6 respectively for peer and DERP traffic: no socket is bound
+	// for the family, no endpoints of the family are gathered or used,
+	// and netcheck doesn't probe it. They're for networks where one
+	// family is broken in a way that only causes long timeouts. At
+	// most one may be set.
+	DisableIPv4, DisableIPv6 bool
+
+	// Clock, if non-nil, is the source of time for the Conn's timers
+	// (heartbeats, DERP cleanup, re-STUN), its timestamps (including the
+	// windows during which a peer's best path is trusted), and those of
+	// its netcheck and DERP clients. It's for tests that want to advance
+	// time deterministically. If nil, the real clock is used.
+	Clock tstime.Clock
 }
 
 func (o *Options) logf() logger.Logf {
@@ -418,6 +516,13 @@ func (o *Options) endpointsFunc() func([]tailcfg.Endpoint) {
 	return o.EndpointsFunc
 }
 
+func (o *Options) derpHomeFunc() func(int) {
+	if o == nil || o.DERPHomeFunc == nil {
+		return func(int) {}
+	}
+	return o.DERPHomeFunc
+}
+
 func (o *Options) derpActiveFunc() func() {
 	if o == nil || o.DERPActiveFunc == nil {
 		return func() {}
@@ -439,7 +544,10 @@ func newConn(logf logger.Logf) *Conn {
 		discoPrivate: discoPrivate,
 		discoPublic:  discoPrivate.Public(),
 		cloudInfo:    newCloudInfo(logf),
+		clock:        tstime.StdClock{},
 	}
+	c.timers = tstime.NewWheel(c.clock, timerWheelResolution)
+	c.discoPacer = newDiscoPacer(c)
 	c.discoShort = c.discoPublic.ShortString()
 	c.bind = &connBind{Conn: c, closed: true}
 	c.receiveBatchPool = sync.Pool{New: func() any {
@@ -458,6 +566,23 @@ func newConn(logf logger.Logf) *Conn {
 	return c
 }
 
+// setClock makes c use clock instead of the real clock. It must be called
+// before c is used.
+func (c *Conn) setClock(clock tstime.Clock) {
+	c.clock = clock
+	c.timers = tstime.NewWheel(clock, timerWheelResolution)
+	c.clockStart = clock.Now()
+	c.monoStart = mono.Now()
+}
+
+// monoNow returns the current monotonic time according to c.clock.
+func (c *Conn) monoNow() mono.Time {
+	if c.monoStart == 0 {
+		return mono.Now()
+	}
+	return c.monoStart.Add(c.clock.Since(c.clockStart))
+}
+
 // NewConn creates a magic Conn listening on opts.Port.
 // As the set of possible endpoints for a Conn changes, the
 // callback opts.EndpointsFunc is called.
@@ -465,22 +590,35 @@ func NewConn(opts Options) (*Conn, error) {
 	if opts.NetMon == nil {
 		return nil, errors.New("magicsock.Options.NetMon must be non-nil")
 	}
+	if opts.DisableIPv4 && opts.DisableIPv6 {
+		return nil, errors.New("magicsock.Options.DisableIPv4 and DisableIPv6 are mutually exclusive")
+	}
 
 	c := newConn(opts.logf())
 	c.port.Store(uint32(opts.Port))
 	c.controlKnobs = opts.ControlKnobs
 	c.epFunc = opts.endpointsFunc()
 	c.derpActiveFunc = opts.derpActiveFunc()
+	c.derpHomeFunc = opts.derpHomeFunc()
 	c.idleFunc = opts.IdleFunc
 	c.testOnlyPacketListener = opts.TestOnlyPacketListener
 	c.noteRecvActivity = opts.NoteRecvActivity
+	c.disableV4 = opts.DisableIPv4
+	c.disableV6 = opts.DisableIPv6
+	if opts.Clock != nil {
+		c.setClock(opts.Clock)
+	}
+	// The port mapper only maps IPv4 ports, so it's only disabled along
+	// with IPv4; with IPv6 disabled, it keeps working as usual.
 	portMapOpts := &portmapper.DebugKnobs{
-		DisableAll: func() bool { return opts.DisablePortMapper || c.onlyTCP443.Load() },
+		DisableAll: func() bool { return opts.DisablePortMapper || opts.DisableIPv4 || c.onlyTCP443.Load() },
 	}
 	c.portMapper = portmapper.NewClient(logger.WithPrefix(c.logf, "portmapper: "), opts.NetMon, portMapOpts, opts.ControlKnobs, c.onPortMapChanged)
 	c.portMapper.SetGatewayLookupFunc(opts.NetMon.GatewayAndSelfIP)
 	c.netMon = opts.NetMon
 	c.health = opts.HealthTracker
+	c.supervisor = supervisor.New(c.logf, c.health)
+	c.portMapper.SetSupervisor(c.supervisor)
 	c.onPortUpdate = opts.OnPortUpdate
 	c.getPeerByKey = opts.PeerByKeyFunc
 
@@ -501,21 +639,30 @@ func NewConn(opts Options) (*Conn, error) {
 			return len(b), err
 		},
 		SkipExternalNetwork: inTest(),
+		Clock:               c.clock,
 		PortMapper:          c.portMapper,
 		UseDNSCache:         true,
+		History:             &c.derpHistory,
+		DisableIPv4:         c.disableV4,
+		DisableIPv6:         c.disableV6,
 	}
 
-	if d4, err := c.listenRawDisco("ip4"); err == nil {
-		c.logf("[v1] using BPF disco receiver for IPv4")
-		c.closeDisco4 = d4
-	} else {
-		c.logf("[v1] couldn't create raw v4 disco listener, using regular listener instead: %v", err)
+	// No disco is received over a disabled address family.
+	if !c.disableV4 {
+		if d4, err := c.listenRawDisco("ip4"); err == nil {
+			c.logf("[v1] using BPF disco receiver for IPv4")
+			c.closeDisco4 = d4
+		} else {
+			c.logf("[v1] couldn't create raw v4 disco listener, using regular listener instead: %v", err)
+		}
 	}
-	if d6, err := c.listenRawDisco("ip6"); err == nil {
-		c.logf("[v1] using BPF disco receiver for IPv6")
-		c.closeDisco6 = d6
-	} else {
-		c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
+	if !c.disableV6 {
+		if d6, err := c.listenRawDisco("ip6"); err == nil {
+			c.logf("[v1] using BPF disco receiver for IPv6")
+			c.closeDisco6 = d6
+		} else {
+			c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
+		}
 	}
 
 	c.logf("magicsock: disco key = %v", c.discoShort)
@@ -569,7 +716,7 @@ func (c *Conn) updateEndpoints(why string) {
 					if debugReSTUNStopOnIdle() {
 						c.logf("scheduling periodicSTUN to run in %v", d)
 					}
-					c.periodicReSTUNTimer = time.AfterFunc(d, c.doPeriodicSTUN)
+					c.periodicReSTUNTimer = c.timers.AfterFunc(d, c.doPeriodicSTUN)
 				}
 			} else {
 				if debugReSTUNStopOnIdle() {
@@ -633,7 +780,7 @@ func (c *Conn) setEndpoints(endpoints []tailcfg.Endpoint) (changed bool) {
 		return false
 	}
 
-	c.lastEndpointsTime = time.Now()
+	c.lastEndpointsTime = c.clock.Now()
 	for de, fn := range c.onEndpointRefreshed {
 		go fn()
 		delete(c.onEndpointRefreshed, de)
@@ -679,6 +826,13 @@ func (c *Conn) setNetInfoHavePortMap() {
 	c.callNetInfoCallbackLocked(ni)
 }
 
+// defaultNetcheckTimeout is the maximum time a netcheck run by updateNetInfo
+// takes if the context it's given has no deadline.
+const defaultNetcheckTimeout = 2 * time.Second
+
+// updateNetInfo runs a netcheck and updates the Conn's NetInfo from its
+// report. The netcheck is bounded by ctx, or by defaultNetcheckTimeout if
+// ctx has no deadline.
 func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	c.mu.Lock()
 	dm := c.derpMap
@@ -688,20 +842,29 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 		return new(netcheck.Report), nil
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
-
-	report, err := c.netChecker.GetReport(ctx, dm, &netcheck.GetReportOpts{
-		// Pass information about the last time that we received a
-		// frame from a DERP server to our netchecker to help avoid
-		// flapping the home region while there's still active
-		// communication.
-		//
-		// NOTE(andrew-d): I don't love that we're depending on the
-		// health package here, but I'd rather do that and not store
-		// the exact same state in two different places.
-		GetLastDERPActivity: c.health.GetDERPRegionReceivedTime,
-	})
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultNetcheckTimeout)
+		defer cancel()
+	}
+
+	var report *netcheck.Report
+	var err error
+	if perr := c.supervisor.Do(supervisor.Netcheck, func() {
+		report, err = c.netChecker.GetReport(ctx, dm, &netcheck.GetReportOpts{
+			// Pass information about the last time that we received a
+			// frame from a DERP server to our netchecker to help avoid
+			// flapping the home region while there's still active
+			// communication.
+			//
+			// NOTE(andrew-d): I don't love that we're depending on the
+			// health package here, but I'd rather do that and not store
+			// the exact same state in two different places.
+			GetLastDERPActivity: c.health.GetDERPRegionReceivedTime,
+		})
+	}); perr != nil {
+		return nil, perr
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -710,6 +873,7 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	c.noV4.Store(!report.IPv4)
 	c.noV6.Store(!report.IPv6)
 	c.noV4Send.Store(!report.IPv4CanSend)
+	c.noteUDPReachability(report)
 
 	ni := &tailcfg.NetInfo{
 		DERPLatency:           map[string]float64{},
@@ -807,6 +971,38 @@ func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
 	return mono.Since(saw).Round(time.Second).String()
 }
 
+// LastWireGuardSendToNodeKey returns when wireguard-go last sent packets
+// to the peer with node key nk. Unlike the peer's ipnstate.PeerStatus.LastWrite,
+// it doesn't count disco pings. It reports false if nk is not a known peer or
+// nothing has been sent to it.
+func (c *Conn) LastWireGuardSendToNodeKey(nk key.NodePublic) (_ time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	de, ok := c.peerMap.endpointForNodeKey(nk)
+	if !ok {
+		return time.Time{}, false
+	}
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.lastSendWG.IsZero() {
+		return time.Time{}, false
+	}
+	return de.lastSendWG.WallTime(), true
+}
+
+// PathVerifications returns the verification state of each UDP path
+// known for the peer with node key nk. It reports false if nk is not a
+// known peer.
+func (c *Conn) PathVerifications(nk key.NodePublic) (_ []PathVerification, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ep, ok := c.peerMap.endpointForNodeKey(nk)
+	if !ok {
+		return nil, false
+	}
+	return ep.pathVerifications(), true
+}
+
 // Ping handles a "tailscale ping" CLI query.
 func (c *Conn) Ping(peer tailcfg.NodeView, res *ipnstate.PingResult, size int, cb func(*ipnstate.PingResult)) {
 	c.mu.Lock()
@@ -913,6 +1109,9 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 		if !ipp.IsValid() || (debugOmitLocalAddresses() && et == tailcfg.EndpointLocal) {
 			return
 		}
+		if c.addrFamilyDisabled(ipp.Addr()) {
+			return
+		}
 		if _, ok := already[ipp]; !ok {
 			mak.Set(&already, ipp, et)
 			eps = append(eps, tailcfg.Endpoint{Addr: ipp, Type: et})
@@ -990,13 +1189,17 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 	// endpoints if they do actually time out without being rediscovered.
 	// For now, though, rely on a minor LinkChange event causing this to
 	// re-run.
-	eps = c.endpointTracker.update(time.Now(), eps)
+	eps = c.endpointTracker.update(c.clock.Now(), eps)
 
 	for i := range c.staticEndpoints.Len() {
 		addAddr(c.staticEndpoints.At(i), tailcfg.EndpointExplicitConf)
 	}
 
-	if localAddr := c.pconn4.LocalAddr(); localAddr.IP.IsUnspecified() {
+	localConn := &c.pconn4
+	if c.disableV4 {
+		localConn = &c.pconn6
+	}
+	if localAddr := localConn.LocalAddr(); localAddr.IP.IsUnspecified() {
 		ips, loopback, err := netmon.LocalAddresses()
 		if err != nil {
 			return nil, err
@@ -1066,15 +1269,26 @@ func endpointSetsEqual(x, y []tailcfg.Endpoint) bool {
 	return true
 }
 
-// LocalPort returns the current IPv4 listener's port number.
+// LocalPort returns the current IPv4 listener's port number, or the IPv6
+// listener's if IPv4 is disabled.
 func (c *Conn) LocalPort() uint16 {
 	if runtime.GOOS == "js" {
 		return 12345
 	}
+	if c.disableV4 {
+		return uint16(c.pconn6.LocalAddr().Port)
+	}
 	laddr := c.pconn4.LocalAddr()
 	return uint16(laddr.Port)
 }
 
+// addrFamilyDisabled reports whether ip's address family has been disabled
+// with Options.DisableIPv4 or Options.DisableIPv6.
+func (c *Conn) addrFamilyDisabled(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return (ip.Is4() && c.disableV4) || (ip.Is6() && c.disableV6)
+}
+
 var errNetworkDown = errors.New("magicsock: network down")
 
 func (c *Conn) networkDown() bool { return !c.networkUp.Load() }
@@ -1089,7 +1303,13 @@ func (c *Conn) Send(buffs [][]byte, ep conn.Endpoint) error {
 		metricSendDataNetworkDown.Add(n)
 		return errNetworkDown
 	}
-	return ep.(*endpoint).send(buffs)
+	de := ep.(*endpoint)
+	if c.chaosActive.Load() {
+		if pc := c.PeerChaos(de.publicKey); !pc.IsZero() {
+			return c.sendWithChaos(de, pc, buffs)
+		}
+	}
+	return de.send(buffs)
 }
 
 var errConnClosed = errors.New("Conn closed")
@@ -1157,9 +1377,9 @@ func (c *Conn) maybeRebindOnError(os string, err error) bool {
 		case "darwin":
 			// TODO(charlotte): implement a backoff, so we don't end up in a rebind loop for persistent
 			// EPERMs.
-			if c.lastEPERMRebind.Load().Before(time.Now().Add(-5 * time.Second)) {
+			if c.lastEPERMRebind.Load().Before(c.clock.Now().Add(-5 * time.Second)) {
 				c.logf("magicsock: performing %q", why)
-				c.lastEPERMRebind.Store(time.Now())
+				c.lastEPERMRebind.Store(c.clock.Now())
 				c.Rebind()
 				go c.ReSTUN(why)
 				return true
@@ -1175,7 +1395,7 @@ func (c *Conn) maybeRebindOnError(os string, err error) bool {
 // sendUDP sends UDP packet b to addr.
 // See sendAddr's docs on the return value meanings.
 func (c *Conn) sendUDPStd(addr netip.AddrPort, b []byte) (sent bool, err error) {
-	if c.onlyTCP443.Load() {
+	if c.onlyTCP443.Load() || c.addrFamilyDisabled(addr.Addr()) {
 		return false, nil
 	}
 	switch {
@@ -1355,7 +1575,7 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache)
 		cache.gen = de.numStopAndReset()
 		ep = de
 	}
-	now := mono.Now()
+	now := c.monoNow()
 	ep.lastRecvUDPAny.StoreAtomic(now)
 	ep.noteRecvActivity(ipp, now)
 	if stats := c.stats.Load(); stats != nil {
@@ -1399,6 +1619,10 @@ func (c *Conn) sendDiscoMessage(dst netip.AddrPort, dstKey key.NodePublic, dstDi
 		c.mu.Unlock()
 		return false, errConnClosed
 	}
+	if c.blockedPeers.Contains(dstKey) {
+		c.mu.Unlock()
+		return false, nil
+	}
 	pkt := make([]byte, 0, 512) // TODO: size it correctly? pool? if it matters.
 	pkt = append(pkt, disco.Magic...)
 	pkt = c.discoPublic.AppendTo(pkt)
@@ -1503,13 +1727,17 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 		}
 		return
 	}
+	if c.discoKeyBlockedLocked(sender) {
+		metricRecvDiscoBlockedPeer.Add(1)
+		return
+	}
 
 	isDERP := src.Addr() == tailcfg.DerpMagicIPAddr
 	if !isDERP {
 		// Record receive time for UDP transport packets.
 		pi, ok := c.peerMap.byIPPort[src]
 		if ok {
-			pi.ep.lastRecvUDPAny.StoreAtomic(mono.Now())
+			pi.ep.lastRecvUDPAny.StoreAtomic(c.monoNow())
 		}
 	}
 
@@ -1543,7 +1771,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 	// Emit information about the disco frame into the pcap stream
 	// if a capture hook is installed.
 	if cb := c.captureHook.Load(); cb != nil {
-		cb(capture.PathDisco, time.Now(), disco.ToPCAPFrame(src, derpNodeSrc, payload), packet.CaptureMeta{})
+		cb(capture.PathDisco, c.clock.Now(), disco.ToPCAPFrame(src, derpNodeSrc, payload), packet.CaptureMeta{})
 	}
 
 	dm, err := disco.Parse(payload)
@@ -1609,6 +1837,15 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 			ep.publicKey.ShortString(), derpStr(src.String()),
 			len(dm.MyNumber))
 		go ep.handleCallMeMaybe(dm)
+	case *disco.AppMessage:
+		metricRecvDiscoAppMessage.Add(1)
+		if !isDERP || derpNodeSrc.IsZero() {
+			// Application messages are only sent via DERP, which
+			// authenticates the sender's node key.
+			metricRecvDiscoAppMessageBadNode.Add(1)
+			return
+		}
+		c.handleAppMessageLocked(dm, sender, derpNodeSrc)
 	}
 	return
 }
@@ -1654,9 +1891,9 @@ func (c *Conn) unambiguousNodeKeyOfPingLocked(dm *disco.Ping, dk key.DiscoPublic
 // di is the discoInfo of the source of the ping.
 // derpNodeSrc is non-zero if the ping arrived via DERP.
 func (c *Conn) handlePingLocked(dm *disco.Ping, src netip.AddrPort, di *discoInfo, derpNodeSrc key.NodePublic) {
-	likelyHeartBeat := src == di.lastPingFrom && time.Since(di.lastPingTime) < 5*time.Second
+	likelyHeartBeat := src == di.lastPingFrom && c.clock.Since(di.lastPingTime) < 5*time.Second
 	di.lastPingFrom = src
-	di.lastPingTime = time.Now()
+	di.lastPingTime = c.clock.Now()
 	isDerp := src.Addr() == tailcfg.DerpMagicIPAddr
 
 	// If we can figure out with certainty which node key this disco
@@ -1666,9 +1903,17 @@ func (c *Conn) handlePingLocked(dm *disco.Ping, src netip.AddrPort, di *discoInf
 	// reliant on DERP call-me-maybe to establish the disco<>node
 	// mapping, and on subsequent disco handlePongConnLocked to establish
 	// the IP<>disco mapping.
+	//
+	// Only do so if the peer has already proven it's reachable at src by
+	// answering one of our pings there. A disco ping is authenticated but
+	// can be replayed from a spoofed source, so for a new src we instead
+	// challenge it with a ping of our own below, and the mapping is made
+	// when its pong arrives.
 	if nk, ok := c.unambiguousNodeKeyOfPingLocked(dm, di.discoKey, derpNodeSrc); ok {
 		if !isDerp {
-			c.peerMap.setNodeKeyForIPPort(src, nk)
+			if ep, ok := c.peerMap.endpointForNodeKey(nk); ok && ep.isVerifiedAddr(src) {
+				c.peerMap.setNodeKeyForIPPort(src, nk)
+			}
 		}
 	}
 
@@ -1695,6 +1940,7 @@ func (c *Conn) handlePingLocked(dm *disco.Ping, src netip.AddrPort, di *discoInf
 				dup = true
 				return false
 			}
+			ep.challengeAddr(src)
 			numNodes++
 			if numNodes == 1 && dstKey.IsZero() {
 				dstKey = ep.publicKey
@@ -1748,7 +1994,7 @@ func (c *Conn) enqueueCallMeMaybe(derpAddr netip.AddrPort, de *endpoint) {
 		return
 	}
 
-	if !c.lastEndpointsTime.After(time.Now().Add(-endpointsFreshEnoughDuration)) {
+	if !c.lastEndpointsTime.After(c.clock.Now().Add(-endpointsFreshEnoughDuration)) {
 		c.dlogf("[v1] magicsock: want call-me-maybe but endpoints stale; restunning")
 
 		mak.Set(&c.onEndpointRefreshed, de, func() {
@@ -1881,6 +2127,26 @@ func (c *Conn) SetPrivateKey(privateKey key.NodePrivate) error {
 	return nil
 }
 
+// SetPrivateKeyContext is like SetPrivateKey, but when the key changes to a
+// non-zero one, it also waits for the connection to the home DERP region to
+// be remade with the new key, or for ctx to be done. If there's no home
+// DERP region yet, it doesn't wait.
+func (c *Conn) SetPrivateKeyContext(ctx context.Context, privateKey key.NodePrivate) error {
+	c.mu.Lock()
+	changed := !privateKey.Equal(c.privateKey)
+	c.mu.Unlock()
+	if err := c.SetPrivateKey(privateKey); err != nil {
+		return err
+	}
+	if !changed || privateKey.IsZero() {
+		return nil
+	}
+	if err := c.ConnectDERPHome(ctx); err != nil && err != errNoDERPHome {
+		return err
+	}
+	return nil
+}
+
 // UpdatePeers is called when the set of WireGuard peers changes. It
 // then removes any state for old peers.
 //
@@ -1979,6 +2245,31 @@ func (c *Conn) SilentDisco() bool {
 	return flags.heartbeatDisabled
 }
 
+// SetBlockedPeers sets the node keys of the peers that the node blocked
+// locally. No disco messages are sent to them or accepted from them, so no
+// direct paths to them are discovered.
+func (c *Conn) SetBlockedPeers(peers set.Set[key.NodePublic]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blockedPeers = peers
+}
+
+// discoKeyBlockedLocked reports whether all the peers using disco key dk
+// are blocked.
+//
+// c.mu must be held.
+func (c *Conn) discoKeyBlockedLocked(dk key.DiscoPublic) bool {
+	if len(c.blockedPeers) == 0 {
+		return false
+	}
+	blocked := false
+	c.peerMap.forEachEndpointWithDiscoKey(dk, func(ep *endpoint) (keepGoing bool) {
+		blocked = c.blockedPeers.Contains(ep.publicKey)
+		return blocked
+	})
+	return blocked
+}
+
 // SetProbeUDPLifetime toggles probing of UDP lifetime based on v.
 func (c *Conn) SetProbeUDPLifetime(v bool) {
 	old := c.probeUDPLifetimeOn.Swap(v)
@@ -2032,6 +2323,8 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 	c.logf("[v1] magicsock: got updated network map; %d peers", len(nm.Peers))
 
 	entriesPerBuffer := debugRingBufferSize(len(nm.Peers))
+	newPeers := 0
+	legacyPeers := 0 // peers skipped for not supporting disco
 
 	// Try a pass of just upserting nodes and creating missing
 	// endpoints. If the set of nodes is the same, this is an
@@ -2068,6 +2361,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 				//    IsWireGuardOnly check)
 				// 3. The server is misbehaving.
 				c.peerMap.deleteEndpoint(ep)
+				legacyPeers++
 				continue
 			}
 			var oldDiscoKey key.DiscoPublic
@@ -2096,6 +2390,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 		if n.DiscoKey().IsZero() && !n.IsWireGuardOnly() {
 			// Ancient pre-0.100 node, which does not have a disco key.
 			// No longer supported.
+			legacyPeers++
 			continue
 		}
 
@@ -2136,14 +2431,18 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 
 		ep.updateFromNode(n, flags.heartbeatDisabled, flags.probeUDPLifetimeOn)
 		c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
+		newPeers++
 	}
+	c.discoPacer.noteNewPeers(newPeers)
+	c.noteLegacyPeersLocked(legacyPeers)
 
 	// If the set of nodes changed since the last SetNetworkMap, the
 	// upsert loop just above made c.peerMap contain the union of the
 	// old and new peers - which will be larger than the set from the
 	// current netmap. If that happens, go through the allocful
-	// deletion path to clean up moribund nodes.
-	if c.peerMap.nodeCount() != len(nm.Peers) {
+	// deletion path to clean up moribund nodes. Legacy peers never
+	// have endpoints, so they don't count.
+	if c.peerMap.nodeCount() != len(nm.Peers)-legacyPeers {
 		keep := set.Set[key.NodePublic]{}
 		for _, n := range nm.Peers {
 			keep.Add(n.Key())
@@ -2163,6 +2462,28 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 	}
 }
 
+// noteLegacyPeersLocked records that the latest netmap has n peers that
+// magicsock ignores because they don't support disco (Tailscale before
+// 0.100), and logs when that number changes, so that it's visible which
+// tailnets still have such nodes left to upgrade.
+//
+// c.mu must be held.
+func (c *Conn) noteLegacyPeersLocked(n int) {
+	metricNumLegacyPeers.Set(int64(n))
+	if n == c.numLegacyPeers {
+		return
+	}
+	if n > c.numLegacyPeers {
+		metricLegacyPeersSeen.Add(int64(n - c.numLegacyPeers))
+	}
+	c.numLegacyPeers = n
+	if n == 0 {
+		c.logf("magicsock: no more peers without disco support")
+	} else {
+		c.logf("magicsock: ignoring %d peers without disco support; they must be upgraded to be reachable", n)
+	}
+}
+
 func devPanicf(format string, a ...any) {
 	if testenv.InTest() || envknob.CrashOnUnexpected() {
 		panic(fmt.Sprintf(format, a...))
@@ -2316,10 +2637,15 @@ func (c *Conn) Close() error {
 		return nil
 	}
 	c.closing.Store(true)
+	c.discoPacer.close()
 	if c.derpCleanupTimerArmed {
 		c.derpCleanupTimer.Stop()
 	}
 	c.stopPeriodicReSTUNTimerLocked()
+	if c.preferredPortRetryTimer != nil {
+		c.preferredPortRetryTimer.Stop()
+		c.preferredPortRetryTimer = nil
+	}
 	c.portMapper.Close()
 
 	c.peerMap.forEachEndpoint(func(ep *endpoint) {
@@ -2386,6 +2712,9 @@ func (c *Conn) shouldDoPeriodicReSTUNLocked() bool {
 		// Also don't if there's no key (not running).
 		return false
 	}
+	if c.lowPowerIdle() {
+		return false
+	}
 	if f := c.idleFunc; f != nil {
 		idleFor := f()
 		if debugReSTUNStopOnIdle() {
@@ -2486,6 +2815,12 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 		return nil
 	}
 
+	if (network == "udp4" && c.disableV4) || (network == "udp6" && c.disableV6) {
+		c.logf("magicsock: not binding %v; address family disabled", network)
+		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+		return nil
+	}
+
 	// Build a list of preferred ports.
 	// Best is the port that the user requested.
 	// Second best is the port that is currently in use.
@@ -2563,6 +2898,62 @@ func (c *Conn) bindSocket(ruc *RebindingUDPConn, network string, curPortFate cur
 	dropCurrentPort = currentPortFate(1)
 )
 
+// preferredPortRetryInterval is how often binding to the preferred port
+// (see SetPreferredPort and ipn.Prefs.ListenPort) is retried while the UDP
+// sockets are bound to another one, so that a transient conflict over the
+// port, such as with another process, doesn't break firewall rules for it
+// for good.
+const preferredPortRetryInterval = time.Minute
+
+// schedulePreferredPortRetryLocked arranges for retryPreferredPort to run
+// in preferredPortRetryInterval if the UDP sockets aren't bound to the
+// preferred port. It uses a timer of its own rather than the periodic
+// re-STUNs, which stop while the node is idle.
+//
+// c.mu must be held.
+func (c *Conn) schedulePreferredPortRetryLocked() {
+	port := uint16(c.port.Load())
+	if c.closed || c.preferredPortRetryTimer != nil || port == 0 || runtime.GOOS == "js" || c.LocalPort() == port {
+		return
+	}
+	c.preferredPortRetryTimer = c.timers.AfterFunc(preferredPortRetryInterval, c.retryPreferredPort)
+}
+
+// retryPreferredPort rebinds the UDP sockets to the preferred port if it's
+// available again, or else schedules another try.
+//
+// c.mu must NOT be held.
+func (c *Conn) retryPreferredPort() {
+	c.mu.Lock()
+	c.preferredPortRetryTimer = nil
+	closed := c.closed
+	c.mu.Unlock()
+	port := uint16(c.port.Load())
+	if closed || port == 0 || c.LocalPort() == port {
+		return
+	}
+
+	// Check that the port is free before rebinding, which closes the
+	// current sockets first.
+	network := "udp4"
+	if c.disableV4 {
+		network = "udp6"
+	}
+	pconn, err := c.listenPacket(network, port)
+	if err != nil {
+		c.dlogf("[v1] magicsock: preferred port %d still unavailable: %v", port, err)
+		c.mu.Lock()
+		c.schedulePreferredPortRetryLocked()
+		c.mu.Unlock()
+		return
+	}
+	pconn.Close()
+	c.logf("magicsock: preferred port %d available again; rebinding to it", port)
+	metricPreferredPortRebinds.Add(1)
+	c.Rebind()
+	c.ReSTUN("preferred-port")
+}
+
 // rebind closes and re-binds the UDP sockets.
 // We consider it successful if we manage to bind the IPv4 socket.
 func (c *Conn) rebind(curPortFate currentPortFate) error {
@@ -2574,6 +2965,9 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 	}
 	c.portMapper.SetLocalPort(c.LocalPort())
 	c.UpdatePMTUD()
+	c.mu.Lock()
+	c.schedulePreferredPortRetryLocked()
+	c.mu.Unlock()
 	return nil
 }
 
@@ -2596,6 +2990,64 @@ func (c *Conn) Rebind() {
 
 	c.maybeCloseDERPsOnRebind(ifIPs)
 	c.resetEndpointStates()
+	c.resumeFromLowPower("rebind")
+}
+
+// udpBlockedReportThreshold is the number of consecutive netcheck reports
+// that must find UDP blocked before Conn stops trying UDP paths to peers.
+// More than one is required so that a single lossy report doesn't cause
+// direct connections to be dropped.
+const udpBlockedReportThreshold = 2
+
+var udpBlockedWarnable = health.Register(&health.Warnable{
+	Code:     "udp-blocked",
+	Title:    "UDP is blocked",
+	Severity: health.SeverityMedium,
+	Text:     health.StaticMessage("This network appears to block UDP traffic. Connections to peers outside this network are being relayed over DERP using HTTPS on TCP port 443, which may be slower."),
+})
+
+// noteUDPReachability updates whether UDP is considered blocked, based on
+// the latest netcheck report.
+//
+// A network is considered to block UDP if netcheck couldn't reach any
+// STUN server but could reach DERP over HTTPS, as happens on networks that
+// allow only web traffic or behind captive portals that have been logged
+// into. Once that has been true for udpBlockedReportThreshold reports in a
+// row, peers without a working direct path are sent traffic only over
+// DERP, rather than also over UDP paths that would time out, and disco
+// only probes their private and link-local candidates: netcheck only
+// tells us that UDP to the internet is blocked, and peers on the same LAN
+// may still be reachable directly. Any report that finds UDP working
+// again turns that off.
+//
+// c.mu must NOT be held.
+func (c *Conn) noteUDPReachability(report *netcheck.Report) {
+	blocked := !report.UDP && len(report.RegionLatency) > 0 && !c.onlyTCP443.Load()
+
+	c.mu.Lock()
+	if blocked {
+		c.udpBlockedReports++
+	} else {
+		c.udpBlockedReports = 0
+	}
+	was := c.udpBlocked.Load()
+	now := c.udpBlockedReports >= udpBlockedReportThreshold
+	c.udpBlocked.Store(now)
+	c.mu.Unlock()
+
+	if was == now {
+		return
+	}
+	if now {
+		c.logf("magicsock: UDP appears to be blocked; relaying peer traffic over DERP unless a LAN path is found")
+		c.health.SetUnhealthy(udpBlockedWarnable, nil)
+		// Forget UDP paths found before UDP was blocked, so that packets
+		// aren't sent to them in addition to DERP.
+		c.resetEndpointStates()
+	} else {
+		c.logf("magicsock: UDP is no longer blocked; resuming discovery of direct paths")
+		c.health.SetHealthy(udpBlockedWarnable)
+	}
 }
 
 // resetEndpointStates resets the preferred address for all peers.
@@ -2697,6 +3149,17 @@ func (c *Conn) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.disableV4 || c.disableV6 {
+		sb.MutateStatus(func(st *ipnstate.Status) {
+			if c.disableV4 {
+				st.DisabledAddrFamilies = append(st.DisabledAddrFamilies, "IPv4")
+			}
+			if c.disableV6 {
+				st.DisabledAddrFamilies = append(st.DisabledAddrFamilies, "IPv6")
+			}
+		})
+	}
+
 	sb.MutateSelfStatus(func(ss *ipnstate.PeerStatus) {
 		ss.Addrs = make([]string, 0, len(c.lastEndpoints))
 		for _, ep := range c.lastEndpoints {
@@ -2740,7 +3203,7 @@ func (c *Conn) SetHomeless(v bool) {
 
 	if v && c.myDerp != 0 {
 		oldHome := c.myDerp
-		c.myDerp = 0
+		c.setMyDerpLocked(0)
 		c.closeDerpLocked(oldHome, "set-homeless")
 	}
 	if !v {
@@ -2775,6 +3238,12 @@ func (c *Conn) SetHomeless(v bool) {
 	// STUN-derived endpoint valid for. UDP NAT mappings typically
 	// expire at 30 seconds, so this is a few seconds shy of that.
 	endpointsFreshEnoughDuration = 27 * time.Second
+
+	// timerWheelResolution is the resolution of Conn.timers, and thus
+	// how late its timers may fire. It bounds the wakeups of an idle Conn
+	// due to those timers to one per timerWheelResolution, regardless of
+	// the number of peers.
+	timerWheelResolution = 1 * time.Second
 )
 
 // Constants that are variable for testing.
@@ -2807,6 +3276,13 @@ func (c *Conn) getPinger() *ping.Pinger {
 	})
 }
 
+// DERPRegionHistory returns the record of how reliable each DERP region has
+// been, which is used to pick the home DERP region. Callers may persist it
+// across restarts.
+func (c *Conn) DERPRegionHistory() *netcheck.RegionHistory {
+	return &c.derpHistory
+}
+
 // DebugPickNewDERP picks a new DERP random home temporarily (even if just for
 // seconds) and reports it to control. It exists to test DERP home changes and
 // netmap deltas, etc. It serves no useful user purpose.
@@ -2895,10 +3371,26 @@ type discoInfo struct {
 	metricNumPeers     = clientmetric.NewGauge("magicsock_netmap_num_peers")
 	metricNumDERPConns = clientmetric.NewGauge("magicsock_num_derp_conns")
 
+	// Peers that don't support disco, which magicsock ignores.
+	metricNumLegacyPeers  = clientmetric.NewGauge("magicsock_netmap_num_legacy_peers")
+	metricLegacyPeersSeen = clientmetric.NewCounter("magicsock_legacy_peers_seen")
+
 	metricRebindCalls     = clientmetric.NewCounter("magicsock_rebind_calls")
 	metricReSTUNCalls     = clientmetric.NewCounter("magicsock_restun_calls")
 	metricUpdateEndpoints = clientmetric.NewCounter("magicsock_update_endpoints")
 
+	metricPreferredPortRebinds = clientmetric.NewCounter("magicsock_preferred_port_rebinds")
+
+	// Initial discovery pacing; see discoPacer.
+	metricDiscoPacerWindows        = clientmetric.NewCounter("magicsock_disco_pacer_windows")
+	metricDiscoPacerQueued         = clientmetric.NewCounter("magicsock_disco_pacer_queued")
+	metricDiscoPacerHandshakesHeld = clientmetric.NewCounter("magicsock_disco_pacer_handshakes_held")
+
+	// Aggressive discovery during bulk transfers over DERP; see derpBulkState.
+	metricDERPBulkProbingStarted   = clientmetric.NewCounter("magicsock_derp_bulk_probing_started")
+	metricDERPBulkProbingSucceeded = clientmetric.NewCounter("magicsock_derp_bulk_probing_succeeded")
+	metricDERPBulkProbingGaveUp    = clientmetric.NewCounter("magicsock_derp_bulk_probing_gave_up")
+
 	// Sends (data or disco)
 	metricSendDERPQueued      = clientmetric.NewCounter("magicsock_send_derp_queued")
 	metricSendDERPErrorChan   = clientmetric.NewCounter("magicsock_send_derp_error_chan")
@@ -2910,11 +3402,16 @@ type discoInfo struct {
 	metricSendDERPError       = clientmetric.NewCounter("magicsock_send_derp_error")
 
 	// Data packets (non-disco)
-	metricSendData            = clientmetric.NewCounter("magicsock_send_data")
-	metricSendDataNetworkDown = clientmetric.NewCounter("magicsock_send_data_network_down")
-	metricRecvDataDERP        = clientmetric.NewCounter("magicsock_recv_data_derp")
-	metricRecvDataIPv4        = clientmetric.NewCounter("magicsock_recv_data_ipv4")
-	metricRecvDataIPv6        = clientmetric.NewCounter("magicsock_recv_data_ipv6")
+	metricSendData             = clientmetric.NewCounter("magicsock_send_data")
+	metricSendDataNetworkDown  = clientmetric.NewCounter("magicsock_send_data_network_down")
+	metricSendDataChaosDropped = clientmetric.NewCounter("magicsock_send_data_chaos_dropped")
+	metricRecvDataDERP         = clientmetric.NewCounter("magicsock_recv_data_derp")
+	metricRecvDataIPv4         = clientmetric.NewCounter("magicsock_recv_data_ipv4")
+	metricRecvDataIPv6         = clientmetric.NewCounter("magicsock_recv_data_ipv6")
+
+	// Low power mode
+	metricLowPowerSuspended = clientmetric.NewCounter("magicsock_low_power_suspended")
+	metricLowPowerResumed   = clientmetric.NewCounter("magicsock_low_power_resumed")
 
 	// Disco packets
 	metricSendDiscoUDP               = clientmetric.NewCounter("magicsock_disco_send_udp")
@@ -2926,7 +3423,9 @@ type discoInfo struct {
 	metricSentDiscoPeerMTUProbes     = clientmetric.NewCounter("magicsock_disco_sent_peer_mtu_probes")
 	metricSentDiscoPeerMTUProbeBytes = clientmetric.NewCounter("magicsock_disco_sent_peer_mtu_probe_bytes")
 	metricSentDiscoCallMeMaybe       = clientmetric.NewCounter("magicsock_disco_sent_callmemaybe")
+	metricSentDiscoAppMessage        = clientmetric.NewCounter("magicsock_disco_sent_app_message")
 	metricRecvDiscoBadPeer           = clientmetric.NewCounter("magicsock_disco_recv_bad_peer")
+	metricRecvDiscoBlockedPeer       = clientmetric.NewCounter("magicsock_disco_recv_blocked_peer")
 	metricRecvDiscoBadKey            = clientmetric.NewCounter("magicsock_disco_recv_bad_key")
 	metricRecvDiscoBadParse          = clientmetric.NewCounter("magicsock_disco_recv_bad_parse")
 
@@ -2937,6 +3436,9 @@ type discoInfo struct {
 	metricRecvDiscoCallMeMaybe         = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe")
 	metricRecvDiscoCallMeMaybeBadNode  = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe_bad_node")
 	metricRecvDiscoCallMeMaybeBadDisco = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe_bad_disco")
+	metricRecvDiscoAppMessage          = clientmetric.NewCounter("magicsock_disco_recv_app_message")
+	metricRecvDiscoAppMessageBadNode   = clientmetric.NewCounter("magicsock_disco_recv_app_message_bad_node")
+	metricRecvDiscoAppMessageNoHandler = clientmetric.NewCounter("magicsock_disco_recv_app_message_no_handler")
 	metricRecvDiscoDERPPeerNotHere     = clientmetric.NewCounter("magicsock_disco_recv_derp_peer_not_here")
 	metricRecvDiscoDERPPeerGoneUnknown = clientmetric.NewCounter("magicsock_disco_recv_derp_peer_gone_unknown")
 	// metricDERPHomeChange is how many times our DERP home region DI has
@@ -2948,6 +3450,11 @@ type discoInfo struct {
 	// the control server.
 	metricDERPHomeNoChangeNoControl = clientmetric.NewCounter("derp_home_no_change_no_control")
 
+	// metricDERPHomeChangeDeferred is how many times a netcheck report
+	// preferred another DERP region than our home, but not for enough
+	// reports in a row to move there.
+	metricDERPHomeChangeDeferred = clientmetric.NewCounter("derp_home_change_deferred")
+
 	// metricDERPHomeFallback is how many times we picked a DERP fallback.
 	metricDERPHomeFallback = clientmetric.NewCounter("derp_home_fallback")
 
@@ -3015,6 +3522,14 @@ func (c *Conn) GetLastNetcheckReport(ctx context.Context) *netcheck.Report {
 	return lastReport
 }
 
+// Netcheck runs a new netcheck, bounded by ctx, and returns its report. As
+// with the netchecks run in the background, the report updates the Conn's
+// NetInfo and may change its home DERP region. If ctx has no deadline, the
+// netcheck takes at most a couple of seconds.
+func (c *Conn) Netcheck(ctx context.Context) (*netcheck.Report, error) {
+	return c.updateNetInfo(ctx)
+}
+
 // SetLastNetcheckReportForTest sets the magicsock conn's last netcheck report.
 // Used for testing purposes.
 func (c *Conn) SetLastNetcheckReportForTest(ctx context.Context, report *netcheck.Report) {
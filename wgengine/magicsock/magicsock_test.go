@@ -19,6 +19,7 @@
 	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,11 +50,14 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/ping"
+	"tailscale.com/net/stun"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/natlab"
+	"tailscale.com/tstest/waitfor"
+	"tailscale.com/tstime"
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -63,6 +67,7 @@
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/cibuild"
 	"tailscale.com/util/racebuild"
+	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/wgcfg"
@@ -87,6 +92,8 @@ func init() {
 // conditions in tests. In particular, you can't expect two test magicsocks
 // to be able to connect to each other through a test DERP unless they are
 // both fully initialized before you try.
+//
+// Tests outside this package can use waitfor.HomeDERP instead.
 func (c *Conn) WaitReady(t testing.TB) {
 	t.Helper()
 	timer := time.NewTimer(10 * time.Second)
@@ -101,7 +108,7 @@ func (c *Conn) WaitReady(t testing.TB) {
 	}
 }
 
-func runDERPAndStun(t *testing.T, logf logger.Logf, l nettype.PacketListener, stunIP netip.Addr) (derpMap *tailcfg.DERPMap, cleanup func()) {
+func runDERPAndStun(t testing.TB, logf logger.Logf, l nettype.PacketListener, stunIP netip.Addr) (derpMap *tailcfg.DERPMap, cleanup func()) {
 	d := derp.NewServer(key.NewNode(), logf)
 
 	httpsrv := httptest.NewUnstartedServer(derphttp.Handler(d))
@@ -258,15 +265,20 @@ func (s *magicStack) Status() *ipnstate.Status {
 // configs to the magicStack in order for it to acquire an IP
 // address. See meshStacks for one possible source of netmaps and IPs.
 func (s *magicStack) IP() netip.Addr {
-	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	var addr netip.Addr
+	err := tstest.WaitFor(5*time.Second, func() error {
 		s.conn.mu.Lock()
-		addr := s.conn.firstAddrForTest
-		s.conn.mu.Unlock()
-		if addr.IsValid() {
-			return addr
+		defer s.conn.mu.Unlock()
+		addr = s.conn.firstAddrForTest
+		if !addr.IsValid() {
+			return errors.New("timed out waiting for magicstack to get an IP assigned")
 		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
 	}
-	panic("timed out waiting for magicstack to get an IP assigned")
+	return addr
 }
 
 // meshStacks monitors epCh on all given ms, and plumbs network maps
@@ -757,6 +769,32 @@ func TestActiveDiscovery(t *testing.T) {
 		testActiveDiscovery(t, n)
 	})
 
+	t.Run("wan_conditions", func(t *testing.T) {
+		t.Parallel()
+		mstun := &natlab.Machine{Name: "stun"}
+		m1 := &natlab.Machine{Name: "m1"}
+		m2 := &natlab.Machine{Name: "m2"}
+		inet := natlab.NewInternet()
+		inet.SetLinkConditions(natlab.LinkConditions{
+			Latency:     20 * time.Millisecond,
+			Jitter:      10 * time.Millisecond,
+			ReorderRate: 0.05,
+		})
+		sif := mstun.Attach("eth0", inet)
+		m1if := m1.Attach("eth0", inet)
+		m2if := m2.Attach("eth0", inet)
+
+		n := &devices{
+			m1:     m1,
+			m1IP:   m1if.V4(),
+			m2:     m2,
+			m2IP:   m2if.V4(),
+			stun:   mstun,
+			stunIP: sif.V4(),
+		}
+		testActiveDiscovery(t, n)
+	})
+
 	t.Run("facing_easy_firewalls", func(t *testing.T) {
 		mstun := &natlab.Machine{Name: "stun"}
 		m1 := &natlab.Machine{
@@ -972,21 +1010,14 @@ func testActiveDiscovery(t *testing.T, d *devices) {
 }
 
 func mustDirect(t *testing.T, logf logger.Logf, m1, m2 *magicStack) {
-	lastLog := time.Now().Add(-time.Minute)
 	// See https://github.com/tailscale/tailscale/issues/654
 	// and https://github.com/tailscale/tailscale/issues/3247 for discussions of this deadline.
-	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
-		pst := m1.Status().Peer[m2.Public()]
-		if pst.CurAddr != "" {
-			logf("direct link %s->%s found with addr %s", m1, m2, pst.CurAddr)
-			return
-		}
-		if now := time.Now(); now.Sub(lastLog) > time.Second {
-			logf("no direct path %s->%s yet, addrs %v", m1, m2, pst.Addrs)
-			lastLog = now
-		}
+	addr, err := waitfor.DirectPath(30*time.Second, logf, m1.Status, m2.Public())
+	if err != nil {
+		t.Errorf("magicsock did not find a direct path from %s to %s: %v", m1, m2, err)
+		return
 	}
-	t.Errorf("magicsock did not find a direct path from %s to %s", m1, m2)
+	logf("direct link %s->%s found with addr %s", m1, m2, addr)
 }
 
 func testTwoDevicePing(t *testing.T, d *devices) {
@@ -1218,6 +1249,155 @@ func TestDiscoMessage(t *testing.T) {
 	}
 }
 
+func TestConnClock(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	c := newConn(t.Logf)
+	c.setClock(clock)
+
+	m0 := c.monoNow()
+	clock.Advance(time.Minute)
+	if got := c.monoNow().Sub(m0); got != time.Minute {
+		t.Fatalf("monoNow advanced %v after clock advanced 1m", got)
+	}
+
+	// The window during which the best UDP path is trusted without
+	// re-pinging follows the clock, not real time.
+	de := &endpoint{c: c}
+	now := c.monoNow()
+	de.bestAddr = addrQuality{AddrPort: netip.MustParseAddrPort("1.2.3.4:567"), latency: time.Millisecond}
+	de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+	de.lastFullPing = now
+	if de.wantFullPingLocked(c.monoNow()) {
+		t.Error("wantFullPing = true while best address is trusted")
+	}
+	clock.Advance(trustUDPAddrDuration + time.Second)
+	if !de.wantFullPingLocked(c.monoNow()) {
+		t.Error("wantFullPing = false after trust window expired")
+	}
+
+	fired := make(chan bool, 1)
+	c.clock.AfterFunc(heartbeatInterval, func() { fired <- true })
+	clock.Advance(heartbeatInterval)
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Error("timer didn't fire when clock advanced")
+	}
+}
+
+func TestRetryPreferredPort(t *testing.T) {
+	// Another socket holds the preferred port at first.
+	blocker, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocker.Close()
+	port := uint16(blocker.LocalAddr().(*net.UDPAddr).Port)
+
+	netMon, err := netmon.New(logger.WithPrefix(t.Logf, "... netmon: "))
+	if err != nil {
+		t.Fatalf("netmon.New: %v", err)
+	}
+	defer netMon.Close()
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	conn, err := NewConn(Options{
+		NetMon:                 netMon,
+		HealthTracker:          new(health.Tracker),
+		DisablePortMapper:      true,
+		Logf:                   t.Logf,
+		Port:                   port,
+		Clock:                  clock,
+		TestOnlyPacketListener: localhostListener{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.LocalPort() == port {
+		t.Fatalf("bound to port %d while it was in use", port)
+	}
+
+	// The retries keep going while the port is in use, with no periodic
+	// STUNs, as when the node is idle, and take it once it's free.
+	clock.Advance(preferredPortRetryInterval + timerWheelResolution)
+	if conn.LocalPort() == port {
+		t.Fatalf("bound to port %d while it was in use", port)
+	}
+	blocker.Close()
+	if err := tstest.WaitFor(10*time.Second, func() error {
+		clock.Advance(preferredPortRetryInterval + timerWheelResolution)
+		if got := conn.LocalPort(); got != port {
+			return fmt.Errorf("LocalPort = %d; want %d", got, port)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoamRequiresPongVerification(t *testing.T) {
+	c := newConn(t.Logf)
+	c.privateKey = key.NewNode()
+	// Swallow the challenge pings.
+	c.pconn4.mu.Lock()
+	c.pconn4.setConnLocked(newBlockForeverConn(), "udp4", 1)
+	c.pconn4.mu.Unlock()
+
+	peerDisco := key.NewDisco().Public()
+	ep := &endpoint{
+		c:             c,
+		nodeID:        1,
+		publicKey:     key.NewNode().Public(),
+		sentPing:      map[stun.TxID]sentPing{},
+		endpointState: map[netip.AddrPort]*endpointState{},
+		debugUpdates:  ringbuffer.New[EndpointChange](10),
+	}
+	ep.disco.Store(&endpointDisco{key: peerDisco, short: peerDisco.ShortString()})
+	c.mu.Lock()
+	c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
+	c.mu.Unlock()
+
+	src := netip.MustParseAddrPort("1.2.3.4:5678")
+	if ep.addCandidateEndpoint(src, stun.NewTxID()) {
+		t.Fatal("first ping reported as duplicate")
+	}
+	ep.challengeAddr(src)
+
+	pv, ok := c.PathVerifications(ep.publicKey)
+	if !ok || len(pv) != 1 {
+		t.Fatalf("PathVerifications = %+v, %v; want one path", pv, ok)
+	}
+	if want := (PathVerification{Addr: src, Pending: true, Learned: true}); pv[0] != want {
+		t.Errorf("before pong: %+v; want %+v", pv[0], want)
+	}
+	c.mu.Lock()
+	_, mapped := c.peerMap.endpointForIPPort(src)
+	c.mu.Unlock()
+	if mapped {
+		t.Fatal("unverified source was attributed to the peer")
+	}
+
+	ep.mu.Lock()
+	var txID stun.TxID
+	for id := range ep.sentPing {
+		txID = id
+	}
+	ep.mu.Unlock()
+	c.mu.Lock()
+	if !ep.handlePongConnLocked(&disco.Pong{TxID: txID, Src: src}, &discoInfo{discoKey: peerDisco}, src) {
+		t.Error("challenge pong not recognized")
+	}
+	got, mapped := c.peerMap.endpointForIPPort(src)
+	c.mu.Unlock()
+	if !mapped || got != ep {
+		t.Error("verified source not attributed to the peer")
+	}
+	pv, _ = c.PathVerifications(ep.publicKey)
+	if want := (PathVerification{Addr: src, Verified: true, Learned: true}); len(pv) != 1 || pv[0] != want {
+		t.Errorf("after pong: %+v; want [%+v]", pv, want)
+	}
+}
+
 // tests that having a endpoint.String prevents wireguard-go's
 // log.Printf("%v") of its conn.Endpoint values from using reflect to
 // walk into read mutex while they're being used and then causing data
@@ -1896,60 +2076,210 @@ func TestStressSetNetworkMap(t *testing.T) {
 func randDiscoKey() (k key.DiscoPublic) { return key.NewDisco().Public() }
 func randNodeKey() (k key.NodePublic)   { return key.NewNode().Public() }
 
-// validate checks m for internal consistency and reports the first error encountered.
-// It is used in tests only, so it doesn't need to be efficient.
-func (m *peerMap) validate() error {
-	seenEps := make(map[*endpoint]bool)
-	for pub, pi := range m.byNodeKey {
-		if got := pi.ep.publicKey; got != pub {
-			return fmt.Errorf("byNodeKey[%v].publicKey = %v", pub, got)
-		}
-		if _, ok := seenEps[pi.ep]; ok {
-			return fmt.Errorf("duplicate endpoint present: %v", pi.ep.publicKey)
-		}
-		seenEps[pi.ep] = true
-		for ipp := range pi.ipPorts {
-			if got := m.byIPPort[ipp]; got != pi {
-				return fmt.Errorf("m.byIPPort[%v] = %v, want %v", ipp, got, pi)
+// TestPeerChurnStress adds and removes hundreds of peers to a Conn while
+// it passes traffic to another peer, and checks that its indexes of peers
+// stay consistent and that removed peers leave nothing running behind.
+func TestPeerChurnStress(t *testing.T) {
+	tstest.ResourceCheck(t)
+	var logBuf tstest.MemLogger
+	logf, closeLogf := logger.LogfCloser(logBuf.Logf)
+	defer closeLogf()
+
+	l, ip := localhostListener{}, netaddr.IPv4(127, 0, 0, 1)
+	derpMap, cleanupDERP := runDERPAndStun(t, logf, l, ip)
+	defer cleanupDERP()
+
+	m1 := newMagicStack(t, logf, l, derpMap)
+	defer m1.Close()
+	m2 := newMagicStack(t, logf, l, derpMap)
+	defer m2.Close()
+
+	const nfake = 300
+	fakes := make([]*tailcfg.Node, nfake)
+	for i := range fakes {
+		fakes[i] = &tailcfg.Node{
+			ID:        tailcfg.NodeID(1000 + i),
+			Key:       randNodeKey(),
+			DiscoKey:  randDiscoKey(),
+			Endpoints: eps(fmt.Sprintf("192.168.%d.%d:41641", 1+i/250, 1+i%250)),
+			DERP:      "127.3.3.40:1",
+		}
+	}
+
+	// m1's netmaps are meshStacks' ones plus the present fake peers, both
+	// when meshStacks sets them and when the churn loop below does.
+	var (
+		mu      sync.Mutex
+		present = make([]bool, nfake)
+		baseNM  *netmap.NetworkMap // last netmap for m1 from meshStacks
+	)
+	netmapLocked := func(base *netmap.NetworkMap) *netmap.NetworkMap {
+		nm := *base
+		nm.Peers = slices.Clone(base.Peers)
+		for i, p := range fakes {
+			if present[i] {
+				nm.Peers = append(nm.Peers, p.Clone().View())
 			}
 		}
+		return &nm
 	}
-	if len(m.byNodeKey) != len(m.byNodeID) {
-		return fmt.Errorf("len(m.byNodeKey)=%d != len(m.byNodeID)=%d", len(m.byNodeKey), len(m.byNodeID))
+	cleanupMesh := meshStacks(logf, func(idx int, nm *netmap.NetworkMap) {
+		if idx != 0 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		base := *nm
+		base.Peers = slices.Clone(nm.Peers)
+		baseNM = &base
+		*nm = *netmapLocked(baseNM)
+	}, m1, m2)
+	defer cleanupMesh()
+
+	cleanupPing := newPinger(t, logf, m1, m2)
+	defer cleanupPing()
+
+	seenEps := set.Set[*endpoint]{}
+	prng := rand.New(rand.NewSource(1))
+	for iter := range 200 {
+		mu.Lock()
+		for i := range fakes {
+			if prng.Intn(4) == 0 {
+				present[i] = !present[i]
+			}
+			if prng.Intn(16) == 0 {
+				fakes[i].DiscoKey = randDiscoKey()
+			}
+		}
+		nm := netmapLocked(baseNM)
+		mu.Unlock()
+		m1.conn.SetNetworkMap(nm)
+
+		// Pretend some present fake peers were found at their endpoints,
+		// so removing them has ip:port mappings to clean up.
+		m1.conn.mu.Lock()
+		m1.conn.peerMap.forEachEndpoint(func(ep *endpoint) {
+			seenEps.Add(ep)
+			if ep.nodeID >= 1000 && prng.Intn(2) == 0 {
+				m1.conn.peerMap.setNodeKeyForIPPort(fakes[ep.nodeID-1000].Endpoints[0], ep.publicKey)
+			}
+		})
+		m1.conn.mu.Unlock()
+
+		if err := m1.conn.CheckInvariants(); err != nil {
+			t.Fatalf("iteration %d: %v", iter, err)
+		}
 	}
-	for nodeID, pi := range m.byNodeID {
-		ep := pi.ep
-		if pi2, ok := m.byNodeKey[ep.publicKey]; !ok {
-			return fmt.Errorf("nodeID %d in map with publicKey %v that's missing from map", nodeID, ep.publicKey)
-		} else if pi2 != pi {
-			return fmt.Errorf("nodeID %d in map with publicKey %v that points to different endpoint", nodeID, ep.publicKey)
+
+	// Remove all fake peers.
+	mu.Lock()
+	clear(present)
+	nm := netmapLocked(baseNM)
+	mu.Unlock()
+	m1.conn.SetNetworkMap(nm)
+	if err := m1.conn.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+
+	m1.conn.mu.Lock()
+	if n := m1.conn.peerMap.nodeCount(); n != 1 {
+		t.Errorf("%d peers left; want only m2", n)
+	}
+	for ipp, pi := range m1.conn.peerMap.byIPPort {
+		if pi.ep.publicKey != m2.Public() {
+			t.Errorf("ip:port mapping left for %v to removed peer %v", ipp, pi.ep.publicKey.ShortString())
 		}
 	}
+	if n := len(m1.conn.peerMap.nodesOfDisco); n != 1 {
+		t.Errorf("%d disco keys left; want only m2's", n)
+	}
+	for dk := range m1.conn.discoInfo {
+		if dk != m2.conn.DiscoPublicKey() {
+			t.Errorf("discoInfo left for removed disco key %v", dk.ShortString())
+		}
+	}
+	m1.conn.mu.Unlock()
 
-	for ipp, pi := range m.byIPPort {
-		if !pi.ipPorts.Contains(ipp) {
-			return fmt.Errorf("ipPorts[%v] for %v is false", ipp, pi.ep.publicKey)
+	// Removed endpoints must have nothing running or queued.
+	for ep := range seenEps {
+		if ep.publicKey == m2.Public() {
+			continue
+		}
+		ep.mu.Lock()
+		if ep.heartBeatTimer != nil {
+			t.Errorf("removed endpoint %v still has a heartbeat timer", ep.publicKey.ShortString())
 		}
-		pi2 := m.byNodeKey[pi.ep.publicKey]
-		if pi != pi2 {
-			return fmt.Errorf("byNodeKey[%v]=%p doesn't match byIPPort[%v]=%p", pi, pi, pi.ep.publicKey, pi2)
+		ep.mu.Unlock()
+		m1.conn.discoPacer.mu.Lock()
+		if m1.conn.discoPacer.queued[ep] != nil {
+			t.Errorf("removed endpoint %v still queued for discovery", ep.publicKey.ShortString())
 		}
+		m1.conn.discoPacer.mu.Unlock()
 	}
+}
 
-	publicToDisco := make(map[key.NodePublic]key.DiscoPublic)
-	for disco, nodes := range m.nodesOfDisco {
-		for pub := range nodes {
-			if _, ok := m.byNodeKey[pub]; !ok {
-				return fmt.Errorf("nodesOfDisco refers to public key %v, which is not present in byNodeKey", pub)
-			}
-			if _, ok := publicToDisco[pub]; ok {
-				return fmt.Errorf("publicKey %v refers to multiple disco keys", pub)
+func TestDebugState(t *testing.T) {
+	conn := newTestConn(t)
+	defer conn.Close()
+	conn.SetPrivateKey(key.NewNode())
+
+	peers := []*tailcfg.Node{
+		{
+			ID:        1,
+			DiscoKey:  randDiscoKey(),
+			Key:       randNodeKey(),
+			Endpoints: eps("192.168.1.2:1000", "10.0.0.1:1000"),
+		},
+		{
+			ID:        2,
+			DiscoKey:  randDiscoKey(),
+			Key:       randNodeKey(),
+			Endpoints: eps("192.168.1.2:2000"),
+		},
+	}
+	conn.SetNetworkMap(&netmap.NetworkMap{Peers: nodeViews(peers)})
+
+	st := conn.DebugState(DebugStateOptions{})
+	if len(st.Peers) != len(peers) {
+		t.Fatalf("got %d peers; want %d", len(st.Peers), len(peers))
+	}
+	for _, n := range peers {
+		var p DebugPeer
+		for _, sp := range st.Peers {
+			if sp.NodeKey == n.Key {
+				p = sp
 			}
-			publicToDisco[pub] = disco
+		}
+		if p.NodeID != n.ID || p.DiscoKey != n.DiscoKey {
+			t.Errorf("peer %v = %+v; want NodeID %v, DiscoKey %v", n.Key.ShortString(), p, n.ID, n.DiscoKey.ShortString())
+		}
+		if got := st.EndpointOfDisco[n.DiscoKey]; len(got) != 1 || got[0] != n.Key {
+			t.Errorf("EndpointOfDisco[%v] = %v; want [%v]", n.DiscoKey.ShortString(), got, n.Key.ShortString())
+		}
+		if len(p.Endpoints) != len(n.Endpoints) {
+			t.Errorf("peer %v endpoints = %q; want %v", n.Key.ShortString(), p.Endpoints, n.Endpoints)
 		}
 	}
 
-	return nil
+	st = conn.DebugState(DebugStateOptions{RedactAddrs: true})
+	var all []string
+	for _, p := range st.Peers {
+		for _, ep := range p.Endpoints {
+			if strings.Contains(ep, "192.168") || strings.Contains(ep, "10.0") {
+				t.Errorf("unredacted endpoint %q", ep)
+			}
+			all = append(all, ep)
+		}
+	}
+	// The two peers' endpoints on 192.168.1.2 must share a placeholder.
+	ips := map[string]bool{}
+	for _, ep := range all {
+		ip, _, _ := strings.Cut(ep, ":")
+		ips[ip] = true
+	}
+	if len(all) != 3 || len(ips) != 2 {
+		t.Errorf("redacted endpoints = %q; want 3 endpoints on 2 IPs", all)
+	}
 }
 
 func TestBlockForeverConnUnblocks(t *testing.T) {
@@ -2664,9 +2994,10 @@ type endpointDetails struct {
 				isWireguardOnly: true,
 				endpointState:   map[netip.AddrPort]*endpointState{},
 				c: &Conn{
-					logf: t.Logf,
-					noV4: atomic.Bool{},
-					noV6: atomic.Bool{},
+					logf:  t.Logf,
+					noV4:  atomic.Bool{},
+					noV6:  atomic.Bool{},
+					clock: tstime.StdClock{},
 				},
 			}
 
@@ -2982,3 +3313,214 @@ func TestMaybeRebindOnError(t *testing.T) {
 		}
 	})
 }
+
+func TestDisableAddrFamily(t *testing.T) {
+	netMon, err := netmon.New(logger.WithPrefix(t.Logf, "... netmon: "))
+	if err != nil {
+		t.Fatalf("netmon.New: %v", err)
+	}
+	defer netMon.Close()
+
+	opts := Options{
+		NetMon:                 netMon,
+		HealthTracker:          new(health.Tracker),
+		DisablePortMapper:      true,
+		Logf:                   t.Logf,
+		TestOnlyPacketListener: localhostListener{},
+		DisableIPv4:            true,
+		DisableIPv6:            true,
+	}
+	if _, err := NewConn(opts); err == nil {
+		t.Fatal("NewConn with both address families disabled succeeded")
+	}
+
+	opts.DisableIPv4 = false
+	conn, err := NewConn(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if port := conn.pconn6.LocalAddr().Port; port != 0 {
+		t.Errorf("IPv6 socket bound to port %d; want unbound", port)
+	}
+	if conn.LocalPort() == 0 {
+		t.Errorf("IPv4 socket unbound")
+	}
+	sent, err := conn.sendUDP(netip.MustParseAddrPort("[2001:db8::1]:41641"), []byte("hello"))
+	if sent || err != nil {
+		t.Errorf("sendUDP over IPv6 = %v, %v; want false, nil", sent, err)
+	}
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.1", false},
+		{"::ffff:192.0.2.1", false},
+		{"2001:db8::1", true},
+	} {
+		if got := conn.addrFamilyDisabled(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("addrFamilyDisabled(%s) = %v; want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestUDPBlocked(t *testing.T) {
+	c := newConn(t.Logf)
+	ht := new(health.Tracker)
+	c.health = ht
+	isUnhealthy := func() bool {
+		_, ok := ht.CurrentState().Warnings[udpBlockedWarnable.Code]
+		return ok
+	}
+
+	derpAddr := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1)
+	de := &endpoint{c: c, derpAddr: derpAddr}
+	de.bestAddr = addrQuality{AddrPort: netip.MustParseAddrPort("1.2.3.4:567")}
+	de.trustBestAddrUntil = c.monoNow().Add(time.Hour)
+
+	blocked := &netcheck.Report{RegionLatency: map[int]time.Duration{1: 10 * time.Millisecond}}
+	working := &netcheck.Report{UDP: true, RegionLatency: blocked.RegionLatency}
+
+	// A single report isn't enough.
+	c.noteUDPReachability(blocked)
+	if c.udpBlocked.Load() || isUnhealthy() {
+		t.Fatal("UDP considered blocked after one report")
+	}
+	c.noteUDPReachability(blocked)
+	if !c.udpBlocked.Load() || !isUnhealthy() {
+		t.Fatal("UDP not considered blocked after two reports")
+	}
+	// A path that discovery found working (such as on the LAN) is
+	// still used.
+	if udp, derp, _ := de.addrForSendLocked(c.monoNow()); udp != de.bestAddr.AddrPort || derp.IsValid() {
+		t.Errorf("addrForSendLocked = %v, %v; want only the trusted UDP path", udp, derp)
+	}
+	// Otherwise, only DERP is used.
+	de.trustBestAddrUntil = 0
+	if udp, derp, _ := de.addrForSendLocked(c.monoNow()); udp.IsValid() || derp != derpAddr {
+		t.Errorf("addrForSendLocked = %v, %v; want only DERP", udp, derp)
+	}
+
+	// Not reaching DERP either isn't the same thing.
+	c.noteUDPReachability(&netcheck.Report{})
+	if c.udpBlocked.Load() {
+		t.Error("UDP considered blocked with DERP unreachable")
+	}
+
+	c.noteUDPReachability(blocked)
+	c.noteUDPReachability(blocked)
+	c.noteUDPReachability(working)
+	if c.udpBlocked.Load() || isUnhealthy() {
+		t.Error("UDP still considered blocked after it worked")
+	}
+}
+
+func TestPeerChaos(t *testing.T) {
+	c := newConn(t.Logf)
+	peer := key.NewNode().Public()
+
+	for _, pc := range []PeerChaos{
+		{Latency: -time.Second},
+		{Jitter: -time.Second},
+		{Latency: maxChaosLatency, Jitter: time.Millisecond},
+		{DropRate: 1.5},
+		{DropRate: -0.1},
+	} {
+		if err := c.SetPeerChaos(peer, pc); err == nil {
+			t.Errorf("SetPeerChaos(%+v) succeeded; want error", pc)
+		}
+	}
+	if c.chaosActive.Load() {
+		t.Fatal("chaos active after only invalid settings")
+	}
+
+	want := PeerChaos{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, DropRate: 0.25}
+	if err := c.SetPeerChaos(peer, want); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.PeerChaos(peer); got != want {
+		t.Errorf("PeerChaos = %+v; want %+v", got, want)
+	}
+	if !c.chaosActive.Load() {
+		t.Error("chaos not active after SetPeerChaos")
+	}
+	if err := c.SetPeerChaos(peer, PeerChaos{}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.PeerChaos(peer).IsZero() || c.chaosActive.Load() {
+		t.Error("chaos still active after being removed")
+	}
+}
+
+func TestPeerHeartbeatInterval(t *testing.T) {
+	c := newConn(t.Logf)
+	peer := key.NewNode().Public()
+	de := &endpoint{c: c, publicKey: peer}
+
+	if err := c.SetPeerHeartbeatInterval(peer, time.Millisecond); err == nil {
+		t.Error("SetPeerHeartbeatInterval(1ms) succeeded; want error")
+	}
+
+	now := mono.Time(10*time.Second + 500*time.Millisecond)
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+		wantOK   bool
+	}{
+		{0, 1500 * time.Millisecond, true}, // aligned to 12s
+		{5 * time.Second, 4500 * time.Millisecond, true},
+		{time.Minute, 49500 * time.Millisecond, true},
+		{-1, 0, false},
+	}
+	for _, tt := range tests {
+		if err := c.SetPeerHeartbeatInterval(peer, tt.interval); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.PeerHeartbeatInterval(peer); got != tt.interval {
+			t.Errorf("PeerHeartbeatInterval = %v; want %v", got, tt.interval)
+		}
+		got, ok := de.nextHeartbeatLocked(now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("interval %v: nextHeartbeatLocked = %v, %v; want %v, %v", tt.interval, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if err := c.SetPeerHeartbeatInterval(peer, 0); err != nil {
+		t.Fatal(err)
+	}
+	de.heartbeatDisabled = true
+	if _, ok := de.nextHeartbeatLocked(now); ok {
+		t.Error("heartbeat scheduled with heartbeats disabled by control")
+	}
+}
+
+func TestLowPower(t *testing.T) {
+	c := newConn(t.Logf)
+	var idle time.Duration
+	c.idleFunc = func() time.Duration { return idle }
+
+	if err := c.SetLowPower(ipnstate.LowPower{IdleAfter: time.Second}); err == nil {
+		t.Error("SetLowPower with a 1s idle time succeeded; want error")
+	}
+	if err := c.SetLowPower(ipnstate.LowPower{IdleAfter: 5 * time.Minute, OnBattery: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	idle = time.Minute
+	if c.lowPowerIdle() {
+		t.Error("low power idle after 1m; want not until 5m")
+	}
+	idle = 10 * time.Minute
+	c.onBattery.Store(false)
+	if c.lowPowerIdle() {
+		t.Error("low power idle while not on battery")
+	}
+	c.onBattery.Store(true)
+	if !c.lowPowerIdle() {
+		t.Error("not low power idle after 10m on battery")
+	}
+	if lp := c.LowPower(); !lp.Active || lp.IdleAfter != 5*time.Minute || !lp.OnBattery {
+		t.Errorf("LowPower = %+v; want active, 5m, on battery", lp)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest/natlab"
+	"tailscale.com/tstest/waitfor"
+	"tailscale.com/types/logger"
+)
+
+var (
+	flagNATMatrix       = flag.Bool("nat-matrix", false, "run the whole NAT traversal scenario matrix, which takes several minutes, rather than a few of its scenarios")
+	flagNATMatrixReport = flag.String("nat-matrix-report", "", "if non-empty, file to write the NAT traversal matrix connectivity report to, in Markdown")
+)
+
+// natSmokeScenarios are the scenarios of the NAT traversal matrix that
+// TestNATMatrix runs without -nat-matrix: three pairs that need hole
+// punching to get a direct path, and one that stays on DERP.
+var natSmokeScenarios = []natlab.Scenario{
+	{A: natlab.Side{}, B: natlab.Side{NAT: true, NATKind: natlab.SymmetricNAT}},
+	{A: natlab.Side{Firewall: true}, B: natlab.Side{NAT: true, NATKind: natlab.PortRestrictedConeNAT}},
+	{A: natlab.Side{NAT: true, NATKind: natlab.PortRestrictedConeNAT}, B: natlab.Side{NAT: true, NATKind: natlab.PortRestrictedConeNAT}},
+	{A: natlab.Side{NAT: true, NATKind: natlab.SymmetricNAT}, B: natlab.Side{NAT: true, NATKind: natlab.SymmetricNAT}},
+}
+
+// TestNATMatrix runs two magicsocks across scenarios of the NAT traversal
+// matrix in natlab, and checks that they end up talking directly or over
+// DERP as expected. By default it runs only natSmokeScenarios; with
+// -nat-matrix, it runs the whole matrix. It writes a connectivity report of
+// the scenarios to the test log and, if set, to the -nat-matrix-report
+// file.
+func TestNATMatrix(t *testing.T) {
+	scenarios := natlab.Matrix(natlab.DefaultSides)
+	if !*flagNATMatrix {
+		scenarios = nil
+		for _, sc := range natSmokeScenarios {
+			sc.Want = natlab.ExpectedOutcome(sc.A, sc.B)
+			scenarios = append(scenarios, sc)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		results []natlab.ScenarioResult
+	)
+	for _, sc := range scenarios {
+		t.Run(sc.Name(), func(t *testing.T) {
+			if sc.Want == natlab.OutcomeDERP && testing.Short() {
+				t.Skipf("skipping in short mode; waits %v for a direct path", natScenarioDirectTimeout)
+			}
+			t.Parallel()
+			r := runNATScenario(t, sc)
+			if !r.OK() {
+				t.Errorf("got %v, err %v; want %v", r.Got, r.Err, sc.Want)
+			}
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		})
+	}
+	// Parallel subtests only run once TestNATMatrix returns, so report
+	// from a cleanup, which runs after them.
+	t.Cleanup(func() {
+		// Report in matrix order, not completion order.
+		var ordered []natlab.ScenarioResult
+		for _, sc := range scenarios {
+			for _, r := range results {
+				if r.Scenario == sc {
+					ordered = append(ordered, r)
+				}
+			}
+		}
+		var buf bytes.Buffer
+		if err := natlab.WriteReport(&buf, ordered); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("NAT traversal matrix:\n%s", buf.Bytes())
+		if *flagNATMatrixReport != "" {
+			if err := os.WriteFile(*flagNATMatrixReport, buf.Bytes(), 0644); err != nil {
+				t.Errorf("writing report: %v", err)
+			}
+		}
+	})
+}
+
+// natScenarioDirectTimeout is how long runNATScenario waits for a direct
+// path before concluding that a scenario stays on DERP.
+const natScenarioDirectTimeout = 30 * time.Second
+
+// runNATScenario brings up two magicStacks across the network of sc, pings
+// between them and reports whether they found a direct path.
+func runNATScenario(t *testing.T, sc natlab.Scenario) natlab.ScenarioResult {
+	tlogf, setT := makeNestable(t)
+	setT(t)
+	logf, closeLogf := logger.LogfCloser(tlogf)
+	defer closeLogf()
+
+	res := natlab.ScenarioResult{Scenario: sc, Got: natlab.OutcomeDERP}
+	sn := sc.Build()
+
+	derpMap, cleanup := runDERPAndStun(t, logf, sn.STUN, sn.STUNIP)
+	defer cleanup()
+
+	m1 := newMagicStack(t, logger.WithPrefix(logf, "conn1: "), sn.A, derpMap)
+	defer m1.Close()
+	m2 := newMagicStack(t, logger.WithPrefix(logf, "conn2: "), sn.B, derpMap)
+	defer m2.Close()
+
+	cleanup = meshStacks(logf, nil, m1, m2)
+	defer cleanup()
+
+	start := time.Now()
+	cleanup = newPinger(t, logf, m1, m2)
+	defer cleanup()
+
+	// Both ends must see the direct path for traffic to flow over it.
+	addr, err := waitfor.DirectPath(natScenarioDirectTimeout, logf, m1.Status, m2.Public())
+	if err == nil {
+		_, err = waitfor.DirectPath(natScenarioDirectTimeout, logf, m2.Status, m1.Public())
+	}
+	res.Took = time.Since(start)
+	if err == nil {
+		logf("direct link %s->%s found with addr %s", m1, m2, addr)
+		res.Got = natlab.OutcomeDirect
+	}
+	if t.Failed() {
+		// newPinger lost a ping: the machines couldn't talk even over
+		// DERP.
+		res.Err = errLostPing
+	}
+	return res
+}
+
+var errLostPing = errors.New("lost ping")
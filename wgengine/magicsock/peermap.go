@@ -4,6 +4,7 @@
 package magicsock
 
 import (
+	"fmt"
 	"net/netip"
 
 	"tailscale.com/tailcfg"
@@ -140,7 +141,7 @@ func (m *peerMap) upsertEndpoint(ep *endpoint, oldDiscoKey key.DiscoPublic) {
 
 	epDisco := ep.disco.Load()
 	if epDisco == nil || oldDiscoKey != epDisco.key {
-		delete(m.nodesOfDisco[oldDiscoKey], ep.publicKey)
+		m.deleteNodeOfDisco(oldDiscoKey, ep.publicKey)
 	}
 	if ep.isWireguardOnly {
 		// If the peer is a WireGuard only peer, add all of its endpoints.
@@ -192,7 +193,7 @@ func (m *peerMap) deleteEndpoint(ep *endpoint) {
 
 	pi := m.byNodeKey[ep.publicKey]
 	if epDisco != nil {
-		delete(m.nodesOfDisco[epDisco.key], ep.publicKey)
+		m.deleteNodeOfDisco(epDisco.key, ep.publicKey)
 	}
 	delete(m.byNodeKey, ep.publicKey)
 	if was, ok := m.byNodeID[ep.nodeID]; ok && was.ep == ep {
@@ -207,3 +208,104 @@ func (m *peerMap) deleteEndpoint(ep *endpoint) {
 		delete(m.byIPPort, ip)
 	}
 }
+
+// deleteNodeOfDisco removes nk from the nodes using dk, and dk from
+// m.nodesOfDisco if no node uses it anymore, so that knownPeerDiscoKey
+// stops reporting it.
+func (m *peerMap) deleteNodeOfDisco(dk key.DiscoPublic, nk key.NodePublic) {
+	nodes, ok := m.nodesOfDisco[dk]
+	if !ok {
+		return
+	}
+	delete(nodes, nk)
+	if len(nodes) == 0 {
+		delete(m.nodesOfDisco, dk)
+	}
+}
+
+// validate checks m for internal consistency and reports the first error
+// encountered. It's meant for tests and debugging, so it isn't efficient.
+func (m *peerMap) validate() error {
+	seenEps := make(map[*endpoint]bool)
+	for pub, pi := range m.byNodeKey {
+		if got := pi.ep.publicKey; got != pub {
+			return fmt.Errorf("byNodeKey[%v].publicKey = %v", pub, got)
+		}
+		if _, ok := seenEps[pi.ep]; ok {
+			return fmt.Errorf("duplicate endpoint present: %v", pi.ep.publicKey)
+		}
+		seenEps[pi.ep] = true
+		for ipp := range pi.ipPorts {
+			if got := m.byIPPort[ipp]; got != pi {
+				return fmt.Errorf("m.byIPPort[%v] = %v, want %v", ipp, got, pi)
+			}
+		}
+		if pi.ep.isWireguardOnly {
+			continue
+		}
+		if d := pi.ep.disco.Load(); d == nil {
+			return fmt.Errorf("endpoint %v has no disco key", pub)
+		} else if !m.nodesOfDisco[d.key].Contains(pub) {
+			return fmt.Errorf("endpoint %v missing from nodesOfDisco[%v]", pub, d.short)
+		}
+	}
+	if len(m.byNodeKey) != len(m.byNodeID) {
+		return fmt.Errorf("len(m.byNodeKey)=%d != len(m.byNodeID)=%d", len(m.byNodeKey), len(m.byNodeID))
+	}
+	for nodeID, pi := range m.byNodeID {
+		ep := pi.ep
+		if ep.nodeID != nodeID {
+			return fmt.Errorf("byNodeID[%d] has nodeID %d", nodeID, ep.nodeID)
+		}
+		if pi2, ok := m.byNodeKey[ep.publicKey]; !ok {
+			return fmt.Errorf("nodeID %d in map with publicKey %v that's missing from map", nodeID, ep.publicKey)
+		} else if pi2 != pi {
+			return fmt.Errorf("nodeID %d in map with publicKey %v that points to different endpoint", nodeID, ep.publicKey)
+		}
+	}
+
+	for ipp, pi := range m.byIPPort {
+		if !pi.ipPorts.Contains(ipp) {
+			return fmt.Errorf("ipPorts[%v] for %v is false", ipp, pi.ep.publicKey)
+		}
+		pi2 := m.byNodeKey[pi.ep.publicKey]
+		if pi != pi2 {
+			return fmt.Errorf("byNodeKey[%v]=%p doesn't match byIPPort[%v]=%p", pi, pi, pi.ep.publicKey, pi2)
+		}
+	}
+
+	publicToDisco := make(map[key.NodePublic]key.DiscoPublic)
+	for disco, nodes := range m.nodesOfDisco {
+		if len(nodes) == 0 {
+			return fmt.Errorf("nodesOfDisco[%v] is empty", disco.ShortString())
+		}
+		for pub := range nodes {
+			pi, ok := m.byNodeKey[pub]
+			if !ok {
+				return fmt.Errorf("nodesOfDisco refers to public key %v, which is not present in byNodeKey", pub)
+			}
+			if d := pi.ep.disco.Load(); d == nil || d.key != disco {
+				return fmt.Errorf("nodesOfDisco[%v] refers to public key %v, which uses another disco key", disco.ShortString(), pub)
+			}
+			if _, ok := publicToDisco[pub]; ok {
+				return fmt.Errorf("publicKey %v refers to multiple disco keys", pub)
+			}
+			publicToDisco[pub] = disco
+		}
+	}
+
+	return nil
+}
+
+// CheckInvariants reports an error if c's indexes of peers (by node key,
+// node ID, disco key and ip:port) are inconsistent with each other, or if
+// the disco pacer's queue is. It's meant for tests and debugging; it's
+// slow with many peers, and holds c.mu while it runs.
+func (c *Conn) CheckInvariants() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.peerMap.validate(); err != nil {
+		return err
+	}
+	return c.discoPacer.validate()
+}
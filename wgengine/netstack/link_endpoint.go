@@ -264,6 +264,31 @@ func (l *linkEndpoint) injectInbound(p *packet.Parsed) {
 	pkt.DecRef()
 }
 
+// injectLoopback delivers pkt, an outbound packet that gVisor addressed to
+// one of its own addresses, back to gVisor as an inbound packet. It takes
+// ownership of one reference count on pkt.
+//
+// Unlike injectInbound, it doesn't validate checksums: the packet never left
+// the process, and its transport checksum may be left for offload.
+func (l *linkEndpoint) injectLoopback(pkt *stack.PacketBuffer) {
+	defer pkt.DecRef()
+	l.mu.RLock()
+	d := l.dispatcher
+	l.mu.RUnlock()
+	if d == nil {
+		return
+	}
+	v := stack.PayloadSince(pkt.NetworkHeader())
+	packetBuf := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(bytes.Clone(v.AsSlice())),
+	})
+	v.Release()
+	packetBuf.NetworkProtocolNumber = pkt.NetworkProtocolNumber
+	packetBuf.RXChecksumValidated = true
+	d.DeliverNetworkPacket(packetBuf.NetworkProtocolNumber, packetBuf)
+	packetBuf.DecRef()
+}
+
 // enqueueGRO enqueues the provided packet for GRO. It may immediately deliver
 // it to the underlying stack.NetworkDispatcher depending on its contents and if
 // GRO was initialized via newLinkEndpoint. To explicitly flush previously
@@ -232,6 +232,8 @@ type Impl struct {
 	// unfortunate that we have to track this all twice, but thankfully the
 	// map only holds pending (in-flight) packets, and it's reasonably cheap.
 	packetsInFlight map[stack.TransportEndpointID]struct{}
+	// tcpTuning is the TCP tuning most recently applied to ipstack.
+	tcpTuning TCPTuning
 }
 
 const nicID = 1
@@ -242,7 +244,7 @@ type Impl struct {
 // have a UDP packet as big as the MTU.
 const maxUDPPacketSize = tstun.MaxPacketSize
 
-func setTCPBufSizes(ipstack *stack.Stack) error {
+func setTCPBufSizes(ipstack *stack.Stack, rxMax, txMax int) error {
 	// tcpip.TCP{Receive,Send}BufferSizeRangeOption is gVisor's version of
 	// Linux's tcp_{r,w}mem. Application within gVisor differs as some Linux
 	// features are not (yet) implemented, and socket buffer memory is not
@@ -256,10 +258,10 @@ func setTCPBufSizes(ipstack *stack.Stack) error {
 		// for application by the TCP_WINDOW_CLAMP socket option.
 		Min: tcpRXBufMinSize,
 		// Default is used by gVisor at socket creation.
-		Default: tcpRXBufDefSize,
+		Default: min(tcpRXBufDefSize, rxMax),
 		// Max is used by gVisor to cap the advertised receive window post-read.
 		// (tcp_moderate_rcvbuf=true, the default).
-		Max: tcpRXBufMaxSize,
+		Max: rxMax,
 	}
 	tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRXBufOpt)
 	if tcpipErr != nil {
@@ -269,9 +271,9 @@ func setTCPBufSizes(ipstack *stack.Stack) error {
 		// Min in unused by gVisor at the time of writing.
 		Min: tcpTXBufMinSize,
 		// Default is used by gVisor at socket creation.
-		Default: tcpTXBufDefSize,
+		Default: min(tcpTXBufDefSize, txMax),
 		// Max is used by gVisor to cap the send window.
-		Max: tcpTXBufMaxSize,
+		Max: txMax,
 	}
 	tcpipErr = ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpTXBufOpt)
 	if tcpipErr != nil {
@@ -304,24 +306,8 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
 		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
 	})
-	sackEnabledOpt := tcpip.TCPSACKEnabled(true) // TCP SACK is disabled by default
-	tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &sackEnabledOpt)
-	if tcpipErr != nil {
-		return nil, fmt.Errorf("could not enable TCP SACK: %v", tcpipErr)
-	}
-	if runtime.GOOS == "windows" {
-		// See https://github.com/tailscale/tailscale/issues/9707
-		// Windows w/RACK performs poorly. ACKs do not appear to be handled in a
-		// timely manner, leading to spurious retransmissions and a reduced
-		// congestion window.
-		tcpRecoveryOpt := tcpip.TCPRecovery(0)
-		tcpipErr = ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRecoveryOpt)
-		if tcpipErr != nil {
-			return nil, fmt.Errorf("could not disable TCP RACK: %v", tcpipErr)
-		}
-	}
-	err := setTCPBufSizes(ipstack)
-	if err != nil {
+	tcpTuning := tcpTuningFromEnv()
+	if err := tcpTuning.apply(ipstack); err != nil {
 		return nil, err
 	}
 	var linkEP *linkEndpoint
@@ -375,6 +361,7 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		packetsInFlight:       make(map[stack.TransportEndpointID]struct{}),
 		dns:                   dns,
 		driveForLocal:         driveForLocal,
+		tcpTuning:             tcpTuning,
 	}
 	ns.ctx, ns.ctxCancel = context.WithCancel(context.Background())
 	ns.atomicIsLocalIPFunc.Store(ipset.FalseContainsIPFunc())
@@ -440,6 +427,11 @@ func (ns *Impl) wrapUDPProtocolHandler(h protocolHandlerFunc) protocolHandlerFun
 
 var (
 	metricPerClientForwardLimit = clientmetric.NewCounter("netstack_tcp_forward_dropped_attempts_per_client")
+
+	// metricLoopbackPackets counts the packets that netstack sent to this
+	// node's own Tailscale IPs, looped back without going through
+	// WireGuard.
+	metricLoopbackPackets = clientmetric.NewCounter("netstack_loopback_packets")
 )
 
 // wrapTCPProtocolHandler wraps the protocol handler we pass to netstack for TCP.
@@ -844,6 +836,16 @@ func (ns *Impl) inject() {
 		// be injected 'inbound'.
 		sendToHost := ns.shouldSendToHost(pkt)
 
+		// Other traffic to this node's own Tailscale IPs, such as
+		// from a tsnet server dialing itself, loops back into
+		// netstack without the round trip through WireGuard, which
+		// would drop it as there's no peer for it.
+		if !sendToHost && ns.shouldLoopback(pkt) {
+			metricLoopbackPackets.Add(1)
+			ns.linkEP.injectLoopback(pkt)
+			continue
+		}
+
 		// pkt has a non-zero refcount, so injection methods takes
 		// ownership of one count and will decrement on completion.
 		if sendToHost {
@@ -860,6 +862,25 @@ func (ns *Impl) inject() {
 	}
 }
 
+// shouldLoopback reports whether the provided outbound packet is addressed
+// to one of this node's own Tailscale IPs, and netstack handles those, in
+// which case it should be delivered straight back to netstack.
+func (ns *Impl) shouldLoopback(pkt *stack.PacketBuffer) bool {
+	if !ns.ProcessLocalIPs {
+		return false
+	}
+	var dstIP netip.Addr
+	switch v := pkt.Network().(type) {
+	case header.IPv4:
+		dstIP = netip.AddrFrom4(v.DestinationAddress().As4())
+	case header.IPv6:
+		dstIP = netip.AddrFrom16(v.DestinationAddress().As16())
+	default:
+		return false
+	}
+	return ns.isLocalIP(dstIP)
+}
+
 // shouldSendToHost determines if the provided packet should be sent to the
 // host (i.e the current machine running Tailscale), in which case it will
 // return true. It will return false if the packet should be sent outbound, for
@@ -955,6 +976,10 @@ func (ns *Impl) shouldProcessInbound(p *packet.Parsed, t *tstun.Wrapper) bool {
 			return true
 		}
 	}
+	// Handle UDP to the Tailscale IP(s) for ports being served.
+	if ns.lb != nil && p.IPProto == ipproto.UDP && isLocal && ns.lb.ShouldInterceptUDPPort(p.Dst.Port()) {
+		return true
+	}
 	if p.IPVersion == 6 && !isLocal && viaRange.Contains(dstIP) {
 		return ns.lb != nil && ns.lb.ShouldHandleViaIP(dstIP)
 	}
@@ -1445,6 +1470,13 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 		return
 	}
 
+	if ns.lb != nil {
+		if h := ns.lb.UDPHandlerForDst(srcAddr, dstAddr); h != nil {
+			go h(gonet.NewUDPConn(&wq, ep))
+			return
+		}
+	}
+
 	if get := ns.GetUDPHandlerForFlow; get != nil {
 		h, intercept := get(srcAddr, dstAddr)
 		if intercept {
@@ -17,6 +17,7 @@
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
@@ -931,6 +932,71 @@ func TestShouldSendToHost(t *testing.T) {
 	}
 }
 
+func TestShouldLoopback(t *testing.T) {
+	var (
+		selfIP4 = netip.MustParseAddr("100.64.1.2")
+		selfIP6 = netip.MustParseAddr("fd7a:115c:a1e0::123")
+	)
+
+	testCases := []struct {
+		name            string
+		processLocalIPs bool
+		src, dst        netip.AddrPort
+		want            bool
+	}{
+		{
+			name:            "to_self",
+			processLocalIPs: true,
+			src:             netip.AddrPortFrom(selfIP4, 12345),
+			dst:             netip.AddrPortFrom(selfIP4, 7777),
+			want:            true,
+		},
+		{
+			name:            "to_self_v6",
+			processLocalIPs: true,
+			src:             netip.AddrPortFrom(selfIP6, 12345),
+			dst:             netip.AddrPortFrom(selfIP6, 7777),
+			want:            true,
+		},
+		{
+			name:            "to_remote",
+			processLocalIPs: true,
+			src:             netip.AddrPortFrom(selfIP4, 12345),
+			dst:             netip.MustParseAddrPort("100.64.99.88:7777"),
+			want:            false,
+		},
+		// If netstack doesn't handle the local IPs, the host does.
+		{
+			name:            "to_self_not_processing_local_ips",
+			processLocalIPs: false,
+			src:             netip.AddrPortFrom(selfIP4, 12345),
+			dst:             netip.AddrPortFrom(selfIP4, 7777),
+			want:            false,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var pkt *stack.PacketBuffer
+			if tt.src.Addr().Is4() {
+				pkt = makeUDP4PacketBuffer(tt.src, tt.dst)
+			} else {
+				pkt = makeUDP6PacketBuffer(tt.src, tt.dst)
+			}
+
+			ns := makeNetstack(t, func(impl *Impl) {
+				impl.ProcessLocalIPs = tt.processLocalIPs
+				impl.atomicIsLocalIPFunc.Store(func(addr netip.Addr) bool {
+					return addr == selfIP4 || addr == selfIP6
+				})
+			})
+			if got := ns.shouldLoopback(pkt); got != tt.want {
+				t.Errorf("shouldLoopback returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func makeUDP4PacketBuffer(src, dst netip.AddrPort) *stack.PacketBuffer {
 	if !src.Addr().Is4() || !dst.Addr().Is4() {
 		panic("src and dst must be IPv4")
@@ -1012,3 +1078,61 @@ func makeUDP6PacketBuffer(src, dst netip.AddrPort) *stack.PacketBuffer {
 
 	return pkt
 }
+
+func TestSetTCPTuning(t *testing.T) {
+	ns := makeNetstack(t, nil)
+
+	tuning := TCPTuning{
+		RXBufMaxSize:      1 << 20,
+		SACK:              "false",
+		CongestionControl: "cubic",
+	}
+	if err := ns.SetTCPTuning(tuning); err != nil {
+		t.Fatalf("SetTCPTuning: %v", err)
+	}
+	if got := ns.TCPTuning(); got != tuning {
+		t.Errorf("TCPTuning = %+v; want %+v", got, tuning)
+	}
+
+	var cc tcpip.CongestionControlOption
+	if err := ns.ipstack.TransportProtocolOption(tcp.ProtocolNumber, &cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc != "cubic" {
+		t.Errorf("congestion control = %q; want cubic", cc)
+	}
+	var sack tcpip.TCPSACKEnabled
+	if err := ns.ipstack.TransportProtocolOption(tcp.ProtocolNumber, &sack); err != nil {
+		t.Fatal(err)
+	}
+	if sack {
+		t.Error("SACK enabled; want disabled")
+	}
+	var rx tcpip.TCPReceiveBufferSizeRangeOption
+	if err := ns.ipstack.TransportProtocolOption(tcp.ProtocolNumber, &rx); err != nil {
+		t.Fatal(err)
+	}
+	if rx.Max != 1<<20 || rx.Default > rx.Max {
+		t.Errorf("rx buf = %+v; want Max %d", rx, 1<<20)
+	}
+
+	if err := ns.SetTCPTuning(TCPTuning{CongestionControl: "bogus"}); err == nil {
+		t.Error("SetTCPTuning with bogus congestion control succeeded")
+	}
+	if got := ns.TCPTuning(); got != tuning {
+		t.Errorf("after failed SetTCPTuning, TCPTuning = %+v; want %+v", got, tuning)
+	}
+	// A single invalid option leaves all the others unchanged.
+	if err := ns.SetTCPTuning(TCPTuning{RXBufMaxSize: 1, CongestionControl: "reno"}); err == nil {
+		t.Error("SetTCPTuning with a tiny RX buf size succeeded")
+	}
+	if err := ns.ipstack.TransportProtocolOption(tcp.ProtocolNumber, &cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc != "cubic" {
+		t.Errorf("after failed SetTCPTuning, congestion control = %q; want cubic", cc)
+	}
+	if got := ns.TCPConnStats(); len(got) != 0 {
+		t.Errorf("TCPConnStats = %+v; want none", got)
+	}
+}
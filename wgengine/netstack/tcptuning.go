@@ -0,0 +1,239 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"tailscale.com/envknob"
+	"tailscale.com/types/opt"
+)
+
+var (
+	envTCPRXBufMax = envknob.RegisterInt("TS_NETSTACK_TCP_RXBUF_MAX")
+	envTCPTXBufMax = envknob.RegisterInt("TS_NETSTACK_TCP_TXBUF_MAX")
+	envTCPSACK     = envknob.RegisterOptBool("TS_NETSTACK_TCP_SACK")
+	envTCPRACK     = envknob.RegisterOptBool("TS_NETSTACK_TCP_RACK")
+	envTCPCC       = envknob.RegisterString("TS_NETSTACK_TCP_CC")
+)
+
+// TCPTuning holds tunables for netstack's TCP implementation.
+//
+// The zero value means to use the defaults for the current platform.
+type TCPTuning struct {
+	// RXBufMaxSize, if non-zero, is the maximum TCP receive buffer size
+	// in bytes. It caps the advertised receive window.
+	RXBufMaxSize int `json:",omitempty"`
+
+	// TXBufMaxSize, if non-zero, is the maximum TCP send buffer size in
+	// bytes. It caps the send window.
+	TXBufMaxSize int `json:",omitempty"`
+
+	// SACK is whether TCP selective acknowledgements are enabled.
+	// If unset, SACK is enabled.
+	SACK opt.Bool `json:",omitempty"`
+
+	// RACK is whether RACK-TLP loss detection is enabled.
+	// If unset, RACK is enabled on all platforms except Windows,
+	// where it performs poorly (tailscale/tailscale#9707).
+	RACK opt.Bool `json:",omitempty"`
+
+	// CongestionControl is the congestion control algorithm to use for
+	// new connections, either "reno" or "cubic". If empty, gVisor's
+	// default is used.
+	CongestionControl string `json:",omitempty"`
+}
+
+// tcpTuningFromEnv returns the TCPTuning configured by TS_NETSTACK_TCP_*
+// environment variables.
+func tcpTuningFromEnv() TCPTuning {
+	return TCPTuning{
+		RXBufMaxSize:      envTCPRXBufMax(),
+		TXBufMaxSize:      envTCPTXBufMax(),
+		SACK:              envTCPSACK(),
+		RACK:              envTCPRACK(),
+		CongestionControl: envTCPCC(),
+	}
+}
+
+// validate checks that ipstack would accept all of t's options, so that
+// apply doesn't leave it partly tuned.
+func (t TCPTuning) validate(ipstack *stack.Stack) error {
+	if t.RXBufMaxSize < 0 || t.TXBufMaxSize < 0 {
+		return fmt.Errorf("invalid TCP buffer sizes rx=%d tx=%d", t.RXBufMaxSize, t.TXBufMaxSize)
+	}
+	if t.RXBufMaxSize != 0 && t.RXBufMaxSize < tcpRXBufMinSize {
+		return fmt.Errorf("TCP RX buf size %d is below the minimum of %d", t.RXBufMaxSize, tcpRXBufMinSize)
+	}
+	if t.TXBufMaxSize != 0 && t.TXBufMaxSize < tcpTXBufMinSize {
+		return fmt.Errorf("TCP TX buf size %d is below the minimum of %d", t.TXBufMaxSize, tcpTXBufMinSize)
+	}
+	if t.CongestionControl != "" {
+		var avail tcpip.TCPAvailableCongestionControlOption
+		if tcpipErr := ipstack.TransportProtocolOption(tcp.ProtocolNumber, &avail); tcpipErr != nil {
+			return fmt.Errorf("could not get available TCP congestion control: %v", tcpipErr)
+		}
+		if !slices.Contains(strings.Fields(string(avail)), t.CongestionControl) {
+			return fmt.Errorf("unknown TCP congestion control %q; want one of %q", t.CongestionControl, avail)
+		}
+	}
+	return nil
+}
+
+// apply sets t's options on ipstack. It first validates all of them, so
+// that an invalid t leaves ipstack unchanged.
+func (t TCPTuning) apply(ipstack *stack.Stack) error {
+	if err := t.validate(ipstack); err != nil {
+		return err
+	}
+	if t.CongestionControl != "" {
+		ccOpt := tcpip.CongestionControlOption(t.CongestionControl)
+		if tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &ccOpt); tcpipErr != nil {
+			return fmt.Errorf("could not set TCP congestion control %q: %v", t.CongestionControl, tcpipErr)
+		}
+	}
+	sack, ok := t.SACK.Get()
+	if !ok {
+		sack = true // TCP SACK is disabled by default in gVisor
+	}
+	sackEnabledOpt := tcpip.TCPSACKEnabled(sack)
+	if tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &sackEnabledOpt); tcpipErr != nil {
+		return fmt.Errorf("could not set TCP SACK: %v", tcpipErr)
+	}
+	rack, ok := t.RACK.Get()
+	if !ok {
+		rack = runtime.GOOS != "windows"
+	}
+	tcpRecoveryOpt := tcpip.TCPRecovery(0)
+	if rack {
+		tcpRecoveryOpt = tcpip.TCPRACKLossDetection
+	}
+	if tcpipErr := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &tcpRecoveryOpt); tcpipErr != nil {
+		return fmt.Errorf("could not set TCP RACK: %v", tcpipErr)
+	}
+	return setTCPBufSizes(ipstack, cmp.Or(t.RXBufMaxSize, tcpRXBufMaxSize), cmp.Or(t.TXBufMaxSize, tcpTXBufMaxSize))
+}
+
+// TCPTuning returns the TCP tuning currently in use.
+func (ns *Impl) TCPTuning() TCPTuning {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.tcpTuning
+}
+
+// SetTCPTuning replaces the TCP tuning, which is initially set from
+// TS_NETSTACK_TCP_* environment variables or tailscaled's
+// --netstack-tcp-tuning flag. If t is invalid, the tuning is left
+// unchanged. Buffer sizes and congestion control apply only to connections
+// created after the call.
+func (ns *Impl) SetTCPTuning(t TCPTuning) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if err := t.apply(ns.ipstack); err != nil {
+		return err
+	}
+	ns.tcpTuning = t
+	return nil
+}
+
+// TCPConnStats are statistics for a single netstack TCP connection.
+type TCPConnStats struct {
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+	State  string
+
+	RTT    time.Duration // smoothed round trip time
+	RTTVar time.Duration // round trip time variation
+	RTO    time.Duration // retransmission timeout
+
+	CongestionState string // congestion control state; see congestionStateString
+	SndCwnd         uint32 // congestion window, in packets
+	SndSsthresh     uint32 // slow start threshold, in packets
+	ReorderSeen     bool   // whether reordering was detected
+
+	SegmentsSent     uint64
+	SegmentsReceived uint64
+	Retransmits      uint64
+	FastRetransmits  uint64
+	Timeouts         uint64
+}
+
+// TCPConnStats returns statistics for each TCP connection currently
+// registered with the netstack.
+func (ns *Impl) TCPConnStats() []TCPConnStats {
+	var ret []TCPConnStats
+	for _, tep := range ns.ipstack.RegisteredEndpoints() {
+		ep, ok := tep.(*tcp.Endpoint)
+		if !ok {
+			continue
+		}
+		info, ok := ep.Info().(*stack.TransportEndpointInfo)
+		if !ok {
+			continue
+		}
+		var ti tcpip.TCPInfoOption
+		if err := ep.GetSockOpt(&ti); err != nil {
+			continue
+		}
+		st := ep.Stats().(*tcp.Stats)
+		cs := TCPConnStats{
+			State:            tcp.EndpointState(ep.State()).String(),
+			RTT:              ti.RTT,
+			RTTVar:           ti.RTTVar,
+			RTO:              ti.RTO,
+			CongestionState:  congestionStateString(ti.CcState),
+			SndCwnd:          ti.SndCwnd,
+			SndSsthresh:      ti.SndSsthresh,
+			ReorderSeen:      ti.ReorderSeen,
+			SegmentsSent:     st.SegmentsSent.Value(),
+			SegmentsReceived: st.SegmentsReceived.Value(),
+			Retransmits:      st.SendErrors.Retransmits.Value(),
+			FastRetransmits:  st.SendErrors.FastRetransmit.Value(),
+			Timeouts:         st.SendErrors.Timeouts.Value(),
+		}
+		cs.Local, _ = ipPortOfNetstackAddr(info.ID.LocalAddress, info.ID.LocalPort)
+		cs.Remote, _ = ipPortOfNetstackAddr(info.ID.RemoteAddress, info.ID.RemotePort)
+		ret = append(ret, cs)
+	}
+	return ret
+}
+
+func congestionStateString(s tcpip.CongestionControlState) string {
+	switch s {
+	case tcpip.Open:
+		return "open"
+	case tcpip.RTORecovery:
+		return "rto-recovery"
+	case tcpip.FastRecovery:
+		return "fast-recovery"
+	case tcpip.SACKRecovery:
+		return "sack-recovery"
+	case tcpip.Disorder:
+		return "disorder"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// ServeHTTPDebugTCP serves the current TCP tuning and per-connection
+// statistics as JSON.
+func (ns *Impl) ServeHTTPDebugTCP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(struct {
+		Tuning TCPTuning
+		Conns  []TCPConnStats
+	}{ns.TCPTuning(), ns.TCPConnStats()})
+}
@@ -53,8 +53,11 @@ func New(logf logger.Logf, tundev tun.Device, netMon *netmon.Monitor, health *he
 // CleanUp restores the system network configuration to its original state
 // in case the Tailscale daemon terminated without closing the router.
 // No other state needs to be instantiated before this runs.
-func CleanUp(logf logger.Logf, netMon *netmon.Monitor, interfaceName string) {
-	cleanUp(logf, interfaceName)
+//
+// It returns an error if the configuration might not have been fully
+// restored.
+func CleanUp(logf logger.Logf, netMon *netmon.Monitor, interfaceName string) error {
+	return cleanUp(logf, interfaceName)
 }
 
 // Config is the subset of Tailscale configuration that is relevant to
@@ -14,6 +14,7 @@ func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Moni
 	return newUserspaceBSDRouter(logf, tundev, netMon, health)
 }
 
-func cleanUp(logger.Logf, string) {
+func cleanUp(logger.Logf, string) error {
 	// Nothing to do.
+	return nil
 }
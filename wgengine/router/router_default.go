@@ -19,6 +19,7 @@ func newUserspaceRouter(logf logger.Logf, tunDev tun.Device, netMon *netmon.Moni
 	return nil, fmt.Errorf("unsupported OS %q", runtime.GOOS)
 }
 
-func cleanUp(logf logger.Logf, interfaceName string) {
+func cleanUp(logf logger.Logf, interfaceName string) error {
 	// Nothing to do here.
+	return nil
 }
@@ -4,6 +4,9 @@
 package router
 
 import (
+	"fmt"
+	"net"
+
 	"github.com/tailscale/wireguard-go/tun"
 	"tailscale.com/health"
 	"tailscale.com/net/netmon"
@@ -19,7 +22,7 @@ func newUserspaceRouter(logf logger.Logf, tundev tun.Device, netMon *netmon.Moni
 	return newUserspaceBSDRouter(logf, tundev, netMon, health)
 }
 
-func cleanUp(logf logger.Logf, interfaceName string) {
+func cleanUp(logf logger.Logf, interfaceName string) error {
 	// If the interface was left behind, ifconfig down will not remove it.
 	// In fact, this will leave a system in a tainted state where starting tailscaled
 	// will result in "interface tailscale0 already exists"
@@ -27,5 +30,10 @@ func cleanUp(logf logger.Logf, interfaceName string) {
 	ifup := []string{"ifconfig", interfaceName, "destroy"}
 	if out, err := cmd(ifup...).CombinedOutput(); err != nil {
 		logf("ifconfig destroy: %v\n%s", err, out)
+		// It's only a failure if the interface is still there.
+		if _, ierr := net.InterfaceByName(interfaceName); ierr == nil {
+			return fmt.Errorf("ifconfig destroy: %w", err)
+		}
 	}
+	return nil
 }
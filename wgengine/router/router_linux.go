@@ -1515,11 +1515,11 @@ func platformCanNetfilter() bool {
 // cleanUp removes all the rules and routes that were added by the linux router.
 // The function calls cleanUp for both iptables and nftables since which ever
 // netfilter runner is used, the cleanUp function for the other one doesn't do anything.
-func cleanUp(logf logger.Logf, interfaceName string) {
+func cleanUp(logf logger.Logf, interfaceName string) error {
 	if interfaceName != "userspace-networking" && platformCanNetfilter() {
-		linuxfw.IPTablesCleanUp(logf)
-		linuxfw.NfTablesCleanUp(logf)
+		return errors.Join(linuxfw.IPTablesCleanUp(logf), linuxfw.NfTablesCleanUp(logf))
 	}
+	return nil
 }
 
 // Checks if the running openWRT system is using mwan3, based on the heuristic
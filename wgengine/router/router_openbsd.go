@@ -7,6 +7,7 @@
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/netip"
 	"os/exec"
 
@@ -241,9 +242,14 @@ func (r *openbsdRouter) Close() error {
 	return nil
 }
 
-func cleanUp(logf logger.Logf, interfaceName string) {
+func cleanUp(logf logger.Logf, interfaceName string) error {
 	out, err := cmd("ifconfig", interfaceName, "down").CombinedOutput()
 	if err != nil {
 		logf("ifconfig down: %v\n%s", err, out)
+		// It's only a failure if the interface is still there.
+		if _, ierr := net.InterfaceByName(interfaceName); ierr == nil {
+			return fmt.Errorf("ifconfig down: %w", err)
+		}
 	}
+	return nil
 }
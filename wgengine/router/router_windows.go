@@ -123,8 +123,9 @@ func (r *winRouter) Close() error {
 	return nil
 }
 
-func cleanUp(logf logger.Logf, interfaceName string) {
+func cleanUp(logf logger.Logf, interfaceName string) error {
 	// Nothing to do here.
+	return nil
 }
 
 // firewallTweaker changes the Windows firewall. Normally this wouldn't be so complicated,
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/logger"
+)
+
+// CleanupState describes the changes a userspace engine may have made to
+// the system's network configuration. The engine persists it to
+// Config.CleanupSentinelPath before making those changes and clears it
+// once it has undone them on a clean shutdown, so a state that is still
+// dirty at startup means the previous engine crashed or was killed.
+type CleanupState struct {
+	PID       int    // process ID of the engine that wrote the state
+	Interface string // name of the TUN interface the changes apply to
+
+	// Routes is whether routes, policy routing rules or firewall rules
+	// may have been installed.
+	Routes bool `json:",omitempty"`
+
+	// DNS is whether the OS DNS configuration may have been modified
+	// (and, on some platforms, backed up to be restored later).
+	DNS bool `json:",omitempty"`
+
+	// StaleInterface, if non-empty, is the name of the differently-named
+	// TUN interface of an earlier engine whose changes couldn't be
+	// cleaned up at startup.
+	StaleInterface string `json:",omitempty"`
+}
+
+// Dirty reports whether s describes system changes that have not been
+// cleaned up.
+func (s CleanupState) Dirty() bool {
+	return s.Routes || s.DNS || s.StaleInterface != ""
+}
+
+// ReadCleanupState reads the CleanupState written to path by a previous
+// engine. If path doesn't exist, it returns an error satisfying
+// errors.Is(err, fs.ErrNotExist).
+func ReadCleanupState(path string) (CleanupState, error) {
+	var s CleanupState
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// cleanupSentinel keeps the CleanupState file of a running engine up to
+// date. A nil *cleanupSentinel is valid and does nothing.
+type cleanupSentinel struct {
+	logf logger.Logf
+	path string
+
+	mu sync.Mutex
+	st CleanupState
+}
+
+func newCleanupSentinel(logf logger.Logf, path, ifName string) *cleanupSentinel {
+	if path == "" {
+		return nil
+	}
+	s := &cleanupSentinel{
+		logf: logf,
+		path: path,
+		st: CleanupState{
+			PID:       os.Getpid(),
+			Interface: ifName,
+		},
+	}
+	// A state that's still dirty wasn't cleaned up at startup. Keep it
+	// recorded so that the next startup retries.
+	if old, err := ReadCleanupState(path); err == nil && old.Dirty() {
+		s.st.StaleInterface = old.StaleInterface
+		if old.Interface == ifName {
+			s.st.Routes, s.st.DNS = old.Routes, old.DNS
+		} else if old.Routes || old.DNS {
+			s.st.StaleInterface = old.Interface
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeLocked()
+	return s
+}
+
+// update calls f to modify the recorded state and, if it changed, writes
+// it out before returning.
+func (s *cleanupSentinel) update(f func(*CleanupState)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.st
+	f(&s.st)
+	if s.st != old {
+		s.writeLocked()
+	}
+}
+
+func (s *cleanupSentinel) writeLocked() {
+	b, err := json.Marshal(s.st)
+	if err != nil {
+		panic(err) // can't happen
+	}
+	if err := atomicfile.WriteFile(s.path, b, 0600); err != nil {
+		s.logf("wgengine: writing cleanup sentinel: %v", err)
+	}
+}
@@ -16,6 +16,7 @@
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tailscale/wireguard-go/device"
@@ -46,6 +47,7 @@
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/deephash"
+	"tailscale.com/util/execqueue"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
 	"tailscale.com/util/testenv"
@@ -105,9 +107,14 @@ type userspaceEngine struct {
 	netMonUnregister func()              // unsubscribes from changes; used regardless of netMonOwned
 	birdClient       BIRDClient          // or nil
 	controlKnobs     *controlknobs.Knobs // or nil
+	sentinel         *cleanupSentinel    // or nil
 
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
 
+	// debugLogging is whether each change in a Reconfig is logged; see
+	// SetDebugLoggingEnabled.
+	debugLogging atomic.Bool
+
 	// isLocalAddr reports the whether an IP is assigned to the local
 	// tunnel interface. It's used to reflect local packets
 	// incorrectly sent to us.
@@ -120,9 +127,11 @@ type userspaceEngine struct {
 	wgLock              sync.Mutex // serializes all wgdev operations; see lock order comment below
 	lastCfgFull         wgcfg.Config
 	lastNMinPeers       int
-	lastRouterSig       deephash.Sum // of router.Config
-	lastEngineSigFull   deephash.Sum // of full wireguard config
-	lastEngineSigTrim   deephash.Sum // of trimmed wireguard config
+	lastRouterSig       deephash.Sum  // of router.Config
+	lastRoutesSig       deephash.Sum  // of router.Config's LocalAddrs and Routes
+	lastEngineSigFull   deephash.Sum  // of full wireguard config
+	lastEngineSigTrim   deephash.Sum  // of trimmed wireguard config
+	lastDevCfg          *wgcfg.Config // applied config last given to wgdev, or nil if unknown
 	lastDNSConfig       *dns.Config
 	lastIsSubnetRouter  bool // was the node a primary subnet router in the last run.
 	recvActivityAt      map[key.NodePublic]mono.Time
@@ -132,13 +141,16 @@ type userspaceEngine struct {
 	lastStatusPollTime  mono.Time    // last time we polled the engine status
 	reconfigureVPN      func() error // or nil
 
-	mu             sync.Mutex         // guards following; see lock order comment below
-	netMap         *netmap.NetworkMap // or nil
-	closing        bool               // Close was called (even if we're still closing)
-	statusCallback StatusCallback
-	peerSequence   []key.NodePublic
-	endpoints      []tailcfg.Endpoint
-	pendOpen       map[flowtrack.Tuple]*pendingOpenFlow // see pendopen.go
+	// lastPeers are the peers as of the last successful Reconfig, which
+	// PeerUpEvents and PeerDownEvents report changes from.
+	lastPeers set.Set[key.NodePublic]
+
+	mu           sync.Mutex         // guards following; see lock order comment below
+	netMap       *netmap.NetworkMap // or nil
+	closing      bool               // Close was called (even if we're still closing)
+	peerSequence []key.NodePublic
+	endpoints    []tailcfg.Endpoint
+	pendOpen     map[flowtrack.Tuple]*pendingOpenFlow // see pendopen.go
 
 	// pongCallback is the map of response handlers waiting for disco or TSMP
 	// pong callbacks. The map key is a random slice of bytes.
@@ -151,6 +163,16 @@ type userspaceEngine struct {
 	// networkLogger logs statistics about network connections.
 	networkLogger netlog.Logger
 
+	// eventQueue delivers published events to subs, one at a time and
+	// in the order they were published.
+	eventQueue execqueue.ExecQueue
+	subsMu     sync.Mutex // guards subs; never held while calling out
+	subs       set.HandleSet[EventCallback]
+
+	// unsubStatusCallback, if non-nil, removes the subscription made by
+	// SetStatusCallback. It's guarded by mu.
+	unsubStatusCallback func()
+
 	// Lock ordering: magicsock.Conn.mu, wgLock, then mu.
 }
 
@@ -207,6 +229,11 @@ type Config struct {
 	// If zero, a port is automatically selected.
 	ListenPort uint16
 
+	// DisableIPv4 and DisableIPv6, if true, disable all use of IPv4 or
+	// IPv6 respectively for peer and DERP connections. See the
+	// magicsock.Options fields of the same names.
+	DisableIPv4, DisableIPv6 bool
+
 	// RespondToPing determines whether this engine should internally
 	// reply to ICMP pings, without involving the OS.
 	// Used in "fake" mode for development.
@@ -222,6 +249,12 @@ type Config struct {
 	// DriveForLocal, if populated, will cause the engine to expose a Taildrive
 	// listener at 100.100.100.100:8080.
 	DriveForLocal drive.FileSystemForLocal
+
+	// CleanupSentinelPath, if non-empty, is the path of a file in which the
+	// engine records which system changes (routes, DNS) it is about to make
+	// before making them, and which it marks clean once Close has undone
+	// them. See ReadCleanupState.
+	CleanupSentinelPath string
 }
 
 // NewFakeUserspaceEngine returns a new userspace engine for testing.
@@ -324,6 +357,10 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		reconfigureVPN: conf.ReconfigureVPN,
 		health:         conf.HealthTracker,
 	}
+	if conf.CleanupSentinelPath != "" {
+		tunName, _ := conf.Tun.Name()
+		e.sentinel = newCleanupSentinel(logf, conf.CleanupSentinelPath, tunName)
+	}
 
 	if e.birdClient != nil {
 		// Disable the protocol at start time.
@@ -368,6 +405,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		e.endpoints = append(e.endpoints[:0], endpoints...)
 		e.mu.Unlock()
 
+		e.publish(EndpointsEvent{Endpoints: slices.Clone(endpoints)})
 		e.RequestStatus()
 	}
 	onPortUpdate := func(port uint16, network string) {
@@ -382,6 +420,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		Port:             conf.ListenPort,
 		EndpointsFunc:    endpointsFn,
 		DERPActiveFunc:   e.RequestStatus,
+		DERPHomeFunc:     func(regionID int) { e.publish(DERPHomeEvent{RegionID: regionID}) },
 		IdleFunc:         e.tundev.IdleDuration,
 		NoteRecvActivity: e.noteRecvActivity,
 		NetMon:           e.netMon,
@@ -389,6 +428,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		ControlKnobs:     conf.ControlKnobs,
 		OnPortUpdate:     onPortUpdate,
 		PeerByKeyFunc:    e.PeerByKey,
+		DisableIPv4:      conf.DisableIPv4,
+		DisableIPv6:      conf.DisableIPv6,
 	}
 
 	var err error
@@ -488,6 +529,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		return nil, fmt.Errorf("wgdev.Up: %w", err)
 	}
 	e.logf("Bringing router up...")
+	e.sentinel.update(func(s *CleanupState) { s.Routes = true })
 	if err := e.router.Up(); err != nil {
 		return nil, fmt.Errorf("router.Up: %w", err)
 	}
@@ -588,12 +630,19 @@ func (e *userspaceEngine) forceFullWireguardConfig(numPeers int) bool {
 // For implementation simplicity, we can only trim peers that have
 // only non-subnet AllowedIPs (an IPv4 /32 or IPv6 /128), which is the
 // common case for most peers. Subnet router nodes will just always be
-// created in the wireguard-go config.
+// created in the wireguard-go config, as will peers with a persistent
+// keepalive.
 func (e *userspaceEngine) isTrimmablePeer(p *wgcfg.Peer, numPeers int) bool {
 	if e.forceFullWireguardConfig(numPeers) {
 		return false
 	}
 
+	// Peers with a persistent keepalive must stay in wireguard-go's
+	// config to send it.
+	if p.PersistentKeepalive != 0 {
+		return false
+	}
+
 	// AllowedIPs must all be single IPs, not subnets.
 	for _, aip := range p.AllowedIPs {
 		if !aip.IsSingleIP() {
@@ -754,7 +803,7 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		}
 		if numRemove > 0 {
 			e.logf("wgengine: Reconfig: removing session keys for %d peers", numRemove)
-			if err := wgcfg.ReconfigDevice(e.wgdev, &minner, e.logf); err != nil {
+			if err := e.reconfigDeviceLocked(&minner); err != nil {
 				e.logf("wgdev.Reconfig: %v", err)
 				return err
 			}
@@ -762,13 +811,35 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 	}
 
 	e.logf("wgengine: Reconfig: configuring userspace WireGuard config (with %d/%d peers)", len(min.Peers), len(full.Peers))
-	if err := wgcfg.ReconfigDevice(e.wgdev, &min, e.logf); err != nil {
+	if err := e.reconfigDeviceLocked(&min); err != nil {
 		e.logf("wgdev.Reconfig: %v", err)
 		return err
 	}
 	return nil
 }
 
+// reconfigDeviceLocked writes cfg to e.wgdev. It writes only the peers that
+// changed since the previous call, without reading wgdev's whole config,
+// unless the previous call failed.
+//
+// e.wgLock must be held.
+func (e *userspaceEngine) reconfigDeviceLocked(cfg *wgcfg.Config) error {
+	var err error
+	if prev := e.lastDevCfg; prev != nil {
+		err = wgcfg.ReconfigDeviceFrom(e.wgdev, prev, cfg, e.logf)
+	} else {
+		err = wgcfg.ReconfigDevice(e.wgdev, cfg, e.logf)
+	}
+	if err != nil {
+		// wgdev may have been partially reconfigured; read its
+		// config back next time.
+		e.lastDevCfg = nil
+		return err
+	}
+	e.lastDevCfg = cfg.Applied()
+	return nil
+}
+
 // updateActivityMapsLocked updates the data structures used for tracking the activity
 // of wireguard peers that we might add/remove dynamically from the real config
 // as given to wireguard-go.
@@ -847,7 +918,7 @@ func hasOverlap(aips, rips views.Slice[netip.Prefix]) bool {
 	return false
 }
 
-func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config, dnsCfg *dns.Config) error {
+func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config, dnsCfg *dns.Config) (err error) {
 	if routerCfg == nil {
 		panic("routerCfg must not be nil")
 	}
@@ -857,6 +928,11 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 
 	e.isLocalAddr.Store(ipset.NewContainsIPFunc(views.SliceOf(routerCfg.LocalAddrs)))
 
+	// Events are published once wgLock is released, peer events only if
+	// the reconfiguration succeeds.
+	var events []Event
+	defer func() { e.publish(events...) }()
+
 	e.wgLock.Lock()
 	defer e.wgLock.Unlock()
 	e.tundev.SetWGConfig(cfg)
@@ -871,8 +947,31 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	}
 	nm := e.netMap
 	e.mu.Unlock()
+	defer func() {
+		if err != nil && err != ErrNoChanges {
+			// The peers are reported once a Reconfig with them
+			// succeeds.
+			return
+		}
+		var peerEvents []Event
+		for _, p := range cfg.Peers {
+			if !e.lastPeers.Contains(p.PublicKey) {
+				peerEvents = append(peerEvents, PeerUpEvent{NodeKey: p.PublicKey})
+			}
+		}
+		for k := range e.lastPeers {
+			if !peerSet.Contains(k) {
+				peerEvents = append(peerEvents, PeerDownEvent{NodeKey: k})
+			}
+		}
+		e.lastPeers = peerSet
+		events = append(peerEvents, events...)
+	}()
 
 	listenPort := e.confListenPort
+	if cfg.ListenPort != 0 {
+		listenPort = cfg.ListenPort
+	}
 	if e.controlKnobs != nil && e.controlKnobs.RandomizeClientPort.Load() {
 		listenPort = 0
 	}
@@ -940,6 +1039,15 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		}
 	}
 
+	if engineChanged {
+		if diff := wgcfg.DiffConfigs(&e.lastCfgFull, cfg); !diff.IsZero() {
+			e.logf("wgengine: Reconfig: %v", diff)
+			if e.debugLogging.Load() {
+				diff.Log(e.logf, "wgengine: Reconfig: ")
+			}
+		}
+	}
+
 	e.lastCfgFull = *cfg.Clone()
 
 	// Tell magicsock about the new (or initial) private key
@@ -989,10 +1097,19 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		if err != nil {
 			return err
 		}
+		if deephash.Update(&e.lastRoutesSig, &struct {
+			LocalAddrs, Routes []netip.Prefix
+		}{routerCfg.LocalAddrs, routerCfg.Routes}) {
+			events = append(events, RoutesEvent{
+				LocalAddrs: slices.Clone(routerCfg.LocalAddrs),
+				Routes:     slices.Clone(routerCfg.Routes),
+			})
+		}
 		// Keep DNS configuration after router configuration, as some
 		// DNS managers refuse to apply settings if the device has no
 		// assigned address.
 		e.logf("wgengine: Reconfig: configuring DNS")
+		e.sentinel.update(func(s *CleanupState) { s.DNS = true })
 		err = e.dns.Set(*dnsCfg)
 		e.health.SetDNSHealth(err)
 		if err != nil {
@@ -1051,16 +1168,57 @@ func (e *userspaceEngine) SetJailedFilter(filt *filter.Filter) {
 	e.tundev.SetJailedFilter(filt)
 }
 
+func (e *userspaceEngine) Subscribe(cb EventCallback) (unsubscribe func()) {
+	e.subsMu.Lock()
+	defer e.subsMu.Unlock()
+	handle := e.subs.Add(cb)
+	return func() {
+		e.subsMu.Lock()
+		defer e.subsMu.Unlock()
+		delete(e.subs, handle)
+	}
+}
+
+// SetStatusCallback implements Engine.
+//
+// Deprecated: use Subscribe.
 func (e *userspaceEngine) SetStatusCallback(cb StatusCallback) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.statusCallback = cb
+	if e.unsubStatusCallback != nil {
+		e.unsubStatusCallback()
+		e.unsubStatusCallback = nil
+	}
+	if cb == nil {
+		return
+	}
+	e.unsubStatusCallback = e.Subscribe(func(ev Event) {
+		if se, ok := ev.(StatusEvent); ok {
+			cb(se.Status, se.Err)
+		}
+	})
 }
 
-func (e *userspaceEngine) getStatusCallback() StatusCallback {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	return e.statusCallback
+// publish queues evs to be delivered to the subscribed callbacks, in
+// order after all previously published events. It doesn't block, so it
+// may be called with any lock held.
+func (e *userspaceEngine) publish(evs ...Event) {
+	if len(evs) == 0 {
+		return
+	}
+	e.eventQueue.Add(func() {
+		e.subsMu.Lock()
+		cbs := make([]EventCallback, 0, len(e.subs))
+		for _, cb := range e.subs {
+			cbs = append(cbs, cb)
+		}
+		e.subsMu.Unlock()
+		for _, ev := range evs {
+			for _, cb := range cbs {
+				cb(ev)
+			}
+		}
+	})
 }
 
 var ErrEngineClosing = errors.New("engine closing; no status")
@@ -1150,13 +1308,28 @@ func (e *userspaceEngine) RequestStatus() {
 			e.logf("[unexpected] RequestStatus: both s and err are nil")
 			return
 		}
-		if cb := e.getStatusCallback(); cb != nil {
-			cb(s, err)
-		}
+		e.publish(StatusEvent{Status: s, Err: err})
 	default:
 	}
 }
 
+// closeStepTimeout is how long each step of userspaceEngine.Close may run
+// before Close gives up waiting on it, logs it as forcibly abandoned, and
+// moves on to the next step. It's a var for tests.
+var closeStepTimeout = 5 * time.Second
+
+// Close shuts the engine down in two phases.
+//
+// The first phase stops the data plane: WireGuard peers are removed and
+// magicsock stops sending and receiving, so no more packets flow and no
+// more state changes are triggered by the network.
+//
+// The second phase undoes the changes made to the system (DNS, routes,
+// BIRD) and only then releases the WireGuard and TUN devices, which the
+// router may still need in order to clean up.
+//
+// Each step is bounded by closeStepTimeout so that one stuck subsystem
+// can't prevent the others from cleaning up.
 func (e *userspaceEngine) Close() {
 	e.mu.Lock()
 	if e.closing {
@@ -1166,21 +1339,39 @@ func (e *userspaceEngine) Close() {
 	e.closing = true
 	e.mu.Unlock()
 
-	r := bufio.NewReader(strings.NewReader(""))
-	e.wgdev.IpcSetOperation(r)
-	e.magicConn.Close()
+	// Phase 1: stop the data plane.
+	e.closeStep("wireguard-peers", func() error {
+		return e.wgdev.IpcSetOperation(bufio.NewReader(strings.NewReader("")))
+	})
+	e.closeStep("magicsock", e.magicConn.Close)
 	e.netMonUnregister()
 	if e.netMonOwned {
-		e.netMon.Close()
+		e.closeStep("netmon", e.netMon.Close)
 	}
-	e.dns.Down()
-	e.router.Close()
-	e.wgdev.Close()
-	e.tundev.Close()
+
+	// Phase 2: undo system changes, then release devices. The cleanup
+	// sentinel is only cleared if that fully succeeded, so that the next
+	// startup can retry otherwise.
+	dnsOK := e.closeStep("dns", e.dns.Down)
+	routerOK := e.closeStep("router", e.router.Close)
+	e.sentinel.update(func(s *CleanupState) {
+		s.DNS = s.DNS && !dnsOK
+		s.Routes = s.Routes && !routerOK
+	})
 	if e.birdClient != nil {
-		e.birdClient.DisableProtocol("tailscale")
-		e.birdClient.Close()
+		e.closeStep("bird", func() error {
+			if err := e.birdClient.DisableProtocol("tailscale"); err != nil {
+				return err
+			}
+			return e.birdClient.Close()
+		})
 	}
+	e.closeStep("wireguard-device", func() error {
+		e.wgdev.Close()
+		return nil
+	})
+	e.closeStep("tun", e.tundev.Close)
+	e.eventQueue.Shutdown()
 	close(e.waitCh)
 
 	ctx, cancel := context.WithTimeout(context.Background(), networkLoggerUploadTimeout)
@@ -1190,6 +1381,29 @@ func (e *userspaceEngine) Close() {
 	}
 }
 
+// closeStep runs the named shutdown step fn, logging any error it returns.
+// If fn doesn't return within closeStepTimeout, closeStep logs that the
+// step was forcibly abandoned and returns without waiting for it.
+// It reports whether fn finished without error.
+func (e *userspaceEngine) closeStep(name string, fn func() error) (ok bool) {
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+	timer := time.NewTimer(closeStepTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			e.logf("wgengine: Close: %s: %v", name, err)
+			return false
+		}
+		return true
+	case <-timer.C:
+		e.logf("wgengine: Close: %s did not finish after %v; abandoning it and forcing cleanup to continue", name, closeStepTimeout)
+		metricCloseStepAbandoned.Add(1)
+		return false
+	}
+}
+
 func (e *userspaceEngine) Done() <-chan struct{} {
 	return e.waitCh
 }
@@ -1579,8 +1793,16 @@ func (ls fwdDNSLinkSelector) PickLink(ip netip.Addr) (linkName string) {
 
 	metricNumMajorChanges = clientmetric.NewCounter("wgengine_major_changes")
 	metricNumMinorChanges = clientmetric.NewCounter("wgengine_minor_changes")
+
+	metricCloseStepAbandoned = clientmetric.NewCounter("wgengine_close_step_abandoned")
 )
 
+// SetDebugLoggingEnabled sets whether each peer added, removed or changed
+// by a Reconfig is logged, in addition to the summary that always is.
+func (e *userspaceEngine) SetDebugLoggingEnabled(v bool) {
+	e.debugLogging.Store(v)
+}
+
 func (e *userspaceEngine) InstallCaptureHook(cb capture.Callback) {
 	e.tundev.InstallCaptureHook(cb)
 	e.magicConn.InstallCaptureHook(cb)
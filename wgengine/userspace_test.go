@@ -4,12 +4,18 @@
 package wgengine
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"go4.org/mem"
 	"tailscale.com/cmd/testwrapper/flakytest"
@@ -380,3 +386,302 @@ func BenchmarkGenLocalAddrFunc(b *testing.B) {
 	})
 	b.Logf("x = %v", x)
 }
+
+// hangingRouter is a router.Router whose Close blocks until unblock is closed.
+type hangingRouter struct {
+	router.Router
+	unblock chan struct{}
+}
+
+func (r hangingRouter) Close() error {
+	<-r.unblock
+	return nil
+}
+
+func TestUserspaceEngineCloseStuckRouter(t *testing.T) {
+	tstest.Replace(t, &closeStepTimeout, 100*time.Millisecond)
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	var logBuf tstest.MemLogger
+	ht := new(health.Tracker)
+	sentinel := filepath.Join(t.TempDir(), "sentinel")
+	e, err := NewUserspaceEngine(logBuf.Logf, Config{
+		Router:              hangingRouter{router.NewFake(t.Logf), unblock},
+		HealthTracker:       ht,
+		CleanupSentinelPath: sentinel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		e.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close blocked on a stuck router")
+	}
+	select {
+	case <-e.Done():
+	default:
+		t.Error("Done not closed after Close returned")
+	}
+	if !strings.Contains(logBuf.String(), "router did not finish") {
+		t.Errorf("stuck router not logged; logs:\n%s", logBuf.String())
+	}
+	st, err := ReadCleanupState(sentinel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Routes {
+		t.Errorf("cleanup state = %+v after router failed to close; want Routes set", st)
+	}
+}
+
+func TestUserspaceEngineCleanupSentinel(t *testing.T) {
+	sentinel := filepath.Join(t.TempDir(), "sentinel")
+	ht := new(health.Tracker)
+	e, err := NewUserspaceEngine(t.Logf, Config{
+		HealthTracker:       ht,
+		CleanupSentinelPath: sentinel,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+
+	st, err := ReadCleanupState(sentinel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Routes || st.PID != os.Getpid() {
+		t.Errorf("cleanup state after start = %+v; want Routes set by this process", st)
+	}
+
+	e.Close()
+	st, err = ReadCleanupState(sentinel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Dirty() {
+		t.Errorf("cleanup state after clean Close = %+v; want clean", st)
+	}
+}
+
+func TestCleanupSentinelKeepsUncleanedState(t *testing.T) {
+	sentinel := filepath.Join(t.TempDir(), "sentinel")
+	write := func(st CleanupState) {
+		t.Helper()
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(sentinel, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() CleanupState {
+		t.Helper()
+		st, err := ReadCleanupState(sentinel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// Left behind on the same interface: the new engine takes over the
+	// flags, which its own clean shutdown then clears.
+	write(CleanupState{PID: 1, Interface: "tailscale0", DNS: true})
+	newCleanupSentinel(t.Logf, sentinel, "tailscale0")
+	if st := read(); !st.DNS || st.Routes || st.StaleInterface != "" {
+		t.Errorf("same interface: cleanup state = %+v; want DNS set", st)
+	}
+
+	// Left behind on another interface: it's kept as stale.
+	write(CleanupState{PID: 1, Interface: "ts-old", Routes: true})
+	newCleanupSentinel(t.Logf, sentinel, "tailscale0")
+	if st := read(); st.StaleInterface != "ts-old" || st.Routes || st.DNS {
+		t.Errorf("other interface: cleanup state = %+v; want StaleInterface ts-old", st)
+	}
+
+	// Cleaned up at startup: nothing is carried over.
+	if err := os.Remove(sentinel); err != nil {
+		t.Fatal(err)
+	}
+	newCleanupSentinel(t.Logf, sentinel, "tailscale0")
+	if st := read(); st.Dirty() {
+		t.Errorf("after clean startup: cleanup state = %+v; want clean", st)
+	}
+}
+
+// failingRouter is a router.Router whose Set always fails.
+type failingRouter struct {
+	router.Router
+}
+
+func (failingRouter) Set(*router.Config) error {
+	return errors.New("failingRouter: Set failed")
+}
+
+func TestUserspaceEngineSubscribe(t *testing.T) {
+	ht := new(health.Tracker)
+	e, err := NewFakeUserspaceEngine(t.Logf, 0, ht)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	ue := e.(*userspaceEngine)
+
+	// flush waits for the events published so far to be delivered.
+	flush := func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := ue.eventQueue.Wait(ctx); err != nil {
+			t.Fatalf("waiting for events: %v", err)
+		}
+	}
+
+	// Ignore events published by magicsock in the background (endpoints,
+	// DERP home), other than the DERP home region 999 published below.
+	var got1, got2 []Event
+	record := func(dst *[]Event) EventCallback {
+		return func(ev Event) {
+			switch ev := ev.(type) {
+			case PeerUpEvent, PeerDownEvent, RoutesEvent:
+				*dst = append(*dst, ev)
+			case DERPHomeEvent:
+				if ev.RegionID == 999 {
+					*dst = append(*dst, ev)
+				}
+			}
+		}
+	}
+	unsub1 := e.Subscribe(record(&got1))
+	e.Subscribe(record(&got2))
+
+	nkA := nkFromHex("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	nkB := nkFromHex("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	peer := func(nk key.NodePublic, ip netip.Addr) wgcfg.Peer {
+		return wgcfg.Peer{PublicKey: nk, AllowedIPs: []netip.Prefix{netip.PrefixFrom(ip, 32)}}
+	}
+	ipA := netaddr.IPv4(100, 100, 99, 1)
+	ipB := netaddr.IPv4(100, 100, 99, 2)
+	routerCfg := &router.Config{
+		LocalAddrs: []netip.Prefix{netip.MustParsePrefix("100.100.99.3/32")},
+		Routes:     []netip.Prefix{netip.MustParsePrefix("100.100.99.0/24")},
+	}
+
+	// Events of all types are delivered in the order they were published.
+	ue.publish(DERPHomeEvent{RegionID: 999})
+	cfg := &wgcfg.Config{Peers: []wgcfg.Peer{peer(nkA, ipA)}}
+	if err := e.Reconfig(cfg, routerCfg, &dns.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	want := []Event{
+		DERPHomeEvent{RegionID: 999},
+		PeerUpEvent{NodeKey: nkA},
+		RoutesEvent{LocalAddrs: routerCfg.LocalAddrs, Routes: routerCfg.Routes},
+	}
+	if !reflect.DeepEqual(got1, want) {
+		t.Errorf("subscriber 1 events:\n got: %v\nwant: %v", got1, want)
+	}
+	if !reflect.DeepEqual(got2, want) {
+		t.Errorf("subscriber 2 events:\n got: %v\nwant: %v", got2, want)
+	}
+
+	// Swap peer A for B, leaving routes alone; only subscriber 2 is
+	// still subscribed.
+	unsub1()
+	got1, got2 = nil, nil
+	cfg = &wgcfg.Config{Peers: []wgcfg.Peer{peer(nkB, ipB)}}
+	if err := e.Reconfig(cfg, routerCfg, &dns.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	want = []Event{
+		PeerUpEvent{NodeKey: nkB},
+		PeerDownEvent{NodeKey: nkA},
+	}
+	if len(got1) > 0 {
+		t.Errorf("unsubscribed subscriber got events: %v", got1)
+	}
+	if !reflect.DeepEqual(got2, want) {
+		t.Errorf("subscriber 2 events:\n got: %v\nwant: %v", got2, want)
+	}
+
+	// Swapping back to A in a Reconfig that fails reports nothing until a
+	// later Reconfig succeeds.
+	realRouter := ue.router
+	ue.router = failingRouter{realRouter}
+	got2 = nil
+	cfg = &wgcfg.Config{Peers: []wgcfg.Peer{peer(nkA, ipA)}}
+	routerCfg2 := &router.Config{
+		LocalAddrs: routerCfg.LocalAddrs,
+		Routes:     []netip.Prefix{netip.MustParsePrefix("100.100.98.0/23")},
+	}
+	if err := e.Reconfig(cfg, routerCfg2, &dns.Config{}); err == nil {
+		t.Fatal("Reconfig with a failing router succeeded")
+	}
+	flush()
+	if len(got2) > 0 {
+		t.Errorf("failed Reconfig published events: %v", got2)
+	}
+	ue.router = realRouter
+	if err := e.Reconfig(cfg, routerCfg2, &dns.Config{}); err != nil && err != ErrNoChanges {
+		t.Fatal(err)
+	}
+	flush()
+	want = []Event{
+		PeerUpEvent{NodeKey: nkA},
+		PeerDownEvent{NodeKey: nkB},
+	}
+	if !reflect.DeepEqual(got2, want) {
+		t.Errorf("subscriber 2 events after a failed Reconfig:\n got: %v\nwant: %v", got2, want)
+	}
+
+	statusc := make(chan StatusEvent, 1)
+	unsub := e.Subscribe(func(ev Event) {
+		if se, ok := ev.(StatusEvent); ok {
+			select {
+			case statusc <- se:
+			default:
+			}
+		}
+	})
+	defer unsub()
+	e.RequestStatus()
+	select {
+	case se := <-statusc:
+		if se.Err != nil || se.Status == nil {
+			t.Errorf("StatusEvent = %+v; want status", se)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no StatusEvent after RequestStatus")
+	}
+
+	// The deprecated SetStatusCallback still gets the status.
+	statusCB := make(chan *Status, 1)
+	e.SetStatusCallback(func(s *Status, err error) {
+		select {
+		case statusCB <- s:
+		default:
+		}
+	})
+	defer e.SetStatusCallback(nil)
+	e.RequestStatus()
+	select {
+	case s := <-statusCB:
+		if s == nil {
+			t.Error("SetStatusCallback callback got a nil status")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status callback after RequestStatus")
+	}
+}
@@ -135,6 +135,10 @@ func (e *watchdogEngine) GetJailedFilter() *filter.Filter {
 func (e *watchdogEngine) SetJailedFilter(filt *filter.Filter) {
 	e.watchdog("SetJailedFilter", func() { e.wrap.SetJailedFilter(filt) })
 }
+func (e *watchdogEngine) Subscribe(cb EventCallback) (unsubscribe func()) {
+	e.watchdog("Subscribe", func() { unsubscribe = e.wrap.Subscribe(cb) })
+	return unsubscribe
+}
 func (e *watchdogEngine) SetStatusCallback(cb StatusCallback) {
 	e.watchdog("SetStatusCallback", func() { e.wrap.SetStatusCallback(cb) })
 }
@@ -166,6 +170,10 @@ func (e *watchdogEngine) InstallCaptureHook(cb capture.Callback) {
 	e.wrap.InstallCaptureHook(cb)
 }
 
+func (e *watchdogEngine) SetDebugLoggingEnabled(v bool) {
+	e.wrap.SetDebugLoggingEnabled(v)
+}
+
 func (e *watchdogEngine) PeerByKey(pubKey key.NodePublic) (_ wgint.Peer, ok bool) {
 	return e.wrap.PeerByKey(pubKey)
 }
@@ -25,6 +25,10 @@ type Config struct {
 	DNS        []netip.Addr
 	Peers      []Peer
 
+	// ListenPort, if non-zero, is the UDP port to listen on, overriding
+	// the engine's configured one.
+	ListenPort uint16
+
 	// NetworkLogging enables network logging.
 	// It is disabled if either ID is the zero value.
 	// LogExitFlowEnabled indicates whether or not exit flows should be logged.
@@ -50,6 +54,18 @@ type Peer struct {
 	WGEndpoint key.NodePublic
 }
 
+// Applied returns a copy of cfg as DeviceConfig would read it back from a
+// device that cfg was written to, for use as the prev argument of a later
+// ReconfigDeviceFrom.
+func (cfg *Config) Applied() *Config {
+	ret := cfg.Clone()
+	for i := range ret.Peers {
+		p := &ret.Peers[i]
+		p.WGEndpoint = p.PublicKey
+	}
+	return ret
+}
+
 // PeerWithKey returns the Peer with key k and reports whether it was found.
 func (config Config) PeerWithKey(k key.NodePublic) (Peer, bool) {
 	for _, p := range config.Peers {
@@ -53,7 +53,26 @@ func ReconfigDevice(d *device.Device, cfg *Config, logf logger.Logf) (err error)
 	if err != nil {
 		return err
 	}
+	return reconfigDeviceFrom(d, prev, cfg, logf)
+}
+
+// ReconfigDeviceFrom is like ReconfigDevice, but takes the device's current
+// configuration as prev rather than reading it from d, which takes time
+// proportional to the number of peers. Only the peers that differ between
+// prev and cfg are written, so the others keep their sessions.
+//
+// prev is typically the Applied config of the previous reconfiguration of
+// d. If it doesn't match d's configuration, the result is undefined.
+func ReconfigDeviceFrom(d *device.Device, prev, cfg *Config, logf logger.Logf) (err error) {
+	defer func() {
+		if err != nil {
+			logf("wgcfg.Reconfig failed: %v", err)
+		}
+	}()
+	return reconfigDeviceFrom(d, prev, cfg, logf)
+}
 
+func reconfigDeviceFrom(d *device.Device, prev, cfg *Config, logf logger.Logf) error {
 	r, w := io.Pipe()
 	errc := make(chan error, 1)
 	go func() {
@@ -197,6 +197,31 @@ func TestDeviceConfig(t *testing.T) {
 			t.Error("reconfig failed to remove peer")
 		}
 	})
+
+	t.Run("device1 reconfig from applied", func(t *testing.T) {
+		k4, _ := newK()
+		prev := cfg1.Applied()
+		cfg1.Peers = append(cfg1.Peers, Peer{
+			PublicKey:  k4,
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.4/32")},
+		})
+		sort.Slice(cfg1.Peers, func(i, j int) bool {
+			return cfg1.Peers[i].PublicKey.Less(cfg1.Peers[j].PublicKey)
+		})
+
+		uapi := new(strings.Builder)
+		if err := cfg1.ToUAPI(t.Logf, uapi, prev); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(uapi.String(), "public_key=") != 1 {
+			t.Errorf("delta from applied config writes more than the new peer:\n%s", uapi)
+		}
+
+		if err := ReconfigDeviceFrom(device1, prev, cfg1, t.Logf); err != nil {
+			t.Fatal(err)
+		}
+		cmp(t, device1, cfg1)
+	})
 }
 
 // TODO: replace with a loopback tunnel
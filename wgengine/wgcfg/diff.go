@@ -0,0 +1,176 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgcfg
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// Diff is what changed between two Configs. It's meant for logging: it
+// names peers by the short form of their public key and holds no private
+// keys or log IDs, only whether they changed.
+type Diff struct {
+	PrivateKeyChanged     bool
+	AddressesChanged      bool
+	NetworkLoggingChanged bool
+
+	Added   []key.NodePublic
+	Removed []key.NodePublic
+	Changed []PeerDiff
+}
+
+// PeerDiff is what changed about a peer that's in both Configs of a Diff.
+type PeerDiff struct {
+	PublicKey key.NodePublic
+
+	// DiscoKeyChanged is whether the peer's disco key changed, which
+	// means that it restarted and its endpoints were rediscovered.
+	DiscoKeyChanged bool
+
+	AddedIPs   []netip.Prefix // allowed IPs the peer gained
+	RemovedIPs []netip.Prefix // allowed IPs the peer lost
+
+	MasqChanged      bool // V4MasqAddr or V6MasqAddr changed
+	JailedChanged    bool
+	KeepaliveChanged bool
+}
+
+// DiffConfigs returns what changed from prev to cfg. A nil prev is
+// treated as the zero Config.
+func DiffConfigs(prev, cfg *Config) Diff {
+	if prev == nil {
+		prev = new(Config)
+	}
+	d := Diff{
+		PrivateKeyChanged:     !prev.PrivateKey.Equal(cfg.PrivateKey),
+		AddressesChanged:      !slices.Equal(prev.Addresses, cfg.Addresses),
+		NetworkLoggingChanged: prev.NetworkLogging != cfg.NetworkLogging,
+	}
+	old := make(map[key.NodePublic]*Peer, len(prev.Peers))
+	for i := range prev.Peers {
+		old[prev.Peers[i].PublicKey] = &prev.Peers[i]
+	}
+	for i := range cfg.Peers {
+		p := &cfg.Peers[i]
+		op, ok := old[p.PublicKey]
+		if !ok {
+			d.Added = append(d.Added, p.PublicKey)
+			continue
+		}
+		delete(old, p.PublicKey)
+		pd := PeerDiff{
+			PublicKey:        p.PublicKey,
+			DiscoKeyChanged:  op.DiscoKey != p.DiscoKey,
+			AddedIPs:         prefixesNotIn(p.AllowedIPs, op.AllowedIPs),
+			RemovedIPs:       prefixesNotIn(op.AllowedIPs, p.AllowedIPs),
+			MasqChanged:      !addrPtrEqual(op.V4MasqAddr, p.V4MasqAddr) || !addrPtrEqual(op.V6MasqAddr, p.V6MasqAddr),
+			JailedChanged:    op.IsJailed != p.IsJailed,
+			KeepaliveChanged: op.PersistentKeepalive != p.PersistentKeepalive,
+		}
+		if !pd.isZero() {
+			d.Changed = append(d.Changed, pd)
+		}
+	}
+	for k := range old {
+		d.Removed = append(d.Removed, k)
+	}
+	slices.SortFunc(d.Removed, func(a, b key.NodePublic) int {
+		if a.Less(b) {
+			return -1
+		}
+		if b.Less(a) {
+			return 1
+		}
+		return 0
+	})
+	return d
+}
+
+// IsZero reports whether d has no changes.
+func (d Diff) IsZero() bool {
+	return !d.PrivateKeyChanged && !d.AddressesChanged && !d.NetworkLoggingChanged &&
+		len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a one-line summary of d.
+func (d Diff) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "peers +%d -%d ~%d", len(d.Added), len(d.Removed), len(d.Changed))
+	if d.PrivateKeyChanged {
+		sb.WriteString(", private key changed")
+	}
+	if d.AddressesChanged {
+		sb.WriteString(", addresses changed")
+	}
+	if d.NetworkLoggingChanged {
+		sb.WriteString(", network logging changed")
+	}
+	return sb.String()
+}
+
+// Log logs each change in d on its own line, with the given prefix.
+func (d Diff) Log(logf logger.Logf, prefix string) {
+	for _, k := range d.Added {
+		logf("%speer %v added", prefix, k.ShortString())
+	}
+	for _, k := range d.Removed {
+		logf("%speer %v removed", prefix, k.ShortString())
+	}
+	for _, pd := range d.Changed {
+		logf("%speer %v changed: %v", prefix, pd.PublicKey.ShortString(), pd)
+	}
+}
+
+func (pd PeerDiff) isZero() bool {
+	return !pd.DiscoKeyChanged && len(pd.AddedIPs) == 0 && len(pd.RemovedIPs) == 0 &&
+		!pd.MasqChanged && !pd.JailedChanged && !pd.KeepaliveChanged
+}
+
+// String returns what changed about the peer, without its key.
+func (pd PeerDiff) String() string {
+	var parts []string
+	if pd.DiscoKeyChanged {
+		parts = append(parts, "disco key")
+	}
+	if len(pd.AddedIPs) > 0 {
+		parts = append(parts, fmt.Sprintf("+allowed IPs %v", pd.AddedIPs))
+	}
+	if len(pd.RemovedIPs) > 0 {
+		parts = append(parts, fmt.Sprintf("-allowed IPs %v", pd.RemovedIPs))
+	}
+	if pd.MasqChanged {
+		parts = append(parts, "masquerade addresses")
+	}
+	if pd.JailedChanged {
+		parts = append(parts, "jailed")
+	}
+	if pd.KeepaliveChanged {
+		parts = append(parts, "keepalive")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// prefixesNotIn returns the prefixes in a that aren't in b.
+func prefixesNotIn(a, b []netip.Prefix) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, p := range a {
+		if !slices.Contains(b, p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func addrPtrEqual(a, b *netip.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
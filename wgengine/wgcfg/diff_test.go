@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgcfg
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	priv := key.NewNode()
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	k3 := key.NewNode().Public()
+	ip1 := netip.MustParsePrefix("100.64.0.1/32")
+	ip2 := netip.MustParsePrefix("100.64.0.2/32")
+	subnet := netip.MustParsePrefix("10.0.0.0/24")
+
+	prev := &Config{
+		PrivateKey: priv,
+		Peers: []Peer{
+			{PublicKey: k1, AllowedIPs: []netip.Prefix{ip1}},
+			{PublicKey: k2, AllowedIPs: []netip.Prefix{ip2}},
+		},
+	}
+	cfg := &Config{
+		PrivateKey: priv,
+		Peers: []Peer{
+			{PublicKey: k1, AllowedIPs: []netip.Prefix{ip1, subnet}, PersistentKeepalive: 25},
+			{PublicKey: k3},
+		},
+	}
+
+	if d := DiffConfigs(prev, prev); !d.IsZero() {
+		t.Errorf("diff of a config with itself = %v; want none", d)
+	}
+
+	d := DiffConfigs(prev, cfg)
+	want := Diff{
+		Added:   []key.NodePublic{k3},
+		Removed: []key.NodePublic{k2},
+		Changed: []PeerDiff{{
+			PublicKey:        k1,
+			AddedIPs:         []netip.Prefix{subnet},
+			KeepaliveChanged: true,
+		}},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DiffConfigs = %+v; want %+v", d, want)
+	}
+	if got, want := d.String(), "peers +1 -1 ~1"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+
+	var lines []string
+	d.Log(func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, "")
+	if len(lines) != 3 {
+		t.Fatalf("logged %d lines; want 3: %q", len(lines), lines)
+	}
+	for _, line := range lines {
+		if strings.Contains(line, priv.Public().String()) || strings.Contains(line, k1.String()) {
+			t.Errorf("logged full key: %q", line)
+		}
+	}
+
+	if d := DiffConfigs(nil, &Config{PrivateKey: priv}); !d.PrivateKeyChanged {
+		t.Errorf("diff from nil = %v; want private key changed", d)
+	}
+}
@@ -42,6 +42,7 @@ func (src *Config) Clone() *Config {
 	MTU            uint16
 	DNS            []netip.Addr
 	Peers          []Peer
+	ListenPort     uint16
 	NetworkLogging struct {
 		NodeID             logid.PrivateID
 		DomainID           logid.PrivateID
@@ -35,6 +35,8 @@ type Status struct {
 // Engine.SetStatusCallback.
 //
 // Exactly one of Status or error is non-nil.
+//
+// Deprecated: use EventCallback and StatusEvent.
 type StatusCallback func(*Status, error)
 
 // NetworkMapCallback is the type used by callbacks that hook
@@ -86,12 +88,19 @@ type Engine interface {
 	// SetJailedFilter updates the packet filter for jailed nodes.
 	SetJailedFilter(*filter.Filter)
 
+	// Subscribe registers cb to be called with each Event the
+	// engine publishes, until unsubscribe is called.
+	Subscribe(cb EventCallback) (unsubscribe func())
+
 	// SetStatusCallback sets the function to call when the
-	// WireGuard status changes.
+	// WireGuard status changes, replacing any previous one.
+	//
+	// Deprecated: use Subscribe and handle StatusEvents, which this
+	// is a shim over.
 	SetStatusCallback(StatusCallback)
 
 	// RequestStatus requests a WireGuard status update right
-	// away, sent to the callback registered via SetStatusCallback.
+	// away, published to subscribers as a StatusEvent.
 	RequestStatus()
 
 	// PeerByKey returns the WireGuard status of the provided peer.
@@ -130,4 +139,8 @@ type Engine interface {
 	// packets traversing the data path. The hook can be uninstalled by
 	// calling this function with a nil value.
 	InstallCaptureHook(capture.Callback)
+
+	// SetDebugLoggingEnabled sets whether the engine logs the details of
+	// each reconfiguration, such as which peers were added or removed.
+	SetDebugLoggingEnabled(bool)
 }